@@ -0,0 +1,525 @@
+// Command cbictl operates the Chicago BI pipelines: it triggers collectors and reports through the services'
+// HTTP APIs and reads job history and freshness straight from the database.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	collectorsURLEnvKey  = "COLLECTORS_URL"
+	reportsURLEnvKey     = "REPORTS_URL"
+	defaultCollectorsURL = "http://localhost:8080"
+	defaultReportsURL    = "http://localhost:8082"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"run-collector":      {usage: "run-collector [-url URL] <name>  run one collector through the collectors service", run: runCollector},
+	"run-report":         {usage: "run-report [-url URL] <name>     run one report through the reports service", run: runReport},
+	"history":            {usage: "history [-limit N] [-diffs|-statements]  list recent report builds, pull diffs, or statement latencies", run: showHistory},
+	"freshness":          {usage: "freshness                       show when each source table was last refreshed", run: showFreshness},
+	"validate-config":    {usage: "validate-config                 check the environment configuration", run: validateConfig},
+	"config":             {usage: "config validate [-file F]       print the effective configuration, secrets redacted", run: configCommand},
+	"rebuild-crosswalks": {usage: "rebuild-crosswalks [-python P]  regenerate the geography crosswalk CSVs", run: rebuildCrosswalks},
+	"smoke":              {usage: "smoke [-limit N] [-keep]        run every collector and report against a throwaway schema", run: runSmoke},
+	"scaffold":           {usage: "scaffold collector -resource ID -name N [-key F] [-type T]  generate a collector for a Socrata dataset", run: scaffold},
+}
+
+var commandOrder = []string{"run-collector", "run-report", "history", "freshness", "validate-config", "config", "rebuild-crosswalks", "smoke", "scaffold"}
+
+func main() {
+	log.SetFlags(0)
+
+	// cbictl is often run from an operator's shell, so a missing .env is not an error.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("error loading .env file: %v", err)
+	}
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		log.Fatalf("%s: %v", os.Args[1], err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: cbictl <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, name := range commandOrder {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+}
+
+func runCollector(args []string) error {
+	flags := flag.NewFlagSet("run-collector", flag.ExitOnError)
+	baseURL := flags.String("url", envOrDefault(collectorsURLEnvKey, defaultCollectorsURL), "collectors service URL")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("expected exactly one collector name")
+	}
+
+	return postRun(*baseURL, "collector", flags.Arg(0))
+}
+
+func runReport(args []string) error {
+	flags := flag.NewFlagSet("run-report", flag.ExitOnError)
+	baseURL := flags.String("url", envOrDefault(reportsURLEnvKey, defaultReportsURL), "reports service URL")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("expected exactly one report name")
+	}
+
+	return postRun(*baseURL, "report", flags.Arg(0))
+}
+
+// postRun asks a service to run a job via its /run endpoint and prints the service's reply.
+func postRun(baseURL, param, name string) error {
+	runURL := strings.TrimSuffix(baseURL, "/") + "/run?" + url.Values{param: {name}}.Encode()
+
+	// Jobs run synchronously on the service side and can take a long time, so no client timeout is set.
+	resp, err := http.Post(runURL, "text/plain", nil)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", runURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s failed (%s): %s", param, name, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	fmt.Print(string(body))
+	return nil
+}
+
+func showHistory(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	limit := flags.Int("limit", 20, "number of report builds to show")
+	diffs := flags.Bool("diffs", false, "show row diffs between consecutive pulls of ccvi and public_health instead")
+	statements := flags.Bool("statements", false, "show the latency of each report SQL statement over the last 30 days instead, slowest first")
+	flags.Parse(args)
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *diffs {
+		return showTableDiffs(db, *limit)
+	}
+	if *statements {
+		return showStatementTimings(db, *limit)
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT "report_table", "built_at", "duration_ms", "build_version", string_agg("source_table", ', ' ORDER BY "source_table")
+		FROM %q
+		GROUP BY "report_table", "built_at", "duration_ms", "build_version"
+		ORDER BY "built_at" DESC, "report_table"
+		LIMIT $1`, shared.LineageTable), *limit)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", shared.LineageTable, err)
+	}
+	defer rows.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REPORT\tBUILT AT\tDURATION\tVERSION\tSOURCES")
+	for rows.Next() {
+		var (
+			report, version, sources string
+			builtAt                  time.Time
+			durationMs               int64
+		)
+		if err := rows.Scan(&report, &builtAt, &durationMs, &version, &sources); err != nil {
+			return fmt.Errorf("failed to scan %s row: %w", shared.LineageTable, err)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", report, builtAt.Local().Format(time.RFC3339), time.Duration(durationMs)*time.Millisecond, version, sources)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", shared.LineageTable, err)
+	}
+
+	return w.Flush()
+}
+
+func showTableDiffs(db *sql.DB, limit int) error {
+	rows, err := db.Query(fmt.Sprintf(`SELECT "table_name", "compared_at", "previous_rows", "current_rows", "rows_added", "rows_removed", "rows_changed", "build_version"
+		FROM %q
+		ORDER BY "compared_at" DESC, "table_name"
+		LIMIT $1`, shared.TableDiffsTable), limit)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", shared.TableDiffsTable, err)
+	}
+	defer rows.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tCOMPARED AT\tPREVIOUS\tCURRENT\tADDED\tREMOVED\tCHANGED\tVERSION")
+	for rows.Next() {
+		var (
+			table, version                           string
+			comparedAt                               time.Time
+			previous, current, added, removed, diffs int64
+		)
+		if err := rows.Scan(&table, &comparedAt, &previous, &current, &added, &removed, &diffs, &version); err != nil {
+			return fmt.Errorf("failed to scan %s row: %w", shared.TableDiffsTable, err)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\n", table, comparedAt.Local().Format(time.RFC3339), previous, current, added, removed, diffs, version)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", shared.TableDiffsTable, err)
+	}
+
+	return w.Flush()
+}
+
+// statementTimingsTable is written by the reports service after every report build.
+const statementTimingsTable = "report_statement_timings"
+
+// showStatementTimings lists the report SQL statements with the highest 95th percentile latency over the last
+// 30 days, with a histogram of their durations.
+func showStatementTimings(db *sql.DB, limit int) error {
+	rows, err := db.Query(fmt.Sprintf(`SELECT "report", "script", "statement_index", MAX("statement"), COUNT(*),
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY "duration_ms"),
+			percentile_cont(0.95) WITHIN GROUP (ORDER BY "duration_ms"),
+			MAX("duration_ms"),
+			COUNT(*) FILTER (WHERE "duration_ms" < 100),
+			COUNT(*) FILTER (WHERE "duration_ms" >= 100 AND "duration_ms" < 1000),
+			COUNT(*) FILTER (WHERE "duration_ms" >= 1000 AND "duration_ms" < 10000),
+			COUNT(*) FILTER (WHERE "duration_ms" >= 10000 AND "duration_ms" < 60000),
+			COUNT(*) FILTER (WHERE "duration_ms" >= 60000)
+		FROM %q
+		WHERE "built_at" > NOW() - INTERVAL '30 days'
+		GROUP BY "report", "script", "statement_index"
+		ORDER BY 7 DESC
+		LIMIT $1`, statementTimingsTable), limit)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", statementTimingsTable, err)
+	}
+	defer rows.Close()
+
+	ms := func(v float64) time.Duration {
+		return time.Duration(v * float64(time.Millisecond)).Round(time.Millisecond)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REPORT\tSTATEMENT\tRUNS\tP50\tP95\tMAX\t<0.1s/<1s/<10s/<1m/>=1m\tSQL")
+	for rows.Next() {
+		var (
+			report, script, statement string
+			index                     int
+			runs                      int64
+			p50, p95, longest         float64
+			buckets                   [5]int64
+		)
+		if err := rows.Scan(&report, &script, &index, &statement, &runs, &p50, &p95, &longest,
+			&buckets[0], &buckets[1], &buckets[2], &buckets[3], &buckets[4]); err != nil {
+			return fmt.Errorf("failed to scan %s row: %w", statementTimingsTable, err)
+		}
+		fmt.Fprintf(w, "%s\t%s #%d\t%d\t%s\t%s\t%s\t%d/%d/%d/%d/%d\t%s\n", report, script, index, runs, ms(p50), ms(p95), ms(longest),
+			buckets[0], buckets[1], buckets[2], buckets[3], buckets[4], statement)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", statementTimingsTable, err)
+	}
+
+	return w.Flush()
+}
+
+func showFreshness(args []string) error {
+	flags := flag.NewFlagSet("freshness", flag.ExitOnError)
+	flags.Parse(args)
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	refreshes, err := shared.TableRefreshes(context.Background(), db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tREFRESHED AT\tAGE\tROWS\tVERSION")
+	for _, refresh := range refreshes {
+		age := time.Since(refresh.RefreshedAt).Round(time.Minute)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", refresh.Table, refresh.RefreshedAt.Local().Format(time.RFC3339), age, refresh.RowCount, refresh.BuildVersion)
+	}
+
+	return w.Flush()
+}
+
+// validateConfig checks the settings the services read at startup and reports every problem it finds.
+func validateConfig(args []string) error {
+	flags := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	flags.Parse(args)
+
+	failures := 0
+	check := func(name string, err error) {
+		if err != nil {
+			failures++
+			fmt.Printf("FAIL  %-28s %v\n", name, err)
+			return
+		}
+		fmt.Printf("ok    %s\n", name)
+	}
+
+	check("DATABASE_URL", func() error {
+		connStr := shared.ApplyDBSchema(envOrDefault("DATABASE_URL", shared.DefaultConnectionString))
+		db, err := sql.Open("postgres", connStr)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return db.PingContext(ctx)
+	}())
+
+	for _, key := range []string{
+		"STARTUP_DELAY_MINUTES", "SOURCE_WAIT_TIMEOUT_MINUTES", "COLLECTOR_CONCURRENCY", "COLLECTOR_TIMEOUT_MINUTES",
+		"COLLECTOR_STAGGER_MINUTES", shared.AnalyzeMinRowsEnvKey, shared.VacuumMinRowsEnvKey, shared.HTTPCacheMaxAgeEnvKey, "REPORT_SNAPSHOT_RETENTION_DAYS", shared.AuditRetentionEnvKey,
+	} {
+		check(key, nonNegativeInt(key))
+	}
+
+	for _, ds := range datasets.ManagedDatasets {
+		table := ds.Table
+		check("storage backend for "+table, func() error {
+			switch backend := shared.StorageBackendFor(table); backend {
+			case shared.BackendPostgres:
+				return nil
+			case shared.BackendBigQuery:
+				_, err := shared.NewBigQueryStoreFromEnv()
+				return err
+			default:
+				return fmt.Errorf("unknown storage backend %q", backend)
+			}
+		}())
+	}
+
+	check("table schemas", func() error {
+		db, err := sql.Open("postgres", shared.ApplyDBSchema(envOrDefault("DATABASE_URL", shared.DefaultConnectionString)))
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		breaking, err := shared.CheckTableSchemas(ctx, db, datasets.ManagedDatasets)
+		if err != nil {
+			return err
+		}
+		if len(breaking) > 0 {
+			tables := make([]string, 0, len(breaking))
+			for _, diff := range breaking {
+				tables = append(tables, diff.Table)
+			}
+			return fmt.Errorf("drift in %s; see the log lines above", strings.Join(tables, ", "))
+		}
+		return nil
+	}())
+
+	check("configuration", func() error {
+		_, err := shared.LoadConfig("")
+		return err
+	}())
+
+	check("REPORT_SNAPSHOT_MODE", func() error {
+		switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("REPORT_SNAPSHOT_MODE"))); mode {
+		case "", "table", "append":
+			return nil
+		default:
+			return fmt.Errorf("expected table or append, got %q", mode)
+		}
+	}())
+
+	check("USE_GEOCODING", func() error {
+		if os.Getenv("USE_GEOCODING") != "true" {
+			return nil
+		}
+		_, err := shared.NewGeocoder(os.Getenv(shared.GeocoderProviderEnvKey))
+		return err
+	}())
+
+	check(datasets.PermitCategoriesFileEnvKey, func() error {
+		_, err := datasets.LoadPermitTaxonomy()
+		return err
+	}())
+
+	check("geography crosswalks", func() error {
+		root, err := findProjectRoot()
+		if err != nil {
+			return err
+		}
+		for _, name := range shared.GeographyCrosswalkFiles {
+			info, err := os.Stat(shared.CrosswalkPath(root, name))
+			if err != nil || info.Size() == 0 {
+				return fmt.Errorf("%s is missing or empty; run cbictl rebuild-crosswalks", name)
+			}
+		}
+		return nil
+	}())
+
+	if failures > 0 {
+		return fmt.Errorf("%d check(s) failed", failures)
+	}
+	return nil
+}
+
+// configCommand runs the config subcommands. "config validate" prints every setting of shared.Config with
+// its value and source, then the problems found, and fails when there are any.
+func configCommand(args []string) error {
+	if len(args) < 1 || args[0] != "validate" {
+		return errors.New("usage: config validate [-file F]")
+	}
+
+	flags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	file := flags.String("file", "", "dotenv-format file read after the environment")
+	flags.Parse(args[1:])
+
+	cfg, err := shared.LoadConfig(*file)
+	var problems shared.ConfigErrors
+	if err != nil && !errors.As(err, &problems) {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, entry := range cfg.Entries() {
+		value, source := entry.Value, entry.Source
+		if source == "" {
+			value, source = "-", "unset"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Key, value, source)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		fmt.Println()
+		for _, problem := range problems {
+			fmt.Printf("FAIL  %-28s %s\n", problem.Key, problem.Problem)
+		}
+		return fmt.Errorf("%d configuration problem(s)", len(problems))
+	}
+	return nil
+}
+
+func rebuildCrosswalks(args []string) error {
+	flags := flag.NewFlagSet("rebuild-crosswalks", flag.ExitOnError)
+	python := flags.String("python", "python3", "Python interpreter used to run build_geo_maps.py")
+	flags.Parse(args)
+
+	if profile, err := shared.CurrentCityProfile(); err != nil {
+		return err
+	} else if profile.Name != shared.ChicagoProfile.Name {
+		return fmt.Errorf("build_geo_maps.py only builds Chicago's crosswalks; provide those of the %s profile in %s",
+			profile.Name, filepath.Join("src", "data", profile.CrosswalkDir))
+	}
+
+	root, err := findProjectRoot()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(*python, filepath.Join("src", "shared", "build_geo_maps.py"))
+	cmd.Dir = root
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("build_geo_maps.py failed: %w", err)
+	}
+
+	for _, name := range shared.GeographyCrosswalkFiles {
+		fmt.Printf("wrote %s\n", filepath.Join(root, "src", "data", name))
+	}
+	return nil
+}
+
+func openDatabase() (*sql.DB, error) {
+	db, err := shared.OpenDatabase(envOrDefault("DATABASE_URL", shared.DefaultConnectionString))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return db, nil
+}
+
+// findProjectRoot walks up from the working directory to the directory containing src/data/spatial.
+func findProjectRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	for {
+		if info, err := os.Stat(filepath.Join(dir, "src", "data", "spatial")); err == nil && info.IsDir() {
+			return dir, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("could not locate the project root containing 'src/data/spatial'")
+		}
+		dir = parent
+	}
+}
+
+func nonNegativeInt(key string) error {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(raw); err != nil || n < 0 {
+		return fmt.Errorf("expected a non-negative integer, got %q", raw)
+	}
+	return nil
+}
+
+func envOrDefault(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
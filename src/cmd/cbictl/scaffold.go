@@ -0,0 +1,662 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+var (
+	scaffoldNamePattern     = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	scaffoldResourcePattern = regexp.MustCompile(`^[a-z0-9]{4}-[a-z0-9]{4}$`)
+	scaffoldTypePattern     = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+)
+
+// scaffoldKinds maps Socrata column types to how the generated code decodes them. Types missing here,
+// such as point, location, and url, are JSON objects in SODA rows and are left out of the record struct.
+var scaffoldKinds = map[string]string{
+	"text":               "text",
+	"number":             "float",
+	"double":             "float",
+	"money":              "float",
+	"percent":            "float",
+	"calendar_date":      "time",
+	"date":               "time",
+	"floating_timestamp": "time",
+	"fixed_timestamp":    "time",
+	"checkbox":           "bool",
+}
+
+// scaffoldInitialisms are the field name words written in capitals in generated Go names.
+var scaffoldInitialisms = map[string]bool{"id": true, "zip": true, "url": true, "gps": true}
+
+// scaffoldColumn is one upstream column kept by a generated collector.
+type scaffoldColumn struct {
+	Field string
+	// DTOName is the record struct field, named like the other SODA records, e.g. Sr_number.
+	DTOName string
+	// GoName is the field of the converted struct, e.g. SRNumber.
+	GoName string
+	Kind   string
+	Key    bool
+}
+
+// scaffoldSpec is everything the collector templates are filled with.
+type scaffoldSpec struct {
+	Name        string
+	Resource    string
+	Type        string
+	Plural      string
+	Title       string
+	Owner       string
+	Description string
+	Columns     []scaffoldColumn
+	Key         scaffoldColumn
+	Skipped     []string
+}
+
+// NeedsSQL reports whether the converted struct has nullable fields.
+func (s scaffoldSpec) NeedsSQL() bool {
+	for _, col := range s.Columns {
+		if !col.Key && col.Kind != "bool" {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields returns the API field names of the kept columns, as a Go string list.
+func (s scaffoldSpec) Fields() string {
+	quoted := make([]string, len(s.Columns))
+	for i, col := range s.Columns {
+		quoted[i] = fmt.Sprintf("%q", col.Field)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// ColumnNames returns the quoted column list of the table's INSERT statement.
+func (s scaffoldSpec) ColumnNames() string {
+	quoted := make([]string, 0, len(s.Columns)+1)
+	for _, col := range s.Columns {
+		quoted = append(quoted, fmt.Sprintf("%q", col.Field))
+	}
+	quoted = append(quoted, fmt.Sprintf("%q", shared.IngestRunIDColumn))
+	return strings.Join(quoted, ", ")
+}
+
+// Placeholders returns the VALUES placeholders of the table's INSERT statement.
+func (s scaffoldSpec) Placeholders() string {
+	placeholders := make([]string, len(s.Columns)+1)
+	for i := range placeholders {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+// Updates returns the SET clause upserts apply to every column but the key.
+func (s scaffoldSpec) Updates() string {
+	var updates []string
+	for _, col := range s.Columns {
+		if !col.Key {
+			updates = append(updates, fmt.Sprintf("%q = EXCLUDED.%q", col.Field, col.Field))
+		}
+	}
+	updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", shared.IngestRunIDColumn, shared.IngestRunIDColumn))
+	return strings.Join(updates, ",\n\t\t\t\t")
+}
+
+func (c scaffoldColumn) SQLType() string {
+	switch {
+	case c.Key:
+		return "VARCHAR(64) PRIMARY KEY"
+	case c.Kind == "float":
+		return "FLOAT8"
+	case c.Kind == "time":
+		return "TIMESTAMP"
+	case c.Kind == "bool":
+		return "BOOLEAN"
+	}
+	return "TEXT"
+}
+
+func (c scaffoldColumn) ColumnType() string {
+	switch {
+	case c.Key:
+		return "shared.ColumnString"
+	case c.Kind == "float":
+		return "shared.ColumnFloat"
+	case c.Kind == "time":
+		return "shared.ColumnTimestamp"
+	case c.Kind == "bool":
+		return "shared.ColumnBoolean"
+	}
+	return "shared.ColumnString"
+}
+
+// DTOType is the type of the record struct field: SODA sends checkboxes as JSON booleans and everything
+// else as strings.
+func (c scaffoldColumn) DTOType() string {
+	if c.Kind == "bool" {
+		return "bool"
+	}
+	return "string"
+}
+
+func (c scaffoldColumn) GoType() string {
+	switch {
+	case c.Key:
+		return "string"
+	case c.Kind == "float":
+		return "sql.NullFloat64"
+	case c.Kind == "time":
+		return "sql.NullTime"
+	case c.Kind == "bool":
+		return "bool"
+	}
+	return "sql.NullString"
+}
+
+// Convert returns the expression converting the record field into the converted struct field.
+func (c scaffoldColumn) Convert() string {
+	raw := "record." + c.DTOName
+	switch {
+	case c.Key:
+		return fmt.Sprintf("p.required(%q, %s)", c.Field, raw)
+	case c.Kind == "float":
+		return fmt.Sprintf("p.nullFloat(%q, %s)", c.Field, raw)
+	case c.Kind == "time":
+		return fmt.Sprintf("p.nullTime(%q, %s)", c.Field, raw)
+	case c.Kind == "bool":
+		return raw
+	}
+	return fmt.Sprintf("nullString(%s)", raw)
+}
+
+// Store returns the expression passing the converted field to the store.
+func (c scaffoldColumn) Store(row string) string {
+	if c.Kind == "time" && !c.Key {
+		return fmt.Sprintf("nullTimestamp(%s.%s)", row, c.GoName)
+	}
+	return row + "." + c.GoName
+}
+
+// scaffold generates code, so far only collectors.
+func scaffold(args []string) error {
+	if len(args) == 0 || args[0] != "collector" {
+		return errors.New(`expected "collector"`)
+	}
+	return scaffoldCollector(args[1:])
+}
+
+// scaffoldCollector writes a record struct, table definition, and loader for a Socrata dataset in
+// datasets, a collector for it in cmd/collectors, and a test skeleton, and registers the collector, its
+// table, and its source. The table definition's CREATE statement is the table's migration: collectors
+// create their tables from it. Column types are guessed from the dataset's metadata and are meant to be
+// reviewed before the collector is committed.
+func scaffoldCollector(args []string) error {
+	flags := flag.NewFlagSet("scaffold collector", flag.ExitOnError)
+	resource := flags.String("resource", "", "Socrata identifier of the dataset, e.g. abcd-1234")
+	name := flags.String("name", "", "collector job and table name, e.g. crimes")
+	key := flags.String("key", "", "field uniquely identifying a row (default id)")
+	typeName := flags.String("type", "", "name of the converted Go struct (default the singular of -name)")
+	flags.Parse(args)
+
+	if !scaffoldResourcePattern.MatchString(*resource) {
+		return fmt.Errorf("-resource %q is not a Socrata identifier like abcd-1234", *resource)
+	}
+	if !scaffoldNamePattern.MatchString(*name) {
+		return fmt.Errorf("-name %q must be lowercase letters, digits, and underscores", *name)
+	}
+	if *typeName != "" && !scaffoldTypePattern.MatchString(*typeName) {
+		return fmt.Errorf("-type %q must be an exported Go identifier", *typeName)
+	}
+
+	root, err := findProjectRoot()
+	if err != nil {
+		return err
+	}
+	src := filepath.Join(root, "src")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	view, err := shared.FetchSODAView(ctx, *resource)
+	if err != nil {
+		return err
+	}
+
+	spec, err := newScaffoldSpec(*name, *resource, *key, *typeName, view)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]*template.Template{
+		filepath.Join(src, "datasets", spec.Name+".go"):          scaffoldDatasetTemplate,
+		filepath.Join(src, "datasets", spec.Name+"_test.go"):     scaffoldTestTemplate,
+		filepath.Join(src, "cmd", "collectors", spec.Name+".go"): scaffoldCollectorTemplate,
+	}
+	for path := range files {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists", path)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to check %s: %w", path, err)
+		}
+	}
+
+	// Every edit is prepared before anything is written, so a failure leaves the tree untouched.
+	edits := map[string][]byte{}
+	for path, tmpl := range files {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, spec); err != nil {
+			return fmt.Errorf("failed to generate %s: %w", path, err)
+		}
+		code, err := format.Source(buf.Bytes())
+		if err != nil {
+			return fmt.Errorf("failed to format %s: %w", path, err)
+		}
+		edits[path] = code
+	}
+
+	registrations := []struct {
+		path, opening, closing, entry string
+		sorted                        bool
+	}{
+		{
+			path:    filepath.Join(src, "cmd", "collectors", "runner.go"),
+			opening: "var collectorJobs = []collectorJob{",
+			closing: "}",
+			entry: fmt.Sprintf("\t{name: %q, run: Get%s, dataset: collectorDataset{\n\t\ttitle: %q,\n\t\tresources: []string{%q},\n\t\towner: %q,\n\t\tdescription: %q,\n\t}},",
+				spec.Name, spec.Plural, spec.Title, spec.Resource, spec.Owner, spec.Description),
+		},
+		{
+			path:    filepath.Join(src, "datasets", "sources.go"),
+			opening: "var SODASources = []shared.SODASource{",
+			closing: "}",
+			entry:   fmt.Sprintf("\t%sSource,", spec.Plural),
+		},
+		{
+			path:    filepath.Join(src, "datasets", "sources.go"),
+			opening: "var ManagedDatasets = []shared.Dataset{",
+			closing: "}",
+			entry:   fmt.Sprintf("\t%sDataset,", spec.Plural),
+			sorted:  true,
+		},
+		{
+			path:    filepath.Join(src, "shared", "city_profile.go"),
+			opening: "\tDatasets: map[string]string{",
+			closing: "\t},",
+			entry:   fmt.Sprintf("\t\t%q: %q,", spec.Name, spec.Resource),
+		},
+	}
+	for _, reg := range registrations {
+		code, ok := edits[reg.path]
+		if !ok {
+			if code, err = os.ReadFile(reg.path); err != nil {
+				return fmt.Errorf("failed to read %s: %w", reg.path, err)
+			}
+		}
+		code, err = insertIntoBlock(code, reg.opening, reg.closing, reg.entry, reg.sorted)
+		if err != nil {
+			return fmt.Errorf("failed to register %s in %s: %w", spec.Name, reg.path, err)
+		}
+		edits[reg.path] = code
+	}
+	for path, code := range edits {
+		if code, err = format.Source(code); err != nil {
+			return fmt.Errorf("failed to format %s: %w", path, err)
+		}
+		edits[path] = code
+	}
+
+	for path, code := range edits {
+		if err := os.WriteFile(path, code, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		rel, _ := filepath.Rel(root, path)
+		fmt.Printf("wrote %s\n", rel)
+	}
+	if len(spec.Skipped) > 0 {
+		fmt.Printf("\nskipped columns SODA sends as objects: %s\n", strings.Join(spec.Skipped, ", "))
+	}
+	fmt.Printf("\nReview the column types and the conversion in datasets/%s.go, fill in the test, then run\n", spec.Name)
+	fmt.Printf("go build ./... && go test ./datasets and cbictl run-collector %s.\n", spec.Name)
+	return nil
+}
+
+// newScaffoldSpec names the generated code after name and keeps the columns of view that SODA sends as
+// strings or booleans.
+func newScaffoldSpec(name, resource, key, typeName string, view shared.SODAView) (scaffoldSpec, error) {
+	plural := goName(name)
+	if typeName == "" {
+		typeName = singular(plural)
+	}
+	if typeName == plural {
+		return scaffoldSpec{}, fmt.Errorf("the singular of %s is ambiguous; name the Go struct with -type", name)
+	}
+	if key == "" {
+		key = "id"
+	}
+
+	spec := scaffoldSpec{
+		Name:        name,
+		Resource:    resource,
+		Type:        typeName,
+		Plural:      plural,
+		Title:       oneLine(view.Name),
+		Owner:       oneLine(view.Attribution),
+		Description: oneLine(view.Description),
+	}
+	if spec.Title == "" {
+		spec.Title = name
+	}
+
+	usedNames := map[string]bool{}
+	for _, col := range view.Columns {
+		kind, ok := scaffoldKinds[col.DataTypeName]
+		if !ok {
+			spec.Skipped = append(spec.Skipped, fmt.Sprintf("%s (%s)", col.FieldName, col.DataTypeName))
+			continue
+		}
+		column := scaffoldColumn{
+			Field:   col.FieldName,
+			DTOName: dtoName(col.FieldName),
+			GoName:  goName(col.FieldName),
+			Kind:    kind,
+			Key:     col.FieldName == key,
+		}
+		for base, i := column.GoName, 2; usedNames[column.GoName]; i++ {
+			column.GoName = fmt.Sprintf("%s%d", base, i)
+		}
+		usedNames[column.GoName] = true
+		if column.Key {
+			if kind == "bool" {
+				return scaffoldSpec{}, fmt.Errorf("key field %s is a checkbox", key)
+			}
+			spec.Key = column
+		}
+		spec.Columns = append(spec.Columns, column)
+	}
+
+	if spec.Key.Field == "" {
+		fields := make([]string, len(spec.Columns))
+		for i, col := range spec.Columns {
+			fields[i] = col.Field
+		}
+		return scaffoldSpec{}, fmt.Errorf("dataset %s has no field %s; pick the field identifying a row with -key from: %s",
+			resource, key, strings.Join(fields, ", "))
+	}
+	return spec, nil
+}
+
+// insertIntoBlock adds entry as a line of the block of code starting at the line opening and ending at the
+// next line equal to closing: in case-insensitive order when sorted, else last.
+func insertIntoBlock(code []byte, opening, closing, entry string, sorted bool) ([]byte, error) {
+	lines := strings.Split(string(code), "\n")
+	start := -1
+	for i, line := range lines {
+		if strings.HasPrefix(line, opening) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("%q not found", opening)
+	}
+
+	at := -1
+	for i := start + 1; i < len(lines); i++ {
+		if sorted && at < 0 && strings.ToLower(strings.TrimSpace(lines[i])) > strings.ToLower(strings.TrimSpace(entry)) {
+			at = i
+		}
+		if lines[i] == closing {
+			if at < 0 {
+				at = i
+			}
+			break
+		}
+	}
+	if at < 0 {
+		return nil, fmt.Errorf("end of the block starting with %q not found", opening)
+	}
+
+	lines = append(lines[:at], append([]string{entry}, lines[at:]...)...)
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// dtoName names a record struct field like the other SODA records do: the field name with its first
+// letter capitalized, e.g. Sr_number.
+func dtoName(field string) string {
+	name := []rune(field)
+	if !unicode.IsLetter(name[0]) {
+		return "F" + field
+	}
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+// goName turns a snake_case name into a Go name, e.g. "sr_number" into "SrNumber" and "zip_code" into
+// "ZIPCode".
+func goName(snake string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(snake, func(r rune) bool { return r == '_' || r == '-' }) {
+		if scaffoldInitialisms[word] {
+			b.WriteString(strings.ToUpper(word))
+			continue
+		}
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	name := b.String()
+	if name == "" || !unicode.IsLetter(rune(name[0])) {
+		name = "F" + name
+	}
+	return name
+}
+
+// singular drops the plural s of name, e.g. "Crimes" becomes "Crime".
+func singular(name string) string {
+	if strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss") {
+		return strings.TrimSuffix(name, "s")
+	}
+	return name
+}
+
+// oneLine collapses the whitespace of Socrata's free text, which may span paragraphs, into single spaces.
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+var scaffoldDatasetTemplate = template.Must(template.New("dataset").Parse(`package datasets
+
+import (
+	"context"
+{{- if .NeedsSQL}}
+	"database/sql"
+{{- end}}
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// {{.Type}}Record is a row of the {{.Title}} dataset ({{.Resource}}) as SODA returns it.
+type {{.Type}}Record struct {
+{{- range .Columns}}
+	{{.DTOName}} {{.DTOType}} ` + "`" + `json:"{{.Field}}" parquet:"{{.Field}}"` + "`" + `
+{{- end}}
+}
+
+type {{.Type}}Records []{{.Type}}Record
+
+var {{.Plural}}Source = shared.SODASource{
+	Name:   "{{.Name}}",
+	ID:     "{{.Resource}}",
+	Record: {{.Type}}Record{},
+	Select: []string{ {{- .Fields -}} },
+}
+
+var {{.Plural}}Dataset = shared.Dataset{
+	Table: "{{.Name}}",
+	CreateSQL: ` + "`" + `CREATE TABLE IF NOT EXISTS "{{.Name}}" (
+{{- range .Columns}}
+    "{{.Field}}" {{.SQLType}},
+{{- end}}
+    "ingest_run_id" VARCHAR(32)
+);` + "`" + `,
+	InsertSQL: ` + "`" + `INSERT INTO {{.Name}} ({{.ColumnNames}})
+			VALUES ({{.Placeholders}})
+			ON CONFLICT ("{{.Key.Field}}") DO UPDATE
+			SET {{.Updates}};` + "`" + `,
+	Columns: []shared.Column{
+{{- range .Columns}}
+		{Name: "{{.Field}}", Type: {{.ColumnType}}},
+{{- end}}
+		{Name: shared.IngestRunIDColumn, Type: shared.ColumnString},
+	},
+	RecordBytes: 1024,
+}
+
+// {{.Type}} is a row of {{.Title}}.
+type {{.Type}} struct {
+{{- range .Columns}}
+	{{.GoName}} {{.GoType}}
+{{- end}}
+}
+
+// {{.Type}}FromDTO converts a SODA {{.Title}} row, failing when its {{.Key.Field}} is missing or a number or
+// date does not parse. Other missing values are NULL.
+func {{.Type}}FromDTO(record {{.Type}}Record) ({{.Type}}, error) {
+	var p fieldParser
+	row := {{.Type}}{
+{{- range .Columns}}
+		{{.GoName}}: {{.Convert}},
+{{- end}}
+	}
+	return row, p.err()
+}
+
+// Load{{.Plural}} writes the {{.Title}} rows that convert to a {{.Type}} to store and flushes it.
+func Load{{.Plural}}(ctx context.Context, store shared.Store, records {{.Type}}Records) (insertedCount, skippedCount int, err error) {
+	skipped := skipTally{}
+	defer skipped.report({{.Plural}}Dataset.Table)
+
+	for _, record := range records {
+		row, convErr := {{.Type}}FromDTO(record)
+		if convErr != nil {
+			skipped.add(convErr)
+			skippedCount++
+			continue
+		}
+
+		err = store.Insert(ctx, {{.Plural}}Dataset,
+{{- range .Columns}}
+			{{.Store "row"}},
+{{- end}}
+		)
+		if err != nil {
+			return insertedCount, skippedCount, err
+		}
+		insertedCount++
+	}
+
+	return insertedCount, skippedCount, store.Flush(ctx, {{.Plural}}Dataset)
+}
+`))
+
+var scaffoldCollectorTemplate = template.Must(template.New("collector").Parse(`package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func Get{{.Plural}}(ctx context.Context, db *sql.DB) {
+	fmt.Println("Get{{.Plural}}: Collecting {{.Title}}")
+
+	store, err := shared.StoreForTable(db, datasets.{{.Plural}}Dataset.Table)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := store.Reset(ctx, datasets.{{.Plural}}Dataset); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Created Table for {{.Name}} in %s\n", store.Name())
+
+	query := shared.SodaQuery{
+		Resource: "{{.Resource}}",
+		Select:   datasets.{{.Plural}}Source.Select,
+	}
+
+	var insertedCount, skippedCount int
+	limit := shared.CollectorLimit(datasets.{{.Plural}}Dataset, 20000)
+	decodeStats, err := shared.FetchSODAChunks(ctx, query, limit, shared.ChunkSize(datasets.{{.Plural}}Dataset), shared.FetchFastAPI,
+		func(chunk int, records []datasets.{{.Type}}Record) error {
+			fmt.Printf("\n\n Number of {{.Name}} SODA records received = %d\n\n", len(records))
+
+			if archived, err := shared.ArchiveRawChunk(ctx, "{{.Name}}", chunk, records); err != nil {
+				shared.NoteError(ctx, "unable to archive raw {{.Name}} records: %v", err)
+			} else if archived != "" {
+				fmt.Printf("Archived raw {{.Name}} records to %s\n", archived)
+			}
+
+			inserted, skipped, err := datasets.Load{{.Plural}}(ctx, store, records)
+			insertedCount += inserted
+			skippedCount += skipped
+			return err
+		})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("{{.Title}} decode stats: %s\n", decodeStats)
+
+	shared.CountLoaded(ctx, insertedCount, skippedCount)
+
+	if err := shared.RecordTableRefresh(db, "{{.Name}}", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to record {{.Name}} refresh: %v", err)
+	}
+
+	if action, err := shared.MaintainTable(ctx, db, "{{.Name}}", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to run maintenance on {{.Name}}: %v", err)
+	} else if action != "" {
+		fmt.Printf("Ran %s on {{.Name}}\n", action)
+	}
+}
+`))
+
+var scaffoldTestTemplate = template.Must(template.New("test").Parse(`package datasets
+
+import "testing"
+
+func Test{{.Type}}FromDTO(t *testing.T) {
+	// TODO: fill in a representative {{.Resource}} row and check the converted fields.
+	record := {{.Type}}Record{ {{- .Key.DTOName}}: "1"}
+	if _, err := {{.Type}}FromDTO(record); err != nil {
+		t.Fatalf("{{.Type}}FromDTO(%+v) failed: %v", record, err)
+	}
+
+	record.{{.Key.DTOName}} = ""
+	if _, err := {{.Type}}FromDTO(record); err == nil {
+		t.Fatalf("{{.Type}}FromDTO accepted a row without {{.Key.Field}}")
+	}
+}
+`))
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// smokeCollector loads a small sample of one SODA dataset into the smoke schema.
+type smokeCollector struct {
+	name    string
+	dataset shared.Dataset
+	pulls   []smokePull
+}
+
+// smokePull fetches one resource and loads it; several pulls may feed one table.
+type smokePull struct {
+	resource string
+	load     func(ctx context.Context, store shared.Store, body []byte) (int, error)
+}
+
+var smokeCollectors = []smokeCollector{
+	{name: "public_health", dataset: datasets.PublicHealthDataset, pulls: []smokePull{
+		{resource: "iqnk-2tcu", load: smokeLoader(func(ctx context.Context, store shared.Store, records []datasets.UnemploymentJsonRecord) (int, int, error) {
+			return datasets.LoadPublicHealth(ctx, store, records, datasets.NewPublicHealthVintage(time.Now()))
+		})},
+	}},
+	{name: "building_permits", dataset: datasets.BuildingPermitsDataset, pulls: []smokePull{
+		{resource: "ydr8-5enu", load: smokeLoader(func(ctx context.Context, store shared.Store, records []datasets.BuildingPermitsJsonRecord) (int, int, error) {
+			return datasets.LoadBuildingPermits(ctx, store, records, false)
+		})},
+	}},
+	{name: "taxi_trips", dataset: datasets.TaxiTripsDataset, pulls: []smokePull{
+		{resource: "wrvz-psew", load: smokeLoader(func(ctx context.Context, store shared.Store, records []datasets.TripRecord) (int, int, error) {
+			return datasets.LoadTrips(ctx, store, "taxi", records, false)
+		})},
+		{resource: "m6dm-c72p", load: smokeLoader(func(ctx context.Context, store shared.Store, records []datasets.TripRecord) (int, int, error) {
+			return datasets.LoadTrips(ctx, store, "tnp", records, false)
+		})},
+	}},
+	{name: "covid", dataset: datasets.CovidDataset, pulls: []smokePull{
+		{resource: "yhhz-zm2v", load: smokeLoader(func(ctx context.Context, store shared.Store, records []datasets.CovidRecord) (int, int, error) {
+			return datasets.LoadCovid(ctx, store, records)
+		})},
+	}},
+	{name: "ccvi", dataset: datasets.CCVIDataset, pulls: []smokePull{
+		{resource: "xhc6-88s9", load: smokeLoader(func(ctx context.Context, store shared.Store, records []datasets.CCVIRecord) (int, int, error) {
+			return datasets.LoadCCVI(ctx, store, records)
+		})},
+	}},
+}
+
+// smokeReports are run through the reports service in the order its cycle runs them.
+var smokeReports = []string{"covid_category", "disadvantaged", "coverage_gaps", "anomalies", "trips_by_time", "trip_fares", "permit_processing", "star_schema"}
+
+func smokeLoader[T any](load func(ctx context.Context, store shared.Store, records []T) (int, int, error)) func(context.Context, shared.Store, []byte) (int, error) {
+	return func(ctx context.Context, store shared.Store, body []byte) (int, error) {
+		records, _, err := shared.DecodeSODARecords[T](body)
+		if err != nil {
+			return 0, err
+		}
+		inserted, _, err := load(ctx, store, records)
+		return inserted, err
+	}
+}
+
+type smokeResult struct {
+	stage    string
+	name     string
+	err      error
+	detail   string
+	duration time.Duration
+}
+
+// runSmoke loads a few rows of every dataset into a throwaway schema, runs every report against it through
+// the reports service, and reports pass/fail per stage. It fails when any stage fails, so it can gate a
+// deployment.
+func runSmoke(args []string) error {
+	flags := flag.NewFlagSet("smoke", flag.ExitOnError)
+	limit := flags.Int("limit", 5, "rows fetched per SODA resource")
+	reportsURL := flags.String("reports-url", envOrDefault(reportsURLEnvKey, defaultReportsURL), "reports service URL")
+	keep := flags.Bool("keep", false, "keep the smoke schema for inspection instead of dropping it")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	connStr := envOrDefault("DATABASE_URL", shared.DefaultConnectionString)
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	schema := fmt.Sprintf("%s%d", shared.SmokeSchemaPrefix, time.Now().Unix())
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE SCHEMA %q`, schema)); err != nil {
+		return fmt.Errorf("failed to create smoke schema: %w", err)
+	}
+	fmt.Printf("smoke schema %s\n", schema)
+	defer func() {
+		if *keep {
+			fmt.Printf("kept smoke schema %s\n", schema)
+			return
+		}
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(`DROP SCHEMA %q CASCADE`, schema)); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to drop smoke schema %s: %v\n", schema, err)
+		}
+	}()
+
+	smokeDB, err := sql.Open("postgres", shared.WithSearchPath(connStr, schema))
+	if err != nil {
+		return fmt.Errorf("failed to open smoke schema connection: %w", err)
+	}
+	defer smokeDB.Close()
+
+	if err := shared.EnsureLineageTables(smokeDB); err != nil {
+		return fmt.Errorf("failed to prepare smoke schema: %w", err)
+	}
+
+	store, err := shared.NewPostgresStore(smokeDB)
+	if err != nil {
+		return err
+	}
+
+	var results []smokeResult
+	for _, collector := range smokeCollectors {
+		started := time.Now()
+		inserted, err := smokeCollect(ctx, smokeDB, store, collector, *limit)
+		results = append(results, smokeResult{
+			stage:    "collector",
+			name:     collector.name,
+			err:      err,
+			detail:   fmt.Sprintf("%d rows", inserted),
+			duration: time.Since(started),
+		})
+	}
+
+	// The reports read the covid weeks through the view the covid collectors maintain.
+	started := time.Now()
+	err = datasets.RefreshCovidUnifiedView(smokeDB)
+	results = append(results, smokeResult{stage: "collector", name: datasets.CovidUnifiedView, err: err, duration: time.Since(started)})
+
+	for _, report := range smokeReports {
+		started := time.Now()
+		err := smokeReport(*reportsURL, report, schema)
+		results = append(results, smokeResult{stage: "report", name: report, err: err, duration: time.Since(started)})
+	}
+
+	failures := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STAGE\tNAME\tRESULT\tDURATION\tDETAIL")
+	for _, result := range results {
+		status, detail := "PASS", result.detail
+		if result.err != nil {
+			status, detail = "FAIL", result.err.Error()
+			failures++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.stage, result.name, status, result.duration.Round(time.Millisecond), detail)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d stages failed", failures, len(results))
+	}
+	return nil
+}
+
+func smokeCollect(ctx context.Context, db *sql.DB, store shared.Store, collector smokeCollector, limit int) (int, error) {
+	if err := store.Reset(ctx, collector.dataset); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, pull := range collector.pulls {
+		if _, err := shared.CityResource(pull.resource); err != nil {
+			return total, err
+		}
+		res, err := shared.FetchFastAPI(ctx, shared.SodaQuery{Resource: pull.resource, Limit: limit}.URL())
+		if err != nil {
+			return total, err
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return total, fmt.Errorf("failed to read %s response: %w", pull.resource, err)
+		}
+		if res.StatusCode != http.StatusOK {
+			return total, fmt.Errorf("unexpected status fetching %s: %s", pull.resource, res.Status)
+		}
+
+		inserted, err := pull.load(ctx, store, body)
+		total += inserted
+		if err != nil {
+			return total, err
+		}
+	}
+
+	if err := shared.RecordTableRefresh(db, collector.dataset.Table, total); err != nil {
+		return total, err
+	}
+	return total, nil
+}
+
+func smokeReport(baseURL, report, schema string) error {
+	runURL := strings.TrimSuffix(baseURL, "/") + "/run?" + url.Values{"report": {report}, "schema": {schema}}.Encode()
+	resp, err := http.Post(runURL, "text/plain", nil)
+	if err != nil {
+		return fmt.Errorf("failed to call reports service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
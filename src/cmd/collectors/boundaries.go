@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// boundaryLayers are the boundary polygons loaded into PostGIS when the collectors start, for the reports
+// that place points in them.
+var boundaryLayers = []shared.BoundaryLayer{
+	shared.CommunityAreaBoundaries,
+	shared.ZipBoundaries,
+	shared.CensusTractBoundaries,
+}
+
+// loadBoundaryLayers loads every layer of boundaryLayers from the spatial datasets cached under
+// SPATIAL_DATA_DIR. Failures, such as a server without PostGIS, are logged rather than returned; reports
+// fall back to reading the cached files themselves.
+func loadBoundaryLayers(ctx context.Context, db *sql.DB) {
+	for _, layer := range boundaryLayers {
+		loaded, err := shared.LoadBoundaryLayer(ctx, db, layer)
+		if err != nil {
+			log.Printf("failed to load %s: %v", layer.Table, err)
+			continue
+		}
+		log.Printf("loaded %d boundaries into %s", loaded, layer.Table)
+	}
+}
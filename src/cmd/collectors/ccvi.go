@@ -1,110 +1,89 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 
 	_ "github.com/lib/pq"
 
+	"github.com/ahbreck/Chicago_BI/datasets"
 	"github.com/ahbreck/Chicago_BI/shared"
 )
 
-type CCVIRecords []struct {
-	Geography_type        string  `json:"geography_type"`
-	Community_area_or_zip string  `json:"community_area_or_zip"`
-	Community_area_name   string  `json:"community_area_name"`
-	CCVI_score            float64 `json:"ccvi_score,string"`
-	CCVI_category         string  `json:"ccvi_category"`
-}
-
 /////////////////////////////////////////////////////////////////////////////////////////////////////////////
 /////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func GetCCVIDetails(db *sql.DB) {
+func GetCCVIDetails(ctx context.Context, db *sql.DB) {
 	fmt.Println("GetCCVIDetails: Collecting data on Chicago Community Vulnerability Index")
 
-	drop_table := `drop table if exists ccvi`
-	_, err := db.Exec(drop_table)
+	store, err := shared.StoreForTable(db, datasets.CCVIDataset.Table)
 	if err != nil {
 		panic(err)
 	}
 
-	create_table := `CREATE TABLE IF NOT EXISTS "ccvi" (
-    "id" SERIAL PRIMARY KEY,
-    "geography_type" VARCHAR(3),
-    "community_area_or_zip" VARCHAR(9) UNIQUE,
-    "community_area_name" VARCHAR(255),
-    "ccvi_score" FLOAT8,
-    "ccvi_category" VARCHAR(6)
-);`
-
-	_, _err := db.Exec(create_table)
-	if _err != nil {
-		panic(_err)
+	// Keep the previous pull so upstream revisions show up in the table diff recorded after the reload.
+	preserved, err := shared.PreserveTable(ctx, db, datasets.CCVIDataset)
+	if err != nil {
+		shared.NoteError(ctx, "unable to preserve previous ccvi pull: %v", err)
 	}
 
-	fmt.Println("Created Table for CCVI")
+	if err := store.Reset(ctx, datasets.CCVIDataset); err != nil {
+		panic(err)
+	}
 
-	var url = "https://data.cityofchicago.org/resource/xhc6-88s9.json?$select=geography_type,community_area_or_zip,community_area_name,ccvi_score,ccvi_category&$limit=500"
+	fmt.Printf("Created Table for CCVI in %s\n", store.Name())
 
-	//testing url: "https://data.cityofchicago.org/resource/xhc6-88s9.json?$limit=1"
+	query := shared.SodaQuery{
+		Resource: "xhc6-88s9",
+		Select:   datasets.CCVISource.Select,
+	}
 
-	res, err := shared.FetchFastAPI(url)
+	//testing query: shared.SodaQuery{Resource: "xhc6-88s9", Limit: 1}
+
+	var insertedCount, skippedCount int
+	limit := shared.CollectorLimit(datasets.CCVIDataset, 500)
+	decodeStats, err := shared.FetchSODAChunks(ctx, query, limit, shared.ChunkSize(datasets.CCVIDataset), shared.FetchFastAPI,
+		func(chunk int, ccvi_data_list []datasets.CCVIRecord) error {
+			s := fmt.Sprintf("\n\n Number of CCVI SODA records received = %d\n\n", len(ccvi_data_list))
+			io.WriteString(os.Stdout, s)
+
+			if archived, err := shared.ArchiveRawChunk(ctx, "ccvi", chunk, ccvi_data_list); err != nil {
+				shared.NoteError(ctx, "unable to archive raw CCVI records: %v", err)
+			} else if archived != "" {
+				fmt.Printf("Archived raw CCVI records to %s\n", archived)
+			}
+
+			inserted, skipped, err := datasets.LoadCCVI(ctx, store, ccvi_data_list)
+			insertedCount += inserted
+			skippedCount += skipped
+			return err
+		})
 	if err != nil {
 		panic(err)
 	}
-	// adding the below statement to ensure closure in case of early return
-	defer res.Body.Close()
-
-	fmt.Println("Received data from SODA REST API for CCVI")
-
-	body, _ := io.ReadAll(res.Body)
-	var ccvi_data_list CCVIRecords
-	json.Unmarshal(body, &ccvi_data_list)
+	fmt.Printf("CCVI decode stats: %s\n", decodeStats)
 
-	s := fmt.Sprintf("\n\n Number of CCVI SODA records received = %d\n\n", len(ccvi_data_list))
-	io.WriteString(os.Stdout, s)
+	shared.CountLoaded(ctx, insertedCount, skippedCount)
 
-	sql := `INSERT INTO ccvi ("geography_type", "community_area_or_zip", "community_area_name", "ccvi_score", "ccvi_category")
-			VALUES ($1, $2, $3, $4, $5)
-			ON CONFLICT ("community_area_or_zip") DO UPDATE 
-			SET geography_type = EXCLUDED.geography_type,
-				community_area_name = EXCLUDED.community_area_name,
-				ccvi_score = EXCLUDED.ccvi_score,
-				ccvi_category = EXCLUDED.ccvi_category;`
-
-	insertedCount := 0
-	skippedCount := 0
-
-	for _, record := range ccvi_data_list {
-
-		// We will execute defensive coding to check for messy/dirty/missing data values
-		// Any record that has messy/dirty/missing data we don't enter it in the data lake/table
+	if err := shared.RecordTableRefresh(db, "ccvi", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to record ccvi refresh: %v", err)
+	}
 
-		if record.Geography_type == "" ||
-			record.Community_area_or_zip == "" ||
-			record.CCVI_score < 0 ||
-			record.CCVI_category == "" {
-			skippedCount++
-			continue
+	if preserved {
+		if diff, err := shared.RecordTableDiff(ctx, db, datasets.CCVIDataset); err != nil {
+			shared.NoteError(ctx, "unable to diff ccvi against the previous pull: %v", err)
+		} else {
+			fmt.Printf("ccvi changes since the previous pull: %s\n", diff)
 		}
+	}
 
-		_, err = db.Exec(sql,
-			record.Geography_type,
-			record.Community_area_or_zip,
-			record.Community_area_name,
-			record.CCVI_score,
-			record.CCVI_category,
-		)
-
-		if err != nil {
-			panic(err)
-		}
-		insertedCount++
+	if action, err := shared.MaintainTable(ctx, db, "ccvi", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to run maintenance on ccvi: %v", err)
+	} else if action != "" {
+		fmt.Printf("Ran %s on ccvi\n", action)
 	}
-	fmt.Printf("Completed inserting %d rows into the ccvi table. Skipped %d records due to data quality issues.\n", insertedCount, skippedCount)
 
 }
@@ -1,111 +1,88 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 
 	_ "github.com/lib/pq"
 
+	"github.com/ahbreck/Chicago_BI/datasets"
 	"github.com/ahbreck/Chicago_BI/shared"
 )
 
-type CovidRecords []struct {
-	ZIP                            string  `json:"zip_code"`
-	Week_start                     string  `json:"week_start"`
-	Week_end                       string  `json:"week_end"`
-	Case_rate_weekly               float64 `json:"case_rate_weekly,string"`
-	Percent_tested_positive_weekly float64 `json:"percent_tested_positive_weekly,string"`
-}
-
 /////////////////////////////////////////////////////////////////////////////////////////////////////////////
 /////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func GetCovidDetails(db *sql.DB) {
+func GetCovidDetails(ctx context.Context, db *sql.DB) {
 	fmt.Println("GetCovidDetails: Collecting weekly COVID data")
 
-	drop_table := `drop table if exists covid`
-	_, err := db.Exec(drop_table)
+	store, err := shared.StoreForTable(db, datasets.CovidDataset.Table)
 	if err != nil {
 		panic(err)
 	}
 
-	create_table := `CREATE TABLE IF NOT EXISTS "covid" (
-    "id" SERIAL PRIMARY KEY,
-    "zip_code" VARCHAR(9) NOT NULL,
-    "week_start" DATE NOT NULL,
-    "week_end" DATE NOT NULL,
-    "case_rate_weekly" FLOAT8,
-    "percent_tested_positive_weekly" FLOAT8,
-    CONSTRAINT covid_unique_zip_week UNIQUE ("zip_code", "week_start", "week_end")
-);`
-
-	_, _err := db.Exec(create_table)
-	if _err != nil {
-		panic(_err)
+	// The view over covid is recreated once the table is reloaded.
+	if shared.StorageBackendFor(datasets.CovidDataset.Table) == shared.BackendPostgres {
+		if err := datasets.DropCovidUnifiedView(db); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := store.Reset(ctx, datasets.CovidDataset); err != nil {
+		panic(err)
 	}
 
-	fmt.Println("Created Table for COVID weekly")
+	fmt.Printf("Created Table for COVID weekly in %s\n", store.Name())
 
 	// for testing purposes, limiting data to 2022
-	var url = "https://data.cityofchicago.org/resource/yhhz-zm2v.json?$select=zip_code,week_start,week_end,case_rate_weekly,percent_tested_positive_weekly&$limit=1500&$where=week_start%20between%20'2021-12-26'%20and%20'2022-3-31'"
-
-	//testing url: "https://data.cityofchicago.org/resource/yhhz-zm2v.json?$limit=1"
+	query := shared.SodaQuery{
+		Resource: "yhhz-zm2v",
+		Select:   datasets.CovidSource.Select,
+		Where:    "week_start between '2021-12-26' and '2022-03-31'",
+	}
 
-	res, err := shared.FetchFastAPI(url)
+	//testing query: shared.SodaQuery{Resource: "yhhz-zm2v", Limit: 1}
+
+	var insertedCount, skippedCount int
+	limit := shared.CollectorLimit(datasets.CovidDataset, 1500)
+	decodeStats, err := shared.FetchSODAChunks(ctx, query, limit, shared.ChunkSize(datasets.CovidDataset), shared.FetchFastAPI,
+		func(chunk int, covid_data_list []datasets.CovidRecord) error {
+			s := fmt.Sprintf("\n\n Number of COVID weekly SODA records received = %d\n\n", len(covid_data_list))
+			io.WriteString(os.Stdout, s)
+
+			if archived, err := shared.ArchiveRawChunk(ctx, "covid", chunk, covid_data_list); err != nil {
+				shared.NoteError(ctx, "unable to archive raw COVID weekly records: %v", err)
+			} else if archived != "" {
+				fmt.Printf("Archived raw COVID weekly records to %s\n", archived)
+			}
+
+			inserted, skipped, err := datasets.LoadCovid(ctx, store, covid_data_list)
+			insertedCount += inserted
+			skippedCount += skipped
+			return err
+		})
 	if err != nil {
 		panic(err)
 	}
-	// adding the below statement to ensure closure in case of early return
-	defer res.Body.Close()
-
-	fmt.Println("Received data from SODA REST API for COVID weekly")
+	fmt.Printf("COVID weekly decode stats: %s\n", decodeStats)
 
-	body, _ := io.ReadAll(res.Body)
-	var covid_data_list CovidRecords
-	json.Unmarshal(body, &covid_data_list)
+	shared.CountLoaded(ctx, insertedCount, skippedCount)
 
-	s := fmt.Sprintf("\n\n Number of COVID weekly SODA records received = %d\n\n", len(covid_data_list))
-	io.WriteString(os.Stdout, s)
-
-	sql := `INSERT INTO covid ("zip_code", "week_start", "week_end", "case_rate_weekly", "percent_tested_positive_weekly")
-			VALUES ($1, $2, $3, $4, $5)
-			ON CONFLICT ("zip_code", "week_start", "week_end") DO UPDATE 
-			SET case_rate_weekly = EXCLUDED.case_rate_weekly,
-				percent_tested_positive_weekly = EXCLUDED.percent_tested_positive_weekly;`
-
-	insertedCount := 0
-	skippedCount := 0
-
-	for _, record := range covid_data_list {
-
-		// We will execute defensive coding to check for messy/dirty/missing data values
-		// Any record that has messy/dirty/missing data we don't enter it in the data lake/table
-
-		if record.ZIP == "" ||
-			record.Week_start == "" ||
-			record.Week_end == "" ||
-			record.Case_rate_weekly < 0 ||
-			record.Percent_tested_positive_weekly < 0 {
-			skippedCount++
-			continue
-		}
+	if err := shared.RecordTableRefresh(db, "covid", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to record covid refresh: %v", err)
+	}
 
-		_, err = db.Exec(sql,
-			record.ZIP,
-			record.Week_start,
-			record.Week_end,
-			record.Case_rate_weekly,
-			record.Percent_tested_positive_weekly,
-		)
+	if err := datasets.RefreshCovidUnifiedView(db); err != nil {
+		shared.NoteError(ctx, "unable to refresh %s: %v", datasets.CovidUnifiedView, err)
+	}
 
-		if err != nil {
-			panic(err)
-		}
-		insertedCount++
+	if action, err := shared.MaintainTable(ctx, db, "covid", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to run maintenance on covid: %v", err)
+	} else if action != "" {
+		fmt.Printf("Ran %s on covid\n", action)
 	}
-	fmt.Printf("Completed inserting %d rows into the covid table. Skipped %d records due to data quality issues.\n", insertedCount, skippedCount)
 
 }
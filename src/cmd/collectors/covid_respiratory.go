@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// GetCovidRespiratory collects the COVID-19 weeks of the respiratory surveillance dataset that succeeded
+// the weekly COVID dataset. It runs instead of GetCovidDetails with COVID_SOURCE=respiratory.
+func GetCovidRespiratory(ctx context.Context, db *sql.DB) {
+	fmt.Println("GetCovidRespiratory: Collecting weekly respiratory surveillance data")
+
+	resource := shared.CovidRespiratoryResource()
+	if resource == "" {
+		panic(fmt.Errorf("%s is not set", shared.CovidRespiratoryResourceEnvKey))
+	}
+
+	store, err := shared.StoreForTable(db, datasets.CovidRespiratoryDataset.Table)
+	if err != nil {
+		panic(err)
+	}
+
+	// The table is upserted rather than reset, so weeks the city stops publishing stay in the archive.
+	if err := store.Ensure(ctx, datasets.CovidRespiratoryDataset); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Ensured Table for respiratory surveillance in %s\n", store.Name())
+
+	query := shared.SodaQuery{
+		Resource: resource,
+		Select:   datasets.CovidRespiratorySource.Select,
+		Where:    fmt.Sprintf("pathogen = '%s'", datasets.CovidRespiratoryPathogen),
+		Order:    "week_start DESC",
+	}
+
+	var insertedCount, skippedCount int
+	limit := shared.CollectorLimit(datasets.CovidRespiratoryDataset, 5000)
+	decodeStats, err := shared.FetchSODAChunks(ctx, query, limit, shared.ChunkSize(datasets.CovidRespiratoryDataset), shared.FetchFastAPI,
+		func(chunk int, records []datasets.CovidRespiratoryRecord) error {
+			fmt.Printf("\n\n Number of respiratory surveillance SODA records received = %d\n\n", len(records))
+
+			if archived, err := shared.ArchiveRawChunk(ctx, "covid_respiratory", chunk, records); err != nil {
+				shared.NoteError(ctx, "unable to archive raw respiratory surveillance records: %v", err)
+			} else if archived != "" {
+				fmt.Printf("Archived raw respiratory surveillance records to %s\n", archived)
+			}
+
+			inserted, skipped, err := datasets.LoadCovidRespiratory(ctx, store, records)
+			insertedCount += inserted
+			skippedCount += skipped
+			return err
+		})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Respiratory surveillance decode stats: %s\n", decodeStats)
+
+	shared.CountLoaded(ctx, insertedCount, skippedCount)
+
+	if err := shared.RecordTableRefresh(db, "covid_respiratory", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to record covid_respiratory refresh: %v", err)
+	}
+
+	if err := datasets.RefreshCovidUnifiedView(db); err != nil {
+		shared.NoteError(ctx, "unable to refresh %s: %v", datasets.CovidUnifiedView, err)
+	}
+
+	if action, err := shared.MaintainTable(ctx, db, "covid_respiratory", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to run maintenance on covid_respiratory: %v", err)
+	} else if action != "" {
+		fmt.Printf("Ran %s on covid_respiratory\n", action)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+
+	_ "github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// GetCTARidership loads daily 'L' station entries and bus route boardings into cta_ridership. Stations
+// are placed with the 'L' stop list and, when geocoding is enabled, given a ZIP code, so transit usage can
+// be compared with taxi trips per area.
+func GetCTARidership(ctx context.Context, db *sql.DB) {
+	fmt.Println("GetCTARidership: Collecting CTA daily ridership")
+
+	useGeocoding := os.Getenv("USE_GEOCODING") == "true"
+
+	store, err := shared.StoreForTable(db, datasets.CTARidershipDataset.Table)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := store.Reset(ctx, datasets.CTARidershipDataset); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Created Table for CTA ridership in %s\n", store.Name())
+
+	var stops []datasets.CTAStopRecord
+	if _, err := shared.FetchSODAChunks(ctx, shared.SodaQuery{Resource: "8pix-ypme", Select: datasets.CTAStopsSource.Select}, 1000, 0, shared.FetchFastAPI,
+		func(chunk int, records []datasets.CTAStopRecord) error {
+			stops = append(stops, records...)
+			return nil
+		}); err != nil {
+		panic(err)
+	}
+	stations := datasets.CTAStations(ctx, stops, useGeocoding)
+	fmt.Printf("Located %d CTA 'L' stations\n", len(stations))
+
+	// For testing purposes, limiting data to the months the trip collectors pull.
+	where := "date between '2022-01-01T00:00:00' and '2022-03-31T23:59:59'"
+
+	var insertedCount, skippedCount int
+	limit := shared.CollectorLimit(datasets.CTARidershipDataset, 50000)
+	railStats, err := shared.FetchSODAChunks(ctx,
+		shared.SodaQuery{Resource: "5neh-572f", Select: datasets.CTARailSource.Select, Where: where},
+		limit, shared.ChunkSize(datasets.CTARidershipDataset), shared.FetchFastAPI,
+		func(chunk int, rail_data_list []datasets.CTARailRecord) error {
+			s := fmt.Sprintf("\n\n Number of CTA 'L' ridership SODA records received = %d\n\n", len(rail_data_list))
+			io.WriteString(os.Stdout, s)
+
+			if archived, err := shared.ArchiveRawChunk(ctx, "cta_rail", chunk, rail_data_list); err != nil {
+				shared.NoteError(ctx, "unable to archive raw CTA 'L' ridership records: %v", err)
+			} else if archived != "" {
+				fmt.Printf("Archived raw CTA 'L' ridership records to %s\n", archived)
+			}
+
+			inserted, skipped, err := datasets.LoadCTARail(ctx, store, rail_data_list, stations)
+			insertedCount += inserted
+			skippedCount += skipped
+			return err
+		})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("CTA 'L' ridership decode stats: %s\n", railStats)
+
+	busStats, err := shared.FetchSODAChunks(ctx,
+		shared.SodaQuery{Resource: "jyb9-n7fm", Select: datasets.CTABusSource.Select, Where: where},
+		limit, shared.ChunkSize(datasets.CTARidershipDataset), shared.FetchFastAPI,
+		func(chunk int, bus_data_list []datasets.CTABusRecord) error {
+			s := fmt.Sprintf("\n\n Number of CTA bus ridership SODA records received = %d\n\n", len(bus_data_list))
+			io.WriteString(os.Stdout, s)
+
+			if archived, err := shared.ArchiveRawChunk(ctx, "cta_bus", chunk, bus_data_list); err != nil {
+				shared.NoteError(ctx, "unable to archive raw CTA bus ridership records: %v", err)
+			} else if archived != "" {
+				fmt.Printf("Archived raw CTA bus ridership records to %s\n", archived)
+			}
+
+			inserted, skipped, err := datasets.LoadCTABus(ctx, store, bus_data_list)
+			insertedCount += inserted
+			skippedCount += skipped
+			return err
+		})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("CTA bus ridership decode stats: %s\n", busStats)
+
+	shared.CountLoaded(ctx, insertedCount, skippedCount)
+
+	if err := shared.RecordTableRefresh(db, "cta_ridership", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to record cta_ridership refresh: %v", err)
+	}
+
+	if action, err := shared.MaintainTable(ctx, db, "cta_ridership", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to run maintenance on cta_ridership: %v", err)
+	} else if action != "" {
+		fmt.Printf("Ran %s on cta_ridership\n", action)
+	}
+
+}
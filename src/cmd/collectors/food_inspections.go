@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+
+	_ "github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// GetFoodInspections loads food inspections into food_inspections and the retail food business licenses
+// they are linked to by license number into business_licenses.
+func GetFoodInspections(ctx context.Context, db *sql.DB) {
+	fmt.Println("GetFoodInspections: Collecting food inspections and retail food business licenses")
+
+	licenseStore, err := shared.StoreForTable(db, datasets.BusinessLicensesDataset.Table)
+	if err != nil {
+		panic(err)
+	}
+	if err := licenseStore.Reset(ctx, datasets.BusinessLicensesDataset); err != nil {
+		panic(err)
+	}
+
+	inspectionStore, err := shared.StoreForTable(db, datasets.FoodInspectionsDataset.Table)
+	if err != nil {
+		panic(err)
+	}
+	if err := inspectionStore.Reset(ctx, datasets.FoodInspectionsDataset); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Created Tables for business licenses in %s and food inspections in %s\n", licenseStore.Name(), inspectionStore.Name())
+
+	licenseQuery := shared.SodaQuery{
+		Resource: "r5kz-chrr",
+		Select:   datasets.BusinessLicensesSource.Select,
+		Where:    fmt.Sprintf("license_description = '%s'", datasets.FoodInspectionsLicenseDescription),
+	}
+
+	var licensesInserted, licensesSkipped int
+	licenseStats, err := shared.FetchSODAChunks(ctx, licenseQuery, shared.CollectorLimit(datasets.BusinessLicensesDataset, 50000),
+		shared.ChunkSize(datasets.BusinessLicensesDataset), shared.FetchFastAPI,
+		func(chunk int, license_data_list []datasets.BusinessLicenseRecord) error {
+			s := fmt.Sprintf("\n\n Number of business license SODA records received = %d\n\n", len(license_data_list))
+			io.WriteString(os.Stdout, s)
+
+			if archived, err := shared.ArchiveRawChunk(ctx, "business_licenses", chunk, license_data_list); err != nil {
+				shared.NoteError(ctx, "unable to archive raw business license records: %v", err)
+			} else if archived != "" {
+				fmt.Printf("Archived raw business license records to %s\n", archived)
+			}
+
+			inserted, skipped, err := datasets.LoadBusinessLicenses(ctx, licenseStore, license_data_list)
+			licensesInserted += inserted
+			licensesSkipped += skipped
+			return err
+		})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Business licenses decode stats: %s\n", licenseStats)
+	shared.CountLoaded(ctx, licensesInserted, licensesSkipped)
+
+	// For testing purposes, limiting data to 2022
+	inspectionQuery := shared.SodaQuery{
+		Resource: "4ijn-s7e5",
+		Select:   datasets.FoodInspectionsSource.Select,
+		Where:    "inspection_date between '2022-01-01T00:00:00' and '2022-12-31T23:59:59'",
+	}
+
+	var inspectionsInserted, inspectionsSkipped int
+	inspectionStats, err := shared.FetchSODAChunks(ctx, inspectionQuery, shared.CollectorLimit(datasets.FoodInspectionsDataset, 20000),
+		shared.ChunkSize(datasets.FoodInspectionsDataset), shared.FetchFastAPI,
+		func(chunk int, inspection_data_list []datasets.FoodInspectionRecord) error {
+			s := fmt.Sprintf("\n\n Number of food inspection SODA records received = %d\n\n", len(inspection_data_list))
+			io.WriteString(os.Stdout, s)
+
+			if archived, err := shared.ArchiveRawChunk(ctx, "food_inspections", chunk, inspection_data_list); err != nil {
+				shared.NoteError(ctx, "unable to archive raw food inspection records: %v", err)
+			} else if archived != "" {
+				fmt.Printf("Archived raw food inspection records to %s\n", archived)
+			}
+
+			inserted, skipped, err := datasets.LoadFoodInspections(ctx, inspectionStore, inspection_data_list)
+			inspectionsInserted += inserted
+			inspectionsSkipped += skipped
+			return err
+		})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Food inspections decode stats: %s\n", inspectionStats)
+	shared.CountLoaded(ctx, inspectionsInserted, inspectionsSkipped)
+
+	for table, inserted := range map[string]int{"business_licenses": licensesInserted, "food_inspections": inspectionsInserted} {
+		if err := shared.RecordTableRefresh(db, table, inserted); err != nil {
+			shared.NoteError(ctx, "unable to record %s refresh: %v", table, err)
+		}
+
+		if action, err := shared.MaintainTable(ctx, db, table, inserted); err != nil {
+			shared.NoteError(ctx, "unable to run maintenance on %s: %v", table, err)
+		} else if action != "" {
+			fmt.Printf("Ran %s on %s\n", action, table)
+		}
+	}
+
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	// idempotencyKeyHeader names the header a caller of /run sets so retries of one trigger, such as Cloud
+	// Scheduler's, do not start the collector again.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// collectorRunsTable records the runs of /run that came with an idempotency key.
+	collectorRunsTable = "collector_runs"
+	// idempotencyKeyTTL is how long a key is remembered; a key reused after that starts a new run.
+	idempotencyKeyTTL = 24 * time.Hour
+	// collectorRunning is the status of a keyed run that has not finished.
+	collectorRunning = "running"
+)
+
+// collectorRun is a run of /run started with an idempotency key.
+type collectorRun struct {
+	IdempotencyKey string     `json:"idempotency_key"`
+	Collector      string     `json:"collector"`
+	Status         string     `json:"status"`
+	Detail         string     `json:"detail,omitempty"`
+	StartedAt      time.Time  `json:"started_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// ensureCollectorRunsTable creates collector_runs when it does not exist.
+func ensureCollectorRunsTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+		"idempotency_key" VARCHAR(255) PRIMARY KEY,
+		"collector" VARCHAR(64) NOT NULL,
+		"status" VARCHAR(16) NOT NULL,
+		"detail" TEXT NOT NULL DEFAULT '',
+		"started_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+		"finished_at" TIMESTAMP WITH TIME ZONE
+	)`, collectorRunsTable))
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", collectorRunsTable, err)
+	}
+	return nil
+}
+
+// claimCollectorRun records a run of collector under key unless the key already has one, in which case
+// that run is returned with claimed false. Keys older than idempotencyKeyTTL are forgotten first, and so is
+// a run still marked running after stale, which means its instance died before recording the outcome.
+func claimCollectorRun(db *sql.DB, key, collector string, stale time.Duration) (run collectorRun, claimed bool, err error) {
+	_, err = db.Exec(fmt.Sprintf(`DELETE FROM %q
+		WHERE "idempotency_key" = $1
+			AND ("started_at" < $2 OR ("status" = $3 AND "started_at" < $4))`, collectorRunsTable),
+		key, time.Now().Add(-idempotencyKeyTTL), collectorRunning, time.Now().Add(-stale))
+	if err != nil {
+		return run, false, fmt.Errorf("failed to expire idempotency key %q: %w", key, err)
+	}
+
+	run = collectorRun{IdempotencyKey: key, Collector: collector, Status: collectorRunning, StartedAt: time.Now()}
+	result, err := db.Exec(fmt.Sprintf(`INSERT INTO %q ("idempotency_key", "collector", "status", "started_at")
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT ("idempotency_key") DO NOTHING`, collectorRunsTable),
+		key, collector, collectorRunning, run.StartedAt)
+	if err != nil {
+		return run, false, fmt.Errorf("failed to record idempotency key %q: %w", key, err)
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 1 {
+		return run, true, nil
+	}
+
+	var finishedAt sql.NullTime
+	err = db.QueryRow(fmt.Sprintf(`SELECT "collector", "status", "detail", "started_at", "finished_at" FROM %q WHERE "idempotency_key" = $1`, collectorRunsTable), key).
+		Scan(&run.Collector, &run.Status, &run.Detail, &run.StartedAt, &finishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The run expired between the insert and the lookup; the caller may retry.
+		return run, false, fmt.Errorf("idempotency key %q was released concurrently; retry the request", key)
+	}
+	if err != nil {
+		return run, false, fmt.Errorf("failed to read idempotency key %q: %w", key, err)
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	return run, false, nil
+}
+
+// finishCollectorRun records the outcome of the run claimed under key.
+func finishCollectorRun(db *sql.DB, key, status, detail string) error {
+	_, err := db.Exec(fmt.Sprintf(`UPDATE %q SET "status" = $2, "detail" = $3, "finished_at" = NOW() WHERE "idempotency_key" = $1`, collectorRunsTable),
+		key, status, detail)
+	if err != nil {
+		return fmt.Errorf("failed to record outcome of idempotency key %q: %w", key, err)
+	}
+	return nil
+}
+
+// releaseCollectorRun forgets the run claimed under key, for a collector that did not start.
+func releaseCollectorRun(db *sql.DB, key string) error {
+	if _, err := db.Exec(fmt.Sprintf(`DELETE FROM %q WHERE "idempotency_key" = $1`, collectorRunsTable), key); err != nil {
+		return fmt.Errorf("failed to release idempotency key %q: %w", key, err)
+	}
+	return nil
+}
+
+// writeCollectorRun answers a retried request with the run its key already started: 202 Accepted while it
+// is running, 500 when it failed, and 200 otherwise. A key reused for another collector is refused with
+// 422 Unprocessable Entity.
+func writeCollectorRun(w http.ResponseWriter, run collectorRun, collector string) {
+	if run.Collector != collector {
+		http.Error(w, fmt.Sprintf("idempotency key %q was already used for collector %s", run.IdempotencyKey, run.Collector), http.StatusUnprocessableEntity)
+		return
+	}
+
+	status := http.StatusOK
+	switch run.Status {
+	case collectorRunning:
+		status = http.StatusAccepted
+	case shared.JobStatusFailed:
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(run); err != nil {
+		log.Printf("failed to write collector run response: %v", err)
+	}
+}
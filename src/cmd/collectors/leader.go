@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	// cycleLeaderLock is the advisory lock held by the one instance that runs the collection cycle.
+	cycleLeaderLock = "collection_cycle"
+	// leaderRetryInterval is how often an instance that lost the election tries again, taking over when the
+	// leader's session ends.
+	leaderRetryInterval = 5 * time.Minute
+)
+
+// leaderElection elects one collectors instance, among those sharing the database, to run the collection
+// cycle; the others only serve HTTP. The leader keeps the lock for the life of its process, so a later
+// instance does not pull the same day's data again.
+type leaderElection struct {
+	db   *sql.DB
+	lock *shared.AdvisoryLock
+}
+
+// elect reports whether this instance leads, taking the lock when it is free. A leader whose session was
+// lost gives the lock up and runs the election again.
+func (e *leaderElection) elect(ctx context.Context) bool {
+	if e.lock != nil {
+		if e.lock.Held(ctx) {
+			return true
+		}
+		log.Print("lost the collection cycle lock; running the election again")
+		e.lock.Release()
+		e.lock = nil
+	}
+
+	lock, err := shared.TryAdvisoryLock(ctx, e.db, shared.CollectorLockNamespace, cycleLeaderLock)
+	if err != nil {
+		log.Printf("leader election failed: %v", err)
+		return false
+	}
+	e.lock = lock
+	return lock != nil
+}
+
+// waitForLeadership blocks until this instance leads the collection cycle, retrying every
+// leaderRetryInterval, or ctx is done.
+func (e *leaderElection) waitForLeadership(ctx context.Context) bool {
+	logged := false
+	for {
+		if e.elect(ctx) {
+			if logged {
+				log.Print("took over the collection cycle")
+			}
+			return true
+		}
+		if !logged {
+			log.Printf("another instance runs the collection cycle; serving HTTP only and retrying every %s", leaderRetryInterval)
+			logged = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(leaderRetryInterval):
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -8,9 +10,9 @@ import (
 
 	"strings"
 
-	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 
+	"github.com/ahbreck/Chicago_BI/datasets"
 	"github.com/ahbreck/Chicago_BI/shared"
 )
 
@@ -24,9 +26,29 @@ func handler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	if err := godotenv.Load(); err != nil {
+	selfTest := flag.Bool("selftest", false, shared.SelfTestFlagUsage)
+	flag.Parse()
+
+	if err := shared.LoadDotenv(); err != nil {
 		log.Fatalf("error loading .env file: %v", err)
 	}
+	if *selfTest {
+		connStr := os.Getenv("DATABASE_URL")
+		if connStr == "" {
+			connStr = shared.DefaultConnectionString
+		}
+		if shared.RunSelfTest(context.Background(), os.Stdout, connStr, datasets.SODASources) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if err := shared.CheckRequiredConfig(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := shared.CheckSODASelects(datasets.SODASources); err != nil {
+		log.Fatalf("%v", err)
+	}
+	shared.LogConfigProblems()
 
 	runOnce := strings.EqualFold(os.Getenv("RUN_ONCE"), "true")
 
@@ -41,7 +63,42 @@ func main() {
 	}
 	defer db.Close()
 
+	if err := shared.EnsureLineageTables(db); err != nil {
+		log.Fatalf("failed to prepare lineage tables: %v", err)
+	}
+	if err := shared.TrackGeocodingUsage(db); err != nil {
+		log.Printf("geocoding usage will not be tracked: %v", err)
+	}
+	if err := shared.PersistGeocodeCache(db); err != nil {
+		log.Printf("geocoder answers will be cached in memory only: %v", err)
+	}
+	if err := ensureCollectorRunsTable(db); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := shared.RegisterDatasets(db, collectorDatasets()); err != nil {
+		log.Printf("datasets registry not updated: %v", err)
+	}
+	loadBoundaryLayers(context.Background(), db)
+
+	if !strings.EqualFold(os.Getenv("SKIP_SCHEMA_CHECK"), "true") {
+		log.Print("checking upstream SODA schemas for drift")
+		if _, err := shared.CheckSODASchemas(context.Background(), db, datasets.SODASources); err != nil {
+			log.Printf("schema drift check failed: %v", err)
+		}
+	}
+
 	http.HandleFunc("/", handler)
+	http.HandleFunc("/last-run", lastRunHandler)
+	http.HandleFunc("/metrics", shared.MetricsHandler())
+	http.HandleFunc("/run", shared.AuditHandler(db, "collectors", nil, runCollectorHandler(db)))
+	// Like /run, /admin/reload is left to Cloud Run's IAM invoker check.
+	reload := func() (any, error) { return shared.ReloadConfig() }
+	http.HandleFunc("/admin/reload", shared.AuditHandler(db, "collectors", nil, shared.ReloadHandler(reload)))
+	shared.OnReloadSignal(context.Background(), func() {
+		if _, err := reload(); err != nil {
+			log.Printf("configuration reload failed: %v", err)
+		}
+	})
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -60,25 +117,28 @@ func main() {
 
 	runCollectors := func() {
 		log.Print("starting CBI collector microservices ...")
-		go GetUnemploymentRates(db)
-		go GetBuildingPermits(db)
-		go GetTaxiTrips(db)
-		go GetCovidDetails(db)
-		go GetCCVIDetails(db)
-		log.Print("finished daily update, waiting for next run in 24 hours")
+		summary, err := runCollectorCycle(context.Background(), db, profileCollectorJobs(), collectorConcurrency())
+		publishCycleSummary(summary)
+		if err != nil {
+			log.Printf("daily update finished with errors:\n%v", err)
+		}
 	}
 
+	election := &leaderElection{db: db}
+
 	if runOnce {
+		election.waitForLeadership(context.Background())
 		runCollectors()
 		log.Print("RUN_ONCE enabled; collectors will remain idle until Cloud Run scales down the instance")
 		select {}
 	}
 
-	ticker := time.NewTicker(24 * time.Hour)
-	defer ticker.Stop()
-
 	for {
+		election.waitForLeadership(context.Background())
+		started := time.Now()
 		runCollectors()
-		<-ticker.C
+		next := started.Add(shared.CycleInterval())
+		log.Printf("finished update, waiting for next run at %s", next.Format(time.RFC3339))
+		time.Sleep(time.Until(next))
 	}
 }
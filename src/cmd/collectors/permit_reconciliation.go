@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// permitReconciliationsTable records the outcome of every permit_reconciliation run.
+const permitReconciliationsTable = "permit_reconciliations"
+
+// permitWindowRecord is the part of a building permit needed to reconcile the pulled window.
+type permitWindowRecord struct {
+	Id         string `json:"id"`
+	Issue_date string `json:"issue_date"`
+}
+
+// ReconcileBuildingPermits compares the permit ids the API currently returns in the pulled window with
+// the stored permits issued within it, and flags stored permits the API no longer returns by setting
+// their vanished_at instead of deleting them. The window holds the permits issued after the oldest issue
+// date pulled; that date is left out because the window may end partway through it. Permits stored
+// outside Postgres are not reconciled.
+func ReconcileBuildingPermits(ctx context.Context, db *sql.DB) {
+	fmt.Println("ReconcileBuildingPermits: Comparing stored building permits with the API window")
+
+	table := datasets.BuildingPermitsDataset.Table
+	if shared.StorageBackendFor(table) != shared.BackendPostgres {
+		fmt.Printf("Skipping permit reconciliation: %s is not stored in Postgres\n", table)
+		return
+	}
+
+	var (
+		ids    []string
+		oldest string
+	)
+	limit := shared.CollectorLimit(datasets.BuildingPermitsDataset, buildingPermitsLimit)
+	_, err := shared.FetchSODAChunks(ctx, buildingPermitsQuery("id", "issue_date"), limit, shared.ChunkSize(datasets.BuildingPermitsDataset), shared.FetchFastAPI,
+		func(chunk int, records []permitWindowRecord) error {
+			for _, record := range records {
+				issued, _, _ := strings.Cut(record.Issue_date, "T")
+				if record.Id == "" || issued == "" {
+					continue
+				}
+				ids = append(ids, record.Id)
+				if oldest == "" || issued < oldest {
+					oldest = issued
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		panic(err)
+	}
+	if len(ids) == 0 {
+		fmt.Println("Skipping permit reconciliation: the API returned no permits")
+		return
+	}
+
+	if err := ensurePermitReconciliationsTable(ctx, db); err != nil {
+		panic(err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		panic(fmt.Errorf("failed to start permit reconciliation transaction: %w", err))
+	}
+	defer tx.Rollback()
+
+	var stored int
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %q WHERE "issue_date" > $1`, table), oldest).Scan(&stored); err != nil {
+		panic(fmt.Errorf("failed to count stored permits in the window: %w", err))
+	}
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`UPDATE %q
+		SET vanished_at = NOW()
+		WHERE "vanished_at" IS NULL
+			AND "issue_date" > $1
+			AND NOT ("id" = ANY($2))
+		RETURNING "id"`, table), oldest, pq.Array(ids))
+	if err != nil {
+		panic(fmt.Errorf("failed to flag vanished permits: %w", err))
+	}
+	var vanished []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			panic(fmt.Errorf("failed to scan vanished permit: %w", err))
+		}
+		vanished = append(vanished, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		panic(fmt.Errorf("error while flagging vanished permits: %w", err))
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %q ("reconciled_at", "window_after", "api_permits", "stored_permits", "vanished", "build_version")
+		VALUES (NOW(), $1, $2, $3, $4, $5)`, permitReconciliationsTable), oldest, len(ids), stored, len(vanished), shared.Version()); err != nil {
+		panic(fmt.Errorf("failed to record permit reconciliation: %w", err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		panic(fmt.Errorf("failed to commit permit reconciliation: %w", err))
+	}
+
+	fmt.Printf("Permit reconciliation: %d permits in the API window, %d stored permits issued after %s, %d newly vanished\n",
+		len(ids), stored, oldest, len(vanished))
+	if len(vanished) > 0 {
+		fmt.Printf("Permits no longer returned by the API (flagged in %s.vanished_at): %s\n", table, strings.Join(vanished, ", "))
+	}
+}
+
+// ensurePermitReconciliationsTable creates permit_reconciliations on the first reconciliation.
+func ensurePermitReconciliationsTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+		"id" SERIAL PRIMARY KEY,
+		"reconciled_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+		"window_after" DATE NOT NULL,
+		"api_permits" INTEGER NOT NULL,
+		"stored_permits" INTEGER NOT NULL,
+		"vanished" INTEGER NOT NULL,
+		"build_version" VARCHAR(255) NOT NULL
+	)`, permitReconciliationsTable))
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", permitReconciliationsTable, err)
+	}
+	return nil
+}
@@ -1,132 +1,110 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
-	"strconv"
 
 	"database/sql"
-	"encoding/json"
 
 	_ "github.com/lib/pq"
 
+	"github.com/ahbreck/Chicago_BI/datasets"
 	"github.com/ahbreck/Chicago_BI/shared"
 )
 
-type BuildingPermitsJsonRecords []struct {
-	Id            string `json:"id"`
-	Permit_       string `json:"permit_"`
-	Permit_type   string `json:"permit_type"`
-	Issue_date    string `json:"issue_date"`
-	Street_number string `json:"street_number"`
-	Street_name   string `json:"street_name"`
-	Latitude      string `json:"latitude"`
-	Longitude     string `json:"longitude"`
-	//Location       string `json:"location"`
-	Community_area string `json:"community_area"`
-	Census_tract   string `json:"census_tract"`
-}
-
-func GetBuildingPermits(db *sql.DB) {
+func GetBuildingPermits(ctx context.Context, db *sql.DB) {
 	fmt.Println("GetBuildingPermits: Collecting Building Permits Data")
 
-	drop_table := `drop table if exists building_permits`
-	_, err := db.Exec(drop_table)
+	// Permits without coordinates are geocoded from their street address when geocoding is enabled.
+	useGeocoding := os.Getenv("USE_GEOCODING") == "true"
+
+	store, err := shared.StoreForTable(db, datasets.BuildingPermitsDataset.Table)
 	if err != nil {
 		panic(err)
 	}
 
-	create_table := `CREATE TABLE IF NOT EXISTS "building_permits" (
-		"id" VARCHAR(255) PRIMARY KEY,
-		"permit_id" VARCHAR(255) UNIQUE,
-		"permit_type" VARCHAR(255),
-		"issue_date"      DATE,
-		"street_number"      VARCHAR(255),
-		"street_name"      VARCHAR(255),
-		"latitude"      FLOAT8,
-		"longitude"      FLOAT8,
-		"community_area" VARCHAR(2),
-		"census_tract" VARCHAR(255)
-	);`
-
-	_, _err := db.Exec(create_table)
-	if _err != nil {
-		panic(_err)
-	}
-
-	fmt.Println("Created Table for Building Permits")
-
-	var url = "https://data.cityofchicago.org/resource/building-permits.json?$select=id,permit_,permit_type,issue_date,street_number,street_name,latitude,longitude,community_area,census_tract&$limit=1000"
-
-	res, err := shared.FetchFastAPI(url)
-	if err != nil {
+	// Postgres upserts permits and keeps those that drop out of the pulled window, for
+	// permit_reconciliation to check. BigQuery appends, so it is still reloaded from scratch.
+	if shared.StorageBackendFor(datasets.BuildingPermitsDataset.Table) == shared.BackendPostgres {
+		if err := store.Ensure(ctx, datasets.BuildingPermitsDataset); err != nil {
+			panic(err)
+		}
+		if err := addPermitColumns(ctx, db); err != nil {
+			panic(err)
+		}
+	} else if err := store.Reset(ctx, datasets.BuildingPermitsDataset); err != nil {
 		panic(err)
 	}
 
-	// adding the below statement to ensure closure in case of early return
-	defer res.Body.Close()
-
-	fmt.Println("Received data from SODA REST API for Building Permits")
+	fmt.Printf("Created Table for Building Permits in %s\n", store.Name())
 
-	body, _ := ioutil.ReadAll(res.Body)
-	var building_data_list BuildingPermitsJsonRecords
-	json.Unmarshal(body, &building_data_list)
+	query := buildingPermitsQuery(datasets.BuildingPermitsSource.Select...)
 
-	s := fmt.Sprintf("\n\n Building Permits: number of SODA records received = %d\n\n", len(building_data_list))
-	io.WriteString(os.Stdout, s)
+	var insertedCount, skippedCount int
+	limit := shared.CollectorLimit(datasets.BuildingPermitsDataset, buildingPermitsLimit)
+	decodeStats, err := shared.FetchSODAChunks(ctx, query, limit, shared.ChunkSize(datasets.BuildingPermitsDataset), shared.FetchFastAPI,
+		func(chunk int, building_data_list []datasets.BuildingPermitsJsonRecord) error {
+			s := fmt.Sprintf("\n\n Building Permits: number of SODA records received = %d\n\n", len(building_data_list))
+			io.WriteString(os.Stdout, s)
 
-	insertedCount := 0
-	skippedCount := 0
+			if archived, err := shared.ArchiveRawChunk(ctx, "building_permits", chunk, building_data_list); err != nil {
+				shared.NoteError(ctx, "unable to archive raw building permit records: %v", err)
+			} else if archived != "" {
+				fmt.Printf("Archived raw building permit records to %s\n", archived)
+			}
 
-	for _, record := range building_data_list {
+			inserted, skipped, err := datasets.LoadBuildingPermits(ctx, store, building_data_list, useGeocoding)
+			insertedCount += inserted
+			skippedCount += skipped
+			return err
+		})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Building Permits decode stats: %s\n", decodeStats)
 
-		// We will execute defensive coding to check for messy/dirty/missing data values
-		// Any record that has messy/dirty/missing data we don't enter it in the data lake/table
+	shared.CountLoaded(ctx, insertedCount, skippedCount)
 
-		if record.Id == "" ||
-			record.Permit_ == "" ||
-			record.Permit_type == "" ||
-			record.Issue_date == "" ||
-			record.Street_number == "" ||
-			record.Street_name == "" ||
-			record.Latitude == "" ||
-			record.Longitude == "" ||
-			//.Location == "" ||
-			record.Community_area == "" ||
-			record.Census_tract == "" {
-			//fmt.Printf("Skipping record due to missing fields: %+v\n", record)
-			skippedCount++
-			continue
-		}
+	if err := shared.RecordTableRefresh(db, "building_permits", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to record building_permits refresh: %v", err)
+	}
 
-		sql := `INSERT INTO building_permits ("id", "permit_id", "permit_type", "issue_date", "street_number", "street_name", "latitude", "longitude", "community_area", "census_tract")
-		values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
-
-		lat, _ := strconv.ParseFloat(record.Latitude, 64)
-		lon, _ := strconv.ParseFloat(record.Longitude, 64)
-
-		_, err := db.Exec(
-			sql,
-			record.Id,
-			record.Permit_,
-			record.Permit_type,
-			record.Issue_date,
-			record.Street_number,
-			record.Street_name,
-			lat,
-			lon,
-			//record.Location,
-			record.Community_area,
-			record.Census_tract)
-
-		if err != nil {
-			panic(err)
-		}
-		insertedCount++
+	if action, err := shared.MaintainTable(ctx, db, "building_permits", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to run maintenance on building_permits: %v", err)
+	} else if action != "" {
+		fmt.Printf("Ran %s on building_permits\n", action)
+	}
+}
 
+// buildingPermitsLimit is how many of the most recently issued permits are pulled, unless
+// COLLECTOR_LIMIT_BUILDING_PERMITS says otherwise.
+const buildingPermitsLimit = 1000
+
+// buildingPermitsQuery selects columns of the pulled window of building permits: the most recently issued
+// permits, ordered so the window is the same whichever columns are selected.
+func buildingPermitsQuery(columns ...string) shared.SodaQuery {
+	return shared.SodaQuery{
+		Resource: "ydr8-5enu",
+		Select:   columns,
+		Order:    "issue_date DESC, id",
 	}
+}
 
-	fmt.Printf("Completed Inserting %d rows into the Building Permits Table. Skipped %d records due to data quality issues.\n", insertedCount, skippedCount)
+// addPermitColumns adds the columns tracking when a permit was last pulled and when it vanished from the
+// window, the permit's total fee, and its application date, ward, and processing days, to a building_permits
+// table created before they existed.
+func addPermitColumns(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %q
+		ADD COLUMN IF NOT EXISTS "last_seen_at" TIMESTAMP WITH TIME ZONE,
+		ADD COLUMN IF NOT EXISTS "vanished_at" TIMESTAMP WITH TIME ZONE,
+		ADD COLUMN IF NOT EXISTS "total_fee" FLOAT8,
+		ADD COLUMN IF NOT EXISTS "application_start_date" DATE,
+		ADD COLUMN IF NOT EXISTS "ward" VARCHAR(2),
+		ADD COLUMN IF NOT EXISTS "processing_days" INTEGER`, datasets.BuildingPermitsDataset.Table))
+	if err != nil {
+		return fmt.Errorf("failed to add columns to %s: %w", datasets.BuildingPermitsDataset.Table, err)
+	}
+	return nil
 }
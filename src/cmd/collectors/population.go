@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	// acsYearEnvKey selects the ACS 5-year vintage. The default, 2019, is the last one published on the 2010
+	// census tracts of census_tract_boundaries, which community area populations are summed from.
+	acsYearEnvKey  = "ACS_YEAR"
+	defaultACSYear = 2019
+
+	// censusAPIKeyEnvKey is optional; the Census API serves a few hundred requests a day without a key.
+	censusAPIKeyEnvKey = "CENSUS_API_KEY"
+
+	censusAPIURL = "https://api.census.gov/data"
+	// acsTotalPopulation is the estimate of ACS table B01003, total population.
+	acsTotalPopulation = "B01003_001E"
+	// chicagoZipPrefix keeps the ZIP Code Tabulation Areas of northern Illinois out of the nationwide list,
+	// which covers every Chicago ZIP code (606xx, 60707, and 60827).
+	chicagoZipPrefix = "60"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func GetPopulation(ctx context.Context, db *sql.DB) {
+	fmt.Println("GetPopulation: Collecting ACS population by ZIP code and census tract")
+
+	store, err := shared.StoreForTable(db, datasets.PopulationDataset.Table)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := store.Reset(ctx, datasets.PopulationDataset); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Created Table for population in %s\n", store.Name())
+
+	year := acsYear()
+	zipRows, err := fetchACSPopulation(ctx, year, url.Values{"for": {"zip code tabulation area:*"}})
+	if err != nil {
+		panic(err)
+	}
+	// Cook County, Illinois.
+	tractRows, err := fetchACSPopulation(ctx, year, url.Values{"for": {"tract:*"}, "in": {"state:17 county:031"}})
+	if err != nil {
+		panic(err)
+	}
+
+	var population_data_list datasets.PopulationRecords
+	for _, row := range zipRows {
+		zip := row["zip code tabulation area"]
+		if !strings.HasPrefix(zip, chicagoZipPrefix) {
+			continue
+		}
+		population_data_list = append(population_data_list, datasets.PopulationRecord{
+			Geography_type: datasets.PopulationZipCode,
+			Geography_id:   zip,
+			Population:     row[acsTotalPopulation],
+			ACS_year:       strconv.Itoa(year),
+		})
+	}
+	for _, row := range tractRows {
+		population_data_list = append(population_data_list, datasets.PopulationRecord{
+			Geography_type: datasets.PopulationCensusTract,
+			Geography_id:   row["state"] + row["county"] + row["tract"],
+			Population:     row[acsTotalPopulation],
+			ACS_year:       strconv.Itoa(year),
+		})
+	}
+	fmt.Printf("\n\n Number of ACS %d population records received = %d\n\n", year, len(population_data_list))
+	shared.CountFetched(ctx, len(population_data_list))
+
+	if archived, err := shared.ArchiveRawRecords(ctx, "population", population_data_list); err != nil {
+		shared.NoteError(ctx, "unable to archive raw population records: %v", err)
+	} else if archived != "" {
+		fmt.Printf("Archived raw population records to %s\n", archived)
+	}
+
+	insertedCount, skippedCount, err := datasets.LoadPopulation(ctx, store, population_data_list)
+	if err != nil {
+		panic(err)
+	}
+
+	if shared.StorageBackendFor(datasets.PopulationDataset.Table) == shared.BackendPostgres {
+		areas, err := sumCommunityAreaPopulation(ctx, db)
+		if err != nil {
+			shared.NoteError(ctx, "unable to sum community area population: %v", err)
+		} else {
+			fmt.Printf("Summed the population of %d community areas from census tracts\n", areas)
+			insertedCount += areas
+		}
+	}
+
+	shared.CountLoaded(ctx, insertedCount, skippedCount)
+
+	if err := shared.RecordTableRefresh(db, datasets.PopulationDataset.Table, insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to record population refresh: %v", err)
+	}
+}
+
+// acsYear reads ACS_YEAR, falling back to defaultACSYear.
+func acsYear() int {
+	raw := strings.TrimSpace(os.Getenv(acsYearEnvKey))
+	if raw == "" {
+		return defaultACSYear
+	}
+	year, err := strconv.Atoi(raw)
+	if err != nil || year < 2009 {
+		log.Printf("invalid %s value %q; defaulting to %d", acsYearEnvKey, raw, defaultACSYear)
+		return defaultACSYear
+	}
+	return year
+}
+
+// fetchACSPopulation requests the total population of the geographies selected by geography from the ACS
+// 5-year estimates of year. The Census API answers with an array of string arrays whose first row names the
+// columns; each row is returned keyed by those names.
+func fetchACSPopulation(ctx context.Context, year int, geography url.Values) ([]map[string]string, error) {
+	params := url.Values{"get": {acsTotalPopulation}}
+	for key, values := range geography {
+		params[key] = values
+	}
+	if key := strings.TrimSpace(os.Getenv(censusAPIKeyEnvKey)); key != "" {
+		params.Set("key", key)
+	}
+
+	res, err := shared.FetchFastAPI(ctx, fmt.Sprintf("%s/%d/acs/acs5?%s", censusAPIURL, year, params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Census API population for %s returned status %d", geography.Get("for"), res.StatusCode)
+	}
+
+	var table [][]string
+	if err := json.NewDecoder(res.Body).Decode(&table); err != nil {
+		return nil, fmt.Errorf("failed to decode Census API population for %s: %w", geography.Get("for"), err)
+	}
+	if len(table) == 0 {
+		return nil, nil
+	}
+
+	header := table[0]
+	rows := make([]map[string]string, 0, len(table)-1)
+	for _, values := range table[1:] {
+		row := make(map[string]string, len(header))
+		for i, name := range header {
+			if i < len(values) {
+				row[name] = values[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// sumCommunityAreaPopulation adds a community_area row to population for every community area, summing
+// the census tracts whose interior point falls inside it. Community areas follow tract lines closely, so a
+// tract is assigned whole. It needs the tract and community area boundaries the collectors load at startup
+// and returns how many community areas were written.
+func sumCommunityAreaPopulation(ctx context.Context, db *sql.DB) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start community area population transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := shared.UsePostGIS(tx); err != nil {
+		return 0, err
+	}
+
+	tracts, areas := shared.CensusTractBoundaries, shared.CommunityAreaBoundaries
+	result, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %q ("geography_type", "geography_id", "population", "acs_year", "ingest_run_id")
+		SELECT $1, a.%q, SUM(p."population"), MAX(p."acs_year"), $3
+		FROM %q p
+		JOIN %q t ON t.%q = p."geography_id"
+		JOIN %q a ON ST_Within(ST_PointOnSurface(t."geom"), a."geom")
+		WHERE p."geography_type" = $2
+		GROUP BY a.%q
+		ON CONFLICT ("geography_type", "geography_id") DO UPDATE
+		SET population = EXCLUDED.population,
+			acs_year = EXCLUDED.acs_year,
+			ingest_run_id = EXCLUDED.ingest_run_id`,
+		datasets.PopulationDataset.Table, areas.KeyColumn,
+		datasets.PopulationDataset.Table,
+		tracts.Table, tracts.KeyColumn,
+		areas.Table,
+		areas.KeyColumn),
+		datasets.PopulationCommunityArea, datasets.PopulationCensusTract, shared.IngestRunID(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum census tracts into community areas: %w", err)
+	}
+	written, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count community area population rows: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit community area population: %w", err)
+	}
+	return int(written), nil
+}
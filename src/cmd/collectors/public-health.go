@@ -1,106 +1,91 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
+	"time"
 
 	_ "github.com/lib/pq"
 
+	"github.com/ahbreck/Chicago_BI/datasets"
 	"github.com/ahbreck/Chicago_BI/shared"
 )
 
-type UnemploymentJsonRecords []struct {
-	Community_area      string  `json:"community_area"`
-	Below_poverty_level float64 `json:"below_poverty_level,string"`
-	Unemployment        float64 `json:"unemployment,string"`
-	Per_capita_income   float64 `json:"per_capita_income,string"`
-}
-
 /////////////////////////////////////////////////////////////////////////////////////////////////////////////
 /////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func GetUnemploymentRates(db *sql.DB) {
+func GetUnemploymentRates(ctx context.Context, db *sql.DB) {
 	fmt.Println("GetUnemploymentRates: Collecting Unemployment Rates Data")
 
-	drop_table := `drop table if exists public_health`
-	_, err := db.Exec(drop_table)
+	store, err := shared.StoreForTable(db, datasets.PublicHealthDataset.Table)
 	if err != nil {
 		panic(err)
 	}
 
-	create_table := `CREATE TABLE IF NOT EXISTS "public_health" (
-		"community_area" VARCHAR(2) PRIMARY KEY,
-		"below_poverty_level" FLOAT8,
-		"unemployment" FLOAT8,
-		"per_capita_income" FLOAT8
-	);`
+	// Keep the previous pull so upstream revisions show up in the table diff recorded after the reload.
+	preserved, err := shared.PreserveTable(ctx, db, datasets.PublicHealthDataset)
+	if err != nil {
+		shared.NoteError(ctx, "unable to preserve previous public_health pull: %v", err)
+	}
 
-	_, _err := db.Exec(create_table)
-	if _err != nil {
-		panic(_err)
+	if err := store.Reset(ctx, datasets.PublicHealthDataset); err != nil {
+		panic(err)
 	}
 
-	fmt.Println("Created Table for Public Health Data")
+	fmt.Printf("Created Table for Public Health Data in %s\n", store.Name())
 
 	// There are 77 known community areas in the data set
 	// So, set limit to 100.
-	var url = "https://data.cityofchicago.org/resource/iqnk-2tcu.json?$select=community_area,below_poverty_level,unemployment,per_capita_income&$limit=100"
+	query := shared.SodaQuery{
+		Resource: "iqnk-2tcu",
+		Select:   datasets.PublicHealthSource.Select,
+	}
 
-	res, err := shared.FetchFastAPI(url)
+	var insertedCount, skippedCount int
+	vintage := datasets.NewPublicHealthVintage(time.Now())
+	limit := shared.CollectorLimit(datasets.PublicHealthDataset, 100)
+	decodeStats, err := shared.FetchSODAChunks(ctx, query, limit, shared.ChunkSize(datasets.PublicHealthDataset), shared.FetchFastAPI,
+		func(chunk int, unemployment_data_list []datasets.UnemploymentJsonRecord) error {
+			s := fmt.Sprintf("\n\n Community Areas number of SODA records received = %d\n\n", len(unemployment_data_list))
+			io.WriteString(os.Stdout, s)
+
+			if archived, err := shared.ArchiveRawChunk(ctx, "public_health", chunk, unemployment_data_list); err != nil {
+				shared.NoteError(ctx, "unable to archive raw public health records: %v", err)
+			} else if archived != "" {
+				fmt.Printf("Archived raw public health records to %s\n", archived)
+			}
+
+			inserted, skipped, err := datasets.LoadPublicHealth(ctx, store, unemployment_data_list, vintage)
+			insertedCount += inserted
+			skippedCount += skipped
+			return err
+		})
 	if err != nil {
 		panic(err)
 	}
-	// adding the below statement to ensure closure in case of early return
-	defer res.Body.Close()
-
-	fmt.Println("Received data from SODA REST API for Public Health")
-
-	body, _ := ioutil.ReadAll(res.Body)
-	var unemployment_data_list UnemploymentJsonRecords
-	json.Unmarshal(body, &unemployment_data_list)
+	fmt.Printf("Public Health decode stats: %s\n", decodeStats)
 
-	s := fmt.Sprintf("\n\n Community Areas number of SODA records received = %d\n\n", len(unemployment_data_list))
-	io.WriteString(os.Stdout, s)
+	shared.CountLoaded(ctx, insertedCount, skippedCount)
 
-	sql := `INSERT INTO public_health ("community_area", "below_poverty_level", "unemployment", "per_capita_income")
-			VALUES ($1, $2, $3, $4)
-			ON CONFLICT ("community_area") DO UPDATE 
-			SET below_poverty_level = EXCLUDED.below_poverty_level,
-				unemployment = EXCLUDED.unemployment,
-				per_capita_income = EXCLUDED.per_capita_income;`
-
-	insertedCount := 0
-	skippedCount := 0
-
-	for _, record := range unemployment_data_list {
-
-		// We will execute defensive coding to check for messy/dirty/missing data values
-		// Any record that has messy/dirty/missing data we don't enter it in the data lake/table
+	if err := shared.RecordTableRefresh(db, "public_health", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to record public_health refresh: %v", err)
+	}
 
-		if record.Community_area == "" ||
-			record.Below_poverty_level < 0 ||
-			record.Unemployment < 0 ||
-			record.Per_capita_income < 0 {
-			skippedCount++
-			continue
+	if preserved {
+		if diff, err := shared.RecordTableDiff(ctx, db, datasets.PublicHealthDataset); err != nil {
+			shared.NoteError(ctx, "unable to diff public_health against the previous pull: %v", err)
+		} else {
+			fmt.Printf("public_health changes since the previous pull: %s\n", diff)
 		}
+	}
 
-		_, err = db.Exec(sql,
-			record.Community_area,
-			record.Below_poverty_level,
-			record.Unemployment,
-			record.Per_capita_income,
-		)
-
-		if err != nil {
-			panic(err)
-		}
-		insertedCount++
+	if action, err := shared.MaintainTable(ctx, db, "public_health", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to run maintenance on public_health: %v", err)
+	} else if action != "" {
+		fmt.Printf("Ran %s on public_health\n", action)
 	}
-	fmt.Printf("Completed inserting %d rows into the public_health table. Skipped %d records due to data quality issues.\n", insertedCount, skippedCount)
 
 }
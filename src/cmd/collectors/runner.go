@@ -0,0 +1,575 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	defaultCollectorTimeoutMinutes = 30
+	collectorTimeoutEnvKey         = "COLLECTOR_TIMEOUT_MINUTES"
+
+	defaultCollectorConcurrency = 3
+	collectorConcurrencyEnvKey  = "COLLECTOR_CONCURRENCY"
+
+	collectorStaggerEnvKey     = "COLLECTOR_STAGGER_MINUTES"
+	collectorStartOffsetEnvKey = "COLLECTOR_START_OFFSET_MINUTES"
+)
+
+// collectorJob is a single dataset pull run by the collectors cycle.
+type collectorJob struct {
+	name string
+	run  func(ctx context.Context, db *sql.DB)
+	// after lists jobs that must finish successfully before this one starts.
+	after []string
+	// covidSource is the COVID_SOURCE the job loads the weekly COVID statistics from, if any. Only the job of
+	// the configured source runs.
+	covidSource string
+	// dataset describes the source the job ingests in the datasets registry. Jobs that only rework other
+	// jobs' tables leave it empty and are not registered.
+	dataset collectorDataset
+}
+
+// collectorDataset is what the datasets registry says about the source of a collector job.
+type collectorDataset struct {
+	title string
+	// resources are the Chicago Socrata identifiers the job reads, translated for other city profiles.
+	resources   []string
+	owner       string
+	description string
+	// configured returns the identifier of a source chosen in configuration, read when the registry is
+	// written.
+	configured func() string
+}
+
+// collectorJobs lists the jobs of one collection cycle. Jobs run concurrently unless ordered by after.
+var collectorJobs = []collectorJob{
+	{name: "public_health", run: GetUnemploymentRates, dataset: collectorDataset{
+		title:       "Public health statistics",
+		resources:   []string{"iqnk-2tcu"},
+		owner:       "Chicago Department of Public Health",
+		description: "Unemployment, below poverty level, and per capita income by community area.",
+	}},
+	{name: "building_permits", run: GetBuildingPermits, dataset: collectorDataset{
+		title:       "Building permits",
+		resources:   []string{"ydr8-5enu"},
+		owner:       "Chicago Department of Buildings",
+		description: "The most recently issued building permits, with their fees, ZIP code, and community area.",
+	}},
+	{name: "permit_reconciliation", run: ReconcileBuildingPermits, after: []string{"building_permits"}},
+	{name: "taxi_trips", run: GetTaxiTrips, dataset: collectorDataset{
+		title:       "Taxi and rideshare trips",
+		resources:   []string{taxiTripsAPICode, tnpTripsAPICode},
+		owner:       "Chicago Department of Business Affairs and Consumer Protection",
+		description: "Taxi and transportation network provider trips with their pickup and dropoff community areas and ZIP codes.",
+	}},
+	{name: "covid", run: GetCovidDetails, covidSource: shared.CovidSourceWeekly, dataset: collectorDataset{
+		title:       "COVID-19 cases by ZIP code",
+		resources:   []string{"yhhz-zm2v"},
+		owner:       "Chicago Department of Public Health",
+		description: "Weekly COVID-19 cases, tests, and deaths by ZIP code of residence.",
+	}},
+	{name: "covid_respiratory", run: GetCovidRespiratory, covidSource: shared.CovidSourceRespiratory, dataset: collectorDataset{
+		title:       "Respiratory virus surveillance by ZIP code",
+		owner:       "Chicago Department of Public Health",
+		description: "Weekly COVID-19 case rates and test positivity by ZIP code from the dataset named by COVID_RESPIRATORY_RESOURCE, succeeding the COVID-19 cases by ZIP code dataset.",
+		configured:  shared.CovidRespiratoryResource,
+	}},
+	{name: "ccvi", run: GetCCVIDetails, dataset: collectorDataset{
+		title:       "COVID-19 Community Vulnerability Index",
+		resources:   []string{"xhc6-88s9"},
+		owner:       "Chicago Department of Public Health",
+		description: "CCVI scores and categories by ZIP code and community area.",
+	}},
+	{name: "weather", run: GetWeatherDetails, dataset: collectorDataset{
+		title:       "Daily weather",
+		owner:       "NOAA National Centers for Environmental Information",
+		description: "Daily observations of the NOAA GHCN-Daily station WEATHER_STATION (default Chicago O'Hare).",
+	}},
+	{name: "cta_ridership", run: GetCTARidership, dataset: collectorDataset{
+		title:       "CTA ridership",
+		resources:   []string{"5neh-572f", "jyb9-n7fm", "8pix-ypme"},
+		owner:       "Chicago Transit Authority",
+		description: "Daily 'L' station entries and bus route boardings, with station locations and ZIP codes.",
+	}},
+	{name: "vacant_buildings", run: GetVacantBuildings, dataset: collectorDataset{
+		title:       "Vacant and abandoned buildings",
+		resources:   []string{"v6vf-nfxy"},
+		owner:       "Chicago Department of Buildings",
+		description: "Violations issued for vacant and abandoned buildings.",
+	}},
+	{name: "food_inspections", run: GetFoodInspections, dataset: collectorDataset{
+		title:       "Food inspections and business licenses",
+		resources:   []string{"4ijn-s7e5", "r5kz-chrr"},
+		owner:       "Chicago Department of Public Health; Department of Business Affairs and Consumer Protection",
+		description: "Food establishment inspections and the retail food business licenses they are linked to.",
+	}},
+	{name: "population", run: GetPopulation, dataset: collectorDataset{
+		title:       "ACS population",
+		owner:       "U.S. Census Bureau",
+		description: "ACS 5-year total population by ZIP code, census tract, and community area from the Census API.",
+	}},
+}
+
+// collectorDatasets returns the datasets registry entries of the jobs the city profile runs.
+func collectorDatasets() []shared.DatasetEntry {
+	cadence := fmt.Sprintf("every %d hours", int(shared.CycleInterval().Hours()))
+	var entries []shared.DatasetEntry
+	for _, job := range profileCollectorJobs() {
+		if job.dataset.title == "" {
+			continue
+		}
+		resources := make([]string, len(job.dataset.resources))
+		for i, resource := range job.dataset.resources {
+			resources[i], _ = shared.CitySODAResource(resource)
+		}
+		if job.dataset.configured != nil {
+			if resource := job.dataset.configured(); resource != "" {
+				resources = append(resources, resource)
+			}
+		}
+		entries = append(entries, shared.DatasetEntry{
+			ID:           job.name,
+			Name:         job.dataset.title,
+			SODAResource: strings.Join(resources, ","),
+			Cadence:      cadence,
+			Owner:        job.dataset.owner,
+			Description:  job.dataset.description,
+		})
+	}
+	return entries
+}
+
+// runCollectorCycle runs jobs concurrently, at most concurrency at a time, starting each job only once its
+// start offset (see collectorStartOffset) has passed and the jobs it depends on have succeeded. Jobs whose
+// dependencies failed are skipped. Every failure of the
+// cycle is returned joined together rather than stopping at the first one. The jobs share one
+// MAX_RECORDS_PER_CYCLE record budget and one request id, which links their ingest runs.
+func runCollectorCycle(ctx context.Context, db *sql.DB, jobs []collectorJob, concurrency int) (cycleSummary, error) {
+	summary := cycleSummary{RequestID: shared.NewRequestID(), StartedAt: time.Now()}
+	ctx = shared.WithRecordBudget(shared.WithRequestID(ctx, summary.RequestID), shared.NewRecordBudget(shared.MaxRecordsPerCycle()))
+
+	ordered, err := orderCollectorJobs(jobs)
+	if err != nil {
+		return summary, err
+	}
+	summary.Jobs = make([]jobSummary, len(ordered))
+
+	done := make(map[string]chan struct{}, len(ordered))
+	for _, job := range ordered {
+		done[job.name] = make(chan struct{})
+	}
+
+	var (
+		mu     sync.Mutex
+		failed = make(map[string]bool)
+		errs   []error
+	)
+	fail := func(name string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed[name] = true
+		errs = append(errs, err)
+	}
+
+	// A job takes one of the concurrency slots only after waiting for its offset and its dependencies, so a
+	// waiting job never holds a slot a dependency still needs.
+	slots := make(chan struct{}, concurrency)
+	var g errgroup.Group
+	for i, job := range ordered {
+		offset := collectorStartOffset(job.name, i)
+		g.Go(func() error {
+			defer close(done[job.name])
+
+			if offset > 0 {
+				log.Printf("collector %s starts %s into the cycle", job.name, offset)
+				select {
+				case <-time.After(time.Until(summary.StartedAt.Add(offset))):
+				case <-ctx.Done():
+				}
+			}
+
+			for _, dep := range job.after {
+				<-done[dep]
+				mu.Lock()
+				depFailed := failed[dep]
+				mu.Unlock()
+				if depFailed {
+					err := fmt.Errorf("collector %s skipped: dependency %s failed", job.name, dep)
+					summary.Jobs[i] = jobSummary{Job: job.name, Status: jobSkipped, JobCounts: shared.JobCounts{Errors: 1}, Error: err.Error()}
+					fail(job.name, err)
+					return nil
+				}
+			}
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			result, err := runCollectorJob(ctx, db, job, collectorTimeout(job.name))
+			summary.Jobs[i] = result
+			if err != nil {
+				fail(job.name, err)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	summary.FinishedAt = time.Now()
+	return summary, errors.Join(errs...)
+}
+
+// unpublishedJobsLogged logs the jobs left out for datasets the city profile does not publish once, since
+// the profile does not change while the service runs.
+var unpublishedJobsLogged sync.Once
+
+// profileCollectorJobs returns the collectorJobs the city profile runs, with the covid job of COVID_SOURCE.
+// Jobs reading a dataset the profile does not publish are left out rather than query Chicago's dataset on
+// the profile's portal.
+func profileCollectorJobs() []collectorJob {
+	covidSource := shared.CovidSource()
+	var (
+		jobs        []collectorJob
+		unpublished []string
+	)
+	for _, job := range collectorJobs {
+		if job.covidSource != "" && job.covidSource != covidSource {
+			continue
+		}
+		if !shared.RunsCollector(job.name) {
+			continue
+		}
+		if resource, ok := unpublishedResource(job); ok {
+			unpublished = append(unpublished, fmt.Sprintf("%s (dataset %s)", job.name, resource))
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	if len(unpublished) > 0 {
+		unpublishedJobsLogged.Do(func() {
+			log.Printf("city profile does not publish the datasets of these collectors, which are skipped: %s", strings.Join(unpublished, ", "))
+		})
+	}
+	return jobs
+}
+
+// unpublishedResource returns the first Chicago dataset job reads that the city profile does not publish.
+func unpublishedResource(job collectorJob) (string, bool) {
+	for _, resource := range job.dataset.resources {
+		if _, ok := shared.CitySODAResource(resource); !ok {
+			return resource, true
+		}
+	}
+	return "", false
+}
+
+func findCollectorJob(name string) (collectorJob, bool) {
+	for _, job := range profileCollectorJobs() {
+		if job.name == name {
+			return job, true
+		}
+	}
+	return collectorJob{}, false
+}
+
+// runCollectorHandler runs the collector named by the collector query parameter and waits for it to finish.
+// Dependencies are not run first; the collector reads whatever its dependencies last loaded. The run gets a
+// MAX_RECORDS_PER_CYCLE budget of its own. A request with an Idempotency-Key header already used within the
+// last day does not run the collector again but answers with the run the key started. The request id, from
+// X-Request-Id or Cloud Run's trace header, is echoed in the response and recorded on the ingest run. A
+// collector already running here or on another instance is answered with 409.
+func runCollectorHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		requestID := shared.RequestIDFromHeader(r)
+		w.Header().Set(shared.RequestIDHeader, requestID)
+
+		name := r.URL.Query().Get("collector")
+		job, ok := findCollectorJob(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown collector %q", name), http.StatusNotFound)
+			return
+		}
+
+		timeout := collectorTimeout(job.name)
+		key := strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+		if key != "" {
+			// A keyed run outlives its timeout only when its instance died, as the watchdog abandons it shortly after.
+			run, claimed, err := claimCollectorRun(db, key, job.name, timeout+time.Minute)
+			if err != nil {
+				log.Printf("%v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !claimed {
+				log.Printf("collector %s: idempotency key %q already started a run at %s; not running it again", job.name, key, run.StartedAt.Format(time.RFC3339))
+				writeCollectorRun(w, run, job.name)
+				return
+			}
+		}
+
+		started := time.Now()
+		ctx := shared.WithRecordBudget(shared.WithRequestID(r.Context(), requestID), shared.NewRecordBudget(shared.MaxRecordsPerCycle()))
+		summary, err := runCollectorJob(ctx, db, job, timeout)
+		if key != "" {
+			var finishErr error
+			if summary.Status == "" {
+				// The collector was already running and never started, so a retry with the key may run it later.
+				finishErr = releaseCollectorRun(db, key)
+			} else {
+				detail := summary.Error
+				if detail == "" {
+					detail = strings.Join(summary.Degradations, "; ")
+				}
+				finishErr = finishCollectorRun(db, key, summary.Status, detail)
+			}
+			if finishErr != nil {
+				log.Printf("%v", finishErr)
+			}
+		}
+		if errors.Is(err, shared.ErrDBConflict) {
+			log.Printf("collector %s failed: %v", job.name, err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Printf("collector %s failed: %v", job.name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "collector %s finished in %s\n", job.name, time.Since(started).Round(time.Second))
+	}
+}
+
+// orderCollectorJobs sorts jobs so every job comes after its dependencies, keeping the declared order
+// otherwise. A job whose dependency is not among jobs, such as one a city profile does not run, is dropped
+// along with the jobs depending on it. Cycles are reported as errors.
+func orderCollectorJobs(jobs []collectorJob) ([]collectorJob, error) {
+	byName := make(map[string]collectorJob, len(jobs))
+	for _, job := range jobs {
+		byName[job.name] = job
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+		dropped  = 3
+	)
+	state := make(map[string]int, len(jobs))
+	ordered := make([]collectorJob, 0, len(jobs))
+
+	var visit func(job collectorJob) error
+	visit = func(job collectorJob) error {
+		switch state[job.name] {
+		case visited, dropped:
+			return nil
+		case visiting:
+			return fmt.Errorf("collector dependency cycle involving %s", job.name)
+		}
+
+		state[job.name] = visiting
+		for _, dep := range job.after {
+			depJob, ok := byName[dep]
+			if !ok {
+				log.Printf("collector %s skipped: its dependency %s is not run", job.name, dep)
+				state[job.name] = dropped
+				return nil
+			}
+			if err := visit(depJob); err != nil {
+				return err
+			}
+			if state[dep] == dropped {
+				log.Printf("collector %s skipped: its dependency %s is skipped", job.name, dep)
+				state[job.name] = dropped
+				return nil
+			}
+		}
+		state[job.name] = visited
+		ordered = append(ordered, job)
+		return nil
+	}
+
+	for _, job := range jobs {
+		if err := visit(job); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// collectorConcurrency returns how many collectors may run at once, from COLLECTOR_CONCURRENCY.
+func collectorConcurrency() int {
+	raw := strings.TrimSpace(os.Getenv(collectorConcurrencyEnvKey))
+	if raw == "" {
+		return defaultCollectorConcurrency
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		log.Printf("invalid %s value %q; defaulting to %d", collectorConcurrencyEnvKey, raw, defaultCollectorConcurrency)
+		return defaultCollectorConcurrency
+	}
+	return n
+}
+
+// collectorLocks keeps a collector from running twice at once, e.g. an on-demand run during the daily cycle.
+var collectorLocks sync.Map
+
+// runCollectorJob runs job under a watchdog. The job's context is canceled when its timeout elapses;
+// if the job does not return promptly after that it is abandoned and reported as failed so the cycle can
+// move on. Panics raised by the job are recovered and reported as failures as well. The outcome, including
+// degradations the job noted such as a geocoder fallback, is recorded in the job_status table and returned
+// in a summary with the job's record counts.
+func runCollectorJob(ctx context.Context, db *sql.DB, job collectorJob, timeout time.Duration) (summary jobSummary, err error) {
+	summary = jobSummary{Job: job.name, StartedAt: time.Now()}
+	lock, _ := collectorLocks.LoadOrStore(job.name, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		return summary, shared.DBConflict(fmt.Errorf("collector %s is already running", job.name))
+	}
+	// Instances sharing the database also hold an advisory lock per collector, so an on-demand run on one
+	// instance cannot race the cycle of another on the same tables.
+	dbLock, err := shared.TryAdvisoryLock(ctx, db, shared.CollectorLockNamespace, job.name)
+	if err != nil || dbLock == nil {
+		lock.(*sync.Mutex).Unlock()
+		if err != nil {
+			return summary, fmt.Errorf("collector %s: %w", job.name, err)
+		}
+		return summary, shared.DBConflict(fmt.Errorf("collector %s is already running on another instance", job.name))
+	}
+	// An abandoned job keeps its locks until it actually returns, so it cannot be started again meanwhile.
+	release := func() {
+		dbLock.Release()
+		lock.(*sync.Mutex).Unlock()
+	}
+
+	health := &shared.JobHealth{}
+	// Rows are still loaded when the run cannot be recorded; their ingest_run_id is then NULL.
+	ctx, run, runErr := shared.StartIngestRun(ctx, db, "collectors", job.name)
+	if runErr != nil {
+		log.Printf("%v", runErr)
+	} else {
+		summary.IngestRunID = run.ID
+	}
+	defer func() {
+		summary.Status, _ = shared.JobStatusOf(err, health)
+		summary.DurationSeconds = time.Since(summary.StartedAt).Seconds()
+		summary.JobCounts = health.Counts()
+		summary.Degradations = health.Degradations()
+		if err != nil {
+			summary.Error = err.Error()
+			summary.ErrorCategory = shared.ErrorCategory(err)
+			summary.Errors++
+		}
+		if statusErr := shared.RecordJobStatus(db, "collectors", job.name, err, health); statusErr != nil {
+			log.Printf("%v", statusErr)
+		}
+		if err == nil {
+			if registryErr := shared.RecordDatasetSuccess(db, job.name); registryErr != nil {
+				log.Printf("%v", registryErr)
+			}
+		}
+		if run != nil {
+			if finishErr := run.Finish(db, err, health); finishErr != nil {
+				log.Printf("%v", finishErr)
+			}
+		}
+	}()
+
+	jobCtx, cancel := context.WithTimeout(shared.WithRejects(shared.WithJobHealth(ctx, health), db), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			r := recover()
+			release()
+			// Collectors panic with the error that stopped them; wrapping it keeps its category.
+			if err, ok := r.(error); ok {
+				done <- fmt.Errorf("collector %s panicked: %w", job.name, err)
+				return
+			} else if r != nil {
+				done <- fmt.Errorf("collector %s panicked: %v", job.name, r)
+				return
+			}
+			done <- nil
+		}()
+		job.run(jobCtx, db)
+	}()
+
+	select {
+	case err := <-done:
+		return summary, err
+	case <-jobCtx.Done():
+	}
+
+	// Give the job a moment to observe the cancellation and unwind before abandoning it.
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		log.Printf("watchdog: collector %s did not stop after cancellation; abandoning it", job.name)
+	}
+	return summary, fmt.Errorf("collector %s timed out after %s: %w", job.name, timeout, jobCtx.Err())
+}
+
+// collectorTimeout returns the timeout for the named job. COLLECTOR_TIMEOUT_MINUTES_<NAME> overrides
+// COLLECTOR_TIMEOUT_MINUTES, which defaults to 30 minutes.
+func collectorTimeout(name string) time.Duration {
+	keys := []string{collectorTimeoutEnvKey + "_" + strings.ToUpper(name), collectorTimeoutEnvKey}
+	for _, key := range keys {
+		raw := strings.TrimSpace(os.Getenv(key))
+		if raw == "" {
+			continue
+		}
+
+		minutes, err := strconv.Atoi(raw)
+		if err != nil || minutes <= 0 {
+			log.Printf("invalid %s value %q; ignoring it", key, raw)
+			continue
+		}
+		return time.Duration(minutes) * time.Minute
+	}
+
+	return time.Duration(defaultCollectorTimeoutMinutes) * time.Minute
+}
+
+// collectorStartOffset returns how long after the start of a cycle the named job, at position in run order,
+// may start. COLLECTOR_START_OFFSET_MINUTES_<NAME> sets it for one job; otherwise jobs are spread by
+// COLLECTOR_STAGGER_MINUTES each, which defaults to 0 so every job may start right away.
+func collectorStartOffset(name string, position int) time.Duration {
+	key := collectorStartOffsetEnvKey + "_" + strings.ToUpper(name)
+	if raw := strings.TrimSpace(os.Getenv(key)); raw != "" {
+		minutes, err := strconv.Atoi(raw)
+		if err == nil && minutes >= 0 {
+			return time.Duration(minutes) * time.Minute
+		}
+		log.Printf("invalid %s value %q; ignoring it", key, raw)
+	}
+
+	raw := strings.TrimSpace(os.Getenv(collectorStaggerEnvKey))
+	if raw == "" {
+		return 0
+	}
+	minutes, err := strconv.Atoi(raw)
+	if err != nil || minutes < 0 {
+		log.Printf("invalid %s value %q; defaulting to 0", collectorStaggerEnvKey, raw)
+		return 0
+	}
+	return time.Duration(position*minutes) * time.Minute
+}
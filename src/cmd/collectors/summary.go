@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// jobSkipped is the summary status of a job not run because a dependency failed.
+const jobSkipped = "skipped"
+
+// jobSummary is the outcome of one collector in a collection cycle. Errors counts the error that failed
+// the collector, if any, along with those it logged and worked around.
+type jobSummary struct {
+	Job             string    `json:"job"`
+	Status          string    `json:"status"`
+	StartedAt       time.Time `json:"started_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	shared.JobCounts
+	Degradations []string `json:"degradations,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	// ErrorCategory is the shared error category of Error, e.g. source_unavailable, if it has one.
+	ErrorCategory string `json:"error_category,omitempty"`
+	// IngestRunID is stamped on the rows the job loaded.
+	IngestRunID string `json:"ingest_run_id,omitempty"`
+}
+
+// cycleSummary is the outcome of a whole collection cycle, one jobSummary per collector in run order.
+type cycleSummary struct {
+	RequestID  string       `json:"request_id"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt time.Time    `json:"finished_at"`
+	Jobs       []jobSummary `json:"jobs"`
+}
+
+// String renders the summary as a table, one collector per row.
+func (c cycleSummary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "collection cycle summary (%s):\n", c.FinishedAt.Sub(c.StartedAt).Round(time.Second))
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tSTATUS\tDURATION\tFETCHED\tINSERTED\tSKIPPED\tERRORS\tGEOCODE HITS\tDETAIL")
+	for _, job := range c.Jobs {
+		detail := job.Error
+		if detail == "" {
+			detail = strings.Join(job.Degradations, "; ")
+		}
+		duration := time.Duration(job.DurationSeconds * float64(time.Second)).Round(time.Second)
+		geocodeHits := "-"
+		if rate, ok := job.GeocodeCacheHitRate(); ok {
+			geocodeHits = fmt.Sprintf("%.1f%% at %d dp", rate*100, job.GeocodeCachePrecision)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\n", job.Job, job.Status, duration, job.Fetched, job.Inserted, job.Skipped, job.Errors, geocodeHits, detail)
+	}
+	w.Flush()
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// lastRun holds the summary of the latest collection cycle this instance ran, served at /last-run.
+var lastRun struct {
+	sync.Mutex
+	summary *cycleSummary
+}
+
+// publishCycleSummary logs summary and makes it the one /last-run serves.
+func publishCycleSummary(summary cycleSummary) {
+	log.Print(summary)
+
+	lastRun.Lock()
+	defer lastRun.Unlock()
+	lastRun.summary = &summary
+}
+
+// lastRunHandler serves the summary of the latest collection cycle as JSON. Instances that have not run a
+// cycle, such as those that lost the leader election, answer 404.
+func lastRunHandler(w http.ResponseWriter, r *http.Request) {
+	lastRun.Lock()
+	summary := lastRun.summary
+	lastRun.Unlock()
+
+	if summary == nil {
+		http.Error(w, "no collection cycle has finished on this instance yet", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		log.Printf("failed to write last run response: %v", err)
+	}
+}
@@ -1,318 +1,404 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/csv"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/kelvins/geocoder"
 	_ "github.com/lib/pq"
 
+	"github.com/ahbreck/Chicago_BI/datasets"
 	"github.com/ahbreck/Chicago_BI/shared"
 )
 
-type TripRecord struct {
-	Trip_id                    string `json:"trip_id"`
-	Trip_start_timestamp       string `json:"trip_start_timestamp"`
-	Trip_end_timestamp         string `json:"trip_end_timestamp"`
-	Pickup_community_area      string `json:"pickup_community_area"`
-	Dropoff_community_area     string `json:"dropoff_community_area"`
-	Pickup_centroid_latitude   string `json:"pickup_centroid_latitude"`
-	Pickup_centroid_longitude  string `json:"pickup_centroid_longitude"`
-	Dropoff_centroid_latitude  string `json:"dropoff_centroid_latitude"`
-	Dropoff_centroid_longitude string `json:"dropoff_centroid_longitude"`
-}
-
 ///////////////////////////////////////////////////////////////////////////////////////
 ///////////////////////////////////////////////////////////////////////////////////////
 
-func GetTaxiTrips(db *sql.DB) {
+func GetTaxiTrips(ctx context.Context, db *sql.DB) {
 
 	// Read USE_GEOCODING flag from environment
 	useGeocoding := os.Getenv("USE_GEOCODING") == "true"
 
 	fmt.Println("Collecting trips data...")
 
-	drop_table := `drop table if exists taxi_trips`
-	_, err := db.Exec(drop_table)
+	store, err := shared.StoreForTable(db, datasets.TaxiTripsDataset.Table)
 	if err != nil {
 		panic(err)
 	}
 
-	create_table := `CREATE TABLE IF NOT EXISTS "taxi_trips" (
-						"id"   SERIAL , 
-						"trip_id" VARCHAR(255) UNIQUE, 
-						"trip_start_timestamp" TIMESTAMP WITH TIME ZONE, 
-						"trip_end_timestamp" TIMESTAMP WITH TIME ZONE, 
-						"pickup_centroid_latitude" DOUBLE PRECISION, 
-						"pickup_centroid_longitude" DOUBLE PRECISION, 
-						"dropoff_centroid_latitude" DOUBLE PRECISION, 
-						"dropoff_centroid_longitude" DOUBLE PRECISION, 
-						"pickup_community_area" VARCHAR(2),
-						"dropoff_community_area" VARCHAR(2),
-						"pickup_zip_code" VARCHAR(9), 
-						"dropoff_zip_code" VARCHAR(9), 
-						"trip_type" VARCHAR(50),
-						PRIMARY KEY ("id") 
-					);`
-
-	_, _err := db.Exec(create_table)
-	if _err != nil {
-		panic(_err)
+	if err := ensureTripWindowsTable(db); err != nil {
+		panic(err)
 	}
 
 	start := time.Now()
 
-	// Just running sequentially works better in this case rather than using goroutines.
-	GetTrips(db, "taxi", "wrvz-psew", 4000, useGeocoding)
-	GetTrips(db, "tnp", "m6dm-c72p", 4000, useGeocoding)
+	var insertedCount int
+	if tripSourceIsCSV() {
+		insertedCount = getTripsFromCSV(ctx, db, store, useGeocoding)
+	} else {
+		insertedCount = getTripsFromAPI(ctx, db, store, useGeocoding)
+	}
 	duration := time.Since(start)
 	fmt.Printf("Time to pull:   %v\n", duration)
 
-}
-
-/////////////////////////////////////////////////////////////////////////////////////////
-/////////////////////////////////////////////////////////////////////////////////////////
-
-func GetTrips(db *sql.DB, tripType string, apiCode string, limit int, useGeocoding bool) {
-
-	fmt.Printf("Collecting %s trip data...\n", tripType)
-
-	// Get your geocoder.ApiKey from here :
-	// https://developers.google.com/maps/documentation/geocoding/get-api-key?authuser=2
+	if err := shared.RecordTableRefresh(db, "taxi_trips", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to record taxi_trips refresh: %v", err)
+	}
 
-	if useGeocoding {
-		geocoder.ApiKey = os.Getenv("API_KEY")
+	if action, err := shared.MaintainTable(ctx, db, "taxi_trips", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to run maintenance on taxi_trips: %v", err)
+	} else if action != "" {
+		fmt.Printf("Ran %s on taxi_trips\n", action)
 	}
 
-	// Build API URL dynamically
-	// For testing purposes, time range filter is set to limit data to Jan through March of 2022
-	url := fmt.Sprintf("https://data.cityofchicago.org/resource/%s.json?$select=trip_id,trip_start_timestamp,trip_end_timestamp,pickup_community_area,dropoff_community_area,pickup_centroid_latitude,pickup_centroid_longitude,dropoff_centroid_latitude,dropoff_centroid_longitude&$limit=%d&$where=trip_start_timestamp%%20between%%20'2022-01-01T00:00:00'%%20and%%20'2022-03-31T23:59:59'", apiCode, limit)
+}
 
-	res, err := shared.FetchSlowAPI(url)
+// getTripsFromAPI pulls both trip types from SODA and returns how many trips the pull holds.
+func getTripsFromAPI(ctx context.Context, db *sql.DB, store shared.Store, useGeocoding bool) int {
+	// A pull that was interrupted, or stopped by MAX_RECORDS_PER_CYCLE, left its loaded windows behind and
+	// is resumed after them instead of starting over.
+	resuming, err := tripPullInProgress(db)
 	if err != nil {
 		panic(err)
 	}
-	defer res.Body.Close()
-
-	body, _ := ioutil.ReadAll(res.Body)
-	var taxi_trips_list []TripRecord
-	json.Unmarshal(body, &taxi_trips_list)
-
-	insertedCount := 0
-	skippedCount := 0
-	var communityZipMap map[string]string
-
-	if !useGeocoding {
-		var err error
-		communityZipMap, err = loadCommunityAreaZipCodes()
-		if err != nil {
-			fmt.Printf("Unable to load community area ZIP code mapping, defaulting to empty values: %v\n", err)
-		}
+	if resuming {
+		fmt.Println("Resuming the previous trips pull after its loaded windows...")
+	} else if err := store.Reset(ctx, datasets.TaxiTripsDataset); err != nil {
+		panic(err)
 	}
 
-	for _, record := range taxi_trips_list {
-
-		// We will execute defensive coding to check for messy/dirty/missing data values
-		// Any record that has messy/dirty/missing data we don't enter it in the data lake/table
-		fmt.Printf("record: %+v\n", record)
-
-		pickupCommunityRaw := strings.TrimSpace(record.Pickup_community_area)
-		dropoffCommunityRaw := strings.TrimSpace(record.Dropoff_community_area)
-
-		if record.Trip_id == "" ||
-			// if trip start/end timestamp doesn't have the length of 23 chars in the format "0000-00-00T00:00:00.000"
-			// skip this record
-			len(record.Trip_start_timestamp) < 23 ||
-			len(record.Trip_end_timestamp) < 23 ||
-			(pickupCommunityRaw == "" && dropoffCommunityRaw == "") { //||
-			//record.Pickup_centroid_latitude == "" ||
-			//record.Pickup_centroid_longitude == "" ||
-			//record.Dropoff_centroid_latitude == "" ||
-			//record.Dropoff_centroid_longitude == "" {
-			//fmt.Printf("Skipping record due to missing fields: %+v\n", record)
-			skippedCount++
-			continue
-		}
-
-		pickup_centroid_latitude_float, _ := strconv.ParseFloat(record.Pickup_centroid_latitude, 64)
-		pickup_centroid_longitude_float, _ := strconv.ParseFloat(record.Pickup_centroid_longitude, 64)
-		dropoff_centroid_latitude_float, _ := strconv.ParseFloat(record.Dropoff_centroid_latitude, 64)
-		dropoff_centroid_longitude_float, _ := strconv.ParseFloat(record.Dropoff_centroid_longitude, 64)
+	// The two trip types run one after the other; each pull is already pipelined internally.
+	limit := shared.CollectorLimit(datasets.TaxiTripsDataset, 4000)
+	taxiCount, taxiComplete := GetTrips(ctx, db, store, "taxi", taxiTripsAPICode, limit, useGeocoding)
+	tnpCount, tnpComplete := GetTrips(ctx, db, store, "tnp", tnpTripsAPICode, limit, useGeocoding)
 
-		pickupCommunityArea := sql.NullString{}
-		if pickupCommunityRaw != "" {
-			pickupCommunityArea = sql.NullString{String: pickupCommunityRaw, Valid: true}
+	if taxiComplete && tnpComplete {
+		if err := clearTripWindows(db); err != nil {
+			shared.NoteError(ctx, "unable to clear %s: %v", tripWindowsTable, err)
 		}
+	}
+	return taxiCount + tnpCount
+}
 
-		dropoffCommunityArea := sql.NullString{}
-		if dropoffCommunityRaw != "" {
-			dropoffCommunityArea = sql.NullString{String: dropoffCommunityRaw, Valid: true}
-		}
+/////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////////////////
 
-		// Default ZIPs to empty strings
-		pickup_zip_code := ""
-		dropoff_zip_code := ""
+const (
+	// SODA dataset IDs of the taxi and transportation network provider trips.
+	taxiTripsAPICode = "wrvz-psew"
+	tnpTripsAPICode  = "m6dm-c72p"
+
+	// tripFetchWorkersEnvKey sets how many pages of a trip pull are requested from SODA at once.
+	tripFetchWorkersEnvKey = "TRIP_FETCH_WORKERS"
+	// tripPageSizeEnvKey sets the rows requested per page.
+	tripPageSizeEnvKey = "TRIP_PAGE_SIZE"
+	// tripWindowDaysEnvKey sets how many days of trip start times each window of a trip pull covers.
+	tripWindowDaysEnvKey = "TRIP_WINDOW_DAYS"
+
+	defaultTripFetchWorkers = 2
+	defaultTripPageSize     = 1000
+	defaultTripWindowDays   = 1
+
+	// tripWindowsTable records the windows of the current trips pull that are fully loaded. It is emptied
+	// once both trip types have loaded every window.
+	tripWindowsTable = "trip_pull_windows"
+)
 
-		if useGeocoding {
+// For testing purposes, trips are limited to January through March of 2022: tripRangeStart up to, but not
+// including, tripRangeEnd.
+var (
+	tripRangeStart = time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tripRangeEnd   = time.Date(2022, time.April, 1, 0, 0, 0, 0, time.UTC)
+)
 
-			pickup_location := geocoder.Location{
-				Latitude:  pickup_centroid_latitude_float,
-				Longitude: pickup_centroid_longitude_float,
-			}
+// tripWindow is a range of trip start times, start included and end excluded, pulled as one unit.
+type tripWindow struct {
+	start time.Time
+	end   time.Time
+}
 
-			dropoff_location := geocoder.Location{
-				Latitude:  dropoff_centroid_latitude_float,
-				Longitude: dropoff_centroid_longitude_float,
-			}
+// tripWindows splits the pulled range into windows of days days.
+func tripWindows(days int) []tripWindow {
+	var windows []tripWindow
+	for start := tripRangeStart; start.Before(tripRangeEnd); start = start.AddDate(0, 0, days) {
+		windows = append(windows, tripWindow{start: start, end: minTime(start.AddDate(0, 0, days), tripRangeEnd)})
+	}
+	return windows
+}
 
-			pickup_address_list, _ := geocoder.GeocodingReverse(pickup_location)
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
 
-			dropoff_address_list, _ := geocoder.GeocodingReverse(dropoff_location)
+// GetTrips pulls up to limit trips of one type, spread evenly over windows of TRIP_WINDOW_DAYS days of trip
+// start times, and returns how many trips the pull holds and whether every window is loaded. Windows are
+// pulled in order, each paged by offset over trips ordered by trip_id, so every page is reproducible. A
+// window is recorded in trip_pull_windows once its trips are loaded, and a pull that stops early is
+// resumed after its recorded windows by the next run.
+func GetTrips(ctx context.Context, db *sql.DB, store shared.Store, tripType string, apiCode string, limit int, useGeocoding bool) (int, bool) {
 
-			if len(pickup_address_list) > 0 {
-				pickup_zip_code = pickup_address_list[0].PostalCode
-			}
-			if len(dropoff_address_list) > 0 {
-				dropoff_zip_code = dropoff_address_list[0].PostalCode
-			}
-		} else if len(communityZipMap) > 0 {
-			if pickupCommunityArea.Valid {
-				if zip, ok := communityZipMap[pickupCommunityArea.String]; ok {
-					pickup_zip_code = zip
-				}
-			}
-			if dropoffCommunityArea.Valid {
-				if zip, ok := communityZipMap[dropoffCommunityArea.String]; ok {
-					dropoff_zip_code = zip
-				}
-			}
-		}
+	fmt.Printf("Collecting %s trip data...\n", tripType)
+
+	loaded, err := loadedTripWindows(db, tripType)
+	if err != nil {
+		panic(err)
+	}
 
-		sql := `INSERT INTO taxi_trips ("trip_id", "trip_start_timestamp", "trip_end_timestamp", "pickup_centroid_latitude", "pickup_centroid_longitude", "dropoff_centroid_latitude", "dropoff_centroid_longitude", "pickup_community_area", "dropoff_community_area", "pickup_zip_code", 
-			"dropoff_zip_code", "trip_type") values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-			ON CONFLICT (trip_id) DO NOTHING`
-
-		_, err = db.Exec(
-			sql,
-			record.Trip_id,
-			record.Trip_start_timestamp,
-			record.Trip_end_timestamp,
-			pickup_centroid_latitude_float,
-			pickup_centroid_longitude_float,
-			dropoff_centroid_latitude_float,
-			dropoff_centroid_longitude_float,
-			pickupCommunityArea,
-			dropoffCommunityArea,
-			pickup_zip_code,
-			dropoff_zip_code,
-			tripType)
-
-		if err != nil {
-			fmt.Printf("Error inserting %s trip %s: %v\n", tripType, record.Trip_id, err)
+	windows := tripWindows(tripFetchSetting(tripWindowDaysEnvKey, defaultTripWindowDays))
+	perWindow := max(1, (limit+len(windows)-1)/len(windows))
+	pullCount := 0
+	for i, window := range windows {
+		if count, ok := loaded[window.start.Format(sodaTimestampLayout)]; ok {
+			pullCount += count
 			continue
 		}
-		insertedCount++
 
+		insertedCount, complete := getTripWindow(ctx, store, tripType, apiCode, i, window, perWindow, useGeocoding)
+		pullCount += insertedCount
+		if !complete {
+			fmt.Printf("%s reached; the next run resumes %s trips at %s\n", shared.MaxRecordsPerCycleEnvKey, tripType,
+				window.start.Format(time.DateOnly))
+			return pullCount, false
+		}
+		if err := recordTripWindow(db, tripType, window, insertedCount); err != nil {
+			panic(err)
+		}
 	}
-	fmt.Printf("Finished inserting %d %s trips (%d skipped).\n", insertedCount, tripType, skippedCount)
 
+	fmt.Printf("Finished %s trip pull: %d trips over %d windows.\n", tripType, pullCount, len(windows))
+	return pullCount, true
 }
 
-// findCommunityZipDataPath walks up from the current working directory until it finds the community area to ZIP code CSV.
-func findCommunityZipDataPath() (string, error) {
-	relPath := filepath.Join("src", "data", "community_area_to_zip_code.csv")
-
-	seen := map[string]struct{}{}
-	searchFrom := func(start string) (string, bool) {
-		if start == "" {
-			return "", false
+// getTripWindow pulls up to limit trips of one window through a fetch -> validate -> geocode -> insert
+// pipeline and returns how many were inserted and whether the window was pulled in full. The fetch stage
+// requests pages of TRIP_PAGE_SIZE rows on TRIP_FETCH_WORKERS goroutines and streams their trips into
+// datasets.TripPipeline, so trips are geocoded and inserted while later pages are still downloading.
+// Pages are shrunk so the pages in flight fit in COLLECTOR_MEMORY_MB, and every page draws on the cycle's
+// MAX_RECORDS_PER_CYCLE budget; a window cut short by the budget is not complete.
+func getTripWindow(ctx context.Context, store shared.Store, tripType, apiCode string, index int, window tripWindow, limit int, useGeocoding bool) (int, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pipeline := datasets.TripPipelineFromEnv()
+	fetchWorkers := tripFetchSetting(tripFetchWorkersEnvKey, defaultTripFetchWorkers)
+	pageSize := min(tripFetchSetting(tripPageSizeEnvKey, defaultTripPageSize), max(1, shared.ChunkSize(datasets.TaxiTripsDataset)/fetchWorkers))
+	pagesPerWindow := (limit + pageSize - 1) / pageSize
+	pages := make(chan int)
+	go func() {
+		defer close(pages)
+		for offset := 0; offset < limit; offset += pageSize {
+			select {
+			case pages <- offset:
+			case <-ctx.Done():
+				return
+			}
 		}
-		if _, ok := seen[start]; ok {
-			return "", false
+	}()
+
+	var (
+		mu       sync.Mutex
+		fetchErr error
+		stats    shared.DecodeStats
+		// drained is set once a page comes back short: the window holds no trips past it.
+		drained    atomic.Bool
+		overBudget atomic.Bool
+	)
+	records := make(chan datasets.TripRecord, pipeline.Buffer)
+	go func() {
+		defer close(records)
+		var wg sync.WaitGroup
+		for range fetchWorkers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for offset := range pages {
+					if drained.Load() {
+						continue
+					}
+					granted := shared.TakeRecords(ctx, min(pageSize, limit-offset))
+					if granted == 0 {
+						overBudget.Store(true)
+						return
+					}
+
+					page, pageStats, err := fetchTripPage(ctx, apiCode, window, offset, granted)
+					if err != nil {
+						shared.ReturnRecords(ctx, granted)
+						mu.Lock()
+						if fetchErr == nil {
+							fetchErr = err
+						}
+						mu.Unlock()
+						cancel()
+						return
+					}
+
+					shared.ReturnRecords(ctx, granted-len(page))
+					shared.CountFetched(ctx, len(page))
+					if len(page) < granted {
+						drained.Store(true)
+					}
+					mu.Lock()
+					stats.Add(pageStats)
+					mu.Unlock()
+
+					if archived, err := shared.ArchiveRawChunk(ctx, tripType+"_trips", index*pagesPerWindow+offset/pageSize, page); err != nil {
+						shared.NoteError(ctx, "unable to archive raw %s trip records: %v", tripType, err)
+					} else if archived != "" {
+						fmt.Printf("Archived raw %s trip records to %s\n", tripType, archived)
+					}
+
+					for _, record := range page {
+						select {
+						case records <- record:
+						case <-ctx.Done():
+							return
+						}
+					}
+				}
+			}()
 		}
-		seen[start] = struct{}{}
+		wg.Wait()
+	}()
 
-		dir := start
-		for {
-			candidate := filepath.Join(dir, relPath)
-			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
-				return candidate, true
-			}
+	insertedCount, skippedCount, err := pipeline.Load(ctx, store, tripType, records, useGeocoding)
+	mu.Lock()
+	err = errors.Join(fetchErr, err)
+	mu.Unlock()
+	if err != nil {
+		panic(err)
+	}
+	shared.CountLoaded(ctx, insertedCount, skippedCount)
+	fmt.Printf("%s trips %s: %d inserted, %d skipped; decode stats: %s\n", tripType, window.start.Format(time.DateOnly),
+		insertedCount, skippedCount, stats)
 
-			parent := filepath.Dir(dir)
-			if parent == dir {
-				break
-			}
-			dir = parent
-		}
+	return insertedCount, !overBudget.Load()
+}
 
-		return "", false
-	}
+// tripSources are the Socrata sources of the trips datasets, by API code.
+var tripSources = map[string]shared.SODASource{
+	taxiTripsAPICode: datasets.TaxiTripsSource,
+	tnpTripsAPICode:  datasets.TNPTripsSource,
+}
 
-	if cwd, err := os.Getwd(); err == nil {
-		if path, ok := searchFrom(cwd); ok {
-			return path, nil
-		}
+// fetchTripPage requests one page of a window's trips. Pages are ordered by trip_id so offsets do not
+// overlap and the same page always holds the same trips.
+func fetchTripPage(ctx context.Context, apiCode string, window tripWindow, offset, pageSize int) ([]datasets.TripRecord, shared.DecodeStats, error) {
+	if _, err := shared.CityResource(apiCode); err != nil {
+		return nil, shared.DecodeStats{}, err
 	}
-
-	if exe, err := os.Executable(); err == nil {
-		if path, ok := searchFrom(filepath.Dir(exe)); ok {
-			return path, nil
-		}
+	url := shared.SodaQuery{
+		Resource: apiCode,
+		Select:   tripSources[apiCode].Select,
+		Where: fmt.Sprintf("trip_start_timestamp >= '%s' AND trip_start_timestamp < '%s'",
+			window.start.Format(sodaTimestampLayout), window.end.Format(sodaTimestampLayout)),
+		Order:  "trip_id",
+		Limit:  pageSize,
+		Offset: offset,
+	}.URL()
+
+	res, err := shared.FetchSlowAPI(ctx, url)
+	if err != nil {
+		return nil, shared.DecodeStats{}, err
 	}
+	defer res.Body.Close()
 
-	return "", fmt.Errorf("could not locate %s", relPath)
+	body, _ := ioutil.ReadAll(res.Body)
+	return shared.DecodeSODARecords[datasets.TripRecord](body)
 }
 
-// loadCommunityAreaZipCodes reads the community area to ZIP code mapping.
-func loadCommunityAreaZipCodes() (map[string]string, error) {
-	csvPath, err := findCommunityZipDataPath()
+// sodaTimestampLayout is SoQL's floating timestamp format.
+const sodaTimestampLayout = "2006-01-02T15:04:05"
+
+// ensureTripWindowsTable creates trip_pull_windows on the first trips pull.
+func ensureTripWindowsTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+		"trip_type" VARCHAR(16) NOT NULL,
+		"window_start" TIMESTAMP NOT NULL,
+		"window_end" TIMESTAMP NOT NULL,
+		"trips" INTEGER NOT NULL,
+		"loaded_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		PRIMARY KEY ("trip_type", "window_start")
+	)`, tripWindowsTable))
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to create %s: %w", tripWindowsTable, err)
 	}
+	return nil
+}
 
-	file, err := os.Open(csvPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open community area zip code file: %w", err)
+// tripPullInProgress reports whether an earlier trips pull left loaded windows behind.
+func tripPullInProgress(db *sql.DB) (bool, error) {
+	var exists bool
+	if err := db.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %q)`, tripWindowsTable)).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", tripWindowsTable, err)
 	}
-	defer file.Close()
+	return exists, nil
+}
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+// loadedTripWindows returns the trips loaded per window start, formatted with sodaTimestampLayout, for one
+// trip type.
+func loadedTripWindows(db *sql.DB, tripType string) (map[string]int, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT "window_start", "trips" FROM %q WHERE "trip_type" = $1`, tripWindowsTable), tripType)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read community area zip code file: %w", err)
+		return nil, fmt.Errorf("failed to read %s: %w", tripWindowsTable, err)
 	}
-
-	areaZipMap := make(map[string]string, len(records))
-	for i, row := range records {
-		if len(row) < 2 {
-			continue
+	defer rows.Close()
+
+	loaded := make(map[string]int)
+	for rows.Next() {
+		var (
+			start time.Time
+			trips int
+		)
+		if err := rows.Scan(&start, &trips); err != nil {
+			return nil, fmt.Errorf("failed to scan %s row: %w", tripWindowsTable, err)
 		}
-		communityArea := strings.TrimSpace(row[0])
-		zip := strings.TrimSpace(row[1])
+		loaded[start.UTC().Format(sodaTimestampLayout)] = trips
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading %s: %w", tripWindowsTable, err)
+	}
+	return loaded, nil
+}
 
-		if i == 0 && strings.EqualFold(communityArea, "community_area") {
-			continue
-		}
+func recordTripWindow(db *sql.DB, tripType string, window tripWindow, trips int) error {
+	_, err := db.Exec(fmt.Sprintf(`INSERT INTO %q ("trip_type", "window_start", "window_end", "trips") VALUES ($1, $2, $3, $4)
+		ON CONFLICT ("trip_type", "window_start") DO UPDATE
+		SET window_end = EXCLUDED.window_end,
+			trips = EXCLUDED.trips,
+			loaded_at = NOW()`, tripWindowsTable), tripType, window.start, window.end, trips)
+	if err != nil {
+		return fmt.Errorf("failed to record %s trips window %s: %w", tripType, window.start.Format(time.DateOnly), err)
+	}
+	return nil
+}
 
-		if communityArea == "" || zip == "" {
-			continue
-		}
+func clearTripWindows(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(`DELETE FROM %q`, tripWindowsTable))
+	return err
+}
 
-		areaZipMap[communityArea] = zip
+func tripFetchSetting(key string, fallback int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
 	}
 
-	if len(areaZipMap) == 0 {
-		return nil, fmt.Errorf("no community area zip codes found in %s", csvPath)
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		fmt.Printf("invalid %s value %q; defaulting to %d\n", key, raw, fallback)
+		return fallback
 	}
-
-	return areaZipMap, nil
+	return n
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	// tripSourceEnvKey picks where the trips collector reads trips from: "api" (default) pages through SODA,
+	// "csv" streams bulk CSV exports for backfills.
+	tripSourceEnvKey = "TRIP_SOURCE"
+	// tripCSVEnvKeyPrefix names the CSV export of one trip type, e.g. TRIP_CSV_TAXI: a local path, a
+	// gs://bucket/object, or a URL. Unset downloads the data portal's export of the whole dataset.
+	tripCSVEnvKeyPrefix = "TRIP_CSV_"
+
+	tripSourceAPI = "api"
+	tripSourceCSV = "csv"
+)
+
+// tripSourceIsCSV reports whether TRIP_SOURCE selects the bulk CSV exports.
+func tripSourceIsCSV() bool {
+	raw := strings.ToLower(strings.TrimSpace(os.Getenv(tripSourceEnvKey)))
+	switch raw {
+	case "", tripSourceAPI:
+		return false
+	case tripSourceCSV:
+		return true
+	}
+	fmt.Printf("invalid %s value %q; defaulting to %s\n", tripSourceEnvKey, raw, tripSourceAPI)
+	return false
+}
+
+// getTripsFromCSV replaces the trips table with every trip in the CSV exports of both trip types and
+// returns how many were inserted. Exports are streamed through the same validate -> geocode -> insert
+// pipeline as API pulls, so only the pipeline's buffers are held in memory. They are not subject to
+// MAX_RECORDS_PER_CYCLE or the collector's record limit, and any API pull in progress is abandoned. The
+// header of both exports is checked before the table is emptied, so a wrong file leaves it untouched.
+func getTripsFromCSV(ctx context.Context, db *sql.DB, store shared.Store, useGeocoding bool) int {
+	taxiSource, err := tripCSVSource("taxi", taxiTripsAPICode)
+	if err != nil {
+		panic(err)
+	}
+	tnpSource, err := tripCSVSource("tnp", tnpTripsAPICode)
+	if err != nil {
+		panic(err)
+	}
+	for _, source := range []string{taxiSource, tnpSource} {
+		file, _, err := openTripCSV(ctx, source)
+		if err != nil {
+			panic(err)
+		}
+		file.Close()
+	}
+
+	if err := store.Reset(ctx, datasets.TaxiTripsDataset); err != nil {
+		panic(err)
+	}
+	if err := clearTripWindows(db); err != nil {
+		panic(fmt.Errorf("failed to clear %s: %w", tripWindowsTable, err))
+	}
+
+	taxiCount := getTripCSV(ctx, store, "taxi", taxiSource, useGeocoding)
+	tnpCount := getTripCSV(ctx, store, "tnp", tnpSource, useGeocoding)
+	return taxiCount + tnpCount
+}
+
+// tripCSVSource returns the CSV export of one trip type: TRIP_CSV_<TYPE>, or else the portal's export.
+func tripCSVSource(tripType, apiCode string) (string, error) {
+	if source := strings.TrimSpace(os.Getenv(tripCSVEnvKeyPrefix + strings.ToUpper(tripType))); source != "" {
+		return source, nil
+	}
+	return shared.PortalExportURL(apiCode)
+}
+
+// openTripCSV opens a trips export, decompressing it if needed, and verifies its header.
+func openTripCSV(ctx context.Context, source string) (io.ReadCloser, *shared.CSVReader[datasets.TripRecord], error) {
+	file, err := shared.OpenBulkFile(ctx, source)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := shared.NewCSVReader[datasets.TripRecord](file)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s is not a trips export: %w", source, err)
+	}
+	return file, reader, nil
+}
+
+// getTripCSV loads the CSV export of one trip type and returns how many trips were inserted.
+func getTripCSV(ctx context.Context, store shared.Store, tripType, source string, useGeocoding bool) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fmt.Printf("Loading %s trips from %s...\n", tripType, source)
+	file, reader, err := openTripCSV(ctx, source)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	pipeline := datasets.TripPipelineFromEnv()
+	records := make(chan datasets.TripRecord, pipeline.Buffer)
+	// A failed read cancels the pipeline rather than letting it flush a partial export.
+	readDone := make(chan error, 1)
+	go func() {
+		defer close(records)
+		read, err := reader.Each(func(record datasets.TripRecord) error {
+			select {
+			case records <- record:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		shared.CountFetched(ctx, read)
+		if err != nil {
+			cancel()
+		}
+		readDone <- err
+	}()
+
+	insertedCount, skippedCount, err := pipeline.Load(ctx, store, tripType, records, useGeocoding)
+	cancel()
+	if readErr := <-readDone; readErr != nil && !errors.Is(readErr, context.Canceled) {
+		panic(fmt.Errorf("failed to read %s trips from %s: %w", tripType, source, readErr))
+	}
+	if err != nil {
+		panic(err)
+	}
+	shared.CountLoaded(ctx, insertedCount, skippedCount)
+	fmt.Printf("%s trips from %s: %d inserted, %d skipped\n", tripType, source, insertedCount, skippedCount)
+
+	return insertedCount
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+
+	_ "github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func GetVacantBuildings(ctx context.Context, db *sql.DB) {
+	fmt.Println("GetVacantBuildings: Collecting 311 vacant and abandoned building reports")
+
+	store, err := shared.StoreForTable(db, datasets.VacantBuildingsDataset.Table)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := store.Reset(ctx, datasets.VacantBuildingsDataset); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Created Table for vacant buildings in %s\n", store.Name())
+
+	query := shared.SodaQuery{
+		Resource: "v6vf-nfxy",
+		Select:   datasets.VacantBuildingsSource.Select,
+		Where:    fmt.Sprintf("sr_type = '%s'", datasets.VacantBuildingsServiceType),
+	}
+
+	var insertedCount, skippedCount int
+	limit := shared.CollectorLimit(datasets.VacantBuildingsDataset, 20000)
+	decodeStats, err := shared.FetchSODAChunks(ctx, query, limit, shared.ChunkSize(datasets.VacantBuildingsDataset), shared.FetchFastAPI,
+		func(chunk int, vacant_data_list []datasets.VacantBuildingRecord) error {
+			s := fmt.Sprintf("\n\n Number of vacant building SODA records received = %d\n\n", len(vacant_data_list))
+			io.WriteString(os.Stdout, s)
+
+			if archived, err := shared.ArchiveRawChunk(ctx, "vacant_buildings", chunk, vacant_data_list); err != nil {
+				shared.NoteError(ctx, "unable to archive raw vacant building records: %v", err)
+			} else if archived != "" {
+				fmt.Printf("Archived raw vacant building records to %s\n", archived)
+			}
+
+			inserted, skipped, err := datasets.LoadVacantBuildings(ctx, store, vacant_data_list)
+			insertedCount += inserted
+			skippedCount += skipped
+			return err
+		})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Vacant buildings decode stats: %s\n", decodeStats)
+
+	shared.CountLoaded(ctx, insertedCount, skippedCount)
+
+	if err := shared.RecordTableRefresh(db, "vacant_buildings", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to record vacant_buildings refresh: %v", err)
+	}
+
+	if action, err := shared.MaintainTable(ctx, db, "vacant_buildings", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to run maintenance on vacant_buildings: %v", err)
+	} else if action != "" {
+		fmt.Printf("Ran %s on vacant_buildings\n", action)
+	}
+
+}
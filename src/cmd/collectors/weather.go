@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	_ "github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	// weatherStationEnvKey selects the NOAA GHCN-Daily station; the default is Chicago O'Hare.
+	weatherStationEnvKey  = "WEATHER_STATION"
+	defaultWeatherStation = "USW00094846"
+
+	noaaDailySummariesURL = "https://www.ncei.noaa.gov/access/services/data/v1"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func GetWeatherDetails(ctx context.Context, db *sql.DB) {
+	fmt.Println("GetWeatherDetails: Collecting daily NOAA weather observations")
+
+	store, err := shared.StoreForTable(db, datasets.WeatherDataset.Table)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := store.Reset(ctx, datasets.WeatherDataset); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Created Table for daily weather in %s\n", store.Name())
+
+	station := strings.TrimSpace(os.Getenv(weatherStationEnvKey))
+	if station == "" {
+		station = defaultWeatherStation
+	}
+
+	// Matches the date range of the trip pulls, so every trip day has its weather.
+	params := url.Values{
+		"dataset":   {"daily-summaries"},
+		"stations":  {station},
+		"startDate": {"2022-01-01"},
+		"endDate":   {"2022-03-31"},
+		"dataTypes": {"TMAX,TMIN,PRCP,SNOW"},
+		"units":     {"standard"},
+		"format":    {"json"},
+	}
+
+	res, err := shared.FetchFastAPI(ctx, noaaDailySummariesURL+"?"+params.Encode())
+	if err != nil {
+		panic(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		panic(fmt.Errorf("NOAA daily summaries for station %s returned status %d", station, res.StatusCode))
+	}
+
+	var weather_data_list datasets.WeatherRecords
+	if err := json.NewDecoder(res.Body).Decode(&weather_data_list); err != nil {
+		panic(fmt.Errorf("failed to decode NOAA daily summaries: %w", err))
+	}
+	fmt.Printf("\n\n Number of NOAA daily weather records received = %d\n\n", len(weather_data_list))
+	shared.CountFetched(ctx, len(weather_data_list))
+
+	if archived, err := shared.ArchiveRawRecords(ctx, "weather", weather_data_list); err != nil {
+		shared.NoteError(ctx, "unable to archive raw weather records: %v", err)
+	} else if archived != "" {
+		fmt.Printf("Archived raw weather records to %s\n", archived)
+	}
+
+	insertedCount, skippedCount, err := datasets.LoadWeather(ctx, store, weather_data_list)
+	if err != nil {
+		panic(err)
+	}
+
+	shared.CountLoaded(ctx, insertedCount, skippedCount)
+
+	if err := shared.RecordTableRefresh(db, "weather_daily", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to record weather_daily refresh: %v", err)
+	}
+
+	if action, err := shared.MaintainTable(ctx, db, "weather_daily", insertedCount); err != nil {
+		shared.NoteError(ctx, "unable to run maintenance on weather_daily: %v", err)
+	} else if action != "" {
+		fmt.Printf("Ran %s on weather_daily\n", action)
+	}
+
+}
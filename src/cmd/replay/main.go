@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// replayer reloads one archived dataset into its destination table.
+type replayer struct {
+	dataset shared.Dataset
+	// load decodes one archived file and loads it; retrieved is the date the file was archived.
+	load func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error)
+	// covidSource marks the tables of the covid_unified view, which is dropped before they are reset and
+	// refreshed after the replay.
+	covidSource bool
+	// partition is set for the datasets that share their table with another, such as taxi and TNP trips: their
+	// reset deletes only the rows of the partition instead of recreating the table.
+	partition *rowPartition
+}
+
+// rowPartition selects the rows of one dataset in a shared table.
+type rowPartition struct {
+	column string
+	value  string
+}
+
+// replayers is keyed by the dataset name used in the raw archive layout.
+var replayers = map[string]replayer{
+	"ccvi": {
+		dataset: datasets.CCVIDataset,
+		load: func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error) {
+			records, err := decodeRaw[datasets.CCVIRecord](data, format)
+			if err != nil {
+				return 0, 0, err
+			}
+			return datasets.LoadCCVI(ctx, store, records)
+		},
+	},
+	"covid": {
+		dataset:     datasets.CovidDataset,
+		covidSource: true,
+		load: func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error) {
+			records, err := decodeRaw[datasets.CovidRecord](data, format)
+			if err != nil {
+				return 0, 0, err
+			}
+			return datasets.LoadCovid(ctx, store, records)
+		},
+	},
+	"covid_respiratory": {
+		dataset:     datasets.CovidRespiratoryDataset,
+		covidSource: true,
+		load: func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error) {
+			records, err := decodeRaw[datasets.CovidRespiratoryRecord](data, format)
+			if err != nil {
+				return 0, 0, err
+			}
+			return datasets.LoadCovidRespiratory(ctx, store, records)
+		},
+	},
+	"public_health": {
+		dataset: datasets.PublicHealthDataset,
+		load: func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error) {
+			records, err := decodeRaw[datasets.UnemploymentJsonRecord](data, format)
+			if err != nil {
+				return 0, 0, err
+			}
+			return datasets.LoadPublicHealth(ctx, store, records, datasets.NewPublicHealthVintage(retrieved))
+		},
+	},
+	"building_permits": {
+		dataset: datasets.BuildingPermitsDataset,
+		load: func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error) {
+			records, err := decodeRaw[datasets.BuildingPermitsJsonRecord](data, format)
+			if err != nil {
+				return 0, 0, err
+			}
+			return datasets.LoadBuildingPermits(ctx, store, records, geocodingEnabled())
+		},
+	},
+	"weather": {
+		dataset: datasets.WeatherDataset,
+		load: func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error) {
+			records, err := decodeRaw[datasets.WeatherRecord](data, format)
+			if err != nil {
+				return 0, 0, err
+			}
+			return datasets.LoadWeather(ctx, store, records)
+		},
+	},
+	"business_licenses": {
+		dataset: datasets.BusinessLicensesDataset,
+		load: func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error) {
+			records, err := decodeRaw[datasets.BusinessLicenseRecord](data, format)
+			if err != nil {
+				return 0, 0, err
+			}
+			return datasets.LoadBusinessLicenses(ctx, store, records)
+		},
+	},
+	"food_inspections": {
+		dataset: datasets.FoodInspectionsDataset,
+		load: func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error) {
+			records, err := decodeRaw[datasets.FoodInspectionRecord](data, format)
+			if err != nil {
+				return 0, 0, err
+			}
+			return datasets.LoadFoodInspections(ctx, store, records)
+		},
+	},
+	"vacant_buildings": {
+		dataset: datasets.VacantBuildingsDataset,
+		load: func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error) {
+			records, err := decodeRaw[datasets.VacantBuildingRecord](data, format)
+			if err != nil {
+				return 0, 0, err
+			}
+			return datasets.LoadVacantBuildings(ctx, store, records)
+		},
+	},
+	"population": {
+		dataset: datasets.PopulationDataset,
+		load: func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error) {
+			records, err := decodeRaw[datasets.PopulationRecord](data, format)
+			if err != nil {
+				return 0, 0, err
+			}
+			return datasets.LoadPopulation(ctx, store, records)
+		},
+	},
+	"taxi_trips": tripReplayer("taxi"),
+	"tnp_trips":  tripReplayer("tnp"),
+	"cta_rail": {
+		dataset:   datasets.CTARidershipDataset,
+		partition: &rowPartition{column: "mode", value: datasets.CTARailMode},
+		load: func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error) {
+			records, err := decodeRaw[datasets.CTARailRecord](data, format)
+			if err != nil {
+				return 0, 0, err
+			}
+			stations, err := ctaStations()
+			if err != nil {
+				return 0, 0, err
+			}
+			return datasets.LoadCTARail(ctx, store, records, stations)
+		},
+	},
+	"cta_bus": {
+		dataset:   datasets.CTARidershipDataset,
+		partition: &rowPartition{column: "mode", value: datasets.CTABusMode},
+		load: func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error) {
+			records, err := decodeRaw[datasets.CTABusRecord](data, format)
+			if err != nil {
+				return 0, 0, err
+			}
+			return datasets.LoadCTABus(ctx, store, records)
+		},
+	},
+}
+
+func tripReplayer(tripType string) replayer {
+	return replayer{
+		dataset:   datasets.TaxiTripsDataset,
+		partition: &rowPartition{column: "trip_type", value: tripType},
+		load: func(ctx context.Context, store shared.Store, data []byte, format string, retrieved time.Time) (int, int, error) {
+			records, err := decodeRaw[datasets.TripRecord](data, format)
+			if err != nil {
+				return 0, 0, err
+			}
+			return datasets.LoadTrips(ctx, store, tripType, records, geocodingEnabled())
+		},
+	}
+}
+
+// ctaStations locates the 'L' stations replayed rail ridership is placed at. The collector does not archive
+// the stop list, so it is fetched from SODA once per replay.
+var ctaStations = sync.OnceValues(func() (map[string]datasets.CTAStation, error) {
+	var stops []datasets.CTAStopRecord
+	_, err := shared.FetchSODAChunks(context.Background(),
+		shared.SodaQuery{Resource: datasets.CTAStopsSource.ID, Select: datasets.CTAStopsSource.Select}, 1000, 0, shared.FetchFastAPI,
+		func(chunk int, records []datasets.CTAStopRecord) error {
+			stops = append(stops, records...)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the CTA 'L' stop list: %w", err)
+	}
+	stations := datasets.CTAStations(context.Background(), stops, geocodingEnabled())
+	log.Printf("located %d CTA 'L' stations", len(stations))
+	return stations, nil
+})
+
+// geocodingEnabled reports whether USE_GEOCODING is set; the provider comes from GEOCODER_PROVIDER.
+func geocodingEnabled() bool {
+	return os.Getenv("USE_GEOCODING") == "true"
+}
+
+func main() {
+	if err := shared.LoadDotenv(); err != nil {
+		log.Fatalf("error loading .env file: %v", err)
+	}
+	if err := shared.CheckRequiredConfig(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	datasetName := flag.String("dataset", "", "archived dataset to replay: "+strings.Join(replayerNames(), ", "))
+	fromRaw := flag.String("from", "", "first partition date to replay (YYYY-MM-DD)")
+	toRaw := flag.String("to", "", "last partition date to replay (YYYY-MM-DD); defaults to -from")
+	source := flag.String("source", "", "local archive directory or gs://bucket; defaults to gs://$"+shared.RawArchiveBucketEnvKey)
+	reset := flag.Bool("reset", true, "drop and recreate the destination table before replaying; datasets sharing a table delete only their own rows")
+	flag.Parse()
+
+	r, ok := replayers[*datasetName]
+	if !ok {
+		log.Fatalf("unknown -dataset %q; expected one of %s", *datasetName, strings.Join(replayerNames(), ", "))
+	}
+
+	from, err := time.Parse("2006-01-02", *fromRaw)
+	if err != nil {
+		log.Fatalf("invalid -from %q: %v", *fromRaw, err)
+	}
+	to := from
+	if *toRaw != "" {
+		if to, err = time.Parse("2006-01-02", *toRaw); err != nil {
+			log.Fatalf("invalid -to %q: %v", *toRaw, err)
+		}
+	}
+	if to.Before(from) {
+		log.Fatalf("-to %s is before -from %s", to.Format("2006-01-02"), from.Format("2006-01-02"))
+	}
+
+	if *source == "" {
+		if !shared.RawArchiveEnabled() {
+			log.Fatalf("-source is required when %s is not set", shared.RawArchiveBucketEnvKey)
+		}
+		*source = "gs://" + strings.TrimSpace(os.Getenv(shared.RawArchiveBucketEnvKey))
+	}
+
+	connStr := os.Getenv("DATABASE_URL")
+	if connStr == "" {
+		connStr = shared.DefaultConnectionString
+	}
+
+	db, err := shared.OpenDatabase(connStr)
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := shared.EnsureLineageTables(db); err != nil {
+		log.Fatalf("failed to prepare lineage tables: %v", err)
+	}
+	if err := shared.TrackGeocodingUsage(db); err != nil {
+		log.Printf("geocoding usage will not be tracked: %v", err)
+	}
+	if err := shared.PersistGeocodeCache(db); err != nil {
+		log.Printf("geocoder answers will be cached in memory only: %v", err)
+	}
+
+	// Replayed records placed outside Chicago are rejected again, like in the collectors.
+	ctx := shared.WithRejects(shared.WithRequestID(context.Background(), shared.NewRequestID()), db)
+	// Replayed rows get an ingest run of their own, whose sources are the archived files.
+	ctx, run, err := shared.StartIngestRun(ctx, db, "replay", *datasetName)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	err = replay(ctx, db, *datasetName, r, *source, from, to, *reset)
+	if finishErr := run.Finish(db, err, nil); finishErr != nil {
+		log.Printf("%v", finishErr)
+	}
+	if err != nil {
+		log.Fatalf("replay of %s failed: %v", *datasetName, err)
+	}
+}
+
+func replay(ctx context.Context, db *sql.DB, name string, r replayer, source string, from, to time.Time, reset bool) error {
+	files, err := listArchive(ctx, source, name, from, to)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no archived files for %s between %s and %s in %s", name, from.Format("2006-01-02"), to.Format("2006-01-02"), source)
+	}
+
+	store, err := shared.StoreForTable(db, r.dataset.Table)
+	if err != nil {
+		return err
+	}
+
+	if reset {
+		if r.covidSource {
+			if err := datasets.DropCovidUnifiedView(db); err != nil {
+				return err
+			}
+		}
+		if r.partition != nil {
+			if err := resetPartition(ctx, db, store, r.dataset, *r.partition); err != nil {
+				return err
+			}
+		} else {
+			if err := store.Reset(ctx, r.dataset); err != nil {
+				return err
+			}
+			log.Printf("recreated %s in %s", r.dataset.Table, store.Name())
+		}
+	}
+
+	totalInserted := 0
+	for _, file := range files {
+		data, err := readArchive(ctx, source, file)
+		if err != nil {
+			return err
+		}
+
+		retrieved, ok := shared.RawArchivePartitionDate(file)
+		if !ok {
+			retrieved = time.Now()
+		}
+
+		inserted, skipped, err := r.load(ctx, store, data, archiveFormat(file), retrieved)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", file, err)
+		}
+		log.Printf("replayed %s: inserted %d rows, skipped %d", file, inserted, skipped)
+		totalInserted += inserted
+	}
+
+	if err := shared.RecordTableRefresh(db, r.dataset.Table, totalInserted); err != nil {
+		log.Printf("unable to record %s refresh: %v", r.dataset.Table, err)
+	}
+	if r.covidSource {
+		if err := datasets.RefreshCovidUnifiedView(db); err != nil {
+			log.Printf("unable to refresh %s: %v", datasets.CovidUnifiedView, err)
+		}
+	}
+	if action, err := shared.MaintainTable(ctx, db, r.dataset.Table, totalInserted); err != nil {
+		log.Printf("unable to run maintenance on %s: %v", r.dataset.Table, err)
+	} else if action != "" {
+		log.Printf("ran %s on %s", action, r.dataset.Table)
+	}
+
+	log.Printf("replay of %s complete: %d files, %d rows inserted", name, len(files), totalInserted)
+	return nil
+}
+
+// resetPartition deletes the rows of partition from the shared table of ds, creating it if needed, and keeps
+// the rows of the other datasets loading it. Only the Postgres backend can delete rows, so a reset against
+// another backend fails.
+func resetPartition(ctx context.Context, db *sql.DB, store shared.Store, ds shared.Dataset, partition rowPartition) error {
+	if backend := shared.StorageBackendFor(ds.Table); backend != shared.BackendPostgres {
+		return fmt.Errorf("cannot reset the %s rows of %s in %s without dropping the rows of other datasets; rerun with -reset=false", partition.value, ds.Table, backend)
+	}
+	if err := store.Ensure(ctx, ds); err != nil {
+		return err
+	}
+
+	result, err := db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %q WHERE %q = $1`, ds.Table, partition.column), partition.value)
+	if err != nil {
+		return fmt.Errorf("failed to delete the %s rows of %s: %w", partition.value, ds.Table, err)
+	}
+	deleted, _ := result.RowsAffected()
+	log.Printf("deleted %d %s rows from %s in %s", deleted, partition.value, ds.Table, store.Name())
+	return nil
+}
+
+// listArchive finds the archived files of dataset within [from, to] under a gs:// bucket or local directory.
+func listArchive(ctx context.Context, source, dataset string, from, to time.Time) ([]string, error) {
+	if bucket, ok := strings.CutPrefix(source, "gs://"); ok {
+		return shared.ListRawArchive(ctx, strings.TrimSuffix(bucket, "/"), dataset, from, to)
+	}
+
+	root := filepath.Join(source, dataset)
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || archiveFormat(path) == "" {
+			return nil
+		}
+		date, ok := shared.RawArchivePartitionDate(path)
+		if !ok || date.Before(from) || date.After(to) {
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", root, err)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// readArchive reads an archived file and records it as a source of the ingest run of ctx.
+func readArchive(ctx context.Context, source, file string) ([]byte, error) {
+	if bucket, ok := strings.CutPrefix(source, "gs://"); ok {
+		bucket = strings.TrimSuffix(bucket, "/")
+		shared.NoteIngestSource(ctx, "gs://"+bucket+"/"+file)
+		return shared.ReadRawArchiveObject(ctx, bucket, file)
+	}
+
+	shared.NoteIngestSource(ctx, file)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return data, nil
+}
+
+// archiveFormat returns "parquet" or "json" based on the file extension, or "" for anything else.
+func archiveFormat(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".parquet":
+		return "parquet"
+	case ".json":
+		return "json"
+	default:
+		return ""
+	}
+}
+
+// decodeRaw parses an archived file holding SODA records of type T.
+func decodeRaw[T any](data []byte, format string) ([]T, error) {
+	switch format {
+	case "parquet":
+		records, err := parquet.Read[T](bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode parquet: %w", err)
+		}
+		return records, nil
+	case "json":
+		records, stats, err := shared.DecodeSODARecords[T](data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode json: %w", err)
+		}
+		log.Printf("json decode stats: %s", stats)
+		return records, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+}
+
+func replayerNames() []string {
+	names := make([]string, 0, len(replayers))
+	for name := range replayers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
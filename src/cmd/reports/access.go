@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/ahbreck/Chicago_BI/reportspb"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// internalAPITokensEnvKey lists the bearer tokens, comma separated, that grant the internal role. A token
+// may be given as name:token to name its holder in the audit log. Without any, internal endpoints are
+// refused.
+const internalAPITokensEnvKey = "INTERNAL_API_TOKENS"
+
+// accessRole is the role needed to call an endpoint. Public callers see aggregated report tables; internal
+// callers, who present a token from INTERNAL_API_TOKENS, may also read row-level tables such as individual
+// trips and permits.
+type accessRole int
+
+const (
+	rolePublic accessRole = iota
+	roleInternal
+)
+
+func (r accessRole) String() string {
+	if r == roleInternal {
+		return "internal"
+	}
+	return "public"
+}
+
+// grpcMethodRoles tags the gRPC methods that need more than the public role.
+var grpcMethodRoles = map[string]accessRole{
+	reportspb.Reports_StreamCovidAlerts_FullMethodName: roleInternal,
+}
+
+// accessControl resolves the caller's role from the Authorization header and guards endpoints tagged with
+// a role. The caller's role is kept in the request context so handlers serving tables of both kinds, such
+// as /graphql, can check it per table. Calls to internal endpoints, refused ones included, and calls made
+// with an internal token are recorded in the api_audit table.
+type accessControl struct {
+	db     *sql.DB
+	tokens []apiToken
+}
+
+type apiToken struct {
+	name  string
+	value string
+}
+
+type accessRoleKey struct{}
+
+func newAccessControl(db *sql.DB) *accessControl {
+	var tokens []apiToken
+	for _, entry := range strings.Split(os.Getenv(internalAPITokensEnvKey), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		token := apiToken{name: fmt.Sprintf("internal-token-%d", len(tokens)+1), value: entry}
+		if name, value, found := strings.Cut(entry, ":"); found {
+			token = apiToken{name: strings.TrimSpace(name), value: strings.TrimSpace(value)}
+		}
+		if token.value == "" {
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	if len(tokens) == 0 {
+		log.Printf("%s is not set; internal report endpoints are disabled", internalAPITokensEnvKey)
+	}
+	return &accessControl{db: db, tokens: tokens}
+}
+
+// handle registers handler for pattern on mux, open to callers with at least role.
+func (a *accessControl) handle(mux *http.ServeMux, pattern string, role accessRole, handler http.HandlerFunc) {
+	audited := shared.AuditHandler(a.db, "reports", a.identity, handler)
+	if role == roleInternal {
+		mux.HandleFunc(pattern, shared.AuditHandler(a.db, "reports", a.identity, a.require(role, handler, handler)))
+		return
+	}
+	mux.HandleFunc(pattern, a.require(role, handler, audited))
+}
+
+// require answers callers below role with a 401 and passes the others on, internal callers to internal.
+func (a *accessControl) require(role accessRole, public, internal http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, _ := a.caller(r.Header.Get("Authorization"))
+		if caller < role {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="reports"`)
+			http.Error(w, "this endpoint requires an internal API token", http.StatusUnauthorized)
+			return
+		}
+		next := public
+		if caller == roleInternal {
+			next = internal
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), accessRoleKey{}, caller)))
+	}
+}
+
+// caller maps an Authorization header to a role and, for internal tokens, the token's name. Other bearer
+// tokens, such as the ID tokens Cloud Run's invoker check passes through, leave the caller public.
+func (a *accessControl) caller(header string) (role accessRole, name string) {
+	token, found := strings.CutPrefix(header, "Bearer ")
+	if !found {
+		return rolePublic, ""
+	}
+	for _, known := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(known.value)) == 1 {
+			return roleInternal, known.name
+		}
+	}
+	return rolePublic, ""
+}
+
+// identity names the caller in the audit log: the internal token's name, or shared.RequestIdentity.
+func (a *accessControl) identity(r *http.Request) string {
+	if _, name := a.caller(r.Header.Get("Authorization")); name != "" {
+		return name
+	}
+	return shared.RequestIdentity(r)
+}
+
+// requestRole returns the caller's role stored by accessControl, defaulting to public.
+func requestRole(ctx context.Context) accessRole {
+	if role, ok := ctx.Value(accessRoleKey{}).(accessRole); ok {
+		return role
+	}
+	return rolePublic
+}
+
+// grpcServerOptions guards the gRPC methods in grpcMethodRoles with the same tokens, read from the
+// authorization metadata.
+func (a *accessControl) grpcServerOptions() []grpc.ServerOption {
+	authorize := func(ctx context.Context, method string) (context.Context, error) {
+		var header string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("authorization"); len(values) > 0 {
+				header = values[0]
+			}
+		}
+		caller, _ := a.caller(header)
+		if caller < grpcMethodRoles[method] {
+			return nil, status.Error(codes.Unauthenticated, "this method requires an internal API token")
+		}
+		return context.WithValue(ctx, accessRoleKey{}, caller), nil
+	}
+
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			ctx, err := authorize(ctx, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if _, err := authorize(stream.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	}
+}
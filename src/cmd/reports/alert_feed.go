@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	// covidAlertTransitionsTable records every week a ZIP code entered the high covid category. It is kept
+	// across builds, so each transition is published once.
+	covidAlertTransitionsTable = "covid_alert_transitions"
+
+	// driverAlertWebhooksEnvKey lists comma-separated URLs that receive a JSON POST for every new transition,
+	// e.g. taxi dispatch systems. Unset disables the push.
+	driverAlertWebhooksEnvKey = "DRIVER_ALERT_WEBHOOK_URLS"
+	// driverAlertSecretEnvKey signs the pushed bodies with HMAC-SHA256 in the X-Signature-256 header.
+	driverAlertSecretEnvKey = "DRIVER_ALERT_WEBHOOK_SECRET"
+
+	// covidAlertFeedEntries is how many of the latest transitions the feeds list.
+	covidAlertFeedEntries = 50
+	covidAlertFeedTitle   = "Chicago BI: ZIP codes entering the high COVID category"
+)
+
+var driverAlertClient = &http.Client{Timeout: 15 * time.Second}
+
+// covidAlertTransition is one week a ZIP code entered the high covid category, as pushed to webhooks.
+type covidAlertTransition struct {
+	ZipCode   string `json:"zip_code"`
+	WeekStart string `json:"week_start"`
+	// PreviousCategory is the category of the ZIP code's previous week, null for its first week or a week
+	// without a case rate.
+	PreviousCategory *string   `json:"previous_cat"`
+	Category         string    `json:"covid_cat"`
+	CaseRate         *float64  `json:"case_rate_weekly"`
+	DetectedAt       time.Time `json:"detected_at"`
+}
+
+// ensureCovidAlertTransitionsTable creates covid_alert_transitions when it does not exist.
+func ensureCovidAlertTransitionsTable(db *sql.DB) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		"zip_code" VARCHAR(9) NOT NULL,
+		"week_start" DATE NOT NULL,
+		"previous_cat" VARCHAR(6),
+		"covid_cat" VARCHAR(6) NOT NULL,
+		"case_rate_weekly" FLOAT8,
+		"detected_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+		"pushed_at" TIMESTAMP WITH TIME ZONE,
+		PRIMARY KEY ("zip_code", "week_start")
+	)`, quoteIdentifier(covidAlertTransitionsTable))
+	if _, err := db.Exec(stmt); err != nil {
+		return fmt.Errorf("failed to create %s: %w", covidAlertTransitionsTable, err)
+	}
+	return nil
+}
+
+// publishCovidAlerts records the weeks in covid_rep_cats where a ZIP code entered the high category, from
+// any other category or none, and pushes the transitions not pushed yet to
+// DRIVER_ALERT_WEBHOOK_URLS. A transition is marked pushed once every webhook accepted it, so a failed push
+// is retried after the next build. The transitions found by the first run are history and are not pushed,
+// nor are those of smoke test schemas.
+func publishCovidAlerts(db *sql.DB) error {
+	if err := ensureCovidAlertTransitionsTable(db); err != nil {
+		return err
+	}
+
+	var recorded bool
+	if err := db.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s)`, quoteIdentifier(covidAlertTransitionsTable))).Scan(&recorded); err != nil {
+		return fmt.Errorf("failed to read %s: %w", covidAlertTransitionsTable, err)
+	}
+
+	result, err := db.Exec(fmt.Sprintf(`INSERT INTO %[1]s ("zip_code", "week_start", "previous_cat", "covid_cat", "case_rate_weekly", "detected_at")
+		SELECT "zip_code", "week_start", previous_cat, covid_cat, "case_rate_weekly", NOW()
+		FROM (
+			SELECT "zip_code", "week_start", covid_cat, "case_rate_weekly",
+				LAG(covid_cat) OVER (PARTITION BY "zip_code" ORDER BY "week_start") AS previous_cat
+			FROM %[2]s
+		) weeks
+		WHERE covid_cat = 'high' AND previous_cat IS DISTINCT FROM 'high'
+		ON CONFLICT DO NOTHING`, quoteIdentifier(covidAlertTransitionsTable), quoteIdentifier(covidRepCatsTable)))
+	if err != nil {
+		return fmt.Errorf("failed to record covid alert transitions: %w", err)
+	}
+	if added, err := result.RowsAffected(); err == nil && added > 0 {
+		log.Printf("recorded %d new covid alert transitions", added)
+	}
+
+	webhooks := driverAlertWebhooks()
+	var schema string
+	if err := db.QueryRow(`SELECT current_schema()`).Scan(&schema); err != nil {
+		return fmt.Errorf("failed to read the current schema: %w", err)
+	}
+	if !recorded || len(webhooks) == 0 || shared.IsSmokeSchema(schema) {
+		// Nothing to deliver them to, so they are not left pending for a webhook configured later.
+		_, err := db.Exec(fmt.Sprintf(`UPDATE %s SET "pushed_at" = NOW() WHERE "pushed_at" IS NULL`, quoteIdentifier(covidAlertTransitionsTable)))
+		if err != nil {
+			return fmt.Errorf("failed to mark covid alert transitions pushed: %w", err)
+		}
+		return nil
+	}
+
+	pending, err := readCovidAlertTransitions(db, `WHERE "pushed_at" IS NULL ORDER BY "week_start", "zip_code"`)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, transition := range pending {
+		if err := pushCovidAlert(webhooks, transition); err != nil {
+			log.Printf("failed to push covid alert for %s, week of %s: %v", transition.ZipCode, transition.WeekStart, err)
+			failed++
+			continue
+		}
+		_, err := db.Exec(fmt.Sprintf(`UPDATE %s SET "pushed_at" = NOW() WHERE "zip_code" = $1 AND "week_start" = $2`, quoteIdentifier(covidAlertTransitionsTable)),
+			transition.ZipCode, transition.WeekStart)
+		if err != nil {
+			return fmt.Errorf("failed to mark covid alert transition pushed: %w", err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to push %d of %d covid alerts; they are retried after the next build", failed, len(pending))
+	}
+	if len(pending) > 0 {
+		log.Printf("pushed %d covid alerts to %d webhooks", len(pending), len(webhooks))
+	}
+	return nil
+}
+
+// driverAlertWebhooks parses DRIVER_ALERT_WEBHOOK_URLS.
+func driverAlertWebhooks() []string {
+	var webhooks []string
+	for _, webhook := range strings.Split(os.Getenv(driverAlertWebhooksEnvKey), ",") {
+		if webhook = strings.TrimSpace(webhook); webhook != "" {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks
+}
+
+// pushCovidAlert posts transition as JSON to every webhook, returning the failures joined together.
+func pushCovidAlert(webhooks []string, transition covidAlertTransition) error {
+	body, err := json.Marshal(transition)
+	if err != nil {
+		return fmt.Errorf("failed to encode covid alert: %w", err)
+	}
+
+	var signature string
+	if secret := os.Getenv(driverAlertSecretEnvKey); secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	var failures []string
+	for _, webhook := range webhooks {
+		if err := postCovidAlert(webhook, body, signature); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func postCovidAlert(webhook string, body []byte, signature string) error {
+	req, err := http.NewRequest(http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if signature != "" {
+		req.Header.Set("X-Signature-256", signature)
+	}
+
+	resp, err := driverAlertClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// readCovidAlertTransitions reads the transitions selected by clause, which follows the FROM.
+func readCovidAlertTransitions(db *sql.DB, clause string, args ...any) ([]covidAlertTransition, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT "zip_code", "week_start"::text, "previous_cat", "covid_cat", "case_rate_weekly", "detected_at"
+		FROM %s %s`, quoteIdentifier(covidAlertTransitionsTable), clause), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", covidAlertTransitionsTable, err)
+	}
+	defer rows.Close()
+
+	var transitions []covidAlertTransition
+	for rows.Next() {
+		var t covidAlertTransition
+		if err := rows.Scan(&t.ZipCode, &t.WeekStart, &t.PreviousCategory, &t.Category, &t.CaseRate, &t.DetectedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan %s row: %w", covidAlertTransitionsTable, err)
+		}
+		transitions = append(transitions, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading %s: %w", covidAlertTransitionsTable, err)
+	}
+	return transitions, nil
+}
+
+// atomFeed and the types below are the parts of an Atom feed (RFC 4287) the covid alert feed uses.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID       string       `xml:"id"`
+	Title    string       `xml:"title"`
+	Updated  string       `xml:"updated"`
+	Summary  string       `xml:"summary"`
+	Category atomCategory `xml:"category"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// rssFeed and the types below are the parts of an RSS 2.0 feed the covid alert feed uses.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Category    string  `xml:"category"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// covidAlertFeedHandler serves the latest transitions of ZIP codes into the high covid category as an Atom
+// (format "atom") or RSS 2.0 (format "rss") feed, newest first. ?zip=60614,60622 limits it to some ZIP codes.
+func covidAlertFeedHandler(db *sql.DB, format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clause := `ORDER BY "week_start" DESC, "zip_code" LIMIT $1`
+		args := []any{covidAlertFeedEntries}
+		if raw := r.URL.Query().Get("zip"); raw != "" {
+			zips := strings.Split(raw, ",")
+			for i, zip := range zips {
+				zips[i] = strings.TrimSpace(zip)
+				if !zipCodePattern.MatchString(zips[i]) {
+					writeQueryError(w, covidAlertTransitionsTable, invalidQueryError{param: "zip", message: "zip must be a comma-separated list of five-digit ZIP codes"})
+					return
+				}
+			}
+			clause = `WHERE "zip_code" = ANY($2) ` + clause
+			args = append(args, pq.Array(zips))
+		}
+
+		transitions, err := readCovidAlertTransitions(db, clause, args...)
+		if err != nil {
+			writeQueryError(w, covidAlertTransitionsTable, err)
+			return
+		}
+
+		self := feedURL(r)
+		updated := time.Now()
+		if len(transitions) > 0 {
+			updated = transitions[0].DetectedAt
+			for _, t := range transitions {
+				if t.DetectedAt.After(updated) {
+					updated = t.DetectedAt
+				}
+			}
+		}
+
+		var feed any
+		contentType := "application/atom+xml; charset=utf-8"
+		if format == "rss" {
+			channel := rssChannel{
+				Title:         covidAlertFeedTitle,
+				Link:          self,
+				Description:   "Weeks in which a ZIP code's weekly COVID case rate entered the high category.",
+				LastBuildDate: updated.Format(time.RFC1123Z),
+			}
+			for _, t := range transitions {
+				channel.Items = append(channel.Items, rssItem{
+					Title:       covidAlertTitle(t),
+					Description: covidAlertSummary(t),
+					GUID:        rssGUID{Value: covidAlertID(t)},
+					PubDate:     t.DetectedAt.Format(time.RFC1123Z),
+					Category:    t.ZipCode,
+				})
+			}
+			feed = rssFeed{Version: "2.0", Channel: channel}
+			contentType = "application/rss+xml; charset=utf-8"
+		} else {
+			atom := atomFeed{
+				Title:   covidAlertFeedTitle,
+				ID:      self,
+				Updated: updated.UTC().Format(time.RFC3339),
+				Link:    atomLink{Rel: "self", Href: self},
+				Author:  atomAuthor{Name: "Chicago BI"},
+			}
+			for _, t := range transitions {
+				atom.Entries = append(atom.Entries, atomEntry{
+					ID:       covidAlertID(t),
+					Title:    covidAlertTitle(t),
+					Updated:  t.DetectedAt.UTC().Format(time.RFC3339),
+					Summary:  covidAlertSummary(t),
+					Category: atomCategory{Term: t.ZipCode},
+				})
+			}
+			feed = atom
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(feed); err != nil {
+			log.Printf("failed to write %s feed: %v", covidAlertTransitionsTable, err)
+		}
+	}
+}
+
+// feedURL is the absolute URL r was made for, honoring the X-Forwarded-Proto set by Cloud Run.
+func feedURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+func covidAlertID(t covidAlertTransition) string {
+	return fmt.Sprintf("urn:chicago-bi:covid-alert:%s:%s", t.ZipCode, t.WeekStart)
+}
+
+func covidAlertTitle(t covidAlertTransition) string {
+	return fmt.Sprintf("ZIP %s entered the high COVID category in the week of %s", t.ZipCode, t.WeekStart)
+}
+
+func covidAlertSummary(t covidAlertTransition) string {
+	summary := "The previous week has no category."
+	if t.PreviousCategory != nil {
+		summary = fmt.Sprintf("The previous week was %s.", *t.PreviousCategory)
+	}
+	if t.CaseRate != nil {
+		summary = fmt.Sprintf("Weekly case rate %.1f. %s", *t.CaseRate, summary)
+	}
+	return summary
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	anomaliesTable = "anomalies"
+
+	// anomalySigmaEnvKey sets how many standard deviations from the baseline a week must be to be flagged.
+	anomalySigmaEnvKey = "ANOMALY_SIGMA"
+	// anomalyBaselineWeeksEnvKey sets how many preceding weeks form the baseline.
+	anomalyBaselineWeeksEnvKey = "ANOMALY_BASELINE_WEEKS"
+
+	defaultAnomalySigma         = 3.0
+	defaultAnomalyBaselineWeeks = 8
+	// anomalyMinBaselineWeeks is the fewest preceding weeks a ZIP code needs before it is scored.
+	anomalyMinBaselineWeeks = 4
+	// anomalyAlertLines caps how many anomalies are listed in one alert.
+	anomalyAlertLines = 20
+)
+
+// anomaliesReportSources maps the table built by CreateAnomaliesReport to the collector tables it reads.
+var anomaliesReportSources = map[string][]string{
+	anomaliesTable: {covidTable, taxiTripsTable},
+}
+
+// anomaly is one newly flagged row of the anomalies table.
+type anomaly struct {
+	metric       string
+	geographyID  string
+	periodStart  time.Time
+	value        float64
+	baselineMean float64
+	baselineSD   float64
+	zScore       float64
+	// holidays names the holidays in the week, empty for weeks without any.
+	holidays string
+}
+
+// CreateAnomaliesReport scores weekly trips per ZIP code and weekly COVID case rates against their recent
+// baselines, records deviations beyond ANOMALY_SIGMA in the anomalies table, and sends an alert listing the
+// anomalies this refresh found. It reads the weekly trip tables, so it runs after the covid category report.
+func CreateAnomaliesReport(db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if err := ensureTableReady(db, covidTable); err != nil {
+		return err
+	}
+	if err := ensureTableReady(db, weeklyPickupTable); err != nil {
+		return err
+	}
+
+	if err := ensureHolidaysTable(db); err != nil {
+		return err
+	}
+
+	baselineWeeks := anomalyBaselineWeeks()
+	statements, err := renderStatements("anomalies_report.sql", map[string]string{
+		"Target":           quoteIdentifier(anomaliesTable),
+		"WeeklyPickup":     quoteIdentifier(weeklyPickupTable),
+		"Covid":            quoteIdentifier(covidTable),
+		"Holidays":         quoteIdentifier(holidaysTable),
+		"Sigma":            strconv.FormatFloat(anomalySigma(), 'f', -1, 64),
+		"BaselineWeeks":    strconv.Itoa(baselineWeeks),
+		"MinBaselineWeeks": strconv.Itoa(min(anomalyMinBaselineWeeks, baselineWeeks)),
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start anomalies report transaction: %w", err)
+	}
+
+	if err := execStatements(tx, "anomalies_report.sql", statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// detected_at defaults to NOW(), the start time of this transaction, so it identifies the rows just added.
+	found, err := newAnomalies(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to commit anomalies report transaction: %w", err)
+	}
+
+	if len(found) > 0 {
+		log.Printf("flagged %d new anomalies", len(found))
+		if err := shared.Notify(context.Background(), fmt.Sprintf("Chicago BI: %d new anomalies detected", len(found)), formatAnomalies(found)); err != nil {
+			log.Printf("failed to send anomaly alert: %v", err)
+		}
+	}
+	return nil
+}
+
+func newAnomalies(tx *sql.Tx) ([]anomaly, error) {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT "metric", "geography_id", "period_start", "value", "baseline_mean", "baseline_stddev", "z_score",
+	COALESCE("holidays", '')
+FROM %s
+WHERE "detected_at" = NOW()
+ORDER BY ABS("z_score") DESC`, quoteIdentifier(anomaliesTable)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query new anomalies: %w", err)
+	}
+	defer rows.Close()
+
+	var found []anomaly
+	for rows.Next() {
+		var a anomaly
+		if err := rows.Scan(&a.metric, &a.geographyID, &a.periodStart, &a.value, &a.baselineMean, &a.baselineSD, &a.zScore, &a.holidays); err != nil {
+			return nil, fmt.Errorf("failed to scan anomaly: %w", err)
+		}
+		found = append(found, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading anomalies: %w", err)
+	}
+	return found, nil
+}
+
+// formatAnomalies lists the strongest anomalies one per line, e.g.
+// "weekly_trips_per_zip ZIP 60607 week of 2022-03-06: 1240 vs baseline 410 ± 52 (z=16.0)", followed by the
+// week's holidays, e.g. "[Thanksgiving]", when it has any.
+func formatAnomalies(found []anomaly) string {
+	var b strings.Builder
+	for i, a := range found {
+		if i == anomalyAlertLines {
+			fmt.Fprintf(&b, "... and %d more in the %s table\n", len(found)-i, anomaliesTable)
+			break
+		}
+		fmt.Fprintf(&b, "%s ZIP %s week of %s: %.1f vs baseline %.1f ± %.1f (z=%.1f)",
+			a.metric, a.geographyID, a.periodStart.Format("2006-01-02"), a.value, a.baselineMean, a.baselineSD, a.zScore)
+		if a.holidays != "" {
+			fmt.Fprintf(&b, " [%s]", a.holidays)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func anomalySigma() float64 {
+	raw := strings.TrimSpace(os.Getenv(anomalySigmaEnvKey))
+	if raw == "" {
+		return defaultAnomalySigma
+	}
+
+	sigma, err := strconv.ParseFloat(raw, 64)
+	if err != nil || sigma <= 0 {
+		log.Printf("invalid %s value %q; defaulting to %g", anomalySigmaEnvKey, raw, defaultAnomalySigma)
+		return defaultAnomalySigma
+	}
+	return sigma
+}
+
+func anomalyBaselineWeeks() int {
+	raw := strings.TrimSpace(os.Getenv(anomalyBaselineWeeksEnvKey))
+	if raw == "" {
+		return defaultAnomalyBaselineWeeks
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 2 {
+		log.Printf("invalid %s value %q; defaulting to %d", anomalyBaselineWeeksEnvKey, raw, defaultAnomalyBaselineWeeks)
+		return defaultAnomalyBaselineWeeks
+	}
+	return n
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// errAssertionsFailed is returned by runReport when a report was built but breaks one of its assertions.
+// It is in the shared.ErrValidation category.
+var errAssertionsFailed = shared.Invalid(errors.New("report assertions failed"))
+
+// reportAssertion is an invariant of a report table, checked after every build of the report. Its query
+// returns one number, which ok judges; a failure is described by detail with that number.
+type reportAssertion struct {
+	name   string
+	query  string
+	ok     func(value int64) bool
+	detail string
+	// volume marks assertions that only hold on complete source data, such as row counts. Smoke test
+	// schemas, loaded with a handful of rows, skip them.
+	volume bool
+}
+
+// assertNotNull asserts that no row of table has a NULL column.
+func assertNotNull(table, column string) reportAssertion {
+	return reportAssertion{
+		name:   fmt.Sprintf("%s.%s not null", table, column),
+		query:  fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s IS NULL`, quoteIdentifier(table), quoteIdentifier(column)),
+		ok:     isZero,
+		detail: "%d rows are NULL",
+	}
+}
+
+// assertNonNegative asserts that no row of table has a negative column.
+func assertNonNegative(table, column string) reportAssertion {
+	return reportAssertion{
+		name:   fmt.Sprintf("%s.%s >= 0", table, column),
+		query:  fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s < 0`, quoteIdentifier(table), quoteIdentifier(column)),
+		ok:     isZero,
+		detail: "%d rows are negative",
+	}
+}
+
+// assertAcceptedValues asserts that column holds only NULL or one of values.
+func assertAcceptedValues(table, column string, values ...string) reportAssertion {
+	literals := make([]string, len(values))
+	for i, value := range values {
+		literals[i] = pq.QuoteLiteral(value)
+	}
+	return reportAssertion{
+		name: fmt.Sprintf("%s.%s in (%s)", table, column, strings.Join(values, ", ")),
+		query: fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s NOT IN (%s)`,
+			quoteIdentifier(table), quoteIdentifier(column), strings.Join(literals, ", ")),
+		ok:     isZero,
+		detail: "%d rows hold another value",
+	}
+}
+
+// assertUnique asserts that no two rows of table share a column value.
+func assertUnique(table, column string) reportAssertion {
+	return reportAssertion{
+		name: fmt.Sprintf("%s.%s unique", table, column),
+		query: fmt.Sprintf(`SELECT COUNT(*) FROM (SELECT %[2]s FROM %[1]s GROUP BY %[2]s HAVING COUNT(*) > 1) duplicates`,
+			quoteIdentifier(table), quoteIdentifier(column)),
+		ok:     isZero,
+		detail: "%d values appear more than once",
+	}
+}
+
+// assertRowCount asserts that table has exactly rows rows.
+func assertRowCount(table string, rows int64) reportAssertion {
+	return reportAssertion{
+		name:   fmt.Sprintf("%s has %d rows", table, rows),
+		query:  fmt.Sprintf(`SELECT COUNT(*) FROM %s`, quoteIdentifier(table)),
+		ok:     func(value int64) bool { return value == rows },
+		detail: "found %d rows",
+		volume: true,
+	}
+}
+
+func isZero(value int64) bool {
+	return value == 0
+}
+
+// checkReportAssertions runs the assertions of job against its freshly built tables and returns a
+// description of each one that failed. An assertion whose query fails counts as failed.
+func checkReportAssertions(db *sql.DB, job reportJob) []string {
+	if len(job.assertions) == 0 {
+		return nil
+	}
+
+	var schema string
+	if err := db.QueryRow(`SELECT current_schema()`).Scan(&schema); err != nil {
+		log.Printf("failed to read the current schema: %v", err)
+	}
+	smoke := shared.IsSmokeSchema(schema)
+
+	var failures []string
+	for _, assertion := range job.assertions {
+		if assertion.volume && smoke {
+			continue
+		}
+
+		var value int64
+		if err := db.QueryRow(assertion.query).Scan(&value); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", assertion.name, err))
+			continue
+		}
+		if !assertion.ok(value) {
+			failures = append(failures, fmt.Sprintf("%s: "+assertion.detail, assertion.name, value))
+		}
+	}
+	return failures
+}
+
+// reportAssertionFailures logs the failed assertions of job, alerts on them, and returns them as an error
+// wrapping errAssertionsFailed.
+func reportAssertionFailures(job reportJob, failures []string) error {
+	for _, failure := range failures {
+		log.Printf("%s report assertion failed: %s", job.name, failure)
+	}
+
+	subject := fmt.Sprintf("Chicago BI: %s report failed %d of %d assertions", job.name, len(failures), len(job.assertions))
+	if err := shared.Notify(context.Background(), subject, strings.Join(failures, "\n")); err != nil {
+		log.Printf("failed to send assertion alert: %v", err)
+	}
+	return fmt.Errorf("%s: %w: %s", job.name, errAssertionsFailed, strings.Join(failures, "; "))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// auditEntryRow is one row of the api_audit table as served by /api/audit.
+type auditEntryRow struct {
+	CalledAt     time.Time `json:"called_at"`
+	Service      string    `json:"service"`
+	Identity     string    `json:"identity"`
+	Method       string    `json:"method"`
+	Endpoint     string    `json:"endpoint"`
+	Parameters   string    `json:"parameters"`
+	Body         string    `json:"body"`
+	Status       int       `json:"status"`
+	DurationMS   int64     `json:"duration_ms"`
+	BuildVersion string    `json:"build_version"`
+}
+
+// auditLogParams are the filters, sort keys, and paging of /api/audit. from and to compare the day of
+// called_at.
+var auditLogParams = listParams{
+	sorts: map[string]string{
+		"id":        `"id"`,
+		"called_at": `"called_at"`,
+		"identity":  `"identity"`,
+		"endpoint":  `"endpoint"`,
+		"status":    `"status"`,
+	},
+	defaultSort: "-called_at,-id",
+	filters: []listFilter{
+		{param: "service", kind: filterText, columns: []string{"service"}, values: []string{"collectors", "reports"}},
+		{param: "identity", kind: filterText, columns: []string{"identity"}},
+		{param: "endpoint", kind: filterText, columns: []string{"endpoint"}},
+		{param: "from", kind: filterDate, columns: []string{"called_at"}, op: ">="},
+		{param: "to", kind: filterDate, columns: []string{"called_at"}, op: "<="},
+	},
+	defaultLimit: defaultListLimit,
+}
+
+// auditLogHandler serves /api/audit, the newest api_audit rows first; see auditLogParams for its parameters.
+func auditLogHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := auditLogParams.parse(r.URL.Query())
+		if err != nil {
+			writeQueryError(w, shared.AuditTable, err)
+			return
+		}
+
+		statement, args := query.withLookahead().build(`"called_at", "service", "identity", "method", "endpoint", "parameters",
+			"body", "status", "duration_ms", "build_version"`, shared.AuditTable)
+		rows, err := db.QueryContext(r.Context(), statement, args...)
+		if err != nil {
+			writeQueryError(w, shared.AuditTable, err)
+			return
+		}
+		defer rows.Close()
+
+		entries := []auditEntryRow{}
+		for rows.Next() {
+			var entry auditEntryRow
+			if err := rows.Scan(&entry.CalledAt, &entry.Service, &entry.Identity, &entry.Method, &entry.Endpoint, &entry.Parameters,
+				&entry.Body, &entry.Status, &entry.DurationMS, &entry.BuildVersion); err != nil {
+				writeQueryError(w, shared.AuditTable, err)
+				return
+			}
+			entries = append(entries, entry)
+		}
+		if err := rows.Err(); err != nil {
+			writeQueryError(w, shared.AuditTable, err)
+			return
+		}
+
+		writeListPage(w, r, shared.AuditTable, query, entries)
+	}
+}
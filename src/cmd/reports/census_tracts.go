@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	permitsByTractTable = "permits_by_census_tract"
+	tripsByTractTable   = "trips_by_census_tract"
+	// censusTractBoundariesTable is the temporary table CreateCensusTractReport loads the tract boundaries
+	// into when the collectors have not loaded them.
+	censusTractBoundariesTable = "report_census_tract_boundaries"
+)
+
+// censusTractReportSources maps the tables built by CreateCensusTractReport to the collector tables they
+// read. The tract boundaries come from a spatial dataset, so they are not listed.
+var censusTractReportSources = map[string][]string{
+	permitsByTractTable: {buildingPermits},
+	tripsByTractTable:   {taxiTripsTable},
+}
+
+// censusTractReportAssertions are the invariants of the tables built by CreateCensusTractReport.
+var censusTractReportAssertions = []reportAssertion{
+	assertNotNull(permitsByTractTable, "census_tract"),
+	assertNotNull(tripsByTractTable, "census_tract"),
+	assertNonNegative(tripsByTractTable, "pickups"),
+	assertNonNegative(tripsByTractTable, "dropoffs"),
+}
+
+// CreateCensusTractReport rebuilds permits_by_census_tract, the building permits per census tract and
+// permit category, and trips_by_census_tract, the weekly pickups and dropoffs per census tract, placing
+// permits and trips in the tract boundaries with PostGIS. Both give their counts per 1,000 residents of the
+// tract.
+func CreateCensusTractReport(db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if err := ensureTableReady(db, buildingPermits); err != nil {
+		return err
+	}
+	if err := ensureTableReady(db, taxiTripsTable); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start census tract report transaction: %w", err)
+	}
+
+	if err := shared.UsePostGIS(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	tracts, err := boundaryTable(tx, shared.CensusTractBoundaries.Table, censusTractBoundariesTable,
+		shared.CensusTractsDataset, shared.CensusTractBoundaries.KeyColumn, shared.CensusTractBoundaries.KeyProperty)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	statements, err := renderStatements("census_tract_report.sql", map[string]string{
+		"Permits":         quoteIdentifier(permitsByTractTable),
+		"Trips":           quoteIdentifier(tripsByTractTable),
+		"BuildingPermits": quoteIdentifier(buildingPermits),
+		"TaxiTrips":       quoteIdentifier(taxiTripsTable),
+		"Tracts":          quoteIdentifier(tracts),
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := execStatements(tx, "census_tract_report.sql", statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := addPerCapitaColumns(tx, permitsByTractTable, datasets.PopulationCensusTract, "census_tract", "permits"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := addPerCapitaColumns(tx, tripsByTractTable, datasets.PopulationCensusTract, "census_tract", "pickups", "dropoffs"); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to commit census tract report transaction: %w", err)
+	}
+
+	return nil
+}
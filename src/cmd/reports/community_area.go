@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// communityAreaCount is the number of Chicago community areas, numbered from 1.
+	communityAreaCount = 77
+	// communityAreaRecentWeeks is how many of the latest weeks of trip data are summarized.
+	communityAreaRecentWeeks = 4
+)
+
+// communityAreaProfile is the /api/community-area/{id} response.
+type communityAreaProfile struct {
+	CommunityArea     string                   `json:"community_area"`
+	Name              *string                  `json:"name"`
+	Socioeconomic     *communityAreaIndicators `json:"socioeconomic"`
+	CCVI              *communityAreaCCVI       `json:"ccvi"`
+	PermitsByCategory map[string]int64         `json:"permits_by_category"`
+	RecentTrips       []communityAreaWeek      `json:"recent_trips"`
+	Disadvantaged     *communityAreaFlags      `json:"disadvantaged"`
+}
+
+type communityAreaIndicators struct {
+	BelowPovertyLevel *float64 `json:"below_poverty_level"`
+	Unemployment      *float64 `json:"unemployment"`
+	PerCapitaIncome   *float64 `json:"per_capita_income"`
+	SourcePeriod      *string  `json:"source_period"`
+}
+
+type communityAreaCCVI struct {
+	Score    *float64 `json:"score"`
+	Category *string  `json:"category"`
+}
+
+type communityAreaWeek struct {
+	WeekStart string `json:"week_start"`
+	Pickups   int64  `json:"pickups"`
+	Dropoffs  int64  `json:"dropoffs"`
+}
+
+type communityAreaFlags struct {
+	Top5Poverty      bool     `json:"top_5_poverty"`
+	Top5Unemployment bool     `json:"top_5_unemployment"`
+	CompositeScore   *float64 `json:"composite_score"`
+	CompositeRank    *int64   `json:"composite_rank"`
+	Disadvantaged    bool     `json:"disadvantaged"`
+}
+
+// communityAreaHandler serves /api/community-area/{id}: one community area's public health indicators,
+// CCVI, permit counts by category, pickups and dropoffs over the latest weeks of trip data, and
+// disadvantaged flags, assembled from the collector and report tables. Sections without data are null.
+func communityAreaHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil || n < 1 || n > communityAreaCount {
+			http.Error(w, fmt.Sprintf("community area must be a number from 1 to %d", communityAreaCount), http.StatusBadRequest)
+			return
+		}
+		id := strconv.Itoa(n)
+
+		profile, err := readCommunityAreaProfile(db, id)
+		if err != nil {
+			log.Printf("failed to read community area %s: %v", id, err)
+			http.Error(w, "community area data is not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(profile); err != nil {
+			log.Printf("failed to write community area %s response: %v", id, err)
+		}
+	}
+}
+
+func readCommunityAreaProfile(db *sql.DB, id string) (communityAreaProfile, error) {
+	profile := communityAreaProfile{CommunityArea: id, PermitsByCategory: map[string]int64{}, RecentTrips: []communityAreaWeek{}}
+
+	var (
+		indicators    communityAreaIndicators
+		disadvantaged struct {
+			top5Poverty, top5Unemployment, disadvantaged sql.NullBool
+			compositeScore                               *float64
+			compositeRank                                *int64
+		}
+	)
+	err := db.QueryRow(fmt.Sprintf(`SELECT ph."below_poverty_level", ph."unemployment", ph."per_capita_income", ph."source_period",
+			d."top_5_poverty", d."top_5_unemployment", d."composite_score", d."composite_rank", d."disadvantaged"
+		FROM %s ph
+		LEFT JOIN %s d ON d."community_area" = ph."community_area"
+		WHERE ph."community_area" = $1`, quoteIdentifier(publichealthTable), quoteIdentifier(disadvantagedTable)), id).
+		Scan(&indicators.BelowPovertyLevel, &indicators.Unemployment, &indicators.PerCapitaIncome, &indicators.SourcePeriod,
+			&disadvantaged.top5Poverty, &disadvantaged.top5Unemployment, &disadvantaged.compositeScore,
+			&disadvantaged.compositeRank, &disadvantaged.disadvantaged)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return profile, fmt.Errorf("failed to query %s: %w", publichealthTable, err)
+	default:
+		profile.Socioeconomic = &indicators
+		if disadvantaged.disadvantaged.Valid {
+			profile.Disadvantaged = &communityAreaFlags{
+				Top5Poverty:      disadvantaged.top5Poverty.Bool,
+				Top5Unemployment: disadvantaged.top5Unemployment.Bool,
+				CompositeScore:   disadvantaged.compositeScore,
+				CompositeRank:    disadvantaged.compositeRank,
+				Disadvantaged:    disadvantaged.disadvantaged.Bool,
+			}
+		}
+	}
+
+	var ccvi communityAreaCCVI
+	err = db.QueryRow(fmt.Sprintf(`SELECT "community_area_name", "ccvi_score", "ccvi_category" FROM %s
+		WHERE "geography_type" = 'CA' AND "community_area_or_zip" = $1
+		LIMIT 1`, quoteIdentifier(ccviTable)), id).Scan(&profile.Name, &ccvi.Score, &ccvi.Category)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return profile, fmt.Errorf("failed to query %s: %w", ccviTable, err)
+	default:
+		profile.CCVI = &ccvi
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT COALESCE("permit_category", 'other'), COUNT(*) FROM %s
+		WHERE "community_area" = $1
+		GROUP BY 1`, quoteIdentifier(buildingPermits)), id)
+	if err != nil {
+		return profile, fmt.Errorf("failed to query %s: %w", buildingPermits, err)
+	}
+	for rows.Next() {
+		var (
+			category string
+			count    int64
+		)
+		if err := rows.Scan(&category, &count); err != nil {
+			rows.Close()
+			return profile, fmt.Errorf("failed to scan %s row: %w", buildingPermits, err)
+		}
+		profile.PermitsByCategory[category] += count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return profile, fmt.Errorf("error while reading %s: %w", buildingPermits, err)
+	}
+
+	// The weeks are the latest ones in the trip data rather than the calendar, as the collectors load
+	// historical pulls.
+	rows, err = db.Query(fmt.Sprintf(`WITH weeks AS (
+			SELECT generate_series(MAX("week_start") - ($2::int - 1) * 7, MAX("week_start"), INTERVAL '7 days')::date AS "week_start"
+			FROM %[1]s
+		)
+		SELECT w."week_start",
+			COUNT(*) FILTER (WHERE t."pickup_community_area" = $1),
+			COUNT(*) FILTER (WHERE t."dropoff_community_area" = $1)
+		FROM weeks w
+		LEFT JOIN %[1]s t ON t."week_start" = w."week_start"
+			AND (t."pickup_community_area" = $1 OR t."dropoff_community_area" = $1)
+		GROUP BY w."week_start"
+		ORDER BY w."week_start"`, quoteIdentifier(covidAlertsTable)), id, communityAreaRecentWeeks)
+	if err != nil {
+		return profile, fmt.Errorf("failed to query %s: %w", covidAlertsTable, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			week      communityAreaWeek
+			weekStart time.Time
+		)
+		if err := rows.Scan(&weekStart, &week.Pickups, &week.Dropoffs); err != nil {
+			return profile, fmt.Errorf("failed to scan %s row: %w", covidAlertsTable, err)
+		}
+		week.WeekStart = weekStart.Format("2006-01-02")
+		profile.RecentTrips = append(profile.RecentTrips, week)
+	}
+	if err := rows.Err(); err != nil {
+		return profile, fmt.Errorf("error while reading %s: %w", covidAlertsTable, err)
+	}
+
+	return profile, nil
+}
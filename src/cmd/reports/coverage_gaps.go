@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const coverageGapsTable = "coverage_gaps"
+
+// coverageGapsReportSources maps the table built by CreateCoverageGapsReport to the collector tables it reads.
+var coverageGapsReportSources = map[string][]string{
+	coverageGapsTable: {covidTable, ccviTable, publichealthTable, buildingPermits, taxiTripsTable},
+}
+
+// coverageGap is one row of the coverage_gaps table as served by the API.
+type coverageGap struct {
+	GapType       string    `json:"gap_type"`
+	GeographyType string    `json:"geography_type"`
+	GeographyID   string    `json:"geography_id"`
+	PresentIn     string    `json:"present_in"`
+	MissingFrom   string    `json:"missing_from"`
+	DetectedAt    time.Time `json:"detected_at"`
+}
+
+// CreateCoverageGapsReport rebuilds the coverage_gaps table, which lists ZIP codes and community areas
+// that appear in one source dataset but not in another dataset they are joined against.
+func CreateCoverageGapsReport(db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	for _, table := range coverageGapsReportSources[coverageGapsTable] {
+		if err := ensureTableReady(db, table); err != nil {
+			return err
+		}
+	}
+
+	statements, err := renderStatements("coverage_gaps_report.sql", map[string]string{
+		"Target":       quoteIdentifier(coverageGapsTable),
+		"Covid":        quoteIdentifier(covidTable),
+		"CCVI":         quoteIdentifier(ccviTable),
+		"PublicHealth": quoteIdentifier(publichealthTable),
+		"Permits":      quoteIdentifier(buildingPermits),
+		"Trips":        quoteIdentifier(taxiTripsTable),
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start coverage gaps report transaction: %w", err)
+	}
+
+	if err := execStatements(tx, "coverage_gaps_report.sql", statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to commit coverage gaps report transaction: %w", err)
+	}
+
+	return nil
+}
+
+// coverageGapsParams are the filters, sort keys, and paging of /coverage-gaps.
+var coverageGapsParams = listParams{
+	sorts: map[string]string{
+		"gap_type":       `"gap_type"`,
+		"geography_id":   `"geography_id"`,
+		"geography_type": `"geography_type"`,
+		"detected_at":    `"detected_at"`,
+	},
+	defaultSort: "gap_type,geography_id",
+	filters: []listFilter{
+		{param: "gap_type", kind: filterText, columns: []string{"gap_type"}},
+		{param: "geography_type", kind: filterText, columns: []string{"geography_type"}, values: []string{"ZIP", "CA"}},
+	},
+	defaultLimit: defaultListLimit,
+}
+
+// coverageGapsHandler serves the coverage_gaps table as JSON. An optional gap_type query parameter
+// restricts the response to one kind of gap; see coverageGapsParams for the other parameters.
+func coverageGapsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := coverageGapsParams.parse(r.URL.Query())
+		if err != nil {
+			writeQueryError(w, coverageGapsTable, err)
+			return
+		}
+
+		statement, args := query.withLookahead().build(`"gap_type", "geography_type", "geography_id", "present_in", "missing_from", "detected_at"`, coverageGapsTable)
+		rows, err := db.QueryContext(r.Context(), statement, args...)
+		if err != nil {
+			writeQueryError(w, coverageGapsTable, fmt.Errorf("failed to query %s: %w", coverageGapsTable, err))
+			return
+		}
+		defer rows.Close()
+
+		gaps := []coverageGap{}
+		for rows.Next() {
+			var gap coverageGap
+			if err := rows.Scan(&gap.GapType, &gap.GeographyType, &gap.GeographyID, &gap.PresentIn, &gap.MissingFrom, &gap.DetectedAt); err != nil {
+				writeQueryError(w, coverageGapsTable, fmt.Errorf("failed to scan %s row: %w", coverageGapsTable, err))
+				return
+			}
+			gaps = append(gaps, gap)
+		}
+		if err := rows.Err(); err != nil {
+			writeQueryError(w, coverageGapsTable, fmt.Errorf("error while reading %s: %w", coverageGapsTable, err))
+			return
+		}
+
+		writeListPage(w, r, coverageGapsTable, query, gaps)
+	}
+}
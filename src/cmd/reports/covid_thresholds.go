@@ -0,0 +1,250 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// reportParametersTable overrides report parameters without a redeploy: a row named like one of the
+	// environment variables below takes precedence over it for the next report run.
+	reportParametersTable = "report_parameters"
+
+	// covidMediumCaseRateEnvKey and covidHighCaseRateEnvKey are the weekly cases per 100,000 at which a ZIP
+	// code's week becomes medium and high in covid_cat.
+	covidMediumCaseRateEnvKey = "COVID_MEDIUM_CASE_RATE"
+	covidHighCaseRateEnvKey   = "COVID_HIGH_CASE_RATE"
+	// covidMediumPercentPositiveEnvKey and covidHighPercentPositiveEnvKey are the shares of weekly tests,
+	// from 0 to 1, at which a week becomes medium and high in positivity_cat.
+	covidMediumPercentPositiveEnvKey = "COVID_MEDIUM_PERCENT_POSITIVE"
+	covidHighPercentPositiveEnvKey   = "COVID_HIGH_PERCENT_POSITIVE"
+	// covidRiskMatrixEnvKey overrides cells of the rule matrix that combines covid_cat and positivity_cat
+	// into covid_risk, as comma-separated covid_cat/positivity_cat=covid_risk entries such as
+	// "low/high=medium". Cells not listed take the higher of the two categories.
+	covidRiskMatrixEnvKey = "COVID_RISK_MATRIX"
+
+	defaultCovidMediumCaseRate        = 50
+	defaultCovidHighCaseRate          = 100
+	defaultCovidMediumPercentPositive = 0.05
+	defaultCovidHighPercentPositive   = 0.1
+)
+
+// covidBuckets are the cutoffs that sort one metric into the low, medium, and high categories.
+type covidBuckets struct {
+	Metric string  `json:"metric"`
+	Medium float64 `json:"medium"`
+	High   float64 `json:"high"`
+}
+
+// covidThresholds is the definition of the covid categories used by one report run: covid_cat from the case
+// rate, positivity_cat from the percent of tests positive, and covid_risk from the two through RiskMatrix.
+// It is stored with every row of covid_rep_cats as covid_cat_definition.
+type covidThresholds struct {
+	covidBuckets
+	PercentPositive covidBuckets `json:"percent_positive"`
+	// RiskMatrix maps "covid_cat/positivity_cat" to covid_risk.
+	RiskMatrix map[string]string `json:"risk_matrix"`
+}
+
+// loadCovidThresholds reads the covid category definition through lookup, usually reportParameters, falling
+// back to the defaults.
+func loadCovidThresholds(lookup func(name string) (string, error)) (covidThresholds, error) {
+	var (
+		thresholds covidThresholds
+		err        error
+	)
+	thresholds.covidBuckets, err = loadCovidBuckets(lookup, "case_rate_weekly", covidMediumCaseRateEnvKey, covidHighCaseRateEnvKey,
+		defaultCovidMediumCaseRate, defaultCovidHighCaseRate)
+	if err != nil {
+		return thresholds, err
+	}
+	thresholds.PercentPositive, err = loadCovidBuckets(lookup, "percent_tested_positive_weekly", covidMediumPercentPositiveEnvKey,
+		covidHighPercentPositiveEnvKey, defaultCovidMediumPercentPositive, defaultCovidHighPercentPositive)
+	if err != nil {
+		return thresholds, err
+	}
+
+	raw, err := lookup(covidRiskMatrixEnvKey)
+	if err != nil {
+		return thresholds, err
+	}
+	thresholds.RiskMatrix = parseRiskMatrix(raw)
+	return thresholds, nil
+}
+
+// loadCovidBuckets reads the cutoffs of one metric. A pair that does not rise from medium to high falls back
+// to the defaults.
+func loadCovidBuckets(lookup func(name string) (string, error), metric, mediumKey, highKey string, defaultMedium, defaultHigh float64) (covidBuckets, error) {
+	buckets := covidBuckets{Metric: metric}
+
+	var err error
+	if buckets.Medium, err = floatReportParameter(lookup, mediumKey, defaultMedium); err != nil {
+		return buckets, err
+	}
+	if buckets.High, err = floatReportParameter(lookup, highKey, defaultHigh); err != nil {
+		return buckets, err
+	}
+
+	if buckets.Medium >= buckets.High {
+		log.Printf("%s (%g) must be below %s (%g); defaulting to %g and %g", mediumKey, buckets.Medium,
+			highKey, buckets.High, defaultMedium, defaultHigh)
+		buckets.Medium, buckets.High = defaultMedium, defaultHigh
+	}
+	return buckets, nil
+}
+
+// parseRiskMatrix fills the covid_risk rule matrix from COVID_RISK_MATRIX entries. Malformed entries are
+// logged and skipped.
+func parseRiskMatrix(raw string) map[string]string {
+	matrix := make(map[string]string, len(covidCategories)*len(covidCategories))
+	for i, caseCat := range covidCategories {
+		for j, positivityCat := range covidCategories {
+			matrix[caseCat+"/"+positivityCat] = covidCategories[max(i, j)]
+		}
+	}
+
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		cell, risk, found := strings.Cut(entry, "=")
+		cell, risk = strings.ReplaceAll(strings.TrimSpace(cell), " ", ""), strings.TrimSpace(risk)
+		if _, known := matrix[cell]; !found || !known || !slices.Contains(covidCategories, risk) {
+			log.Printf("invalid %s entry %q; expected covid_cat/positivity_cat=covid_risk with categories %s",
+				covidRiskMatrixEnvKey, entry, strings.Join(covidCategories, ", "))
+			continue
+		}
+		matrix[cell] = risk
+	}
+	return matrix
+}
+
+// templateParams renders the thresholds for covid_category_report.sql: the cutoffs as numeric literals, the
+// risk matrix as a CASE expression, and the definition as a quoted JSON literal.
+func (t covidThresholds) templateParams() (map[string]string, error) {
+	definition, err := json.Marshal(t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode covid thresholds: %w", err)
+	}
+
+	cells := make([]string, 0, len(t.RiskMatrix))
+	for cell := range t.RiskMatrix {
+		cells = append(cells, cell)
+	}
+	sort.Strings(cells)
+	riskCase := `CASE "covid_cat" || '/' || "positivity_cat"`
+	for _, cell := range cells {
+		riskCase += fmt.Sprintf(" WHEN %s THEN %s", pq.QuoteLiteral(cell), pq.QuoteLiteral(t.RiskMatrix[cell]))
+	}
+	riskCase += " END"
+
+	return map[string]string{
+		"MediumCaseRate":        strconv.FormatFloat(t.Medium, 'f', -1, 64),
+		"HighCaseRate":          strconv.FormatFloat(t.High, 'f', -1, 64),
+		"MediumPercentPositive": strconv.FormatFloat(t.PercentPositive.Medium, 'f', -1, 64),
+		"HighPercentPositive":   strconv.FormatFloat(t.PercentPositive.High, 'f', -1, 64),
+		"CovidRisk":             riskCase,
+		"CovidCatDefinition":    pq.QuoteLiteral(string(definition)),
+	}, nil
+}
+
+// floatReportParameter returns the non-negative number lookup finds for name, or fallback when it finds none.
+func floatReportParameter(lookup func(name string) (string, error), name string, fallback float64) (float64, error) {
+	raw, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	if raw == "" {
+		return fallback, nil
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || value < 0 {
+		log.Printf("invalid %s value %q; defaulting to %g", name, raw, fallback)
+		return fallback, nil
+	}
+	return value, nil
+}
+
+// intReportParameter returns the positive whole number lookup finds for name, or fallback when it finds none.
+func intReportParameter(lookup func(name string) (string, error), name string, fallback int) (int, error) {
+	raw, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	if raw == "" {
+		return fallback, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		log.Printf("invalid %s value %q; defaulting to %d", name, raw, fallback)
+		return fallback, nil
+	}
+	return value, nil
+}
+
+// ensureReportParametersTable creates report_parameters, empty, on the first report run.
+func ensureReportParametersTable(db *sql.DB) error {
+	createStmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		"name" VARCHAR(255) PRIMARY KEY,
+		"value" VARCHAR(255) NOT NULL,
+		"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	)`, quoteIdentifier(reportParametersTable))
+	if _, err := db.Exec(createStmt); err != nil {
+		return fmt.Errorf("failed to create %s: %w", reportParametersTable, err)
+	}
+	return nil
+}
+
+// reportParameters looks parameters up in report_parameters, which must exist, and then the environment.
+func reportParameters(db *sql.DB) func(name string) (string, error) {
+	return func(name string) (string, error) {
+		var value string
+		err := db.QueryRow(fmt.Sprintf(`SELECT "value" FROM %s WHERE "name" = $1`, quoteIdentifier(reportParametersTable)), name).Scan(&value)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return envParameter(name)
+		case err != nil:
+			return "", fmt.Errorf("failed to read %s from %s: %w", name, reportParametersTable, err)
+		}
+		return strings.TrimSpace(value), nil
+	}
+}
+
+// envParameter looks parameters up in the environment only.
+func envParameter(name string) (string, error) {
+	return strings.TrimSpace(os.Getenv(name)), nil
+}
+
+// latestCovidThresholds returns the definition stored with the current covid_rep_cats, so readers bucket
+// case rates the same way the report did. Before the first build it falls back to the environment, since
+// db may be a read replica without report_parameters.
+func latestCovidThresholds(db *sql.DB) (covidThresholds, error) {
+	var definition []byte
+	err := db.QueryRow(fmt.Sprintf(`SELECT "covid_cat_definition" FROM %s WHERE "covid_cat_definition" IS NOT NULL LIMIT 1`,
+		quoteIdentifier(covidRepCatsTable))).Scan(&definition)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to read covid_cat definition from %s: %v", covidRepCatsTable, err)
+		}
+		return loadCovidThresholds(envParameter)
+	}
+
+	var thresholds covidThresholds
+	if err := json.Unmarshal(definition, &thresholds); err != nil {
+		return thresholds, fmt.Errorf("failed to decode covid_cat definition: %w", err)
+	}
+	return thresholds, nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// digestDirEnvKey names a directory that receives digest-YYYYMMDD.html after each successful refresh.
+	digestDirEnvKey = "DIGEST_DIR"
+	// digestEmailToEnvKey lists comma-separated recipients of the digest; unset disables email.
+	digestEmailToEnvKey   = "DIGEST_EMAIL_TO"
+	digestEmailFromEnvKey = "DIGEST_EMAIL_FROM"
+	// digestMaxRowsEnvKey caps the rows shown per table.
+	digestMaxRowsEnvKey = "DIGEST_MAX_ROWS"
+
+	// The digest is sent through SendGrid when SENDGRID_API_KEY is set, otherwise through SMTP_HOST.
+	sendGridAPIKeyEnvKey = "SENDGRID_API_KEY"
+	smtpHostEnvKey       = "SMTP_HOST"
+	smtpPortEnvKey       = "SMTP_PORT"
+	smtpUsernameEnvKey   = "SMTP_USERNAME"
+	smtpPasswordEnvKey   = "SMTP_PASSWORD"
+
+	defaultDigestMaxRows = 25
+	defaultSMTPPort      = 587
+	sendGridSendURL      = "https://api.sendgrid.com/v3/mail/send"
+)
+
+//go:embed templates/digest.html
+var digestFiles embed.FS
+
+var digestTemplate = template.Must(template.ParseFS(digestFiles, "templates/digest.html"))
+
+// digestSection is one report table rendered into the digest.
+type digestSection struct {
+	Title       string
+	Description string
+	table       string
+}
+
+// digestSections lists the report tables stakeholders receive, in digest order.
+var digestSections = []digestSection{
+	{
+		Title:       "COVID-19 alerts for taxi drivers",
+		Description: "Taxi and rideshare trips tagged with the COVID-19 category of their pickup and dropoff ZIP codes.",
+		table:       covidAlertsTable,
+	},
+	{
+		Title:       "Airport trips by ZIP code",
+		Description: "Weekly trips between each ZIP code and O'Hare or Midway, with the ZIP code's COVID-19 category.",
+		table:       reqAirportTripsTable,
+	},
+	{
+		Title:       "Building permits in disadvantaged areas",
+		Description: "Permits eligible for a waived fee in the top five community areas by poverty or unemployment.",
+		table:       disadvantagedPermitsTable,
+	},
+}
+
+// renderedDigestSection holds a section's rows, or the error that kept them from being read.
+type renderedDigestSection struct {
+	digestSection
+	Columns []string
+	Rows    [][]string
+	Total   int
+	Err     error
+}
+
+// digestEnabled reports whether a digest output is configured.
+func digestEnabled() bool {
+	return strings.TrimSpace(os.Getenv(digestDirEnvKey)) != "" || strings.TrimSpace(os.Getenv(digestEmailToEnvKey)) != ""
+}
+
+// publishDigest renders the digest after a successful refresh, writes it to DIGEST_DIR, and emails it to
+// DIGEST_EMAIL_TO. Failures are logged rather than returned so the digest never fails a refresh.
+func publishDigest(db *sql.DB) {
+	if !digestEnabled() {
+		return
+	}
+
+	now := time.Now()
+	html, err := renderDigest(db, now)
+	if err != nil {
+		log.Printf("failed to render digest: %v", err)
+		return
+	}
+
+	if dir := strings.TrimSpace(os.Getenv(digestDirEnvKey)); dir != "" {
+		path := filepath.Join(dir, "digest-"+now.Format(snapshotDateLayout)+".html")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Printf("failed to create digest directory: %v", err)
+		} else if err := os.WriteFile(path, html, 0o644); err != nil {
+			log.Printf("failed to write digest: %v", err)
+		} else {
+			log.Printf("wrote digest to %s", path)
+		}
+	}
+
+	if recipients := digestRecipients(); len(recipients) > 0 {
+		subject := "Chicago BI digest for " + now.Format("January 2, 2006")
+		if err := sendDigest(recipients, subject, html); err != nil {
+			log.Printf("failed to email digest: %v", err)
+		} else {
+			log.Printf("emailed digest to %d recipients", len(recipients))
+		}
+	}
+}
+
+// renderDigest reads the first rows of every digest table and renders them as an HTML page. A table that
+// cannot be read is shown as unavailable instead of failing the whole digest.
+func renderDigest(db *sql.DB, generated time.Time) ([]byte, error) {
+	maxRows := digestMaxRows()
+	sections := make([]renderedDigestSection, 0, len(digestSections))
+	for _, section := range digestSections {
+		rendered := renderedDigestSection{digestSection: section}
+		rendered.Columns, rendered.Rows, rendered.Total, rendered.Err = readDigestTable(db, section.table, maxRows)
+		sections = append(sections, rendered)
+	}
+
+	var buf bytes.Buffer
+	if err := digestTemplate.Execute(&buf, map[string]any{"Generated": generated, "Sections": sections}); err != nil {
+		return nil, fmt.Errorf("failed to execute digest template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func readDigestTable(db *sql.DB, table string, maxRows int) ([]string, [][]string, int, error) {
+	tableIdent := quoteIdentifier(table)
+
+	var total int
+	if err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s`, tableIdent)).Scan(&total); err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to count rows in %s: %w", table, err)
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT * FROM %s LIMIT %d`, tableIdent, maxRows))
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to read %s columns: %w", table, err)
+	}
+
+	var values [][]string
+	for rows.Next() {
+		raw := make([]any, len(columns))
+		dest := make([]any, len(columns))
+		for i := range raw {
+			dest[i] = &raw[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, nil, 0, fmt.Errorf("failed to scan %s row: %w", table, err)
+		}
+
+		row := make([]string, len(columns))
+		for i, value := range raw {
+			row[i] = formatDigestValue(value)
+		}
+		values = append(values, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, 0, fmt.Errorf("error while reading %s rows: %w", table, err)
+	}
+
+	return columns, values, total, nil
+}
+
+func formatDigestValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 {
+			return v.Format("2006-01-02")
+		}
+		return v.Format("2006-01-02 15:04")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func digestMaxRows() int {
+	raw := strings.TrimSpace(os.Getenv(digestMaxRowsEnvKey))
+	if raw == "" {
+		return defaultDigestMaxRows
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		log.Printf("invalid %s value %q; defaulting to %d", digestMaxRowsEnvKey, raw, defaultDigestMaxRows)
+		return defaultDigestMaxRows
+	}
+	return n
+}
+
+func digestRecipients() []string {
+	var recipients []string
+	for _, recipient := range strings.Split(os.Getenv(digestEmailToEnvKey), ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients
+}
+
+// sendDigest emails the HTML digest through SendGrid when SENDGRID_API_KEY is set, otherwise through the
+// SMTP server at SMTP_HOST.
+func sendDigest(recipients []string, subject string, html []byte) error {
+	from := strings.TrimSpace(os.Getenv(digestEmailFromEnvKey))
+	if from == "" {
+		return fmt.Errorf("%s is required to email the digest", digestEmailFromEnvKey)
+	}
+
+	if apiKey := strings.TrimSpace(os.Getenv(sendGridAPIKeyEnvKey)); apiKey != "" {
+		return sendDigestSendGrid(apiKey, from, recipients, subject, html)
+	}
+	return sendDigestSMTP(from, recipients, subject, html)
+}
+
+func sendDigestSMTP(from string, recipients []string, subject string, html []byte) error {
+	host := strings.TrimSpace(os.Getenv(smtpHostEnvKey))
+	if host == "" {
+		return fmt.Errorf("%s or %s is required to email the digest", smtpHostEnvKey, sendGridAPIKeyEnvKey)
+	}
+
+	port := defaultSMTPPort
+	if raw := strings.TrimSpace(os.Getenv(smtpPortEnvKey)); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			log.Printf("invalid %s value %q; defaulting to %d", smtpPortEnvKey, raw, defaultSMTPPort)
+		} else {
+			port = parsed
+		}
+	}
+
+	var auth smtp.Auth
+	if username := os.Getenv(smtpUsernameEnvKey); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv(smtpPasswordEnvKey), host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/html; charset=utf-8\r\n\r\n")
+	msg.Write(html)
+
+	if err := smtp.SendMail(fmt.Sprintf("%s:%d", host, port), auth, from, recipients, msg.Bytes()); err != nil {
+		return fmt.Errorf("failed to send digest through %s: %w", host, err)
+	}
+	return nil
+}
+
+func sendDigestSendGrid(apiKey, from string, recipients []string, subject string, html []byte) error {
+	to := make([]map[string]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		to = append(to, map[string]string{"email": recipient})
+	}
+	payload, err := json.Marshal(map[string]any{
+		"personalizations": []map[string]any{{"to": to}},
+		"from":             map[string]string{"email": from},
+		"subject":          subject,
+		"content":          []map[string]string{{"type": "text/html", "value": string(html)}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode SendGrid request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sendGridSendURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build SendGrid request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("SendGrid request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("SendGrid returned %s", resp.Status)
+	}
+	return nil
+}
+
+// digestHandler serves the digest as it would be rendered now, for previewing the email in a browser. It is
+// internal, like /api/covid-alerts, because the digest shows trip and permit rows.
+func digestHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		html, err := renderDigest(db, time.Now())
+		if err != nil {
+			log.Printf("failed to render digest: %v", err)
+			http.Error(w, "failed to render digest", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(html)
+	}
+}
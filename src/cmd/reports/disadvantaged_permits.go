@@ -4,26 +4,50 @@ import (
 	"context"
 	"database/sql"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/kelvins/geocoder"
+	"github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/scoring"
+	"github.com/ahbreck/Chicago_BI/shared"
 )
 
 const (
 	disadvantagedTable        = "disadvantaged"
 	publichealthTable         = "public_health"
+	publicHealthVersionsTable = "public_health_versions"
+	// pinnedPublicHealthTable is the temporary table holding the vintage chosen by PUBLIC_HEALTH_VINTAGE.
+	pinnedPublicHealthTable   = "public_health_pinned"
+	publicHealthVintageEnvKey = "PUBLIC_HEALTH_VINTAGE"
 	buildingPermits           = "building_permits"
 	disadvantagedPermitsTable = "req_5_disadv_perm"
 	loanEligibilityPermits    = "req_6_loan_elig_permits"
 	ccviTable                 = "ccvi"
-	covidTable                = "covid"
-	taxiTripsTable            = "taxi_trips"
+	// covidTable is datasets.CovidUnifiedView, the view over the covid archive and its COVID_SOURCE
+	// successor, so the reports read current weeks whichever source is collected.
+	covidTable           = "covid_unified"
+	taxiTripsTable       = "taxi_trips"
+	vacantBuildingsTable = "vacant_buildings"
+	// communityAreaZipsTable is the temporary table holding the community area to ZIP code crosswalk during
+	// a build of the disadvantaged report.
+	communityAreaZipsTable = "community_area_zips"
+	// disadvantagedAreaCountEnvKey is how many community areas, ranked by composite score, are flagged
+	// disadvantaged.
+	disadvantagedAreaCountEnvKey  = "DISADVANTAGED_AREA_COUNT"
+	defaultDisadvantagedAreaCount = 10
+	// loanIncomeLimit is the per capita income, in dollars, below which a ZIP code's permits can be loan
+	// eligible.
+	loanIncomeLimit = 30000
+	// exactCountThreshold is the planner row estimate below which readiness checks count a table's rows
+	// instead of trusting the estimate.
+	exactCountThreshold = 10000
 )
 
 // SourceTables lists all base datasets produced by collectors that reports may depend on.
@@ -35,15 +59,41 @@ var SourceTables = []string{
 	taxiTripsTable,
 }
 
+// disadvantagedReportSources maps each table built by CreateDisadvantagedReport to the collector tables it reads.
+var disadvantagedReportSources = map[string][]string{
+	disadvantagedTable:        {publichealthTable, ccviTable, vacantBuildingsTable},
+	scoring.Table:             {publichealthTable, ccviTable},
+	disadvantagedPermitsTable: {buildingPermits, publichealthTable},
+	loanEligibilityPermits:    {buildingPermits, publichealthTable},
+}
+
+// loanScoreFormula is how the loan eligibility report scores ZIP codes, recorded in its lineage.
+var loanScoreFormula = fmt.Sprintf("loan_score = (1 - (permit_rate / MAX(permit_rate) + fee_rate / MAX(fee_rate)) / 2) * (1 - per_capita_income / %d), "+
+	"where permit_rate is permits_per_1000_residents (new construction permits per 1,000 ACS residents of the ZIP code) and "+
+	"fee_rate is fees_per_resident (their total fees per resident), or the raw counts and fees when population is not loaded; "+
+	"loan_rank orders ZIP codes by loan_score, highest first, and the permits of rank 1 are loan eligible", loanIncomeLimit)
+
+// disadvantagedReportMetadata records how the loan eligibility report is scored in its lineage.
+var disadvantagedReportMetadata = map[string]map[string]string{
+	loanEligibilityPermits: {"loan_score_formula": loanScoreFormula},
+}
+
+// disadvantagedReportAssertions are the invariants of the tables built by CreateDisadvantagedReport: one row
+// per community area, and a known geography_check on every permit.
+var disadvantagedReportAssertions = []reportAssertion{
+	assertNotNull(disadvantagedTable, "community_area"),
+	assertUnique(disadvantagedTable, "community_area"),
+	assertRowCount(disadvantagedTable, communityAreaCount),
+	assertAcceptedValues(disadvantagedPermitsTable, "geography_check", geographyAgrees, geographyCommunityAreaDiffers,
+		geographyZipDiffers, geographyBothDiffer, geographyOutsideBoundaries, geographyNoCoordinates, geographyNotChecked),
+}
+
 func CreateDisadvantagedReport(db *sql.DB) error {
 	if db == nil {
 		return fmt.Errorf("db connection is nil")
 	}
 
 	useGeocoding := os.Getenv("USE_GEOCODING") == "true"
-	if useGeocoding {
-		geocoder.ApiKey = os.Getenv("API_KEY")
-	}
 
 	if err := ensureTableReady(db, publichealthTable); err != nil {
 		return err
@@ -53,76 +103,104 @@ func CreateDisadvantagedReport(db *sql.DB) error {
 		return err
 	}
 
-	tx, err := db.Begin()
+	publicHealth := publichealthTable
+	vintage := pinnedPublicHealthVintage()
+	if vintage != "" {
+		if err := ensureTableReady(db, publicHealthVersionsTable); err != nil {
+			return err
+		}
+		publicHealth = pinnedPublicHealthTable
+	}
+
+	// CCVI is one of the composite score's indicators; without it areas are scored on the others.
+	ccvi := ccviTable
+	if err := ensureTableReady(db, ccviTable); err != nil {
+		log.Printf("scoring community areas without CCVI: %v", err)
+		ccvi = ""
+	}
+
+	if err := ensureReportParametersTable(db); err != nil {
+		return err
+	}
+	weights, err := scoring.LoadWeights(reportParameters(db))
 	if err != nil {
-		return fmt.Errorf("failed to start disadvantaged report transaction: %w", err)
+		return err
+	}
+	areaCount, err := intReportParameter(reportParameters(db), disadvantagedAreaCountEnvKey, defaultDisadvantagedAreaCount)
+	if err != nil {
+		return err
+	}
+	log.Printf("disadvantaged report flags the top %d community areas by composite score (weights: poverty %g, unemployment %g, income %g, ccvi %g)",
+		areaCount, weights.Poverty, weights.Unemployment, weights.Income, weights.CCVI)
+
+	// Vacant building reports are an extra signal; the report is still built without them.
+	vacantBuildingsIdent := ""
+	if err := ensureTableReady(db, vacantBuildingsTable); err != nil {
+		log.Printf("building disadvantaged report without vacant building reports: %v", err)
+	} else {
+		vacantBuildingsIdent = quoteIdentifier(vacantBuildingsTable)
 	}
 
 	targetIdent := quoteIdentifier(disadvantagedTable)
-	baseIdent := quoteIdentifier(publichealthTable)
-	buildingPermitsIdent := quoteIdentifier(buildingPermits)
 	disadvantagedPermitsIdent := quoteIdentifier(disadvantagedPermitsTable)
 	loanEligibilityPermitsIdent := quoteIdentifier(loanEligibilityPermits)
 
-	statements := []string{
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, disadvantagedPermitsIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS TABLE %s`, disadvantagedPermitsIdent, buildingPermitsIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN zip_code VARCHAR(9) DEFAULT ''`, disadvantagedPermitsIdent),
-		fmt.Sprintf(`ALTER TABLE %s
-                        ADD COLUMN top_5_poverty BOOLEAN DEFAULT FALSE,
-                        ADD COLUMN top_5_unemployment BOOLEAN DEFAULT FALSE,
-                        ADD COLUMN disadvantaged BOOLEAN DEFAULT FALSE`, disadvantagedPermitsIdent),
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, targetIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS TABLE %s`, targetIdent, baseIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN zip_code VARCHAR(9) DEFAULT ''`, targetIdent),
-		fmt.Sprintf(`ALTER TABLE %s
-                        ADD COLUMN top_5_poverty BOOLEAN DEFAULT FALSE,
-                        ADD COLUMN top_5_unemployment BOOLEAN DEFAULT FALSE,
-                        ADD COLUMN disadvantaged BOOLEAN DEFAULT FALSE`, targetIdent),
-		fmt.Sprintf(`UPDATE %s
-                        SET top_5_poverty = TRUE
-                        WHERE "community_area" IN (
-                                SELECT "community_area"
-                                FROM %s
-                                ORDER BY "below_poverty_level" DESC
-                                LIMIT 5
-                        )`, targetIdent, targetIdent),
-		fmt.Sprintf(`UPDATE %s
-                        SET top_5_unemployment = TRUE
-                        WHERE "community_area" IN (
-                                SELECT "community_area"
-                                FROM %s
-                                ORDER BY "unemployment" DESC
-                                LIMIT 5
-                        )`, targetIdent, targetIdent),
-		fmt.Sprintf(`UPDATE %s
-                        SET disadvantaged = top_5_poverty OR top_5_unemployment`, targetIdent),
-		fmt.Sprintf(`UPDATE %s dp
-		SET top_5_poverty = d.top_5_poverty,
-		    top_5_unemployment = d.top_5_unemployment,
-		    disadvantaged = d.disadvantaged
-		FROM %s d
-		WHERE dp."community_area" = d."community_area"`, disadvantagedPermitsIdent, targetIdent),
-		fmt.Sprintf(`ALTER TABLE %s RENAME COLUMN disadvantaged TO waived_fee`, disadvantagedPermitsIdent),
-	}
-
-	for _, statement := range statements {
-		if _, execErr := tx.Exec(statement); execErr != nil {
+	statements, err := renderStatements("disadvantaged_report.sql", map[string]string{
+		"Target":          targetIdent,
+		"PublicHealth":    quoteIdentifier(publicHealth),
+		"Scores":          quoteIdentifier(scoring.Table),
+		"AreaCount":       strconv.Itoa(areaCount),
+		"BuildingPermits": quoteIdentifier(buildingPermits),
+		"Permits":         disadvantagedPermitsIdent,
+		"VacantBuildings": vacantBuildingsIdent,
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start disadvantaged report transaction: %w", err)
+	}
+
+	if vintage != "" {
+		if err := pinPublicHealth(tx, vintage); err != nil {
 			tx.Rollback()
-			return fmt.Errorf("failed to execute statement %q: %w", statement, execErr)
+			return err
 		}
 	}
 
+	if _, err := scoring.Refresh(tx, publicHealth, ccvi, weights); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to compute composite scores: %w", err)
+	}
+
+	if execErr := execStatements(tx, "disadvantaged_report.sql", statements); execErr != nil {
+		tx.Rollback()
+		return execErr
+	}
+
 	if err := populateDisadvantagedZipCodes(tx, targetIdent); err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to populate disadvantaged zip codes: %w", err)
 	}
 
-	if err := populatePermitZipCodes(tx, disadvantagedPermitsIdent, useGeocoding); err != nil {
+	boundaries, spatial, err := loadPermitBoundaries(tx)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to load community area and zip code boundaries: %w", err)
+	}
+
+	if err := populatePermitZipCodes(tx, disadvantagedPermitsIdent, useGeocoding, spatial, boundaries); err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to populate zip codes: %w", err)
 	}
 
+	if err := checkPermitGeography(tx, disadvantagedPermitsIdent, spatial); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := createLoanEligibilityPermits(tx, disadvantagedPermitsIdent, targetIdent, loanEligibilityPermitsIdent); err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to build loan eligibility report: %w", err)
@@ -142,6 +220,49 @@ func CreateDisadvantagedReport(db *sql.DB) error {
 	return nil
 }
 
+// pinnedPublicHealthVintage returns PUBLIC_HEALTH_VINTAGE, which pins the disadvantaged report to one
+// vintage in public_health_versions: a source period such as "2008-2012", optionally followed by
+// "@YYYY-MM-DD" to choose a retrieval date other than the latest. Unset uses the latest pull in public_health.
+func pinnedPublicHealthVintage() string {
+	return strings.TrimSpace(os.Getenv(publicHealthVintageEnvKey))
+}
+
+// pinPublicHealth copies the pinned vintage into a temporary table that stands in for public_health for
+// the rest of the transaction.
+func pinPublicHealth(tx *sql.Tx, vintage string) error {
+	period, retrievedRaw, _ := strings.Cut(vintage, "@")
+	var retrieved sql.NullString
+	if retrievedRaw != "" {
+		if _, err := time.Parse("2006-01-02", retrievedRaw); err != nil {
+			return fmt.Errorf("invalid %s %q: retrieval date must be YYYY-MM-DD", publicHealthVintageEnvKey, vintage)
+		}
+		retrieved = sql.NullString{String: retrievedRaw, Valid: true}
+	}
+
+	pinnedIdent := quoteIdentifier(pinnedPublicHealthTable)
+	versionsIdent := quoteIdentifier(publicHealthVersionsTable)
+	createStmt := fmt.Sprintf(`CREATE TEMP TABLE %s (LIKE %s) ON COMMIT DROP`, pinnedIdent, versionsIdent)
+	if _, err := tx.Exec(createStmt); err != nil {
+		return fmt.Errorf("failed to create pinned public health table: %w", err)
+	}
+
+	insertStmt := fmt.Sprintf(`INSERT INTO %s
+SELECT *
+FROM %s
+WHERE "source_period" = $1
+	AND "date_retrieved" = COALESCE($2::date, (SELECT MAX("date_retrieved") FROM %s WHERE "source_period" = $1))`, pinnedIdent, versionsIdent, versionsIdent)
+	result, err := tx.Exec(insertStmt, period, retrieved)
+	if err != nil {
+		return fmt.Errorf("failed to pin public health vintage %s: %w", vintage, err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("no public health rows for vintage %s in %s", vintage, publicHealthVersionsTable)
+	}
+
+	log.Printf("disadvantaged report pinned to public health vintage %s", vintage)
+	return nil
+}
+
 func populateDisadvantagedZipCodes(tx *sql.Tx, tableIdent string) error {
 	if tx == nil {
 		return fmt.Errorf("transaction is nil")
@@ -152,25 +273,15 @@ func populateDisadvantagedZipCodes(tx *sql.Tx, tableIdent string) error {
 		return fmt.Errorf("failed to initialize disadvantaged zip codes: %w", err)
 	}
 
-	communityZipMap, err := loadCommunityAreaZipCodes()
+	crosswalkIdent, err := loadCommunityAreaZipTable(tx)
 	if err != nil {
 		return err
 	}
 
-	if len(communityZipMap) == 0 {
-		return fmt.Errorf("no community area to zip code mappings were loaded")
-	}
-
-	values := make([]string, 0, len(communityZipMap))
-	for communityArea, zip := range communityZipMap {
-		escapedZip := strings.ReplaceAll(zip, `'`, `''`)
-		values = append(values, fmt.Sprintf("('%d', '%s')", communityArea, escapedZip))
-	}
-
 	updateStmt := fmt.Sprintf(`UPDATE %s d
 SET zip_code = mapping.zip_code
-FROM (VALUES %s) AS mapping(community_area, zip_code)
-WHERE d."community_area"::text = mapping.community_area`, tableIdent, strings.Join(values, ","))
+FROM %s mapping
+WHERE d."community_area"::text = mapping.community_area`, tableIdent, crosswalkIdent)
 
 	if _, err := tx.Exec(updateStmt); err != nil {
 		return fmt.Errorf("failed to populate disadvantaged zip codes from community area mapping: %w", err)
@@ -179,63 +290,42 @@ WHERE d."community_area"::text = mapping.community_area`, tableIdent, strings.Jo
 	return nil
 }
 
+// createLoanEligibilityPermits builds the loan eligibility report from the new construction permits of
+// ZIP codes with a per capita income below loanIncomeLimit, scored as loanScoreFormula describes. Without
+// the population table the ZIP codes are compared on raw permit counts and fee totals.
 func createLoanEligibilityPermits(tx *sql.Tx, sourcePermitsIdent, disadvantagedIdent, loanEligIdent string) error {
 	if tx == nil {
 		return fmt.Errorf("transaction is nil")
 	}
 
-	statements := []string{
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, loanEligIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS TABLE %s`, loanEligIdent, sourcePermitsIdent),
-		fmt.Sprintf(`DELETE FROM %s WHERE "permit_type" IS NULL OR "permit_type" <> 'PERMIT - NEW CONSTRUCTION'`, loanEligIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN per_capita_income NUMERIC`, loanEligIdent),
-		fmt.Sprintf(`UPDATE %s lp
-SET per_capita_income = d.per_capita_income
-FROM %s d
-WHERE lp."zip_code" <> '' AND lp."zip_code" = d."zip_code"`, loanEligIdent, disadvantagedIdent),
-		fmt.Sprintf(`DELETE FROM %s WHERE per_capita_income IS NULL OR per_capita_income >= 30000`, loanEligIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN new_const_permits_for_zip INTEGER DEFAULT 0`, loanEligIdent),
-		fmt.Sprintf(`UPDATE %s lp
-SET new_const_permits_for_zip = counts.permit_count
-FROM (
-	SELECT "zip_code", COUNT(*) AS permit_count
-	FROM %s
-	WHERE "zip_code" <> ''
-	GROUP BY "zip_code"
-) counts
-WHERE lp."zip_code" = counts."zip_code"`, loanEligIdent, loanEligIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN loan_eligibility BOOLEAN DEFAULT FALSE`, loanEligIdent),
-		fmt.Sprintf(`UPDATE %s lp
-SET loan_eligibility = TRUE
-FROM (
-	SELECT "zip_code", COUNT(*) AS permit_count
-	FROM %s
-	WHERE "zip_code" <> ''
-	GROUP BY "zip_code"
-) counts
-WHERE lp."zip_code" = counts."zip_code"
-	AND counts.permit_count = (
-		SELECT MIN(permit_count)
-		FROM (
-			SELECT COUNT(*) AS permit_count
-			FROM %s
-			WHERE "zip_code" <> ''
-			GROUP BY "zip_code"
-		) permit_counts
-	)`, loanEligIdent, loanEligIdent, loanEligIdent),
-		fmt.Sprintf(`DELETE FROM %s WHERE loan_eligibility IS NOT TRUE`, loanEligIdent),
-	}
-
-	for _, statement := range statements {
-		if _, err := tx.Exec(statement); err != nil {
-			return fmt.Errorf("failed to execute statement %q: %w", statement, err)
-		}
+	populationIdent := ""
+	if loaded, err := tableHasRows(tx, populationTable); err != nil {
+		return err
+	} else if loaded {
+		populationIdent = quoteIdentifier(populationTable)
+	} else {
+		log.Printf("%s is not loaded; scoring loan eligibility on raw permit counts and fee totals", populationTable)
+	}
+
+	statements, err := renderStatements("loan_eligibility_permits.sql", map[string]string{
+		"LoanElig":      loanEligIdent,
+		"Permits":       sourcePermitsIdent,
+		"Disadvantaged": disadvantagedIdent,
+		"Population":    populationIdent,
+		"IncomeLimit":   strconv.Itoa(loanIncomeLimit),
+	})
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return execStatements(tx, "loan_eligibility_permits.sql", statements)
 }
 
-func populatePermitZipCodes(tx *sql.Tx, tableIdent string, useGeocoding bool) error {
+// populatePermitZipCodes sets the zip_code of the permits in tableIdent. With useGeocoding, the ZIP codes the
+// Census batch geocoder found at ingest come first. When spatial is set, the boundaries found by
+// loadPermitBoundaries then place permits by their coordinates; the rest are reverse geocoded with
+// useGeocoding, or take the crosswalk ZIP code of their community area without it.
+func populatePermitZipCodes(tx *sql.Tx, tableIdent string, useGeocoding, spatial bool, boundaries permitBoundaries) error {
 	if tx == nil {
 		return fmt.Errorf("transaction is nil")
 	}
@@ -245,35 +335,39 @@ func populatePermitZipCodes(tx *sql.Tx, tableIdent string, useGeocoding bool) er
 		return fmt.Errorf("failed to initialize zip codes: %w", err)
 	}
 
-	if !useGeocoding {
-		communityZipMap, err := loadCommunityAreaZipCodes()
-		if err != nil {
-			return err
-		}
+	if err := fillCrosswalkZipCodes(tx, tableIdent); err != nil {
+		return err
+	}
 
-		if len(communityZipMap) == 0 {
-			return fmt.Errorf("no community area to zip code mappings were loaded")
+	if useGeocoding {
+		// ZIPs found by the Census batch geocoder at ingest are kept; only the remaining permits are placed.
+		addressZipStmt := fmt.Sprintf(`UPDATE %s SET zip_code = "address_zip" WHERE COALESCE("address_zip", '') <> ''`, tableIdent)
+		if _, err := tx.Exec(addressZipStmt); err != nil {
+			return fmt.Errorf("failed to copy address zip codes: %w", err)
 		}
+	}
 
-		values := make([]string, 0, len(communityZipMap))
-		for communityArea, zip := range communityZipMap {
-			escapedZip := strings.ReplaceAll(zip, `'`, `''`)
-			values = append(values, fmt.Sprintf("('%d', '%s')", communityArea, escapedZip))
+	if spatial {
+		if err := placePermitsInBoundaries(tx, tableIdent, boundaries); err != nil {
+			return err
 		}
+	}
 
-		updateStmt := fmt.Sprintf(`UPDATE %s bp
-SET zip_code = mapping.zip_code
-FROM (VALUES %s) AS mapping(community_area, zip_code)
-WHERE bp."community_area"::text = mapping.community_area`, tableIdent, strings.Join(values, ","))
-
-		if _, err := tx.Exec(updateStmt); err != nil {
-			return fmt.Errorf("failed to populate zip codes from community area mapping: %w", err)
-		}
+	if !useGeocoding {
+		return applyCommunityAreaZipCodes(tx, tableIdent)
+	}
 
-		return nil
+	// Permits without coordinates cannot be reverse geocoded and keep an empty ZIP code.
+	var unlocated int
+	unlocatedStmt := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE ("latitude" IS NULL OR "longitude" IS NULL) AND zip_code = ''`, tableIdent)
+	if err := tx.QueryRow(unlocatedStmt).Scan(&unlocated); err != nil {
+		return fmt.Errorf("failed to count permits without coordinates: %w", err)
+	}
+	if unlocated > 0 {
+		log.Printf("%d permits have no coordinates or address ZIP code; skipping them for reverse geocoding", unlocated)
 	}
 
-	rows, err := tx.Query(fmt.Sprintf(`SELECT "id", "latitude", "longitude" FROM %s WHERE "latitude" IS NOT NULL AND "longitude" IS NOT NULL`, tableIdent))
+	rows, err := tx.Query(fmt.Sprintf(`SELECT "id", "latitude", "longitude" FROM %s WHERE "latitude" IS NOT NULL AND "longitude" IS NOT NULL AND zip_code = ''`, tableIdent))
 	if err != nil {
 		return fmt.Errorf("failed to fetch permits for geocoding: %w", err)
 	}
@@ -325,17 +419,16 @@ WHERE bp."community_area"::text = mapping.community_area`, tableIdent, strings.J
 			Longitude: permit.longitude,
 		}
 
-		addresses, geoErr := geocoder.GeocodingReverse(location)
+		zipCode, geoErr := shared.ReverseGeocodeZip(context.Background(), location)
+		if errors.Is(geoErr, shared.ErrGeocoderUnavailable) {
+			// The remaining permits take the ZIP code of their community area instead.
+			return applyCommunityAreaZipCodes(tx, tableIdent)
+		}
 		if geoErr != nil {
 			fmt.Printf("failed to reverse geocode permit %s: %v\n", permit.id, geoErr)
 			continue
 		}
 
-		zipCode := ""
-		if len(addresses) > 0 {
-			zipCode = addresses[0].PostalCode
-		}
-
 		if _, updateErr := updateStmt.Exec(zipCode, permit.id); updateErr != nil {
 			fmt.Printf("failed to update zip code for permit %s: %v\n", permit.id, updateErr)
 			continue
@@ -345,13 +438,92 @@ WHERE bp."community_area"::text = mapping.community_area`, tableIdent, strings.J
 	return nil
 }
 
+// applyCommunityAreaZipCodes gives permits without a ZIP code the ZIP code the crosswalk maps their
+// community area to, as filled in by fillCrosswalkZipCodes.
+func applyCommunityAreaZipCodes(tx *sql.Tx, tableIdent string) error {
+	updateStmt := fmt.Sprintf(`UPDATE %s SET zip_code = crosswalk_zip_code WHERE zip_code = '' AND crosswalk_zip_code IS NOT NULL`, tableIdent)
+	if _, err := tx.Exec(updateStmt); err != nil {
+		return fmt.Errorf("failed to populate zip codes from community area mapping: %w", err)
+	}
+	return nil
+}
+
+// fillCrosswalkZipCodes sets the crosswalk_zip_code of permits to the ZIP code the crosswalk maps their
+// community area to.
+func fillCrosswalkZipCodes(tx *sql.Tx, tableIdent string) error {
+	crosswalkIdent, err := loadCommunityAreaZipTable(tx)
+	if err != nil {
+		return err
+	}
+
+	updateStmt := fmt.Sprintf(`UPDATE %s bp
+SET crosswalk_zip_code = mapping.zip_code
+FROM %s mapping
+WHERE bp."community_area"::text = mapping.community_area`, tableIdent, crosswalkIdent)
+
+	if _, err := tx.Exec(updateStmt); err != nil {
+		return fmt.Errorf("failed to populate crosswalk zip codes from community area mapping: %w", err)
+	}
+
+	return nil
+}
+
+// loadCommunityAreaZipTable copies the community area to ZIP code crosswalk into the temporary table
+// communityAreaZipsTable, dropped when tx commits, and returns its quoted name. The rows are bound by COPY
+// rather than spliced into the statements; later calls in the same transaction reuse the table.
+func loadCommunityAreaZipTable(tx *sql.Tx) (string, error) {
+	tableIdent := quoteIdentifier(communityAreaZipsTable)
+
+	var regClass sql.NullString
+	if err := tx.QueryRow(`SELECT to_regclass($1)`, tableIdent).Scan(&regClass); err != nil {
+		return "", fmt.Errorf("failed to verify presence of %s: %w", communityAreaZipsTable, err)
+	}
+	if regClass.Valid {
+		return tableIdent, nil
+	}
+
+	communityZipMap, err := loadCommunityAreaZipCodes()
+	if err != nil {
+		return "", err
+	}
+
+	if len(communityZipMap) == 0 {
+		return "", fmt.Errorf("no community area to zip code mappings were loaded")
+	}
+
+	createStmt := fmt.Sprintf(`CREATE TEMP TABLE %s (
+		community_area TEXT PRIMARY KEY,
+		zip_code VARCHAR(9) NOT NULL
+	) ON COMMIT DROP`, tableIdent)
+	if _, err := tx.Exec(createStmt); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", communityAreaZipsTable, err)
+	}
+
+	copyStmt, err := tx.Prepare(pq.CopyIn(communityAreaZipsTable, "community_area", "zip_code"))
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare %s copy: %w", communityAreaZipsTable, err)
+	}
+	defer copyStmt.Close()
+
+	for communityArea, zip := range communityZipMap {
+		if _, err := copyStmt.Exec(strconv.Itoa(communityArea), zip); err != nil {
+			return "", fmt.Errorf("failed to copy community area %d into %s: %w", communityArea, communityAreaZipsTable, err)
+		}
+	}
+	if _, err := copyStmt.Exec(); err != nil {
+		return "", fmt.Errorf("failed to copy %s: %w", communityAreaZipsTable, err)
+	}
+
+	return tableIdent, nil
+}
+
 func loadCommunityAreaZipCodes() (map[int]string, error) {
 	projectRoot, err := findProjectRoot()
 	if err != nil {
 		return nil, fmt.Errorf("failed to locate project root while loading community area mapping: %w", err)
 	}
 
-	mappingPath := filepath.Join(projectRoot, "src", "data", "community_area_to_zip_code.csv")
+	mappingPath := shared.CrosswalkPath(projectRoot, "community_area_to_zip_code.csv")
 	file, err := os.Open(mappingPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open community area zip code mapping %s: %w", mappingPath, err)
@@ -398,10 +570,15 @@ func loadCommunityAreaZipCodes() (map[int]string, error) {
 	return areaZipMap, nil
 }
 
+// ensureTableReady checks that tableName exists and has data. A successful load recorded in the freshness
+// registry (table_refreshes) that inserted rows is enough; for tables the registry has no such entry for, such
+// as those loaded before it existed or by resumed and upserting loads, the planner's row estimate is used, and
+// only tables estimated below exactCountThreshold rows are counted.
 func ensureTableReady(db *sql.DB, tableName string) error {
 	var regClass sql.NullString
-	lookup := fmt.Sprintf("public.%s", quoteIdentifier(tableName))
-	if err := db.QueryRow(`SELECT to_regclass($1)`, lookup).Scan(&regClass); err != nil {
+	// The name is left unqualified so it resolves through the connection's search_path, which points at
+	// DB_SCHEMA (or a smoke schema) when one is configured.
+	if err := db.QueryRow(`SELECT to_regclass($1)`, quoteIdentifier(tableName)).Scan(&regClass); err != nil {
 		return fmt.Errorf("failed to verify presence of %s: %w", tableName, err)
 	}
 
@@ -409,6 +586,23 @@ func ensureTableReady(db *sql.DB, tableName string) error {
 		return fmt.Errorf("required table %q does not exist", tableName)
 	}
 
+	refresh, recorded, err := shared.LookupTableRefresh(db, tableName)
+	if err != nil {
+		log.Printf("%v; counting rows instead", err)
+	} else if recorded && refresh.RowCount > 0 {
+		return nil
+	}
+
+	// reltuples is -1 (0 before Postgres 14) until the table is first vacuumed or analyzed, so a low estimate
+	// is confirmed with an exact count.
+	var estimate float64
+	if err := db.QueryRow(`SELECT reltuples FROM pg_class WHERE oid = $1::regclass`, regClass.String).Scan(&estimate); err != nil {
+		return fmt.Errorf("failed to estimate rows in %s: %w", tableName, err)
+	}
+	if estimate >= exactCountThreshold {
+		return nil
+	}
+
 	countQuery := fmt.Sprintf(`SELECT COUNT(*) FROM %s`, quoteIdentifier(tableName))
 	var rowCount int
 	if err := db.QueryRow(countQuery).Scan(&rowCount); err != nil {
@@ -422,18 +616,60 @@ func ensureTableReady(db *sql.DB, tableName string) error {
 	return nil
 }
 
+// quoteIdentifier quotes a table name without a schema qualifier. Tables resolve through the connection's
+// search_path, which shared.OpenDatabase sets to DB_SCHEMA, so the same SQL runs in any schema.
 func quoteIdentifier(name string) string {
 	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
 }
 
-func WaitForTablesReady(ctx context.Context, db *sql.DB, initialDelay time.Duration, pollInterval time.Duration, tables ...string) error {
+// TableWait configures WaitForTablesReady.
+type TableWait struct {
+	// StartupDelay is waited out before the first check, giving collectors started at the same time a head
+	// start.
+	StartupDelay time.Duration
+	// PollInterval is the time between checks (default 5 seconds).
+	PollInterval time.Duration
+	// Timeout is how long to keep checking after the startup delay; 0 waits until ctx is done.
+	Timeout time.Duration
+}
+
+// TablesNotReadyError is returned by WaitForTablesReady when its timeout passes before every table is
+// ready. Status holds the outcome of the last check of each table, nil for tables that were ready.
+type TablesNotReadyError struct {
+	Waited time.Duration
+	Tables []string
+	Status map[string]error
+}
+
+func (e *TablesNotReadyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "source tables not ready after %s:", e.Waited.Round(time.Second))
+	for _, table := range e.Tables {
+		if err := e.Status[table]; err != nil {
+			fmt.Fprintf(&b, "\n  %s: %v", table, err)
+		} else {
+			fmt.Fprintf(&b, "\n  %s: ready", table)
+		}
+	}
+	fmt.Fprintf(&b, "\nrun the collectors for the tables above, or set %s=true to build reports from the tables that are ready", forceRunEnvKey)
+	return b.String()
+}
+
+// Is puts TablesNotReadyError in the shared.ErrSourceUnavailable category.
+func (e *TablesNotReadyError) Is(target error) bool {
+	return target == shared.ErrSourceUnavailable
+}
+
+// WaitForTablesReady blocks until every table passes ensureTableReady, wait.Timeout passes, or ctx is done.
+// A timeout returns a *TablesNotReadyError naming what is wrong with each table still not ready.
+func WaitForTablesReady(ctx context.Context, db *sql.DB, wait TableWait, tables ...string) error {
 	if db == nil {
 		return fmt.Errorf("db connection is nil")
 	}
 
-	if initialDelay > 0 {
-		log.Printf("waiting %s before checking source table readiness", initialDelay)
-		timer := time.NewTimer(initialDelay)
+	if wait.StartupDelay > 0 {
+		log.Printf("waiting %s before checking source table readiness", wait.StartupDelay)
+		timer := time.NewTimer(wait.StartupDelay)
 		defer timer.Stop()
 
 		select {
@@ -443,6 +679,7 @@ func WaitForTablesReady(ctx context.Context, db *sql.DB, initialDelay time.Durat
 		}
 	}
 
+	pollInterval := wait.PollInterval
 	if pollInterval <= 0 {
 		pollInterval = 5 * time.Second
 	}
@@ -451,6 +688,14 @@ func WaitForTablesReady(ctx context.Context, db *sql.DB, initialDelay time.Durat
 		return nil
 	}
 
+	started := time.Now()
+	var deadline <-chan time.Time
+	if wait.Timeout > 0 {
+		timer := time.NewTimer(wait.Timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
 	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
 
@@ -462,32 +707,30 @@ func WaitForTablesReady(ctx context.Context, db *sql.DB, initialDelay time.Durat
 			return ctx.Err()
 		}
 
+		status := make(map[string]error, len(tables))
 		var lastErr error
-		allReady := true
 		for _, table := range tables {
 			if err := ensureTableReady(db, table); err != nil {
+				status[table] = err
 				lastErr = err
-				allReady = false
-				break
 			}
 		}
 
-		if allReady {
+		if lastErr == nil {
 			return nil
 		}
 
 		// Log the latest readiness issue periodically so callers can see why we're still waiting.
-		if lastErr != nil && (lastStatusLog.IsZero() || time.Since(lastStatusLog) >= statusLogInterval) {
-			log.Printf("still waiting for source tables: %v", lastErr)
+		if lastStatusLog.IsZero() || time.Since(lastStatusLog) >= statusLogInterval {
+			log.Printf("still waiting for %d of %d source tables: %v", len(status), len(tables), lastErr)
 			lastStatusLog = time.Now()
 		}
 
 		select {
 		case <-ctx.Done():
-			if lastErr != nil {
-				return fmt.Errorf("context canceled while waiting for tables: %w", lastErr)
-			}
-			return ctx.Err()
+			return fmt.Errorf("context canceled while waiting for tables: %w", lastErr)
+		case <-deadline:
+			return &TablesNotReadyError{Waited: time.Since(started), Tables: tables, Status: status}
 		case <-ticker.C:
 		}
 	}
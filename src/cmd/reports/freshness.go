@@ -0,0 +1,34 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// freshnessHandler serves /freshness: the freshness registry, i.e. when each source table was last loaded
+// successfully by a collector and how many rows that load inserted, as a JSON array ordered by table.
+func freshnessHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refreshes, err := shared.TableRefreshes(r.Context(), db)
+		if err != nil {
+			writeQueryError(w, shared.TableRefreshesTable, err)
+			return
+		}
+		writeJSON(w, shared.TableRefreshesTable, refreshes)
+	}
+}
+
+// datasetsHandler serves /api/datasets: the datasets registry the collectors maintain, i.e. every source the
+// platform ingests with its Socrata resource, cadence, owner, and last successful pull, ordered by id.
+func datasetsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := shared.RegisteredDatasets(r.Context(), db)
+		if err != nil {
+			writeQueryError(w, shared.DatasetRegistryTable, err)
+			return
+		}
+		writeJSON(w, shared.DatasetRegistryTable, entries)
+	}
+}
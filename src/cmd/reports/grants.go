@@ -0,0 +1,64 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// reportReadRolesEnvKey lists comma-separated database roles, e.g. the dashboards' read-only role, that are
+// granted SELECT on every report table after each build. Report builders drop and recreate their tables,
+// which drops any grant made on them by hand.
+const reportReadRolesEnvKey = "REPORT_READ_ROLES"
+
+// reportReadRoles parses REPORT_READ_ROLES.
+func reportReadRoles() []string {
+	var roles []string
+	for _, role := range strings.Split(os.Getenv(reportReadRolesEnvKey), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// grantReportTables grants the REPORT_READ_ROLES usage of the current schema and SELECT on every report
+// table produced by a builder run. Failures are logged rather than returned, like snapshots, so a missing
+// role never fails an otherwise good build.
+func grantReportTables(db *sql.DB, reportSources map[string][]string) {
+	roles := reportReadRoles()
+	if len(roles) == 0 {
+		return
+	}
+
+	quotedRoles := make([]string, len(roles))
+	for i, role := range roles {
+		quotedRoles[i] = quoteIdentifier(role)
+	}
+	grantees := strings.Join(quotedRoles, ", ")
+
+	var schema string
+	if err := db.QueryRow(`SELECT current_schema()`).Scan(&schema); err != nil {
+		log.Printf("failed to grant report tables to %s: failed to read the current schema: %v", strings.Join(roles, ", "), err)
+		return
+	}
+	statements := []string{fmt.Sprintf(`GRANT USAGE ON SCHEMA %s TO %s`, quoteIdentifier(schema), grantees)}
+
+	tables := make([]string, 0, len(reportSources))
+	for reportTable := range reportSources {
+		tables = append(tables, reportTable)
+	}
+	sort.Strings(tables)
+	for _, reportTable := range tables {
+		statements = append(statements, fmt.Sprintf(`GRANT SELECT ON TABLE %s TO %s`, quoteIdentifier(reportTable), grantees))
+	}
+
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			log.Printf("failed to execute statement %q: %v", statement, err)
+		}
+	}
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/scoring"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// graphqlTable exposes one report table as a list field of the /graphql query type. Every column is a
+// field of the row type, named like the column, and only the columns a query selects are read. The
+// arguments are checked and applied by the same listParams as the REST endpoints: limit, offset, and sort
+// on any column, plus zip, community_area, from, and to when the table has columns to apply them to.
+type graphqlTable struct {
+	field       string
+	typeName    string
+	description string
+	table       string
+	columns     []shared.Column
+	// zipColumns and areaColumns are the columns the zip and community_area arguments match; a row
+	// matches when any of them does.
+	zipColumns  []string
+	areaColumns []string
+	// dateColumn is compared against the inclusive from and to arguments.
+	dateColumn  string
+	defaultSort string
+	// sortExprs overrides how a column is sorted.
+	sortExprs map[string]string
+	// role is needed to read the table; row-level tables are internal.
+	role accessRole
+}
+
+// graphqlTables lists the report tables served over /graphql. Most reports are copies of a source table
+// with columns added, so their columns start from the source dataset's.
+var graphqlTables = []graphqlTable{
+	{
+		field:       "airport_trips",
+		typeName:    "AirportTrips",
+		description: "Weekly COVID case rates and trips to and from the airports per ZIP code.",
+		table:       reqAirportTripsTable,
+		columns: slices.Concat(datasets.CovidDataset.Columns, []shared.Column{
+			{Name: "covid_cat", Type: shared.ColumnString},
+			{Name: "positivity_cat", Type: shared.ColumnString},
+			{Name: "covid_risk", Type: shared.ColumnString},
+			{Name: "covid_cat_definition", Type: shared.ColumnString},
+			{Name: "trips_to_airport", Type: shared.ColumnInteger},
+			{Name: "trips_from_airport", Type: shared.ColumnInteger},
+		}),
+		zipColumns:  []string{"zip_code"},
+		dateColumn:  "week_start",
+		defaultSort: "zip_code,week_start",
+	},
+	{
+		field:       "airport_trips_anomalies",
+		typeName:    "AirportTripsAnomaly",
+		description: "Weekly airport trips per ZIP code and direction against a seasonal baseline: the same week last year, else the trailing 8-week average.",
+		table:       airportAnomaliesTable,
+		columns: []shared.Column{
+			{Name: "zip_code", Type: shared.ColumnString},
+			{Name: "week_start", Type: shared.ColumnDate},
+			{Name: "direction", Type: shared.ColumnString},
+			{Name: "trips", Type: shared.ColumnInteger},
+			{Name: "same_week_last_year", Type: shared.ColumnInteger},
+			{Name: "trailing_8_week_avg", Type: shared.ColumnFloat},
+			{Name: "baseline_method", Type: shared.ColumnString},
+			{Name: "baseline", Type: shared.ColumnFloat},
+			{Name: "deviation", Type: shared.ColumnFloat},
+			{Name: "deviation_pct", Type: shared.ColumnFloat},
+		},
+		zipColumns:  []string{"zip_code"},
+		dateColumn:  "week_start",
+		defaultSort: "zip_code,week_start,direction",
+	},
+	{
+		field:       "covid_alerts",
+		typeName:    "CovidAlert",
+		description: "Trips with the COVID category of their pickup and dropoff ZIP codes.",
+		table:       covidAlertsTable,
+		columns: slices.Concat(datasets.TaxiTripsDataset.Columns, []shared.Column{
+			{Name: "airport_pickup", Type: shared.ColumnBoolean},
+			{Name: "airport_dropoff", Type: shared.ColumnBoolean},
+			{Name: "day", Type: shared.ColumnDate},
+			{Name: "week_start", Type: shared.ColumnDate},
+			{Name: "month_start", Type: shared.ColumnDate},
+			{Name: "pickup_covid_cat", Type: shared.ColumnString},
+			{Name: "dropoff_covid_cat", Type: shared.ColumnString},
+			{Name: "pickup_covid_risk", Type: shared.ColumnString},
+			{Name: "dropoff_covid_risk", Type: shared.ColumnString},
+		}),
+		zipColumns:  []string{"pickup_zip_code", "dropoff_zip_code"},
+		areaColumns: []string{"pickup_community_area", "dropoff_community_area"},
+		dateColumn:  "day",
+		defaultSort: "trip_start_timestamp,trip_id",
+		role:        roleInternal,
+	},
+	{
+		field:       "ccvi_trips",
+		typeName:    "CCVITrips",
+		description: "Weekly trips from and to ZIP codes with a high COVID Community Vulnerability Index.",
+		table:       CCVITable,
+		columns: slices.Concat(datasets.CCVIDataset.Columns, []shared.Column{
+			{Name: "week_start", Type: shared.ColumnDate},
+			{Name: "weekly_trips", Type: shared.ColumnInteger},
+		}),
+		zipColumns:  []string{"community_area_or_zip"},
+		dateColumn:  "week_start",
+		defaultSort: "community_area_or_zip,week_start",
+	},
+	{
+		field:       "trips_by_time_of_day",
+		typeName:    "TripsByTimeOfDay",
+		description: "Trips per pickup ZIP code by Chicago hour of day and day of week (0 is Sunday), split by the pickup ZIP code's COVID categories.",
+		table:       tripsByTimeTable,
+		columns: []shared.Column{
+			{Name: "pickup_zip_code", Type: shared.ColumnString},
+			{Name: "day_of_week", Type: shared.ColumnInteger},
+			{Name: "hour_of_day", Type: shared.ColumnInteger},
+			{Name: "pickup_covid_cat", Type: shared.ColumnString},
+			{Name: "pickup_covid_risk", Type: shared.ColumnString},
+			{Name: "trips", Type: shared.ColumnInteger},
+		},
+		zipColumns:  []string{"pickup_zip_code"},
+		defaultSort: "pickup_zip_code,day_of_week,hour_of_day",
+	},
+	{
+		field:       "trip_fares_by_zip",
+		typeName:    "TripFaresByZip",
+		description: "Median fare, tips as a percentage of fares, and revenue of the trips picked up per ZIP code, week, and trip type, with fares outside the week's 1st to 99th percentile trimmed.",
+		table:       tripFaresTable,
+		columns: []shared.Column{
+			{Name: "week_start", Type: shared.ColumnDate},
+			{Name: "pickup_zip_code", Type: shared.ColumnString},
+			{Name: "trip_type", Type: shared.ColumnString},
+			{Name: "trips", Type: shared.ColumnInteger},
+			{Name: "trimmed_trips", Type: shared.ColumnInteger},
+			{Name: "median_fare", Type: shared.ColumnFloat},
+			{Name: "tip_pct", Type: shared.ColumnFloat},
+			{Name: "revenue", Type: shared.ColumnFloat},
+		},
+		zipColumns:  []string{"pickup_zip_code"},
+		dateColumn:  "week_start",
+		defaultSort: "pickup_zip_code,week_start,trip_type",
+	},
+	{
+		field:       "permit_processing_times",
+		typeName:    "PermitProcessingTimes",
+		description: "Median and 90th percentile days from application to issue of the building permits issued per quarter, permit category, and ward, and the percentage issued within the SLA.",
+		table:       permitProcessingTable,
+		columns: []shared.Column{
+			{Name: "quarter_start", Type: shared.ColumnDate},
+			{Name: "quarter", Type: shared.ColumnString},
+			{Name: "permit_category", Type: shared.ColumnString},
+			{Name: "ward", Type: shared.ColumnString},
+			{Name: "permits", Type: shared.ColumnInteger},
+			{Name: "median_processing_days", Type: shared.ColumnFloat},
+			{Name: "p90_processing_days", Type: shared.ColumnFloat},
+			{Name: "sla_days", Type: shared.ColumnInteger},
+			{Name: "within_sla_pct", Type: shared.ColumnFloat},
+		},
+		dateColumn:  "quarter_start",
+		defaultSort: "quarter_start,permit_category,ward",
+		sortExprs:   map[string]string{"ward": `LPAD("ward", 2, '0')`},
+	},
+	{
+		field:       "daily_trips_weather",
+		typeName:    "DailyTripsWeather",
+		description: "Trips per dropoff ZIP code and day with that day's Chicago weather and holidays.",
+		table:       dailyTripsWeatherTable,
+		columns: []shared.Column{
+			{Name: "zip_code", Type: shared.ColumnString},
+			{Name: "day", Type: shared.ColumnDate},
+			{Name: "trips", Type: shared.ColumnInteger},
+			{Name: "temperature_max_f", Type: shared.ColumnFloat},
+			{Name: "temperature_min_f", Type: shared.ColumnFloat},
+			{Name: "precipitation_in", Type: shared.ColumnFloat},
+			{Name: "snowfall_in", Type: shared.ColumnFloat},
+			{Name: "is_holiday", Type: shared.ColumnBoolean},
+			{Name: "holiday", Type: shared.ColumnString},
+			{Name: "population", Type: shared.ColumnInteger},
+			{Name: "trips_per_1000_residents", Type: shared.ColumnFloat},
+		},
+		zipColumns:  []string{"zip_code"},
+		dateColumn:  "day",
+		defaultSort: "zip_code,day",
+	},
+	{
+		field:       "small_business_health",
+		typeName:    "SmallBusinessHealth",
+		description: "Active retail food licenses and food inspection outcomes per ZIP code.",
+		table:       smallBusinessHealthTable,
+		columns: []shared.Column{
+			{Name: "zip_code", Type: shared.ColumnString},
+			{Name: "active_licenses", Type: shared.ColumnInteger},
+			{Name: "inspections", Type: shared.ColumnInteger},
+			{Name: "linked_inspections", Type: shared.ColumnInteger},
+			{Name: "passed", Type: shared.ColumnInteger},
+			{Name: "failed", Type: shared.ColumnInteger},
+			{Name: "out_of_business", Type: shared.ColumnInteger},
+			{Name: "pass_rate", Type: shared.ColumnFloat},
+		},
+		zipColumns:  []string{"zip_code"},
+		defaultSort: "zip_code",
+	},
+	{
+		field:       "permit_zoning",
+		typeName:    "PermitZoning",
+		description: "Building permits with the zoning class and category of the district they fall in.",
+		table:       permitZoningTable,
+		columns: []shared.Column{
+			{Name: "id", Type: shared.ColumnString},
+			{Name: "permit_id", Type: shared.ColumnString},
+			{Name: "permit_type", Type: shared.ColumnString},
+			{Name: "permit_category", Type: shared.ColumnString},
+			{Name: "issue_date", Type: shared.ColumnDate},
+			{Name: "community_area", Type: shared.ColumnString},
+			{Name: "zip_code", Type: shared.ColumnString},
+			{Name: "zone_class", Type: shared.ColumnString},
+			{Name: "zone_category", Type: shared.ColumnString},
+			{Name: "industrial", Type: shared.ColumnBoolean},
+		},
+		zipColumns:  []string{"zip_code"},
+		areaColumns: []string{"community_area"},
+		dateColumn:  "issue_date",
+		defaultSort: "issue_date,id",
+		role:        roleInternal,
+	},
+	{
+		field:       "permit_zoning_summary",
+		typeName:    "PermitZoningSummary",
+		description: "Building permits per community area, zoning category, and permit category.",
+		table:       permitZoningSummaryTable,
+		columns: []shared.Column{
+			{Name: "community_area", Type: shared.ColumnString},
+			{Name: "zone_category", Type: shared.ColumnString},
+			{Name: "industrial", Type: shared.ColumnBoolean},
+			{Name: "permit_category", Type: shared.ColumnString},
+			{Name: "permits", Type: shared.ColumnInteger},
+			{Name: "population", Type: shared.ColumnInteger},
+			{Name: "permits_per_1000_residents", Type: shared.ColumnFloat},
+		},
+		areaColumns: []string{"community_area"},
+		defaultSort: "community_area,zone_category,permit_category",
+	},
+	{
+		field:       "permits_by_census_tract",
+		typeName:    "PermitsByCensusTract",
+		description: "Building permits per census tract (11 digit GEOID), community area, and permit category.",
+		table:       permitsByTractTable,
+		columns: []shared.Column{
+			{Name: "census_tract", Type: shared.ColumnString},
+			{Name: "community_area", Type: shared.ColumnString},
+			{Name: "permit_category", Type: shared.ColumnString},
+			{Name: "permits", Type: shared.ColumnInteger},
+			{Name: "first_issue_date", Type: shared.ColumnDate},
+			{Name: "last_issue_date", Type: shared.ColumnDate},
+			{Name: "population", Type: shared.ColumnInteger},
+			{Name: "permits_per_1000_residents", Type: shared.ColumnFloat},
+		},
+		areaColumns: []string{"community_area"},
+		defaultSort: "census_tract,permit_category",
+	},
+	{
+		field:       "trips_by_census_tract",
+		typeName:    "TripsByCensusTract",
+		description: "Weekly pickups and dropoffs per census tract (11 digit GEOID), placed by the trips' centroids.",
+		table:       tripsByTractTable,
+		columns: []shared.Column{
+			{Name: "census_tract", Type: shared.ColumnString},
+			{Name: "week_start", Type: shared.ColumnDate},
+			{Name: "pickups", Type: shared.ColumnInteger},
+			{Name: "dropoffs", Type: shared.ColumnInteger},
+			{Name: "population", Type: shared.ColumnInteger},
+			{Name: "pickups_per_1000_residents", Type: shared.ColumnFloat},
+			{Name: "dropoffs_per_1000_residents", Type: shared.ColumnFloat},
+		},
+		dateColumn:  "week_start",
+		defaultSort: "census_tract,week_start",
+	},
+	{
+		field:       "disadvantaged_areas",
+		typeName:    "DisadvantagedArea",
+		description: "Public health indicators and disadvantaged flags per community area.",
+		table:       disadvantagedTable,
+		columns: slices.Concat(datasets.PublicHealthDataset.Columns, []shared.Column{
+			{Name: "zip_code", Type: shared.ColumnString},
+			{Name: "top_5_poverty", Type: shared.ColumnBoolean},
+			{Name: "top_5_unemployment", Type: shared.ColumnBoolean},
+			{Name: "composite_score", Type: shared.ColumnFloat},
+			{Name: "composite_rank", Type: shared.ColumnInteger},
+			{Name: "disadvantaged", Type: shared.ColumnBoolean},
+			{Name: "vacant_building_reports", Type: shared.ColumnInteger},
+		}),
+		zipColumns:  []string{"zip_code"},
+		areaColumns: []string{"community_area"},
+		defaultSort: "community_area",
+		sortExprs:   map[string]string{"community_area": `"community_area"::int`},
+	},
+	{
+		field:       "composite_scores",
+		typeName:    "CompositeScore",
+		description: "Composite disadvantage score and rank per community area, with the weights used.",
+		table:       scoring.Table,
+		columns: []shared.Column{
+			{Name: "community_area", Type: shared.ColumnString},
+			{Name: "poverty_z", Type: shared.ColumnFloat},
+			{Name: "unemployment_z", Type: shared.ColumnFloat},
+			{Name: "income_z", Type: shared.ColumnFloat},
+			{Name: "ccvi_z", Type: shared.ColumnFloat},
+			{Name: "composite_score", Type: shared.ColumnFloat},
+			{Name: "composite_rank", Type: shared.ColumnInteger},
+			{Name: "weights", Type: shared.ColumnString},
+		},
+		areaColumns: []string{"community_area"},
+		defaultSort: "composite_rank",
+	},
+	{
+		field:       "disadvantaged_permits",
+		typeName:    "DisadvantagedPermit",
+		description: "Building permits with the disadvantaged flags of their community area.",
+		table:       disadvantagedPermitsTable,
+		columns: slices.Concat(datasets.BuildingPermitsDataset.Columns, []shared.Column{
+			{Name: "zip_code", Type: shared.ColumnString},
+			{Name: "spatial_community_area", Type: shared.ColumnString},
+			{Name: "crosswalk_zip_code", Type: shared.ColumnString},
+			{Name: "geography_check", Type: shared.ColumnString},
+			{Name: "top_5_poverty", Type: shared.ColumnBoolean},
+			{Name: "top_5_unemployment", Type: shared.ColumnBoolean},
+			{Name: "disadvantaged", Type: shared.ColumnBoolean},
+		}),
+		zipColumns:  []string{"zip_code"},
+		areaColumns: []string{"community_area"},
+		dateColumn:  "issue_date",
+		defaultSort: "issue_date,permit_id",
+		role:        roleInternal,
+	},
+}
+
+// graphqlRequest is the body of a POST to /graphql; GET requests carry the same fields as query parameters.
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables"`
+	OperationName string         `json:"operationName"`
+}
+
+// graphqlHandler serves /graphql, a GraphQL schema over the report tables in graphqlTables.
+func graphqlHandler(db *sql.DB) (http.HandlerFunc, error) {
+	schema, err := newGraphQLSchema(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphqlRequest
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			params := r.URL.Query()
+			req.Query = params.Get("query")
+			req.OperationName = params.Get("operationName")
+			if raw := params.Get("variables"); raw != "" {
+				if err := json.Unmarshal([]byte(raw), &req.Variables); err != nil {
+					http.Error(w, "variables must be a JSON object", http.StatusBadRequest)
+					return
+				}
+			}
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "request body must be a JSON object with a query", http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if strings.TrimSpace(req.Query) == "" {
+			http.Error(w, "query is required", http.StatusBadRequest)
+			return
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        r.Context(),
+		})
+		writeJSON(w, "graphql", result)
+	}, nil
+}
+
+func newGraphQLSchema(db *sql.DB) (graphql.Schema, error) {
+	fields := graphql.Fields{}
+	for _, table := range graphqlTables {
+		rowFields := graphql.Fields{}
+		for _, column := range table.columns {
+			rowFields[column.Name] = &graphql.Field{Type: graphqlColumnType(column.Type)}
+		}
+
+		args := graphql.FieldConfigArgument{
+			"limit": {
+				Type:         graphql.Int,
+				DefaultValue: defaultListLimit,
+				Description:  fmt.Sprintf("Maximum number of rows, at most %d.", maxListLimit),
+			},
+			"offset": {Type: graphql.Int, Description: "Number of rows to skip."},
+			"sort":   {Type: graphql.String, Description: "Comma-separated fields to sort by, each optionally prefixed with - for descending."},
+		}
+		if len(table.zipColumns) > 0 {
+			args["zip"] = &graphql.ArgumentConfig{Type: graphql.String, Description: "Five-digit ZIP code."}
+		}
+		if len(table.areaColumns) > 0 {
+			args["community_area"] = &graphql.ArgumentConfig{Type: graphql.Int, Description: "Community area number from 1 to 77."}
+		}
+		if table.dateColumn != "" {
+			args["from"] = &graphql.ArgumentConfig{Type: graphql.String, Description: "First " + table.dateColumn + " to include, like 2022-03-06."}
+			args["to"] = &graphql.ArgumentConfig{Type: graphql.String, Description: "Last " + table.dateColumn + " to include, like 2022-03-06."}
+		}
+
+		fields[table.field] = &graphql.Field{
+			Type:        graphql.NewList(graphql.NewObject(graphql.ObjectConfig{Name: table.typeName, Fields: rowFields})),
+			Description: table.description,
+			Args:        args,
+			Resolve:     table.resolver(db),
+		}
+	}
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: fields}),
+	})
+	if err != nil {
+		return graphql.Schema{}, fmt.Errorf("failed to build GraphQL schema: %w", err)
+	}
+	return schema, nil
+}
+
+func graphqlColumnType(columnType shared.ColumnType) graphql.Output {
+	switch columnType {
+	case shared.ColumnFloat:
+		return graphql.Float
+	case shared.ColumnInteger:
+		return graphql.Int
+	case shared.ColumnBoolean:
+		return graphql.Boolean
+	default:
+		// Dates and timestamps are returned as ISO 8601 strings.
+		return graphql.String
+	}
+}
+
+// listParams describes the field's arguments for listParams.parse.
+func (t graphqlTable) listParams() listParams {
+	params := listParams{sorts: map[string]string{}, defaultSort: t.defaultSort, defaultLimit: defaultListLimit}
+	for _, column := range t.columns {
+		params.sorts[column.Name] = quoteIdentifier(column.Name)
+	}
+	for name, expr := range t.sortExprs {
+		params.sorts[name] = expr
+	}
+	if len(t.zipColumns) > 0 {
+		params.filters = append(params.filters, listFilter{param: "zip", kind: filterZip, columns: t.zipColumns})
+	}
+	if len(t.areaColumns) > 0 {
+		params.filters = append(params.filters, listFilter{param: "community_area", kind: filterCommunityArea, columns: t.areaColumns})
+	}
+	if t.dateColumn != "" {
+		params.filters = append(params.filters,
+			listFilter{param: "from", kind: filterDate, columns: []string{t.dateColumn}, op: ">="},
+			listFilter{param: "to", kind: filterDate, columns: []string{t.dateColumn}, op: "<="})
+	}
+	return params
+}
+
+// resolver reads the selected columns of the rows that match the field's arguments.
+func (t graphqlTable) resolver(db *sql.DB) graphql.FieldResolveFn {
+	params := t.listParams()
+
+	return func(p graphql.ResolveParams) (any, error) {
+		if requestRole(p.Context) < t.role {
+			return nil, fmt.Errorf("%s requires an internal API token", t.field)
+		}
+
+		arguments := url.Values{}
+		for name, value := range p.Args {
+			arguments.Set(name, fmt.Sprint(value))
+		}
+		query, err := params.parse(arguments)
+		if err != nil {
+			return nil, err
+		}
+
+		columns := t.selectedColumns(p)
+		selectList := make([]string, len(columns))
+		for i, column := range columns {
+			selectList[i] = quoteIdentifier(column.Name)
+		}
+		statement, args := query.build(strings.Join(selectList, ", "), t.table)
+
+		rows, err := db.QueryContext(p.Context, statement, args...)
+		if err != nil {
+			log.Printf("failed to query %s: %v", t.table, err)
+			return nil, fmt.Errorf("%s is not available", t.table)
+		}
+		defer rows.Close()
+
+		found := []map[string]any{}
+		values := make([]any, len(columns))
+		targets := make([]any, len(columns))
+		for i := range values {
+			targets[i] = &values[i]
+		}
+		for rows.Next() {
+			if err := rows.Scan(targets...); err != nil {
+				log.Printf("failed to scan %s row: %v", t.table, err)
+				return nil, fmt.Errorf("%s is not available", t.table)
+			}
+			row := make(map[string]any, len(columns))
+			for i, column := range columns {
+				row[column.Name] = graphqlValue(column.Type, values[i])
+			}
+			found = append(found, row)
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("error while reading %s: %v", t.table, err)
+			return nil, fmt.Errorf("%s is not available", t.table)
+		}
+		return found, nil
+	}
+}
+
+// selectedColumns returns the columns the query selects from the field, in table order.
+func (t graphqlTable) selectedColumns(p graphql.ResolveParams) []shared.Column {
+	selected := map[string]bool{}
+	for _, field := range p.Info.FieldASTs {
+		collectSelectedFields(field.SelectionSet, p.Info.Fragments, selected)
+	}
+	var columns []shared.Column
+	for _, column := range t.columns {
+		if selected[column.Name] {
+			columns = append(columns, column)
+		}
+	}
+	if len(columns) == 0 {
+		// Only __typename was asked for; the rows still have to be counted.
+		columns = t.columns[:1]
+	}
+	return columns
+}
+
+// collectSelectedFields adds the names of the fields selected in set, including through fragments, to selected.
+func collectSelectedFields(set *ast.SelectionSet, fragments map[string]ast.Definition, selected map[string]bool) {
+	if set == nil {
+		return
+	}
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			selected[selection.Name.Value] = true
+		case *ast.InlineFragment:
+			collectSelectedFields(selection.SelectionSet, fragments, selected)
+		case *ast.FragmentSpread:
+			if fragment, ok := fragments[selection.Name.Value].(*ast.FragmentDefinition); ok {
+				collectSelectedFields(fragment.SelectionSet, fragments, selected)
+			}
+		}
+	}
+}
+
+// graphqlValue converts a scanned column value to what its GraphQL field type serializes. lib/pq returns
+// text and numeric columns as bytes.
+func graphqlValue(columnType shared.ColumnType, value any) any {
+	switch value := value.(type) {
+	case []byte:
+		text := string(value)
+		switch columnType {
+		case shared.ColumnFloat:
+			if f, err := strconv.ParseFloat(text, 64); err == nil {
+				return f
+			}
+		case shared.ColumnInteger:
+			if f, err := strconv.ParseFloat(text, 64); err == nil {
+				return int64(f)
+			}
+		}
+		return text
+	case time.Time:
+		if columnType == shared.ColumnDate {
+			return value.Format("2006-01-02")
+		}
+		return value.Format(time.RFC3339)
+	default:
+		return value
+	}
+}
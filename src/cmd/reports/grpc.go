@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ahbreck/Chicago_BI/reportspb"
+)
+
+// grpcPortEnvKey enables the gRPC API on the given port; it is off when unset, since Cloud Run routes a
+// single port per service.
+const grpcPortEnvKey = "GRPC_PORT"
+
+// reportsServer implements reportspb.ReportsServer on top of the same queries as the REST endpoints.
+type reportsServer struct {
+	reportspb.UnimplementedReportsServer
+	db *sql.DB
+}
+
+// startGRPCServer serves the Reports gRPC service on port until ctx is done, guarded by access.
+func startGRPCServer(ctx context.Context, db *sql.DB, port string, access *accessControl) error {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return fmt.Errorf("failed to listen on gRPC port %s: %w", port, err)
+	}
+
+	server := grpc.NewServer(access.grpcServerOptions()...)
+	reportspb.RegisterReportsServer(server, &reportsServer{db: db})
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+	go func() {
+		log.Printf("gRPC API listening on port %s", port)
+		if err := server.Serve(listener); err != nil {
+			log.Printf("gRPC server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (s *reportsServer) ListAirportTrips(ctx context.Context, req *reportspb.AirportTripsRequest) (*reportspb.ListAirportTripsResponse, error) {
+	resp := &reportspb.ListAirportTripsResponse{}
+	query, err := grpcListQuery(airportTripsParams, url.Values{"zip": {req.GetZipCode()}, "week": {req.GetWeekStart()}})
+	if err == nil {
+		err = queryAirportTrips(ctx, s.db, query, func(row airportTripsRow) error {
+			resp.AirportTrips = append(resp.AirportTrips, airportTripsMessage(row))
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, grpcQueryError(reqAirportTripsTable, err)
+	}
+	return resp, nil
+}
+
+func (s *reportsServer) StreamAirportTrips(req *reportspb.AirportTripsRequest, stream reportspb.Reports_StreamAirportTripsServer) error {
+	query, err := grpcListQuery(airportTripsParams, url.Values{"zip": {req.GetZipCode()}, "week": {req.GetWeekStart()}})
+	if err == nil {
+		err = queryAirportTrips(stream.Context(), s.db, query, func(row airportTripsRow) error {
+			return stream.Send(airportTripsMessage(row))
+		})
+	}
+	return grpcQueryError(reqAirportTripsTable, err)
+}
+
+func (s *reportsServer) ListDisadvantagedAreas(ctx context.Context, req *reportspb.DisadvantagedAreasRequest) (*reportspb.ListDisadvantagedAreasResponse, error) {
+	resp := &reportspb.ListDisadvantagedAreasResponse{}
+	query, err := grpcListQuery(disadvantagedAreasParams, url.Values{"only_disadvantaged": {strconv.FormatBool(req.GetOnlyDisadvantaged())}})
+	if err == nil {
+		err = queryDisadvantagedAreas(ctx, s.db, query, func(row disadvantagedAreaRow) error {
+			resp.Areas = append(resp.Areas, &reportspb.DisadvantagedArea{
+				CommunityArea:     row.CommunityArea,
+				BelowPovertyLevel: row.BelowPovertyLevel,
+				Unemployment:      row.Unemployment,
+				PerCapitaIncome:   row.PerCapitaIncome,
+				Top_5Poverty:      row.Top5Poverty,
+				Top_5Unemployment: row.Top5Unemployment,
+				Disadvantaged:     row.Disadvantaged,
+			})
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, grpcQueryError(disadvantagedTable, err)
+	}
+	return resp, nil
+}
+
+func (s *reportsServer) StreamCovidAlerts(req *reportspb.CovidAlertsRequest, stream reportspb.Reports_StreamCovidAlertsServer) error {
+	query, err := grpcListQuery(covidAlertsParams, url.Values{
+		"zip":       {req.GetZipCode()},
+		"week":      {req.GetWeekStart()},
+		"covid_cat": {req.GetCovidCat()},
+	})
+	if err == nil {
+		err = queryCovidAlerts(stream.Context(), s.db, query, func(row covidAlertRow) error {
+			return stream.Send(&reportspb.CovidAlert{
+				TripId:             row.TripID,
+				TripStartTimestamp: timestamppb.New(row.TripStartTimestamp),
+				WeekStart:          row.WeekStart,
+				PickupZipCode:      row.PickupZipCode,
+				DropoffZipCode:     row.DropoffZipCode,
+				PickupCovidCat:     row.PickupCovidCat,
+				DropoffCovidCat:    row.DropoffCovidCat,
+				AirportPickup:      row.AirportPickup,
+				AirportDropoff:     row.AirportDropoff,
+			})
+		})
+	}
+	return grpcQueryError(covidAlertsTable, err)
+}
+
+func airportTripsMessage(row airportTripsRow) *reportspb.AirportTrips {
+	return &reportspb.AirportTrips{
+		ZipCode:          row.ZipCode,
+		WeekStart:        row.WeekStart,
+		CaseRateWeekly:   row.CaseRateWeekly,
+		CovidCat:         row.CovidCat,
+		TripsToAirport:   row.TripsToAirport,
+		TripsFromAirport: row.TripsFromAirport,
+	}
+}
+
+// grpcListQuery parses request fields with the same rules as the REST parameters they mirror. Large results
+// are read through the streaming RPCs, so no page limit applies.
+func grpcListQuery(params listParams, values url.Values) (listQuery, error) {
+	query, err := params.parse(values)
+	query.limit = 0
+	return query, err
+}
+
+// grpcQueryError maps a report query error to a gRPC status, mirroring writeQueryError. Errors that are
+// already statuses, such as a failed stream.Send, pass through.
+func grpcQueryError(table string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var invalid invalidQueryError
+	if errors.As(err, &invalid) {
+		return status.Error(codes.InvalidArgument, invalid.Error())
+	}
+	log.Printf("failed to read %s: %v", table, err)
+	return status.Errorf(codes.Unavailable, "%s is not available", table)
+}
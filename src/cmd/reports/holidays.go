@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	holidaysTable = "holidays"
+
+	// holidaysFirstYear is the first year of the trips datasets; the calendar starts there.
+	holidaysFirstYear = 2013
+
+	holidayScopeFederal  = "federal"
+	holidayScopeIllinois = "illinois"
+	holidayScopeChicago  = "chicago"
+)
+
+// holiday is one row of the holidays table. A federal holiday falling on a weekend gets a second row on the
+// weekday it is observed, named like "Independence Day (observed)".
+type holiday struct {
+	date time.Time
+	name string
+	// scope is who observes the holiday: every employer following the federal calendar, the State of
+	// Illinois, or only the City of Chicago.
+	scope string
+}
+
+// chicagoHolidays returns the holidays observed in Chicago in year: the federal holidays, the Illinois
+// state holidays, and the day after Thanksgiving, which city offices also close for.
+func chicagoHolidays(year int) []holiday {
+	thanksgiving := nthWeekday(year, time.November, time.Thursday, 4)
+
+	var holidays []holiday
+	federal := func(date time.Time, name string) {
+		holidays = append(holidays, holiday{date: date, name: name, scope: holidayScopeFederal})
+	}
+	// fixedFederal adds a holiday on a calendar date, and the Friday before or Monday after it when it falls
+	// on a weekend.
+	fixedFederal := func(month time.Month, day int, name string) {
+		date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		federal(date, name)
+		switch date.Weekday() {
+		case time.Saturday:
+			federal(date.AddDate(0, 0, -1), name+" (observed)")
+		case time.Sunday:
+			federal(date.AddDate(0, 0, 1), name+" (observed)")
+		}
+	}
+
+	fixedFederal(time.January, 1, "New Year's Day")
+	federal(nthWeekday(year, time.January, time.Monday, 3), "Martin Luther King Jr. Day")
+	holidays = append(holidays, holiday{date: time.Date(year, time.February, 12, 0, 0, 0, 0, time.UTC), name: "Lincoln's Birthday", scope: holidayScopeIllinois})
+	federal(nthWeekday(year, time.February, time.Monday, 3), "Presidents' Day")
+	holidays = append(holidays, holiday{date: nthWeekday(year, time.March, time.Monday, 1), name: "Casimir Pulaski Day", scope: holidayScopeIllinois})
+	federal(lastWeekday(year, time.May, time.Monday), "Memorial Day")
+	// Juneteenth became a federal holiday in 2021.
+	if year >= 2021 {
+		fixedFederal(time.June, 19, "Juneteenth")
+	}
+	fixedFederal(time.July, 4, "Independence Day")
+	federal(nthWeekday(year, time.September, time.Monday, 1), "Labor Day")
+	federal(nthWeekday(year, time.October, time.Monday, 2), "Columbus Day")
+	fixedFederal(time.November, 11, "Veterans Day")
+	federal(thanksgiving, "Thanksgiving")
+	holidays = append(holidays, holiday{date: thanksgiving.AddDate(0, 0, 1), name: "Day after Thanksgiving", scope: holidayScopeChicago})
+	fixedFederal(time.December, 25, "Christmas Day")
+	return holidays
+}
+
+// nthWeekday returns the nth weekday of month, counting from 1.
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	offset := (int(weekday) - int(first.Weekday()) + 7) % 7
+	return first.AddDate(0, 0, offset+7*(n-1))
+}
+
+// lastWeekday returns the last weekday of month.
+func lastWeekday(year int, month time.Month, weekday time.Weekday) time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+	offset := (int(last.Weekday()) - int(weekday) + 7) % 7
+	return last.AddDate(0, 0, -offset)
+}
+
+// ensureHolidaysTable creates the holidays table when it does not exist and adds the holidays from
+// holidaysFirstYear through next year, so forecasts into next year are covered. Rows already present are
+// kept, so the reports reading the table can each call it before they build.
+func ensureHolidaysTable(db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	createStmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		"date" DATE NOT NULL,
+		"name" VARCHAR(64) NOT NULL,
+		"scope" VARCHAR(16) NOT NULL,
+		PRIMARY KEY ("date", "name")
+	)`, quoteIdentifier(holidaysTable))
+	if _, err := db.Exec(createStmt); err != nil {
+		return fmt.Errorf("failed to create %s: %w", holidaysTable, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start %s transaction: %w", holidaysTable, err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf(`INSERT INTO %s ("date", "name", "scope") VALUES ($1, $2, $3)
+		ON CONFLICT ("date", "name") DO UPDATE SET scope = EXCLUDED.scope`, quoteIdentifier(holidaysTable)))
+	if err != nil {
+		return fmt.Errorf("failed to prepare %s insert: %w", holidaysTable, err)
+	}
+	defer stmt.Close()
+
+	for year := holidaysFirstYear; year <= time.Now().Year()+1; year++ {
+		for _, h := range chicagoHolidays(year) {
+			if _, err := stmt.Exec(h.date.Format("2006-01-02"), h.name, h.scope); err != nil {
+				return fmt.Errorf("failed to add %s on %s to %s: %w", h.name, h.date.Format("2006-01-02"), holidaysTable, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit %s: %w", holidaysTable, err)
+	}
+	return nil
+}
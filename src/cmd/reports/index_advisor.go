@@ -0,0 +1,336 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+)
+
+const (
+	// perfRecommendationsTable keeps the indexes the index advisor suggests, one row per table and column.
+	perfRecommendationsTable = "perf_recommendations"
+
+	// advisedSlowQueries is how many of the slowest statements, by mean time, the advisor reads.
+	advisedSlowQueries = 25
+	// minAdvisedTableRows leaves small tables out, since scanning them is cheaper than keeping an index.
+	minAdvisedTableRows = 10000
+	// maxIndexNameLength is Postgres's identifier limit.
+	maxIndexNameLength = 63
+)
+
+// indexAdvisorSkipped remembers that pg_stat_statements is unavailable, so that is logged once.
+var indexAdvisorSkipped sync.Once
+
+// slowQuery is a statement from pg_stat_statements.
+type slowQuery struct {
+	id     int64
+	text   string
+	calls  int64
+	meanMs float64
+}
+
+// scannedTable is a managed table Postgres reads mostly by sequential scans.
+type scannedTable struct {
+	name       string
+	seqScans   int64
+	seqRows    int64
+	indexScans int64
+}
+
+// indexCandidate is a column of a scanned table filtered, joined, or grouped on by slow queries.
+type indexCandidate struct {
+	table   scannedTable
+	column  string
+	queries []slowQuery
+	totalMs float64
+}
+
+// adviseIndexes looks for indexes that would speed up the slowest report and API queries after a report
+// cycle. Managed tables read mostly by sequential scans are matched with the columns the slowest statements
+// in pg_stat_statements filter, join, or group them on, and each such column without an index is logged
+// and recorded in perf_recommendations for operators to review. Nothing is created. Databases without the
+// pg_stat_statements extension are skipped.
+func adviseIndexes(db *sql.DB) {
+	if !statStatementsAvailable(db) {
+		return
+	}
+	if err := ensurePerfRecommendationsTable(db); err != nil {
+		log.Printf("index advisor: %v", err)
+		return
+	}
+
+	queries, err := slowestQueries(db)
+	if err != nil {
+		log.Printf("index advisor: %v", err)
+		return
+	}
+	tables, err := sequentiallyScannedTables(db, managedTables())
+	if err != nil {
+		log.Printf("index advisor: %v", err)
+		return
+	}
+
+	var candidates []indexCandidate
+	for _, table := range tables {
+		found, err := tableIndexCandidates(db, table, queries)
+		if err != nil {
+			log.Printf("index advisor: %v", err)
+			continue
+		}
+		candidates = append(candidates, found...)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].totalMs > candidates[j].totalMs })
+
+	for _, candidate := range candidates {
+		suggestion := indexSuggestion(candidate.table.name, candidate.column)
+		log.Printf("index advisor: %s (%s)", suggestion, candidate.reason())
+		if err := recordPerfRecommendation(db, candidate, suggestion); err != nil {
+			log.Printf("index advisor: %v", err)
+		}
+	}
+}
+
+// statStatementsAvailable creates the pg_stat_statements extension when it is missing and reports whether
+// it can be read. It needs pg_stat_statements in shared_preload_libraries.
+func statStatementsAvailable(db *sql.DB) bool {
+	_, err := db.Exec(`CREATE EXTENSION IF NOT EXISTS pg_stat_statements`)
+	if err == nil {
+		_, err = db.Exec(`SELECT 1 FROM pg_stat_statements LIMIT 1`)
+	}
+	if err != nil {
+		indexAdvisorSkipped.Do(func() {
+			log.Printf("index advisor disabled: pg_stat_statements is not available: %v", err)
+		})
+		return false
+	}
+	return true
+}
+
+func ensurePerfRecommendationsTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		"id" SERIAL PRIMARY KEY,
+		"table_name" VARCHAR(255) NOT NULL,
+		"column_name" VARCHAR(255) NOT NULL,
+		"suggestion" TEXT NOT NULL,
+		"reason" TEXT NOT NULL,
+		"seq_scans" BIGINT NOT NULL,
+		"seq_rows_read" BIGINT NOT NULL,
+		"query_time_ms" DOUBLE PRECISION NOT NULL,
+		"first_seen" TIMESTAMP WITH TIME ZONE NOT NULL,
+		"last_seen" TIMESTAMP WITH TIME ZONE NOT NULL,
+		UNIQUE ("table_name", "column_name")
+	)`, quoteIdentifier(perfRecommendationsTable)))
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", perfRecommendationsTable, err)
+	}
+	return nil
+}
+
+// managedTables returns the collector tables and the report tables every report job builds.
+func managedTables() []string {
+	var tables []string
+	for _, dataset := range datasets.ManagedDatasets {
+		tables = append(tables, dataset.Table)
+	}
+	for _, job := range reportJobs {
+		for table := range job.sources {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// slowestQueries returns the advisedSlowQueries slowest statements of the database by mean time that
+// read tables: queries, DML, and CREATE TABLE ... AS.
+func slowestQueries(db *sql.DB) ([]slowQuery, error) {
+	rows, err := db.Query(`SELECT queryid, query, calls, mean_exec_time
+		FROM pg_stat_statements
+		WHERE dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+		ORDER BY mean_exec_time DESC
+		LIMIT $1`, advisedSlowQueries*8)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pg_stat_statements: %w", err)
+	}
+	defer rows.Close()
+
+	var queries []slowQuery
+	for rows.Next() {
+		var query slowQuery
+		if err := rows.Scan(&query.id, &query.text, &query.calls, &query.meanMs); err != nil {
+			return nil, fmt.Errorf("failed to scan pg_stat_statements row: %w", err)
+		}
+		if len(queries) < advisedSlowQueries && explainable(query.text) {
+			queries = append(queries, query)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pg_stat_statements: %w", err)
+	}
+	return queries, nil
+}
+
+// sequentiallyScannedTables returns the tables among names in the current schema with at least
+// minAdvisedTableRows rows that were scanned sequentially more often than through an index.
+func sequentiallyScannedTables(db *sql.DB, names []string) ([]scannedTable, error) {
+	rows, err := db.Query(`SELECT relname, seq_scan, seq_tup_read, COALESCE(idx_scan, 0)
+		FROM pg_stat_user_tables
+		WHERE schemaname = current_schema()
+			AND relname = ANY($1)
+			AND n_live_tup >= $2
+			AND seq_scan > COALESCE(idx_scan, 0)
+		ORDER BY seq_tup_read DESC`, pq.Array(names), minAdvisedTableRows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table scan statistics: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []scannedTable
+	for rows.Next() {
+		var table scannedTable
+		if err := rows.Scan(&table.name, &table.seqScans, &table.seqRows, &table.indexScans); err != nil {
+			return nil, fmt.Errorf("failed to scan table scan statistics: %w", err)
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// tableIndexCandidates returns the columns of table, not already leading an index, that queries reading
+// table filter, join, or group on.
+func tableIndexCandidates(db *sql.DB, table scannedTable, queries []slowQuery) ([]indexCandidate, error) {
+	tablePattern := regexp.MustCompile(`(?i)(^|[^\w"])"?` + regexp.QuoteMeta(table.name) + `"?($|[^\w"])`)
+	var reading []slowQuery
+	for _, query := range queries {
+		if tablePattern.MatchString(query.text) {
+			reading = append(reading, query)
+		}
+	}
+	if len(reading) == 0 {
+		return nil, nil
+	}
+
+	indexed, err := leadingIndexColumns(db, table.name)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := tableColumns(db, table.name)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []indexCandidate
+	for _, column := range columns {
+		if indexed[column] {
+			continue
+		}
+		candidate := indexCandidate{table: table, column: column}
+		for _, query := range reading {
+			if columnPredicatePattern(column).MatchString(query.text) {
+				candidate.queries = append(candidate.queries, query)
+				candidate.totalMs += query.meanMs * float64(query.calls)
+			}
+		}
+		if len(candidate.queries) > 0 {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates, nil
+}
+
+// columnPredicatePattern matches column used in a WHERE, ON, or GROUP BY clause: compared after WHERE, AND,
+// OR, or ON, on either side of an equality, or grouped by.
+func columnPredicatePattern(column string) *regexp.Regexp {
+	ref := `(\w+\.)?"?` + regexp.QuoteMeta(column) + `"?`
+	return regexp.MustCompile(`(?i)(\b(WHERE|AND|OR|ON)\s+` + ref + `\s*(=|<>|!=|<|>|\bIN\b|\bBETWEEN\b|\bLIKE\b|\bIS\b))` +
+		`|(=\s*` + ref + `($|[^\w"]))` +
+		`|(\bGROUP\s+BY\s+` + ref + `($|[^\w"]))`)
+}
+
+// leadingIndexColumns returns the columns of table in the current schema that lead an index.
+func leadingIndexColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT a.attname
+		FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum = i.indkey[0]
+		WHERE n.nspname = current_schema() AND c.relname = $1`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read indexes of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	indexed := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("failed to scan index of %s: %w", table, err)
+		}
+		indexed[column] = true
+	}
+	return indexed, rows.Err()
+}
+
+func tableColumns(db *sql.DB, table string) ([]string, error) {
+	rows, err := db.Query(`SELECT column_name
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1
+		ORDER BY ordinal_position`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("failed to scan column of %s: %w", table, err)
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// indexSuggestion is the statement that would create the suggested index without blocking writes.
+func indexSuggestion(table, column string) string {
+	name := table + "_" + column + "_idx"
+	if len(name) > maxIndexNameLength {
+		name = name[:maxIndexNameLength]
+	}
+	return fmt.Sprintf("CREATE INDEX CONCURRENTLY IF NOT EXISTS %s ON %s (%s)", quoteIdentifier(name), quoteIdentifier(table), quoteIdentifier(column))
+}
+
+// reason explains the suggestion: how the table is scanned and which slow queries use the column.
+func (c indexCandidate) reason() string {
+	ids := make([]string, len(c.queries))
+	for i, query := range c.queries {
+		ids[i] = fmt.Sprint(query.id)
+	}
+	return fmt.Sprintf("%d sequential scans reading %d rows against %d index scans; used by %d slow queries (queryid %s) taking %.0f ms in total",
+		c.table.seqScans, c.table.seqRows, c.table.indexScans, len(c.queries), strings.Join(ids, ", "), c.totalMs)
+}
+
+func recordPerfRecommendation(db *sql.DB, candidate indexCandidate, suggestion string) error {
+	stmt := fmt.Sprintf(`INSERT INTO %s ("table_name", "column_name", "suggestion", "reason", "seq_scans", "seq_rows_read", "query_time_ms", "first_seen", "last_seen")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
+		ON CONFLICT ("table_name", "column_name") DO UPDATE
+		SET suggestion = EXCLUDED.suggestion,
+			reason = EXCLUDED.reason,
+			seq_scans = EXCLUDED.seq_scans,
+			seq_rows_read = EXCLUDED.seq_rows_read,
+			query_time_ms = EXCLUDED.query_time_ms,
+			last_seen = EXCLUDED.last_seen`, quoteIdentifier(perfRecommendationsTable))
+	if _, err := db.Exec(stmt, candidate.table.name, candidate.column, suggestion, candidate.reason(),
+		candidate.table.seqScans, candidate.table.seqRows, candidate.totalMs); err != nil {
+		return fmt.Errorf("failed to record index suggestion for %s.%s: %w", candidate.table.name, candidate.column, err)
+	}
+	return nil
+}
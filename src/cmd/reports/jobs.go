@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// reportJob is one report builder run by the reports cycle or on demand through /run.
+type reportJob struct {
+	name    string
+	build   func(db *sql.DB) error
+	sources map[string][]string
+	// metadata records, per report table, how it was computed, such as a score's formula, in its lineage.
+	metadata map[string]map[string]string
+	// assertions are checked after every build; see checkReportAssertions.
+	assertions []reportAssertion
+	// publish, when set, pushes the freshly built report to subscribers once it passed its assertions.
+	publish func(db *sql.DB) error
+}
+
+// reportJobs lists the report builders in the order each cycle runs them.
+var reportJobs = []reportJob{
+	{name: "covid_category", build: CreateCovidCategoryReport, sources: covidReportSources, assertions: covidReportAssertions, publish: publishCovidAlerts},
+	{name: "disadvantaged", build: CreateDisadvantagedReport, sources: disadvantagedReportSources, metadata: disadvantagedReportMetadata, assertions: disadvantagedReportAssertions},
+	{name: "coverage_gaps", build: CreateCoverageGapsReport, sources: coverageGapsReportSources},
+	{name: "anomalies", build: CreateAnomaliesReport, sources: anomaliesReportSources},
+	{name: "trips_by_time", build: CreateTripsByTimeReport, sources: tripsByTimeReportSources, assertions: tripsByTimeReportAssertions},
+	{name: "trip_fares", build: CreateTripFaresReport, sources: tripFaresReportSources, assertions: tripFaresReportAssertions},
+	{name: "daily_trips_weather", build: CreateDailyTripsWeatherReport, sources: dailyTripsWeatherReportSources, assertions: dailyTripsWeatherReportAssertions},
+	{name: "small_business_health", build: CreateSmallBusinessHealthReport, sources: smallBusinessHealthReportSources},
+	{name: "zoning", build: CreateZoningReport, sources: zoningReportSources},
+	{name: "permit_processing", build: CreatePermitProcessingReport, sources: permitProcessingReportSources, assertions: permitProcessingReportAssertions},
+	{name: "census_tracts", build: CreateCensusTractReport, sources: censusTractReportSources, assertions: censusTractReportAssertions},
+	{name: "star_schema", build: CreateStarSchemaReport, sources: starSchemaReportSources, assertions: starSchemaReportAssertions},
+}
+
+// reportRunMu keeps on-demand runs from rebuilding report tables while the cycle is rebuilding them.
+var reportRunMu sync.Mutex
+
+// buildMetadata collects the lineage metadata the report being built notes about how it was built, such as a
+// fallback it took. Builds are serialized by reportRunMu, so a single collection is enough.
+var buildMetadata struct {
+	sync.Mutex
+	tables map[string]map[string]string
+}
+
+// noteReportMetadata records key in the lineage metadata of table for the report being built.
+func noteReportMetadata(table, key, value string) {
+	buildMetadata.Lock()
+	defer buildMetadata.Unlock()
+	if buildMetadata.tables == nil {
+		buildMetadata.tables = map[string]map[string]string{}
+	}
+	if buildMetadata.tables[table] == nil {
+		buildMetadata.tables[table] = map[string]string{}
+	}
+	buildMetadata.tables[table][key] = value
+}
+
+// takeReportMetadata returns the metadata of a job, overlaid with what was noted since the last call, and
+// starts a new collection.
+func takeReportMetadata(metadata map[string]map[string]string) map[string]map[string]string {
+	buildMetadata.Lock()
+	noted := buildMetadata.tables
+	buildMetadata.tables = nil
+	buildMetadata.Unlock()
+
+	if len(noted) == 0 {
+		return metadata
+	}
+	merged := make(map[string]map[string]string, len(metadata)+len(noted))
+	for table, values := range metadata {
+		merged[table] = maps.Clone(values)
+	}
+	for table, values := range noted {
+		if merged[table] == nil {
+			merged[table] = map[string]string{}
+		}
+		maps.Copy(merged[table], values)
+	}
+	return merged
+}
+
+// errReportLocked is returned by runReport when another instance is building the same report. It is in the
+// shared.ErrDBConflict category.
+var errReportLocked = shared.DBConflict(errors.New("report is being built by another instance"))
+
+// runReport builds one report, re-applies its grants, checks its assertions, records its statement timings,
+// lineage with the metadata the build noted, status, and snapshots, and publishes it. A report that fails
+// its assertions stays built, but runReport returns an error wrapping errAssertionsFailed. A Postgres
+// advisory lock per report keeps instances sharing the database from building it at the same time; when
+// another instance holds it the build is skipped with errReportLocked.
+func runReport(db *sql.DB, job reportJob) error {
+	reportRunMu.Lock()
+	defer reportRunMu.Unlock()
+
+	lock, err := shared.TryAdvisoryLock(context.Background(), db, shared.ReportLockNamespace, job.name)
+	if err != nil {
+		return fmt.Errorf("failed to build %s report: %w", job.name, err)
+	}
+	if lock == nil {
+		log.Printf("skipping %s report: another instance is building it", job.name)
+		return fmt.Errorf("%s: %w", job.name, errReportLocked)
+	}
+	defer lock.Release()
+
+	log.Printf("building %s report", job.name)
+	started := time.Now()
+	takeStatementTimings()
+	takeReportMetadata(nil)
+	err = job.build(db)
+	recordStatementTimings(db, job.name, takeStatementTimings())
+	metadata := takeReportMetadata(job.metadata)
+	if err != nil {
+		err = fmt.Errorf("failed to build %s report: %w", job.name, err)
+		recordReportStatus(db, job, err)
+		return err
+	}
+
+	log.Printf("%s report refreshed", job.name)
+	recordReportLineage(db, job.sources, metadata, time.Since(started))
+	grantReportTables(db, job.sources)
+	// A report that breaks its assertions is not snapshotted or exported, so bad rows do not spread further.
+	if failures := checkReportAssertions(db, job); len(failures) > 0 {
+		err := reportAssertionFailures(job, failures)
+		recordReportStatus(db, job, err)
+		return err
+	}
+	snapshotReports(db, job.sources)
+	exportReportSheets(db, job.sources)
+	// A failed publish is retried after the next build; it does not fail the report.
+	if job.publish != nil {
+		if err := job.publish(db); err != nil {
+			log.Printf("failed to publish %s report: %v", job.name, err)
+		}
+	}
+	recordReportStatus(db, job, nil)
+	return nil
+}
+
+// recordReportStatus records the outcome of a run of job in job_status, logging rather than returning
+// failures to do so.
+func recordReportStatus(db *sql.DB, job reportJob, runErr error) {
+	if err := shared.RecordJobStatus(db, "reports", job.name, runErr, nil); err != nil {
+		log.Print(err)
+	}
+}
+
+func findReportJob(name string) (reportJob, bool) {
+	for _, job := range reportJobs {
+		if job.name == name {
+			return job, true
+		}
+	}
+	return reportJob{}, false
+}
+
+// runReportHandler builds the report named by the report query parameter and waits for it to finish. With
+// a schema parameter naming a smoke test schema (see cbictl smoke) the report reads and writes that schema
+// instead of the live tables.
+func runReportHandler(db *sql.DB, connStr string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := r.URL.Query().Get("report")
+		job, ok := findReportJob(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown report %q", name), http.StatusNotFound)
+			return
+		}
+
+		target := db
+		if schema := r.URL.Query().Get("schema"); schema != "" {
+			if !shared.IsSmokeSchema(schema) {
+				http.Error(w, fmt.Sprintf("schema %q is not a smoke test schema", schema), http.StatusBadRequest)
+				return
+			}
+
+			smokeDB, err := sql.Open("postgres", shared.WithSearchPath(connStr, schema))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer smokeDB.Close()
+			target = smokeDB
+		}
+
+		if err := runReport(target, job); errors.Is(err, shared.ErrDBConflict) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "%s report refreshed\n", job.name)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultListLimit is the page size of list endpoints that page by default; maxListLimit caps ?limit=.
+	defaultListLimit = 1000
+	maxListLimit     = 10000
+)
+
+// listParams describes the query parameters a list endpoint accepts: typed filters, a whitelist of sort
+// keys, and limit/offset paging. Sorting is given as ?sort=key,-key, where a leading - sorts descending.
+type listParams struct {
+	// sorts maps each sort key to the SQL expression it orders by.
+	sorts map[string]string
+	// defaultSort, in ?sort= syntax, follows the requested order so that pages are stable.
+	defaultSort string
+	filters     []listFilter
+	// defaultLimit applies when ?limit= is absent; 0 returns every row.
+	defaultLimit int
+}
+
+type filterKind int
+
+const (
+	filterText filterKind = iota
+	filterZip
+	filterCommunityArea
+	filterDate
+	// filterFlag keeps only the rows where the column is true when the parameter is true, and does not
+	// filter otherwise.
+	filterFlag
+)
+
+// listFilter compares one query parameter against columns; with several columns a row matches when any
+// of them does.
+type listFilter struct {
+	param   string
+	kind    filterKind
+	columns []string
+	// op defaults to =; date ranges use >= and <=.
+	op string
+	// values restricts a filterText parameter to a fixed set.
+	values []string
+}
+
+// listQuery is a parsed list request, ready to be rendered by build.
+type listQuery struct {
+	conditions []string
+	args       []any
+	orderBy    string
+	limit      int
+	offset     int
+}
+
+// parse reads a list request's parameters. Empty parameters are ignored; invalid ones are reported as an
+// invalidQueryError naming the parameter.
+func (p listParams) parse(params url.Values) (listQuery, error) {
+	var query listQuery
+	for _, filter := range p.filters {
+		raw := strings.TrimSpace(params.Get(filter.param))
+		if raw == "" {
+			continue
+		}
+		if err := query.addFilter(filter, raw); err != nil {
+			return listQuery{}, err
+		}
+	}
+
+	orderBy, err := p.orderBy(params.Get("sort"))
+	if err != nil {
+		return listQuery{}, err
+	}
+	query.orderBy = orderBy
+
+	query.limit = p.defaultLimit
+	if raw := strings.TrimSpace(params.Get("limit")); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return listQuery{}, invalidQueryError{param: "limit", message: fmt.Sprintf("limit must be a number from 1 to %d", maxListLimit)}
+		}
+		query.limit = limit
+	}
+	if raw := strings.TrimSpace(params.Get("offset")); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return listQuery{}, invalidQueryError{param: "offset", message: "offset must be a non-negative number"}
+		}
+		query.offset = offset
+	}
+	return query, nil
+}
+
+func (q *listQuery) addFilter(filter listFilter, raw string) error {
+	var value any = raw
+	cast := ""
+	switch filter.kind {
+	case filterText:
+		if len(filter.values) > 0 && !slices.Contains(filter.values, raw) {
+			return invalidQueryError{param: filter.param, message: fmt.Sprintf("%s must be one of %s", filter.param, strings.Join(filter.values, ", "))}
+		}
+	case filterZip:
+		if !zipCodePattern.MatchString(raw) {
+			return invalidQueryError{param: filter.param, message: filter.param + " must be a five-digit ZIP code"}
+		}
+	case filterCommunityArea:
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > communityAreaCount {
+			return invalidQueryError{param: filter.param, message: fmt.Sprintf("%s must be a number from 1 to %d", filter.param, communityAreaCount)}
+		}
+		value = strconv.Itoa(n)
+	case filterDate:
+		if _, err := time.Parse("2006-01-02", raw); err != nil {
+			return invalidQueryError{param: filter.param, message: filter.param + " must be a date like 2022-03-06"}
+		}
+		cast = "::date"
+	case filterFlag:
+		flag, err := strconv.ParseBool(raw)
+		if err != nil {
+			return invalidQueryError{param: filter.param, message: filter.param + " must be true or false"}
+		}
+		if flag {
+			q.conditions = append(q.conditions, anyColumn(filter.columns, "%s"))
+		}
+		return nil
+	}
+
+	op := filter.op
+	if op == "" {
+		op = "="
+	}
+	q.args = append(q.args, value)
+	q.conditions = append(q.conditions, anyColumn(filter.columns, fmt.Sprintf("%%s%s %s $%d%s", cast, op, len(q.args), cast)))
+	return nil
+}
+
+// anyColumn applies format, which holds one %s for the column, to each column and ORs the results.
+func anyColumn(columns []string, format string) string {
+	terms := make([]string, len(columns))
+	for i, column := range columns {
+		terms[i] = fmt.Sprintf(format, quoteIdentifier(column))
+	}
+	if len(terms) == 1 {
+		return terms[0]
+	}
+	return "(" + strings.Join(terms, " OR ") + ")"
+}
+
+// orderBy renders the requested sort followed by the default one, skipping keys already sorted on.
+func (p listParams) orderBy(requested string) (string, error) {
+	var (
+		terms []string
+		seen  = map[string]bool{}
+	)
+	for i, raw := range []string{requested, p.defaultSort} {
+		for _, key := range strings.Split(raw, ",") {
+			key = strings.TrimSpace(key)
+			direction := "ASC"
+			if strings.HasPrefix(key, "-") {
+				key, direction = strings.TrimPrefix(key, "-"), "DESC"
+			}
+			if key == "" {
+				continue
+			}
+			expr, ok := p.sorts[key]
+			if !ok {
+				if i > 0 {
+					panic(fmt.Sprintf("default sort key %q is not sortable", key))
+				}
+				return "", invalidQueryError{param: "sort", message: "sort must list keys from " + strings.Join(p.sortKeys(), ", ") + ", each optionally prefixed with -"}
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			terms = append(terms, expr+" "+direction)
+		}
+	}
+	return strings.Join(terms, ", "), nil
+}
+
+func (p listParams) sortKeys() []string {
+	keys := make([]string, 0, len(p.sorts))
+	for key := range p.sorts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// build renders a SELECT of selectList from table with the query's filters, order, and page.
+func (q listQuery) build(selectList, table string) (string, []any) {
+	args := append([]any(nil), q.args...)
+	query := fmt.Sprintf("SELECT %s FROM %s", selectList, quoteIdentifier(table))
+	if len(q.conditions) > 0 {
+		query += " WHERE " + strings.Join(q.conditions, " AND ")
+	}
+	if q.orderBy != "" {
+		query += " ORDER BY " + q.orderBy
+	}
+	if q.limit > 0 {
+		args = append(args, q.limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if q.offset > 0 {
+		args = append(args, q.offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+	return query, args
+}
+
+// withLookahead asks for one row past the page, so writeListPage can tell whether another page follows.
+func (q listQuery) withLookahead() listQuery {
+	if q.limit > 0 {
+		q.limit++
+	}
+	return q
+}
+
+// writeListPage writes one page of a list endpoint read with query.withLookahead(). When a row past the
+// page was found, it is dropped and a Link header points at the next page.
+func writeListPage[T any](w http.ResponseWriter, r *http.Request, table string, query listQuery, rows []T) {
+	if query.limit > 0 && len(rows) > query.limit {
+		rows = rows[:query.limit]
+
+		next := *r.URL
+		params := next.Query()
+		params.Set("offset", strconv.Itoa(query.offset+query.limit))
+		next.RawQuery = params.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+	writeJSON(w, table, rows)
+}
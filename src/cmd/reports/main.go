@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -15,21 +17,45 @@ import (
 
 	"errors"
 
-	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 
+	"github.com/ahbreck/Chicago_BI/datasets"
 	"github.com/ahbreck/Chicago_BI/shared"
 )
 
 const (
 	defaultStartupDelayMinutes = 4
 	startupDelayEnvKey         = "STARTUP_DELAY_MINUTES"
+	// sourceWaitTimeoutEnvKey bounds, in minutes after the startup delay, the wait for source tables; 0 waits
+	// without limit.
+	sourceWaitTimeoutEnvKey         = "SOURCE_WAIT_TIMEOUT_MINUTES"
+	defaultSourceWaitTimeoutMinutes = 120
+	// forceRunEnvKey, set to true, builds reports when the source wait times out instead of exiting. Reports
+	// whose own sources are not ready fail as usual.
+	forceRunEnvKey = "FORCE_RUN"
 )
 
 func main() {
-	if err := godotenv.Load(); err != nil {
+	selfTest := flag.Bool("selftest", false, shared.SelfTestFlagUsage)
+	flag.Parse()
+
+	if err := shared.LoadDotenv(); err != nil {
 		log.Fatalf("error loading .env file: %v", err)
 	}
+	if *selfTest {
+		connStr := os.Getenv("DATABASE_URL")
+		if connStr == "" {
+			connStr = shared.DefaultConnectionString
+		}
+		if shared.RunSelfTest(context.Background(), os.Stdout, connStr, datasets.SODASources) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if err := shared.CheckRequiredConfig(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	shared.LogConfigProblems()
 
 	runOnce := strings.EqualFold(os.Getenv("RUN_ONCE"), "true")
 
@@ -51,7 +77,7 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	startHTTPServer(ctx, port)
+	mux := startHTTPServer(ctx, port)
 
 	connStr := os.Getenv("DATABASE_URL")
 	if connStr == "" {
@@ -64,31 +90,106 @@ func main() {
 	}
 	defer db.Close()
 
+	if err := shared.EnsureLineageTables(db); err != nil {
+		log.Fatalf("failed to prepare lineage tables: %v", err)
+	}
+	if err := shared.TrackGeocodingUsage(db); err != nil {
+		log.Printf("geocoding usage will not be tracked: %v", err)
+	}
+	if err := shared.PersistGeocodeCache(db); err != nil {
+		log.Printf("geocoder answers will be cached in memory only: %v", err)
+	}
+
+	readDB, closeReadDB := shared.OpenReadDatabase(db)
+	defer closeReadDB()
+
+	apiCache := newResponseCache(readDB)
+	access := newAccessControl(db)
+	access.handle(mux, "/coverage-gaps", rolePublic, apiCache.wrap(coverageGapsHandler(readDB)))
+	access.handle(mux, "/digest", roleInternal, digestHandler(readDB))
+	access.handle(mux, "GET /freshness", rolePublic, freshnessHandler(readDB))
+	access.handle(mux, "GET /api/datasets", rolePublic, datasetsHandler(readDB))
+	access.handle(mux, "GET /api/maps/{report}", rolePublic, apiCache.wrap(mapsHandler(readDB)))
+	access.handle(mux, "GET /api/trips/trends", rolePublic, apiCache.wrap(tripTrendsHandler(readDB)))
+	access.handle(mux, "GET /api/community-area/{id}", rolePublic, apiCache.wrap(communityAreaHandler(readDB)))
+	access.handle(mux, "GET /api/airport-trips", rolePublic, apiCache.wrap(airportTripsHandler(readDB)))
+	access.handle(mux, "GET /api/disadvantaged-areas", rolePublic, apiCache.wrap(disadvantagedAreasHandler(readDB)))
+	access.handle(mux, "GET /api/permit-processing", rolePublic, apiCache.wrap(permitProcessingHandler(readDB)))
+	access.handle(mux, "GET /export/disadvantaged.pdf", rolePublic, apiCache.wrap(disadvantagedPDFHandler(readDB)))
+	access.handle(mux, "GET /api/covid-alerts", roleInternal, covidAlertsHandler(readDB))
+	access.handle(mux, "GET /api/feeds/covid-alerts.atom", rolePublic, covidAlertFeedHandler(readDB, "atom"))
+	access.handle(mux, "GET /api/feeds/covid-alerts.rss", rolePublic, covidAlertFeedHandler(readDB, "rss"))
+	access.handle(mux, "GET /api/audit", roleInternal, auditLogHandler(readDB))
+	access.handle(mux, "GET /metrics", roleInternal, shared.MetricsHandler())
+	graphqlAPI, err := graphqlHandler(readDB)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	// /graphql is public; its row-level tables check the caller's role themselves.
+	access.handle(mux, "/graphql", rolePublic, apiCache.wrap(graphqlAPI))
+	if grpcPort := strings.TrimSpace(os.Getenv(grpcPortEnvKey)); grpcPort != "" {
+		if err := startGRPCServer(ctx, readDB, grpcPort, access); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	// /run is left to Cloud Run's IAM invoker check, like the collectors' /run, but is audited.
+	mux.HandleFunc("/run", shared.AuditHandler(db, "reports", access.identity, runReportHandler(db, connStr)))
+	access.handle(mux, "POST /admin/reload", roleInternal, shared.ReloadHandler(func() (any, error) { return reloadConfig(db) }))
+	shared.OnReloadSignal(ctx, func() {
+		if _, err := reloadConfig(db); err != nil {
+			log.Printf("configuration reload failed: %v", err)
+		}
+	})
+
 	log.Print("ensuring spatial datasets are available")
 	if _, err := shared.EnsureSpatialDatasets(ctx, shared.DefaultSpatialDatasets...); err != nil {
 		log.Fatalf("failed to prepare spatial datasets: %v", err)
 	}
 
-	startupDelay := startupDelayDuration()
+	if _, err := shared.CheckTableSchemas(ctx, db, datasets.ManagedDatasets); err != nil {
+		log.Printf("table schema check failed: %v", err)
+	}
+
+	// Collectors refresh the view after each load; creating it here covers databases loaded before it existed.
+	if _, err := datasets.EnsureCovidUnifiedView(db); err != nil {
+		log.Printf("failed to prepare %s: %v", datasets.CovidUnifiedView, err)
+	}
+
+	wait := TableWait{
+		StartupDelay: envMinutes(startupDelayEnvKey, defaultStartupDelayMinutes),
+		PollInterval: time.Minute,
+		Timeout:      envMinutes(sourceWaitTimeoutEnvKey, defaultSourceWaitTimeoutMinutes),
+	}
 	log.Print("waiting for source datasets before starting report refresh loop")
-	if err := WaitForTablesReady(ctx, db, startupDelay, time.Minute, SourceTables...); err != nil {
-		log.Fatalf("failed to verify disadvantaged report dependencies: %v", err)
+	if err := WaitForTablesReady(ctx, db, wait, SourceTables...); err != nil {
+		var notReady *TablesNotReadyError
+		if !errors.As(err, &notReady) || !strings.EqualFold(os.Getenv(forceRunEnvKey), "true") {
+			log.Fatalf("failed to verify disadvantaged report dependencies: %v", err)
+		}
+		log.Printf("%s is set; building reports with partial sources: %v", forceRunEnvKey, err)
 	}
 
 	runReports := func() {
-		log.Print("building covid category report")
-		if err := CreateCovidCategoryReport(db); err != nil {
-			log.Printf("failed to build covid category report: %v", err)
-		} else {
-			log.Print("covid category report refreshed")
+		if removed, err := shared.PruneAuditLog(db); err != nil {
+			log.Print(err)
+		} else if removed > 0 {
+			log.Printf("pruned %d audit entries older than %d days", removed, shared.AuditRetentionDays())
 		}
 
-		log.Print("building disadvantaged report")
-		if err := CreateDisadvantagedReport(db); err != nil {
-			log.Printf("failed to build disadvantaged report: %v", err)
-		} else {
-			log.Print("disadvantaged report refreshed")
+		failed := false
+		for _, job := range reportJobs {
+			if err := runReport(db, job); errors.Is(err, errReportLocked) {
+				// Already logged; the instance building it publishes the digest.
+				failed = true
+			} else if err != nil {
+				log.Print(err)
+				failed = true
+			}
 		}
+		if !failed {
+			publishDigest(db)
+		}
+		adviseIndexes(db)
 	}
 
 	if runOnce {
@@ -96,9 +197,6 @@ func main() {
 		log.Print("RUN_ONCE enabled; reports will remain idle until Cloud Run scales down the instance")
 		select {}
 	} else {
-		ticker := time.NewTicker(24 * time.Hour)
-		defer ticker.Stop()
-
 		for {
 			select {
 			case <-ctx.Done():
@@ -107,18 +205,66 @@ func main() {
 			default:
 			}
 
+			started := time.Now()
 			runReports()
 
+			next := started.Add(shared.CycleInterval())
+			log.Printf("reports refreshed, waiting for next run at %s", next.Format(time.RFC3339))
 			select {
 			case <-ctx.Done():
 				return
-			case <-ticker.C:
+			case <-time.After(time.Until(next)):
 			}
 		}
 	}
 }
 
-func startHTTPServer(ctx context.Context, port string) {
+// reportsReload is the outcome of a configuration reload of the reports service.
+type reportsReload struct {
+	shared.ConfigReload
+	// CovidThresholds is the covid category definition the next covid_category build will use.
+	CovidThresholds *covidThresholds `json:"covid_thresholds,omitempty"`
+}
+
+// reloadConfig reloads the dotenv files and reads report_parameters again, so problems with either show up
+// now rather than in the next build. Report parameters are read at the start of every build, so the reload
+// itself needs no further step to apply them.
+func reloadConfig(db *sql.DB) (reportsReload, error) {
+	reload, err := shared.ReloadConfig()
+	if err != nil {
+		return reportsReload{}, err
+	}
+
+	result := reportsReload{ConfigReload: reload}
+	if err := ensureReportParametersTable(db); err != nil {
+		return result, err
+	}
+	thresholds, err := loadCovidThresholds(reportParameters(db))
+	if err != nil {
+		result.Problems = append(result.Problems, err.Error())
+		log.Printf("report parameters problem: %v", err)
+		return result, nil
+	}
+	result.CovidThresholds = &thresholds
+	log.Printf("covid thresholds for the next build: cases %g/%g, percent positive %g/%g",
+		thresholds.Medium, thresholds.High, thresholds.PercentPositive.Medium, thresholds.PercentPositive.High)
+	return result, nil
+}
+
+// recordReportLineage writes lineage rows for every report table produced by a single builder run, with
+// the table's metadata from reportMetadata. Failures are logged rather than returned so that bookkeeping
+// never fails an otherwise good build.
+func recordReportLineage(db *sql.DB, reportSources map[string][]string, reportMetadata map[string]map[string]string, duration time.Duration) {
+	for reportTable, sources := range reportSources {
+		if err := shared.RecordLineage(db, reportTable, sources, reportMetadata[reportTable], duration); err != nil {
+			log.Printf("failed to record lineage for %s: %v", reportTable, err)
+		}
+	}
+}
+
+// startHTTPServer serves the health endpoints right away and returns the mux so routes that need the
+// database can be registered once it is connected.
+func startHTTPServer(ctx context.Context, port string) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("reports service is running"))
@@ -152,6 +298,8 @@ func startHTTPServer(ctx context.Context, port string) {
 			log.Fatalf("reports http server failed: %v", err)
 		}
 	}()
+
+	return mux
 }
 
 func findProjectRoot() (string, error) {
@@ -178,15 +326,9 @@ func findProjectRoot() (string, error) {
 }
 
 func ensureGeographyCrosswalks(projectRoot string) error {
-	required := []string{
-		filepath.Join("src", "data", "census_tract_to_zip_code.csv"),
-		filepath.Join("src", "data", "zip_code_to_community_area.csv"),
-		filepath.Join("src", "data", "community_area_to_zip_code.csv"),
-	}
-
 	var missing []string
-	for _, relPath := range required {
-		absPath := filepath.Join(projectRoot, relPath)
+	for _, name := range shared.GeographyCrosswalkFiles {
+		absPath := shared.CrosswalkPath(projectRoot, name)
 		info, err := os.Stat(absPath)
 		if err != nil || info.Size() == 0 {
 			if rel, relErr := filepath.Rel(projectRoot, absPath); relErr == nil {
@@ -199,7 +341,7 @@ func ensureGeographyCrosswalks(projectRoot string) error {
 
 	if len(missing) > 0 {
 		return fmt.Errorf(
-			"required geography crosswalk files missing or empty: %s. run 'python scripts/build_geography_maps.py' to generate them",
+			"required geography crosswalk files missing or empty: %s. run 'cbictl rebuild-crosswalks' to generate them",
 			strings.Join(missing, ", "),
 		)
 	}
@@ -207,21 +349,23 @@ func ensureGeographyCrosswalks(projectRoot string) error {
 	return nil
 }
 
-func startupDelayDuration() time.Duration {
-	raw := strings.TrimSpace(os.Getenv(startupDelayEnvKey))
+// envMinutes reads a non-negative number of minutes from key, falling back to defaultMinutes when it is
+// unset or invalid.
+func envMinutes(key string, defaultMinutes int) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(key))
 	if raw == "" {
-		return time.Duration(defaultStartupDelayMinutes) * time.Minute
+		return time.Duration(defaultMinutes) * time.Minute
 	}
 
 	minutes, err := strconv.Atoi(raw)
 	if err != nil {
-		log.Printf("invalid %s value %q; defaulting to %d minutes", startupDelayEnvKey, raw, defaultStartupDelayMinutes)
-		return time.Duration(defaultStartupDelayMinutes) * time.Minute
+		log.Printf("invalid %s value %q; defaulting to %d minutes", key, raw, defaultMinutes)
+		return time.Duration(defaultMinutes) * time.Minute
 	}
 
 	if minutes < 0 {
-		log.Printf("%s is negative (%d); defaulting to %d minutes", startupDelayEnvKey, minutes, defaultStartupDelayMinutes)
-		return time.Duration(defaultStartupDelayMinutes) * time.Minute
+		log.Printf("%s is negative (%d); defaulting to %d minutes", key, minutes, defaultMinutes)
+		return time.Duration(defaultMinutes) * time.Minute
 	}
 
 	return time.Duration(minutes) * time.Minute
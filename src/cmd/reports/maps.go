@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// mapReport describes how /api/maps/{report} joins a report table to boundaries.
+type mapReport struct {
+	table     string
+	geography shared.BoundaryLayer
+	// key is the report column matching the boundary identifier.
+	key string
+	// week, when set, is a date column; one week is mapped at a time, the latest unless ?week= is given.
+	week   string
+	values []string
+}
+
+// mapReports lists the reports served as choropleths, keyed by the {report} path segment.
+var mapReports = map[string]mapReport{
+	"covid_category": {
+		table:     covidRepCatsTable,
+		geography: shared.ZipBoundaries,
+		key:       "zip_code",
+		week:      "week_start",
+		values:    []string{"case_rate_weekly", "percent_tested_positive_weekly", "covid_cat", "positivity_cat", "covid_risk"},
+	},
+	"airport_trips": {
+		table:     reqAirportTripsTable,
+		geography: shared.ZipBoundaries,
+		key:       "zip_code",
+		week:      "week_start",
+		values:    []string{"trips_to_airport", "trips_from_airport", "covid_cat"},
+	},
+	"disadvantaged": {
+		table:     disadvantagedTable,
+		geography: shared.CommunityAreaBoundaries,
+		key:       "community_area",
+		values:    []string{"below_poverty_level", "unemployment", "per_capita_income", "disadvantaged"},
+	},
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   json.RawMessage `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// mapBoundary is the geometry of one ZIP code or community area, keyed by its identifier.
+type mapBoundary struct {
+	id       string
+	geometry json.RawMessage
+}
+
+// boundaryCache keeps parsed boundary files in memory; they only change when the spatial datasets are
+// downloaded again, which requires a restart.
+var boundaryCache = struct {
+	sync.Mutex
+	layers map[string][]mapBoundary
+}{layers: make(map[string][]mapBoundary)}
+
+// mapsHandler serves /api/maps/{report}: a GeoJSON FeatureCollection of the report's boundaries with the
+// report values of each ZIP code or community area copied into the feature properties. Boundaries come
+// from the PostGIS boundary tables the collectors load, which the reports place permits in, or from the
+// spatial datasets cached under SPATIAL_DATA_DIR until those are loaded. Boundaries without report rows are returned
+// with null values so the map shows every area.
+func mapsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("report")
+		report, ok := mapReports[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown map report %q", name), http.StatusNotFound)
+			return
+		}
+
+		week := r.URL.Query().Get("week")
+		if week != "" {
+			if report.week == "" {
+				http.Error(w, fmt.Sprintf("map report %q is not weekly", name), http.StatusBadRequest)
+				return
+			}
+			if _, err := time.Parse("2006-01-02", week); err != nil {
+				http.Error(w, "week must be a date like 2022-03-06", http.StatusBadRequest)
+				return
+			}
+		}
+
+		boundaries, err := loadBoundaries(r.Context(), db, report.geography)
+		if err != nil {
+			log.Printf("failed to load %s boundaries: %v", report.geography.Table, err)
+			http.Error(w, "failed to load boundaries", http.StatusInternalServerError)
+			return
+		}
+
+		values, mappedWeek, err := readMapValues(db, report, week)
+		if err != nil {
+			log.Printf("failed to read %s map values: %v", name, err)
+			http.Error(w, "failed to read report values", http.StatusInternalServerError)
+			return
+		}
+
+		collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]geoJSONFeature, 0, len(boundaries))}
+		for _, boundary := range boundaries {
+			properties := map[string]any{"geography_id": boundary.id}
+			if mappedWeek != "" {
+				properties["week_start"] = mappedWeek
+			}
+			row := values[boundary.id]
+			for _, column := range report.values {
+				properties[column] = row[column]
+			}
+			collection.Features = append(collection.Features, geoJSONFeature{Type: "Feature", Geometry: boundary.geometry, Properties: properties})
+		}
+
+		w.Header().Set("Content-Type", "application/geo+json")
+		if err := json.NewEncoder(w).Encode(collection); err != nil {
+			log.Printf("failed to write %s map: %v", name, err)
+		}
+	}
+}
+
+// loadBoundaries returns the boundaries of layer from its PostGIS table, or, while the collectors have not
+// loaded it, from its spatial dataset.
+func loadBoundaries(ctx context.Context, db *sql.DB, layer shared.BoundaryLayer) ([]mapBoundary, error) {
+	boundaries, loaded, err := queryBoundaries(ctx, db, layer)
+	if err != nil || loaded {
+		return boundaries, err
+	}
+	return readBoundaryFile(ctx, layer)
+}
+
+// queryBoundaries reads the boundaries of layer as GeoJSON geometries from its table. It returns false when
+// the table does not exist or has no rows.
+func queryBoundaries(ctx context.Context, db *sql.DB, layer shared.BoundaryLayer) ([]mapBoundary, bool, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to start %s transaction: %w", layer.Table, err)
+	}
+	defer tx.Rollback()
+
+	if loaded, err := tableHasRows(tx, layer.Table); err != nil || !loaded {
+		return nil, false, err
+	}
+	if err := shared.SearchPostGIS(tx); err != nil {
+		return nil, false, err
+	}
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT %s, ST_AsGeoJSON("geom") FROM %s ORDER BY 1`,
+		quoteIdentifier(layer.KeyColumn), quoteIdentifier(layer.Table)))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query %s: %w", layer.Table, err)
+	}
+	defer rows.Close()
+
+	var boundaries []mapBoundary
+	for rows.Next() {
+		var id, geometry string
+		if err := rows.Scan(&id, &geometry); err != nil {
+			return nil, false, fmt.Errorf("failed to scan %s row: %w", layer.Table, err)
+		}
+		boundaries = append(boundaries, mapBoundary{id: id, geometry: json.RawMessage(geometry)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("error while reading %s rows: %w", layer.Table, err)
+	}
+	return boundaries, true, nil
+}
+
+// readBoundaryFile reads the boundaries of layer from its spatial dataset, downloading it when it is not
+// cached under SPATIAL_DATA_DIR yet.
+func readBoundaryFile(ctx context.Context, layer shared.BoundaryLayer) ([]mapBoundary, error) {
+	boundaryCache.Lock()
+	defer boundaryCache.Unlock()
+
+	if boundaries, ok := boundaryCache.layers[layer.Dataset.Name]; ok {
+		return boundaries, nil
+	}
+
+	paths, err := shared.EnsureSpatialDatasets(ctx, layer.Dataset)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(paths[layer.Dataset.Name])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths[layer.Dataset.Name], err)
+	}
+	var collection geoJSONFeatureCollection
+	if err := json.Unmarshal(raw, &collection); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths[layer.Dataset.Name], err)
+	}
+
+	boundaries := make([]mapBoundary, 0, len(collection.Features))
+	for _, feature := range collection.Features {
+		boundaries = append(boundaries, mapBoundary{
+			id:       fmt.Sprint(feature.Properties[layer.KeyProperty]),
+			geometry: feature.Geometry,
+		})
+	}
+	boundaryCache.layers[layer.Dataset.Name] = boundaries
+	return boundaries, nil
+}
+
+// readMapValues returns the report values keyed by geography id, for the given week (or the latest one)
+// when the report is weekly. The mapped week is returned as well.
+func readMapValues(db *sql.DB, report mapReport, week string) (map[string]map[string]any, string, error) {
+	tableIdent := quoteIdentifier(report.table)
+
+	if report.week != "" && week == "" {
+		var latest sql.NullTime
+		query := fmt.Sprintf(`SELECT MAX(%s) FROM %s`, quoteIdentifier(report.week), tableIdent)
+		if err := db.QueryRow(query).Scan(&latest); err != nil {
+			return nil, "", fmt.Errorf("failed to find latest week in %s: %w", report.table, err)
+		}
+		if !latest.Valid {
+			return map[string]map[string]any{}, "", nil
+		}
+		week = latest.Time.Format("2006-01-02")
+	}
+
+	columns := make([]string, 0, len(report.values)+1)
+	columns = append(columns, quoteIdentifier(report.key)+"::text")
+	for _, column := range report.values {
+		columns = append(columns, quoteIdentifier(column))
+	}
+	query := fmt.Sprintf(`SELECT %s FROM %s`, strings.Join(columns, ", "), tableIdent)
+	var args []any
+	if report.week != "" {
+		query += fmt.Sprintf(` WHERE %s = $1`, quoteIdentifier(report.week))
+		args = append(args, week)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query %s: %w", report.table, err)
+	}
+	defer rows.Close()
+
+	values := make(map[string]map[string]any)
+	for rows.Next() {
+		var id sql.NullString
+		raw := make([]any, len(report.values))
+		dest := append([]any{&id}, make([]any, len(report.values))...)
+		for i := range raw {
+			dest[i+1] = &raw[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, "", fmt.Errorf("failed to scan %s row: %w", report.table, err)
+		}
+		if !id.Valid {
+			continue
+		}
+
+		row := make(map[string]any, len(report.values))
+		for i, column := range report.values {
+			if b, ok := raw[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = raw[i]
+			}
+		}
+		values[strings.TrimSpace(id.String)] = row
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("error while reading %s rows: %w", report.table, err)
+	}
+
+	return values, week, nil
+}
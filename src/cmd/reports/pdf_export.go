@@ -0,0 +1,302 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// briefingArea is one community area's page of /export/disadvantaged.pdf.
+type briefingArea struct {
+	communityArea         string
+	name                  sql.NullString
+	belowPovertyLevel     sql.NullFloat64
+	unemployment          sql.NullFloat64
+	perCapitaIncome       sql.NullFloat64
+	sourcePeriod          sql.NullString
+	compositeScore        sql.NullFloat64
+	compositeRank         sql.NullInt64
+	disadvantaged         bool
+	top5Poverty           bool
+	top5Unemployment      bool
+	vacantBuildingReports int64
+	ccviScore             sql.NullFloat64
+	ccviCategory          sql.NullString
+	permits               []briefingPermits
+}
+
+// briefingPermits counts one permit category's permits in a community area and those with waived fees.
+type briefingPermits struct {
+	category   string
+	permits    int64
+	waived     int64
+	waivedFees float64
+	totalFees  float64
+}
+
+// disadvantagedPDFHandler serves /export/disadvantaged.pdf, a briefing with one page per community area
+// summarizing its disadvantaged report indicators and flags and its building permits and waived fees from
+// req_5_disadv_perm, ordered by composite rank. ?community_area= limits it to one area and
+// ?only_disadvantaged=true to the flagged areas.
+func disadvantagedPDFHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		communityArea := ""
+		if raw := params.Get("community_area"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > communityAreaCount {
+				http.Error(w, fmt.Sprintf("community_area must be a number from 1 to %d", communityAreaCount), http.StatusBadRequest)
+				return
+			}
+			communityArea = strconv.Itoa(n)
+		}
+		onlyDisadvantaged := false
+		if raw := params.Get("only_disadvantaged"); raw != "" {
+			var err error
+			if onlyDisadvantaged, err = strconv.ParseBool(raw); err != nil {
+				http.Error(w, "only_disadvantaged must be true or false", http.StatusBadRequest)
+				return
+			}
+		}
+
+		areas, err := readBriefingAreas(db, communityArea, onlyDisadvantaged)
+		if err != nil {
+			log.Printf("failed to read the disadvantaged briefing: %v", err)
+			http.Error(w, "disadvantaged report is not available", http.StatusServiceUnavailable)
+			return
+		}
+		if len(areas) == 0 {
+			http.Error(w, "no community areas match", http.StatusNotFound)
+			return
+		}
+
+		pdf := renderDisadvantagedBriefing(areas, time.Now())
+		if err := pdf.Error(); err != nil {
+			log.Printf("failed to render the disadvantaged briefing: %v", err)
+			http.Error(w, "failed to render the disadvantaged briefing", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="disadvantaged.pdf"`)
+		if err := pdf.Output(w); err != nil {
+			log.Printf("failed to write the disadvantaged briefing: %v", err)
+		}
+	}
+}
+
+// readBriefingAreas reads the community areas of the briefing, all of them when communityArea is empty,
+// with their permits by category.
+func readBriefingAreas(db *sql.DB, communityArea string, onlyDisadvantaged bool) ([]briefingArea, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT d."community_area", c."community_area_name",
+			d."below_poverty_level", d."unemployment", d."per_capita_income", d."source_period",
+			d."composite_score", d."composite_rank", COALESCE(d."disadvantaged", FALSE),
+			COALESCE(d."top_5_poverty", FALSE), COALESCE(d."top_5_unemployment", FALSE),
+			COALESCE(d."vacant_building_reports", 0), c."ccvi_score", c."ccvi_category"
+		FROM %s d
+		LEFT JOIN (
+			SELECT DISTINCT ON ("community_area_or_zip") "community_area_or_zip", "community_area_name", "ccvi_score", "ccvi_category"
+			FROM %s
+			WHERE "geography_type" = 'CA'
+			ORDER BY "community_area_or_zip"
+		) c ON c."community_area_or_zip" = d."community_area"
+		WHERE ($1::text = '' OR d."community_area" = $1)
+			AND (NOT $2::boolean OR d."disadvantaged")
+		ORDER BY d."composite_rank" NULLS LAST, d."community_area"`,
+		quoteIdentifier(disadvantagedTable), quoteIdentifier(ccviTable)), communityArea, onlyDisadvantaged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", disadvantagedTable, err)
+	}
+	defer rows.Close()
+
+	var areas []briefingArea
+	byArea := map[string]int{}
+	for rows.Next() {
+		var a briefingArea
+		if err := rows.Scan(&a.communityArea, &a.name, &a.belowPovertyLevel, &a.unemployment, &a.perCapitaIncome,
+			&a.sourcePeriod, &a.compositeScore, &a.compositeRank, &a.disadvantaged, &a.top5Poverty,
+			&a.top5Unemployment, &a.vacantBuildingReports, &a.ccviScore, &a.ccviCategory); err != nil {
+			return nil, fmt.Errorf("failed to scan %s row: %w", disadvantagedTable, err)
+		}
+		byArea[a.communityArea] = len(areas)
+		areas = append(areas, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading %s: %w", disadvantagedTable, err)
+	}
+	if len(areas) == 0 {
+		return nil, nil
+	}
+
+	permitRows, err := db.Query(fmt.Sprintf(`SELECT "community_area", COALESCE("permit_category", 'other'), COUNT(*),
+			COUNT(*) FILTER (WHERE "waived_fee"),
+			COALESCE(SUM("total_fee") FILTER (WHERE "waived_fee"), 0),
+			COALESCE(SUM("total_fee"), 0)
+		FROM %s
+		WHERE ($1::text = '' OR "community_area" = $1)
+		GROUP BY 1, 2
+		ORDER BY 1, 3 DESC, 2`, quoteIdentifier(disadvantagedPermitsTable)), communityArea)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", disadvantagedPermitsTable, err)
+	}
+	defer permitRows.Close()
+	for permitRows.Next() {
+		var (
+			area string
+			p    briefingPermits
+		)
+		if err := permitRows.Scan(&area, &p.category, &p.permits, &p.waived, &p.waivedFees, &p.totalFees); err != nil {
+			return nil, fmt.Errorf("failed to scan %s row: %w", disadvantagedPermitsTable, err)
+		}
+		if i, ok := byArea[area]; ok {
+			areas[i].permits = append(areas[i].permits, p)
+		}
+	}
+	if err := permitRows.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading %s: %w", disadvantagedPermitsTable, err)
+	}
+	return areas, nil
+}
+
+// renderDisadvantagedBriefing lays out one letter-size page per area. Rendering errors are kept in the
+// returned document's Error.
+func renderDisadvantagedBriefing(areas []briefingArea, generated time.Time) *gofpdf.Fpdf {
+	pdf := gofpdf.New("P", "mm", "Letter", "")
+	pdf.SetTitle("Disadvantaged community areas and permit fee waivers", true)
+	pdf.SetMargins(18, 18, 18)
+	pdf.SetAutoPageBreak(true, 18)
+	// Community area names are Latin-1; the core fonts need them translated from UTF-8.
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-14)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(110, 110, 110)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Chicago BI, generated %s from the %s and %s reports", generated.Format("January 2, 2006"),
+			disadvantagedTable, disadvantagedPermitsTable), "", 0, "L", false, 0, "")
+		left, _, _, _ := pdf.GetMargins()
+		pdf.SetX(left)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AliasNbPages("")
+
+	for _, a := range areas {
+		pdf.AddPage()
+		pdf.SetTextColor(0, 0, 0)
+
+		title := "Community area " + a.communityArea
+		if a.name.Valid && a.name.String != "" {
+			title += ": " + a.name.String
+		}
+		pdf.SetFont("Helvetica", "B", 18)
+		pdf.CellFormat(0, 10, tr(title), "", 1, "L", false, 0, "")
+
+		status := "Not flagged disadvantaged"
+		pdf.SetFillColor(225, 235, 225)
+		if a.disadvantaged {
+			status = "Disadvantaged: building permit fees are waived"
+			pdf.SetFillColor(250, 220, 200)
+		}
+		if a.compositeRank.Valid {
+			status += fmt.Sprintf(" (composite rank %d of %d)", a.compositeRank.Int64, communityAreaCount)
+		}
+		pdf.SetFont("Helvetica", "B", 11)
+		pdf.CellFormat(0, 9, status, "", 1, "L", true, 0, "")
+		pdf.Ln(4)
+
+		briefingHeading(pdf, "Indicators")
+		indicators := [][2]string{
+			{"Below poverty level", formatNullFloat(a.belowPovertyLevel, "%.1f%%")},
+			{"Unemployment", formatNullFloat(a.unemployment, "%.1f%%")},
+			{"Per capita income", formatNullFloat(a.perCapitaIncome, "$%.0f")},
+			{"CCVI score", formatNullFloat(a.ccviScore, "%.1f") + formatCategory(a.ccviCategory)},
+			{"Composite disadvantage score", formatNullFloat(a.compositeScore, "%.2f")},
+			{"Top 5 by poverty / by unemployment", yesNo(a.top5Poverty) + " / " + yesNo(a.top5Unemployment)},
+			{"Vacant building reports", strconv.FormatInt(a.vacantBuildingReports, 10)},
+		}
+		if a.sourcePeriod.Valid {
+			indicators = append(indicators, [2]string{"Census period", a.sourcePeriod.String})
+		}
+		pdf.SetFont("Helvetica", "", 10)
+		for _, row := range indicators {
+			pdf.CellFormat(80, 6.5, row[0], "B", 0, "L", false, 0, "")
+			pdf.CellFormat(0, 6.5, row[1], "B", 1, "R", false, 0, "")
+		}
+		pdf.Ln(6)
+
+		briefingHeading(pdf, "Building permits and fee waivers")
+		widths := []float64{70, 25, 25, 30, 0}
+		pdf.SetFont("Helvetica", "B", 9)
+		pdf.SetFillColor(235, 235, 235)
+		for i, heading := range []string{"Permit category", "Permits", "Fees waived", "Waived fees", "Total fees"} {
+			align := "R"
+			if i == 0 {
+				align = "L"
+			}
+			pdf.CellFormat(widths[i], 7, heading, "B", 0, align, true, 0, "")
+		}
+		pdf.Ln(-1)
+
+		pdf.SetFont("Helvetica", "", 9)
+		var total briefingPermits
+		for _, p := range a.permits {
+			briefingPermitRow(pdf, widths, p.category, p)
+			total.permits += p.permits
+			total.waived += p.waived
+			total.waivedFees += p.waivedFees
+			total.totalFees += p.totalFees
+		}
+		if len(a.permits) == 0 {
+			pdf.CellFormat(0, 6.5, "No building permits in this community area.", "", 1, "L", false, 0, "")
+		} else {
+			pdf.SetFont("Helvetica", "B", 9)
+			briefingPermitRow(pdf, widths, "Total", total)
+		}
+		pdf.Ln(6)
+
+		pdf.SetFont("Helvetica", "I", 8.5)
+		pdf.SetTextColor(90, 90, 90)
+		pdf.MultiCell(0, 4.5, "Community areas are ranked by a composite score of the poverty rate, unemployment, per capita "+
+			"income, and CCVI score; the highest ranked are flagged disadvantaged, and building permits issued in them "+
+			"have their fees waived.", "", "L", false)
+	}
+	return pdf
+}
+
+func briefingHeading(pdf *gofpdf.Fpdf, text string) {
+	pdf.SetFont("Helvetica", "B", 12)
+	pdf.CellFormat(0, 8, text, "", 1, "L", false, 0, "")
+}
+
+func briefingPermitRow(pdf *gofpdf.Fpdf, widths []float64, label string, p briefingPermits) {
+	pdf.CellFormat(widths[0], 6, label, "B", 0, "L", false, 0, "")
+	pdf.CellFormat(widths[1], 6, strconv.FormatInt(p.permits, 10), "B", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[2], 6, strconv.FormatInt(p.waived, 10), "B", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[3], 6, fmt.Sprintf("$%.0f", p.waivedFees), "B", 0, "R", false, 0, "")
+	pdf.CellFormat(widths[4], 6, fmt.Sprintf("$%.0f", p.totalFees), "B", 1, "R", false, 0, "")
+}
+
+func formatNullFloat(value sql.NullFloat64, format string) string {
+	if !value.Valid {
+		return "n/a"
+	}
+	return fmt.Sprintf(format, value.Float64)
+}
+
+func formatCategory(category sql.NullString) string {
+	if !category.Valid || category.String == "" {
+		return ""
+	}
+	return " (" + strings.ToLower(category.String) + ")"
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	// permitAreaBoundariesTable and permitZipBoundariesTable are the temporary tables the disadvantaged
+	// report loads the community area and ZIP code boundaries into when the collectors have not loaded them.
+	permitAreaBoundariesTable = "permit_area_boundaries"
+	permitZipBoundariesTable  = "permit_zip_boundaries"
+
+	// Values of the geography_check column of req_5_disadv_perm, comparing a permit's spatial placement with
+	// its reported community area and the crosswalk ZIP code of that area.
+	geographyAgrees               = "agrees"
+	geographyCommunityAreaDiffers = "community_area_differs"
+	geographyZipDiffers           = "zip_differs"
+	geographyBothDiffer           = "both_differ"
+	geographyOutsideBoundaries    = "outside_boundaries"
+	geographyNoCoordinates        = "no_coordinates"
+	geographyNotChecked           = "not_checked"
+
+	// Values of the geography_mode lineage metadata of the permit tables: permits placed in the boundaries
+	// with PostGIS, or, without PostGIS, by the crosswalk ZIP code of their community area only.
+	geographyModeSpatial   = "spatial"
+	geographyModeCrosswalk = "crosswalk"
+)
+
+// permitGeographyTables are the tables whose ZIP codes depend on how permits were placed, and whose lineage
+// records the geography_mode.
+var permitGeographyTables = []string{disadvantagedPermitsTable, loanEligibilityPermits}
+
+// permitBoundaries names the tables holding the community area and ZIP code boundaries during a build of the
+// disadvantaged report.
+type permitBoundaries struct {
+	areas string
+	zips  string
+}
+
+// loadPermitBoundaries installs PostGIS and finds the community area and ZIP code boundaries. The tables the
+// collectors load at startup are used when they have rows; otherwise the boundaries are loaded from the
+// cached spatial datasets into temporary tables dropped when tx commits. It returns false, leaving tx
+// usable, when the server cannot provide PostGIS, because the extension is not available or the role may
+// not install it, so the report falls back to the crosswalk. Either way the mode is noted in the lineage of
+// permitGeographyTables.
+func loadPermitBoundaries(tx *sql.Tx) (permitBoundaries, bool, error) {
+	var available bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'postgis')`).Scan(&available); err != nil {
+		return permitBoundaries{}, false, fmt.Errorf("failed to check for postgis: %w", err)
+	}
+	if !available {
+		notePermitGeographyMode(geographyModeCrosswalk, "postgis is not available")
+		return permitBoundaries{}, false, nil
+	}
+
+	// A failed CREATE EXTENSION aborts the transaction, so it runs in a savepoint the fallback rolls back to.
+	if _, err := tx.Exec(`SAVEPOINT use_postgis`); err != nil {
+		return permitBoundaries{}, false, fmt.Errorf("failed to set postgis savepoint: %w", err)
+	}
+	if err := shared.UsePostGIS(tx); err != nil {
+		if _, rollbackErr := tx.Exec(`ROLLBACK TO SAVEPOINT use_postgis`); rollbackErr != nil {
+			return permitBoundaries{}, false, fmt.Errorf("%v; failed to roll back to the postgis savepoint: %w", err, rollbackErr)
+		}
+		notePermitGeographyMode(geographyModeCrosswalk, err.Error())
+		return permitBoundaries{}, false, nil
+	}
+	if _, err := tx.Exec(`RELEASE SAVEPOINT use_postgis`); err != nil {
+		return permitBoundaries{}, false, fmt.Errorf("failed to release postgis savepoint: %w", err)
+	}
+
+	areas, err := boundaryTable(tx, shared.CommunityAreaBoundaries.Table, permitAreaBoundariesTable,
+		shared.CommunityAreasDataset, "community_area", shared.CommunityAreaBoundaries.KeyProperty)
+	if err != nil {
+		return permitBoundaries{}, false, err
+	}
+	zips, err := boundaryTable(tx, shared.ZipBoundaries.Table, permitZipBoundariesTable,
+		shared.ZipCodesDataset, "zip_code", shared.ZipBoundaries.KeyProperty)
+	if err != nil {
+		return permitBoundaries{}, false, err
+	}
+	notePermitGeographyMode(geographyModeSpatial, "")
+	return permitBoundaries{areas: areas, zips: zips}, true, nil
+}
+
+// notePermitGeographyMode records how permits were placed in the lineage of permitGeographyTables. The
+// crosswalk mode is degraded: it is logged, and reason is recorded as degraded_reason.
+func notePermitGeographyMode(mode, reason string) {
+	if reason != "" {
+		log.Printf("%s; placing permits by the community area crosswalk only", reason)
+	}
+	for _, table := range permitGeographyTables {
+		noteReportMetadata(table, "geography_mode", mode)
+		if reason != "" {
+			noteReportMetadata(table, "degraded_reason", reason)
+		}
+	}
+}
+
+// permitBoundaryTable returns loaded when the collectors have filled it, or else loads the features of
+// dataset into the temporary table temp and returns temp.
+func boundaryTable(tx *sql.Tx, loaded, temp string, dataset shared.SpatialDataset, column, property string) (string, error) {
+	ok, err := tableHasRows(tx, loaded)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return loaded, nil
+	}
+
+	paths, err := shared.EnsureSpatialDatasets(context.Background(), dataset)
+	if err != nil {
+		return "", err
+	}
+	raw, err := os.ReadFile(paths[dataset.Name])
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", paths[dataset.Name], err)
+	}
+	var boundaries geoJSONFeatureCollection
+	if err := json.Unmarshal(raw, &boundaries); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", paths[dataset.Name], err)
+	}
+	if err := loadBoundaryTable(tx, temp, column, property, boundaries.Features); err != nil {
+		return "", err
+	}
+	return temp, nil
+}
+
+// tableHasRows reports whether table, loaded by the collectors, exists and has rows.
+func tableHasRows(tx *sql.Tx, table string) (bool, error) {
+	var regClass sql.NullString
+	if err := tx.QueryRow(`SELECT to_regclass($1)`, quoteIdentifier(table)).Scan(&regClass); err != nil {
+		return false, fmt.Errorf("failed to verify presence of %s: %w", table, err)
+	}
+	if !regClass.Valid {
+		return false, nil
+	}
+	var loaded bool
+	if err := tx.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s)`, quoteIdentifier(table))).Scan(&loaded); err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	return loaded, nil
+}
+
+// loadBoundaryTable creates the temporary table table holding each feature's geometry, keyed by column from
+// the feature property property. Features without a geometry or key are skipped.
+func loadBoundaryTable(tx *sql.Tx, table, column, property string, features []geoJSONFeature) error {
+	tableIdent := quoteIdentifier(table)
+	createStmt := fmt.Sprintf(`CREATE TEMP TABLE %s (
+		%s VARCHAR(16) NOT NULL,
+		"geom" geometry(MultiPolygon, 4326) NOT NULL
+	) ON COMMIT DROP`, tableIdent, quoteIdentifier(column))
+	if _, err := tx.Exec(createStmt); err != nil {
+		return fmt.Errorf("failed to create %s: %w", table, err)
+	}
+
+	insert, err := tx.Prepare(fmt.Sprintf(`INSERT INTO %s (%s, "geom")
+		VALUES ($1, ST_Multi(ST_SetSRID(ST_GeomFromGeoJSON($2), 4326)))`, tableIdent, quoteIdentifier(column)))
+	if err != nil {
+		return fmt.Errorf("failed to prepare %s insert: %w", table, err)
+	}
+	defer insert.Close()
+
+	for _, feature := range features {
+		key, _ := feature.Properties[property].(string)
+		if key == "" || len(feature.Geometry) == 0 || string(feature.Geometry) == "null" {
+			continue
+		}
+		if _, err := insert.Exec(key, string(feature.Geometry)); err != nil {
+			return fmt.Errorf("failed to insert %s boundary %s: %w", table, key, err)
+		}
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`CREATE INDEX ON %s USING GIST ("geom")`, tableIdent)); err != nil {
+		return fmt.Errorf("failed to index %s: %w", table, err)
+	}
+	return nil
+}
+
+// placePermitsInBoundaries sets the spatial_community_area of the permits in tableIdent and gives those
+// without a ZIP code the one of the ZIP code boundary containing them.
+func placePermitsInBoundaries(tx *sql.Tx, tableIdent string, boundaries permitBoundaries) error {
+	statements, err := renderStatements("permit_geography.sql", map[string]string{
+		"Permits":        tableIdent,
+		"AreaBoundaries": quoteIdentifier(boundaries.areas),
+		"ZipBoundaries":  quoteIdentifier(boundaries.zips),
+	})
+	if err != nil {
+		return err
+	}
+	return execStatements(tx, "permit_geography.sql", statements)
+}
+
+// checkPermitGeography fills the geography_check column of the permits in tableIdent, comparing the
+// community area and ZIP code found from their coordinates with their reported community area and its
+// crosswalk ZIP code. When spatial is false no boundaries were loaded and every permit is not_checked.
+func checkPermitGeography(tx *sql.Tx, tableIdent string, spatial bool) error {
+	stmt := fmt.Sprintf(`UPDATE %s SET geography_check = '%s'`, tableIdent, geographyNotChecked)
+	if spatial {
+		stmt = fmt.Sprintf(`UPDATE %s SET geography_check = CASE
+			WHEN "latitude" IS NULL OR "longitude" IS NULL THEN '%s'
+			WHEN spatial_community_area IS NULL THEN '%s'
+			WHEN spatial_community_area IS DISTINCT FROM "community_area"
+				AND LEFT(zip_code, 5) IS DISTINCT FROM crosswalk_zip_code THEN '%s'
+			WHEN spatial_community_area IS DISTINCT FROM "community_area" THEN '%s'
+			WHEN LEFT(zip_code, 5) IS DISTINCT FROM crosswalk_zip_code THEN '%s'
+			ELSE '%s'
+		END`, tableIdent, geographyNoCoordinates, geographyOutsideBoundaries, geographyBothDiffer,
+			geographyCommunityAreaDiffers, geographyZipDiffers, geographyAgrees)
+	}
+	if _, err := tx.Exec(stmt); err != nil {
+		return fmt.Errorf("failed to compare permit geography with the crosswalk: %w", err)
+	}
+	return nil
+}
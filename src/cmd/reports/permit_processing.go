@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+const (
+	permitProcessingTable = "permit_processing_times"
+
+	// permitProcessingSLAEnvKey is the processing time, in days, a permit is expected to be issued within.
+	permitProcessingSLAEnvKey  = "PERMIT_PROCESSING_SLA_DAYS"
+	defaultPermitProcessingSLA = 30
+)
+
+// permitProcessingReportSources maps the table built by CreatePermitProcessingReport to the collector tables
+// it reads.
+var permitProcessingReportSources = map[string][]string{
+	permitProcessingTable: {buildingPermits},
+}
+
+// permitProcessingReportAssertions are the invariants of the table built by CreatePermitProcessingReport.
+var permitProcessingReportAssertions = []reportAssertion{
+	assertNotNull(permitProcessingTable, "quarter_start"),
+	assertNotNull(permitProcessingTable, "ward"),
+	assertNonNegative(permitProcessingTable, "permits"),
+	assertNonNegative(permitProcessingTable, "median_processing_days"),
+}
+
+// CreatePermitProcessingReport rebuilds permit_processing_times, the median processing time of the building
+// permits issued per quarter, permit category, and ward, and the share issued within
+// PERMIT_PROCESSING_SLA_DAYS, which is recorded in the table's lineage.
+func CreatePermitProcessingReport(db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if err := ensureTableReady(db, buildingPermits); err != nil {
+		return err
+	}
+
+	if err := ensureReportParametersTable(db); err != nil {
+		return err
+	}
+	slaDays, err := intReportParameter(reportParameters(db), permitProcessingSLAEnvKey, defaultPermitProcessingSLA)
+	if err != nil {
+		return err
+	}
+	noteReportMetadata(permitProcessingTable, "sla_days", strconv.Itoa(slaDays))
+
+	statements, err := renderStatements("permit_processing_report.sql", map[string]string{
+		"Target":  quoteIdentifier(permitProcessingTable),
+		"Permits": quoteIdentifier(buildingPermits),
+		"SLADays": strconv.Itoa(slaDays),
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start permit processing report transaction: %w", err)
+	}
+
+	if err := execStatements(tx, "permit_processing_report.sql", statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to commit permit processing report transaction: %w", err)
+	}
+
+	return nil
+}
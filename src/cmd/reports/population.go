@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+)
+
+// populationTable is loaded by the population collector. It is optional: reports built without it keep
+// NULL per-capita columns, so it is not listed among their sources.
+var populationTable = datasets.PopulationDataset.Table
+
+// perCapitaColumn names the column addPerCapitaColumns derives from the count column count.
+func perCapitaColumn(count string) string {
+	return count + "_per_1000_residents"
+}
+
+// addPerCapitaColumns adds to table a population column, the ACS population of the geography of type
+// geographyType in keyColumn, and for each of counts a <count>_per_1000_residents column. Rows whose
+// geography has no population, or a population of zero, and every row when the population table is not
+// loaded, keep NULL.
+func addPerCapitaColumns(tx *sql.Tx, table, geographyType, keyColumn string, counts ...string) error {
+	tableIdent := quoteIdentifier(table)
+	statements := []string{fmt.Sprintf(`ALTER TABLE %s ADD COLUMN "population" INTEGER`, tableIdent)}
+	for _, count := range counts {
+		statements = append(statements, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s FLOAT8`, tableIdent, quoteIdentifier(perCapitaColumn(count))))
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to add per-capita columns to %s: %w", table, err)
+		}
+	}
+
+	loaded, err := tableHasRows(tx, populationTable)
+	if err != nil {
+		return err
+	}
+	if !loaded {
+		log.Printf("%s is not loaded; leaving the per-capita columns of %s NULL", populationTable, table)
+		return nil
+	}
+
+	assignments := `"population" = p."population"`
+	for _, count := range counts {
+		assignments += fmt.Sprintf(`,
+			%s = r.%s * 1000.0 / NULLIF(p."population", 0)`, quoteIdentifier(perCapitaColumn(count)), quoteIdentifier(count))
+	}
+	stmt := fmt.Sprintf(`UPDATE %s r
+		SET %s
+		FROM %s p
+		WHERE p."geography_type" = $1
+			AND p."geography_id" = r.%s`, tableIdent, assignments, quoteIdentifier(populationTable), quoteIdentifier(keyColumn))
+	if _, err := tx.Exec(stmt, geographyType); err != nil {
+		return fmt.Errorf("failed to compute per-capita columns of %s: %w", table, err)
+	}
+	return nil
+}
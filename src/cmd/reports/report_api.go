@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// airportTripsHandler serves /api/airport-trips as a JSON array; see airportTripsParams for its parameters.
+func airportTripsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := airportTripsParams.parse(r.URL.Query())
+		if err != nil {
+			writeQueryError(w, reqAirportTripsTable, err)
+			return
+		}
+
+		found := []airportTripsRow{}
+		err = queryAirportTrips(r.Context(), db, query.withLookahead(), func(row airportTripsRow) error {
+			found = append(found, row)
+			return nil
+		})
+		if err != nil {
+			writeQueryError(w, reqAirportTripsTable, err)
+			return
+		}
+		writeListPage(w, r, reqAirportTripsTable, query, found)
+	}
+}
+
+// disadvantagedAreasHandler serves /api/disadvantaged-areas as a JSON array; ?only_disadvantaged=true
+// drops the areas that are not flagged. See disadvantagedAreasParams for the other parameters.
+func disadvantagedAreasHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := disadvantagedAreasParams.parse(r.URL.Query())
+		if err != nil {
+			writeQueryError(w, disadvantagedTable, err)
+			return
+		}
+
+		found := []disadvantagedAreaRow{}
+		err = queryDisadvantagedAreas(r.Context(), db, query.withLookahead(), func(row disadvantagedAreaRow) error {
+			found = append(found, row)
+			return nil
+		})
+		if err != nil {
+			writeQueryError(w, disadvantagedTable, err)
+			return
+		}
+		writeListPage(w, r, disadvantagedTable, query, found)
+	}
+}
+
+// permitProcessingHandler serves /api/permit-processing as a JSON array; see permitProcessingParams for its
+// parameters.
+func permitProcessingHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := permitProcessingParams.parse(r.URL.Query())
+		if err != nil {
+			writeQueryError(w, permitProcessingTable, err)
+			return
+		}
+
+		found := []permitProcessingRow{}
+		err = queryPermitProcessing(r.Context(), db, query.withLookahead(), func(row permitProcessingRow) error {
+			found = append(found, row)
+			return nil
+		})
+		if err != nil {
+			writeQueryError(w, permitProcessingTable, err)
+			return
+		}
+		writeListPage(w, r, permitProcessingTable, query, found)
+	}
+}
+
+// covidAlertsHandler streams /api/covid-alerts as newline-delimited JSON, one trip per line; see
+// covidAlertsParams for its parameters. The table holds one row per trip, so it is not buffered, and a
+// page is known to be the last when it holds fewer rows than ?limit=.
+func covidAlertsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := covidAlertsParams.parse(r.URL.Query())
+		if err != nil {
+			writeQueryError(w, covidAlertsTable, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		streamed := false
+		err = queryCovidAlerts(r.Context(), db, query, func(row covidAlertRow) error {
+			streamed = true
+			return encoder.Encode(row)
+		})
+		if err == nil {
+			return
+		}
+		if streamed {
+			// The status line is already sent; the truncated stream is all the client will see.
+			log.Printf("covid alerts stream interrupted: %v", err)
+			return
+		}
+		writeQueryError(w, covidAlertsTable, err)
+	}
+}
+
+// queryErrorResponse is the body of every failed list request.
+type queryErrorResponse struct {
+	Error string `json:"error"`
+	// Parameter names the request parameter that was rejected.
+	Parameter string `json:"parameter,omitempty"`
+}
+
+// writeQueryError answers a failed list request with a JSON queryErrorResponse: 400 for a bad parameter,
+// 503 when the table cannot be read.
+func writeQueryError(w http.ResponseWriter, table string, err error) {
+	response := queryErrorResponse{Error: table + " is not available"}
+	status := http.StatusServiceUnavailable
+
+	var invalid invalidQueryError
+	if errors.As(err, &invalid) {
+		response = queryErrorResponse{Error: invalid.message, Parameter: invalid.param}
+		status = http.StatusBadRequest
+	} else {
+		log.Printf("failed to read %s: %v", table, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("failed to write %s error response: %v", table, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, table string, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("failed to write %s response: %v", table, err)
+	}
+}
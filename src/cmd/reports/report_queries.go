@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// The query functions below are shared by the REST endpoints under /api/ and the gRPC service. Each one
+// reads the rows selected by a listQuery parsed from the endpoint's listParams, passes them to each in
+// order, and stops at the first error each returns, so large results can be streamed without being held
+// in memory.
+
+// airportTripsRow is one row of req_2_airport_trips.
+type airportTripsRow struct {
+	ZipCode          string   `json:"zip_code"`
+	WeekStart        string   `json:"week_start"`
+	CaseRateWeekly   *float64 `json:"case_rate_weekly"`
+	CovidCat         string   `json:"covid_cat"`
+	TripsToAirport   int64    `json:"trips_to_airport"`
+	TripsFromAirport int64    `json:"trips_from_airport"`
+}
+
+// airportTripsParams are the filters, sort keys, and paging of /api/airport-trips.
+var airportTripsParams = listParams{
+	sorts: map[string]string{
+		"zip_code":           `"zip_code"`,
+		"week_start":         `"week_start"`,
+		"case_rate_weekly":   `"case_rate_weekly"`,
+		"trips_to_airport":   `"trips_to_airport"`,
+		"trips_from_airport": `"trips_from_airport"`,
+	},
+	defaultSort: "zip_code,week_start",
+	filters: []listFilter{
+		{param: "zip", kind: filterZip, columns: []string{"zip_code"}},
+		{param: "week", kind: filterDate, columns: []string{"week_start"}},
+		{param: "from", kind: filterDate, columns: []string{"week_start"}, op: ">="},
+		{param: "to", kind: filterDate, columns: []string{"week_start"}, op: "<="},
+		{param: "covid_cat", kind: filterText, columns: []string{"covid_cat"}, values: covidCategories},
+	},
+	defaultLimit: defaultListLimit,
+}
+
+func queryAirportTrips(ctx context.Context, db *sql.DB, query listQuery, each func(airportTripsRow) error) error {
+	statement, args := query.build(`"zip_code", "week_start", "case_rate_weekly", COALESCE("covid_cat", ''),
+		COALESCE("trips_to_airport", 0), COALESCE("trips_from_airport", 0)`, reqAirportTripsTable)
+	rows, err := db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", reqAirportTripsTable, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			row       airportTripsRow
+			weekStart time.Time
+		)
+		if err := rows.Scan(&row.ZipCode, &weekStart, &row.CaseRateWeekly, &row.CovidCat, &row.TripsToAirport, &row.TripsFromAirport); err != nil {
+			return fmt.Errorf("failed to scan %s row: %w", reqAirportTripsTable, err)
+		}
+		row.WeekStart = weekStart.Format("2006-01-02")
+		if err := each(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error while reading %s: %w", reqAirportTripsTable, err)
+	}
+	return nil
+}
+
+// disadvantagedAreaRow is one row of the disadvantaged table.
+type disadvantagedAreaRow struct {
+	CommunityArea     string   `json:"community_area"`
+	BelowPovertyLevel *float64 `json:"below_poverty_level"`
+	Unemployment      *float64 `json:"unemployment"`
+	PerCapitaIncome   *float64 `json:"per_capita_income"`
+	Top5Poverty       bool     `json:"top_5_poverty"`
+	Top5Unemployment  bool     `json:"top_5_unemployment"`
+	CompositeScore    *float64 `json:"composite_score"`
+	CompositeRank     *int64   `json:"composite_rank"`
+	Disadvantaged     bool     `json:"disadvantaged"`
+}
+
+// disadvantagedAreasParams are the filters, sort keys, and paging of /api/disadvantaged-areas.
+var disadvantagedAreasParams = listParams{
+	sorts: map[string]string{
+		"community_area":      `"community_area"::int`,
+		"below_poverty_level": `"below_poverty_level"`,
+		"unemployment":        `"unemployment"`,
+		"per_capita_income":   `"per_capita_income"`,
+		"composite_rank":      `"composite_rank"`,
+	},
+	defaultSort: "community_area",
+	filters: []listFilter{
+		{param: "community_area", kind: filterCommunityArea, columns: []string{"community_area"}},
+		{param: "only_disadvantaged", kind: filterFlag, columns: []string{"disadvantaged"}},
+	},
+	defaultLimit: defaultListLimit,
+}
+
+func queryDisadvantagedAreas(ctx context.Context, db *sql.DB, query listQuery, each func(disadvantagedAreaRow) error) error {
+	statement, args := query.build(`"community_area", "below_poverty_level", "unemployment", "per_capita_income",
+		COALESCE("top_5_poverty", FALSE), COALESCE("top_5_unemployment", FALSE), "composite_score", "composite_rank",
+		COALESCE("disadvantaged", FALSE)`, disadvantagedTable)
+	rows, err := db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", disadvantagedTable, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row disadvantagedAreaRow
+		if err := rows.Scan(&row.CommunityArea, &row.BelowPovertyLevel, &row.Unemployment, &row.PerCapitaIncome,
+			&row.Top5Poverty, &row.Top5Unemployment, &row.CompositeScore, &row.CompositeRank, &row.Disadvantaged); err != nil {
+			return fmt.Errorf("failed to scan %s row: %w", disadvantagedTable, err)
+		}
+		if err := each(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error while reading %s: %w", disadvantagedTable, err)
+	}
+	return nil
+}
+
+// permitProcessingRow is one row of permit_processing_times.
+type permitProcessingRow struct {
+	QuarterStart         string  `json:"quarter_start"`
+	Quarter              string  `json:"quarter"`
+	PermitCategory       string  `json:"permit_category"`
+	Ward                 string  `json:"ward"`
+	Permits              int64   `json:"permits"`
+	MedianProcessingDays float64 `json:"median_processing_days"`
+	P90ProcessingDays    float64 `json:"p90_processing_days"`
+	SLADays              int64   `json:"sla_days"`
+	WithinSLAPct         float64 `json:"within_sla_pct"`
+}
+
+// permitProcessingParams are the filters, sort keys, and paging of /api/permit-processing.
+var permitProcessingParams = listParams{
+	sorts: map[string]string{
+		"quarter_start":          `"quarter_start"`,
+		"permit_category":        `"permit_category"`,
+		"ward":                   `LPAD("ward", 2, '0')`,
+		"permits":                `"permits"`,
+		"median_processing_days": `"median_processing_days"`,
+		"p90_processing_days":    `"p90_processing_days"`,
+		"within_sla_pct":         `"within_sla_pct"`,
+	},
+	defaultSort: "quarter_start,permit_category,ward",
+	filters: []listFilter{
+		{param: "ward", kind: filterText, columns: []string{"ward"}},
+		{param: "permit_category", kind: filterText, columns: []string{"permit_category"}},
+		{param: "from", kind: filterDate, columns: []string{"quarter_start"}, op: ">="},
+		{param: "to", kind: filterDate, columns: []string{"quarter_start"}, op: "<="},
+	},
+	defaultLimit: defaultListLimit,
+}
+
+func queryPermitProcessing(ctx context.Context, db *sql.DB, query listQuery, each func(permitProcessingRow) error) error {
+	statement, args := query.build(`"quarter_start", "quarter", "permit_category", "ward", "permits",
+		"median_processing_days", "p90_processing_days", "sla_days", "within_sla_pct"`, permitProcessingTable)
+	rows, err := db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", permitProcessingTable, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			row          permitProcessingRow
+			quarterStart time.Time
+		)
+		if err := rows.Scan(&quarterStart, &row.Quarter, &row.PermitCategory, &row.Ward, &row.Permits,
+			&row.MedianProcessingDays, &row.P90ProcessingDays, &row.SLADays, &row.WithinSLAPct); err != nil {
+			return fmt.Errorf("failed to scan %s row: %w", permitProcessingTable, err)
+		}
+		row.QuarterStart = quarterStart.Format("2006-01-02")
+		if err := each(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error while reading %s: %w", permitProcessingTable, err)
+	}
+	return nil
+}
+
+// covidAlertRow is one trip of req_1a_covid_alerts_drivers.
+type covidAlertRow struct {
+	TripID             string    `json:"trip_id"`
+	TripStartTimestamp time.Time `json:"trip_start_timestamp"`
+	WeekStart          string    `json:"week_start"`
+	PickupZipCode      string    `json:"pickup_zip_code"`
+	DropoffZipCode     string    `json:"dropoff_zip_code"`
+	PickupCovidCat     string    `json:"pickup_covid_cat"`
+	DropoffCovidCat    string    `json:"dropoff_covid_cat"`
+	AirportPickup      bool      `json:"airport_pickup"`
+	AirportDropoff     bool      `json:"airport_dropoff"`
+}
+
+// covidAlertsParams are the filters, sort keys, and paging of /api/covid-alerts. zip, community_area, and
+// covid_cat match either end of the trip. The endpoint streams, so it is not paged unless ?limit= is given.
+var covidAlertsParams = listParams{
+	sorts: map[string]string{
+		"trip_start_timestamp": `"trip_start_timestamp"`,
+		"trip_id":              `"trip_id"`,
+		"week_start":           `"week_start"`,
+	},
+	defaultSort: "trip_start_timestamp,trip_id",
+	filters: []listFilter{
+		{param: "zip", kind: filterZip, columns: []string{"pickup_zip_code", "dropoff_zip_code"}},
+		{param: "community_area", kind: filterCommunityArea, columns: []string{"pickup_community_area", "dropoff_community_area"}},
+		{param: "week", kind: filterDate, columns: []string{"week_start"}},
+		{param: "from", kind: filterDate, columns: []string{"day"}, op: ">="},
+		{param: "to", kind: filterDate, columns: []string{"day"}, op: "<="},
+		{param: "covid_cat", kind: filterText, columns: []string{"pickup_covid_cat", "dropoff_covid_cat"}, values: covidCategories},
+	},
+}
+
+func queryCovidAlerts(ctx context.Context, db *sql.DB, query listQuery, each func(covidAlertRow) error) error {
+	statement, args := query.build(`"trip_id", "trip_start_timestamp", "week_start",
+		COALESCE("pickup_zip_code", ''), COALESCE("dropoff_zip_code", ''),
+		COALESCE("pickup_covid_cat", ''), COALESCE("dropoff_covid_cat", ''),
+		COALESCE("airport_pickup", FALSE), COALESCE("airport_dropoff", FALSE)`, covidAlertsTable)
+	rows, err := db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", covidAlertsTable, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			row       covidAlertRow
+			weekStart time.Time
+		)
+		if err := rows.Scan(&row.TripID, &row.TripStartTimestamp, &weekStart, &row.PickupZipCode, &row.DropoffZipCode,
+			&row.PickupCovidCat, &row.DropoffCovidCat, &row.AirportPickup, &row.AirportDropoff); err != nil {
+			return fmt.Errorf("failed to scan %s row: %w", covidAlertsTable, err)
+		}
+		row.WeekStart = weekStart.Format("2006-01-02")
+		if err := each(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error while reading %s: %w", covidAlertsTable, err)
+	}
+	return nil
+}
+
+// covidCategories are the values of the covid_cat, positivity_cat, and covid_risk columns, lowest first.
+var covidCategories = []string{"low", "medium", "high"}
+
+// invalidQueryError reports a bad request parameter; REST handlers answer it with 400 and gRPC with
+// InvalidArgument. It is in the shared.ErrValidation category.
+type invalidQueryError struct {
+	param   string
+	message string
+}
+
+func (e invalidQueryError) Error() string {
+	return e.message
+}
+
+func (e invalidQueryError) Is(target error) bool {
+	return target == shared.ErrValidation
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// reportCheckpointsTable records the stages of a staged report build that have committed, so a failed build
+// resumes from the stage that failed instead of starting over.
+const reportCheckpointsTable = "report_checkpoints"
+
+// stageMarker starts a named stage in a staged report script, e.g. "-- stage: airport_trips".
+const stageMarker = "-- stage:"
+
+// reportStage is a named run of statements from a staged report script, committed in a transaction of its
+// own.
+type reportStage struct {
+	name       string
+	statements []string
+}
+
+// renderStages executes the named embedded SQL script and splits it into stages at its stage markers.
+// Statements before the first marker are not allowed, so every statement belongs to a checkpointed stage.
+func renderStages(name string, params map[string]string) ([]reportStage, string, error) {
+	var buf bytes.Buffer
+	if err := sqlTemplates.ExecuteTemplate(&buf, name, params); err != nil {
+		return nil, "", fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	rendered := buf.String()
+
+	var (
+		stages  []reportStage
+		current *strings.Builder
+	)
+	flush := func() {
+		if current != nil {
+			stages[len(stages)-1].statements = splitStatements(current.String())
+		}
+	}
+	for _, line := range strings.SplitAfter(rendered, "\n") {
+		if stage, ok := strings.CutPrefix(strings.TrimSpace(line), stageMarker); ok {
+			flush()
+			stages = append(stages, reportStage{name: strings.TrimSpace(stage)})
+			current = &strings.Builder{}
+			continue
+		}
+		if current == nil {
+			if !isBlankSQL(line) {
+				return nil, "", fmt.Errorf("%s has statements before its first %q marker", name, stageMarker)
+			}
+			continue
+		}
+		current.WriteString(line)
+	}
+	flush()
+
+	if len(stages) == 0 {
+		return nil, "", fmt.Errorf("%s has no %q markers", name, stageMarker)
+	}
+	return stages, rendered, nil
+}
+
+// runStages runs the stages of report in order, each in its own transaction that also records the stage's
+// checkpoint, skipping stages already checkpointed by an earlier failed build with the same fingerprint.
+// The checkpoints are cleared once every stage has committed, so the next build starts from the first
+// stage. Between stages, readers see the report tables of finished stages already rebuilt.
+func runStages(db *sql.DB, report, script, fingerprint string, stages []reportStage) error {
+	done, err := loadReportCheckpoints(db, report, fingerprint)
+	if err != nil {
+		return err
+	}
+	if len(done) > 0 {
+		log.Printf("resuming %s report: %d of %d stages already built", report, len(done), len(stages))
+	}
+
+	for _, stage := range stages {
+		if done[stage.name] {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to start %s stage %s transaction: %w", report, stage.name, err)
+		}
+		if err := execStatements(tx, script+"#"+stage.name, stage.statements); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("stage %s: %w", stage.name, err)
+		}
+		checkpointStmt := fmt.Sprintf(`INSERT INTO %q ("report", "stage", "fingerprint", "completed_at", "build_version")
+			VALUES ($1, $2, $3, NOW(), $4)`, reportCheckpointsTable)
+		if _, err := tx.Exec(checkpointStmt, report, stage.name, fingerprint, shared.Version()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to checkpoint %s stage %s: %w", report, stage.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit %s stage %s transaction: %w", report, stage.name, err)
+		}
+	}
+
+	if _, err := db.Exec(fmt.Sprintf(`DELETE FROM %q WHERE "report" = $1`, reportCheckpointsTable), report); err != nil {
+		return fmt.Errorf("failed to clear %s checkpoints: %w", report, err)
+	}
+	return nil
+}
+
+// loadReportCheckpoints returns the stages of report checkpointed with fingerprint. Checkpoints left by a
+// build with another fingerprint describe tables built from other inputs, so they are discarded.
+func loadReportCheckpoints(db *sql.DB, report, fingerprint string) (map[string]bool, error) {
+	createStmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+		"report" VARCHAR(255) NOT NULL,
+		"stage" VARCHAR(255) NOT NULL,
+		"fingerprint" VARCHAR(64) NOT NULL,
+		"completed_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+		"build_version" VARCHAR(255) NOT NULL,
+		PRIMARY KEY ("report", "stage")
+	)`, reportCheckpointsTable)
+	if _, err := db.Exec(createStmt); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", reportCheckpointsTable, err)
+	}
+
+	staleStmt := fmt.Sprintf(`DELETE FROM %q WHERE "report" = $1 AND "fingerprint" <> $2`, reportCheckpointsTable)
+	if _, err := db.Exec(staleStmt, report, fingerprint); err != nil {
+		return nil, fmt.Errorf("failed to discard stale %s checkpoints: %w", report, err)
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT "stage" FROM %q WHERE "report" = $1`, reportCheckpointsTable), report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s checkpoints: %w", report, err)
+	}
+	defer rows.Close()
+
+	done := make(map[string]bool)
+	for rows.Next() {
+		var stage string
+		if err := rows.Scan(&stage); err != nil {
+			return nil, fmt.Errorf("failed to scan %s checkpoint: %w", report, err)
+		}
+		done[stage] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading %s checkpoints: %w", report, err)
+	}
+	return done, nil
+}
+
+// stageFingerprint identifies the inputs of a staged build: the rendered script, which carries the report
+// parameters, and when each source table was last refreshed. A checkpoint only applies to a build with the
+// same fingerprint.
+func stageFingerprint(db *sql.DB, rendered string, sources ...string) (string, error) {
+	hash := sha256.New()
+	hash.Write([]byte(rendered))
+
+	query := fmt.Sprintf(`SELECT COALESCE(MAX("refreshed_at")::TEXT, '') FROM %q WHERE "table_name" = $1`, shared.TableRefreshesTable)
+	for _, source := range sources {
+		var refreshedAt string
+		if err := db.QueryRow(query, source).Scan(&refreshedAt); err != nil {
+			return "", fmt.Errorf("failed to read refresh time of %s: %w", source, err)
+		}
+		fmt.Fprintf(hash, "\n%s=%s", source, refreshedAt)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	// apiCacheMaxAgeEnvKey caps how long a cached API response is served; 0 disables the cache.
+	apiCacheMaxAgeEnvKey = "API_CACHE_MAX_AGE_MINUTES"
+
+	defaultAPICacheMaxAgeMinutes = 60
+	// apiCacheMaxEntries bounds the cache; it is emptied when full.
+	apiCacheMaxEntries = 1000
+	// apiCacheVersionInterval is how often the latest refresh timestamp is read back from the database.
+	apiCacheVersionInterval = 30 * time.Second
+)
+
+// responseCache keeps successful GET responses keyed by path and normalized query. Entries are dropped when
+// the data version, the latest report build or source table refresh recorded in the lineage tables,
+// changes, so cached responses never outlive the data they were computed from by more than
+// apiCacheVersionInterval. Responses carry an ETag; a request whose If-None-Match matches gets a 304.
+type responseCache struct {
+	db     *sql.DB
+	maxAge time.Duration
+
+	mu          sync.Mutex
+	entries     map[string]cachedResponse
+	version     time.Time
+	versionRead time.Time
+}
+
+type cachedResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	etag    string
+	expires time.Time
+}
+
+func newResponseCache(db *sql.DB) *responseCache {
+	return &responseCache{db: db, maxAge: apiCacheMaxAge(), entries: make(map[string]cachedResponse)}
+}
+
+// wrap serves next through the cache.
+func (c *responseCache) wrap(next http.HandlerFunc) http.HandlerFunc {
+	if c.maxAge <= 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		// The caller's role is part of the key, since /graphql answers differently per role.
+		key := requestRole(r.Context()).String() + " " + r.URL.Path + "?" + r.URL.Query().Encode()
+		entry, ok := c.lookup(key)
+		if !ok {
+			recorder := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+			next(recorder, r)
+
+			entry = cachedResponse{status: recorder.status, header: recorder.header, body: recorder.body.Bytes()}
+			if recorder.status == http.StatusOK {
+				sum := sha256.Sum256(entry.body)
+				entry.etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+				entry.expires = time.Now().Add(c.maxAge)
+				c.store(key, entry)
+			}
+		}
+
+		for name, values := range entry.header {
+			w.Header()[name] = values
+		}
+		if entry.etag != "" {
+			w.Header().Set("ETag", entry.etag)
+			// Clients may keep the response but must revalidate it, which costs a 304 once the data is unchanged.
+			w.Header().Set("Cache-Control", "no-cache")
+			if etagMatches(r.Header.Get("If-None-Match"), entry.etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.WriteHeader(entry.status)
+		w.Write(entry.body)
+	}
+}
+
+func (c *responseCache) lookup(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checkVersion()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return cachedResponse{}, false
+	}
+	return entry, true
+}
+
+func (c *responseCache) store(key string, entry cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= apiCacheMaxEntries {
+		c.entries = make(map[string]cachedResponse)
+	}
+	c.entries[key] = entry
+}
+
+// checkVersion empties the cache when the data version moved since it was last read. It is called with
+// c.mu held and reads the database at most once per apiCacheVersionInterval.
+func (c *responseCache) checkVersion() {
+	if time.Since(c.versionRead) < apiCacheVersionInterval {
+		return
+	}
+	c.versionRead = time.Now()
+
+	var version sql.NullTime
+	err := c.db.QueryRow(fmt.Sprintf(`SELECT GREATEST(
+		(SELECT MAX("built_at") FROM %q),
+		(SELECT MAX("refreshed_at") FROM %q))`, shared.LineageTable, shared.TableRefreshesTable)).Scan(&version)
+	if err != nil {
+		// Without a version the cache cannot tell stale entries apart, so start over.
+		log.Printf("failed to read data version for the API cache: %v", err)
+		c.entries = make(map[string]cachedResponse)
+		return
+	}
+	if !version.Time.Equal(c.version) {
+		c.version = version.Time
+		c.entries = make(map[string]cachedResponse)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header lists etag or is "*".
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// responseRecorder buffers a handler's response so it can be cached before it is sent.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	return r.body.Write(p)
+}
+
+func apiCacheMaxAge() time.Duration {
+	raw := strings.TrimSpace(os.Getenv(apiCacheMaxAgeEnvKey))
+	if raw == "" {
+		return time.Duration(defaultAPICacheMaxAgeMinutes) * time.Minute
+	}
+
+	minutes, err := strconv.Atoi(raw)
+	if err != nil || minutes < 0 {
+		log.Printf("invalid %s value %q; defaulting to %d", apiCacheMaxAgeEnvKey, raw, defaultAPICacheMaxAgeMinutes)
+		return time.Duration(defaultAPICacheMaxAgeMinutes) * time.Minute
+	}
+	return time.Duration(minutes) * time.Minute
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	// sheetsExportsEnvKey lists the report tables pushed to Google Sheets after each refresh, as
+	// comma-separated table=spreadsheet_id/tab entries such as "covid_alerts=1AbC.../Alerts". The tab
+	// defaults to the table name and is created when missing. Unset disables the export.
+	sheetsExportsEnvKey = "SHEETS_EXPORTS"
+	// sheetsMaxRowsEnvKey caps the rows pushed per table, keeping large tables under the Sheets cell limit.
+	sheetsMaxRowsEnvKey = "SHEETS_MAX_ROWS"
+
+	defaultSheetsMaxRows = 10000
+	sheetsAPIBase        = "https://sheets.googleapis.com/v4/spreadsheets"
+	sheetsExportTimeout  = 2 * time.Minute
+)
+
+// sheetsEpoch is day zero of the serial numbers Sheets stores dates as.
+var sheetsEpoch = time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+
+// sheetsExport is one report table pushed to a spreadsheet tab.
+type sheetsExport struct {
+	table       string
+	spreadsheet string
+	tab         string
+}
+
+// sheetsExports parses SHEETS_EXPORTS, logging and skipping malformed entries.
+func sheetsExports() []sheetsExport {
+	var exports []sheetsExport
+	for _, entry := range strings.Split(os.Getenv(sheetsExportsEnvKey), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		table, target, ok := strings.Cut(entry, "=")
+		spreadsheet, tab, _ := strings.Cut(strings.TrimSpace(target), "/")
+		table, spreadsheet, tab = strings.TrimSpace(table), strings.TrimSpace(spreadsheet), strings.TrimSpace(tab)
+		if !ok || table == "" || spreadsheet == "" {
+			log.Printf("invalid %s entry %q; expected table=spreadsheet_id/tab", sheetsExportsEnvKey, entry)
+			continue
+		}
+		if tab == "" {
+			tab = table
+		}
+		exports = append(exports, sheetsExport{table: table, spreadsheet: spreadsheet, tab: tab})
+	}
+	return exports
+}
+
+func sheetsMaxRows() int {
+	raw := strings.TrimSpace(os.Getenv(sheetsMaxRowsEnvKey))
+	if raw == "" {
+		return defaultSheetsMaxRows
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		log.Printf("invalid %s value %q; defaulting to %d", sheetsMaxRowsEnvKey, raw, defaultSheetsMaxRows)
+		return defaultSheetsMaxRows
+	}
+	return n
+}
+
+// exportReportSheets pushes every report table produced by a builder run that SHEETS_EXPORTS lists to its
+// tab. Failures are logged rather than returned so that the export never fails an otherwise good build.
+func exportReportSheets(db *sql.DB, reportSources map[string][]string) {
+	exports := sheetsExports()
+	if len(exports) == 0 {
+		return
+	}
+
+	maxRows := sheetsMaxRows()
+	for _, export := range exports {
+		if _, built := reportSources[export.table]; !built {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), sheetsExportTimeout)
+		err := exportSheet(ctx, db, export, maxRows, time.Now())
+		cancel()
+		if err != nil {
+			log.Printf("failed to export %s to Google Sheets: %v", export.table, err)
+			continue
+		}
+		log.Printf("exported %s to spreadsheet %s, tab %q", export.table, export.spreadsheet, export.tab)
+	}
+}
+
+// sheetColumn is one column of an exported table and the Sheets number format its values get.
+type sheetColumn struct {
+	name   string
+	format map[string]string
+}
+
+// exportSheet replaces the contents of the export's tab with the table's first maxRows rows: a bold,
+// frozen header row, then the rows with numbers and dates as typed cells, and a "Last refreshed" cell one
+// column right of the table.
+func exportSheet(ctx context.Context, db *sql.DB, export sheetsExport, maxRows int, refreshed time.Time) error {
+	columns, rows, err := readSheetTable(db, export.table, maxRows)
+	if err != nil {
+		return err
+	}
+
+	sheetID, err := ensureSheetTab(ctx, export.spreadsheet, export.tab)
+	if err != nil {
+		return err
+	}
+
+	// Size the grid to the table first, so the rows fit and rows left from a longer export are dropped.
+	refreshedColumn := len(columns) + 1
+	requests := []map[string]any{{
+		"updateSheetProperties": map[string]any{
+			"properties": map[string]any{
+				"sheetId": sheetID,
+				"gridProperties": map[string]any{
+					"rowCount":       len(rows) + 2,
+					"columnCount":    refreshedColumn + 2,
+					"frozenRowCount": 1,
+				},
+			},
+			"fields": "gridProperties(rowCount,columnCount,frozenRowCount)",
+		},
+	}, {
+		"repeatCell": map[string]any{
+			"range":  map[string]any{"sheetId": sheetID, "startRowIndex": 0, "endRowIndex": 1},
+			"cell":   map[string]any{"userEnteredFormat": map[string]any{"textFormat": map[string]any{"bold": true}}},
+			"fields": "userEnteredFormat.textFormat.bold",
+		},
+	}}
+	for i, column := range columns {
+		if column.format == nil {
+			continue
+		}
+		requests = append(requests, map[string]any{
+			"repeatCell": map[string]any{
+				"range":  map[string]any{"sheetId": sheetID, "startRowIndex": 1, "startColumnIndex": i, "endColumnIndex": i + 1},
+				"cell":   map[string]any{"userEnteredFormat": map[string]any{"numberFormat": column.format}},
+				"fields": "userEnteredFormat.numberFormat",
+			},
+		})
+	}
+	if err := sheetsBatchUpdate(ctx, export.spreadsheet, requests, nil); err != nil {
+		return err
+	}
+
+	tab := sheetsTabRange(export.tab)
+	if err := sheetsCall(ctx, http.MethodPost, fmt.Sprintf("%s/%s/values/%s:clear", sheetsAPIBase, url.PathEscape(export.spreadsheet), url.PathEscape(tab)), map[string]any{}, nil); err != nil {
+		return fmt.Errorf("failed to clear tab %q: %w", export.tab, err)
+	}
+
+	header := make([]any, len(columns))
+	for i, column := range columns {
+		header[i] = column.name
+	}
+	values := append([][]any{header}, rows...)
+	update := map[string]any{
+		"valueInputOption": "RAW",
+		"data": []map[string]any{
+			{"range": tab + "!A1", "values": values},
+			{"range": tab + "!" + sheetsColumnName(refreshedColumn) + "1", "values": [][]any{{"Last refreshed", refreshed.Format("2006-01-02 15:04 MST")}}},
+		},
+	}
+	if err := sheetsCall(ctx, http.MethodPost, fmt.Sprintf("%s/%s/values:batchUpdate", sheetsAPIBase, url.PathEscape(export.spreadsheet)), update, nil); err != nil {
+		return fmt.Errorf("failed to write tab %q: %w", export.tab, err)
+	}
+
+	resize := []map[string]any{{
+		"autoResizeDimensions": map[string]any{
+			"dimensions": map[string]any{"sheetId": sheetID, "dimension": "COLUMNS", "startIndex": 0, "endIndex": refreshedColumn + 2},
+		},
+	}}
+	return sheetsBatchUpdate(ctx, export.spreadsheet, resize, nil)
+}
+
+// readSheetTable reads the first maxRows rows of table as Sheets cell values: numbers as numbers, dates and
+// timestamps as date serial numbers, and everything else as text.
+func readSheetTable(db *sql.DB, table string, maxRows int) ([]sheetColumn, [][]any, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT * FROM %s LIMIT %d`, quoteIdentifier(table), maxRows))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s columns: %w", table, err)
+	}
+	columns := make([]sheetColumn, len(types))
+	for i, columnType := range types {
+		columns[i] = sheetColumn{name: columnType.Name(), format: sheetsNumberFormat(columnType.DatabaseTypeName())}
+	}
+
+	var values [][]any
+	for rows.Next() {
+		raw := make([]any, len(columns))
+		dest := make([]any, len(columns))
+		for i := range raw {
+			dest[i] = &raw[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan %s row: %w", table, err)
+		}
+
+		row := make([]any, len(columns))
+		for i, value := range raw {
+			row[i] = sheetsCellValue(value, columns[i].format != nil)
+		}
+		values = append(values, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error while reading %s rows: %w", table, err)
+	}
+	return columns, values, nil
+}
+
+// sheetsNumberFormat returns the Sheets number format of a Postgres column type, or nil for text.
+func sheetsNumberFormat(databaseType string) map[string]string {
+	switch databaseType {
+	case "INT2", "INT4", "INT8":
+		return map[string]string{"type": "NUMBER", "pattern": "#,##0"}
+	case "NUMERIC", "FLOAT4", "FLOAT8":
+		return map[string]string{"type": "NUMBER", "pattern": "#,##0.####"}
+	case "DATE":
+		return map[string]string{"type": "DATE", "pattern": "yyyy-mm-dd"}
+	case "TIMESTAMP", "TIMESTAMPTZ":
+		return map[string]string{"type": "DATE_TIME", "pattern": "yyyy-mm-dd hh:mm"}
+	}
+	return nil
+}
+
+// sheetsCellValue converts a scanned value into a cell. Values of numeric and date columns become numbers;
+// anything else, including numeric-looking text, stays text because the cells are written RAW.
+func sheetsCellValue(value any, typed bool) any {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		// Sheets has no time zones, so the wall time is kept.
+		wall := time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), v.Second(), 0, time.UTC)
+		return wall.Sub(sheetsEpoch).Hours() / 24
+	case []byte:
+		if typed {
+			if f, err := strconv.ParseFloat(string(v), 64); err == nil {
+				return f
+			}
+		}
+		return string(v)
+	case int64, float64, bool:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// ensureSheetTab returns the sheet id of the tab named title, adding the tab when the spreadsheet lacks it.
+func ensureSheetTab(ctx context.Context, spreadsheet, title string) (int64, error) {
+	var current struct {
+		Sheets []struct {
+			Properties struct {
+				SheetID int64  `json:"sheetId"`
+				Title   string `json:"title"`
+			} `json:"properties"`
+		} `json:"sheets"`
+	}
+	if err := sheetsCall(ctx, http.MethodGet, fmt.Sprintf("%s/%s?fields=sheets.properties(sheetId,title)", sheetsAPIBase, url.PathEscape(spreadsheet)), nil, &current); err != nil {
+		return 0, fmt.Errorf("failed to read spreadsheet %s: %w", spreadsheet, err)
+	}
+	for _, sheet := range current.Sheets {
+		if sheet.Properties.Title == title {
+			return sheet.Properties.SheetID, nil
+		}
+	}
+
+	var added struct {
+		Replies []struct {
+			AddSheet struct {
+				Properties struct {
+					SheetID int64 `json:"sheetId"`
+				} `json:"properties"`
+			} `json:"addSheet"`
+		} `json:"replies"`
+	}
+	addSheet := []map[string]any{{"addSheet": map[string]any{"properties": map[string]any{"title": title}}}}
+	if err := sheetsBatchUpdate(ctx, spreadsheet, addSheet, &added); err != nil {
+		return 0, fmt.Errorf("failed to add tab %q: %w", title, err)
+	}
+	if len(added.Replies) == 0 {
+		return 0, fmt.Errorf("failed to add tab %q: empty reply", title)
+	}
+	return added.Replies[0].AddSheet.Properties.SheetID, nil
+}
+
+func sheetsBatchUpdate(ctx context.Context, spreadsheet string, requests []map[string]any, out any) error {
+	return sheetsCall(ctx, http.MethodPost, fmt.Sprintf("%s/%s:batchUpdate", sheetsAPIBase, url.PathEscape(spreadsheet)), map[string]any{"requests": requests}, out)
+}
+
+// sheetsCall sends a Sheets API request with a JSON body, when body is not nil, and decodes the response
+// into out, when it is not nil.
+func sheetsCall(ctx context.Context, method, endpoint string, body, out any) error {
+	token, err := shared.SheetsAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode Sheets request: %w", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("failed to build Sheets request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Sheets request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read Sheets response: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Sheets returned %s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("failed to decode Sheets response: %w", err)
+		}
+	}
+	return nil
+}
+
+// sheetsTabRange quotes a tab name for A1 notation, e.g. 'Airport trips'.
+func sheetsTabRange(tab string) string {
+	return "'" + strings.ReplaceAll(tab, "'", "''") + "'"
+}
+
+// sheetsColumnName converts a zero-based column index to its A1 letters: 0 is A, 26 is AA.
+func sheetsColumnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const (
+	foodInspectionsTable     = "food_inspections"
+	businessLicensesTable    = "business_licenses"
+	smallBusinessHealthTable = "small_business_health"
+)
+
+// smallBusinessHealthReportSources maps the table built by CreateSmallBusinessHealthReport to the collector
+// tables it reads.
+var smallBusinessHealthReportSources = map[string][]string{
+	smallBusinessHealthTable: {foodInspectionsTable, businessLicensesTable},
+}
+
+// CreateSmallBusinessHealthReport rebuilds small_business_health, the active retail food licenses and food
+// inspection outcomes per ZIP code, for reports such as loan eligibility that weigh local business health.
+func CreateSmallBusinessHealthReport(db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	for _, table := range smallBusinessHealthReportSources[smallBusinessHealthTable] {
+		if err := ensureTableReady(db, table); err != nil {
+			return err
+		}
+	}
+
+	statements, err := renderStatements("small_business_health_report.sql", map[string]string{
+		"Target":      quoteIdentifier(smallBusinessHealthTable),
+		"Inspections": quoteIdentifier(foodInspectionsTable),
+		"Licenses":    quoteIdentifier(businessLicensesTable),
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start small business health report transaction: %w", err)
+	}
+
+	if err := execStatements(tx, "small_business_health_report.sql", statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to commit small business health report transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// reportSnapshotModeEnvKey selects how report tables are kept after each refresh: "table" copies each
+	// report into <report>_YYYYMMDD, "append" adds its rows to <report>_history with a snapshot_date column,
+	// and anything else disables snapshots.
+	reportSnapshotModeEnvKey = "REPORT_SNAPSHOT_MODE"
+	// reportSnapshotRetentionEnvKey sets how many days of snapshots are kept.
+	reportSnapshotRetentionEnvKey = "REPORT_SNAPSHOT_RETENTION_DAYS"
+
+	snapshotModeTable  = "table"
+	snapshotModeAppend = "append"
+
+	defaultSnapshotRetentionDays = 90
+	snapshotDateLayout           = "20060102"
+)
+
+// snapshotReports snapshots every report table produced by a builder run and prunes expired snapshots.
+// Failures are logged rather than returned so that snapshots never fail an otherwise good build.
+func snapshotReports(db *sql.DB, reportSources map[string][]string) {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv(reportSnapshotModeEnvKey)))
+	if mode != snapshotModeTable && mode != snapshotModeAppend {
+		return
+	}
+
+	tables := make([]string, 0, len(reportSources))
+	for reportTable := range reportSources {
+		tables = append(tables, reportTable)
+	}
+	sort.Strings(tables)
+
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -snapshotRetentionDays())
+	for _, reportTable := range tables {
+		var err error
+		if mode == snapshotModeTable {
+			err = snapshotReportTable(db, reportTable, now, cutoff)
+		} else {
+			err = appendReportSnapshot(db, reportTable, now, cutoff)
+		}
+		if err != nil {
+			log.Printf("failed to snapshot %s: %v", reportTable, err)
+		}
+	}
+}
+
+// snapshotReportTable copies reportTable into <report>_YYYYMMDD, replacing a snapshot taken earlier the
+// same day, and drops dated copies older than cutoff.
+func snapshotReportTable(db *sql.DB, reportTable string, now, cutoff time.Time) error {
+	snapshotIdent := quoteIdentifier(reportTable + "_" + now.Format(snapshotDateLayout))
+	statements := []string{
+		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, snapshotIdent),
+		fmt.Sprintf(`CREATE TABLE %s AS TABLE %s`, snapshotIdent, quoteIdentifier(reportTable)),
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("failed to execute statement %q: %w", statement, err)
+		}
+	}
+
+	rows, err := db.Query(`SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_name LIKE $1`,
+		strings.ReplaceAll(reportTable, "_", `\_`)+`\_%`)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	datedName := regexp.MustCompile(`^` + regexp.QuoteMeta(reportTable) + `_(\d{8})$`)
+	var expired []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("failed to scan snapshot name: %w", err)
+		}
+		match := datedName.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		taken, err := time.ParseInLocation(snapshotDateLayout, match[1], now.Location())
+		if err == nil && taken.Before(cutoff) {
+			expired = append(expired, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	for _, name := range expired {
+		if _, err := db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s`, quoteIdentifier(name))); err != nil {
+			return fmt.Errorf("failed to drop expired snapshot %s: %w", name, err)
+		}
+		log.Printf("dropped expired report snapshot %s", name)
+	}
+
+	return nil
+}
+
+// appendReportSnapshot appends reportTable to <report>_history under today's snapshot_date, replacing rows
+// appended earlier the same day, and deletes history rows older than cutoff.
+func appendReportSnapshot(db *sql.DB, reportTable string, now, cutoff time.Time) error {
+	reportIdent := quoteIdentifier(reportTable)
+	historyIdent := quoteIdentifier(reportTable + "_history")
+	snapshotDate := now.Format("2006-01-02")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start snapshot transaction: %w", err)
+	}
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{query: fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s AS SELECT CURRENT_DATE AS snapshot_date, r.* FROM %s r WITH NO DATA`, historyIdent, reportIdent)},
+		{query: fmt.Sprintf(`DELETE FROM %s WHERE snapshot_date = $1::date OR snapshot_date < $2::date`, historyIdent), args: []any{snapshotDate, cutoff.Format("2006-01-02")}},
+		{query: fmt.Sprintf(`INSERT INTO %s SELECT $1::date, r.* FROM %s r`, historyIdent, reportIdent), args: []any{snapshotDate}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement.query, statement.args...); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to execute statement %q: %w", statement.query, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to commit snapshot transaction: %w", err)
+	}
+	return nil
+}
+
+func snapshotRetentionDays() int {
+	raw := strings.TrimSpace(os.Getenv(reportSnapshotRetentionEnvKey))
+	if raw == "" {
+		return defaultSnapshotRetentionDays
+	}
+
+	days, err := strconv.Atoi(raw)
+	if err != nil || days < 0 {
+		log.Printf("invalid %s value %q; defaulting to %d days", reportSnapshotRetentionEnvKey, raw, defaultSnapshotRetentionDays)
+		return defaultSnapshotRetentionDays
+	}
+	return days
+}
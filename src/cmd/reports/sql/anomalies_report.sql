@@ -0,0 +1,62 @@
+-- anomalies_report scores each week of the key weekly aggregates against the preceding weeks of the same
+-- ZIP code and records deviations beyond the configured number of standard deviations. The table
+-- accumulates across refreshes, recording each metric, ZIP code and week once. Identifiers and thresholds
+-- are supplied pre-quoted and validated by CreateAnomaliesReport.
+
+CREATE TABLE IF NOT EXISTS {{.Target}} (
+	"metric" VARCHAR(64) NOT NULL,
+	"geography_type" VARCHAR(3) NOT NULL,
+	"geography_id" VARCHAR(9) NOT NULL,
+	"period_start" DATE NOT NULL,
+	"value" FLOAT8 NOT NULL,
+	"baseline_mean" FLOAT8 NOT NULL,
+	"baseline_stddev" FLOAT8 NOT NULL,
+	"z_score" FLOAT8 NOT NULL,
+	"detected_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	CONSTRAINT anomalies_unique_metric_period UNIQUE ("metric", "geography_id", "period_start")
+);
+-- holidays names the holidays in the flagged week, so a holiday dip can be told apart from a data problem.
+ALTER TABLE {{.Target}} ADD COLUMN IF NOT EXISTS "holidays" VARCHAR(255);
+
+INSERT INTO {{.Target}} ("metric", "geography_type", "geography_id", "period_start", "value", "baseline_mean", "baseline_stddev", "z_score", "holidays")
+SELECT 'weekly_trips_per_zip', 'ZIP', geography_id, period_start, value, baseline_mean, baseline_stddev,
+	(value - baseline_mean) / baseline_stddev,
+	(SELECT STRING_AGG(h."name", ', ' ORDER BY h."date", h."name") FROM {{.Holidays}} h
+		WHERE h."date" BETWEEN period_start AND period_start + 6)
+FROM (
+	SELECT "pickup_zip_code" AS geography_id,
+		week_start AS period_start,
+		weekly_pickups::FLOAT8 AS value,
+		AVG(weekly_pickups) OVER baseline AS baseline_mean,
+		STDDEV_SAMP(weekly_pickups) OVER baseline AS baseline_stddev,
+		COUNT(*) OVER baseline AS baseline_weeks
+	FROM {{.WeeklyPickup}}
+	WHERE COALESCE("pickup_zip_code", '') <> ''
+		AND week_start IS NOT NULL
+	WINDOW baseline AS (PARTITION BY "pickup_zip_code" ORDER BY week_start ROWS BETWEEN {{.BaselineWeeks}} PRECEDING AND 1 PRECEDING)
+) scored
+WHERE baseline_weeks >= {{.MinBaselineWeeks}}
+	AND baseline_stddev > 0
+	AND ABS(value - baseline_mean) / baseline_stddev > {{.Sigma}}
+ON CONFLICT ("metric", "geography_id", "period_start") DO NOTHING;
+
+INSERT INTO {{.Target}} ("metric", "geography_type", "geography_id", "period_start", "value", "baseline_mean", "baseline_stddev", "z_score", "holidays")
+SELECT 'weekly_covid_case_rate', 'ZIP', geography_id, period_start, value, baseline_mean, baseline_stddev,
+	(value - baseline_mean) / baseline_stddev,
+	(SELECT STRING_AGG(h."name", ', ' ORDER BY h."date", h."name") FROM {{.Holidays}} h
+		WHERE h."date" BETWEEN period_start AND period_start + 6)
+FROM (
+	SELECT "zip_code" AS geography_id,
+		"week_start" AS period_start,
+		"case_rate_weekly" AS value,
+		AVG("case_rate_weekly") OVER baseline AS baseline_mean,
+		STDDEV_SAMP("case_rate_weekly") OVER baseline AS baseline_stddev,
+		COUNT(*) OVER baseline AS baseline_weeks
+	FROM {{.Covid}}
+	WHERE "case_rate_weekly" IS NOT NULL
+	WINDOW baseline AS (PARTITION BY "zip_code" ORDER BY "week_start" ROWS BETWEEN {{.BaselineWeeks}} PRECEDING AND 1 PRECEDING)
+) scored
+WHERE baseline_weeks >= {{.MinBaselineWeeks}}
+	AND baseline_stddev > 0
+	AND ABS(value - baseline_mean) / baseline_stddev > {{.Sigma}}
+ON CONFLICT ("metric", "geography_id", "period_start") DO NOTHING;
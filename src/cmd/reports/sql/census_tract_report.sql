@@ -0,0 +1,57 @@
+-- census_tract_report counts building permits and trips by the census tract boundary containing their
+-- point. Trip points are the pickup and dropoff centroids the data portal publishes, which are tract
+-- centroids unless the trip was only located to its community area, so such trips count in the tract holding
+-- the community area's centroid. Identifiers are supplied pre-quoted by CreateCensusTractReport, which finds
+-- the tract boundaries first.
+
+DROP TABLE IF EXISTS {{.Permits}};
+CREATE TABLE {{.Permits}} AS
+SELECT t."census_tract",
+	bp."community_area",
+	bp."permit_category",
+	COUNT(*) AS permits,
+	MIN(bp."issue_date") AS first_issue_date,
+	MAX(bp."issue_date") AS last_issue_date
+FROM {{.BuildingPermits}} bp
+JOIN {{.Tracts}} t
+	ON ST_Within(ST_SetSRID(ST_MakePoint(bp."longitude", bp."latitude"), 4326), t."geom")
+WHERE bp."latitude" IS NOT NULL
+	AND bp."longitude" IS NOT NULL
+GROUP BY t."census_tract", bp."community_area", bp."permit_category";
+CREATE INDEX ON {{.Permits}} ("census_tract");
+
+DROP TABLE IF EXISTS {{.Trips}};
+CREATE TABLE {{.Trips}} AS
+WITH trip_ends AS (
+	-- Trips share a few thousand centroids, so they are counted per point before the spatial join.
+	SELECT (DATE_TRUNC('week', "trip_start_timestamp") - INTERVAL '1 day')::date AS week_start,
+		"pickup_centroid_longitude" AS longitude,
+		"pickup_centroid_latitude" AS latitude,
+		COUNT(*) AS pickups,
+		0 AS dropoffs
+	FROM {{.TaxiTrips}}
+	WHERE "trip_start_timestamp" IS NOT NULL
+		AND "pickup_centroid_latitude" IS NOT NULL
+		AND "pickup_centroid_longitude" IS NOT NULL
+	GROUP BY 1, 2, 3
+	UNION ALL
+	SELECT (DATE_TRUNC('week', "trip_start_timestamp") - INTERVAL '1 day')::date,
+		"dropoff_centroid_longitude",
+		"dropoff_centroid_latitude",
+		0,
+		COUNT(*)
+	FROM {{.TaxiTrips}}
+	WHERE "trip_start_timestamp" IS NOT NULL
+		AND "dropoff_centroid_latitude" IS NOT NULL
+		AND "dropoff_centroid_longitude" IS NOT NULL
+	GROUP BY 1, 2, 3
+)
+SELECT t."census_tract",
+	e.week_start,
+	SUM(e.pickups)::bigint AS pickups,
+	SUM(e.dropoffs)::bigint AS dropoffs
+FROM trip_ends e
+JOIN {{.Tracts}} t
+	ON ST_Within(ST_SetSRID(ST_MakePoint(e.longitude, e.latitude), 4326), t."geom")
+GROUP BY t."census_tract", e.week_start;
+CREATE INDEX ON {{.Trips}} ("census_tract", "week_start");
@@ -0,0 +1,42 @@
+-- coverage_gaps_report lists geographies present in one source dataset but absent from another, so
+-- reports built on joins between them are not silently missing areas. Identifiers are supplied
+-- pre-quoted by CreateCoverageGapsReport.
+
+DROP TABLE IF EXISTS {{.Target}};
+CREATE TABLE {{.Target}} (
+	"gap_type" VARCHAR(64) NOT NULL,
+	"geography_type" VARCHAR(3) NOT NULL,
+	"geography_id" VARCHAR(9) NOT NULL,
+	"present_in" VARCHAR(255) NOT NULL,
+	"missing_from" VARCHAR(255) NOT NULL,
+	"detected_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO {{.Target}} ("gap_type", "geography_type", "geography_id", "present_in", "missing_from")
+SELECT DISTINCT 'covid_zip_without_ccvi', 'ZIP', cv."zip_code", 'covid', 'ccvi'
+FROM {{.Covid}} cv
+WHERE NOT EXISTS (
+	SELECT 1
+	FROM {{.CCVI}} c
+	WHERE c."geography_type" = 'ZIP'
+		AND c."community_area_or_zip" = cv."zip_code"
+);
+
+INSERT INTO {{.Target}} ("gap_type", "geography_type", "geography_id", "present_in", "missing_from")
+SELECT 'community_area_without_permits', 'CA', ph."community_area", 'public_health', 'building_permits'
+FROM {{.PublicHealth}} ph
+WHERE NOT EXISTS (
+	SELECT 1
+	FROM {{.Permits}} bp
+	WHERE bp."community_area" = ph."community_area"
+);
+
+INSERT INTO {{.Target}} ("gap_type", "geography_type", "geography_id", "present_in", "missing_from")
+SELECT 'community_area_without_trips', 'CA', ph."community_area", 'public_health', 'taxi_trips'
+FROM {{.PublicHealth}} ph
+WHERE NOT EXISTS (
+	SELECT 1
+	FROM {{.Trips}} t
+	WHERE t."pickup_community_area" = ph."community_area"
+		OR t."dropoff_community_area" = ph."community_area"
+);
@@ -0,0 +1,273 @@
+-- covid_category_report builds covid_rep_cats and the request 1-4 trip report tables.
+-- Identifiers, the category thresholds, the covid_risk CASE expression, and their JSON definition are
+-- supplied pre-quoted by CreateCovidCategoryReport. Each stage commits on its own; a failed build resumes
+-- from the stage that failed, so a stage must only read tables built by earlier stages or the sources.
+
+-- stage: covid_categories
+DROP TABLE IF EXISTS {{.Target}};
+CREATE TABLE {{.Target}} AS TABLE {{.Covid}};
+ALTER TABLE {{.Target}} ADD COLUMN covid_cat VARCHAR(6);
+ALTER TABLE {{.Target}} ADD COLUMN positivity_cat VARCHAR(6);
+ALTER TABLE {{.Target}} ADD COLUMN covid_risk VARCHAR(6);
+-- covid_cat_definition records the thresholds and risk matrix the categories were built with.
+ALTER TABLE {{.Target}} ADD COLUMN covid_cat_definition JSONB;
+UPDATE {{.Target}}
+SET covid_cat = CASE
+	WHEN "case_rate_weekly" < {{.MediumCaseRate}} THEN 'low'
+	WHEN "case_rate_weekly" >= {{.MediumCaseRate}} AND "case_rate_weekly" < {{.HighCaseRate}} THEN 'medium'
+	WHEN "case_rate_weekly" >= {{.HighCaseRate}} THEN 'high'
+END,
+	covid_cat_definition = {{.CovidCatDefinition}}::jsonb;
+UPDATE {{.Target}}
+SET positivity_cat = CASE
+	WHEN "percent_tested_positive_weekly" < {{.MediumPercentPositive}} THEN 'low'
+	WHEN "percent_tested_positive_weekly" >= {{.MediumPercentPositive}} AND "percent_tested_positive_weekly" < {{.HighPercentPositive}} THEN 'medium'
+	WHEN "percent_tested_positive_weekly" >= {{.HighPercentPositive}} THEN 'high'
+END;
+-- covid_risk stays NULL for weeks missing either metric.
+UPDATE {{.Target}} SET covid_risk = {{.CovidRisk}};
+-- Indexed for per-ZIP lookups such as /api/trips/trends.
+CREATE INDEX ON {{.Target}} ("zip_code", "week_start");
+
+-- stage: trips
+DROP TABLE IF EXISTS {{.Alerts}};
+CREATE TABLE {{.Alerts}} AS TABLE {{.Trips}};
+ALTER TABLE {{.Alerts}} ADD COLUMN airport_dropoff BOOLEAN DEFAULT false;
+ALTER TABLE {{.Alerts}} ADD COLUMN airport_pickup BOOLEAN DEFAULT false;
+UPDATE {{.Alerts}}
+SET airport_dropoff = true
+WHERE "dropoff_zip_code" IN ('60666', '60656', '60665', '60638');
+UPDATE {{.Alerts}}
+SET airport_pickup = true
+WHERE "pickup_zip_code" IN ('60666', '60656', '60665', '60638');
+ALTER TABLE {{.Alerts}} ADD COLUMN day DATE;
+UPDATE {{.Alerts}} SET day = "trip_start_timestamp"::date;
+ALTER TABLE {{.Alerts}} ADD COLUMN week_start DATE;
+UPDATE {{.Alerts}} SET week_start = (DATE_TRUNC('week', "trip_start_timestamp") - INTERVAL '1 day')::date;
+ALTER TABLE {{.Alerts}} ADD COLUMN month_start DATE;
+UPDATE {{.Alerts}} SET month_start = DATE_TRUNC('month', "trip_start_timestamp")::date;
+
+-- stage: airport_trips
+DROP TABLE IF EXISTS {{.AirportTrips}};
+CREATE TABLE {{.AirportTrips}} AS TABLE {{.Target}};
+ALTER TABLE {{.AirportTrips}} ADD COLUMN trips_to_airport INTEGER DEFAULT 0;
+ALTER TABLE {{.AirportTrips}} ADD COLUMN trips_from_airport INTEGER DEFAULT 0;
+UPDATE {{.AirportTrips}} cat
+SET trips_to_airport = airport_counts.trips_to_airport
+FROM (
+	SELECT "pickup_zip_code" AS zip_code, week_start, COUNT(*) AS trips_to_airport
+	FROM {{.Alerts}}
+	WHERE airport_dropoff = true
+	GROUP BY "pickup_zip_code", week_start
+) AS airport_counts
+WHERE cat."zip_code" = airport_counts.zip_code
+	AND cat."week_start" = airport_counts.week_start;
+UPDATE {{.AirportTrips}} cat
+SET trips_from_airport = airport_counts.trips_from_airport
+FROM (
+	SELECT "dropoff_zip_code" AS zip_code, week_start, COUNT(*) AS trips_from_airport
+	FROM {{.Alerts}}
+	WHERE airport_pickup = true
+	GROUP BY "dropoff_zip_code", week_start
+) AS airport_counts
+WHERE cat."zip_code" = airport_counts.zip_code
+	AND cat."week_start" = airport_counts.week_start;
+DROP TABLE IF EXISTS {{.AirportTripsSorted}};
+CREATE TABLE {{.AirportTripsSorted}} AS
+SELECT *
+FROM {{.AirportTrips}}
+ORDER BY "zip_code", "week_start";
+DROP TABLE {{.AirportTrips}};
+ALTER TABLE {{.AirportTripsSorted}} RENAME TO {{.AirportTrips}};
+
+-- stage: airport_anomalies
+-- airport_trips_anomalies compares each ZIP code's weekly airport trips, per direction, with a seasonal
+-- baseline: the same week last year (52 weeks back, so weeks stay Sunday-aligned) when that week was
+-- pulled, otherwise the average of the 8 weeks before. deviation_pct is NULL when the baseline is 0.
+DROP TABLE IF EXISTS {{.AirportAnomalies}};
+CREATE TABLE {{.AirportAnomalies}} AS
+WITH weekly AS (
+	SELECT "zip_code", "week_start", 'to_airport'::VARCHAR(12) AS direction, COALESCE(trips_to_airport, 0) AS trips
+	FROM {{.AirportTrips}}
+	UNION ALL
+	SELECT "zip_code", "week_start", 'from_airport'::VARCHAR(12), COALESCE(trips_from_airport, 0)
+	FROM {{.AirportTrips}}
+), trailing AS (
+	SELECT w.*,
+		AVG(w.trips) OVER (
+			PARTITION BY w."zip_code", w.direction
+			ORDER BY w."week_start"
+			RANGE BETWEEN INTERVAL '56 days' PRECEDING AND INTERVAL '7 days' PRECEDING
+		)::FLOAT8 AS trailing_8_week_avg
+	FROM weekly w
+), baselines AS (
+	SELECT t.*,
+		last_year.trips AS same_week_last_year,
+		CASE
+			WHEN last_year.trips IS NOT NULL THEN 'same_week_last_year'
+			WHEN t.trailing_8_week_avg IS NOT NULL THEN 'trailing_8_weeks'
+		END::VARCHAR(20) AS baseline_method,
+		COALESCE(last_year.trips::FLOAT8, t.trailing_8_week_avg) AS baseline
+	FROM trailing t
+	LEFT JOIN weekly last_year
+		ON last_year."zip_code" = t."zip_code"
+		AND last_year.direction = t.direction
+		AND last_year."week_start" = t."week_start" - 364
+)
+SELECT "zip_code", "week_start", direction, trips, same_week_last_year, trailing_8_week_avg, baseline_method,
+	baseline,
+	trips - baseline AS deviation,
+	(trips - baseline) / NULLIF(baseline, 0) AS deviation_pct
+FROM baselines
+ORDER BY "zip_code", "week_start", direction;
+CREATE INDEX ON {{.AirportAnomalies}} ("zip_code", "week_start");
+
+-- stage: trip_covid_risk
+ALTER TABLE {{.Alerts}} ADD COLUMN pickup_covid_cat VARCHAR(6);
+ALTER TABLE {{.Alerts}} ADD COLUMN dropoff_covid_cat VARCHAR(6);
+ALTER TABLE {{.Alerts}} ADD COLUMN pickup_covid_risk VARCHAR(6);
+ALTER TABLE {{.Alerts}} ADD COLUMN dropoff_covid_risk VARCHAR(6);
+UPDATE {{.Alerts}} t
+SET pickup_covid_cat = c.covid_cat,
+	pickup_covid_risk = c.covid_risk
+FROM {{.Target}} c
+WHERE t."pickup_zip_code" = c."zip_code"
+	AND t."week_start" = c."week_start";
+UPDATE {{.Alerts}} t
+SET dropoff_covid_cat = c.covid_cat,
+	dropoff_covid_risk = c.covid_risk
+FROM {{.Target}} c
+WHERE t."dropoff_zip_code" = c."zip_code"
+	AND t."week_start" = c."week_start";
+
+-- stage: weekly_zip_trips
+DROP TABLE IF EXISTS {{.WeeklyPickup}};
+CREATE TABLE {{.WeeklyPickup}} AS
+SELECT week_start, "pickup_zip_code", COUNT(*) AS weekly_pickups
+FROM {{.Alerts}}
+GROUP BY week_start, "pickup_zip_code";
+DROP TABLE IF EXISTS {{.WeeklyDropoff}};
+CREATE TABLE {{.WeeklyDropoff}} AS
+SELECT week_start, "dropoff_zip_code", COUNT(*) AS weekly_dropoffs
+FROM {{.Alerts}}
+GROUP BY week_start, "dropoff_zip_code";
+CREATE INDEX ON {{.WeeklyPickup}} ("pickup_zip_code", week_start);
+CREATE INDEX ON {{.WeeklyDropoff}} ("dropoff_zip_code", week_start);
+
+-- stage: resident_alerts
+DROP TABLE IF EXISTS {{.AlertsResidents}};
+CREATE TABLE {{.AlertsResidents}} AS TABLE {{.Target}};
+ALTER TABLE {{.AlertsResidents}} ADD COLUMN weekly_dropoffs INTEGER DEFAULT 0;
+UPDATE {{.AlertsResidents}} r
+SET weekly_dropoffs = wd.weekly_dropoffs
+FROM {{.WeeklyDropoff}} wd
+WHERE r."zip_code" = wd."dropoff_zip_code"
+	AND r."week_start" = wd."week_start";
+ALTER TABLE {{.AlertsResidents}} ADD COLUMN weekly_pickups INTEGER DEFAULT 0;
+UPDATE {{.AlertsResidents}} r
+SET weekly_pickups = wp.weekly_pickups
+FROM {{.WeeklyPickup}} wp
+WHERE r."zip_code" = wp."pickup_zip_code"
+	AND r."week_start" = wp."week_start";
+
+-- stage: daily_forecast
+-- The forecasts cover the {{.ForecastHorizon}} periods after the last one pulled, numbered by horizon from 1.
+-- Each period is forecast as the ZIP code's average over the periods pulled so far.
+DROP TABLE IF EXISTS {{.Daily}};
+CREATE TABLE {{.Daily}} AS
+WITH daily_counts AS (
+	SELECT "dropoff_zip_code", day, COUNT(*) AS trips_per_day
+	FROM {{.Alerts}}
+	GROUP BY "dropoff_zip_code", day
+),
+last_day AS (
+	SELECT MAX(day) AS day_value FROM {{.Alerts}}
+),
+horizons AS (
+	SELECT generate_series(1, {{.ForecastHorizon}}) AS horizon
+),
+holiday_days AS (
+	SELECT "date", STRING_AGG("name", ', ' ORDER BY "name") AS holiday
+	FROM {{.Holidays}}
+	GROUP BY "date"
+),
+forecasts AS (
+	SELECT dc."dropoff_zip_code" AS zip_code, (ld.day_value + h.horizon)::date AS day, AVG(dc.trips_per_day) AS trips,
+		h.horizon
+	FROM daily_counts dc
+	CROSS JOIN last_day ld
+	CROSS JOIN horizons h
+	GROUP BY dc."dropoff_zip_code", ld.day_value, h.horizon
+)
+-- The holiday flags mark forecast days whose trips the average likely overstates.
+SELECT f.*, hd.holiday IS NOT NULL AS is_holiday, hd.holiday
+FROM forecasts f
+LEFT JOIN holiday_days hd ON hd."date" = f.day;
+
+-- stage: weekly_forecast
+DROP TABLE IF EXISTS {{.Weekly}};
+CREATE TABLE {{.Weekly}} AS
+WITH weekly_counts AS (
+	SELECT "dropoff_zip_code", week_start, COUNT(*) AS trips_per_week
+	FROM {{.Alerts}}
+	GROUP BY "dropoff_zip_code", week_start
+),
+last_week AS (
+	SELECT MAX(week_start) AS week_value FROM {{.Alerts}}
+),
+horizons AS (
+	SELECT generate_series(1, {{.ForecastHorizon}}) AS horizon
+)
+SELECT wc."dropoff_zip_code" AS zip_code, (lw.week_value + h.horizon * INTERVAL '1 week')::date AS week_start,
+	AVG(wc.trips_per_week) AS trips, h.horizon
+FROM weekly_counts wc
+CROSS JOIN last_week lw
+CROSS JOIN horizons h
+GROUP BY wc."dropoff_zip_code", lw.week_value, h.horizon;
+
+-- stage: ccvi_trips
+DROP TABLE IF EXISTS {{.CCVIReport}};
+CREATE TABLE {{.CCVIReport}} AS
+WITH weekly_trips AS (
+	SELECT week_start, "pickup_zip_code" AS zip_code, COUNT(*) AS trips
+	FROM {{.Alerts}}
+	GROUP BY week_start, "pickup_zip_code"
+	UNION ALL
+	SELECT week_start, "dropoff_zip_code" AS zip_code, COUNT(*) AS trips
+	FROM {{.Alerts}}
+	GROUP BY week_start, "dropoff_zip_code"
+)
+SELECT c.*, wt.week_start, SUM(wt.trips) AS weekly_trips
+FROM {{.CCVI}} c
+JOIN weekly_trips wt ON wt.zip_code = c."community_area_or_zip"
+WHERE c."ccvi_category" = 'HIGH'
+	AND c."geography_type" = 'ZIP'
+GROUP BY c."id", c."geography_type", c."community_area_or_zip", c."community_area_name", c."ccvi_score", c."ccvi_category", wt.week_start;
+DROP TABLE IF EXISTS {{.CCVIReportSorted}};
+CREATE TABLE {{.CCVIReportSorted}} AS
+SELECT *
+FROM {{.CCVIReport}}
+ORDER BY "community_area_or_zip", "week_start";
+DROP TABLE {{.CCVIReport}};
+ALTER TABLE {{.CCVIReportSorted}} RENAME TO {{.CCVIReport}};
+
+-- stage: monthly_forecast
+DROP TABLE IF EXISTS {{.Monthly}};
+CREATE TABLE {{.Monthly}} AS
+WITH monthly_counts AS (
+	SELECT "dropoff_zip_code", month_start, COUNT(*) AS trips_per_month
+	FROM {{.Alerts}}
+	GROUP BY "dropoff_zip_code", month_start
+),
+last_month AS (
+	SELECT MAX(month_start) AS month_value FROM {{.Alerts}}
+),
+horizons AS (
+	SELECT generate_series(1, {{.ForecastHorizon}}) AS horizon
+)
+SELECT mc."dropoff_zip_code" AS zip_code, (lm.month_value + h.horizon * INTERVAL '1 month')::date AS month_start,
+	AVG(mc.trips_per_month) AS trips, h.horizon
+FROM monthly_counts mc
+CROSS JOIN last_month lm
+CROSS JOIN horizons h
+GROUP BY mc."dropoff_zip_code", lm.month_value, h.horizon;
@@ -0,0 +1,23 @@
+-- daily_trips_weather_report counts trips per dropoff ZIP code and day, as the req_4 daily trips report
+-- does, next to that day's weather and holidays, so forecasts of trip demand can use them as regressors and
+-- holiday dips are not mistaken for data problems. Days without a weather observation keep NULL weather;
+-- holiday names the day's holidays, comma-separated, and is NULL on other days. Identifiers are supplied
+-- pre-quoted by CreateDailyTripsWeatherReport.
+
+DROP TABLE IF EXISTS {{.Target}};
+CREATE TABLE {{.Target}} AS
+WITH holiday_days AS (
+	SELECT "date", STRING_AGG("name", ', ' ORDER BY "name") AS holiday
+	FROM {{.Holidays}}
+	GROUP BY "date"
+)
+SELECT t."dropoff_zip_code" AS zip_code, t.day, COUNT(*) AS trips,
+	w."temperature_max_f", w."temperature_min_f", w."precipitation_in", w."snowfall_in",
+	hd.holiday IS NOT NULL AS is_holiday, hd.holiday
+FROM {{.Alerts}} t
+LEFT JOIN {{.Weather}} w ON w."date" = t.day
+LEFT JOIN holiday_days hd ON hd."date" = t.day
+WHERE t."dropoff_zip_code" IS NOT NULL
+GROUP BY t."dropoff_zip_code", t.day, w."temperature_max_f", w."temperature_min_f", w."precipitation_in", w."snowfall_in",
+	hd.holiday;
+CREATE INDEX ON {{.Target}} (zip_code, day);
@@ -0,0 +1,74 @@
+-- disadvantaged_report flags the AreaCount community areas ranked highest by composite score, which
+-- CreateDisadvantagedReport stores in Scores first, and copies the flags onto building permits. The top
+-- five areas by poverty and by unemployment are still marked for reference. Identifiers are supplied
+-- pre-quoted by CreateDisadvantagedReport, and VacantBuildings is empty when the vacant_buildings table is
+-- not ready.
+
+DROP TABLE IF EXISTS {{.Permits}};
+CREATE TABLE {{.Permits}} AS TABLE {{.BuildingPermits}};
+ALTER TABLE {{.Permits}} ADD COLUMN zip_code VARCHAR(9) DEFAULT '';
+-- spatial_community_area is the community area boundary containing the permit, and geography_check
+-- compares it and the permit's ZIP code with the reported community area and its crosswalk ZIP code.
+ALTER TABLE {{.Permits}}
+	ADD COLUMN spatial_community_area VARCHAR(2),
+	ADD COLUMN crosswalk_zip_code VARCHAR(9),
+	ADD COLUMN geography_check VARCHAR(32);
+ALTER TABLE {{.Permits}}
+	ADD COLUMN top_5_poverty BOOLEAN DEFAULT FALSE,
+	ADD COLUMN top_5_unemployment BOOLEAN DEFAULT FALSE,
+	ADD COLUMN disadvantaged BOOLEAN DEFAULT FALSE;
+
+DROP TABLE IF EXISTS {{.Target}};
+CREATE TABLE {{.Target}} AS TABLE {{.PublicHealth}};
+ALTER TABLE {{.Target}} ADD COLUMN zip_code VARCHAR(9) DEFAULT '';
+ALTER TABLE {{.Target}}
+	ADD COLUMN top_5_poverty BOOLEAN DEFAULT FALSE,
+	ADD COLUMN top_5_unemployment BOOLEAN DEFAULT FALSE,
+	ADD COLUMN disadvantaged BOOLEAN DEFAULT FALSE;
+UPDATE {{.Target}}
+SET top_5_poverty = TRUE
+WHERE "community_area" IN (
+	SELECT "community_area"
+	FROM {{.Target}}
+	ORDER BY "below_poverty_level" DESC
+	LIMIT 5
+);
+UPDATE {{.Target}}
+SET top_5_unemployment = TRUE
+WHERE "community_area" IN (
+	SELECT "community_area"
+	FROM {{.Target}}
+	ORDER BY "unemployment" DESC
+	LIMIT 5
+);
+ALTER TABLE {{.Target}}
+	ADD COLUMN composite_score FLOAT8,
+	ADD COLUMN composite_rank INTEGER;
+UPDATE {{.Target}} d
+SET composite_score = s."composite_score",
+	composite_rank = s."composite_rank"
+FROM {{.Scores}} s
+WHERE d."community_area" = s."community_area";
+UPDATE {{.Target}}
+SET disadvantaged = COALESCE(composite_rank <= {{.AreaCount}}, FALSE);
+-- vacant_building_reports counts the 311 vacant and abandoned building reports per community area. It
+-- stays 0 until the vacant_buildings collector has run.
+ALTER TABLE {{.Target}} ADD COLUMN vacant_building_reports INTEGER DEFAULT 0;
+{{- if .VacantBuildings}}
+UPDATE {{.Target}} d
+SET vacant_building_reports = v.reports
+FROM (
+	SELECT "community_area", COUNT(*) AS reports
+	FROM {{.VacantBuildings}}
+	GROUP BY "community_area"
+) v
+WHERE d."community_area" = v."community_area";
+{{- end}}
+
+UPDATE {{.Permits}} dp
+SET top_5_poverty = d.top_5_poverty,
+	top_5_unemployment = d.top_5_unemployment,
+	disadvantaged = d.disadvantaged
+FROM {{.Target}} d
+WHERE dp."community_area" = d."community_area";
+ALTER TABLE {{.Permits}} RENAME COLUMN disadvantaged TO waived_fee;
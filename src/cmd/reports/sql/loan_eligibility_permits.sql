@@ -0,0 +1,78 @@
+-- loan_eligibility_permits keeps new construction permits in low income ZIP codes and scores each ZIP code
+-- by how little new construction it sees for its population and income, as loanScoreFormula describes. The
+-- permits of the ZIP codes ranked first are kept. Identifiers are supplied pre-quoted by
+-- createLoanEligibilityPermits, and Population is empty when the population table is not loaded, in which
+-- case the ZIP codes are compared on raw permit counts and fee totals.
+
+DROP TABLE IF EXISTS {{.LoanElig}};
+CREATE TABLE {{.LoanElig}} AS TABLE {{.Permits}};
+DELETE FROM {{.LoanElig}} WHERE "permit_category" IS NULL OR "permit_category" <> 'new_construction';
+
+ALTER TABLE {{.LoanElig}} ADD COLUMN per_capita_income NUMERIC;
+UPDATE {{.LoanElig}} lp
+SET per_capita_income = d.per_capita_income
+FROM {{.Disadvantaged}} d
+WHERE lp."zip_code" <> '' AND lp."zip_code" = d."zip_code";
+DELETE FROM {{.LoanElig}} WHERE per_capita_income IS NULL OR per_capita_income >= {{.IncomeLimit}};
+
+ALTER TABLE {{.LoanElig}}
+	ADD COLUMN new_const_permits_for_zip INTEGER DEFAULT 0,
+	ADD COLUMN new_const_fees_for_zip FLOAT8 DEFAULT 0,
+	ADD COLUMN zip_population INTEGER,
+	ADD COLUMN permits_per_1000_residents FLOAT8,
+	ADD COLUMN fees_per_resident FLOAT8,
+	ADD COLUMN loan_score FLOAT8,
+	ADD COLUMN loan_rank INTEGER;
+UPDATE {{.LoanElig}} lp
+SET new_const_permits_for_zip = scores.permits,
+	new_const_fees_for_zip = scores.fees,
+	zip_population = scores.population,
+	permits_per_1000_residents = scores.permits_per_1000_residents,
+	fees_per_resident = scores.fees_per_resident,
+	loan_score = scores.loan_score,
+	loan_rank = scores.loan_rank
+FROM (
+	WITH zips AS (
+		SELECT "zip_code",
+			COUNT(*) AS permits,
+			COALESCE(SUM("total_fee"), 0)::FLOAT8 AS fees,
+			MAX(per_capita_income)::FLOAT8 AS per_capita_income
+		FROM {{.LoanElig}}
+		WHERE "zip_code" <> ''
+		GROUP BY "zip_code"
+	), rates AS (
+		SELECT z.*,
+{{- if .Population}}
+			p."population",
+			z.permits * 1000.0 / NULLIF(p."population", 0) AS permits_per_1000_residents,
+			z.fees / NULLIF(p."population", 0) AS fees_per_resident,
+			z.permits * 1000.0 / NULLIF(p."population", 0) AS permit_rate,
+			z.fees / NULLIF(p."population", 0) AS fee_rate
+		FROM zips z
+		LEFT JOIN {{.Population}} p
+			ON p."geography_type" = 'zip_code'
+			AND p."geography_id" = z."zip_code"
+{{- else}}
+			NULL::INTEGER AS population,
+			NULL::FLOAT8 AS permits_per_1000_residents,
+			NULL::FLOAT8 AS fees_per_resident,
+			z.permits::FLOAT8 AS permit_rate,
+			z.fees AS fee_rate
+		FROM zips z
+{{- end}}
+	), scored AS (
+		SELECT r.*,
+			(1 - (COALESCE(r.permit_rate / NULLIF(MAX(r.permit_rate) OVER (), 0), 0)
+				+ COALESCE(r.fee_rate / NULLIF(MAX(r.fee_rate) OVER (), 0), 0)) / 2)
+			* (1 - r.per_capita_income / {{.IncomeLimit}}) AS loan_score
+		FROM rates r
+		WHERE r.permit_rate IS NOT NULL
+	)
+	SELECT s.*, RANK() OVER (ORDER BY s.loan_score DESC)::INTEGER AS loan_rank
+	FROM scored s
+) scores
+WHERE lp."zip_code" = scores."zip_code";
+
+ALTER TABLE {{.LoanElig}} ADD COLUMN loan_eligibility BOOLEAN DEFAULT FALSE;
+UPDATE {{.LoanElig}} SET loan_eligibility = TRUE WHERE loan_rank = 1;
+DELETE FROM {{.LoanElig}} WHERE loan_eligibility IS NOT TRUE;
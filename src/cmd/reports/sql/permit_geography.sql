@@ -0,0 +1,19 @@
+-- permit_geography places each permit with coordinates in the community area and ZIP code boundaries
+-- containing its point, replacing the one ZIP code per community area of the crosswalk with the permit's
+-- actual location. Permits that already have a ZIP code from their address keep it. Identifiers are
+-- supplied pre-quoted by placePermitsInBoundaries, which loads the boundaries first.
+
+UPDATE {{.Permits}} p
+SET spatial_community_area = a."community_area"
+FROM {{.AreaBoundaries}} a
+WHERE p."latitude" IS NOT NULL
+	AND p."longitude" IS NOT NULL
+	AND ST_Within(ST_SetSRID(ST_MakePoint(p."longitude", p."latitude"), 4326), a."geom");
+
+UPDATE {{.Permits}} p
+SET zip_code = z."zip_code"
+FROM {{.ZipBoundaries}} z
+WHERE p.zip_code = ''
+	AND p."latitude" IS NOT NULL
+	AND p."longitude" IS NOT NULL
+	AND ST_Within(ST_SetSRID(ST_MakePoint(p."longitude", p."latitude"), 4326), z."geom");
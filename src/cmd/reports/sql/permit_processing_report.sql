@@ -0,0 +1,22 @@
+-- permit_processing_report summarizes how long building permits took from application to issue for
+-- construction businesses: the median and 90th percentile processing days of the permits issued in each
+-- quarter per permit category and ward, and the share issued within SLADays days. Permits without a ward or
+-- processing_days are left out. Identifiers are supplied pre-quoted by CreatePermitProcessingReport.
+
+DROP TABLE IF EXISTS {{.Target}};
+CREATE TABLE {{.Target}} AS
+SELECT DATE_TRUNC('quarter', "issue_date")::date AS "quarter_start",
+	TO_CHAR("issue_date", 'YYYY-"Q"Q') AS "quarter",
+	COALESCE("permit_category", 'other') AS "permit_category",
+	"ward",
+	COUNT(*) AS "permits",
+	PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY "processing_days") AS "median_processing_days",
+	PERCENTILE_CONT(0.9) WITHIN GROUP (ORDER BY "processing_days") AS "p90_processing_days",
+	{{.SLADays}} AS "sla_days",
+	ROUND((100.0 * COUNT(*) FILTER (WHERE "processing_days" <= {{.SLADays}}) / COUNT(*))::numeric, 2)::float8 AS "within_sla_pct"
+FROM {{.Permits}}
+WHERE "issue_date" IS NOT NULL
+	AND "processing_days" IS NOT NULL
+	AND COALESCE("ward", '') <> ''
+GROUP BY 1, 2, 3, 4;
+CREATE INDEX ON {{.Target}} ("quarter_start", "permit_category", "ward");
@@ -0,0 +1,43 @@
+-- small_business_health_report summarizes retail food businesses per ZIP code: active licenses and the
+-- outcome of their food inspections. Inspections are linked to business licenses by license number and
+-- placed in the license's ZIP code, falling back to the inspection's. Identifiers are supplied pre-quoted
+-- by CreateSmallBusinessHealthReport.
+
+DROP TABLE IF EXISTS {{.Target}};
+CREATE TABLE {{.Target}} AS
+WITH licenses AS (
+	-- A license number has one row per term, and the latest term places and describes the business.
+	SELECT DISTINCT ON ("license_number") "license_number", LEFT("zip_code", 5) AS zip_code, "license_status"
+	FROM {{.Licenses}}
+	ORDER BY "license_number", "expiration_date" DESC NULLS LAST
+),
+license_counts AS (
+	SELECT zip_code, COUNT(*) FILTER (WHERE "license_status" = 'AAI') AS active_licenses
+	FROM licenses
+	WHERE zip_code IS NOT NULL
+	GROUP BY zip_code
+),
+inspection_counts AS (
+	SELECT COALESCE(l.zip_code, LEFT(f."zip_code", 5)) AS zip_code,
+		COUNT(*) AS inspections,
+		COUNT(l."license_number") AS linked_inspections,
+		COUNT(*) FILTER (WHERE f."results" IN ('Pass', 'Pass w/ Conditions')) AS passed,
+		COUNT(*) FILTER (WHERE f."results" = 'Fail') AS failed,
+		COUNT(*) FILTER (WHERE f."results" = 'Out of Business') AS out_of_business
+	FROM {{.Inspections}} f
+	LEFT JOIN licenses l ON l."license_number" = f."license_number"
+	GROUP BY 1
+)
+SELECT COALESCE(lc.zip_code, ic.zip_code) AS zip_code,
+	COALESCE(lc.active_licenses, 0) AS active_licenses,
+	COALESCE(ic.inspections, 0) AS inspections,
+	COALESCE(ic.linked_inspections, 0) AS linked_inspections,
+	COALESCE(ic.passed, 0) AS passed,
+	COALESCE(ic.failed, 0) AS failed,
+	COALESCE(ic.out_of_business, 0) AS out_of_business,
+	-- pass_rate ignores inspections that did not reach a pass or fail, such as closed businesses.
+	ic.passed::float8 / NULLIF(ic.passed + ic.failed, 0) AS pass_rate
+FROM license_counts lc
+FULL OUTER JOIN inspection_counts ic ON ic.zip_code = lc.zip_code
+WHERE COALESCE(lc.zip_code, ic.zip_code) IS NOT NULL;
+CREATE INDEX ON {{.Target}} (zip_code);
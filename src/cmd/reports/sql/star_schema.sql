@@ -0,0 +1,173 @@
+-- star_schema builds the dimension and fact tables BI tools such as Looker Studio connect to, on top of the
+-- collector and report tables. Their names, columns, and types are kept stable across refreshes, so
+-- dashboards do not break when a report table changes shape. They are tables rather than views because
+-- the collectors and report builds drop and recreate the tables they would depend on. Identifiers are
+-- supplied pre-quoted by CreateStarSchemaReport. Comments must not contain semicolons, which split
+-- statements.
+
+DROP TABLE IF EXISTS {{.DimZip}};
+CREATE TABLE {{.DimZip}} (
+	"zip_code" VARCHAR(5) PRIMARY KEY,
+	"is_airport" BOOLEAN NOT NULL,
+	"ccvi_score" DOUBLE PRECISION,
+	"ccvi_category" VARCHAR(6)
+);
+INSERT INTO {{.DimZip}} ("zip_code", "is_airport", "ccvi_score", "ccvi_category")
+SELECT z.zip_code,
+	z.zip_code IN ('60666', '60656', '60665', '60638'),
+	c."ccvi_score",
+	c."ccvi_category"
+FROM (
+	SELECT "zip_code" FROM {{.CovidCategories}}
+	UNION SELECT LEFT("pickup_zip_code", 5) FROM {{.Alerts}}
+	UNION SELECT LEFT("dropoff_zip_code", 5) FROM {{.Alerts}}
+	UNION SELECT "community_area_or_zip" FROM {{.CCVI}} WHERE "geography_type" = 'ZIP'
+) z(zip_code)
+LEFT JOIN {{.CCVI}} c ON c."geography_type" = 'ZIP' AND c."community_area_or_zip" = z.zip_code
+WHERE z.zip_code ~ '^[0-9]{5}$';
+COMMENT ON TABLE {{.DimZip}} IS 'One row per Chicago ZIP code seen in the COVID, trip, or CCVI data.';
+COMMENT ON COLUMN {{.DimZip}}."is_airport" IS 'Whether the ZIP code is counted as an airport by the airport trips report.';
+COMMENT ON COLUMN {{.DimZip}}."ccvi_category" IS 'COVID Community Vulnerability Index category: LOW, MEDIUM, or HIGH.';
+
+DROP TABLE IF EXISTS {{.DimCommunityArea}};
+CREATE TABLE {{.DimCommunityArea}} (
+	"community_area" VARCHAR(2) PRIMARY KEY,
+	"community_area_number" INTEGER NOT NULL,
+	"community_area_name" VARCHAR(255),
+	"below_poverty_level" DOUBLE PRECISION,
+	"unemployment" DOUBLE PRECISION,
+	"per_capita_income" DOUBLE PRECISION,
+	"ccvi_score" DOUBLE PRECISION,
+	"ccvi_category" VARCHAR(6),
+	"composite_score" DOUBLE PRECISION,
+	"composite_rank" INTEGER,
+	"is_disadvantaged" BOOLEAN NOT NULL
+);
+INSERT INTO {{.DimCommunityArea}}
+SELECT d."community_area",
+	d."community_area"::int,
+	c."community_area_name",
+	d."below_poverty_level",
+	d."unemployment",
+	d."per_capita_income",
+	c."ccvi_score",
+	c."ccvi_category",
+	d."composite_score",
+	d."composite_rank",
+	COALESCE(d."disadvantaged", FALSE)
+FROM {{.Disadvantaged}} d
+LEFT JOIN {{.CCVI}} c ON c."geography_type" = 'CA' AND c."community_area_or_zip" = d."community_area"
+WHERE d."community_area" ~ '^[0-9]{1,2}$';
+COMMENT ON TABLE {{.DimCommunityArea}} IS 'One row per Chicago community area with its socioeconomic indicators and disadvantage ranking.';
+COMMENT ON COLUMN {{.DimCommunityArea}}."below_poverty_level" IS 'Percent of households below the poverty level.';
+COMMENT ON COLUMN {{.DimCommunityArea}}."unemployment" IS 'Percent of residents aged 16 and over who are unemployed.';
+COMMENT ON COLUMN {{.DimCommunityArea}}."is_disadvantaged" IS 'Whether the disadvantaged report flags the area, by composite rank.';
+
+-- dim_date spans every day from the earliest to the latest trip, COVID week, or permit.
+DROP TABLE IF EXISTS {{.DimDate}};
+CREATE TABLE {{.DimDate}} (
+	"date_key" INTEGER PRIMARY KEY,
+	"date" DATE NOT NULL UNIQUE,
+	"year" INTEGER NOT NULL,
+	"quarter" INTEGER NOT NULL,
+	"month" INTEGER NOT NULL,
+	"month_name" VARCHAR(9) NOT NULL,
+	"day_of_month" INTEGER NOT NULL,
+	"day_of_week" INTEGER NOT NULL,
+	"day_name" VARCHAR(9) NOT NULL,
+	"is_weekend" BOOLEAN NOT NULL,
+	"week_start" DATE NOT NULL,
+	"month_start" DATE NOT NULL
+);
+INSERT INTO {{.DimDate}}
+SELECT TO_CHAR(d, 'YYYYMMDD')::int,
+	d,
+	EXTRACT(YEAR FROM d)::int,
+	EXTRACT(QUARTER FROM d)::int,
+	EXTRACT(MONTH FROM d)::int,
+	TRIM(TO_CHAR(d, 'FMMonth')),
+	EXTRACT(DAY FROM d)::int,
+	EXTRACT(DOW FROM d)::int,
+	TRIM(TO_CHAR(d, 'FMDay')),
+	EXTRACT(DOW FROM d) IN (0, 6),
+	-- The same week_start the trip reports assign, so facts join on it.
+	(DATE_TRUNC('week', d) - INTERVAL '1 day')::date,
+	DATE_TRUNC('month', d)::date
+FROM (
+	SELECT generate_series(MIN(first_day), MAX(last_day), INTERVAL '1 day')::date AS d
+	FROM (
+		SELECT MIN(day) AS first_day, MAX(day) AS last_day FROM {{.Alerts}}
+		UNION ALL SELECT MIN("week_start"), MAX("week_end") FROM {{.CovidCategories}}
+		UNION ALL SELECT MIN("issue_date"), MAX("issue_date") FROM {{.Permits}}
+	) bounds
+) days;
+COMMENT ON TABLE {{.DimDate}} IS 'Calendar of every day covered by the trip, COVID, and permit data. date_key is YYYYMMDD.';
+COMMENT ON COLUMN {{.DimDate}}."day_of_week" IS '0 is Sunday.';
+COMMENT ON COLUMN {{.DimDate}}."week_start" IS 'Start of the week as assigned by the trip reports, for joining fact_trips_weekly.';
+
+DROP TABLE IF EXISTS {{.FactTripsWeekly}};
+CREATE TABLE {{.FactTripsWeekly}} (
+	"week_start" DATE NOT NULL,
+	"week_date_key" INTEGER NOT NULL,
+	"pickup_zip_code" VARCHAR(5),
+	"dropoff_zip_code" VARCHAR(5),
+	"pickup_community_area" VARCHAR(2),
+	"dropoff_community_area" VARCHAR(2),
+	"trip_type" VARCHAR(50),
+	"pickup_covid_cat" VARCHAR(6),
+	"dropoff_covid_cat" VARCHAR(6),
+	"trips" BIGINT NOT NULL,
+	"airport_trips" BIGINT NOT NULL
+);
+INSERT INTO {{.FactTripsWeekly}}
+SELECT week_start,
+	TO_CHAR(week_start, 'YYYYMMDD')::int,
+	LEFT("pickup_zip_code", 5),
+	LEFT("dropoff_zip_code", 5),
+	"pickup_community_area",
+	"dropoff_community_area",
+	"trip_type",
+	pickup_covid_cat,
+	dropoff_covid_cat,
+	COUNT(*),
+	COUNT(*) FILTER (WHERE airport_pickup OR airport_dropoff)
+FROM {{.Alerts}}
+WHERE week_start IS NOT NULL
+GROUP BY 1, 2, 3, 4, 5, 6, 7, 8, 9;
+CREATE INDEX ON {{.FactTripsWeekly}} ("week_start");
+CREATE INDEX ON {{.FactTripsWeekly}} ("pickup_zip_code");
+CREATE INDEX ON {{.FactTripsWeekly}} ("dropoff_zip_code");
+COMMENT ON TABLE {{.FactTripsWeekly}} IS 'Taxi and rideshare trips per week, pickup and dropoff ZIP code and community area, and trip type.';
+COMMENT ON COLUMN {{.FactTripsWeekly}}."week_date_key" IS 'dim_date key of week_start.';
+COMMENT ON COLUMN {{.FactTripsWeekly}}."pickup_covid_cat" IS 'COVID category of the pickup ZIP code that week: low, medium, or high.';
+COMMENT ON COLUMN {{.FactTripsWeekly}}."airport_trips" IS 'Trips picked up or dropped off at an airport ZIP code.';
+
+DROP TABLE IF EXISTS {{.FactPermits}};
+CREATE TABLE {{.FactPermits}} (
+	"permit_id" VARCHAR(255) NOT NULL,
+	"issue_date" DATE,
+	"issue_date_key" INTEGER,
+	"permit_type" VARCHAR(255),
+	"permit_category" VARCHAR(64),
+	"community_area" VARCHAR(2),
+	"zip_code" VARCHAR(5),
+	"latitude" DOUBLE PRECISION,
+	"longitude" DOUBLE PRECISION,
+	"is_disadvantaged_area" BOOLEAN NOT NULL
+);
+INSERT INTO {{.FactPermits}}
+SELECT COALESCE("permit_id", "id"),
+	"issue_date",
+	TO_CHAR("issue_date", 'YYYYMMDD')::int,
+	"permit_type",
+	"permit_category",
+	NULLIF("community_area", ''),
+	LEFT(COALESCE(NULLIF("zip_code", ''), NULLIF("address_zip", '')), 5),
+	"latitude",
+	"longitude",
+	COALESCE("waived_fee", FALSE)
+FROM {{.Permits}};
+CREATE INDEX ON {{.FactPermits}} ("issue_date");
+CREATE INDEX ON {{.FactPermits}} ("community_area");
+COMMENT ON TABLE {{.FactPermits}} IS 'One row per building permit, keyed to dim_date, dim_zip, and dim_community_area.';
+COMMENT ON COLUMN {{.FactPermits}}."is_disadvantaged_area" IS 'Whether the permit lies in a disadvantaged community area, which waives its fee.';
@@ -0,0 +1,43 @@
+-- trip_fares_report summarizes the fares of the trips picked up in each ZIP code per week and trip type for
+-- taxi owners: the median fare, tips as a percentage of fares, and revenue. Fares below the 1st or above the
+-- 99th percentile of their week and trip type are trimmed as outliers and only counted in "trimmed_trips".
+-- Weeks start on Sunday like covid_rep_cats. Identifiers are supplied pre-quoted by CreateTripFaresReport.
+
+DROP TABLE IF EXISTS {{.Target}};
+CREATE TABLE {{.Target}} AS
+WITH fares AS (
+	SELECT "pickup_zip_code",
+		"trip_type",
+		(DATE_TRUNC('week', "trip_start_timestamp") - INTERVAL '1 day')::date AS "week_start",
+		"fare",
+		COALESCE("tips", 0) AS "tips",
+		COALESCE("trip_total", "fare" + COALESCE("tips", 0)) AS "trip_total"
+	FROM {{.Trips}}
+	WHERE "pickup_zip_code" IS NOT NULL
+		AND "pickup_zip_code" <> ''
+		AND "trip_start_timestamp" IS NOT NULL
+		AND "fare" IS NOT NULL
+),
+bounds AS (
+	SELECT "week_start", "trip_type",
+		PERCENTILE_CONT(0.01) WITHIN GROUP (ORDER BY "fare") AS "p01",
+		PERCENTILE_CONT(0.99) WITHIN GROUP (ORDER BY "fare") AS "p99"
+	FROM fares
+	GROUP BY "week_start", "trip_type"
+),
+flagged AS (
+	SELECT f.*, f."fare" BETWEEN b."p01" AND b."p99" AS "kept"
+	FROM fares f
+	JOIN bounds b USING ("week_start", "trip_type")
+)
+SELECT "week_start",
+	"pickup_zip_code",
+	"trip_type",
+	COUNT(*) FILTER (WHERE "kept") AS "trips",
+	COUNT(*) FILTER (WHERE NOT "kept") AS "trimmed_trips",
+	PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY "fare") FILTER (WHERE "kept") AS "median_fare",
+	ROUND((100 * SUM("tips") FILTER (WHERE "kept") / NULLIF(SUM("fare") FILTER (WHERE "kept"), 0))::numeric, 2)::float8 AS "tip_pct",
+	ROUND(COALESCE(SUM("trip_total") FILTER (WHERE "kept"), 0)::numeric, 2)::float8 AS "revenue"
+FROM flagged
+GROUP BY "week_start", "pickup_zip_code", "trip_type";
+CREATE INDEX ON {{.Target}} ("pickup_zip_code", "week_start");
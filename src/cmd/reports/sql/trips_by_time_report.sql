@@ -0,0 +1,18 @@
+-- trips_by_time_report counts trips per pickup ZIP code by hour of day and day of week, split by the pickup
+-- ZIP code's COVID categories that week, so drivers can see when pickups in high-COVID ZIP codes happen.
+-- Hours and days are Chicago local time. Identifiers are supplied pre-quoted by CreateTripsByTimeReport.
+
+DROP TABLE IF EXISTS {{.Target}};
+CREATE TABLE {{.Target}} AS
+SELECT "pickup_zip_code",
+	-- 0 is Sunday.
+	EXTRACT(DOW FROM "trip_start_timestamp" AT TIME ZONE 'America/Chicago')::int AS "day_of_week",
+	EXTRACT(HOUR FROM "trip_start_timestamp" AT TIME ZONE 'America/Chicago')::int AS "hour_of_day",
+	"pickup_covid_cat",
+	"pickup_covid_risk",
+	COUNT(*) AS "trips"
+FROM {{.Alerts}}
+WHERE "pickup_zip_code" IS NOT NULL
+	AND "trip_start_timestamp" IS NOT NULL
+GROUP BY 1, 2, 3, 4, 5;
+CREATE INDEX ON {{.Target}} ("pickup_zip_code", "day_of_week", "hour_of_day");
@@ -0,0 +1,49 @@
+-- zoning_report places each building permit in the zoning district containing its coordinates and groups
+-- the zoning classes into categories, flagging planned manufacturing districts (PMD) and the M1-M3
+-- manufacturing districts of the industrial corridors as industrial. Permits without coordinates, or
+-- outside every district, keep a NULL zone_class. Identifiers are supplied pre-quoted by
+-- CreateZoningReport, which loads the districts first.
+
+DROP TABLE IF EXISTS {{.Target}};
+CREATE TABLE {{.Target}} AS
+SELECT bp."id",
+	bp."permit_id",
+	bp."permit_type",
+	bp."permit_category",
+	bp."issue_date",
+	bp."community_area",
+	LEFT(bp."address_zip", 5) AS zip_code,
+	z."zone_class",
+	CASE
+		WHEN z."zone_class" IS NULL THEN NULL
+		WHEN z."zone_class" LIKE 'PMD%' THEN 'planned_manufacturing'
+		WHEN z."zone_class" ~ '^M[1-3]' THEN 'manufacturing'
+		WHEN z."zone_class" ~ '^[BC][1-3]' THEN 'business_commercial'
+		WHEN z."zone_class" ~ '^R[STM]' THEN 'residential'
+		WHEN z."zone_class" ~ '^D[CXRS]' THEN 'downtown'
+		WHEN z."zone_class" LIKE 'PD%' THEN 'planned_development'
+		WHEN z."zone_class" LIKE 'POS%' THEN 'parks_open_space'
+		WHEN z."zone_class" LIKE 'T%' THEN 'transportation'
+		ELSE 'other'
+	END AS zone_category,
+	COALESCE(z."zone_class" LIKE 'PMD%' OR z."zone_class" ~ '^M[1-3]', FALSE) AS industrial
+FROM {{.Permits}} bp
+LEFT JOIN LATERAL (
+	-- District boundaries meet along streets, so a point on a shared edge takes the first district found.
+	SELECT d."zone_class"
+	FROM {{.Districts}} d
+	WHERE bp."latitude" IS NOT NULL
+		AND bp."longitude" IS NOT NULL
+		AND ST_Intersects(d."geom", ST_SetSRID(ST_MakePoint(bp."longitude", bp."latitude"), 4326))
+	LIMIT 1
+) z ON TRUE;
+
+DROP TABLE IF EXISTS {{.Summary}};
+CREATE TABLE {{.Summary}} AS
+SELECT "community_area",
+	COALESCE("zone_category", 'unzoned') AS zone_category,
+	"industrial",
+	"permit_category",
+	COUNT(*) AS permits
+FROM {{.Target}}
+GROUP BY "community_area", COALESCE("zone_category", 'unzoned'), "industrial", "permit_category";
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// sqlFiles holds the report SQL scripts. Each script is a text/template whose placeholders are
+// table identifiers, which callers pass already quoted with quoteIdentifier, or report parameters
+// rendered as SQL literals.
+//
+//go:embed sql/*.sql
+var sqlFiles embed.FS
+
+var sqlTemplates = template.Must(
+	template.New("sql").Option("missingkey=error").ParseFS(sqlFiles, "sql/*.sql"),
+)
+
+// renderStatements executes the named embedded SQL script and splits it into individual statements
+// so that callers can run them one at a time and report the statement that failed.
+func renderStatements(name string, params map[string]string) ([]string, error) {
+	var buf bytes.Buffer
+	if err := sqlTemplates.ExecuteTemplate(&buf, name, params); err != nil {
+		return nil, fmt.Errorf("failed to render %s: %w", name, err)
+	}
+
+	return splitStatements(buf.String()), nil
+}
+
+// splitStatements splits a rendered script into its non-blank statements at the semicolons that end them.
+// Semicolons inside line and block comments, quoted strings and identifiers, and dollar-quoted bodies do not
+// end a statement.
+func splitStatements(script string) []string {
+	var (
+		statements []string
+		start      int
+		hasCode    bool
+	)
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			i = skipUntil(script, i+2, "\n") - 1
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			i = skipUntil(script, i+2, "*/") - 1
+		case c == '\'' || c == '"':
+			i = skipQuoted(script, i, c) - 1
+			hasCode = true
+		case c == '$':
+			if tag, ok := dollarQuoteTag(script[i:]); ok {
+				i = skipUntil(script, i+len(tag), tag) - 1
+			}
+			hasCode = true
+		case c == ';':
+			if hasCode {
+				statements = append(statements, strings.TrimSpace(script[start:i]))
+			}
+			start, hasCode = i+1, false
+		case !unicode.IsSpace(rune(c)):
+			hasCode = true
+		}
+	}
+	if hasCode {
+		statements = append(statements, strings.TrimSpace(script[start:]))
+	}
+	return statements
+}
+
+// skipUntil returns the index just past the first end in script at or after from, or len(script) when end
+// does not occur.
+func skipUntil(script string, from int, end string) int {
+	if n := strings.Index(script[from:], end); n >= 0 {
+		return from + n + len(end)
+	}
+	return len(script)
+}
+
+// skipQuoted returns the index just past the string or identifier quoted with quote that starts at from. A
+// doubled quote inside it is an escaped quote, not its end.
+func skipQuoted(script string, from int, quote byte) int {
+	for i := from + 1; i < len(script); i++ {
+		if script[i] != quote {
+			continue
+		}
+		if i+1 < len(script) && script[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(script)
+}
+
+// dollarQuoteTag returns the opening tag of the dollar-quoted string s starts with, such as "$$" or
+// "$body$", and whether s starts with one rather than a positional parameter such as "$1".
+func dollarQuoteTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1], true
+		case c == '_' || unicode.IsLetter(rune(c)) || (i > 1 && unicode.IsDigit(rune(c))):
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// isBlankSQL reports whether chunk contains nothing but whitespace and line comments.
+func isBlankSQL(chunk string) bool {
+	for _, line := range strings.Split(chunk, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template/parse"
+)
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- header; with a semicolon
+/* block; comment */
+CREATE TABLE "a;b" (note TEXT DEFAULT 'x;''y');
+-- stray; comment
+DO $body$ BEGIN PERFORM 1; END $body$;
+SELECT $1, $$;$$
+`
+	want := []string{
+		"-- header; with a semicolon\n/* block; comment */\nCREATE TABLE \"a;b\" (note TEXT DEFAULT 'x;''y')",
+		"-- stray; comment\nDO $body$ BEGIN PERFORM 1; END $body$",
+		"SELECT $1, $$;$$",
+	}
+	if got := splitStatements(script); !reflect.DeepEqual(got, want) {
+		t.Fatalf("splitStatements() = %q, want %q", got, want)
+	}
+}
+
+// sqlNoise matches the comments and quoted strings and identifiers of a statement, which the statement
+// checks below ignore.
+var sqlNoise = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/|'(?:[^']|'')*'|"(?:[^"]|"")*"`)
+
+// missingCTEComma matches a common table expression following the previous one without the comma between
+// them, e.g. ") holiday_days AS (".
+var missingCTEComma = regexp.MustCompile(`(?i)\)\s+[a-z_][a-z0-9_]*\s+AS\s+\(`)
+
+var statementKeywords = map[string]bool{
+	"ALTER": true, "ANALYZE": true, "COMMENT": true, "CREATE": true, "DELETE": true, "DROP": true, "INSERT": true,
+	"SELECT": true, "SET": true, "TRUNCATE": true, "UPDATE": true, "WITH": true,
+}
+
+// TestSQLTemplatesSplitIntoStatements renders every embedded script and checks that each statement it
+// splits into starts with a statement keyword and has balanced parentheses, so no comment or literal cut a
+// statement apart, and that no common table expression lacks its separating comma.
+func TestSQLTemplatesSplitIntoStatements(t *testing.T) {
+	files, err := fs.Glob(sqlFiles, "sql/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		name := path.Base(file)
+		t.Run(name, func(t *testing.T) {
+			params := map[string]string{}
+			collectTemplateFields(sqlTemplates.Lookup(name).Tree.Root, params)
+
+			raw, err := fs.ReadFile(sqlFiles, file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var statements []string
+			if strings.Contains(string(raw), stageMarker) {
+				stages, _, err := renderStages(name, params)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, stage := range stages {
+					statements = append(statements, stage.statements...)
+				}
+			} else if statements, err = renderStatements(name, params); err != nil {
+				t.Fatal(err)
+			}
+			if len(statements) == 0 {
+				t.Fatal("no statements")
+			}
+
+			for _, statement := range statements {
+				code := sqlNoise.ReplaceAllString(statement, " ")
+				fields := strings.Fields(code)
+				if len(fields) == 0 || !statementKeywords[strings.ToUpper(fields[0])] {
+					t.Errorf("chunk does not start with a statement:\n%s", statement)
+					continue
+				}
+				if strings.Count(code, "(") != strings.Count(code, ")") {
+					t.Errorf("chunk has unbalanced parentheses:\n%s", statement)
+				}
+				if match := missingCTEComma.FindString(code); match != "" {
+					t.Errorf("chunk is missing a comma before %q:\n%s", match, statement)
+				}
+			}
+		})
+	}
+}
+
+// collectTemplateFields sets a quoted identifier in params for every field the template under node reads.
+func collectTemplateFields(node parse.Node, params map[string]string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectTemplateFields(child, params)
+		}
+	case *parse.ActionNode:
+		collectTemplateFields(n.Pipe, params)
+	case *parse.IfNode:
+		collectTemplateFields(n.Pipe, params)
+		collectTemplateFields(n.List, params)
+		collectTemplateFields(n.ElseList, params)
+	case *parse.PipeNode:
+		for _, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				collectTemplateFields(arg, params)
+			}
+		}
+	case *parse.FieldNode:
+		params[n.Ident[0]] = quoteIdentifier(strings.ToLower(n.Ident[0]))
+	}
+}
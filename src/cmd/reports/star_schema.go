@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const (
+	dimZipTable           = "dim_zip"
+	dimCommunityAreaTable = "dim_community_area"
+	dimDateTable          = "dim_date"
+	factTripsWeeklyTable  = "fact_trips_weekly"
+	factPermitsTable      = "fact_permits"
+)
+
+// starSchemaReportSources maps each table built by CreateStarSchemaReport to the collector tables it reads.
+var starSchemaReportSources = map[string][]string{
+	dimZipTable:           {covidTable, taxiTripsTable, ccviTable},
+	dimCommunityAreaTable: {publichealthTable, ccviTable},
+	dimDateTable:          {covidTable, taxiTripsTable, buildingPermits},
+	factTripsWeeklyTable:  {covidTable, taxiTripsTable},
+	factPermitsTable:      {buildingPermits, publichealthTable},
+}
+
+// starSchemaReportAssertions are the invariants of the tables built by CreateStarSchemaReport.
+var starSchemaReportAssertions = []reportAssertion{
+	assertRowCount(dimCommunityAreaTable, communityAreaCount),
+	assertNonNegative(factTripsWeeklyTable, "trips"),
+	assertUnique(factPermitsTable, "permit_id"),
+}
+
+// CreateStarSchemaReport rebuilds the dimension and fact tables BI tools connect to: dim_zip,
+// dim_community_area, dim_date, fact_trips_weekly, and fact_permits. They are derived from the covid
+// category and disadvantaged report tables, so it runs after those reports. All five are rebuilt in one
+// transaction, so a dashboard never joins a new fact table to an old dimension.
+func CreateStarSchemaReport(db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	for _, table := range []string{covidRepCatsTable, covidAlertsTable, disadvantagedTable, disadvantagedPermitsTable, ccviTable} {
+		if err := ensureTableReady(db, table); err != nil {
+			return err
+		}
+	}
+
+	statements, err := renderStatements("star_schema.sql", map[string]string{
+		"DimZip":           quoteIdentifier(dimZipTable),
+		"DimCommunityArea": quoteIdentifier(dimCommunityAreaTable),
+		"DimDate":          quoteIdentifier(dimDateTable),
+		"FactTripsWeekly":  quoteIdentifier(factTripsWeeklyTable),
+		"FactPermits":      quoteIdentifier(factPermitsTable),
+		"CovidCategories":  quoteIdentifier(covidRepCatsTable),
+		"Alerts":           quoteIdentifier(covidAlertsTable),
+		"CCVI":             quoteIdentifier(ccviTable),
+		"Disadvantaged":    quoteIdentifier(disadvantagedTable),
+		"Permits":          quoteIdentifier(disadvantagedPermitsTable),
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start star schema report transaction: %w", err)
+	}
+
+	if err := execStatements(tx, "star_schema.sql", statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to commit star schema report transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	// statementTimingsTable records how long every statement of every report build took.
+	statementTimingsTable = "report_statement_timings"
+
+	// slowStatementEnvKey is the duration in milliseconds above which a report statement is logged as slow;
+	// 0 disables slow statement logging.
+	slowStatementEnvKey = "REPORT_SLOW_STATEMENT_MS"
+	// explainSlowEnvKey adds the EXPLAIN plan of each slow statement to its log line when set to true.
+	explainSlowEnvKey = "REPORT_EXPLAIN_SLOW"
+
+	defaultSlowStatementMs = 5000
+	// statementLabelLength caps the statement text kept with each timing.
+	statementLabelLength = 120
+)
+
+// statementTiming is how long one statement of a report SQL script took.
+type statementTiming struct {
+	script   string
+	index    int
+	label    string
+	duration time.Duration
+}
+
+// buildTimings collects the statement timings of the report being built. Builds are serialized by
+// reportRunMu, so a single collection is enough.
+var buildTimings struct {
+	sync.Mutex
+	timings []statementTiming
+}
+
+// takeStatementTimings returns the timings collected since the last call and starts a new collection.
+func takeStatementTimings() []statementTiming {
+	buildTimings.Lock()
+	defer buildTimings.Unlock()
+	timings := buildTimings.timings
+	buildTimings.timings = nil
+	return timings
+}
+
+// execStatements runs the statements rendered from script one at a time in tx, timing each one and logging
+// those slower than REPORT_SLOW_STATEMENT_MS. It stops at the first failure, which names the statement.
+func execStatements(tx *sql.Tx, script string, statements []string) error {
+	slow := slowStatementThreshold()
+	explain := strings.EqualFold(os.Getenv(explainSlowEnvKey), "true")
+
+	for i, statement := range statements {
+		started := time.Now()
+		_, err := tx.Exec(statement)
+		duration := time.Since(started)
+
+		buildTimings.Lock()
+		buildTimings.timings = append(buildTimings.timings, statementTiming{
+			script:   script,
+			index:    i + 1,
+			label:    statementLabel(statement),
+			duration: duration,
+		})
+		buildTimings.Unlock()
+
+		if err != nil {
+			return fmt.Errorf("failed to execute statement %q: %w", statement, err)
+		}
+		if slow > 0 && duration >= slow {
+			logSlowStatement(tx, script, i+1, statement, duration, explain)
+		}
+	}
+	return nil
+}
+
+func slowStatementThreshold() time.Duration {
+	raw := strings.TrimSpace(os.Getenv(slowStatementEnvKey))
+	if raw == "" {
+		return defaultSlowStatementMs * time.Millisecond
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		log.Printf("invalid %s value %q; defaulting to %d", slowStatementEnvKey, raw, defaultSlowStatementMs)
+		return defaultSlowStatementMs * time.Millisecond
+	}
+	return time.Duration(n) * time.Millisecond
+}
+
+// logSlowStatement logs a slow statement and, with explain, its plan. The plan is taken inside a savepoint,
+// so a statement Postgres cannot explain does not abort the report's transaction.
+func logSlowStatement(tx *sql.Tx, script string, index int, statement string, duration time.Duration, explain bool) {
+	log.Printf("slow statement %s #%d took %s: %s", script, index, duration.Round(time.Millisecond), statementLabel(statement))
+	if !explain || !explainable(statement) {
+		return
+	}
+
+	if _, err := tx.Exec(`SAVEPOINT explain_slow_statement`); err != nil {
+		log.Printf("failed to explain %s #%d: %v", script, index, err)
+		return
+	}
+	plan, err := explainStatement(tx, statement)
+	if err != nil {
+		tx.Exec(`ROLLBACK TO SAVEPOINT explain_slow_statement`)
+		log.Printf("failed to explain %s #%d: %v", script, index, err)
+		return
+	}
+	tx.Exec(`RELEASE SAVEPOINT explain_slow_statement`)
+	log.Printf("plan of %s #%d:\n%s", script, index, plan)
+}
+
+func explainStatement(tx *sql.Tx, statement string) (string, error) {
+	rows, err := tx.Query("EXPLAIN " + statement)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
+
+// explainable reports whether Postgres can EXPLAIN statement: queries, DML, and CREATE TABLE ... AS.
+func explainable(statement string) bool {
+	words := strings.Fields(statementLabel(statement))
+	if len(words) == 0 {
+		return false
+	}
+	switch strings.ToUpper(words[0]) {
+	case "SELECT", "WITH", "INSERT", "UPDATE", "DELETE":
+		return true
+	case "CREATE":
+		return strings.Contains(strings.ToUpper(statement), " AS")
+	}
+	return false
+}
+
+// statementLabel shortens statement to its first lines without comments, for logs and the timings table.
+func statementLabel(statement string) string {
+	var parts []string
+	for _, line := range strings.Split(statement, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+			parts = append(parts, line)
+		}
+	}
+	label := strings.Join(parts, " ")
+	if len(label) > statementLabelLength {
+		label = label[:statementLabelLength-3] + "..."
+	}
+	return label
+}
+
+// recordStatementTimings stores the statement timings of one build of report. Failures are logged rather
+// than returned so bookkeeping never fails a refresh.
+func recordStatementTimings(db *sql.DB, report string, timings []statementTiming) {
+	if len(timings) == 0 {
+		return
+	}
+
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+			"id" SERIAL PRIMARY KEY,
+			"report" VARCHAR(255) NOT NULL,
+			"script" VARCHAR(255) NOT NULL,
+			"statement_index" INTEGER NOT NULL,
+			"statement" TEXT NOT NULL,
+			"duration_ms" DOUBLE PRECISION NOT NULL,
+			"built_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+			"build_version" VARCHAR(255) NOT NULL
+		)`, statementTimingsTable),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %q ON %q ("built_at")`, statementTimingsTable+"_built_at_idx", statementTimingsTable),
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			log.Printf("failed to create %s: %v", statementTimingsTable, err)
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("failed to record statement timings of %s: %v", report, err)
+		return
+	}
+
+	builtAt := time.Now()
+	insertStmt := fmt.Sprintf(`INSERT INTO %q ("report", "script", "statement_index", "statement", "duration_ms", "built_at", "build_version")
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`, statementTimingsTable)
+	for _, timing := range timings {
+		durationMs := float64(timing.duration) / float64(time.Millisecond)
+		if _, err := tx.Exec(insertStmt, report, timing.script, timing.index, timing.label, durationMs, builtAt, shared.Version()); err != nil {
+			tx.Rollback()
+			log.Printf("failed to record statement timings of %s: %v", report, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to record statement timings of %s: %v", report, err)
+	}
+}
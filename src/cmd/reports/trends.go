@@ -0,0 +1,168 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultTrendWeeks = 12
+	maxTrendWeeks     = 104
+	// trendRollingPeriods is how many periods, the current one included, the rolling trip average spans.
+	trendRollingPeriods = 4
+)
+
+var zipCodePattern = regexp.MustCompile(`^[0-9]{5}$`)
+
+// trendGranularities maps the granularity query parameter to the SQL expression that buckets week_start.
+// A week counts toward the month it starts in.
+var trendGranularities = map[string]string{
+	"week":  `w."week_start"`,
+	"month": `DATE_TRUNC('month', w."week_start")::date`,
+}
+
+// tripTrend is the /api/trips/trends response.
+type tripTrend struct {
+	ZipCode      string        `json:"zip"`
+	Granularity  string        `json:"granularity"`
+	Weeks        int           `json:"weeks"`
+	CCVICategory *string       `json:"ccvi_category"`
+	CCVIScore    *float64      `json:"ccvi_score"`
+	Periods      []trendPeriod `json:"periods"`
+}
+
+// trendPeriod is one week or month of a tripTrend.
+type trendPeriod struct {
+	PeriodStart     string   `json:"period_start"`
+	Pickups         int64    `json:"pickups"`
+	Dropoffs        int64    `json:"dropoffs"`
+	Trips           int64    `json:"trips"`
+	TripsRollingAvg float64  `json:"trips_rolling_avg"`
+	CaseRate        *float64 `json:"case_rate_weekly"`
+	CovidCategory   *string  `json:"covid_cat"`
+}
+
+// tripTrendsHandler serves /api/trips/trends?zip=60614&granularity=week&weeks=12: pickups and dropoffs,
+// their rolling average, the weekly COVID case rate, and the CCVI category of one ZIP code over the last
+// weeks weeks of trip data, for the front end's drill-down charts. It reads the weekly trip tables and
+// covid_rep_cats built by the covid category report, which index them by ZIP code. Weeks without trips
+// are returned as zeros so charts have no gaps; with granularity=month the weeks are summed per month and
+// the case rate is averaged.
+func tripTrendsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		zip := params.Get("zip")
+		if !zipCodePattern.MatchString(zip) {
+			http.Error(w, "zip must be a five-digit ZIP code", http.StatusBadRequest)
+			return
+		}
+
+		granularity := params.Get("granularity")
+		if granularity == "" {
+			granularity = "week"
+		}
+		if _, ok := trendGranularities[granularity]; !ok {
+			http.Error(w, "granularity must be week or month", http.StatusBadRequest)
+			return
+		}
+
+		weeks := defaultTrendWeeks
+		if raw := params.Get("weeks"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxTrendWeeks {
+				http.Error(w, fmt.Sprintf("weeks must be between 1 and %d", maxTrendWeeks), http.StatusBadRequest)
+				return
+			}
+			weeks = n
+		}
+
+		trend, err := readTripTrend(db, zip, granularity, weeks)
+		if err != nil {
+			log.Printf("failed to read trip trends for %s: %v", zip, err)
+			http.Error(w, "trip trends are not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(trend); err != nil {
+			log.Printf("failed to write trip trends response: %v", err)
+		}
+	}
+}
+
+func readTripTrend(db *sql.DB, zip, granularity string, weeks int) (tripTrend, error) {
+	trend := tripTrend{ZipCode: zip, Granularity: granularity, Weeks: weeks, Periods: []trendPeriod{}}
+
+	err := db.QueryRow(fmt.Sprintf(`SELECT "ccvi_category", "ccvi_score" FROM %s
+		WHERE "geography_type" = 'ZIP' AND "community_area_or_zip" = $1
+		LIMIT 1`, quoteIdentifier(ccviTable)), zip).Scan(&trend.CCVICategory, &trend.CCVIScore)
+	if err != nil && err != sql.ErrNoRows {
+		return trend, fmt.Errorf("failed to query %s: %w", ccviTable, err)
+	}
+
+	// Averaged periods are bucketed with the thresholds the current covid_rep_cats was built with.
+	thresholds, err := latestCovidThresholds(db)
+	if err != nil {
+		return trend, err
+	}
+
+	// The window ends at the latest week of trip data rather than today, so it stays meaningful for the
+	// historical pulls the collectors load.
+	query := fmt.Sprintf(`WITH weeks AS (
+		SELECT generate_series(MAX("week_start") - ($2::int - 1) * 7, MAX("week_start"), INTERVAL '7 days')::date AS "week_start"
+		FROM %[1]s
+	),
+	periods AS (
+		SELECT %[4]s AS "period_start",
+			COALESCE(SUM(p."weekly_pickups"), 0)::bigint AS "pickups",
+			COALESCE(SUM(d."weekly_dropoffs"), 0)::bigint AS "dropoffs",
+			AVG(c."case_rate_weekly") AS "case_rate"
+		FROM weeks w
+		LEFT JOIN %[1]s p ON p."pickup_zip_code" = $1 AND p."week_start" = w."week_start"
+		LEFT JOIN %[2]s d ON d."dropoff_zip_code" = $1 AND d."week_start" = w."week_start"
+		LEFT JOIN %[3]s c ON c."zip_code" = $1 AND c."week_start" = w."week_start"
+		GROUP BY 1
+	)
+	SELECT "period_start", "pickups", "dropoffs",
+		AVG("pickups" + "dropoffs") OVER (ORDER BY "period_start" ROWS BETWEEN %[5]d PRECEDING AND CURRENT ROW),
+		"case_rate",
+		CASE
+			WHEN "case_rate" < $3 THEN 'low'
+			WHEN "case_rate" < $4 THEN 'medium'
+			WHEN "case_rate" >= $4 THEN 'high'
+		END
+	FROM periods
+	ORDER BY "period_start"`,
+		quoteIdentifier(weeklyPickupTable), quoteIdentifier(weeklyDropoffTable), quoteIdentifier(covidRepCatsTable),
+		trendGranularities[granularity], trendRollingPeriods-1)
+
+	rows, err := db.Query(query, zip, weeks, thresholds.Medium, thresholds.High)
+	if err != nil {
+		return trend, fmt.Errorf("failed to query weekly trips: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			period      trendPeriod
+			periodStart time.Time
+		)
+		if err := rows.Scan(&periodStart, &period.Pickups, &period.Dropoffs, &period.TripsRollingAvg, &period.CaseRate, &period.CovidCategory); err != nil {
+			return trend, fmt.Errorf("failed to scan trip trend row: %w", err)
+		}
+		period.PeriodStart = periodStart.Format("2006-01-02")
+		period.Trips = period.Pickups + period.Dropoffs
+		trend.Periods = append(trend.Periods, period)
+	}
+	if err := rows.Err(); err != nil {
+		return trend, fmt.Errorf("error while reading trip trends: %w", err)
+	}
+	return trend, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const tripFaresTable = "trip_fares_by_zip"
+
+// tripFaresReportSources maps the table built by CreateTripFaresReport to the collector tables it reads.
+var tripFaresReportSources = map[string][]string{
+	tripFaresTable: {taxiTripsTable},
+}
+
+// tripFaresReportAssertions are the invariants of the table built by CreateTripFaresReport.
+var tripFaresReportAssertions = []reportAssertion{
+	assertNotNull(tripFaresTable, "pickup_zip_code"),
+	assertNotNull(tripFaresTable, "week_start"),
+	assertNonNegative(tripFaresTable, "trips"),
+	assertNonNegative(tripFaresTable, "median_fare"),
+	assertNonNegative(tripFaresTable, "revenue"),
+}
+
+// CreateTripFaresReport rebuilds trip_fares_by_zip, the median fare, tip percentage, and revenue of the trips
+// picked up in each ZIP code per week and trip type, leaving out fares outside the 1st to 99th percentile of
+// their week and trip type.
+func CreateTripFaresReport(db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if err := ensureTableReady(db, taxiTripsTable); err != nil {
+		return err
+	}
+
+	statements, err := renderStatements("trip_fares_report.sql", map[string]string{
+		"Target": quoteIdentifier(tripFaresTable),
+		"Trips":  quoteIdentifier(taxiTripsTable),
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start trip fares report transaction: %w", err)
+	}
+
+	if err := execStatements(tx, "trip_fares_report.sql", statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to commit trip fares report transaction: %w", err)
+	}
+
+	return nil
+}
@@ -3,22 +3,64 @@
 import (
 	"database/sql"
 	"fmt"
+	"log"
+	"strconv"
 )
 
 const (
-	covidRepCatsTable    = "covid_rep_cats"
-	covidAlertsTable     = "req_1a_covid_alerts_drivers"
-	covidAlertsResidents = "req_1b_covid_alerts_residents"
-	reqAirportTripsTable = "req_2_airport_trips"
-	CCVITable            = "req_3_ccvi_trips"
-	dailyTripsTable      = "req_4_daily_trips"
-	weeklyTripsTable     = "req_4_weekly_trips"
-	monthlyTripsTable    = "req_4_monthly_trips"
-	weeklyPickupTable    = "weekly_trips_by_pickup_and_zip"
-	weeklyDropoffTable   = "weekly_trips_by_dropoff_and_zip"
+	covidRepCatsTable     = "covid_rep_cats"
+	covidAlertsTable      = "req_1a_covid_alerts_drivers"
+	covidAlertsResidents  = "req_1b_covid_alerts_residents"
+	reqAirportTripsTable  = "req_2_airport_trips"
+	airportAnomaliesTable = "airport_trips_anomalies"
+	CCVITable             = "req_3_ccvi_trips"
+	dailyTripsTable       = "req_4_daily_trips"
+	weeklyTripsTable      = "req_4_weekly_trips"
+	monthlyTripsTable     = "req_4_monthly_trips"
+	weeklyPickupTable     = "weekly_trips_by_pickup_and_zip"
+	weeklyDropoffTable    = "weekly_trips_by_dropoff_and_zip"
+
+	// tripForecastHorizonEnvKey is how many days, weeks, and months after the last one pulled the req_4
+	// trip tables forecast.
+	tripForecastHorizonEnvKey  = "TRIP_FORECAST_HORIZON"
+	defaultTripForecastHorizon = 1
+	// maxTripForecastHorizon keeps a mistyped horizon from multiplying the forecast tables' rows.
+	maxTripForecastHorizon = 366
 )
 
-// CreateCovidCategoryReport builds covid_rep_cats with covid_cat buckets based on case_rate_weekly.
+// covidReportSources maps each table built by CreateCovidCategoryReport to the collector tables it reads.
+var covidReportSources = map[string][]string{
+	covidRepCatsTable:     {covidTable},
+	covidAlertsTable:      {covidTable, taxiTripsTable},
+	covidAlertsResidents:  {covidTable, taxiTripsTable},
+	reqAirportTripsTable:  {covidTable, taxiTripsTable},
+	airportAnomaliesTable: {covidTable, taxiTripsTable},
+	CCVITable:             {ccviTable, taxiTripsTable},
+	dailyTripsTable:       {taxiTripsTable},
+	weeklyTripsTable:      {taxiTripsTable},
+	monthlyTripsTable:     {taxiTripsTable},
+}
+
+// covidReportAssertions are the invariants of the tables built by CreateCovidCategoryReport.
+var covidReportAssertions = []reportAssertion{
+	assertNotNull(covidRepCatsTable, "zip_code"),
+	assertNotNull(covidRepCatsTable, "week_start"),
+	assertAcceptedValues(covidRepCatsTable, "covid_cat", "low", "medium", "high"),
+	assertNonNegative(weeklyPickupTable, "weekly_pickups"),
+	assertNonNegative(weeklyDropoffTable, "weekly_dropoffs"),
+	assertNonNegative(reqAirportTripsTable, "trips_to_airport"),
+	assertNonNegative(reqAirportTripsTable, "trips_from_airport"),
+	assertAcceptedValues(airportAnomaliesTable, "direction", "to_airport", "from_airport"),
+	assertAcceptedValues(airportAnomaliesTable, "baseline_method", "same_week_last_year", "trailing_8_weeks"),
+	assertNotNull(dailyTripsTable, "horizon"),
+	assertNotNull(weeklyTripsTable, "horizon"),
+	assertNotNull(monthlyTripsTable, "horizon"),
+}
+
+// CreateCovidCategoryReport builds covid_rep_cats with covid_cat buckets based on case_rate_weekly, using the
+// thresholds from loadCovidThresholds, and the trip reports that depend on it. The script runs in stages,
+// each committed separately, so a build that fails resumes from the failed stage as long as the thresholds
+// and source tables are unchanged.
 func CreateCovidCategoryReport(db *sql.DB) error {
 	if db == nil {
 		return fmt.Errorf("db connection is nil")
@@ -36,198 +78,75 @@ func CreateCovidCategoryReport(db *sql.DB) error {
 		return err
 	}
 
-	tx, err := db.Begin()
-	if err != nil {
-		return fmt.Errorf("failed to start covid category report transaction: %w", err)
+	if err := ensureHolidaysTable(db); err != nil {
+		return err
 	}
 
-	sourceIdent := quoteIdentifier(covidTable)
-	targetIdent := quoteIdentifier(covidRepCatsTable)
-	alertsIdent := quoteIdentifier(covidAlertsTable)
-	alertsResidentsIdent := quoteIdentifier(covidAlertsResidents)
-	reqAirportTripsIdent := quoteIdentifier(reqAirportTripsTable)
-	reqAirportTripsSortedIdent := quoteIdentifier(reqAirportTripsTable + "_sorted")
-	ccviIdent := quoteIdentifier(ccviTable)
-	CCVIIdent := quoteIdentifier(CCVITable)
-	CCVISortedIdent := quoteIdentifier(CCVITable + "_sorted")
-	dailyIdent := quoteIdentifier(dailyTripsTable)
-	weeklyIdent := quoteIdentifier(weeklyTripsTable)
-	monthlyIdent := quoteIdentifier(monthlyTripsTable)
-	weeklyPickupIdent := quoteIdentifier(weeklyPickupTable)
-	weeklyDropoffIdent := quoteIdentifier(weeklyDropoffTable)
-	tripsIdent := quoteIdentifier(taxiTripsTable)
-
-	statements := []string{
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, targetIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS TABLE %s`, targetIdent, sourceIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN covid_cat VARCHAR(6)`, targetIdent),
-		fmt.Sprintf(`UPDATE %s
-			SET covid_cat = CASE
-				WHEN "case_rate_weekly" < 50 THEN 'low'
-				WHEN "case_rate_weekly" >= 50 AND "case_rate_weekly" < 100 THEN 'medium'
-				WHEN "case_rate_weekly" >= 100 THEN 'high'
-			END`, targetIdent),
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, alertsIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS TABLE %s`, alertsIdent, tripsIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN airport_dropoff BOOLEAN DEFAULT false`, alertsIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN airport_pickup BOOLEAN DEFAULT false`, alertsIdent),
-		fmt.Sprintf(`UPDATE %s
-			SET airport_dropoff = true
-			WHERE "dropoff_zip_code" IN ('60666', '60656', '60665', '60638')`, alertsIdent),
-		fmt.Sprintf(`UPDATE %s
-			SET airport_pickup = true
-			WHERE "pickup_zip_code" IN ('60666', '60656', '60665', '60638')`, alertsIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN day DATE`, alertsIdent),
-		fmt.Sprintf(`UPDATE %s SET day = "trip_start_timestamp"::date`, alertsIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN week_start DATE`, alertsIdent),
-		fmt.Sprintf(`UPDATE %s SET week_start = (DATE_TRUNC('week', "trip_start_timestamp") - INTERVAL '1 day')::date`, alertsIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN month_start DATE`, alertsIdent),
-		fmt.Sprintf(`UPDATE %s SET month_start = DATE_TRUNC('month', "trip_start_timestamp")::date`, alertsIdent),
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, reqAirportTripsIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS TABLE %s`, reqAirportTripsIdent, targetIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN trips_to_airport INTEGER DEFAULT 0`, reqAirportTripsIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN trips_from_airport INTEGER DEFAULT 0`, reqAirportTripsIdent),
-		fmt.Sprintf(`UPDATE %s cat
-			SET trips_to_airport = airport_counts.trips_to_airport
-			FROM (
-				SELECT "pickup_zip_code" AS zip_code, week_start, COUNT(*) AS trips_to_airport
-				FROM %s
-				WHERE airport_dropoff = true
-				GROUP BY "pickup_zip_code", week_start
-			) AS airport_counts
-			WHERE cat."zip_code" = airport_counts.zip_code
-				AND cat."week_start" = airport_counts.week_start`, reqAirportTripsIdent, alertsIdent),
-		fmt.Sprintf(`UPDATE %s cat
-			SET trips_from_airport = airport_counts.trips_from_airport
-			FROM (
-				SELECT "dropoff_zip_code" AS zip_code, week_start, COUNT(*) AS trips_from_airport
-				FROM %s
-				WHERE airport_pickup = true
-				GROUP BY "dropoff_zip_code", week_start
-			) AS airport_counts
-			WHERE cat."zip_code" = airport_counts.zip_code
-				AND cat."week_start" = airport_counts.week_start`, reqAirportTripsIdent, alertsIdent),
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, reqAirportTripsSortedIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS
-			SELECT *
-			FROM %s
-			ORDER BY "zip_code", "week_start"`, reqAirportTripsSortedIdent, reqAirportTripsIdent),
-		fmt.Sprintf(`DROP TABLE %s`, reqAirportTripsIdent),
-		fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, reqAirportTripsSortedIdent, reqAirportTripsIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN pickup_covid_cat VARCHAR(6)`, alertsIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN dropoff_covid_cat VARCHAR(6)`, alertsIdent),
-		fmt.Sprintf(`UPDATE %s t
-			SET pickup_covid_cat = c.covid_cat
-			FROM %s c
-			WHERE t."pickup_zip_code" = c."zip_code"
-				AND t."week_start" = c."week_start"`, alertsIdent, targetIdent),
-		fmt.Sprintf(`UPDATE %s t
-			SET dropoff_covid_cat = c.covid_cat
-			FROM %s c
-			WHERE t."dropoff_zip_code" = c."zip_code"
-				AND t."week_start" = c."week_start"`, alertsIdent, targetIdent),
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, weeklyPickupIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS
-			SELECT week_start, "pickup_zip_code", COUNT(*) AS weekly_pickups
-			FROM %s
-			GROUP BY week_start, "pickup_zip_code"`, weeklyPickupIdent, alertsIdent),
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, weeklyDropoffIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS
-			SELECT week_start, "dropoff_zip_code", COUNT(*) AS weekly_dropoffs
-			FROM %s
-			GROUP BY week_start, "dropoff_zip_code"`, weeklyDropoffIdent, alertsIdent),
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, alertsResidentsIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS TABLE %s`, alertsResidentsIdent, targetIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN weekly_dropoffs INTEGER DEFAULT 0`, alertsResidentsIdent),
-		fmt.Sprintf(`UPDATE %s r
-			SET weekly_dropoffs = wd.weekly_dropoffs
-			FROM %s wd
-			WHERE r."zip_code" = wd."dropoff_zip_code"
-				AND r."week_start" = wd."week_start"`, alertsResidentsIdent, weeklyDropoffIdent),
-		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN weekly_pickups INTEGER DEFAULT 0`, alertsResidentsIdent),
-		fmt.Sprintf(`UPDATE %s r
-			SET weekly_pickups = wp.weekly_pickups
-			FROM %s wp
-			WHERE r."zip_code" = wp."pickup_zip_code"
-				AND r."week_start" = wp."week_start"`, alertsResidentsIdent, weeklyPickupIdent),
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, dailyIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS
-			WITH daily_counts AS (
-				SELECT "dropoff_zip_code", day, COUNT(*) AS trips_per_day
-				FROM %s
-				GROUP BY "dropoff_zip_code", day
-			),
-			next_day AS (
-				SELECT (MAX(day) + INTERVAL '1 day')::date AS day_value FROM %s
-			)
-			SELECT dc."dropoff_zip_code" AS zip_code, nd.day_value AS day, AVG(dc.trips_per_day) AS trips
-			FROM daily_counts dc
-			CROSS JOIN next_day nd
-			GROUP BY dc."dropoff_zip_code", nd.day_value`, dailyIdent, alertsIdent, alertsIdent),
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, weeklyIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS
-			WITH weekly_counts AS (
-				SELECT "dropoff_zip_code", week_start, COUNT(*) AS trips_per_week
-				FROM %s
-				GROUP BY "dropoff_zip_code", week_start
-			),
-			next_week AS (
-				SELECT (MAX(week_start) + INTERVAL '1 week')::date AS week_value FROM %s
-			)
-			SELECT wc."dropoff_zip_code" AS zip_code, nw.week_value AS week_start, AVG(wc.trips_per_week) AS trips
-			FROM weekly_counts wc
-			CROSS JOIN next_week nw
-			GROUP BY wc."dropoff_zip_code", nw.week_value`, weeklyIdent, alertsIdent, alertsIdent),
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, CCVIIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS
-			WITH weekly_trips AS (
-				SELECT week_start, "pickup_zip_code" AS zip_code, COUNT(*) AS trips
-				FROM %s
-				GROUP BY week_start, "pickup_zip_code"
-				UNION ALL
-				SELECT week_start, "dropoff_zip_code" AS zip_code, COUNT(*) AS trips
-				FROM %s
-				GROUP BY week_start, "dropoff_zip_code"
-			)
-			SELECT c.*, wt.week_start, SUM(wt.trips) AS weekly_trips
-			FROM %s c
-			JOIN weekly_trips wt ON wt.zip_code = c."community_area_or_zip"
-			WHERE c."ccvi_category" = 'HIGH'
-				AND c."geography_type" = 'ZIP'
-			GROUP BY c."id", c."geography_type", c."community_area_or_zip", c."community_area_name", c."ccvi_score", c."ccvi_category", wt.week_start`, CCVIIdent, alertsIdent, alertsIdent, ccviIdent),
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, CCVISortedIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS
-			SELECT *
-			FROM %s
-			ORDER BY "community_area_or_zip", "week_start"`, CCVISortedIdent, CCVIIdent),
-		fmt.Sprintf(`DROP TABLE %s`, CCVIIdent),
-		fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, CCVISortedIdent, CCVIIdent),
-		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, monthlyIdent),
-		fmt.Sprintf(`CREATE TABLE %s AS
-			WITH monthly_counts AS (
-				SELECT "dropoff_zip_code", month_start, COUNT(*) AS trips_per_month
-				FROM %s
-				GROUP BY "dropoff_zip_code", month_start
-			),
-			next_month AS (
-				SELECT (MAX(month_start) + INTERVAL '1 month')::date AS month_value FROM %s
-			)
-			SELECT mc."dropoff_zip_code" AS zip_code, nm.month_value AS month_start, AVG(mc.trips_per_month) AS trips
-			FROM monthly_counts mc
-			CROSS JOIN next_month nm
-			GROUP BY mc."dropoff_zip_code", nm.month_value`, monthlyIdent, alertsIdent, alertsIdent),
+	if err := ensureReportParametersTable(db); err != nil {
+		return err
+	}
+	thresholds, err := loadCovidThresholds(reportParameters(db))
+	if err != nil {
+		return err
+	}
+	params, err := thresholds.templateParams()
+	if err != nil {
+		return err
+	}
+	log.Printf("bucketing covid_cat with %s medium >= %g, high >= %g and positivity_cat with %s medium >= %g, high >= %g",
+		thresholds.Metric, thresholds.Medium, thresholds.High,
+		thresholds.PercentPositive.Metric, thresholds.PercentPositive.Medium, thresholds.PercentPositive.High)
+	horizon, err := loadTripForecastHorizon(reportParameters(db))
+	if err != nil {
+		return err
 	}
+	params["ForecastHorizon"] = strconv.Itoa(horizon)
 
-	for _, stmt := range statements {
-		if _, execErr := tx.Exec(stmt); execErr != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to execute statement %q: %w", stmt, execErr)
-		}
+	for name, value := range map[string]string{
+		"Covid":              quoteIdentifier(covidTable),
+		"Trips":              quoteIdentifier(taxiTripsTable),
+		"CCVI":               quoteIdentifier(ccviTable),
+		"Target":             quoteIdentifier(covidRepCatsTable),
+		"Alerts":             quoteIdentifier(covidAlertsTable),
+		"AlertsResidents":    quoteIdentifier(covidAlertsResidents),
+		"AirportTrips":       quoteIdentifier(reqAirportTripsTable),
+		"AirportTripsSorted": quoteIdentifier(reqAirportTripsTable + "_sorted"),
+		"AirportAnomalies":   quoteIdentifier(airportAnomaliesTable),
+		"CCVIReport":         quoteIdentifier(CCVITable),
+		"CCVIReportSorted":   quoteIdentifier(CCVITable + "_sorted"),
+		"Daily":              quoteIdentifier(dailyTripsTable),
+		"Weekly":             quoteIdentifier(weeklyTripsTable),
+		"Monthly":            quoteIdentifier(monthlyTripsTable),
+		"WeeklyPickup":       quoteIdentifier(weeklyPickupTable),
+		"WeeklyDropoff":      quoteIdentifier(weeklyDropoffTable),
+		"Holidays":           quoteIdentifier(holidaysTable),
+	} {
+		params[name] = value
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit covid category report transaction: %w", err)
+	stages, rendered, err := renderStages("covid_category_report.sql", params)
+	if err != nil {
+		return err
+	}
+	fingerprint, err := stageFingerprint(db, rendered, covidTable, taxiTripsTable, ccviTable)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return runStages(db, "covid_category", "covid_category_report.sql", fingerprint, stages)
+}
+
+// loadTripForecastHorizon reads how many periods the req_4 trip tables forecast through lookup, usually
+// reportParameters. A horizon beyond maxTripForecastHorizon falls back to the default.
+func loadTripForecastHorizon(lookup func(name string) (string, error)) (int, error) {
+	horizon, err := intReportParameter(lookup, tripForecastHorizonEnvKey, defaultTripForecastHorizon)
+	if err != nil {
+		return 0, err
+	}
+	if horizon > maxTripForecastHorizon {
+		log.Printf("%s (%d) must be at most %d; defaulting to %d", tripForecastHorizonEnvKey, horizon,
+			maxTripForecastHorizon, defaultTripForecastHorizon)
+		return defaultTripForecastHorizon, nil
+	}
+	return horizon, nil
 }
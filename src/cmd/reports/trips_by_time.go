@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const tripsByTimeTable = "trips_by_time_of_day"
+
+// tripsByTimeReportSources maps the table built by CreateTripsByTimeReport to the collector tables it reads.
+var tripsByTimeReportSources = map[string][]string{
+	tripsByTimeTable: {covidTable, taxiTripsTable},
+}
+
+// tripsByTimeReportAssertions are the invariants of the table built by CreateTripsByTimeReport.
+var tripsByTimeReportAssertions = []reportAssertion{
+	assertNotNull(tripsByTimeTable, "pickup_zip_code"),
+	assertNonNegative(tripsByTimeTable, "trips"),
+}
+
+// CreateTripsByTimeReport rebuilds trips_by_time_of_day, the trips per pickup ZIP code, hour of day, day of
+// week, and pickup COVID category. It reads the covid alerts table, so it runs after the covid category report.
+func CreateTripsByTimeReport(db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if err := ensureTableReady(db, covidAlertsTable); err != nil {
+		return err
+	}
+
+	statements, err := renderStatements("trips_by_time_report.sql", map[string]string{
+		"Target": quoteIdentifier(tripsByTimeTable),
+		"Alerts": quoteIdentifier(covidAlertsTable),
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start trips by time report transaction: %w", err)
+	}
+
+	if err := execStatements(tx, "trips_by_time_report.sql", statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to commit trips by time report transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+)
+
+const (
+	weatherTable           = "weather_daily"
+	dailyTripsWeatherTable = "daily_trips_weather"
+)
+
+// dailyTripsWeatherReportSources maps the table built by CreateDailyTripsWeatherReport to the collector
+// tables it reads.
+var dailyTripsWeatherReportSources = map[string][]string{
+	dailyTripsWeatherTable: {taxiTripsTable, weatherTable},
+}
+
+// dailyTripsWeatherReportAssertions are the invariants of the table built by CreateDailyTripsWeatherReport.
+var dailyTripsWeatherReportAssertions = []reportAssertion{
+	assertNonNegative(dailyTripsWeatherTable, "trips"),
+}
+
+// CreateDailyTripsWeatherReport rebuilds daily_trips_weather, the trips per dropoff ZIP code and day joined
+// with that day's weather from the weather collector and its holidays. It reads the covid alerts table, so it
+// runs after the covid category report. weather_daily holds one station, so every ZIP code shares the day's
+// weather. Trips per 1,000 residents use the ZIP code's ACS population.
+func CreateDailyTripsWeatherReport(db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if err := ensureTableReady(db, covidAlertsTable); err != nil {
+		return err
+	}
+	if err := ensureTableReady(db, weatherTable); err != nil {
+		return err
+	}
+
+	if err := ensureHolidaysTable(db); err != nil {
+		return err
+	}
+
+	statements, err := renderStatements("daily_trips_weather_report.sql", map[string]string{
+		"Target":   quoteIdentifier(dailyTripsWeatherTable),
+		"Alerts":   quoteIdentifier(covidAlertsTable),
+		"Weather":  quoteIdentifier(weatherTable),
+		"Holidays": quoteIdentifier(holidaysTable),
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start daily trips weather report transaction: %w", err)
+	}
+
+	if err := execStatements(tx, "daily_trips_weather_report.sql", statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := addPerCapitaColumns(tx, dailyTripsWeatherTable, datasets.PopulationZipCode, "zip_code", "trips"); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to commit daily trips weather report transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ahbreck/Chicago_BI/datasets"
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	zoningDistrictsTable     = "zoning_districts"
+	permitZoningTable        = "permit_zoning"
+	permitZoningSummaryTable = "permit_zoning_summary"
+)
+
+// zoningReportSources maps the tables built by CreateZoningReport to the collector tables they read. The
+// zoning districts come from a spatial dataset rather than a collector, so they are not listed.
+var zoningReportSources = map[string][]string{
+	permitZoningTable:        {buildingPermits},
+	permitZoningSummaryTable: {buildingPermits},
+}
+
+// CreateZoningReport loads the zoning districts spatial dataset into PostGIS and rebuilds permit_zoning,
+// the zoning class each building permit falls in, and permit_zoning_summary, its counts per community area,
+// for the industrial-area analysis of permits in planned manufacturing districts and industrial corridors.
+// The summary also gives permits per 1,000 residents of the community area.
+func CreateZoningReport(db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("db connection is nil")
+	}
+
+	if err := ensureTableReady(db, buildingPermits); err != nil {
+		return err
+	}
+
+	paths, err := shared.EnsureSpatialDatasets(context.Background(), shared.ZoningDistrictsDataset)
+	if err != nil {
+		return err
+	}
+	raw, err := os.ReadFile(paths[shared.ZoningDistrictsDataset.Name])
+	if err != nil {
+		return fmt.Errorf("failed to read zoning districts: %w", err)
+	}
+	var districts geoJSONFeatureCollection
+	if err := json.Unmarshal(raw, &districts); err != nil {
+		return fmt.Errorf("failed to parse zoning districts: %w", err)
+	}
+
+	statements, err := renderStatements("zoning_report.sql", map[string]string{
+		"Target":    quoteIdentifier(permitZoningTable),
+		"Summary":   quoteIdentifier(permitZoningSummaryTable),
+		"Permits":   quoteIdentifier(buildingPermits),
+		"Districts": quoteIdentifier(zoningDistrictsTable),
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start zoning report transaction: %w", err)
+	}
+
+	if err := shared.UsePostGIS(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := loadZoningDistricts(tx, districts.Features); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := execStatements(tx, "zoning_report.sql", statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := addPerCapitaColumns(tx, permitZoningSummaryTable, datasets.PopulationCommunityArea, "community_area", "permits"); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to commit zoning report transaction: %w", err)
+	}
+
+	return nil
+}
+
+// loadZoningDistricts replaces zoning_districts with features. Features without a geometry or zone class
+// are skipped.
+func loadZoningDistricts(tx *sql.Tx, features []geoJSONFeature) error {
+	districts := quoteIdentifier(zoningDistrictsTable)
+	createStmts := []string{
+		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, districts),
+		fmt.Sprintf(`CREATE TABLE %s (
+			"zone_class" VARCHAR(32) NOT NULL,
+			"pd_num" VARCHAR(32),
+			"geom" geometry(MultiPolygon, 4326) NOT NULL
+		)`, districts),
+	}
+	for _, statement := range createStmts {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to create %s: %w", zoningDistrictsTable, err)
+		}
+	}
+
+	insert, err := tx.Prepare(fmt.Sprintf(`INSERT INTO %s ("zone_class", "pd_num", "geom")
+		VALUES ($1, NULLIF($2, ''), ST_Multi(ST_SetSRID(ST_GeomFromGeoJSON($3), 4326)))`, districts))
+	if err != nil {
+		return fmt.Errorf("failed to prepare %s insert: %w", zoningDistrictsTable, err)
+	}
+	defer insert.Close()
+
+	for _, feature := range features {
+		zoneClass, _ := feature.Properties["zone_class"].(string)
+		if zoneClass == "" || len(feature.Geometry) == 0 || string(feature.Geometry) == "null" {
+			continue
+		}
+		pdNum, _ := feature.Properties["pd_num"].(string)
+		if _, err := insert.Exec(zoneClass, pdNum, string(feature.Geometry)); err != nil {
+			return fmt.Errorf("failed to insert zoning district %s: %w", zoneClass, err)
+		}
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`CREATE INDEX ON %s USING GIST ("geom")`, districts)); err != nil {
+		return fmt.Errorf("failed to index %s: %w", zoningDistrictsTable, err)
+	}
+	return nil
+}
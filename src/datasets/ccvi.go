@@ -0,0 +1,109 @@
+package datasets
+
+import (
+	"context"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+type CCVIRecord struct {
+	Geography_type        string  `json:"geography_type" parquet:"geography_type"`
+	Community_area_or_zip string  `json:"community_area_or_zip" parquet:"community_area_or_zip"`
+	Community_area_name   string  `json:"community_area_name" parquet:"community_area_name"`
+	CCVI_score            float64 `json:"ccvi_score,string" parquet:"ccvi_score"`
+	CCVI_category         string  `json:"ccvi_category" parquet:"ccvi_category"`
+}
+
+type CCVIRecords []CCVIRecord
+
+var CCVISource = shared.SODASource{
+	Name:   "ccvi",
+	ID:     "xhc6-88s9",
+	Record: CCVIRecord{},
+	Select: []string{"geography_type", "community_area_or_zip", "community_area_name", "ccvi_score", "ccvi_category"},
+}
+
+var CCVIDataset = shared.Dataset{
+	Table: "ccvi",
+	CreateSQL: `CREATE TABLE IF NOT EXISTS "ccvi" (
+    "id" SERIAL PRIMARY KEY,
+    "geography_type" VARCHAR(3),
+    "community_area_or_zip" VARCHAR(9) UNIQUE,
+    "community_area_name" VARCHAR(255),
+    "ccvi_score" FLOAT8,
+    "ccvi_category" VARCHAR(6),
+    "ingest_run_id" VARCHAR(32)
+);`,
+	InsertSQL: `INSERT INTO ccvi ("geography_type", "community_area_or_zip", "community_area_name", "ccvi_score", "ccvi_category", "ingest_run_id")
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT ("community_area_or_zip") DO UPDATE
+			SET geography_type = EXCLUDED.geography_type,
+				community_area_name = EXCLUDED.community_area_name,
+				ccvi_score = EXCLUDED.ccvi_score,
+				ccvi_category = EXCLUDED.ccvi_category,
+				ingest_run_id = EXCLUDED.ingest_run_id;`,
+	Columns: []shared.Column{
+		{Name: "geography_type", Type: shared.ColumnString},
+		{Name: "community_area_or_zip", Type: shared.ColumnString},
+		{Name: "community_area_name", Type: shared.ColumnString},
+		{Name: "ccvi_score", Type: shared.ColumnFloat},
+		{Name: "ccvi_category", Type: shared.ColumnString},
+		{Name: shared.IngestRunIDColumn, Type: shared.ColumnString},
+	},
+	DiffKey:     []string{"community_area_or_zip"},
+	DiffColumns: []string{"geography_type", "community_area_name", "ccvi_score", "ccvi_category"},
+	RecordBytes: 768,
+}
+
+// CCVIArea is the COVID Community Vulnerability Index of a community area or ZIP code.
+type CCVIArea struct {
+	GeographyType      string
+	CommunityAreaOrZIP string
+	CommunityAreaName  string
+	Score              float64
+	Category           string
+}
+
+// CCVIAreaFromDTO converts a SODA CCVI record, failing when its geography or category is missing or its
+// score is negative.
+func CCVIAreaFromDTO(record CCVIRecord) (CCVIArea, error) {
+	var p fieldParser
+	area := CCVIArea{
+		GeographyType:      p.required("geography_type", record.Geography_type),
+		CommunityAreaOrZIP: p.required("community_area_or_zip", record.Community_area_or_zip),
+		CommunityAreaName:  record.Community_area_name,
+		Score:              p.nonNegative("ccvi_score", record.CCVI_score),
+		Category:           p.required("ccvi_category", record.CCVI_category),
+	}
+	return area, p.err()
+}
+
+// LoadCCVI writes the CCVI records that convert to a CCVIArea to store and flushes it.
+func LoadCCVI(ctx context.Context, store shared.Store, ccvi_data_list CCVIRecords) (insertedCount, skippedCount int, err error) {
+	skipped := skipTally{}
+	defer skipped.report(CCVIDataset.Table)
+
+	for _, record := range ccvi_data_list {
+		area, convErr := CCVIAreaFromDTO(record)
+		if convErr != nil {
+			skipped.add(convErr)
+			skippedCount++
+			continue
+		}
+
+		err = store.Insert(ctx, CCVIDataset,
+			area.GeographyType,
+			area.CommunityAreaOrZIP,
+			area.CommunityAreaName,
+			area.Score,
+			area.Category,
+		)
+
+		if err != nil {
+			return insertedCount, skippedCount, err
+		}
+		insertedCount++
+	}
+
+	return insertedCount, skippedCount, store.Flush(ctx, CCVIDataset)
+}
@@ -0,0 +1,270 @@
+package datasets
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kelvins/geocoder"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// sodaTimestampLayout is how the domain types write timestamps back out: SODA's floating timestamp format,
+// local Chicago time without a zone.
+const sodaTimestampLayout = "2006-01-02T15:04:05.000"
+
+// sodaTimeLayouts are the date and timestamp formats SODA and NCEI use, with and without the time part.
+var sodaTimeLayouts = []string{sodaTimestampLayout, "2006-01-02T15:04:05", "2006-01-02"}
+
+// ValidationError is a DTO field that could not be converted into its domain type.
+type ValidationError struct {
+	Field   string
+	Value   string
+	Problem string
+}
+
+func (e ValidationError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("%s %s", e.Field, e.Problem)
+	}
+	return fmt.Sprintf("%s %q %s", e.Field, e.Value, e.Problem)
+}
+
+// ValidationErrors lists every field of one DTO that could not be converted. It is in the
+// shared.ErrValidation category.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Is(target error) bool {
+	return target == shared.ErrValidation
+}
+
+func (e ValidationErrors) Error() string {
+	problems := make([]string, len(e))
+	for i, err := range e {
+		problems[i] = err.Error()
+	}
+	return strings.Join(problems, "; ")
+}
+
+// fieldParser converts the string fields of a DTO, collecting a ValidationError for every field that is
+// missing or malformed instead of stopping at the first.
+type fieldParser struct {
+	errs ValidationErrors
+}
+
+func (p *fieldParser) fail(field, value, problem string) {
+	p.errs = append(p.errs, ValidationError{Field: field, Value: value, Problem: problem})
+}
+
+// err returns the collected problems, or nil when the DTO converted cleanly.
+func (p *fieldParser) err() error {
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return p.errs
+}
+
+// required returns raw trimmed, failing when it is empty.
+func (p *fieldParser) required(field, raw string) string {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		p.fail(field, "", "is missing")
+	}
+	return value
+}
+
+// nonNegative fails when value is below zero and returns it unchanged.
+func (p *fieldParser) nonNegative(field string, value float64) float64 {
+	if value < 0 {
+		p.fail(field, strconv.FormatFloat(value, 'f', -1, 64), "is negative")
+	}
+	return value
+}
+
+// nullFloat parses an optional number: NULL when raw is empty, a failure when it is not a number.
+func (p *fieldParser) nullFloat(field, raw string) sql.NullFloat64 {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return sql.NullFloat64{}
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		p.fail(field, raw, "is not a number")
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: value, Valid: true}
+}
+
+// requiredNumber returns a number decoded into a pointer, failing when it is nil because the source left it
+// out.
+func (p *fieldParser) requiredNumber(field string, value *float64) float64 {
+	if value == nil {
+		p.fail(field, "", "is missing")
+		return 0
+	}
+	return *value
+}
+
+// nullNumber returns a number decoded into a pointer, NULL when the source left it out.
+func nullNumber(value *float64) sql.NullFloat64 {
+	if value == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *value, Valid: true}
+}
+
+// count parses a required whole number, failing when it is missing, not a number, or negative.
+func (p *fieldParser) count(field, raw string) int64 {
+	raw = p.required(field, raw)
+	if raw == "" {
+		return 0
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		p.fail(field, raw, "is not a whole number")
+		return 0
+	}
+	if value < 0 {
+		p.fail(field, raw, "is negative")
+		return 0
+	}
+	return value
+}
+
+// nullPoint parses a latitude and longitude pair, both NULL unless both are numbers. An absent or
+// unparsable coordinate does not fail the record: the point is stored as NULL rather than as 0,0, which
+// would later be geocoded to somewhere off the coast of Africa.
+func nullPoint(rawLatitude, rawLongitude string) (latitude, longitude sql.NullFloat64) {
+	lat, latErr := strconv.ParseFloat(strings.TrimSpace(rawLatitude), 64)
+	lon, lonErr := strconv.ParseFloat(strings.TrimSpace(rawLongitude), 64)
+	if latErr != nil || lonErr != nil {
+		return sql.NullFloat64{}, sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: lat, Valid: true}, sql.NullFloat64{Float64: lon, Valid: true}
+}
+
+// pointLocation returns the location of a point parsed by nullPoint, reporting false when it is NULL.
+func pointLocation(latitude, longitude sql.NullFloat64) (geocoder.Location, bool) {
+	if !latitude.Valid || !longitude.Valid {
+		return geocoder.Location{}, false
+	}
+	return geocoder.Location{Latitude: latitude.Float64, Longitude: longitude.Float64}, true
+}
+
+// time parses a required SODA date or floating timestamp. The wall time is kept as is, labeled UTC.
+func (p *fieldParser) time(field, raw string) time.Time {
+	value := p.nullTime(field, raw)
+	if !value.Valid && strings.TrimSpace(raw) == "" {
+		p.fail(field, "", "is missing")
+	}
+	return value.Time
+}
+
+// nullTime parses an optional SODA date or floating timestamp: NULL when raw is empty.
+func (p *fieldParser) nullTime(field, raw string) sql.NullTime {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return sql.NullTime{}
+	}
+	for _, layout := range sodaTimeLayouts {
+		if value, err := time.Parse(layout, raw); err == nil {
+			return sql.NullTime{Time: value, Valid: true}
+		}
+	}
+	p.fail(field, raw, "is not a date")
+	return sql.NullTime{}
+}
+
+// inChicago fails when the point parsed by nullPoint is set but lies outside Chicago, which means the source
+// or the geocoder placed it wrong.
+func (p *fieldParser) inChicago(field string, latitude, longitude sql.NullFloat64) {
+	location, ok := pointLocation(latitude, longitude)
+	if ok && !shared.InChicago(location) {
+		p.fail(field, fmt.Sprintf("%.5f,%.5f", location.Latitude, location.Longitude), "is outside Chicago")
+	}
+}
+
+// sodaDate writes the date part of t for a DATE column.
+func sodaDate(t time.Time) string {
+	return t.Format(time.DateOnly)
+}
+
+// sodaTimestamp writes t back as the floating timestamp it was parsed from, so the database interprets it
+// exactly as it did the raw SODA value.
+func sodaTimestamp(t time.Time) string {
+	return t.Format(sodaTimestampLayout)
+}
+
+// nullDate writes an optional date for a DATE column.
+func nullDate(t sql.NullTime) sql.NullString {
+	if !t.Valid {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: sodaDate(t.Time), Valid: true}
+}
+
+// nullTimestamp writes an optional timestamp for a TIMESTAMP column, as sodaTimestamp does.
+func nullTimestamp(t sql.NullTime) sql.NullString {
+	if !t.Valid {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: sodaTimestamp(t.Time), Valid: true}
+}
+
+// skipTally counts why the records of a load were skipped, by field problem.
+type skipTally map[string]int
+
+// add records the problems of one skipped record: each ValidationError of err, or err itself.
+func (t skipTally) add(err error) {
+	if problems, ok := err.(ValidationErrors); ok {
+		for _, problem := range problems {
+			t[problem.Field+" "+problem.Problem]++
+		}
+		return
+	}
+	t[err.Error()]++
+}
+
+// String renders the reasons most frequent first, e.g. "week_start is missing (10), case_rate_weekly is
+// negative (2)".
+func (t skipTally) String() string {
+	reasons := make([]string, 0, len(t))
+	for reason := range t {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if t[reasons[i]] != t[reasons[j]] {
+			return t[reasons[i]] > t[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	for i, reason := range reasons {
+		reasons[i] = fmt.Sprintf("%s (%d)", reason, t[reason])
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// reject routes record, refused from table for err, to the rejects table and tallies it on skipped.
+func reject(ctx context.Context, table, recordID string, err error, record any, skipped skipTally) {
+	skipped.add(err)
+	shared.RecordReject(ctx, table, recordID, err.Error(), record)
+}
+
+// reportMissingPoints prints how many records of a load of table were stored without coordinates.
+func reportMissingPoints(table string, count int) {
+	if count > 0 {
+		fmt.Printf("Stored %d %s records without coordinates\n", count, table)
+	}
+}
+
+// report prints the skip reasons of a load of table, if any records were skipped.
+func (t skipTally) report(table string) {
+	if len(t) > 0 {
+		fmt.Printf("Skipped %s records: %s\n", table, t)
+	}
+}
@@ -0,0 +1,107 @@
+package datasets
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+type CovidRecord struct {
+	ZIP                            string  `json:"zip_code" parquet:"zip_code"`
+	Week_start                     string  `json:"week_start" parquet:"week_start"`
+	Week_end                       string  `json:"week_end" parquet:"week_end"`
+	Case_rate_weekly               float64 `json:"case_rate_weekly,string" parquet:"case_rate_weekly"`
+	Percent_tested_positive_weekly float64 `json:"percent_tested_positive_weekly,string" parquet:"percent_tested_positive_weekly"`
+}
+
+type CovidRecords []CovidRecord
+
+var CovidSource = shared.SODASource{
+	Name:   "covid",
+	ID:     "yhhz-zm2v",
+	Record: CovidRecord{},
+	Select: []string{"zip_code", "week_start", "week_end", "case_rate_weekly", "percent_tested_positive_weekly"},
+}
+
+var CovidDataset = shared.Dataset{
+	Table: "covid",
+	CreateSQL: `CREATE TABLE IF NOT EXISTS "covid" (
+    "id" SERIAL PRIMARY KEY,
+    "zip_code" VARCHAR(9) NOT NULL,
+    "week_start" DATE NOT NULL,
+    "week_end" DATE NOT NULL,
+    "case_rate_weekly" FLOAT8,
+    "percent_tested_positive_weekly" FLOAT8,
+    "ingest_run_id" VARCHAR(32),
+    CONSTRAINT covid_unique_zip_week UNIQUE ("zip_code", "week_start", "week_end")
+);`,
+	InsertSQL: `INSERT INTO covid ("zip_code", "week_start", "week_end", "case_rate_weekly", "percent_tested_positive_weekly", "ingest_run_id")
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT ("zip_code", "week_start", "week_end") DO UPDATE
+			SET case_rate_weekly = EXCLUDED.case_rate_weekly,
+				percent_tested_positive_weekly = EXCLUDED.percent_tested_positive_weekly,
+				ingest_run_id = EXCLUDED.ingest_run_id;`,
+	Columns: []shared.Column{
+		{Name: "zip_code", Type: shared.ColumnString},
+		{Name: "week_start", Type: shared.ColumnDate},
+		{Name: "week_end", Type: shared.ColumnDate},
+		{Name: "case_rate_weekly", Type: shared.ColumnFloat},
+		{Name: "percent_tested_positive_weekly", Type: shared.ColumnFloat},
+		{Name: shared.IngestRunIDColumn, Type: shared.ColumnString},
+	},
+	RecordBytes: 768,
+}
+
+// CovidWeek is one week of COVID statistics for a ZIP code.
+type CovidWeek struct {
+	ZIP                         string
+	WeekStart                   time.Time
+	WeekEnd                     time.Time
+	CaseRateWeekly              float64
+	PercentTestedPositiveWeekly float64
+}
+
+// CovidWeekFromDTO converts a SODA COVID record, failing when the ZIP code or week is missing or a rate
+// is negative.
+func CovidWeekFromDTO(record CovidRecord) (CovidWeek, error) {
+	var p fieldParser
+	week := CovidWeek{
+		ZIP:                         p.required("zip_code", record.ZIP),
+		WeekStart:                   p.time("week_start", record.Week_start),
+		WeekEnd:                     p.time("week_end", record.Week_end),
+		CaseRateWeekly:              p.nonNegative("case_rate_weekly", record.Case_rate_weekly),
+		PercentTestedPositiveWeekly: p.nonNegative("percent_tested_positive_weekly", record.Percent_tested_positive_weekly),
+	}
+	return week, p.err()
+}
+
+// LoadCovid writes the weekly COVID records that convert to a CovidWeek to store and flushes it.
+func LoadCovid(ctx context.Context, store shared.Store, covid_data_list CovidRecords) (insertedCount, skippedCount int, err error) {
+	skipped := skipTally{}
+	defer skipped.report(CovidDataset.Table)
+
+	for _, record := range covid_data_list {
+		week, convErr := CovidWeekFromDTO(record)
+		if convErr != nil {
+			skipped.add(convErr)
+			skippedCount++
+			continue
+		}
+
+		err = store.Insert(ctx, CovidDataset,
+			week.ZIP,
+			sodaDate(week.WeekStart),
+			sodaDate(week.WeekEnd),
+			week.CaseRateWeekly,
+			week.PercentTestedPositiveWeekly,
+		)
+
+		if err != nil {
+			return insertedCount, skippedCount, err
+		}
+		insertedCount++
+	}
+
+	return insertedCount, skippedCount, store.Flush(ctx, CovidDataset)
+}
@@ -0,0 +1,208 @@
+package datasets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	// CovidRespiratoryPathogen selects the COVID-19 rows of the respiratory surveillance dataset, which also
+	// reports influenza and RSV.
+	CovidRespiratoryPathogen = "COVID-19"
+
+	// CovidUnifiedView is the view reports read weekly COVID statistics from: the covid archive and the
+	// covid_respiratory successor, with the successor's week preferred where both cover a ZIP code.
+	CovidUnifiedView = "covid_unified"
+)
+
+type CovidRespiratoryRecord struct {
+	ZIP                     string `json:"zip_code" parquet:"zip_code"`
+	Week_start              string `json:"week_start" parquet:"week_start"`
+	Week_end                string `json:"week_end" parquet:"week_end"`
+	Pathogen                string `json:"pathogen" parquet:"pathogen"`
+	Case_rate_weekly        string `json:"case_rate_weekly" parquet:"case_rate_weekly"`
+	Percent_positive_weekly string `json:"percent_positive_weekly" parquet:"percent_positive_weekly"`
+}
+
+type CovidRespiratoryRecords []CovidRespiratoryRecord
+
+// CovidRespiratorySource has no ID: the dataset is the one COVID_RESPIRATORY_RESOURCE configures.
+var CovidRespiratorySource = shared.SODASource{
+	Name:   "covid_respiratory",
+	ID:     "",
+	Record: CovidRespiratoryRecord{},
+	Select: []string{"zip_code", "week_start", "week_end", "pathogen", "case_rate_weekly", "percent_positive_weekly"},
+}
+
+var CovidRespiratoryDataset = shared.Dataset{
+	Table: "covid_respiratory",
+	CreateSQL: `CREATE TABLE IF NOT EXISTS "covid_respiratory" (
+    "id" SERIAL PRIMARY KEY,
+    "zip_code" VARCHAR(9) NOT NULL,
+    "week_start" DATE NOT NULL,
+    "week_end" DATE NOT NULL,
+    "pathogen" VARCHAR(32) NOT NULL,
+    "case_rate_weekly" FLOAT8,
+    "percent_positive_weekly" FLOAT8,
+    "ingest_run_id" VARCHAR(32),
+    CONSTRAINT covid_respiratory_unique_zip_week UNIQUE ("zip_code", "week_start", "week_end", "pathogen")
+);`,
+	InsertSQL: `INSERT INTO covid_respiratory ("zip_code", "week_start", "week_end", "pathogen", "case_rate_weekly", "percent_positive_weekly", "ingest_run_id")
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT ("zip_code", "week_start", "week_end", "pathogen") DO UPDATE
+			SET case_rate_weekly = EXCLUDED.case_rate_weekly,
+				percent_positive_weekly = EXCLUDED.percent_positive_weekly,
+				ingest_run_id = EXCLUDED.ingest_run_id;`,
+	Columns: []shared.Column{
+		{Name: "zip_code", Type: shared.ColumnString},
+		{Name: "week_start", Type: shared.ColumnDate},
+		{Name: "week_end", Type: shared.ColumnDate},
+		{Name: "pathogen", Type: shared.ColumnString},
+		{Name: "case_rate_weekly", Type: shared.ColumnFloat},
+		{Name: "percent_positive_weekly", Type: shared.ColumnFloat},
+		{Name: shared.IngestRunIDColumn, Type: shared.ColumnString},
+	},
+	RecordBytes: 768,
+}
+
+// CovidRespiratoryWeek is one week of respiratory surveillance for a ZIP code and pathogen.
+type CovidRespiratoryWeek struct {
+	ZIP                   string
+	WeekStart             time.Time
+	WeekEnd               time.Time
+	Pathogen              string
+	CaseRateWeekly        sql.NullFloat64
+	PercentPositiveWeekly sql.NullFloat64
+}
+
+// CovidRespiratoryWeekFromDTO converts a SODA respiratory surveillance record, failing when the ZIP code,
+// week, or pathogen is missing or a rate is negative. Rates the city suppresses for small counts are NULL.
+func CovidRespiratoryWeekFromDTO(record CovidRespiratoryRecord) (CovidRespiratoryWeek, error) {
+	var p fieldParser
+	week := CovidRespiratoryWeek{
+		ZIP:                   p.required("zip_code", record.ZIP),
+		WeekStart:             p.time("week_start", record.Week_start),
+		WeekEnd:               p.time("week_end", record.Week_end),
+		Pathogen:              p.required("pathogen", record.Pathogen),
+		CaseRateWeekly:        p.nullFloat("case_rate_weekly", record.Case_rate_weekly),
+		PercentPositiveWeekly: p.nullFloat("percent_positive_weekly", record.Percent_positive_weekly),
+	}
+	p.nonNegative("case_rate_weekly", week.CaseRateWeekly.Float64)
+	p.nonNegative("percent_positive_weekly", week.PercentPositiveWeekly.Float64)
+	return week, p.err()
+}
+
+// LoadCovidRespiratory writes the respiratory surveillance records that convert to a CovidRespiratoryWeek
+// to store and flushes it.
+func LoadCovidRespiratory(ctx context.Context, store shared.Store, records CovidRespiratoryRecords) (insertedCount, skippedCount int, err error) {
+	skipped := skipTally{}
+	defer skipped.report(CovidRespiratoryDataset.Table)
+
+	for _, record := range records {
+		week, convErr := CovidRespiratoryWeekFromDTO(record)
+		if convErr != nil {
+			skipped.add(convErr)
+			skippedCount++
+			continue
+		}
+
+		err = store.Insert(ctx, CovidRespiratoryDataset,
+			week.ZIP,
+			sodaDate(week.WeekStart),
+			sodaDate(week.WeekEnd),
+			week.Pathogen,
+			week.CaseRateWeekly,
+			week.PercentPositiveWeekly,
+		)
+		if err != nil {
+			return insertedCount, skippedCount, err
+		}
+		insertedCount++
+	}
+
+	return insertedCount, skippedCount, store.Flush(ctx, CovidRespiratoryDataset)
+}
+
+// EnsureCovidUnifiedView creates or replaces covid_unified over whichever of the covid and
+// covid_respiratory tables exist, in the search_path schema. It reports false, creating nothing, when
+// neither does yet. The view has the columns of the covid table the reports read, plus the source table of
+// each row.
+func EnsureCovidUnifiedView(db *sql.DB) (bool, error) {
+	if db == nil {
+		return false, errors.New("db connection is nil")
+	}
+
+	var covid, respiratory sql.NullString
+	if err := db.QueryRow(`SELECT to_regclass($1), to_regclass($2)`, CovidDataset.Table, CovidRespiratoryDataset.Table).Scan(&covid, &respiratory); err != nil {
+		return false, fmt.Errorf("failed to look up the sources of %s: %w", CovidUnifiedView, err)
+	}
+
+	archive := fmt.Sprintf(`SELECT c."zip_code", c."week_start", c."week_end", c."case_rate_weekly",
+		c."percent_tested_positive_weekly", c."ingest_run_id", %s::VARCHAR(32) AS "source"
+	FROM %q c`, pq.QuoteLiteral(CovidDataset.Table), CovidDataset.Table)
+	successor := fmt.Sprintf(`SELECT r."zip_code", r."week_start", r."week_end", r."case_rate_weekly",
+		r."percent_positive_weekly" AS "percent_tested_positive_weekly", r."ingest_run_id", %s::VARCHAR(32) AS "source"
+	FROM %q r
+	WHERE r."pathogen" = %s`, pq.QuoteLiteral(CovidRespiratoryDataset.Table), CovidRespiratoryDataset.Table,
+		pq.QuoteLiteral(CovidRespiratoryPathogen))
+
+	var selects []string
+	switch {
+	case covid.Valid && respiratory.Valid:
+		selects = []string{
+			archive + fmt.Sprintf(`
+	WHERE NOT EXISTS (
+		SELECT 1 FROM %q r
+		WHERE r."zip_code" = c."zip_code" AND r."week_start" = c."week_start" AND r."pathogen" = %s
+	)`, CovidRespiratoryDataset.Table, pq.QuoteLiteral(CovidRespiratoryPathogen)),
+			successor,
+		}
+	case covid.Valid:
+		selects = []string{archive}
+	case respiratory.Valid:
+		selects = []string{successor}
+	default:
+		return false, nil
+	}
+
+	stmt := fmt.Sprintf("CREATE OR REPLACE VIEW %q AS\n%s", CovidUnifiedView, strings.Join(selects, "\nUNION ALL\n"))
+	if _, err := db.Exec(stmt); err != nil {
+		return false, fmt.Errorf("failed to create %s: %w", CovidUnifiedView, err)
+	}
+	return true, nil
+}
+
+// DropCovidUnifiedView drops covid_unified, which has to go before either of its tables is dropped. The
+// next RefreshCovidUnifiedView recreates it.
+func DropCovidUnifiedView(db *sql.DB) error {
+	if db == nil {
+		return errors.New("db connection is nil")
+	}
+	if _, err := db.Exec(fmt.Sprintf(`DROP VIEW IF EXISTS %q`, CovidUnifiedView)); err != nil {
+		return fmt.Errorf("failed to drop %s: %w", CovidUnifiedView, err)
+	}
+	return nil
+}
+
+// RefreshCovidUnifiedView recreates covid_unified after one of its sources was loaded and records the
+// refresh of the view, so reports reading it notice the new weeks.
+func RefreshCovidUnifiedView(db *sql.DB) error {
+	created, err := EnsureCovidUnifiedView(db)
+	if err != nil || !created {
+		return err
+	}
+
+	var rowCount int
+	if err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %q`, CovidUnifiedView)).Scan(&rowCount); err != nil {
+		return fmt.Errorf("failed to count rows in %s: %w", CovidUnifiedView, err)
+	}
+	return shared.RecordTableRefresh(db, CovidUnifiedView, rowCount)
+}
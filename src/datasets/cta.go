@@ -0,0 +1,251 @@
+package datasets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kelvins/geocoder"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	// CTARailMode and CTABusMode tell the two sources apart in cta_ridership.
+	CTARailMode = "rail"
+	CTABusMode  = "bus"
+)
+
+// CTARailRecord is one day of entries at an 'L' station.
+type CTARailRecord struct {
+	Station_id  string `json:"station_id" parquet:"station_id"`
+	Stationname string `json:"stationname" parquet:"stationname"`
+	Date        string `json:"date" parquet:"date"`
+	Daytype     string `json:"daytype" parquet:"daytype"`
+	Rides       int    `json:"rides,string" parquet:"rides"`
+}
+
+// CTABusRecord is one day of boardings on a bus route.
+type CTABusRecord struct {
+	Route   string `json:"route" parquet:"route"`
+	Date    string `json:"date" parquet:"date"`
+	Daytype string `json:"daytype" parquet:"daytype"`
+	Rides   int    `json:"rides,string" parquet:"rides"`
+}
+
+// CTAStopRecord is one platform of the 'L' stop list; map_id is the station_id of the ridership data.
+type CTAStopRecord struct {
+	Map_id   string `json:"map_id"`
+	Location struct {
+		Latitude  string `json:"latitude"`
+		Longitude string `json:"longitude"`
+	} `json:"location"`
+}
+
+// CTAStation is where an 'L' station is, used to place its ridership.
+type CTAStation struct {
+	Location geocoder.Location
+	ZipCode  string
+}
+
+var CTARailSource = shared.SODASource{
+	Name:   "cta_rail",
+	ID:     "5neh-572f",
+	Record: CTARailRecord{},
+	Select: []string{"station_id", "stationname", "date", "daytype", "rides"},
+}
+
+var CTABusSource = shared.SODASource{
+	Name:   "cta_bus",
+	ID:     "jyb9-n7fm",
+	Record: CTABusRecord{},
+	Select: []string{"route", "date", "daytype", "rides"},
+}
+
+var CTAStopsSource = shared.SODASource{
+	Name:   "cta_stops",
+	ID:     "8pix-ypme",
+	Record: CTAStopRecord{},
+	Select: []string{"map_id", "location"},
+}
+
+var CTARidershipDataset = shared.Dataset{
+	Table: "cta_ridership",
+	CreateSQL: `CREATE TABLE IF NOT EXISTS "cta_ridership" (
+    "id" SERIAL PRIMARY KEY,
+    "mode" VARCHAR(4) NOT NULL,
+    "station_or_route" VARCHAR(16) NOT NULL,
+    "name" VARCHAR(255),
+    "date" DATE NOT NULL,
+    "daytype" VARCHAR(1),
+    "rides" INTEGER,
+    "latitude" FLOAT8,
+    "longitude" FLOAT8,
+    "zip_code" VARCHAR(9),
+    "ingest_run_id" VARCHAR(32),
+    CONSTRAINT cta_ridership_unique_mode_station_date UNIQUE ("mode", "station_or_route", "date")
+);`,
+	InsertSQL: `INSERT INTO cta_ridership ("mode", "station_or_route", "name", "date", "daytype", "rides", "latitude", "longitude", "zip_code", "ingest_run_id")
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			ON CONFLICT ("mode", "station_or_route", "date") DO UPDATE
+			SET name = EXCLUDED.name,
+				daytype = EXCLUDED.daytype,
+				rides = EXCLUDED.rides,
+				latitude = EXCLUDED.latitude,
+				longitude = EXCLUDED.longitude,
+				zip_code = EXCLUDED.zip_code,
+				ingest_run_id = EXCLUDED.ingest_run_id;`,
+	Columns: []shared.Column{
+		{Name: "mode", Type: shared.ColumnString},
+		{Name: "station_or_route", Type: shared.ColumnString},
+		{Name: "name", Type: shared.ColumnString},
+		{Name: "date", Type: shared.ColumnDate},
+		{Name: "daytype", Type: shared.ColumnString},
+		{Name: "rides", Type: shared.ColumnInteger},
+		{Name: "latitude", Type: shared.ColumnFloat},
+		{Name: "longitude", Type: shared.ColumnFloat},
+		{Name: "zip_code", Type: shared.ColumnString},
+		{Name: shared.IngestRunIDColumn, Type: shared.ColumnString},
+	},
+	RecordBytes: 768,
+}
+
+// CTAStations locates every 'L' station in stops, keyed by station_id. With useGeocoding each station's
+// ZIP code is reverse geocoded once; a station that cannot be resolved keeps an empty ZIP code.
+func CTAStations(ctx context.Context, stops []CTAStopRecord, useGeocoding bool) map[string]CTAStation {
+	stations := make(map[string]CTAStation)
+	for _, stop := range stops {
+		if _, seen := stations[stop.Map_id]; seen || stop.Map_id == "" {
+			continue
+		}
+		latitude, latErr := strconv.ParseFloat(strings.TrimSpace(stop.Location.Latitude), 64)
+		longitude, lonErr := strconv.ParseFloat(strings.TrimSpace(stop.Location.Longitude), 64)
+		if latErr != nil || lonErr != nil {
+			continue
+		}
+
+		station := CTAStation{Location: geocoder.Location{Latitude: latitude, Longitude: longitude}}
+		if useGeocoding {
+			zip, err := shared.ReverseGeocodeZip(ctx, station.Location)
+			if err != nil && !errors.Is(err, shared.ErrGeocoderUnavailable) {
+				fmt.Printf("Unable to reverse geocode CTA station %s: %v\n", stop.Map_id, err)
+			}
+			station.ZipCode = zip
+		}
+		stations[stop.Map_id] = station
+	}
+	return stations
+}
+
+// CTARide is one day of CTA ridership: entries at an 'L' station or boardings on a bus route.
+type CTARide struct {
+	Mode           string
+	StationOrRoute string
+	Name           string
+	Date           time.Time
+	DayType        string
+	Rides          int
+}
+
+// CTARailFromDTO converts an 'L' station record, failing when its station or date is missing or its ride
+// count is negative.
+func CTARailFromDTO(record CTARailRecord) (CTARide, error) {
+	var p fieldParser
+	ride := CTARide{
+		Mode:           CTARailMode,
+		StationOrRoute: p.required("station_id", record.Station_id),
+		Name:           record.Stationname,
+		Date:           p.time("date", record.Date),
+		DayType:        record.Daytype,
+		Rides:          int(p.nonNegative("rides", float64(record.Rides))),
+	}
+	return ride, p.err()
+}
+
+// CTABusFromDTO converts a bus route record, failing when its route or date is missing or its ride count is
+// negative. Routes have no other name.
+func CTABusFromDTO(record CTABusRecord) (CTARide, error) {
+	var p fieldParser
+	ride := CTARide{
+		Mode:           CTABusMode,
+		StationOrRoute: p.required("route", record.Route),
+		Date:           p.time("date", record.Date),
+		DayType:        record.Daytype,
+		Rides:          int(p.nonNegative("rides", float64(record.Rides))),
+	}
+	ride.Name = ride.StationOrRoute
+	return ride, p.err()
+}
+
+// insertCTARide writes ride to store, placed at latitude and longitude in zip when they are known.
+func insertCTARide(ctx context.Context, store shared.Store, ride CTARide, latitude, longitude sql.NullFloat64, zip sql.NullString) error {
+	return store.Insert(ctx, CTARidershipDataset,
+		ride.Mode,
+		ride.StationOrRoute,
+		ride.Name,
+		sodaDate(ride.Date),
+		ride.DayType,
+		ride.Rides,
+		latitude,
+		longitude,
+		zip,
+	)
+}
+
+// LoadCTARail writes the 'L' station records that convert to a CTARide to store, placed at their station in
+// stations, and flushes it.
+func LoadCTARail(ctx context.Context, store shared.Store, rail_data_list []CTARailRecord, stations map[string]CTAStation) (insertedCount, skippedCount int, err error) {
+	skipped := skipTally{}
+	defer skipped.report("cta_ridership rail")
+
+	for _, record := range rail_data_list {
+		ride, convErr := CTARailFromDTO(record)
+		if convErr != nil {
+			skipped.add(convErr)
+			skippedCount++
+			continue
+		}
+
+		var latitude, longitude sql.NullFloat64
+		var zip sql.NullString
+		if station, ok := stations[ride.StationOrRoute]; ok {
+			latitude = sql.NullFloat64{Float64: station.Location.Latitude, Valid: true}
+			longitude = sql.NullFloat64{Float64: station.Location.Longitude, Valid: true}
+			zip = sql.NullString{String: station.ZipCode, Valid: station.ZipCode != ""}
+		}
+
+		if err = insertCTARide(ctx, store, ride, latitude, longitude, zip); err != nil {
+			return insertedCount, skippedCount, err
+		}
+		insertedCount++
+	}
+
+	return insertedCount, skippedCount, store.Flush(ctx, CTARidershipDataset)
+}
+
+// LoadCTABus writes the bus route records that convert to a CTARide to store and flushes it. Routes span
+// many areas, so they carry no location.
+func LoadCTABus(ctx context.Context, store shared.Store, bus_data_list []CTABusRecord) (insertedCount, skippedCount int, err error) {
+	skipped := skipTally{}
+	defer skipped.report("cta_ridership bus")
+
+	for _, record := range bus_data_list {
+		ride, convErr := CTABusFromDTO(record)
+		if convErr != nil {
+			skipped.add(convErr)
+			skippedCount++
+			continue
+		}
+
+		if err = insertCTARide(ctx, store, ride, sql.NullFloat64{}, sql.NullFloat64{}, sql.NullString{}); err != nil {
+			return insertedCount, skippedCount, err
+		}
+		insertedCount++
+	}
+
+	return insertedCount, skippedCount, store.Flush(ctx, CTARidershipDataset)
+}
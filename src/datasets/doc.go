@@ -0,0 +1,9 @@
+// Package datasets holds the SODA record types, table definitions, and insert logic for every source
+// collected into the data lake. The collectors service feeds it live API pulls and the replay tool feeds
+// it archived raw files, so both paths apply identical data quality rules.
+//
+// The SODA record types are DTOs that mirror the JSON, all strings, and are what gets archived. Each has a
+// typed domain counterpart (Trip, BuildingPermit, CovidWeek, ...) built by a FromDTO function, which
+// parses dates and numbers and returns ValidationErrors naming every field that is missing or malformed.
+// The Load functions insert only domain values and log why the records they skipped failed conversion.
+package datasets
@@ -0,0 +1,298 @@
+package datasets
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// FoodInspectionsLicenseDescription selects the business licenses food inspections are linked to.
+const FoodInspectionsLicenseDescription = "Retail Food Establishment"
+
+type FoodInspectionRecord struct {
+	Inspection_id   string `json:"inspection_id" parquet:"inspection_id"`
+	Dba_name        string `json:"dba_name" parquet:"dba_name"`
+	License_        string `json:"license_" parquet:"license_"`
+	Facility_type   string `json:"facility_type" parquet:"facility_type"`
+	Risk            string `json:"risk" parquet:"risk"`
+	Address         string `json:"address" parquet:"address"`
+	Zip             string `json:"zip" parquet:"zip"`
+	Inspection_date string `json:"inspection_date" parquet:"inspection_date"`
+	Inspection_type string `json:"inspection_type" parquet:"inspection_type"`
+	Results         string `json:"results" parquet:"results"`
+	Latitude        string `json:"latitude" parquet:"latitude"`
+	Longitude       string `json:"longitude" parquet:"longitude"`
+}
+
+type FoodInspectionRecords []FoodInspectionRecord
+
+type BusinessLicenseRecord struct {
+	Id                     string `json:"id" parquet:"id"`
+	License_number         string `json:"license_number" parquet:"license_number"`
+	Legal_name             string `json:"legal_name" parquet:"legal_name"`
+	Doing_business_as_name string `json:"doing_business_as_name" parquet:"doing_business_as_name"`
+	License_description    string `json:"license_description" parquet:"license_description"`
+	Address                string `json:"address" parquet:"address"`
+	Zip_code               string `json:"zip_code" parquet:"zip_code"`
+	License_status         string `json:"license_status" parquet:"license_status"`
+	Expiration_date        string `json:"expiration_date" parquet:"expiration_date"`
+}
+
+type BusinessLicenseRecords []BusinessLicenseRecord
+
+var FoodInspectionsSource = shared.SODASource{
+	Name:   "food_inspections",
+	ID:     "4ijn-s7e5",
+	Record: FoodInspectionRecord{},
+	Select: []string{
+		"inspection_id", "dba_name", "license_", "facility_type", "risk", "address", "zip", "inspection_date",
+		"inspection_type", "results", "latitude", "longitude",
+	},
+}
+
+var FoodInspectionsDataset = shared.Dataset{
+	Table: "food_inspections",
+	CreateSQL: `CREATE TABLE IF NOT EXISTS "food_inspections" (
+    "inspection_id" VARCHAR(16) PRIMARY KEY,
+    "dba_name" VARCHAR(255),
+    "license_number" VARCHAR(16),
+    "facility_type" VARCHAR(255),
+    "risk" VARCHAR(32),
+    "address" VARCHAR(255),
+    "zip_code" VARCHAR(9),
+    "inspection_date" DATE NOT NULL,
+    "inspection_type" VARCHAR(255),
+    "results" VARCHAR(64),
+    "latitude" FLOAT8,
+    "longitude" FLOAT8,
+    "ingest_run_id" VARCHAR(32)
+);`,
+	InsertSQL: `INSERT INTO food_inspections ("inspection_id", "dba_name", "license_number", "facility_type", "risk", "address", "zip_code", "inspection_date", "inspection_type", "results", "latitude", "longitude", "ingest_run_id")
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			ON CONFLICT ("inspection_id") DO NOTHING;`,
+	Columns: []shared.Column{
+		{Name: "inspection_id", Type: shared.ColumnString},
+		{Name: "dba_name", Type: shared.ColumnString},
+		{Name: "license_number", Type: shared.ColumnString},
+		{Name: "facility_type", Type: shared.ColumnString},
+		{Name: "risk", Type: shared.ColumnString},
+		{Name: "address", Type: shared.ColumnString},
+		{Name: "zip_code", Type: shared.ColumnString},
+		{Name: "inspection_date", Type: shared.ColumnDate},
+		{Name: "inspection_type", Type: shared.ColumnString},
+		{Name: "results", Type: shared.ColumnString},
+		{Name: "latitude", Type: shared.ColumnFloat},
+		{Name: "longitude", Type: shared.ColumnFloat},
+		{Name: shared.IngestRunIDColumn, Type: shared.ColumnString},
+	},
+	RecordBytes: 1536,
+}
+
+var BusinessLicensesSource = shared.SODASource{
+	Name:   "business_licenses",
+	ID:     "r5kz-chrr",
+	Record: BusinessLicenseRecord{},
+	Select: []string{
+		"id", "license_number", "legal_name", "doing_business_as_name", "license_description", "address", "zip_code",
+		"license_status", "expiration_date",
+	},
+}
+
+var BusinessLicensesDataset = shared.Dataset{
+	Table: "business_licenses",
+	CreateSQL: `CREATE TABLE IF NOT EXISTS "business_licenses" (
+    "id" VARCHAR(64) PRIMARY KEY,
+    "license_number" VARCHAR(16) NOT NULL,
+    "legal_name" VARCHAR(255),
+    "doing_business_as_name" VARCHAR(255),
+    "license_description" VARCHAR(255),
+    "address" VARCHAR(255),
+    "zip_code" VARCHAR(9),
+    "license_status" VARCHAR(8),
+    "expiration_date" DATE,
+    "ingest_run_id" VARCHAR(32)
+);`,
+	InsertSQL: `INSERT INTO business_licenses ("id", "license_number", "legal_name", "doing_business_as_name", "license_description", "address", "zip_code", "license_status", "expiration_date", "ingest_run_id")
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			ON CONFLICT ("id") DO NOTHING;`,
+	Columns: []shared.Column{
+		{Name: "id", Type: shared.ColumnString},
+		{Name: "license_number", Type: shared.ColumnString},
+		{Name: "legal_name", Type: shared.ColumnString},
+		{Name: "doing_business_as_name", Type: shared.ColumnString},
+		{Name: "license_description", Type: shared.ColumnString},
+		{Name: "address", Type: shared.ColumnString},
+		{Name: "zip_code", Type: shared.ColumnString},
+		{Name: "license_status", Type: shared.ColumnString},
+		{Name: "expiration_date", Type: shared.ColumnDate},
+		{Name: shared.IngestRunIDColumn, Type: shared.ColumnString},
+	},
+	RecordBytes: 1024,
+}
+
+// FoodInspection is one inspection of a food establishment.
+type FoodInspection struct {
+	InspectionID  string
+	DBAName       string
+	LicenseNumber sql.NullString
+	FacilityType  string
+	Risk          string
+	Address       string
+	ZIPCode       sql.NullString
+	Date          time.Time
+	Type          string
+	Results       string
+	Latitude      sql.NullFloat64
+	Longitude     sql.NullFloat64
+}
+
+// FoodInspectionFromDTO converts a SODA food inspection, failing when its id or date is missing. The portal
+// uses license number 0 for inspections without one, converted to NULL, and missing or unparsable
+// coordinates are NULL too.
+func FoodInspectionFromDTO(record FoodInspectionRecord) (FoodInspection, error) {
+	var p fieldParser
+	inspection := FoodInspection{
+		InspectionID:  p.required("inspection_id", record.Inspection_id),
+		DBAName:       record.Dba_name,
+		LicenseNumber: licenseNumber(record.License_),
+		FacilityType:  record.Facility_type,
+		Risk:          record.Risk,
+		Address:       record.Address,
+		ZIPCode:       nullString(record.Zip),
+		Date:          p.time("inspection_date", record.Inspection_date),
+		Type:          record.Inspection_type,
+		Results:       record.Results,
+	}
+	inspection.Latitude, inspection.Longitude = nullPoint(record.Latitude, record.Longitude)
+	return inspection, p.err()
+}
+
+// BusinessLicense is one term of a business license.
+type BusinessLicense struct {
+	ID             string
+	LicenseNumber  string
+	LegalName      string
+	DBAName        string
+	Description    string
+	Address        string
+	ZIPCode        sql.NullString
+	Status         string
+	ExpirationDate sql.NullTime
+}
+
+// BusinessLicenseFromDTO converts a SODA business license, failing when its id or license number is
+// missing or its expiration date is malformed.
+func BusinessLicenseFromDTO(record BusinessLicenseRecord) (BusinessLicense, error) {
+	var p fieldParser
+	number := licenseNumber(record.License_number)
+	if !number.Valid {
+		p.fail("license_number", "", "is missing")
+	}
+	license := BusinessLicense{
+		ID:             p.required("id", record.Id),
+		LicenseNumber:  number.String,
+		LegalName:      record.Legal_name,
+		DBAName:        record.Doing_business_as_name,
+		Description:    record.License_description,
+		Address:        record.Address,
+		ZIPCode:        nullString(record.Zip_code),
+		Status:         record.License_status,
+		ExpirationDate: p.nullTime("expiration_date", record.Expiration_date),
+	}
+	return license, p.err()
+}
+
+// LoadFoodInspections writes the food inspections that convert to a FoodInspection to store and flushes
+// it. The license number links an inspection to business_licenses.
+func LoadFoodInspections(ctx context.Context, store shared.Store, inspection_data_list FoodInspectionRecords) (insertedCount, skippedCount int, err error) {
+	skipped := skipTally{}
+	defer skipped.report(FoodInspectionsDataset.Table)
+	missingPoints := 0
+	defer func() { reportMissingPoints(FoodInspectionsDataset.Table, missingPoints) }()
+
+	for _, record := range inspection_data_list {
+		inspection, convErr := FoodInspectionFromDTO(record)
+		if convErr != nil {
+			skipped.add(convErr)
+			skippedCount++
+			continue
+		}
+		if _, ok := pointLocation(inspection.Latitude, inspection.Longitude); !ok {
+			missingPoints++
+		}
+
+		err = store.Insert(ctx, FoodInspectionsDataset,
+			inspection.InspectionID,
+			inspection.DBAName,
+			inspection.LicenseNumber,
+			inspection.FacilityType,
+			inspection.Risk,
+			inspection.Address,
+			inspection.ZIPCode,
+			sodaDate(inspection.Date),
+			inspection.Type,
+			inspection.Results,
+			inspection.Latitude,
+			inspection.Longitude,
+		)
+		if err != nil {
+			return insertedCount, skippedCount, err
+		}
+		insertedCount++
+	}
+
+	return insertedCount, skippedCount, store.Flush(ctx, FoodInspectionsDataset)
+}
+
+// LoadBusinessLicenses writes the business licenses that convert to a BusinessLicense to store and flushes
+// it. A license number has one row per license term.
+func LoadBusinessLicenses(ctx context.Context, store shared.Store, license_data_list BusinessLicenseRecords) (insertedCount, skippedCount int, err error) {
+	skipped := skipTally{}
+	defer skipped.report(BusinessLicensesDataset.Table)
+
+	for _, record := range license_data_list {
+		license, convErr := BusinessLicenseFromDTO(record)
+		if convErr != nil {
+			skipped.add(convErr)
+			skippedCount++
+			continue
+		}
+
+		err = store.Insert(ctx, BusinessLicensesDataset,
+			license.ID,
+			license.LicenseNumber,
+			license.LegalName,
+			license.DBAName,
+			license.Description,
+			license.Address,
+			license.ZIPCode,
+			license.Status,
+			nullDate(license.ExpirationDate),
+		)
+		if err != nil {
+			return insertedCount, skippedCount, err
+		}
+		insertedCount++
+	}
+
+	return insertedCount, skippedCount, store.Flush(ctx, BusinessLicensesDataset)
+}
+
+// licenseNumber normalizes a license number so food inspections and business licenses match: without
+// leading zeros, and NULL when missing.
+func licenseNumber(raw string) sql.NullString {
+	number := strings.TrimLeft(strings.TrimSpace(raw), "0")
+	if number == "" {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: number, Valid: true}
+}
+
+// nullString stores an empty SODA value as NULL.
+func nullString(raw string) sql.NullString {
+	raw = strings.TrimSpace(raw)
+	return sql.NullString{String: raw, Valid: raw != ""}
+}
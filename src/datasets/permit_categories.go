@@ -0,0 +1,98 @@
+package datasets
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PermitCategoriesFileEnvKey points at an optional CSV of permit_type,permit_category rows that extends
+// or overrides the built-in permit taxonomy.
+const PermitCategoriesFileEnvKey = "PERMIT_CATEGORIES_FILE"
+
+// PermitCategoryOther is assigned to permit types the taxonomy does not know.
+const PermitCategoryOther = "other"
+
+// defaultPermitCategories maps normalized permit types (see NormalizePermitType) to their category.
+var defaultPermitCategories = map[string]string{
+	"NEW CONSTRUCTION":      "new_construction",
+	"RENOVATION/ALTERATION": "renovation",
+	"PORCH CONSTRUCTION":    "renovation",
+	"EASY PERMIT PROCESS":   "renovation",
+	"WRECKING/DEMOLITION":   "demolition",
+	"ELECTRIC WIRING":       "electrical",
+	"ELEVATOR EQUIPMENT":    "elevator",
+	"SIGNS":                 "signage",
+	"SCAFFOLDING":           "scaffolding",
+	"REINSTATE REVOKED PMT": "administrative",
+	"FOR EXTENSION OF PMT":  "administrative",
+}
+
+// NormalizePermitType upper-cases a SODA permit_type, collapses whitespace, and drops the "PERMIT -"
+// prefix, so "Permit - New  Construction" and "PERMIT - NEW CONSTRUCTION" compare equal.
+func NormalizePermitType(permitType string) string {
+	normalized := strings.Join(strings.Fields(strings.ToUpper(permitType)), " ")
+	for _, prefix := range []string{"PERMIT - ", "PERMIT -", "PERMIT-"} {
+		if rest, ok := strings.CutPrefix(normalized, prefix); ok {
+			return strings.TrimSpace(rest)
+		}
+	}
+	return normalized
+}
+
+// PermitTaxonomy assigns permit categories to permit types.
+type PermitTaxonomy map[string]string
+
+// Category returns the category of permitType, or PermitCategoryOther when it is not mapped.
+func (t PermitTaxonomy) Category(permitType string) string {
+	if category, ok := t[NormalizePermitType(permitType)]; ok {
+		return category
+	}
+	return PermitCategoryOther
+}
+
+// LoadPermitTaxonomy returns the built-in taxonomy merged with the rows of PERMIT_CATEGORIES_FILE, if set.
+func LoadPermitTaxonomy() (PermitTaxonomy, error) {
+	taxonomy := make(PermitTaxonomy, len(defaultPermitCategories))
+	for permitType, category := range defaultPermitCategories {
+		taxonomy[permitType] = category
+	}
+
+	path := strings.TrimSpace(os.Getenv(PermitCategoriesFileEnvKey))
+	if path == "" {
+		return taxonomy, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open permit categories file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read permit categories file %s: %w", path, err)
+	}
+
+	for i, row := range records {
+		if len(row) < 2 {
+			return nil, fmt.Errorf("invalid row %d in %s: expected permit_type and permit_category", i+1, path)
+		}
+		permitType := NormalizePermitType(row[0])
+		category := strings.ToLower(strings.TrimSpace(row[1]))
+
+		if i == 0 && strings.EqualFold(permitType, "permit_type") {
+			continue
+		}
+		if permitType == "" || category == "" {
+			return nil, fmt.Errorf("missing permit_type or permit_category at row %d in %s", i+1, path)
+		}
+
+		taxonomy[permitType] = category
+	}
+
+	return taxonomy, nil
+}
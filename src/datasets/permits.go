@@ -0,0 +1,358 @@
+package datasets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kelvins/geocoder"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+type BuildingPermitsJsonRecord struct {
+	Id            string `json:"id" parquet:"id"`
+	Permit_       string `json:"permit_" parquet:"permit_"`
+	Permit_type   string `json:"permit_type" parquet:"permit_type"`
+	Issue_date    string `json:"issue_date" parquet:"issue_date"`
+	Street_number string `json:"street_number" parquet:"street_number"`
+	Street_name   string `json:"street_name" parquet:"street_name"`
+	// Street_direction and Suffix complete the street address used for forward geocoding.
+	Street_direction string `json:"street_direction" parquet:"street_direction"`
+	Suffix           string `json:"suffix" parquet:"suffix"`
+	Latitude         string `json:"latitude" parquet:"latitude"`
+	Longitude        string `json:"longitude" parquet:"longitude"`
+	//Location       string `json:"location"`
+	Community_area string `json:"community_area" parquet:"community_area"`
+	Census_tract   string `json:"census_tract" parquet:"census_tract"`
+	// Total_fee is the permit's total fee in dollars, paid, unpaid, and waived.
+	Total_fee string `json:"total_fee" parquet:"total_fee"`
+	// Application_start_date is when the application was filed; with Issue_date it gives the processing time.
+	Application_start_date string `json:"application_start_date" parquet:"application_start_date"`
+	Ward                   string `json:"ward" parquet:"ward"`
+}
+
+type BuildingPermitsJsonRecords []BuildingPermitsJsonRecord
+
+var BuildingPermitsSource = shared.SODASource{
+	Name:   "building_permits",
+	ID:     "ydr8-5enu",
+	Record: BuildingPermitsJsonRecord{},
+	Select: []string{
+		"id", "permit_", "permit_type", "issue_date", "street_number", "street_direction", "street_name", "suffix",
+		"latitude", "longitude", "community_area", "census_tract", "total_fee", "application_start_date", "ward",
+	},
+}
+
+var BuildingPermitsDataset = shared.Dataset{
+	Table: "building_permits",
+	CreateSQL: `CREATE TABLE IF NOT EXISTS "building_permits" (
+		"id" VARCHAR(255) PRIMARY KEY,
+		"permit_id" VARCHAR(255) UNIQUE,
+		"permit_type" VARCHAR(255),
+		"permit_category" VARCHAR(64),
+		"issue_date"      DATE,
+		"street_number"      VARCHAR(255),
+		"street_name"      VARCHAR(255),
+		"street_direction" VARCHAR(255),
+		"suffix"           VARCHAR(255),
+		"latitude"      FLOAT8,
+		"longitude"      FLOAT8,
+		"community_area" VARCHAR(2),
+		"census_tract" VARCHAR(255),
+		"address_zip"  VARCHAR(9),
+		"total_fee"    FLOAT8,
+		"application_start_date" DATE,
+		"ward"         VARCHAR(2),
+		"processing_days" INTEGER,
+		"last_seen_at" TIMESTAMP WITH TIME ZONE,
+		"vanished_at"  TIMESTAMP WITH TIME ZONE,
+		"ingest_run_id" VARCHAR(32)
+	);`,
+	// Permits are upserted, so permits that drop out of the pulled window are kept. A permit seen again is
+	// no longer considered vanished.
+	InsertSQL: `INSERT INTO building_permits ("id", "permit_id", "permit_type", "permit_category", "issue_date", "street_number", "street_name", "street_direction", "suffix", "latitude", "longitude", "community_area", "census_tract", "address_zip", "total_fee", "application_start_date", "ward", "processing_days", "ingest_run_id", "last_seen_at")
+		values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, NOW())
+		ON CONFLICT ("id") DO UPDATE
+		SET permit_id = EXCLUDED.permit_id,
+			permit_type = EXCLUDED.permit_type,
+			permit_category = EXCLUDED.permit_category,
+			issue_date = EXCLUDED.issue_date,
+			street_number = EXCLUDED.street_number,
+			street_name = EXCLUDED.street_name,
+			street_direction = EXCLUDED.street_direction,
+			suffix = EXCLUDED.suffix,
+			latitude = EXCLUDED.latitude,
+			longitude = EXCLUDED.longitude,
+			community_area = EXCLUDED.community_area,
+			census_tract = EXCLUDED.census_tract,
+			address_zip = EXCLUDED.address_zip,
+			total_fee = EXCLUDED.total_fee,
+			application_start_date = EXCLUDED.application_start_date,
+			ward = EXCLUDED.ward,
+			processing_days = EXCLUDED.processing_days,
+			ingest_run_id = EXCLUDED.ingest_run_id,
+			last_seen_at = EXCLUDED.last_seen_at,
+			vanished_at = NULL`,
+	Columns: []shared.Column{
+		{Name: "id", Type: shared.ColumnString},
+		{Name: "permit_id", Type: shared.ColumnString},
+		{Name: "permit_type", Type: shared.ColumnString},
+		{Name: "permit_category", Type: shared.ColumnString},
+		{Name: "issue_date", Type: shared.ColumnDate},
+		{Name: "street_number", Type: shared.ColumnString},
+		{Name: "street_name", Type: shared.ColumnString},
+		{Name: "street_direction", Type: shared.ColumnString},
+		{Name: "suffix", Type: shared.ColumnString},
+		{Name: "latitude", Type: shared.ColumnFloat},
+		{Name: "longitude", Type: shared.ColumnFloat},
+		{Name: "community_area", Type: shared.ColumnString},
+		{Name: "census_tract", Type: shared.ColumnString},
+		{Name: "address_zip", Type: shared.ColumnString},
+		{Name: "total_fee", Type: shared.ColumnFloat},
+		{Name: "application_start_date", Type: shared.ColumnDate},
+		{Name: "ward", Type: shared.ColumnString},
+		{Name: "processing_days", Type: shared.ColumnInteger},
+		{Name: shared.IngestRunIDColumn, Type: shared.ColumnString},
+	},
+	RecordBytes: 2560,
+}
+
+// BuildingPermit is one issued building permit. Coordinates and census tract may still be missing after
+// conversion, until geocoding fills them in.
+type BuildingPermit struct {
+	ID              string
+	PermitID        string
+	PermitType      string
+	IssueDate       time.Time
+	StreetNumber    string
+	StreetName      string
+	StreetDirection string
+	Suffix          string
+	Latitude        sql.NullFloat64
+	Longitude       sql.NullFloat64
+	CommunityArea   string
+	CensusTract     string
+	TotalFee        sql.NullFloat64
+	// ApplicationStartDate is NULL when the source leaves it out, and so is ProcessingDays, the days from it
+	// to IssueDate. ProcessingDays is also NULL when the application date falls after the issue date.
+	ApplicationStartDate sql.NullTime
+	Ward                 sql.NullString
+	ProcessingDays       sql.NullInt64
+}
+
+// BuildingPermitFromDTO converts a SODA building permit, failing when a field other than its location, ward,
+// or application start date is missing or a date is malformed. Missing or unparsable coordinates are NULL,
+// left for geocoding.
+func BuildingPermitFromDTO(record BuildingPermitsJsonRecord) (BuildingPermit, error) {
+	var p fieldParser
+	permit := BuildingPermit{
+		ID:              p.required("id", record.Id),
+		PermitID:        p.required("permit_", record.Permit_),
+		PermitType:      p.required("permit_type", record.Permit_type),
+		IssueDate:       p.time("issue_date", record.Issue_date),
+		StreetNumber:    p.required("street_number", record.Street_number),
+		StreetName:      p.required("street_name", record.Street_name),
+		StreetDirection: record.Street_direction,
+		Suffix:          record.Suffix,
+		CommunityArea:   p.required("community_area", record.Community_area),
+		CensusTract:     strings.TrimSpace(record.Census_tract),
+		TotalFee:        p.nullFloat("total_fee", record.Total_fee),
+
+		ApplicationStartDate: p.nullTime("application_start_date", record.Application_start_date),
+		Ward:                 nullString(record.Ward),
+	}
+	permit.Latitude, permit.Longitude = nullPoint(record.Latitude, record.Longitude)
+	permit.ProcessingDays = processingDays(permit.ApplicationStartDate, permit.IssueDate)
+	return permit, p.err()
+}
+
+// processingDays counts the calendar days from the application start date to the issue date, NULL when the
+// start date is missing or after the issue date.
+func processingDays(applied sql.NullTime, issued time.Time) sql.NullInt64 {
+	if !applied.Valid || issued.IsZero() {
+		return sql.NullInt64{}
+	}
+	start := time.Date(applied.Time.Year(), applied.Time.Month(), applied.Time.Day(), 0, 0, 0, 0, time.UTC)
+	end := time.Date(issued.Year(), issued.Month(), issued.Day(), 0, 0, 0, 0, time.UTC)
+	if end.Before(start) {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(end.Sub(start).Hours() / 24), Valid: true}
+}
+
+// hasLocation reports whether the permit has both coordinates.
+func (b BuildingPermit) hasLocation() bool {
+	_, ok := pointLocation(b.Latitude, b.Longitude)
+	return ok
+}
+
+// located fails when the permit still lacks coordinates or a census tract once geocoding has had its turn.
+func (b BuildingPermit) located() error {
+	var p fieldParser
+	if !b.hasLocation() {
+		p.fail("latitude/longitude", "", "is missing")
+	}
+	if b.CensusTract == "" {
+		p.fail("census_tract", "", "is missing")
+	}
+	return p.err()
+}
+
+// checkInChicago fails when the permit's coordinates, from the source or a geocoder, lie outside Chicago.
+func (b BuildingPermit) checkInChicago() error {
+	var p fieldParser
+	p.inChicago("latitude/longitude", b.Latitude, b.Longitude)
+	return p.err()
+}
+
+// setLocation fills the permit's coordinates from location.
+func (b *BuildingPermit) setLocation(location geocoder.Location) {
+	b.Latitude = sql.NullFloat64{Float64: location.Latitude, Valid: true}
+	b.Longitude = sql.NullFloat64{Float64: location.Longitude, Valid: true}
+}
+
+// LoadBuildingPermits writes the building permits that convert to a located BuildingPermit to store and
+// flushes it. Permits placed outside Chicago are routed to the rejects table instead. When useGeocoding is set, permits without coordinates are forward geocoded from their street
+// address instead of being skipped; coordinates come from shared.DefaultGeocoder. When that is the census
+// provider, every permit address is instead batch geocoded up front, which also fills address_zip and any
+// missing census_tract. Each permit is tagged with its permit_category from the permit taxonomy and its
+// processing_days.
+func LoadBuildingPermits(ctx context.Context, store shared.Store, building_data_list BuildingPermitsJsonRecords, useGeocoding bool) (insertedCount, skippedCount int, err error) {
+	taxonomy, err := LoadPermitTaxonomy()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	batchGeocoding := useGeocoding && shared.GeocoderProvider() == shared.GeocoderCensus
+	var batchMatches map[string]shared.BatchMatch
+	if batchGeocoding {
+		var batchErr error
+		batchMatches, batchErr = shared.CensusBatchGeocode(ctx, permitBatchAddresses(building_data_list))
+		if batchErr != nil {
+			fmt.Printf("Unable to batch geocode building permits: %v\n", batchErr)
+		}
+		fmt.Printf("Census batch geocoder matched %d of %d building permit addresses\n", len(batchMatches), len(building_data_list))
+	}
+
+	skipped := skipTally{}
+	defer skipped.report(BuildingPermitsDataset.Table)
+
+	geocodedCount := 0
+	for _, record := range building_data_list {
+		// Any record that has messy/dirty/missing data we don't enter it in the data lake/table
+		permit, convErr := BuildingPermitFromDTO(record)
+		if convErr != nil {
+			skipped.add(convErr)
+			skippedCount++
+			continue
+		}
+
+		addressZip := ""
+		if match, ok := batchMatches[permit.ID]; ok {
+			addressZip = match.Zip
+			if permit.CensusTract == "" {
+				permit.CensusTract = match.Tract
+			}
+			if !permit.hasLocation() {
+				permit.setLocation(match.Location)
+				geocodedCount++
+			}
+		} else if useGeocoding && !batchGeocoding && !permit.hasLocation() {
+			location, geoErr := shared.ForwardGeocode(ctx, permitAddress(record))
+			switch {
+			case errors.Is(geoErr, shared.ErrGeocoderUnavailable):
+				// Logged once when geocoding was disabled; the permit is skipped below for lack of coordinates.
+			case geoErr != nil:
+				fmt.Printf("Unable to geocode permit %s: %v\n", permit.ID, geoErr)
+			default:
+				permit.setLocation(location)
+				geocodedCount++
+			}
+		}
+
+		if locErr := permit.located(); locErr != nil {
+			skipped.add(locErr)
+			skippedCount++
+			continue
+		}
+		if boundsErr := permit.checkInChicago(); boundsErr != nil {
+			reject(ctx, BuildingPermitsDataset.Table, permit.ID, boundsErr, record, skipped)
+			skippedCount++
+			continue
+		}
+
+		err = store.Insert(
+			ctx,
+			BuildingPermitsDataset,
+			permit.ID,
+			permit.PermitID,
+			permit.PermitType,
+			taxonomy.Category(permit.PermitType),
+			sodaDate(permit.IssueDate),
+			permit.StreetNumber,
+			permit.StreetName,
+			permit.StreetDirection,
+			permit.Suffix,
+			permit.Latitude,
+			permit.Longitude,
+			permit.CommunityArea,
+			permit.CensusTract,
+			addressZip,
+			permit.TotalFee,
+			nullDate(permit.ApplicationStartDate),
+			permit.Ward,
+			permit.ProcessingDays)
+
+		if err != nil {
+			return insertedCount, skippedCount, err
+		}
+		insertedCount++
+
+	}
+
+	if geocodedCount > 0 {
+		fmt.Printf("Filled coordinates for %d building permits from their street address\n", geocodedCount)
+	}
+
+	return insertedCount, skippedCount, store.Flush(ctx, BuildingPermitsDataset)
+}
+
+// permitBatchAddresses lists the street addresses of permits for the Census batch geocoder, keyed by permit id.
+func permitBatchAddresses(records BuildingPermitsJsonRecords) []shared.BatchAddress {
+	addresses := make([]shared.BatchAddress, 0, len(records))
+	for _, record := range records {
+		if record.Id == "" || record.Street_number == "" || record.Street_name == "" {
+			continue
+		}
+		address := permitAddress(record)
+		street := address.Street
+		if address.Number > 0 {
+			street = strconv.Itoa(address.Number) + " " + street
+		}
+		addresses = append(addresses, shared.BatchAddress{ID: record.Id, Street: street, City: address.City, State: address.State})
+	}
+	return addresses
+}
+
+// permitAddress builds the Chicago street address of a permit, e.g. "1234 N CLARK ST, Chicago, IL".
+func permitAddress(record BuildingPermitsJsonRecord) geocoder.Address {
+	street := strings.Join(strings.Fields(strings.Join([]string{record.Street_direction, record.Street_name, record.Suffix}, " ")), " ")
+
+	address := geocoder.Address{
+		Street:  street,
+		City:    "Chicago",
+		State:   "IL",
+		Country: "United States",
+	}
+	if number, err := strconv.Atoi(strings.TrimSpace(record.Street_number)); err == nil {
+		address.Number = number
+	} else {
+		address.Street = strings.TrimSpace(record.Street_number) + " " + street
+	}
+	return address
+}
@@ -0,0 +1,112 @@
+package datasets
+
+import (
+	"context"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// Geography types of the population table.
+const (
+	PopulationZipCode       = "zip_code"
+	PopulationCensusTract   = "census_tract"
+	PopulationCommunityArea = "community_area"
+)
+
+// PopulationRecord is the total population (ACS table B01003) of one geography from the Census Bureau's
+// American Community Survey 5-year estimates. The Census API answers with rows of strings, which the
+// population collector turns into records: ZIP codes are ZIP Code Tabulation Areas and census tracts are
+// 11 digit GEOIDs.
+type PopulationRecord struct {
+	Geography_type string `json:"geography_type" parquet:"geography_type"`
+	Geography_id   string `json:"geography_id" parquet:"geography_id"`
+	Population     string `json:"population" parquet:"population"`
+	ACS_year       string `json:"acs_year" parquet:"acs_year"`
+}
+
+type PopulationRecords []PopulationRecord
+
+var PopulationDataset = shared.Dataset{
+	Table: "population",
+	CreateSQL: `CREATE TABLE IF NOT EXISTS "population" (
+    "id" SERIAL PRIMARY KEY,
+    "geography_type" VARCHAR(16) NOT NULL,
+    "geography_id" VARCHAR(16) NOT NULL,
+    "population" INTEGER NOT NULL,
+    "acs_year" INTEGER NOT NULL,
+    "ingest_run_id" VARCHAR(32),
+    CONSTRAINT population_unique_geography UNIQUE ("geography_type", "geography_id")
+);`,
+	InsertSQL: `INSERT INTO population ("geography_type", "geography_id", "population", "acs_year", "ingest_run_id")
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT ("geography_type", "geography_id") DO UPDATE
+			SET population = EXCLUDED.population,
+				acs_year = EXCLUDED.acs_year,
+				ingest_run_id = EXCLUDED.ingest_run_id;`,
+	Columns: []shared.Column{
+		{Name: "geography_type", Type: shared.ColumnString},
+		{Name: "geography_id", Type: shared.ColumnString},
+		{Name: "population", Type: shared.ColumnInteger},
+		{Name: "acs_year", Type: shared.ColumnInteger},
+		{Name: shared.IngestRunIDColumn, Type: shared.ColumnString},
+	},
+	DiffKey:     []string{"geography_type", "geography_id"},
+	DiffColumns: []string{"population", "acs_year"},
+	RecordBytes: 256,
+}
+
+// PopulationArea is the ACS population estimate of a ZIP code, census tract, or community area.
+type PopulationArea struct {
+	GeographyType string
+	GeographyID   string
+	Population    int64
+	ACSYear       int64
+}
+
+// PopulationAreaFromDTO converts a population record, failing when its geography is missing or unknown or
+// its population or year is not a whole number. The Census API marks unavailable estimates with negative
+// sentinels, such as -666666666, which fail as negative.
+func PopulationAreaFromDTO(record PopulationRecord) (PopulationArea, error) {
+	var p fieldParser
+	area := PopulationArea{
+		GeographyType: p.required("geography_type", record.Geography_type),
+		GeographyID:   p.required("geography_id", record.Geography_id),
+		Population:    p.count("population", record.Population),
+		ACSYear:       p.count("acs_year", record.ACS_year),
+	}
+	switch area.GeographyType {
+	case "", PopulationZipCode, PopulationCensusTract, PopulationCommunityArea:
+	default:
+		p.fail("geography_type", area.GeographyType, "is not a known geography")
+	}
+	return area, p.err()
+}
+
+// LoadPopulation writes the population records that convert to a PopulationArea to store and flushes it.
+func LoadPopulation(ctx context.Context, store shared.Store, population_data_list PopulationRecords) (insertedCount, skippedCount int, err error) {
+	skipped := skipTally{}
+	defer skipped.report(PopulationDataset.Table)
+
+	for _, record := range population_data_list {
+		area, convErr := PopulationAreaFromDTO(record)
+		if convErr != nil {
+			skipped.add(convErr)
+			skippedCount++
+			continue
+		}
+
+		err = store.Insert(ctx, PopulationDataset,
+			area.GeographyType,
+			area.GeographyID,
+			area.Population,
+			area.ACSYear,
+		)
+
+		if err != nil {
+			return insertedCount, skippedCount, err
+		}
+		insertedCount++
+	}
+
+	return insertedCount, skippedCount, store.Flush(ctx, PopulationDataset)
+}
@@ -0,0 +1,181 @@
+package datasets
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+const (
+	// PublicHealthSourcePeriodEnvKey overrides the census period the public health indicators describe,
+	// for when the city republishes the dataset with newer estimates.
+	PublicHealthSourcePeriodEnvKey = "PUBLIC_HEALTH_SOURCE_PERIOD"
+	// DefaultPublicHealthSourcePeriod is the ACS 5-year period of the published poverty, unemployment, and
+	// income indicators.
+	DefaultPublicHealthSourcePeriod = "2008-2012"
+)
+
+// PublicHealthVintage identifies one version of the public health indicators: the census period they
+// describe and the day they were retrieved.
+type PublicHealthVintage struct {
+	SourcePeriod string
+	Retrieved    time.Time
+}
+
+// NewPublicHealthVintage returns the vintage of indicators retrieved on retrieved, with the source period
+// from PUBLIC_HEALTH_SOURCE_PERIOD.
+func NewPublicHealthVintage(retrieved time.Time) PublicHealthVintage {
+	period := strings.TrimSpace(os.Getenv(PublicHealthSourcePeriodEnvKey))
+	if period == "" {
+		period = DefaultPublicHealthSourcePeriod
+	}
+	return PublicHealthVintage{SourcePeriod: period, Retrieved: retrieved}
+}
+
+// UnemploymentJsonRecord is a SODA public health record. The indicators are pointers so a value SODA leaves
+// out, which is nil, is told apart from a rate of exactly 0.
+type UnemploymentJsonRecord struct {
+	Community_area      string   `json:"community_area" parquet:"community_area"`
+	Below_poverty_level *float64 `json:"below_poverty_level,string" parquet:"below_poverty_level"`
+	Unemployment        *float64 `json:"unemployment,string" parquet:"unemployment"`
+	Per_capita_income   *float64 `json:"per_capita_income,string" parquet:"per_capita_income"`
+}
+
+type UnemploymentJsonRecords []UnemploymentJsonRecord
+
+var PublicHealthSource = shared.SODASource{
+	Name:   "public_health",
+	ID:     "iqnk-2tcu",
+	Record: UnemploymentJsonRecord{},
+	Select: []string{"community_area", "below_poverty_level", "unemployment", "per_capita_income"},
+}
+
+var PublicHealthDataset = shared.Dataset{
+	Table: "public_health",
+	CreateSQL: `CREATE TABLE IF NOT EXISTS "public_health" (
+		"community_area" VARCHAR(2) PRIMARY KEY,
+		"below_poverty_level" FLOAT8,
+		"unemployment" FLOAT8,
+		"per_capita_income" FLOAT8,
+		"source_period" VARCHAR(32),
+		"date_retrieved" DATE,
+		"ingest_run_id" VARCHAR(32)
+	);`,
+	InsertSQL: `INSERT INTO public_health ("community_area", "below_poverty_level", "unemployment", "per_capita_income", "source_period", "date_retrieved", "ingest_run_id")
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT ("community_area") DO UPDATE
+			SET below_poverty_level = EXCLUDED.below_poverty_level,
+				unemployment = EXCLUDED.unemployment,
+				per_capita_income = EXCLUDED.per_capita_income,
+				source_period = EXCLUDED.source_period,
+				date_retrieved = EXCLUDED.date_retrieved,
+				ingest_run_id = EXCLUDED.ingest_run_id;`,
+	Columns:     publicHealthColumns,
+	DiffKey:     []string{"community_area"},
+	DiffColumns: []string{"below_poverty_level", "unemployment", "per_capita_income", "source_period"},
+	RecordBytes: 512,
+}
+
+// PublicHealthVersionsDataset keeps every retrieved vintage of the public health indicators. Unlike
+// public_health, which holds the latest pull, it is never reset, so reports can pin an earlier vintage.
+var PublicHealthVersionsDataset = shared.Dataset{
+	Table: "public_health_versions",
+	CreateSQL: `CREATE TABLE IF NOT EXISTS "public_health_versions" (
+		"community_area" VARCHAR(2) NOT NULL,
+		"below_poverty_level" FLOAT8,
+		"unemployment" FLOAT8,
+		"per_capita_income" FLOAT8,
+		"source_period" VARCHAR(32) NOT NULL,
+		"date_retrieved" DATE NOT NULL,
+		"ingest_run_id" VARCHAR(32),
+		PRIMARY KEY ("source_period", "date_retrieved", "community_area")
+	);`,
+	InsertSQL: `INSERT INTO public_health_versions ("community_area", "below_poverty_level", "unemployment", "per_capita_income", "source_period", "date_retrieved", "ingest_run_id")
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT ("source_period", "date_retrieved", "community_area") DO UPDATE
+			SET below_poverty_level = EXCLUDED.below_poverty_level,
+				unemployment = EXCLUDED.unemployment,
+				per_capita_income = EXCLUDED.per_capita_income,
+				ingest_run_id = EXCLUDED.ingest_run_id;`,
+	Columns: publicHealthColumns,
+}
+
+var publicHealthColumns = []shared.Column{
+	{Name: "community_area", Type: shared.ColumnString},
+	{Name: "below_poverty_level", Type: shared.ColumnFloat},
+	{Name: "unemployment", Type: shared.ColumnFloat},
+	{Name: "per_capita_income", Type: shared.ColumnFloat},
+	{Name: "source_period", Type: shared.ColumnString},
+	{Name: "date_retrieved", Type: shared.ColumnDate},
+	{Name: shared.IngestRunIDColumn, Type: shared.ColumnString},
+}
+
+// PublicHealthArea is the poverty, unemployment, and income indicators of a community area. Per capita
+// income is NULL when the city did not publish it.
+type PublicHealthArea struct {
+	CommunityArea     string
+	BelowPovertyLevel float64
+	Unemployment      float64
+	PerCapitaIncome   sql.NullFloat64
+}
+
+// PublicHealthAreaFromDTO converts a SODA public health record, failing when the community area, the
+// poverty rate, or the unemployment rate is missing or an indicator is negative. Rates of 0 are valid.
+func PublicHealthAreaFromDTO(record UnemploymentJsonRecord) (PublicHealthArea, error) {
+	var p fieldParser
+	area := PublicHealthArea{
+		CommunityArea:     p.required("community_area", record.Community_area),
+		BelowPovertyLevel: p.nonNegative("below_poverty_level", p.requiredNumber("below_poverty_level", record.Below_poverty_level)),
+		Unemployment:      p.nonNegative("unemployment", p.requiredNumber("unemployment", record.Unemployment)),
+		PerCapitaIncome:   nullNumber(record.Per_capita_income),
+	}
+	p.nonNegative("per_capita_income", area.PerCapitaIncome.Float64)
+	return area, p.err()
+}
+
+// LoadPublicHealth writes the community area health indicators of one vintage that convert to a
+// PublicHealthArea to store and flushes it. The rows are also added to public_health_versions in the same
+// store, alongside earlier vintages.
+func LoadPublicHealth(ctx context.Context, store shared.Store, unemployment_data_list UnemploymentJsonRecords, vintage PublicHealthVintage) (insertedCount, skippedCount int, err error) {
+	if err := store.Ensure(ctx, PublicHealthVersionsDataset); err != nil {
+		return 0, 0, err
+	}
+	retrieved := sodaDate(vintage.Retrieved)
+	skipped := skipTally{}
+	defer skipped.report(PublicHealthDataset.Table)
+
+	for _, record := range unemployment_data_list {
+		area, convErr := PublicHealthAreaFromDTO(record)
+		if convErr != nil {
+			skipped.add(convErr)
+			skippedCount++
+			continue
+		}
+
+		values := []any{
+			area.CommunityArea,
+			area.BelowPovertyLevel,
+			area.Unemployment,
+			area.PerCapitaIncome,
+			vintage.SourcePeriod,
+			retrieved,
+		}
+
+		if err = store.Insert(ctx, PublicHealthDataset, values...); err != nil {
+			return insertedCount, skippedCount, err
+		}
+		if err = store.Insert(ctx, PublicHealthVersionsDataset, values...); err != nil {
+			return insertedCount, skippedCount, err
+		}
+		insertedCount++
+	}
+
+	if err := store.Flush(ctx, PublicHealthDataset); err != nil {
+		return insertedCount, skippedCount, err
+	}
+	return insertedCount, skippedCount, store.Flush(ctx, PublicHealthVersionsDataset)
+}
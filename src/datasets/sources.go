@@ -0,0 +1,38 @@
+package datasets
+
+import "github.com/ahbreck/Chicago_BI/shared"
+
+// SODASources lists every upstream Socrata dataset the collectors decode, for schema drift checks and the
+// startup check of their $select lists.
+var SODASources = []shared.SODASource{
+	CCVISource,
+	CovidSource,
+	CovidRespiratorySource,
+	PublicHealthSource,
+	BuildingPermitsSource,
+	TaxiTripsSource,
+	TNPTripsSource,
+	CTARailSource,
+	CTABusSource,
+	CTAStopsSource,
+	VacantBuildingsSource,
+	FoodInspectionsSource,
+	BusinessLicensesSource,
+}
+
+// ManagedDatasets lists every collector output table, for checks that compare the database with the code.
+var ManagedDatasets = []shared.Dataset{
+	BuildingPermitsDataset,
+	BusinessLicensesDataset,
+	CCVIDataset,
+	CovidDataset,
+	CovidRespiratoryDataset,
+	CTARidershipDataset,
+	FoodInspectionsDataset,
+	PopulationDataset,
+	PublicHealthDataset,
+	PublicHealthVersionsDataset,
+	TaxiTripsDataset,
+	VacantBuildingsDataset,
+	WeatherDataset,
+}
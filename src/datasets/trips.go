@@ -0,0 +1,485 @@
+package datasets
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kelvins/geocoder"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+type TripRecord struct {
+	Trip_id                    string `json:"trip_id" parquet:"trip_id"`
+	Trip_start_timestamp       string `json:"trip_start_timestamp" parquet:"trip_start_timestamp"`
+	Trip_end_timestamp         string `json:"trip_end_timestamp" parquet:"trip_end_timestamp"`
+	Pickup_community_area      string `json:"pickup_community_area" parquet:"pickup_community_area"`
+	Dropoff_community_area     string `json:"dropoff_community_area" parquet:"dropoff_community_area"`
+	Pickup_centroid_latitude   string `json:"pickup_centroid_latitude" parquet:"pickup_centroid_latitude"`
+	Pickup_centroid_longitude  string `json:"pickup_centroid_longitude" parquet:"pickup_centroid_longitude"`
+	Dropoff_centroid_latitude  string `json:"dropoff_centroid_latitude" parquet:"dropoff_centroid_latitude"`
+	Dropoff_centroid_longitude string `json:"dropoff_centroid_longitude" parquet:"dropoff_centroid_longitude"`
+	Fare                       string `json:"fare" parquet:"fare"`
+	// Tips is named tip in the rideshare dataset, which TNPTripsSource selects as tips.
+	Tips       string `json:"tips" parquet:"tips" soda_alias:"tip"`
+	Trip_total string `json:"trip_total" parquet:"trip_total"`
+}
+
+var TaxiTripsSource = shared.SODASource{
+	Name:   "taxi_trips",
+	ID:     "wrvz-psew",
+	Record: TripRecord{},
+	Select: []string{
+		"trip_id", "trip_start_timestamp", "trip_end_timestamp", "pickup_community_area", "dropoff_community_area",
+		"pickup_centroid_latitude", "pickup_centroid_longitude", "dropoff_centroid_latitude",
+		"dropoff_centroid_longitude", "fare", "tips", "trip_total",
+	},
+}
+
+// TNPTripsSource is the rideshare trips dataset, which calls the tips column tip.
+var TNPTripsSource = shared.SODASource{
+	Name:   "tnp_trips",
+	ID:     "m6dm-c72p",
+	Record: TripRecord{},
+	Select: []string{
+		"trip_id", "trip_start_timestamp", "trip_end_timestamp", "pickup_community_area", "dropoff_community_area",
+		"pickup_centroid_latitude", "pickup_centroid_longitude", "dropoff_centroid_latitude",
+		"dropoff_centroid_longitude", "fare", "tip AS tips", "trip_total",
+	},
+}
+
+var TaxiTripsDataset = shared.Dataset{
+	Table: "taxi_trips",
+	CreateSQL: `CREATE TABLE IF NOT EXISTS "taxi_trips" (
+						"id"   SERIAL , 
+						"trip_id" VARCHAR(255) UNIQUE, 
+						"trip_start_timestamp" TIMESTAMP WITH TIME ZONE, 
+						"trip_end_timestamp" TIMESTAMP WITH TIME ZONE, 
+						"pickup_centroid_latitude" DOUBLE PRECISION, 
+						"pickup_centroid_longitude" DOUBLE PRECISION, 
+						"dropoff_centroid_latitude" DOUBLE PRECISION, 
+						"dropoff_centroid_longitude" DOUBLE PRECISION, 
+						"pickup_community_area" VARCHAR(2),
+						"dropoff_community_area" VARCHAR(2),
+						"pickup_zip_code" VARCHAR(9), 
+						"dropoff_zip_code" VARCHAR(9), 
+						"trip_type" VARCHAR(50),
+						"fare" DOUBLE PRECISION,
+						"tips" DOUBLE PRECISION,
+						"trip_total" DOUBLE PRECISION,
+						"ingest_run_id" VARCHAR(32),
+						PRIMARY KEY ("id") 
+					);`,
+	InsertSQL: `INSERT INTO taxi_trips ("trip_id", "trip_start_timestamp", "trip_end_timestamp", "pickup_centroid_latitude", "pickup_centroid_longitude", "dropoff_centroid_latitude", "dropoff_centroid_longitude", "pickup_community_area", "dropoff_community_area", "pickup_zip_code", 
+			"dropoff_zip_code", "trip_type", "fare", "tips", "trip_total", "ingest_run_id") values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+			ON CONFLICT (trip_id) DO NOTHING`,
+	Columns: []shared.Column{
+		{Name: "trip_id", Type: shared.ColumnString},
+		{Name: "trip_start_timestamp", Type: shared.ColumnTimestamp},
+		{Name: "trip_end_timestamp", Type: shared.ColumnTimestamp},
+		{Name: "pickup_centroid_latitude", Type: shared.ColumnFloat},
+		{Name: "pickup_centroid_longitude", Type: shared.ColumnFloat},
+		{Name: "dropoff_centroid_latitude", Type: shared.ColumnFloat},
+		{Name: "dropoff_centroid_longitude", Type: shared.ColumnFloat},
+		{Name: "pickup_community_area", Type: shared.ColumnString},
+		{Name: "dropoff_community_area", Type: shared.ColumnString},
+		{Name: "pickup_zip_code", Type: shared.ColumnString},
+		{Name: "dropoff_zip_code", Type: shared.ColumnString},
+		{Name: "trip_type", Type: shared.ColumnString},
+		{Name: "fare", Type: shared.ColumnFloat},
+		{Name: "tips", Type: shared.ColumnFloat},
+		{Name: "trip_total", Type: shared.ColumnFloat},
+		{Name: shared.IngestRunIDColumn, Type: shared.ColumnString},
+	},
+	RecordBytes: 1536,
+}
+
+const (
+	// TripValidateWorkersEnvKey, TripGeocodeWorkersEnvKey, and TripInsertWorkersEnvKey set the number of
+	// goroutines in each stage of the trips load.
+	TripValidateWorkersEnvKey = "TRIP_VALIDATE_WORKERS"
+	TripGeocodeWorkersEnvKey  = "TRIP_GEOCODE_WORKERS"
+	TripInsertWorkersEnvKey   = "TRIP_INSERT_WORKERS"
+	// TripPipelineBufferEnvKey sets the capacity of the channels between stages. A full channel blocks the
+	// stage feeding it, so a slow stage holds back the ones before it instead of piling up trips in memory.
+	TripPipelineBufferEnvKey = "TRIP_PIPELINE_BUFFER"
+
+	defaultTripValidateWorkers = 2
+	defaultTripGeocodeWorkers  = 8
+	defaultTripInsertWorkers   = 4
+	defaultTripPipelineBuffer  = 500
+)
+
+// TripPipeline sizes the validate, geocode, and insert stages of a trips load. The stages run
+// concurrently, connected by bounded channels, so geocoding requests and database inserts overlap.
+type TripPipeline struct {
+	ValidateWorkers int
+	GeocodeWorkers  int
+	InsertWorkers   int
+	Buffer          int
+}
+
+// TripPipelineFromEnv reads the stage sizes from TRIP_VALIDATE_WORKERS, TRIP_GEOCODE_WORKERS,
+// TRIP_INSERT_WORKERS, and TRIP_PIPELINE_BUFFER.
+func TripPipelineFromEnv() TripPipeline {
+	return TripPipeline{
+		ValidateWorkers: tripPipelineSize(TripValidateWorkersEnvKey, defaultTripValidateWorkers),
+		GeocodeWorkers:  tripPipelineSize(TripGeocodeWorkersEnvKey, defaultTripGeocodeWorkers),
+		InsertWorkers:   tripPipelineSize(TripInsertWorkersEnvKey, defaultTripInsertWorkers),
+		Buffer:          tripPipelineSize(TripPipelineBufferEnvKey, defaultTripPipelineBuffer),
+	}
+}
+
+func tripPipelineSize(key string, fallback int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		log.Printf("invalid %s value %q; defaulting to %d", key, raw, fallback)
+		return fallback
+	}
+	return n
+}
+
+// Trip is one taxi or rideshare trip. The portal leaves out the centroid of a pickup or dropoff it cannot
+// place, such as one outside Chicago, so either point may be NULL.
+type Trip struct {
+	ID                   string
+	Start                time.Time
+	End                  time.Time
+	PickupLatitude       sql.NullFloat64
+	PickupLongitude      sql.NullFloat64
+	DropoffLatitude      sql.NullFloat64
+	DropoffLongitude     sql.NullFloat64
+	PickupCommunityArea  sql.NullString
+	DropoffCommunityArea sql.NullString
+	// Fare, Tips, and TripTotal are in dollars, NULL where the portal leaves them out.
+	Fare      sql.NullFloat64
+	Tips      sql.NullFloat64
+	TripTotal sql.NullFloat64
+}
+
+// PickupLocation returns the pickup centroid, reporting false when it is NULL.
+func (t Trip) PickupLocation() (geocoder.Location, bool) {
+	return pointLocation(t.PickupLatitude, t.PickupLongitude)
+}
+
+// DropoffLocation returns the dropoff centroid, reporting false when it is NULL.
+func (t Trip) DropoffLocation() (geocoder.Location, bool) {
+	return pointLocation(t.DropoffLatitude, t.DropoffLongitude)
+}
+
+// TripFromDTO converts a SODA trip, failing when its id or either timestamp is missing or malformed or it
+// has neither a pickup nor a dropoff community area, or its fare, tips, or total is not a number. Missing or
+// unparsable centroids are NULL.
+func TripFromDTO(record TripRecord) (Trip, error) {
+	var p fieldParser
+	trip := Trip{
+		ID:                   p.required("trip_id", record.Trip_id),
+		Start:                p.time("trip_start_timestamp", record.Trip_start_timestamp),
+		End:                  p.time("trip_end_timestamp", record.Trip_end_timestamp),
+		PickupCommunityArea:  nullString(record.Pickup_community_area),
+		DropoffCommunityArea: nullString(record.Dropoff_community_area),
+		Fare:                 p.nullFloat("fare", record.Fare),
+		Tips:                 p.nullFloat("tips", record.Tips),
+		TripTotal:            p.nullFloat("trip_total", record.Trip_total),
+	}
+	trip.PickupLatitude, trip.PickupLongitude = nullPoint(record.Pickup_centroid_latitude, record.Pickup_centroid_longitude)
+	trip.DropoffLatitude, trip.DropoffLongitude = nullPoint(record.Dropoff_centroid_latitude, record.Dropoff_centroid_longitude)
+	if !trip.PickupCommunityArea.Valid && !trip.DropoffCommunityArea.Valid {
+		p.fail("pickup/dropoff_community_area", "", "is missing")
+	}
+	return trip, p.err()
+}
+
+// checkInChicago fails when either centroid lies outside Chicago.
+func (t Trip) checkInChicago() error {
+	var p fieldParser
+	p.inChicago("pickup_centroid", t.PickupLatitude, t.PickupLongitude)
+	p.inChicago("dropoff_centroid", t.DropoffLatitude, t.DropoffLongitude)
+	return p.err()
+}
+
+// tripRow is a validated trip on its way to the insert stage.
+type tripRow struct {
+	Trip
+	pickupZipCode  string
+	dropoffZipCode string
+}
+
+// LoadTrips writes the usable trips of one trip type to store and flushes it, running them through a
+// TripPipeline sized from the environment. Insert failures for individual trips are logged and skipped
+// so one bad row does not abort the whole pull.
+// When useGeocoding is set, ZIP codes come from shared.DefaultGeocoder.
+func LoadTrips(ctx context.Context, store shared.Store, tripType string, taxi_trips_list []TripRecord, useGeocoding bool) (insertedCount, skippedCount int, err error) {
+	pipeline := TripPipelineFromEnv()
+	records := make(chan TripRecord, pipeline.Buffer)
+	go func() {
+		defer close(records)
+		for _, record := range taxi_trips_list {
+			select {
+			case records <- record:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return pipeline.Load(ctx, store, tripType, records, useGeocoding)
+}
+
+// Load validates the trips received on records, assigns their ZIP codes, and inserts them into store,
+// each step in its own stage of workers, then flushes the store. It returns once records is closed and
+// every trip has been handled, or early with ctx's error; whoever sends on records must stop when ctx
+// is done.
+func (p TripPipeline) Load(ctx context.Context, store shared.Store, tripType string, records <-chan TripRecord, useGeocoding bool) (insertedCount, skippedCount int, err error) {
+	// The crosswalk is loaded even with geocoding, as the fallback for when the geocoder is unavailable.
+	communityZipMap, err := loadCommunityAreaZipCodes()
+	if err != nil {
+		fmt.Printf("Unable to load community area ZIP code mapping, defaulting to empty values: %v\n", err)
+	}
+
+	var inserted, skipped, missingPoints atomic.Int64
+	defer func() { reportMissingPoints(tripType+" trip", int(missingPoints.Load())) }()
+	var skipReasonsMu sync.Mutex
+	skipReasons := skipTally{}
+	defer skipReasons.report(tripType + " trip")
+
+	validated := make(chan tripRow, p.Buffer)
+	go runTripStage(p.ValidateWorkers, func() {
+		for record := range records {
+			row, convErr := validateTrip(ctx, record)
+			if convErr != nil {
+				skipped.Add(1)
+				skipReasonsMu.Lock()
+				skipReasons.add(convErr)
+				skipReasonsMu.Unlock()
+				continue
+			}
+			select {
+			case validated <- row:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}, func() { close(validated) })
+
+	located := make(chan tripRow, p.Buffer)
+	go runTripStage(p.GeocodeWorkers, func() {
+		for row := range validated {
+			if useGeocoding {
+				geocodeTrip(ctx, &row, communityZipMap)
+			} else {
+				row.pickupZipCode = communityZipMap[row.PickupCommunityArea.String]
+				row.dropoffZipCode = communityZipMap[row.DropoffCommunityArea.String]
+			}
+			select {
+			case located <- row:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}, func() { close(located) })
+
+	runTripStage(p.InsertWorkers, func() {
+		for row := range located {
+			err := store.Insert(
+				ctx,
+				TaxiTripsDataset,
+				row.ID,
+				sodaTimestamp(row.Start),
+				sodaTimestamp(row.End),
+				row.PickupLatitude,
+				row.PickupLongitude,
+				row.DropoffLatitude,
+				row.DropoffLongitude,
+				row.PickupCommunityArea,
+				row.DropoffCommunityArea,
+				row.pickupZipCode,
+				row.dropoffZipCode,
+				tripType,
+				row.Fare,
+				row.Tips,
+				row.TripTotal)
+
+			if err != nil {
+				fmt.Printf("Error inserting %s trip %s: %v\n", tripType, row.ID, err)
+				continue
+			}
+			inserted.Add(1)
+			_, hasPickup := row.PickupLocation()
+			_, hasDropoff := row.DropoffLocation()
+			if !hasPickup || !hasDropoff {
+				missingPoints.Add(1)
+			}
+		}
+	}, nil)
+
+	insertedCount, skippedCount = int(inserted.Load()), int(skipped.Load())
+	if err := ctx.Err(); err != nil {
+		return insertedCount, skippedCount, err
+	}
+	return insertedCount, skippedCount, store.Flush(ctx, TaxiTripsDataset)
+}
+
+// runTripStage runs work on workers goroutines and calls closeOut, when set, once all of them return.
+func runTripStage(workers int, work func(), closeOut func()) {
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work()
+		}()
+	}
+	wg.Wait()
+	if closeOut != nil {
+		closeOut()
+	}
+}
+
+// validateTrip converts a SODA trip into a tripRow, failing for trips too messy to load. Trips placed
+// outside Chicago are also routed to the rejects table, so they never get a ZIP code.
+func validateTrip(ctx context.Context, record TripRecord) (tripRow, error) {
+	// We will execute defensive coding to check for messy/dirty/missing data values
+	// Any record that has messy/dirty/missing data we don't enter it in the data lake/table
+	fmt.Printf("record: %+v\n", record)
+
+	trip, err := TripFromDTO(record)
+	if err != nil {
+		return tripRow{}, err
+	}
+	if err := trip.checkInChicago(); err != nil {
+		shared.RecordReject(ctx, TaxiTripsDataset.Table, trip.ID, err.Error(), record)
+		return tripRow{}, err
+	}
+	return tripRow{Trip: trip}, nil
+}
+
+// geocodeTrip fills the trip's ZIP codes from its pickup and dropoff centroids, leaving a ZIP code
+// empty when it cannot be resolved. A point without a centroid, or any point while the geocoder is
+// unavailable, takes the ZIP code of its community area through communityZipMap instead.
+func geocodeTrip(ctx context.Context, row *tripRow, communityZipMap map[string]string) {
+	row.pickupZipCode = geocodeTripPoint(ctx, row.ID, "pickup", row.PickupLocation, row.PickupCommunityArea, communityZipMap)
+	row.dropoffZipCode = geocodeTripPoint(ctx, row.ID, "dropoff", row.DropoffLocation, row.DropoffCommunityArea, communityZipMap)
+}
+
+// geocodeTripPoint returns the ZIP code of one end of a trip.
+func geocodeTripPoint(ctx context.Context, tripID, end string, point func() (geocoder.Location, bool), communityArea sql.NullString, communityZipMap map[string]string) string {
+	location, ok := point()
+	if !ok {
+		return communityZipMap[communityArea.String]
+	}
+	zip, geoErr := shared.ReverseGeocodeZip(ctx, location)
+	if errors.Is(geoErr, shared.ErrGeocoderUnavailable) {
+		return communityZipMap[communityArea.String]
+	} else if geoErr != nil {
+		fmt.Printf("Unable to reverse geocode %s of trip %s: %v\n", end, tripID, geoErr)
+	}
+	return zip
+}
+
+// findCommunityZipDataPath walks up from the current working directory until it finds the community area to
+// ZIP code CSV of the city profile.
+func findCommunityZipDataPath() (string, error) {
+	relPath := shared.CrosswalkPath("", "community_area_to_zip_code.csv")
+
+	seen := map[string]struct{}{}
+	searchFrom := func(start string) (string, bool) {
+		if start == "" {
+			return "", false
+		}
+		if _, ok := seen[start]; ok {
+			return "", false
+		}
+		seen[start] = struct{}{}
+
+		dir := start
+		for {
+			candidate := filepath.Join(dir, relPath)
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate, true
+			}
+
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+
+		return "", false
+	}
+
+	if cwd, err := os.Getwd(); err == nil {
+		if path, ok := searchFrom(cwd); ok {
+			return path, nil
+		}
+	}
+
+	if exe, err := os.Executable(); err == nil {
+		if path, ok := searchFrom(filepath.Dir(exe)); ok {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("could not locate %s", relPath)
+}
+
+// loadCommunityAreaZipCodes reads the community area to ZIP code mapping.
+func loadCommunityAreaZipCodes() (map[string]string, error) {
+	csvPath, err := findCommunityZipDataPath()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(csvPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open community area zip code file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read community area zip code file: %w", err)
+	}
+
+	areaZipMap := make(map[string]string, len(records))
+	for i, row := range records {
+		if len(row) < 2 {
+			continue
+		}
+		communityArea := strings.TrimSpace(row[0])
+		zip := strings.TrimSpace(row[1])
+
+		if i == 0 && strings.EqualFold(communityArea, "community_area") {
+			continue
+		}
+
+		if communityArea == "" || zip == "" {
+			continue
+		}
+
+		areaZipMap[communityArea] = zip
+	}
+
+	if len(areaZipMap) == 0 {
+		return nil, fmt.Errorf("no community area zip codes found in %s", csvPath)
+	}
+
+	return areaZipMap, nil
+}
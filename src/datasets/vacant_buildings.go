@@ -0,0 +1,140 @@
+package datasets
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// VacantBuildingsServiceType selects the vacant and abandoned building complaints among the 311 service
+// requests.
+const VacantBuildingsServiceType = "Vacant/Abandoned Building Complaint"
+
+type VacantBuildingRecord struct {
+	Sr_number      string `json:"sr_number" parquet:"sr_number"`
+	Status         string `json:"status" parquet:"status"`
+	Created_date   string `json:"created_date" parquet:"created_date"`
+	Street_address string `json:"street_address" parquet:"street_address"`
+	Zip_code       string `json:"zip_code" parquet:"zip_code"`
+	Community_area string `json:"community_area" parquet:"community_area"`
+	Latitude       string `json:"latitude" parquet:"latitude"`
+	Longitude      string `json:"longitude" parquet:"longitude"`
+}
+
+type VacantBuildingRecords []VacantBuildingRecord
+
+var VacantBuildingsSource = shared.SODASource{
+	Name:   "vacant_buildings",
+	ID:     "v6vf-nfxy",
+	Record: VacantBuildingRecord{},
+	Select: []string{
+		"sr_number", "status", "created_date", "street_address", "zip_code", "community_area", "latitude",
+		"longitude",
+	},
+}
+
+var VacantBuildingsDataset = shared.Dataset{
+	Table: "vacant_buildings",
+	CreateSQL: `CREATE TABLE IF NOT EXISTS "vacant_buildings" (
+    "sr_number" VARCHAR(32) PRIMARY KEY,
+    "status" VARCHAR(32),
+    "created_date" TIMESTAMP,
+    "street_address" VARCHAR(255),
+    "zip_code" VARCHAR(9),
+    "community_area" VARCHAR(2),
+    "latitude" FLOAT8,
+    "longitude" FLOAT8,
+    "ingest_run_id" VARCHAR(32)
+);`,
+	InsertSQL: `INSERT INTO vacant_buildings ("sr_number", "status", "created_date", "street_address", "zip_code", "community_area", "latitude", "longitude", "ingest_run_id")
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT ("sr_number") DO UPDATE
+			SET status = EXCLUDED.status,
+				street_address = EXCLUDED.street_address,
+				zip_code = EXCLUDED.zip_code,
+				community_area = EXCLUDED.community_area,
+				latitude = EXCLUDED.latitude,
+				longitude = EXCLUDED.longitude,
+				ingest_run_id = EXCLUDED.ingest_run_id;`,
+	Columns: []shared.Column{
+		{Name: "sr_number", Type: shared.ColumnString},
+		{Name: "status", Type: shared.ColumnString},
+		{Name: "created_date", Type: shared.ColumnTimestamp},
+		{Name: "street_address", Type: shared.ColumnString},
+		{Name: "zip_code", Type: shared.ColumnString},
+		{Name: "community_area", Type: shared.ColumnString},
+		{Name: "latitude", Type: shared.ColumnFloat},
+		{Name: "longitude", Type: shared.ColumnFloat},
+		{Name: shared.IngestRunIDColumn, Type: shared.ColumnString},
+	},
+	RecordBytes: 1024,
+}
+
+// VacantBuilding is a 311 report of a vacant or abandoned building.
+type VacantBuilding struct {
+	SRNumber      string
+	Status        string
+	CreatedDate   time.Time
+	StreetAddress string
+	ZIPCode       sql.NullString
+	CommunityArea string
+	Latitude      sql.NullFloat64
+	Longitude     sql.NullFloat64
+}
+
+// VacantBuildingFromDTO converts a SODA 311 vacant building report, failing when its service request
+// number, creation date, or community area is missing. Reports without a community area cannot feed the
+// community area reports. Missing or unparsable coordinates are NULL.
+func VacantBuildingFromDTO(record VacantBuildingRecord) (VacantBuilding, error) {
+	var p fieldParser
+	building := VacantBuilding{
+		SRNumber:      p.required("sr_number", record.Sr_number),
+		Status:        record.Status,
+		CreatedDate:   p.time("created_date", record.Created_date),
+		StreetAddress: record.Street_address,
+		ZIPCode:       nullString(record.Zip_code),
+		CommunityArea: p.required("community_area", record.Community_area),
+	}
+	building.Latitude, building.Longitude = nullPoint(record.Latitude, record.Longitude)
+	return building, p.err()
+}
+
+// LoadVacantBuildings writes the vacant building reports that convert to a VacantBuilding to store and
+// flushes it.
+func LoadVacantBuildings(ctx context.Context, store shared.Store, vacant_data_list VacantBuildingRecords) (insertedCount, skippedCount int, err error) {
+	skipped := skipTally{}
+	defer skipped.report(VacantBuildingsDataset.Table)
+	missingPoints := 0
+	defer func() { reportMissingPoints(VacantBuildingsDataset.Table, missingPoints) }()
+
+	for _, record := range vacant_data_list {
+		building, convErr := VacantBuildingFromDTO(record)
+		if convErr != nil {
+			skipped.add(convErr)
+			skippedCount++
+			continue
+		}
+		if _, ok := pointLocation(building.Latitude, building.Longitude); !ok {
+			missingPoints++
+		}
+
+		err = store.Insert(ctx, VacantBuildingsDataset,
+			building.SRNumber,
+			building.Status,
+			sodaTimestamp(building.CreatedDate),
+			building.StreetAddress,
+			building.ZIPCode,
+			building.CommunityArea,
+			building.Latitude,
+			building.Longitude,
+		)
+		if err != nil {
+			return insertedCount, skippedCount, err
+		}
+		insertedCount++
+	}
+
+	return insertedCount, skippedCount, store.Flush(ctx, VacantBuildingsDataset)
+}
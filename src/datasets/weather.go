@@ -0,0 +1,113 @@
+package datasets
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ahbreck/Chicago_BI/shared"
+)
+
+// WeatherRecord is one day of the NOAA NCEI daily summaries for a weather station, requested with
+// units=standard (degrees Fahrenheit and inches). NCEI serializes values as padded strings and leaves out
+// the data types a station did not report that day.
+type WeatherRecord struct {
+	Station       string `json:"STATION" parquet:"station"`
+	Date          string `json:"DATE" parquet:"date"`
+	MaxTemp       string `json:"TMAX" parquet:"tmax"`
+	MinTemp       string `json:"TMIN" parquet:"tmin"`
+	Precipitation string `json:"PRCP" parquet:"prcp"`
+	Snowfall      string `json:"SNOW" parquet:"snow"`
+}
+
+type WeatherRecords []WeatherRecord
+
+var WeatherDataset = shared.Dataset{
+	Table: "weather_daily",
+	CreateSQL: `CREATE TABLE IF NOT EXISTS "weather_daily" (
+    "id" SERIAL PRIMARY KEY,
+    "station" VARCHAR(17) NOT NULL,
+    "date" DATE NOT NULL,
+    "temperature_max_f" FLOAT8,
+    "temperature_min_f" FLOAT8,
+    "precipitation_in" FLOAT8,
+    "snowfall_in" FLOAT8,
+    "ingest_run_id" VARCHAR(32),
+    CONSTRAINT weather_daily_unique_station_date UNIQUE ("station", "date")
+);`,
+	InsertSQL: `INSERT INTO weather_daily ("station", "date", "temperature_max_f", "temperature_min_f", "precipitation_in", "snowfall_in", "ingest_run_id")
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT ("station", "date") DO UPDATE
+			SET temperature_max_f = EXCLUDED.temperature_max_f,
+				temperature_min_f = EXCLUDED.temperature_min_f,
+				precipitation_in = EXCLUDED.precipitation_in,
+				snowfall_in = EXCLUDED.snowfall_in,
+				ingest_run_id = EXCLUDED.ingest_run_id;`,
+	Columns: []shared.Column{
+		{Name: "station", Type: shared.ColumnString},
+		{Name: "date", Type: shared.ColumnDate},
+		{Name: "temperature_max_f", Type: shared.ColumnFloat},
+		{Name: "temperature_min_f", Type: shared.ColumnFloat},
+		{Name: "precipitation_in", Type: shared.ColumnFloat},
+		{Name: "snowfall_in", Type: shared.ColumnFloat},
+		{Name: shared.IngestRunIDColumn, Type: shared.ColumnString},
+	},
+	RecordBytes: 512,
+}
+
+// WeatherDay is one day of observations at a weather station. Observations the station did not report are
+// NULL.
+type WeatherDay struct {
+	Station         string
+	Date            time.Time
+	MaxTempF        sql.NullFloat64
+	MinTempF        sql.NullFloat64
+	PrecipitationIn sql.NullFloat64
+	SnowfallIn      sql.NullFloat64
+}
+
+// WeatherDayFromDTO converts an NCEI daily summary, failing when the station or date is missing or an
+// observation is not a number.
+func WeatherDayFromDTO(record WeatherRecord) (WeatherDay, error) {
+	var p fieldParser
+	day := WeatherDay{
+		Station:         p.required("station", record.Station),
+		Date:            p.time("date", record.Date),
+		MaxTempF:        p.nullFloat("tmax", record.MaxTemp),
+		MinTempF:        p.nullFloat("tmin", record.MinTemp),
+		PrecipitationIn: p.nullFloat("prcp", record.Precipitation),
+		SnowfallIn:      p.nullFloat("snow", record.Snowfall),
+	}
+	return day, p.err()
+}
+
+// LoadWeather writes the daily weather records that convert to a WeatherDay to store and flushes it.
+func LoadWeather(ctx context.Context, store shared.Store, weather_data_list WeatherRecords) (insertedCount, skippedCount int, err error) {
+	skipped := skipTally{}
+	defer skipped.report(WeatherDataset.Table)
+
+	for _, record := range weather_data_list {
+		day, convErr := WeatherDayFromDTO(record)
+		if convErr != nil {
+			skipped.add(convErr)
+			skippedCount++
+			continue
+		}
+
+		err = store.Insert(ctx, WeatherDataset,
+			day.Station,
+			sodaDate(day.Date),
+			day.MaxTempF,
+			day.MinTempF,
+			day.PrecipitationIn,
+			day.SnowfallIn,
+		)
+
+		if err != nil {
+			return insertedCount, skippedCount, err
+		}
+		insertedCount++
+	}
+
+	return insertedCount, skippedCount, store.Flush(ctx, WeatherDataset)
+}
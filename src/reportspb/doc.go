@@ -0,0 +1,5 @@
+// Package reportspb holds the protobuf messages and gRPC service for the reports API, generated from
+// reports.proto.
+package reportspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative reports.proto
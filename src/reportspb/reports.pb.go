@@ -0,0 +1,892 @@
+// Typed access to the main report tables built by the reports service. The service shares its query layer
+// with the REST endpoints under /api/, so both return the same rows.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        v27.3.0
+// source: reports.proto
+
+package reportspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// AirportTripsRequest filters req_2_airport_trips; empty fields match every row.
+type AirportTripsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ZipCode string `protobuf:"bytes,1,opt,name=zip_code,json=zipCode,proto3" json:"zip_code,omitempty"`
+	// week_start is a date like 2022-03-06.
+	WeekStart string `protobuf:"bytes,2,opt,name=week_start,json=weekStart,proto3" json:"week_start,omitempty"`
+}
+
+func (x *AirportTripsRequest) Reset() {
+	*x = AirportTripsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_reports_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AirportTripsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AirportTripsRequest) ProtoMessage() {}
+
+func (x *AirportTripsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_reports_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AirportTripsRequest.ProtoReflect.Descriptor instead.
+func (*AirportTripsRequest) Descriptor() ([]byte, []int) {
+	return file_reports_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AirportTripsRequest) GetZipCode() string {
+	if x != nil {
+		return x.ZipCode
+	}
+	return ""
+}
+
+func (x *AirportTripsRequest) GetWeekStart() string {
+	if x != nil {
+		return x.WeekStart
+	}
+	return ""
+}
+
+// AirportTrips is one row of req_2_airport_trips.
+type AirportTrips struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ZipCode          string   `protobuf:"bytes,1,opt,name=zip_code,json=zipCode,proto3" json:"zip_code,omitempty"`
+	WeekStart        string   `protobuf:"bytes,2,opt,name=week_start,json=weekStart,proto3" json:"week_start,omitempty"`
+	CaseRateWeekly   *float64 `protobuf:"fixed64,3,opt,name=case_rate_weekly,json=caseRateWeekly,proto3,oneof" json:"case_rate_weekly,omitempty"`
+	CovidCat         string   `protobuf:"bytes,4,opt,name=covid_cat,json=covidCat,proto3" json:"covid_cat,omitempty"`
+	TripsToAirport   int64    `protobuf:"varint,5,opt,name=trips_to_airport,json=tripsToAirport,proto3" json:"trips_to_airport,omitempty"`
+	TripsFromAirport int64    `protobuf:"varint,6,opt,name=trips_from_airport,json=tripsFromAirport,proto3" json:"trips_from_airport,omitempty"`
+}
+
+func (x *AirportTrips) Reset() {
+	*x = AirportTrips{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_reports_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AirportTrips) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AirportTrips) ProtoMessage() {}
+
+func (x *AirportTrips) ProtoReflect() protoreflect.Message {
+	mi := &file_reports_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AirportTrips.ProtoReflect.Descriptor instead.
+func (*AirportTrips) Descriptor() ([]byte, []int) {
+	return file_reports_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AirportTrips) GetZipCode() string {
+	if x != nil {
+		return x.ZipCode
+	}
+	return ""
+}
+
+func (x *AirportTrips) GetWeekStart() string {
+	if x != nil {
+		return x.WeekStart
+	}
+	return ""
+}
+
+func (x *AirportTrips) GetCaseRateWeekly() float64 {
+	if x != nil && x.CaseRateWeekly != nil {
+		return *x.CaseRateWeekly
+	}
+	return 0
+}
+
+func (x *AirportTrips) GetCovidCat() string {
+	if x != nil {
+		return x.CovidCat
+	}
+	return ""
+}
+
+func (x *AirportTrips) GetTripsToAirport() int64 {
+	if x != nil {
+		return x.TripsToAirport
+	}
+	return 0
+}
+
+func (x *AirportTrips) GetTripsFromAirport() int64 {
+	if x != nil {
+		return x.TripsFromAirport
+	}
+	return 0
+}
+
+type ListAirportTripsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	AirportTrips []*AirportTrips `protobuf:"bytes,1,rep,name=airport_trips,json=airportTrips,proto3" json:"airport_trips,omitempty"`
+}
+
+func (x *ListAirportTripsResponse) Reset() {
+	*x = ListAirportTripsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_reports_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListAirportTripsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAirportTripsResponse) ProtoMessage() {}
+
+func (x *ListAirportTripsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_reports_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAirportTripsResponse.ProtoReflect.Descriptor instead.
+func (*ListAirportTripsResponse) Descriptor() ([]byte, []int) {
+	return file_reports_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListAirportTripsResponse) GetAirportTrips() []*AirportTrips {
+	if x != nil {
+		return x.AirportTrips
+	}
+	return nil
+}
+
+// DisadvantagedAreasRequest filters the disadvantaged table.
+type DisadvantagedAreasRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// only_disadvantaged restricts the response to areas flagged as disadvantaged.
+	OnlyDisadvantaged bool `protobuf:"varint,1,opt,name=only_disadvantaged,json=onlyDisadvantaged,proto3" json:"only_disadvantaged,omitempty"`
+}
+
+func (x *DisadvantagedAreasRequest) Reset() {
+	*x = DisadvantagedAreasRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_reports_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DisadvantagedAreasRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisadvantagedAreasRequest) ProtoMessage() {}
+
+func (x *DisadvantagedAreasRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_reports_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisadvantagedAreasRequest.ProtoReflect.Descriptor instead.
+func (*DisadvantagedAreasRequest) Descriptor() ([]byte, []int) {
+	return file_reports_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *DisadvantagedAreasRequest) GetOnlyDisadvantaged() bool {
+	if x != nil {
+		return x.OnlyDisadvantaged
+	}
+	return false
+}
+
+// DisadvantagedArea is one row of the disadvantaged table.
+type DisadvantagedArea struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	CommunityArea     string   `protobuf:"bytes,1,opt,name=community_area,json=communityArea,proto3" json:"community_area,omitempty"`
+	BelowPovertyLevel *float64 `protobuf:"fixed64,2,opt,name=below_poverty_level,json=belowPovertyLevel,proto3,oneof" json:"below_poverty_level,omitempty"`
+	Unemployment      *float64 `protobuf:"fixed64,3,opt,name=unemployment,proto3,oneof" json:"unemployment,omitempty"`
+	PerCapitaIncome   *float64 `protobuf:"fixed64,4,opt,name=per_capita_income,json=perCapitaIncome,proto3,oneof" json:"per_capita_income,omitempty"`
+	Top_5Poverty      bool     `protobuf:"varint,5,opt,name=top_5_poverty,json=top5Poverty,proto3" json:"top_5_poverty,omitempty"`
+	Top_5Unemployment bool     `protobuf:"varint,6,opt,name=top_5_unemployment,json=top5Unemployment,proto3" json:"top_5_unemployment,omitempty"`
+	Disadvantaged     bool     `protobuf:"varint,7,opt,name=disadvantaged,proto3" json:"disadvantaged,omitempty"`
+}
+
+func (x *DisadvantagedArea) Reset() {
+	*x = DisadvantagedArea{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_reports_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DisadvantagedArea) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DisadvantagedArea) ProtoMessage() {}
+
+func (x *DisadvantagedArea) ProtoReflect() protoreflect.Message {
+	mi := &file_reports_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DisadvantagedArea.ProtoReflect.Descriptor instead.
+func (*DisadvantagedArea) Descriptor() ([]byte, []int) {
+	return file_reports_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *DisadvantagedArea) GetCommunityArea() string {
+	if x != nil {
+		return x.CommunityArea
+	}
+	return ""
+}
+
+func (x *DisadvantagedArea) GetBelowPovertyLevel() float64 {
+	if x != nil && x.BelowPovertyLevel != nil {
+		return *x.BelowPovertyLevel
+	}
+	return 0
+}
+
+func (x *DisadvantagedArea) GetUnemployment() float64 {
+	if x != nil && x.Unemployment != nil {
+		return *x.Unemployment
+	}
+	return 0
+}
+
+func (x *DisadvantagedArea) GetPerCapitaIncome() float64 {
+	if x != nil && x.PerCapitaIncome != nil {
+		return *x.PerCapitaIncome
+	}
+	return 0
+}
+
+func (x *DisadvantagedArea) GetTop_5Poverty() bool {
+	if x != nil {
+		return x.Top_5Poverty
+	}
+	return false
+}
+
+func (x *DisadvantagedArea) GetTop_5Unemployment() bool {
+	if x != nil {
+		return x.Top_5Unemployment
+	}
+	return false
+}
+
+func (x *DisadvantagedArea) GetDisadvantaged() bool {
+	if x != nil {
+		return x.Disadvantaged
+	}
+	return false
+}
+
+type ListDisadvantagedAreasResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Areas []*DisadvantagedArea `protobuf:"bytes,1,rep,name=areas,proto3" json:"areas,omitempty"`
+}
+
+func (x *ListDisadvantagedAreasResponse) Reset() {
+	*x = ListDisadvantagedAreasResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_reports_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListDisadvantagedAreasResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDisadvantagedAreasResponse) ProtoMessage() {}
+
+func (x *ListDisadvantagedAreasResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_reports_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDisadvantagedAreasResponse.ProtoReflect.Descriptor instead.
+func (*ListDisadvantagedAreasResponse) Descriptor() ([]byte, []int) {
+	return file_reports_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListDisadvantagedAreasResponse) GetAreas() []*DisadvantagedArea {
+	if x != nil {
+		return x.Areas
+	}
+	return nil
+}
+
+// CovidAlertsRequest filters req_1a_covid_alerts_drivers; empty fields match every row.
+type CovidAlertsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// zip_code matches either the pickup or the dropoff ZIP code.
+	ZipCode string `protobuf:"bytes,1,opt,name=zip_code,json=zipCode,proto3" json:"zip_code,omitempty"`
+	// week_start is a date like 2022-03-06.
+	WeekStart string `protobuf:"bytes,2,opt,name=week_start,json=weekStart,proto3" json:"week_start,omitempty"`
+	// covid_cat restricts the stream to trips whose pickup or dropoff ZIP code is in this category.
+	CovidCat string `protobuf:"bytes,3,opt,name=covid_cat,json=covidCat,proto3" json:"covid_cat,omitempty"`
+}
+
+func (x *CovidAlertsRequest) Reset() {
+	*x = CovidAlertsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_reports_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CovidAlertsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CovidAlertsRequest) ProtoMessage() {}
+
+func (x *CovidAlertsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_reports_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CovidAlertsRequest.ProtoReflect.Descriptor instead.
+func (*CovidAlertsRequest) Descriptor() ([]byte, []int) {
+	return file_reports_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CovidAlertsRequest) GetZipCode() string {
+	if x != nil {
+		return x.ZipCode
+	}
+	return ""
+}
+
+func (x *CovidAlertsRequest) GetWeekStart() string {
+	if x != nil {
+		return x.WeekStart
+	}
+	return ""
+}
+
+func (x *CovidAlertsRequest) GetCovidCat() string {
+	if x != nil {
+		return x.CovidCat
+	}
+	return ""
+}
+
+// CovidAlert is one trip of req_1a_covid_alerts_drivers.
+type CovidAlert struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	TripId             string                 `protobuf:"bytes,1,opt,name=trip_id,json=tripId,proto3" json:"trip_id,omitempty"`
+	TripStartTimestamp *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=trip_start_timestamp,json=tripStartTimestamp,proto3" json:"trip_start_timestamp,omitempty"`
+	WeekStart          string                 `protobuf:"bytes,3,opt,name=week_start,json=weekStart,proto3" json:"week_start,omitempty"`
+	PickupZipCode      string                 `protobuf:"bytes,4,opt,name=pickup_zip_code,json=pickupZipCode,proto3" json:"pickup_zip_code,omitempty"`
+	DropoffZipCode     string                 `protobuf:"bytes,5,opt,name=dropoff_zip_code,json=dropoffZipCode,proto3" json:"dropoff_zip_code,omitempty"`
+	PickupCovidCat     string                 `protobuf:"bytes,6,opt,name=pickup_covid_cat,json=pickupCovidCat,proto3" json:"pickup_covid_cat,omitempty"`
+	DropoffCovidCat    string                 `protobuf:"bytes,7,opt,name=dropoff_covid_cat,json=dropoffCovidCat,proto3" json:"dropoff_covid_cat,omitempty"`
+	AirportPickup      bool                   `protobuf:"varint,8,opt,name=airport_pickup,json=airportPickup,proto3" json:"airport_pickup,omitempty"`
+	AirportDropoff     bool                   `protobuf:"varint,9,opt,name=airport_dropoff,json=airportDropoff,proto3" json:"airport_dropoff,omitempty"`
+}
+
+func (x *CovidAlert) Reset() {
+	*x = CovidAlert{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_reports_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CovidAlert) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CovidAlert) ProtoMessage() {}
+
+func (x *CovidAlert) ProtoReflect() protoreflect.Message {
+	mi := &file_reports_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CovidAlert.ProtoReflect.Descriptor instead.
+func (*CovidAlert) Descriptor() ([]byte, []int) {
+	return file_reports_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *CovidAlert) GetTripId() string {
+	if x != nil {
+		return x.TripId
+	}
+	return ""
+}
+
+func (x *CovidAlert) GetTripStartTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.TripStartTimestamp
+	}
+	return nil
+}
+
+func (x *CovidAlert) GetWeekStart() string {
+	if x != nil {
+		return x.WeekStart
+	}
+	return ""
+}
+
+func (x *CovidAlert) GetPickupZipCode() string {
+	if x != nil {
+		return x.PickupZipCode
+	}
+	return ""
+}
+
+func (x *CovidAlert) GetDropoffZipCode() string {
+	if x != nil {
+		return x.DropoffZipCode
+	}
+	return ""
+}
+
+func (x *CovidAlert) GetPickupCovidCat() string {
+	if x != nil {
+		return x.PickupCovidCat
+	}
+	return ""
+}
+
+func (x *CovidAlert) GetDropoffCovidCat() string {
+	if x != nil {
+		return x.DropoffCovidCat
+	}
+	return ""
+}
+
+func (x *CovidAlert) GetAirportPickup() bool {
+	if x != nil {
+		return x.AirportPickup
+	}
+	return false
+}
+
+func (x *CovidAlert) GetAirportDropoff() bool {
+	if x != nil {
+		return x.AirportDropoff
+	}
+	return false
+}
+
+var File_reports_proto protoreflect.FileDescriptor
+
+var file_reports_proto_rawDesc = []byte{
+	0x0a, 0x0d, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12,
+	0x15, 0x63, 0x68, 0x69, 0x63, 0x61, 0x67, 0x6f, 0x5f, 0x62, 0x69, 0x2e, 0x72, 0x65, 0x70, 0x6f,
+	0x72, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x4f, 0x0a, 0x13, 0x41, 0x69, 0x72, 0x70, 0x6f,
+	0x72, 0x74, 0x54, 0x72, 0x69, 0x70, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x19,
+	0x0a, 0x08, 0x7a, 0x69, 0x70, 0x5f, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x07, 0x7a, 0x69, 0x70, 0x43, 0x6f, 0x64, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x77, 0x65, 0x65,
+	0x6b, 0x5f, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x77,
+	0x65, 0x65, 0x6b, 0x53, 0x74, 0x61, 0x72, 0x74, 0x22, 0x81, 0x02, 0x0a, 0x0c, 0x41, 0x69, 0x72,
+	0x70, 0x6f, 0x72, 0x74, 0x54, 0x72, 0x69, 0x70, 0x73, 0x12, 0x19, 0x0a, 0x08, 0x7a, 0x69, 0x70,
+	0x5f, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x7a, 0x69, 0x70,
+	0x43, 0x6f, 0x64, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x77, 0x65, 0x65, 0x6b, 0x5f, 0x73, 0x74, 0x61,
+	0x72, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x77, 0x65, 0x65, 0x6b, 0x53, 0x74,
+	0x61, 0x72, 0x74, 0x12, 0x2d, 0x0a, 0x10, 0x63, 0x61, 0x73, 0x65, 0x5f, 0x72, 0x61, 0x74, 0x65,
+	0x5f, 0x77, 0x65, 0x65, 0x6b, 0x6c, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x48, 0x00, 0x52,
+	0x0e, 0x63, 0x61, 0x73, 0x65, 0x52, 0x61, 0x74, 0x65, 0x57, 0x65, 0x65, 0x6b, 0x6c, 0x79, 0x88,
+	0x01, 0x01, 0x12, 0x1b, 0x0a, 0x09, 0x63, 0x6f, 0x76, 0x69, 0x64, 0x5f, 0x63, 0x61, 0x74, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63, 0x6f, 0x76, 0x69, 0x64, 0x43, 0x61, 0x74, 0x12,
+	0x28, 0x0a, 0x10, 0x74, 0x72, 0x69, 0x70, 0x73, 0x5f, 0x74, 0x6f, 0x5f, 0x61, 0x69, 0x72, 0x70,
+	0x6f, 0x72, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x74, 0x72, 0x69, 0x70, 0x73,
+	0x54, 0x6f, 0x41, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x12, 0x2c, 0x0a, 0x12, 0x74, 0x72, 0x69,
+	0x70, 0x73, 0x5f, 0x66, 0x72, 0x6f, 0x6d, 0x5f, 0x61, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x10, 0x74, 0x72, 0x69, 0x70, 0x73, 0x46, 0x72, 0x6f, 0x6d,
+	0x41, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x42, 0x13, 0x0a, 0x11, 0x5f, 0x63, 0x61, 0x73, 0x65,
+	0x5f, 0x72, 0x61, 0x74, 0x65, 0x5f, 0x77, 0x65, 0x65, 0x6b, 0x6c, 0x79, 0x22, 0x64, 0x0a, 0x18,
+	0x4c, 0x69, 0x73, 0x74, 0x41, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x54, 0x72, 0x69, 0x70, 0x73,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x48, 0x0a, 0x0d, 0x61, 0x69, 0x72, 0x70,
+	0x6f, 0x72, 0x74, 0x5f, 0x74, 0x72, 0x69, 0x70, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x23, 0x2e, 0x63, 0x68, 0x69, 0x63, 0x61, 0x67, 0x6f, 0x5f, 0x62, 0x69, 0x2e, 0x72, 0x65, 0x70,
+	0x6f, 0x72, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x54,
+	0x72, 0x69, 0x70, 0x73, 0x52, 0x0c, 0x61, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x54, 0x72, 0x69,
+	0x70, 0x73, 0x22, 0x4a, 0x0a, 0x19, 0x44, 0x69, 0x73, 0x61, 0x64, 0x76, 0x61, 0x6e, 0x74, 0x61,
+	0x67, 0x65, 0x64, 0x41, 0x72, 0x65, 0x61, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
+	0x2d, 0x0a, 0x12, 0x6f, 0x6e, 0x6c, 0x79, 0x5f, 0x64, 0x69, 0x73, 0x61, 0x64, 0x76, 0x61, 0x6e,
+	0x74, 0x61, 0x67, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x11, 0x6f, 0x6e, 0x6c,
+	0x79, 0x44, 0x69, 0x73, 0x61, 0x64, 0x76, 0x61, 0x6e, 0x74, 0x61, 0x67, 0x65, 0x64, 0x22, 0x80,
+	0x03, 0x0a, 0x11, 0x44, 0x69, 0x73, 0x61, 0x64, 0x76, 0x61, 0x6e, 0x74, 0x61, 0x67, 0x65, 0x64,
+	0x41, 0x72, 0x65, 0x61, 0x12, 0x25, 0x0a, 0x0e, 0x63, 0x6f, 0x6d, 0x6d, 0x75, 0x6e, 0x69, 0x74,
+	0x79, 0x5f, 0x61, 0x72, 0x65, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x63, 0x6f,
+	0x6d, 0x6d, 0x75, 0x6e, 0x69, 0x74, 0x79, 0x41, 0x72, 0x65, 0x61, 0x12, 0x33, 0x0a, 0x13, 0x62,
+	0x65, 0x6c, 0x6f, 0x77, 0x5f, 0x70, 0x6f, 0x76, 0x65, 0x72, 0x74, 0x79, 0x5f, 0x6c, 0x65, 0x76,
+	0x65, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x48, 0x00, 0x52, 0x11, 0x62, 0x65, 0x6c, 0x6f,
+	0x77, 0x50, 0x6f, 0x76, 0x65, 0x72, 0x74, 0x79, 0x4c, 0x65, 0x76, 0x65, 0x6c, 0x88, 0x01, 0x01,
+	0x12, 0x27, 0x0a, 0x0c, 0x75, 0x6e, 0x65, 0x6d, 0x70, 0x6c, 0x6f, 0x79, 0x6d, 0x65, 0x6e, 0x74,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x48, 0x01, 0x52, 0x0c, 0x75, 0x6e, 0x65, 0x6d, 0x70, 0x6c,
+	0x6f, 0x79, 0x6d, 0x65, 0x6e, 0x74, 0x88, 0x01, 0x01, 0x12, 0x2f, 0x0a, 0x11, 0x70, 0x65, 0x72,
+	0x5f, 0x63, 0x61, 0x70, 0x69, 0x74, 0x61, 0x5f, 0x69, 0x6e, 0x63, 0x6f, 0x6d, 0x65, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x01, 0x48, 0x02, 0x52, 0x0f, 0x70, 0x65, 0x72, 0x43, 0x61, 0x70, 0x69, 0x74,
+	0x61, 0x49, 0x6e, 0x63, 0x6f, 0x6d, 0x65, 0x88, 0x01, 0x01, 0x12, 0x22, 0x0a, 0x0d, 0x74, 0x6f,
+	0x70, 0x5f, 0x35, 0x5f, 0x70, 0x6f, 0x76, 0x65, 0x72, 0x74, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x0b, 0x74, 0x6f, 0x70, 0x35, 0x50, 0x6f, 0x76, 0x65, 0x72, 0x74, 0x79, 0x12, 0x2c,
+	0x0a, 0x12, 0x74, 0x6f, 0x70, 0x5f, 0x35, 0x5f, 0x75, 0x6e, 0x65, 0x6d, 0x70, 0x6c, 0x6f, 0x79,
+	0x6d, 0x65, 0x6e, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x10, 0x74, 0x6f, 0x70, 0x35,
+	0x55, 0x6e, 0x65, 0x6d, 0x70, 0x6c, 0x6f, 0x79, 0x6d, 0x65, 0x6e, 0x74, 0x12, 0x24, 0x0a, 0x0d,
+	0x64, 0x69, 0x73, 0x61, 0x64, 0x76, 0x61, 0x6e, 0x74, 0x61, 0x67, 0x65, 0x64, 0x18, 0x07, 0x20,
+	0x01, 0x28, 0x08, 0x52, 0x0d, 0x64, 0x69, 0x73, 0x61, 0x64, 0x76, 0x61, 0x6e, 0x74, 0x61, 0x67,
+	0x65, 0x64, 0x42, 0x16, 0x0a, 0x14, 0x5f, 0x62, 0x65, 0x6c, 0x6f, 0x77, 0x5f, 0x70, 0x6f, 0x76,
+	0x65, 0x72, 0x74, 0x79, 0x5f, 0x6c, 0x65, 0x76, 0x65, 0x6c, 0x42, 0x0f, 0x0a, 0x0d, 0x5f, 0x75,
+	0x6e, 0x65, 0x6d, 0x70, 0x6c, 0x6f, 0x79, 0x6d, 0x65, 0x6e, 0x74, 0x42, 0x14, 0x0a, 0x12, 0x5f,
+	0x70, 0x65, 0x72, 0x5f, 0x63, 0x61, 0x70, 0x69, 0x74, 0x61, 0x5f, 0x69, 0x6e, 0x63, 0x6f, 0x6d,
+	0x65, 0x22, 0x60, 0x0a, 0x1e, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x69, 0x73, 0x61, 0x64, 0x76, 0x61,
+	0x6e, 0x74, 0x61, 0x67, 0x65, 0x64, 0x41, 0x72, 0x65, 0x61, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x12, 0x3e, 0x0a, 0x05, 0x61, 0x72, 0x65, 0x61, 0x73, 0x18, 0x01, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x28, 0x2e, 0x63, 0x68, 0x69, 0x63, 0x61, 0x67, 0x6f, 0x5f, 0x62, 0x69, 0x2e,
+	0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x69, 0x73, 0x61, 0x64,
+	0x76, 0x61, 0x6e, 0x74, 0x61, 0x67, 0x65, 0x64, 0x41, 0x72, 0x65, 0x61, 0x52, 0x05, 0x61, 0x72,
+	0x65, 0x61, 0x73, 0x22, 0x6b, 0x0a, 0x12, 0x43, 0x6f, 0x76, 0x69, 0x64, 0x41, 0x6c, 0x65, 0x72,
+	0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x7a, 0x69, 0x70,
+	0x5f, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x7a, 0x69, 0x70,
+	0x43, 0x6f, 0x64, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x77, 0x65, 0x65, 0x6b, 0x5f, 0x73, 0x74, 0x61,
+	0x72, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x77, 0x65, 0x65, 0x6b, 0x53, 0x74,
+	0x61, 0x72, 0x74, 0x12, 0x1b, 0x0a, 0x09, 0x63, 0x6f, 0x76, 0x69, 0x64, 0x5f, 0x63, 0x61, 0x74,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63, 0x6f, 0x76, 0x69, 0x64, 0x43, 0x61, 0x74,
+	0x22, 0x8a, 0x03, 0x0a, 0x0a, 0x43, 0x6f, 0x76, 0x69, 0x64, 0x41, 0x6c, 0x65, 0x72, 0x74, 0x12,
+	0x17, 0x0a, 0x07, 0x74, 0x72, 0x69, 0x70, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x74, 0x72, 0x69, 0x70, 0x49, 0x64, 0x12, 0x4c, 0x0a, 0x14, 0x74, 0x72, 0x69, 0x70,
+	0x5f, 0x73, 0x74, 0x61, 0x72, 0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61,
+	0x6d, 0x70, 0x52, 0x12, 0x74, 0x72, 0x69, 0x70, 0x53, 0x74, 0x61, 0x72, 0x74, 0x54, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x1d, 0x0a, 0x0a, 0x77, 0x65, 0x65, 0x6b, 0x5f, 0x73,
+	0x74, 0x61, 0x72, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x77, 0x65, 0x65, 0x6b,
+	0x53, 0x74, 0x61, 0x72, 0x74, 0x12, 0x26, 0x0a, 0x0f, 0x70, 0x69, 0x63, 0x6b, 0x75, 0x70, 0x5f,
+	0x7a, 0x69, 0x70, 0x5f, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d,
+	0x70, 0x69, 0x63, 0x6b, 0x75, 0x70, 0x5a, 0x69, 0x70, 0x43, 0x6f, 0x64, 0x65, 0x12, 0x28, 0x0a,
+	0x10, 0x64, 0x72, 0x6f, 0x70, 0x6f, 0x66, 0x66, 0x5f, 0x7a, 0x69, 0x70, 0x5f, 0x63, 0x6f, 0x64,
+	0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x64, 0x72, 0x6f, 0x70, 0x6f, 0x66, 0x66,
+	0x5a, 0x69, 0x70, 0x43, 0x6f, 0x64, 0x65, 0x12, 0x28, 0x0a, 0x10, 0x70, 0x69, 0x63, 0x6b, 0x75,
+	0x70, 0x5f, 0x63, 0x6f, 0x76, 0x69, 0x64, 0x5f, 0x63, 0x61, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0e, 0x70, 0x69, 0x63, 0x6b, 0x75, 0x70, 0x43, 0x6f, 0x76, 0x69, 0x64, 0x43, 0x61,
+	0x74, 0x12, 0x2a, 0x0a, 0x11, 0x64, 0x72, 0x6f, 0x70, 0x6f, 0x66, 0x66, 0x5f, 0x63, 0x6f, 0x76,
+	0x69, 0x64, 0x5f, 0x63, 0x61, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x64, 0x72,
+	0x6f, 0x70, 0x6f, 0x66, 0x66, 0x43, 0x6f, 0x76, 0x69, 0x64, 0x43, 0x61, 0x74, 0x12, 0x25, 0x0a,
+	0x0e, 0x61, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x5f, 0x70, 0x69, 0x63, 0x6b, 0x75, 0x70, 0x18,
+	0x08, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0d, 0x61, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x50, 0x69,
+	0x63, 0x6b, 0x75, 0x70, 0x12, 0x27, 0x0a, 0x0f, 0x61, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x5f,
+	0x64, 0x72, 0x6f, 0x70, 0x6f, 0x66, 0x66, 0x18, 0x09, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0e, 0x61,
+	0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x44, 0x72, 0x6f, 0x70, 0x6f, 0x66, 0x66, 0x32, 0xcc, 0x03,
+	0x0a, 0x07, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x12, 0x6f, 0x0a, 0x10, 0x4c, 0x69, 0x73,
+	0x74, 0x41, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x54, 0x72, 0x69, 0x70, 0x73, 0x12, 0x2a, 0x2e,
+	0x63, 0x68, 0x69, 0x63, 0x61, 0x67, 0x6f, 0x5f, 0x62, 0x69, 0x2e, 0x72, 0x65, 0x70, 0x6f, 0x72,
+	0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x54, 0x72, 0x69,
+	0x70, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x2f, 0x2e, 0x63, 0x68, 0x69, 0x63,
+	0x61, 0x67, 0x6f, 0x5f, 0x62, 0x69, 0x2e, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x2e, 0x76,
+	0x31, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x41, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x54, 0x72, 0x69,
+	0x70, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x67, 0x0a, 0x12, 0x53, 0x74,
+	0x72, 0x65, 0x61, 0x6d, 0x41, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x54, 0x72, 0x69, 0x70, 0x73,
+	0x12, 0x2a, 0x2e, 0x63, 0x68, 0x69, 0x63, 0x61, 0x67, 0x6f, 0x5f, 0x62, 0x69, 0x2e, 0x72, 0x65,
+	0x70, 0x6f, 0x72, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74,
+	0x54, 0x72, 0x69, 0x70, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x23, 0x2e, 0x63,
+	0x68, 0x69, 0x63, 0x61, 0x67, 0x6f, 0x5f, 0x62, 0x69, 0x2e, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74,
+	0x73, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x69, 0x72, 0x70, 0x6f, 0x72, 0x74, 0x54, 0x72, 0x69, 0x70,
+	0x73, 0x30, 0x01, 0x12, 0x81, 0x01, 0x0a, 0x16, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x69, 0x73, 0x61,
+	0x64, 0x76, 0x61, 0x6e, 0x74, 0x61, 0x67, 0x65, 0x64, 0x41, 0x72, 0x65, 0x61, 0x73, 0x12, 0x30,
+	0x2e, 0x63, 0x68, 0x69, 0x63, 0x61, 0x67, 0x6f, 0x5f, 0x62, 0x69, 0x2e, 0x72, 0x65, 0x70, 0x6f,
+	0x72, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x69, 0x73, 0x61, 0x64, 0x76, 0x61, 0x6e, 0x74,
+	0x61, 0x67, 0x65, 0x64, 0x41, 0x72, 0x65, 0x61, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x35, 0x2e, 0x63, 0x68, 0x69, 0x63, 0x61, 0x67, 0x6f, 0x5f, 0x62, 0x69, 0x2e, 0x72, 0x65,
+	0x70, 0x6f, 0x72, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x69, 0x73,
+	0x61, 0x64, 0x76, 0x61, 0x6e, 0x74, 0x61, 0x67, 0x65, 0x64, 0x41, 0x72, 0x65, 0x61, 0x73, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x63, 0x0a, 0x11, 0x53, 0x74, 0x72, 0x65, 0x61,
+	0x6d, 0x43, 0x6f, 0x76, 0x69, 0x64, 0x41, 0x6c, 0x65, 0x72, 0x74, 0x73, 0x12, 0x29, 0x2e, 0x63,
+	0x68, 0x69, 0x63, 0x61, 0x67, 0x6f, 0x5f, 0x62, 0x69, 0x2e, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74,
+	0x73, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f, 0x76, 0x69, 0x64, 0x41, 0x6c, 0x65, 0x72, 0x74, 0x73,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x21, 0x2e, 0x63, 0x68, 0x69, 0x63, 0x61, 0x67,
+	0x6f, 0x5f, 0x62, 0x69, 0x2e, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e,
+	0x43, 0x6f, 0x76, 0x69, 0x64, 0x41, 0x6c, 0x65, 0x72, 0x74, 0x30, 0x01, 0x42, 0x29, 0x5a, 0x27,
+	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x61, 0x68, 0x62, 0x72, 0x65,
+	0x63, 0x6b, 0x2f, 0x43, 0x68, 0x69, 0x63, 0x61, 0x67, 0x6f, 0x5f, 0x42, 0x49, 0x2f, 0x72, 0x65,
+	0x70, 0x6f, 0x72, 0x74, 0x73, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_reports_proto_rawDescOnce sync.Once
+	file_reports_proto_rawDescData = file_reports_proto_rawDesc
+)
+
+func file_reports_proto_rawDescGZIP() []byte {
+	file_reports_proto_rawDescOnce.Do(func() {
+		file_reports_proto_rawDescData = protoimpl.X.CompressGZIP(file_reports_proto_rawDescData)
+	})
+	return file_reports_proto_rawDescData
+}
+
+var file_reports_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_reports_proto_goTypes = []any{
+	(*AirportTripsRequest)(nil),            // 0: chicago_bi.reports.v1.AirportTripsRequest
+	(*AirportTrips)(nil),                   // 1: chicago_bi.reports.v1.AirportTrips
+	(*ListAirportTripsResponse)(nil),       // 2: chicago_bi.reports.v1.ListAirportTripsResponse
+	(*DisadvantagedAreasRequest)(nil),      // 3: chicago_bi.reports.v1.DisadvantagedAreasRequest
+	(*DisadvantagedArea)(nil),              // 4: chicago_bi.reports.v1.DisadvantagedArea
+	(*ListDisadvantagedAreasResponse)(nil), // 5: chicago_bi.reports.v1.ListDisadvantagedAreasResponse
+	(*CovidAlertsRequest)(nil),             // 6: chicago_bi.reports.v1.CovidAlertsRequest
+	(*CovidAlert)(nil),                     // 7: chicago_bi.reports.v1.CovidAlert
+	(*timestamppb.Timestamp)(nil),          // 8: google.protobuf.Timestamp
+}
+var file_reports_proto_depIdxs = []int32{
+	1, // 0: chicago_bi.reports.v1.ListAirportTripsResponse.airport_trips:type_name -> chicago_bi.reports.v1.AirportTrips
+	4, // 1: chicago_bi.reports.v1.ListDisadvantagedAreasResponse.areas:type_name -> chicago_bi.reports.v1.DisadvantagedArea
+	8, // 2: chicago_bi.reports.v1.CovidAlert.trip_start_timestamp:type_name -> google.protobuf.Timestamp
+	0, // 3: chicago_bi.reports.v1.Reports.ListAirportTrips:input_type -> chicago_bi.reports.v1.AirportTripsRequest
+	0, // 4: chicago_bi.reports.v1.Reports.StreamAirportTrips:input_type -> chicago_bi.reports.v1.AirportTripsRequest
+	3, // 5: chicago_bi.reports.v1.Reports.ListDisadvantagedAreas:input_type -> chicago_bi.reports.v1.DisadvantagedAreasRequest
+	6, // 6: chicago_bi.reports.v1.Reports.StreamCovidAlerts:input_type -> chicago_bi.reports.v1.CovidAlertsRequest
+	2, // 7: chicago_bi.reports.v1.Reports.ListAirportTrips:output_type -> chicago_bi.reports.v1.ListAirportTripsResponse
+	1, // 8: chicago_bi.reports.v1.Reports.StreamAirportTrips:output_type -> chicago_bi.reports.v1.AirportTrips
+	5, // 9: chicago_bi.reports.v1.Reports.ListDisadvantagedAreas:output_type -> chicago_bi.reports.v1.ListDisadvantagedAreasResponse
+	7, // 10: chicago_bi.reports.v1.Reports.StreamCovidAlerts:output_type -> chicago_bi.reports.v1.CovidAlert
+	7, // [7:11] is the sub-list for method output_type
+	3, // [3:7] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_reports_proto_init() }
+func file_reports_proto_init() {
+	if File_reports_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_reports_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*AirportTripsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_reports_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*AirportTrips); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_reports_proto_msgTypes[2].Exporter = func(v any, i int) any {
+			switch v := v.(*ListAirportTripsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_reports_proto_msgTypes[3].Exporter = func(v any, i int) any {
+			switch v := v.(*DisadvantagedAreasRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_reports_proto_msgTypes[4].Exporter = func(v any, i int) any {
+			switch v := v.(*DisadvantagedArea); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_reports_proto_msgTypes[5].Exporter = func(v any, i int) any {
+			switch v := v.(*ListDisadvantagedAreasResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_reports_proto_msgTypes[6].Exporter = func(v any, i int) any {
+			switch v := v.(*CovidAlertsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_reports_proto_msgTypes[7].Exporter = func(v any, i int) any {
+			switch v := v.(*CovidAlert); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_reports_proto_msgTypes[1].OneofWrappers = []any{}
+	file_reports_proto_msgTypes[4].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_reports_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_reports_proto_goTypes,
+		DependencyIndexes: file_reports_proto_depIdxs,
+		MessageInfos:      file_reports_proto_msgTypes,
+	}.Build()
+	File_reports_proto = out.File
+	file_reports_proto_rawDesc = nil
+	file_reports_proto_goTypes = nil
+	file_reports_proto_depIdxs = nil
+}
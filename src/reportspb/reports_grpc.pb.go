@@ -0,0 +1,255 @@
+// Typed access to the main report tables built by the reports service. The service shares its query layer
+// with the REST endpoints under /api/, so both return the same rows.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v27.3.0
+// source: reports.proto
+
+package reportspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Reports_ListAirportTrips_FullMethodName       = "/chicago_bi.reports.v1.Reports/ListAirportTrips"
+	Reports_StreamAirportTrips_FullMethodName     = "/chicago_bi.reports.v1.Reports/StreamAirportTrips"
+	Reports_ListDisadvantagedAreas_FullMethodName = "/chicago_bi.reports.v1.Reports/ListDisadvantagedAreas"
+	Reports_StreamCovidAlerts_FullMethodName      = "/chicago_bi.reports.v1.Reports/StreamCovidAlerts"
+)
+
+// ReportsClient is the client API for Reports service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReportsClient interface {
+	// ListAirportTrips returns weekly trips to and from the airports per ZIP code.
+	ListAirportTrips(ctx context.Context, in *AirportTripsRequest, opts ...grpc.CallOption) (*ListAirportTripsResponse, error)
+	// StreamAirportTrips returns the same rows as ListAirportTrips one message at a time.
+	StreamAirportTrips(ctx context.Context, in *AirportTripsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[AirportTrips], error)
+	// ListDisadvantagedAreas returns the public health indicators and disadvantaged flags per community area.
+	ListDisadvantagedAreas(ctx context.Context, in *DisadvantagedAreasRequest, opts ...grpc.CallOption) (*ListDisadvantagedAreasResponse, error)
+	// StreamCovidAlerts streams trips with the COVID category of their pickup and dropoff ZIP codes. The
+	// table holds one row per trip, so it is only offered as a stream.
+	StreamCovidAlerts(ctx context.Context, in *CovidAlertsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[CovidAlert], error)
+}
+
+type reportsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReportsClient(cc grpc.ClientConnInterface) ReportsClient {
+	return &reportsClient{cc}
+}
+
+func (c *reportsClient) ListAirportTrips(ctx context.Context, in *AirportTripsRequest, opts ...grpc.CallOption) (*ListAirportTripsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListAirportTripsResponse)
+	err := c.cc.Invoke(ctx, Reports_ListAirportTrips_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reportsClient) StreamAirportTrips(ctx context.Context, in *AirportTripsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[AirportTrips], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Reports_ServiceDesc.Streams[0], Reports_StreamAirportTrips_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[AirportTripsRequest, AirportTrips]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Reports_StreamAirportTripsClient = grpc.ServerStreamingClient[AirportTrips]
+
+func (c *reportsClient) ListDisadvantagedAreas(ctx context.Context, in *DisadvantagedAreasRequest, opts ...grpc.CallOption) (*ListDisadvantagedAreasResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDisadvantagedAreasResponse)
+	err := c.cc.Invoke(ctx, Reports_ListDisadvantagedAreas_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *reportsClient) StreamCovidAlerts(ctx context.Context, in *CovidAlertsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[CovidAlert], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Reports_ServiceDesc.Streams[1], Reports_StreamCovidAlerts_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[CovidAlertsRequest, CovidAlert]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Reports_StreamCovidAlertsClient = grpc.ServerStreamingClient[CovidAlert]
+
+// ReportsServer is the server API for Reports service.
+// All implementations must embed UnimplementedReportsServer
+// for forward compatibility.
+type ReportsServer interface {
+	// ListAirportTrips returns weekly trips to and from the airports per ZIP code.
+	ListAirportTrips(context.Context, *AirportTripsRequest) (*ListAirportTripsResponse, error)
+	// StreamAirportTrips returns the same rows as ListAirportTrips one message at a time.
+	StreamAirportTrips(*AirportTripsRequest, grpc.ServerStreamingServer[AirportTrips]) error
+	// ListDisadvantagedAreas returns the public health indicators and disadvantaged flags per community area.
+	ListDisadvantagedAreas(context.Context, *DisadvantagedAreasRequest) (*ListDisadvantagedAreasResponse, error)
+	// StreamCovidAlerts streams trips with the COVID category of their pickup and dropoff ZIP codes. The
+	// table holds one row per trip, so it is only offered as a stream.
+	StreamCovidAlerts(*CovidAlertsRequest, grpc.ServerStreamingServer[CovidAlert]) error
+	mustEmbedUnimplementedReportsServer()
+}
+
+// UnimplementedReportsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedReportsServer struct{}
+
+func (UnimplementedReportsServer) ListAirportTrips(context.Context, *AirportTripsRequest) (*ListAirportTripsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAirportTrips not implemented")
+}
+func (UnimplementedReportsServer) StreamAirportTrips(*AirportTripsRequest, grpc.ServerStreamingServer[AirportTrips]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamAirportTrips not implemented")
+}
+func (UnimplementedReportsServer) ListDisadvantagedAreas(context.Context, *DisadvantagedAreasRequest) (*ListDisadvantagedAreasResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDisadvantagedAreas not implemented")
+}
+func (UnimplementedReportsServer) StreamCovidAlerts(*CovidAlertsRequest, grpc.ServerStreamingServer[CovidAlert]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamCovidAlerts not implemented")
+}
+func (UnimplementedReportsServer) mustEmbedUnimplementedReportsServer() {}
+func (UnimplementedReportsServer) testEmbeddedByValue()                 {}
+
+// UnsafeReportsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReportsServer will
+// result in compilation errors.
+type UnsafeReportsServer interface {
+	mustEmbedUnimplementedReportsServer()
+}
+
+func RegisterReportsServer(s grpc.ServiceRegistrar, srv ReportsServer) {
+	// If the following call pancis, it indicates UnimplementedReportsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Reports_ServiceDesc, srv)
+}
+
+func _Reports_ListAirportTrips_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AirportTripsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReportsServer).ListAirportTrips(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Reports_ListAirportTrips_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReportsServer).ListAirportTrips(ctx, req.(*AirportTripsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Reports_StreamAirportTrips_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(AirportTripsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReportsServer).StreamAirportTrips(m, &grpc.GenericServerStream[AirportTripsRequest, AirportTrips]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Reports_StreamAirportTripsServer = grpc.ServerStreamingServer[AirportTrips]
+
+func _Reports_ListDisadvantagedAreas_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisadvantagedAreasRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReportsServer).ListDisadvantagedAreas(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Reports_ListDisadvantagedAreas_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReportsServer).ListDisadvantagedAreas(ctx, req.(*DisadvantagedAreasRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Reports_StreamCovidAlerts_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(CovidAlertsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReportsServer).StreamCovidAlerts(m, &grpc.GenericServerStream[CovidAlertsRequest, CovidAlert]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Reports_StreamCovidAlertsServer = grpc.ServerStreamingServer[CovidAlert]
+
+// Reports_ServiceDesc is the grpc.ServiceDesc for Reports service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Reports_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "chicago_bi.reports.v1.Reports",
+	HandlerType: (*ReportsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListAirportTrips",
+			Handler:    _Reports_ListAirportTrips_Handler,
+		},
+		{
+			MethodName: "ListDisadvantagedAreas",
+			Handler:    _Reports_ListDisadvantagedAreas_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamAirportTrips",
+			Handler:       _Reports_StreamAirportTrips_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamCovidAlerts",
+			Handler:       _Reports_StreamCovidAlerts_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "reports.proto",
+}
@@ -0,0 +1,287 @@
+package scoring
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// Table holds one row per scored community area.
+	Table = "composite_scores"
+
+	// The weight of each indicator in the composite score. Weights are relative: they are divided by the sum
+	// of the weights of the indicators an area has values for.
+	PovertyWeightEnvKey      = "COMPOSITE_WEIGHT_POVERTY"
+	UnemploymentWeightEnvKey = "COMPOSITE_WEIGHT_UNEMPLOYMENT"
+	IncomeWeightEnvKey       = "COMPOSITE_WEIGHT_INCOME"
+	CCVIWeightEnvKey         = "COMPOSITE_WEIGHT_CCVI"
+)
+
+// Weights are the relative weights of the indicators in the composite score.
+type Weights struct {
+	Poverty      float64 `json:"poverty"`
+	Unemployment float64 `json:"unemployment"`
+	Income       float64 `json:"income"`
+	CCVI         float64 `json:"ccvi"`
+}
+
+// DefaultWeights weigh every indicator equally.
+var DefaultWeights = Weights{Poverty: 1, Unemployment: 1, Income: 1, CCVI: 1}
+
+// Indicators are the inputs of one community area's score. A missing indicator leaves the area scored on
+// the others.
+type Indicators struct {
+	CommunityArea string
+	Poverty       sql.NullFloat64
+	Unemployment  sql.NullFloat64
+	Income        sql.NullFloat64
+	CCVI          sql.NullFloat64
+}
+
+// Score is one community area's composite score. Every z-score is oriented so that higher means more
+// disadvantaged, so IncomeZ is the negated z-score of per capita income. Rank 1 is the most disadvantaged
+// area.
+type Score struct {
+	CommunityArea  string
+	PovertyZ       sql.NullFloat64
+	UnemploymentZ  sql.NullFloat64
+	IncomeZ        sql.NullFloat64
+	CCVIZ          sql.NullFloat64
+	CompositeScore float64
+	Rank           int
+}
+
+// Queryer is satisfied by *sql.DB and *sql.Tx, so scores can be refreshed inside a report's transaction.
+type Queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// LoadWeights reads the weights through lookup, falling back to DefaultWeights for weights that are unset
+// or not a non-negative number. When every weight is 0 the defaults are used instead.
+func LoadWeights(lookup func(name string) (string, error)) (Weights, error) {
+	weights := DefaultWeights
+	for _, weight := range []struct {
+		key   string
+		value *float64
+	}{
+		{PovertyWeightEnvKey, &weights.Poverty},
+		{UnemploymentWeightEnvKey, &weights.Unemployment},
+		{IncomeWeightEnvKey, &weights.Income},
+		{CCVIWeightEnvKey, &weights.CCVI},
+	} {
+		raw, err := lookup(weight.key)
+		if err != nil {
+			return weights, err
+		}
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value < 0 || math.IsInf(value, 0) {
+			log.Printf("invalid %s value %q; defaulting to %g", weight.key, raw, *weight.value)
+			continue
+		}
+		*weight.value = value
+	}
+
+	if weights.Poverty+weights.Unemployment+weights.Income+weights.CCVI == 0 {
+		log.Printf("composite score weights are all 0; defaulting to equal weights")
+		weights = DefaultWeights
+	}
+	return weights, nil
+}
+
+// Compute scores areas and ranks them from the most to the least disadvantaged. Areas without any
+// indicator that carries weight are left out.
+func Compute(areas []Indicators, weights Weights) []Score {
+	povertyZ := zScores(areas, func(a Indicators) sql.NullFloat64 { return a.Poverty })
+	unemploymentZ := zScores(areas, func(a Indicators) sql.NullFloat64 { return a.Unemployment })
+	incomeZ := zScores(areas, func(a Indicators) sql.NullFloat64 { return a.Income })
+	ccviZ := zScores(areas, func(a Indicators) sql.NullFloat64 { return a.CCVI })
+
+	scores := make([]Score, 0, len(areas))
+	for i, area := range areas {
+		if incomeZ[i].Valid {
+			incomeZ[i].Float64 = -incomeZ[i].Float64
+		}
+		score := Score{
+			CommunityArea: area.CommunityArea,
+			PovertyZ:      povertyZ[i],
+			UnemploymentZ: unemploymentZ[i],
+			IncomeZ:       incomeZ[i],
+			CCVIZ:         ccviZ[i],
+		}
+
+		var sum, weightSum float64
+		for _, part := range []struct {
+			z      sql.NullFloat64
+			weight float64
+		}{
+			{score.PovertyZ, weights.Poverty},
+			{score.UnemploymentZ, weights.Unemployment},
+			{score.IncomeZ, weights.Income},
+			{score.CCVIZ, weights.CCVI},
+		} {
+			if part.z.Valid && part.weight > 0 {
+				sum += part.weight * part.z.Float64
+				weightSum += part.weight
+			}
+		}
+		if weightSum == 0 {
+			continue
+		}
+		score.CompositeScore = sum / weightSum
+		scores = append(scores, score)
+	}
+
+	slices.SortFunc(scores, func(a, b Score) int {
+		if c := cmp.Compare(b.CompositeScore, a.CompositeScore); c != 0 {
+			return c
+		}
+		return compareCommunityAreas(a.CommunityArea, b.CommunityArea)
+	})
+	for i := range scores {
+		scores[i].Rank = i + 1
+	}
+	return scores
+}
+
+// zScores standardizes one indicator across the areas that have it, using the population standard
+// deviation. It returns no values when the indicator does not vary.
+func zScores(areas []Indicators, value func(Indicators) sql.NullFloat64) []sql.NullFloat64 {
+	var sum, count float64
+	for _, area := range areas {
+		if v := value(area); v.Valid {
+			sum += v.Float64
+			count++
+		}
+	}
+	z := make([]sql.NullFloat64, len(areas))
+	if count < 2 {
+		return z
+	}
+
+	mean := sum / count
+	var squares float64
+	for _, area := range areas {
+		if v := value(area); v.Valid {
+			squares += (v.Float64 - mean) * (v.Float64 - mean)
+		}
+	}
+	stddev := math.Sqrt(squares / count)
+	if stddev == 0 {
+		return z
+	}
+
+	for i, area := range areas {
+		if v := value(area); v.Valid {
+			z[i] = sql.NullFloat64{Float64: (v.Float64 - mean) / stddev, Valid: true}
+		}
+	}
+	return z
+}
+
+// compareCommunityAreas orders community area numbers numerically.
+func compareCommunityAreas(a, b string) int {
+	ai, aErr := strconv.Atoi(a)
+	bi, bErr := strconv.Atoi(b)
+	if aErr != nil || bErr != nil {
+		return strings.Compare(a, b)
+	}
+	return cmp.Compare(ai, bi)
+}
+
+// LoadIndicators reads the indicators of every community area in publicHealthTable and, when ccviTable is
+// not empty, the community area CCVI scores in ccviTable.
+func LoadIndicators(q Queryer, publicHealthTable, ccviTable string) ([]Indicators, error) {
+	ccviJoin, ccviColumn := "", "NULL::FLOAT8"
+	if ccviTable != "" {
+		ccviJoin = fmt.Sprintf(`LEFT JOIN %s c ON c."geography_type" = 'CA' AND c."community_area_or_zip" = ph."community_area"`,
+			pq.QuoteIdentifier(ccviTable))
+		ccviColumn = `c."ccvi_score"`
+	}
+
+	rows, err := q.Query(fmt.Sprintf(`SELECT ph."community_area", ph."below_poverty_level", ph."unemployment", ph."per_capita_income", %s
+		FROM %s ph
+		%s`, ccviColumn, pq.QuoteIdentifier(publicHealthTable), ccviJoin))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query composite score indicators: %w", err)
+	}
+	defer rows.Close()
+
+	var areas []Indicators
+	for rows.Next() {
+		var area Indicators
+		if err := rows.Scan(&area.CommunityArea, &area.Poverty, &area.Unemployment, &area.Income, &area.CCVI); err != nil {
+			return nil, fmt.Errorf("failed to scan composite score indicators: %w", err)
+		}
+		areas = append(areas, area)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading composite score indicators: %w", err)
+	}
+	return areas, nil
+}
+
+// Store replaces the composite_scores table with scores, recording weights on every row.
+func Store(q Queryer, scores []Score, weights Weights) error {
+	definition, err := json.Marshal(weights)
+	if err != nil {
+		return fmt.Errorf("failed to encode composite score weights: %w", err)
+	}
+
+	table := pq.QuoteIdentifier(Table)
+	createStmts := []string{
+		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, table),
+		fmt.Sprintf(`CREATE TABLE %s (
+			"community_area" VARCHAR(2) PRIMARY KEY,
+			"poverty_z" FLOAT8,
+			"unemployment_z" FLOAT8,
+			"income_z" FLOAT8,
+			"ccvi_z" FLOAT8,
+			"composite_score" FLOAT8 NOT NULL,
+			"composite_rank" INTEGER NOT NULL,
+			"weights" JSONB NOT NULL,
+			"computed_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		)`, table),
+	}
+	for _, statement := range createStmts {
+		if _, err := q.Exec(statement); err != nil {
+			return fmt.Errorf("failed to create %s: %w", Table, err)
+		}
+	}
+
+	insertStmt := fmt.Sprintf(`INSERT INTO %s ("community_area", "poverty_z", "unemployment_z", "income_z", "ccvi_z", "composite_score", "composite_rank", "weights")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, table)
+	for _, score := range scores {
+		if _, err := q.Exec(insertStmt, score.CommunityArea, score.PovertyZ, score.UnemploymentZ, score.IncomeZ, score.CCVIZ,
+			score.CompositeScore, score.Rank, string(definition)); err != nil {
+			return fmt.Errorf("failed to store composite score of community area %s: %w", score.CommunityArea, err)
+		}
+	}
+	return nil
+}
+
+// Refresh recomputes the composite scores from publicHealthTable and ccviTable, which may be empty to score
+// without CCVI, and stores them.
+func Refresh(q Queryer, publicHealthTable, ccviTable string, weights Weights) ([]Score, error) {
+	areas, err := LoadIndicators(q, publicHealthTable, ccviTable)
+	if err != nil {
+		return nil, err
+	}
+	scores := Compute(areas, weights)
+	if err := Store(q, scores, weights); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
@@ -0,0 +1,5 @@
+// Package scoring computes the composite disadvantage score of each community area: the weighted mean of
+// the z-scores of its poverty rate, unemployment rate, per capita income, and CCVI score. Report builders
+// rank areas by it rather than by any single indicator, and the scores are kept in the composite_scores
+// table together with the weights that produced them.
+package scoring
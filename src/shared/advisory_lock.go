@@ -0,0 +1,71 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Advisory lock namespaces keep the lock keys of different services from colliding. They are the first
+// key of Postgres's two-key advisory locks; the second is a hash of the lock name.
+const (
+	ReportLockNamespace    = 7301
+	CollectorLockNamespace = 7302
+)
+
+// AdvisoryLock is a session-level Postgres advisory lock held on a dedicated connection, so it lasts until
+// Release is called or the connection is lost, e.g. when the instance holding it dies.
+type AdvisoryLock struct {
+	conn      *sql.Conn
+	namespace int
+	name      string
+}
+
+// TryAdvisoryLock takes the advisory lock name in namespace without waiting. It returns a nil lock and no
+// error when another session holds it. Locks are scoped to the DB schema, so deployments of different city
+// profiles sharing a database do not wait on each other.
+func TryAdvisoryLock(ctx context.Context, db *sql.DB, namespace int, name string) (*AdvisoryLock, error) {
+	if db == nil {
+		return nil, errors.New("db connection is nil")
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get a connection for lock %s: %w", name, err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1, hashtext($2))`, namespace, advisoryLockKey(name)).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to take lock %s: %w", name, err)
+	}
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+	return &AdvisoryLock{conn: conn, namespace: namespace, name: name}, nil
+}
+
+// Held reports whether the session holding the lock is still connected. A lost connection releases the
+// lock on the server, so another instance may have taken it since.
+func (l *AdvisoryLock) Held(ctx context.Context) bool {
+	return l.conn.PingContext(ctx) == nil
+}
+
+// Release gives the lock up and returns its connection to the pool.
+func (l *AdvisoryLock) Release() {
+	if _, err := l.conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, hashtext($2))`, l.namespace, advisoryLockKey(l.name)); err != nil {
+		log.Printf("failed to release lock %s: %v", l.name, err)
+	}
+	l.conn.Close()
+}
+
+// advisoryLockKey qualifies name with the DB schema, when there is one.
+func advisoryLockKey(name string) string {
+	if schema := DBSchema(); schema != "" {
+		return schema + "." + name
+	}
+	return name
+}
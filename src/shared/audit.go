@@ -0,0 +1,180 @@
+package shared
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// AuditTable records calls to trigger, export, and admin endpoints.
+	AuditTable = "api_audit"
+	// AuditRetentionEnvKey sets how many days of audit rows PruneAuditLog keeps; 0 keeps them forever.
+	AuditRetentionEnvKey = "API_AUDIT_RETENTION_DAYS"
+
+	defaultAuditRetentionDays = 365
+	// auditBodyLimit caps how much of a request body is kept as the call's parameters.
+	auditBodyLimit = 4096
+)
+
+// AuditEntry is one row of the api_audit table.
+type AuditEntry struct {
+	CalledAt   time.Time
+	Service    string
+	Identity   string
+	Method     string
+	Endpoint   string
+	Parameters string
+	Body       string
+	Status     int
+	DurationMS int64
+}
+
+// AuditHandler records every call to next in the api_audit table once it has been answered. identify names
+// the caller; RequestIdentity is used when it is nil. Failures to record are logged so that bookkeeping
+// never fails the call itself.
+func AuditHandler(db *sql.DB, service string, identify func(*http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	if identify == nil {
+		identify = RequestIdentity
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := AuditEntry{
+			CalledAt:   time.Now(),
+			Service:    service,
+			Identity:   identify(r),
+			Method:     r.Method,
+			Endpoint:   r.URL.Path,
+			Parameters: r.URL.Query().Encode(),
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			head, err := io.ReadAll(io.LimitReader(r.Body, auditBodyLimit))
+			if err == nil {
+				entry.Body = string(head)
+			}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+
+		entry.Status = recorder.status
+		entry.DurationMS = time.Since(entry.CalledAt).Milliseconds()
+		if err := RecordAudit(db, entry); err != nil {
+			log.Printf("failed to audit %s %s: %v", entry.Method, entry.Endpoint, err)
+		}
+	}
+}
+
+// RecordAudit stores one audit row.
+func RecordAudit(db *sql.DB, entry AuditEntry) error {
+	if db == nil {
+		return errors.New("db connection is nil")
+	}
+
+	stmt := fmt.Sprintf(`INSERT INTO %q ("called_at", "service", "identity", "method", "endpoint", "parameters", "body", "status", "duration_ms", "build_version")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`, AuditTable)
+	if _, err := db.Exec(stmt, entry.CalledAt, entry.Service, entry.Identity, entry.Method, entry.Endpoint,
+		entry.Parameters, entry.Body, entry.Status, entry.DurationMS, Version()); err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// PruneAuditLog deletes audit rows older than API_AUDIT_RETENTION_DAYS and returns how many were removed.
+func PruneAuditLog(db *sql.DB) (int64, error) {
+	if db == nil {
+		return 0, errors.New("db connection is nil")
+	}
+
+	days := AuditRetentionDays()
+	if days == 0 {
+		return 0, nil
+	}
+	result, err := db.Exec(fmt.Sprintf(`DELETE FROM %q WHERE "called_at" < NOW() - make_interval(days => $1)`, AuditTable), days)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune %s: %w", AuditTable, err)
+	}
+	removed, _ := result.RowsAffected()
+	return removed, nil
+}
+
+// AuditRetentionDays returns API_AUDIT_RETENTION_DAYS, defaulting to a year.
+func AuditRetentionDays() int {
+	raw := strings.TrimSpace(os.Getenv(AuditRetentionEnvKey))
+	if raw == "" {
+		return defaultAuditRetentionDays
+	}
+
+	days, err := strconv.Atoi(raw)
+	if err != nil || days < 0 {
+		log.Printf("invalid %s value %q; defaulting to %d days", AuditRetentionEnvKey, raw, defaultAuditRetentionDays)
+		return defaultAuditRetentionDays
+	}
+	return days
+}
+
+// RequestIdentity names the caller of a request for the audit log: the user Identity-Aware Proxy
+// authenticated, else the email or subject of the bearer ID token Cloud Run's invoker check verified, else
+// "anonymous". The token is not verified again here.
+func RequestIdentity(r *http.Request) string {
+	if email := r.Header.Get("X-Goog-Authenticated-User-Email"); email != "" {
+		return strings.TrimPrefix(email, "accounts.google.com:")
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "anonymous"
+	}
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return "anonymous"
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "anonymous"
+	}
+	var claims struct {
+		Email   string `json:"email"`
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "anonymous"
+	}
+	switch {
+	case claims.Email != "":
+		return claims.Email
+	case claims.Subject != "":
+		return claims.Subject
+	default:
+		return "anonymous"
+	}
+}
+
+// statusRecorder remembers the status code a handler answered with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
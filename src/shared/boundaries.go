@@ -0,0 +1,175 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// BoundaryLayer is a spatial dataset of polygons loaded into a PostGIS table, one row per feature, keyed by
+// one of the feature's properties.
+type BoundaryLayer struct {
+	Dataset SpatialDataset
+	Table   string
+	// KeyColumn holds the feature property KeyProperty, e.g. the community area number.
+	KeyColumn   string
+	KeyProperty string
+	// NameColumn, when set, holds the feature property NameProperty, e.g. the community area name.
+	NameColumn   string
+	NameProperty string
+}
+
+// CommunityAreaBoundaries loads the 77 community areas into community_area_boundaries.
+var CommunityAreaBoundaries = BoundaryLayer{
+	Dataset:      CommunityAreasDataset,
+	Table:        "community_area_boundaries",
+	KeyColumn:    "community_area",
+	KeyProperty:  "area_numbe",
+	NameColumn:   "community",
+	NameProperty: "community",
+}
+
+// ZipBoundaries loads the ZIP code boundaries into zip_boundaries.
+var ZipBoundaries = BoundaryLayer{
+	Dataset:     ZipCodesDataset,
+	Table:       "zip_boundaries",
+	KeyColumn:   "zip_code",
+	KeyProperty: "zip",
+}
+
+// CensusTractBoundaries loads the 2010 census tracts into census_tract_boundaries, keyed by their 11 digit
+// GEOID, e.g. 17031842400.
+var CensusTractBoundaries = BoundaryLayer{
+	Dataset:      CensusTractsDataset,
+	Table:        "census_tract_boundaries",
+	KeyColumn:    "census_tract",
+	KeyProperty:  "geoid10",
+	NameColumn:   "name",
+	NameProperty: "name10",
+}
+
+// geoJSONFeatureCollection is the part of a GeoJSON file LoadBoundaryLayer reads.
+type geoJSONFeatureCollection struct {
+	Features []struct {
+		Geometry   json.RawMessage `json:"geometry"`
+		Properties map[string]any  `json:"properties"`
+	} `json:"features"`
+}
+
+// LoadBoundaryLayer replaces the rows of layer.Table with the features of its spatial dataset, downloading
+// the dataset into SPATIAL_DATA_DIR when it is not cached there yet, and records the refresh. The table is
+// created, with a GIST index on its geometry, when it does not exist, and replaced in one transaction, so
+// readers see either the previous boundaries or the new ones. Features without a geometry or key are
+// skipped. It returns how many boundaries were loaded.
+func LoadBoundaryLayer(ctx context.Context, db *sql.DB, layer BoundaryLayer) (int, error) {
+	if db == nil {
+		return 0, errors.New("db connection is nil")
+	}
+
+	paths, err := EnsureSpatialDatasets(ctx, layer.Dataset)
+	if err != nil {
+		return 0, err
+	}
+	path := paths[layer.Dataset.Name]
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var collection geoJSONFeatureCollection
+	if err := json.Unmarshal(raw, &collection); err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start %s transaction: %w", layer.Table, err)
+	}
+	defer tx.Rollback()
+
+	if err := UsePostGIS(tx); err != nil {
+		return 0, err
+	}
+
+	nameColumn := ""
+	if layer.NameColumn != "" {
+		nameColumn = fmt.Sprintf("%q VARCHAR(255),\n\t\t", layer.NameColumn)
+	}
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+		%q VARCHAR(16) PRIMARY KEY,
+		%s"geom" geometry(MultiPolygon, 4326) NOT NULL
+	)`, layer.Table, layer.KeyColumn, nameColumn),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %q ON %q USING GIST ("geom")`, layer.Table+"_geom_idx", layer.Table),
+		// Taken before reading the table, so instances starting together load it one after the other.
+		fmt.Sprintf(`LOCK TABLE %q IN EXCLUSIVE MODE`, layer.Table),
+		fmt.Sprintf(`DELETE FROM %q`, layer.Table),
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return 0, fmt.Errorf("failed to prepare %s: %w", layer.Table, err)
+		}
+	}
+
+	columns, values := fmt.Sprintf("%q", layer.KeyColumn), "$1"
+	if layer.NameColumn != "" {
+		columns, values = fmt.Sprintf("%q, %q", layer.KeyColumn, layer.NameColumn), "$1, NULLIF($3, '')"
+	}
+	insert, err := tx.PrepareContext(ctx, fmt.Sprintf(`INSERT INTO %q (%s, "geom")
+		VALUES (%s, ST_Multi(ST_SetSRID(ST_GeomFromGeoJSON($2), 4326)))
+		ON CONFLICT DO NOTHING`, layer.Table, columns, values))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare %s insert: %w", layer.Table, err)
+	}
+	defer insert.Close()
+
+	loaded := 0
+	for _, feature := range collection.Features {
+		value, ok := feature.Properties[layer.KeyProperty]
+		key := fmt.Sprint(value)
+		if !ok || value == nil || key == "" || len(feature.Geometry) == 0 || string(feature.Geometry) == "null" {
+			continue
+		}
+		args := []any{key, string(feature.Geometry)}
+		if layer.NameColumn != "" {
+			name, _ := feature.Properties[layer.NameProperty].(string)
+			args = append(args, name)
+		}
+		if _, err := insert.ExecContext(ctx, args...); err != nil {
+			return 0, fmt.Errorf("failed to insert %s boundary %s: %w", layer.Table, key, err)
+		}
+		loaded++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit %s: %w", layer.Table, err)
+	}
+	if err := RecordTableRefresh(db, layer.Table, loaded); err != nil {
+		return loaded, err
+	}
+	return loaded, nil
+}
+
+// UsePostGIS installs PostGIS if it is missing and, for the rest of tx, appends the schema it lives in to
+// the search_path, which otherwise names only DB_SCHEMA and would hide the geometry type and functions.
+func UsePostGIS(tx *sql.Tx) error {
+	if _, err := tx.Exec(`CREATE EXTENSION IF NOT EXISTS postgis`); err != nil {
+		return fmt.Errorf("failed to enable postgis: %w", err)
+	}
+	return SearchPostGIS(tx)
+}
+
+// SearchPostGIS appends the schema PostGIS is installed in to the search_path for the rest of tx, without
+// installing it, so read-only transactions can call its functions.
+func SearchPostGIS(tx *sql.Tx) error {
+	_, err := tx.Exec(`SELECT set_config('search_path', current_setting('search_path') || ', ' || quote_ident(n.nspname), true)
+		FROM pg_extension e
+		JOIN pg_namespace n ON n.oid = e.extnamespace
+		WHERE e.extname = 'postgis'`)
+	if err != nil {
+		return fmt.Errorf("failed to add the postgis schema to the search_path: %w", err)
+	}
+	return nil
+}
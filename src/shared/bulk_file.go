@@ -0,0 +1,266 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// portalTimestampLayout is how the data portal's CSV exports write timestamps, e.g. "01/02/2022 03:15:00 PM".
+const portalTimestampLayout = "01/02/2006 03:04:05 PM"
+
+// bulkClient downloads bulk exports. It has no overall timeout, since a full export can take far longer
+// than any API call; the caller's context bounds the download instead. Responses bypass the HTTP cache.
+var bulkClient = &http.Client{Transport: slowTransport}
+
+// PortalExportURL is the data portal's CSV export of a whole dataset, given by its Chicago identifier. It
+// fails when the city profile does not publish the dataset.
+func PortalExportURL(datasetID string) (string, error) {
+	resource, err := CityResource(datasetID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/api/views/%s/rows.csv?accessType=DOWNLOAD", SODADomain(), resource), nil
+}
+
+// OpenBulkFile opens a bulk export for streaming. source is a local path, a gs://bucket/object, or an
+// http(s) URL. Gzip and zstd compressed files, recognized by their leading magic bytes rather than their
+// names, are decompressed as they are read. source is recorded as a source of the ingest run of ctx.
+func OpenBulkFile(ctx context.Context, source string) (io.ReadCloser, error) {
+	NoteIngestSource(ctx, source)
+	file, err := openBulkSource(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := decompressBulkFile(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open %s: %w", source, err)
+	}
+	return reader, nil
+}
+
+func openBulkSource(ctx context.Context, source string) (io.ReadCloser, error) {
+	if bucketObject, ok := strings.CutPrefix(source, "gs://"); ok {
+		bucket, object, _ := strings.Cut(bucketObject, "/")
+		if bucket == "" || object == "" {
+			return nil, fmt.Errorf("invalid GCS location %q; expected gs://bucket/object", source)
+		}
+		token, err := googleAccessTokens.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		objectURL := fmt.Sprintf("%s/b/%s/o/%s?alt=media", gcsAPIBase, url.PathEscape(bucket), url.PathEscape(object))
+		return openBulkURL(ctx, objectURL, "Bearer "+token, source)
+	}
+
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		return openBulkURL(ctx, source, "", source)
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", source, err)
+	}
+	return file, nil
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// bulkReader reads a possibly decompressed bulk file and closes it along with the file underneath.
+type bulkReader struct {
+	io.Reader
+	closers []func() error
+}
+
+func (r *bulkReader) Close() error {
+	var errs []error
+	for _, closeFn := range r.closers {
+		errs = append(errs, closeFn())
+	}
+	return errors.Join(errs...)
+}
+
+// decompressBulkFile wraps file in a gzip or zstd decompressor when it starts with their magic bytes.
+func decompressBulkFile(file io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReaderSize(file, 64<<10)
+	magic, err := buffered.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip stream: %w", err)
+		}
+		return &bulkReader{Reader: gz, closers: []func() error{gz.Close, file.Close}}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd stream: %w", err)
+		}
+		return &bulkReader{Reader: zr, closers: []func() error{func() error { zr.Close(); return nil }, file.Close}}, nil
+	}
+	return &bulkReader{Reader: buffered, closers: []func() error{file.Close}}, nil
+}
+
+func openBulkURL(ctx context.Context, location, authorization, source string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct download request: %w", err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := bulkClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", source, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status downloading %s: %s", source, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// CSVReader streams the rows of a CSV file with a header row as records of type T. Columns are matched to
+// the json tags of T's string fields by name, or their SODAAliasTag, ignoring case and treating spaces as
+// underscores, so a portal export's "Trip Start Timestamp" column fills the trip_start_timestamp field. Columns without a matching
+// field are ignored. Timestamps in the portal export format are rewritten to SODA's floating timestamp
+// format, so records read from an export validate like records fetched from the API.
+type CSVReader[T any] struct {
+	reader  *csv.Reader
+	columns []int
+	rows    int
+}
+
+// NewCSVReader reads the header row of r and verifies that every field of T has a column, so a file with
+// the wrong layout is rejected before any row is loaded.
+func NewCSVReader[T any](r io.Reader) (*CSVReader[T], error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	var zero T
+	recordType := reflect.TypeOf(zero)
+	if recordType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("CSV records must decode into a struct, not %s", recordType)
+	}
+	fieldsByName := make(map[string]int)
+	fieldNames := make(map[int]string)
+	for i := 0; i < recordType.NumField(); i++ {
+		field := recordType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" || field.Type.Kind() != reflect.String {
+			continue
+		}
+		fieldsByName[name] = i
+		fieldNames[i] = name
+		if alias := field.Tag.Get(SODAAliasTag); alias != "" {
+			fieldsByName[alias] = i
+		}
+	}
+
+	columns := make([]int, len(header))
+	found := make(map[int]bool, len(fieldNames))
+	for i, column := range header {
+		name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		name = strings.NewReplacer(" ", "_", "-", "_").Replace(name)
+		if field, ok := fieldsByName[name]; ok {
+			columns[i] = field
+			found[field] = true
+		} else {
+			columns[i] = -1
+		}
+	}
+
+	var missing []string
+	for field, name := range fieldNames {
+		if !found[field] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("CSV header lacks columns %s", strings.Join(missing, ", "))
+	}
+
+	return &CSVReader[T]{reader: reader, columns: columns}, nil
+}
+
+// Read returns the next record, or io.EOF after the last one.
+func (c *CSVReader[T]) Read() (T, error) {
+	var record T
+	row, err := c.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return record, io.EOF
+	}
+	if err != nil {
+		return record, fmt.Errorf("failed to read CSV row %d: %w", c.rows+2, err)
+	}
+
+	value := reflect.ValueOf(&record).Elem()
+	for i, cell := range row {
+		if i < len(c.columns) && c.columns[i] >= 0 {
+			value.Field(c.columns[i]).SetString(portalTimestampToSODA(cell))
+		}
+	}
+	c.rows++
+	return record, nil
+}
+
+// Each calls handle for every remaining record, stopping at the first error, and returns the number of
+// rows read so far.
+func (c *CSVReader[T]) Each(handle func(T) error) (int, error) {
+	for {
+		record, err := c.Read()
+		if errors.Is(err, io.EOF) {
+			return c.rows, nil
+		}
+		if err != nil {
+			return c.rows, err
+		}
+		if err := handle(record); err != nil {
+			return c.rows, err
+		}
+	}
+}
+
+// portalTimestampToSODA rewrites a portal export timestamp to SODA's floating timestamp format and returns
+// any other value unchanged.
+func portalTimestampToSODA(value string) string {
+	if len(value) != len(portalTimestampLayout) {
+		return value
+	}
+	parsed, err := time.Parse(portalTimestampLayout, value)
+	if err != nil {
+		return value
+	}
+	return parsed.Format("2006-01-02T15:04:05.000")
+}
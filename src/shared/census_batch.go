@@ -0,0 +1,142 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kelvins/geocoder"
+)
+
+// CensusBatchSize is the most addresses the Census batch geocoder accepts in one upload.
+const CensusBatchSize = 10000
+
+// BatchAddress is one row of a Census batch geocoding upload.
+type BatchAddress struct {
+	// ID is echoed back in the response and must be unique within the batch.
+	ID     string
+	Street string
+	City   string
+	State  string
+	Zip    string
+}
+
+// BatchMatch is the Census geocoder's answer for one matched BatchAddress.
+type BatchMatch struct {
+	Location geocoder.Location
+	// Zip is the postal code of the matched address.
+	Zip string
+	// Tract is the 11-digit census tract GEOID (state, county, and tract FIPS codes).
+	Tract string
+}
+
+// censusBatchClient allows for the minutes the Census geocoder takes on a full batch.
+var censusBatchClient = &http.Client{Timeout: 10 * time.Minute}
+
+// CensusBatchGeocode geocodes addresses through the free US Census batch geocoder, uploading them in
+// CSV batches of CensusBatchSize. It returns the matched addresses keyed by ID; unmatched and ambiguous
+// addresses are left out.
+func CensusBatchGeocode(ctx context.Context, addresses []BatchAddress) (map[string]BatchMatch, error) {
+	matches := make(map[string]BatchMatch, len(addresses))
+	for start := 0; start < len(addresses); start += CensusBatchSize {
+		end := min(start+CensusBatchSize, len(addresses))
+		if err := censusBatch(ctx, addresses[start:end], matches); err != nil {
+			return matches, fmt.Errorf("failed to geocode batch of addresses %d-%d: %w", start, end-1, err)
+		}
+	}
+	return matches, nil
+}
+
+func censusBatch(ctx context.Context, addresses []BatchAddress, matches map[string]BatchMatch) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for field, value := range map[string]string{"benchmark": "Public_AR_Current", "vintage": "Current_Current"} {
+		if err := form.WriteField(field, value); err != nil {
+			return err
+		}
+	}
+	file, err := form.CreateFormFile("addressFile", "addresses.csv")
+	if err != nil {
+		return err
+	}
+	rows := csv.NewWriter(file)
+	for _, address := range addresses {
+		if err := rows.Write([]string{address.ID, address.Street, address.City, address.State, address.Zip}); err != nil {
+			return err
+		}
+	}
+	rows.Flush()
+	if err := rows.Error(); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, censusGeocoderURL+"/geographies/addressbatch", &body)
+	if err != nil {
+		return fmt.Errorf("failed to build batch geocoder request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("User-Agent", geocoderUserAgent)
+
+	resp, err := censusBatchClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("batch geocoder request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("batch geocoder returned %s", resp.Status)
+	}
+	return parseCensusBatch(resp.Body, matches)
+}
+
+// parseCensusBatch reads the batch response CSV. Matched rows carry the columns id, input address,
+// "Match", match type, matched address, "lon,lat", TIGER line id, side, state, county, tract, and block;
+// other rows stop after the match column.
+func parseCensusBatch(r io.Reader, matches map[string]BatchMatch) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read batch geocoder response: %w", err)
+		}
+		if len(record) < 6 || record[2] != "Match" {
+			continue
+		}
+
+		lon, lat, ok := strings.Cut(record[5], ",")
+		if !ok {
+			continue
+		}
+		longitude, lonErr := strconv.ParseFloat(lon, 64)
+		latitude, latErr := strconv.ParseFloat(lat, 64)
+		if lonErr != nil || latErr != nil {
+			continue
+		}
+
+		match := BatchMatch{Location: geocoder.Location{Latitude: latitude, Longitude: longitude}}
+		// Matched addresses read "1234 N CLARK ST, CHICAGO, IL, 60610".
+		if i := strings.LastIndex(record[4], ","); i >= 0 {
+			match.Zip = strings.TrimSpace(record[4][i+1:])
+		}
+		if len(record) >= 11 {
+			match.Tract = record[8] + record[9] + record[10]
+		}
+		matches[record[0]] = match
+	}
+}
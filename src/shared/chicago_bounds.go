@@ -0,0 +1,25 @@
+package shared
+
+import "github.com/kelvins/geocoder"
+
+// The bounding box of Chicago's city limits, from the southern tip at 138th Street to the northern edge at
+// Howard Street and from O'Hare to the lakefront.
+const (
+	chicagoMinLatitude  = 41.6445
+	chicagoMaxLatitude  = 42.0230
+	chicagoMinLongitude = -87.9401
+	chicagoMaxLongitude = -87.5237
+)
+
+// ChicagoBoundsTolerance pads the bounding box by this many degrees, about 2 km, so points on the city
+// limits, and the centroids of areas that straddle them, are not rejected.
+const ChicagoBoundsTolerance = 0.02
+
+// InChicago reports whether location lies within Chicago's bounding box, padded by ChicagoBoundsTolerance.
+// It is a cheap sanity check for bad coordinates and geocodes, not a test of the city limits themselves.
+func InChicago(location geocoder.Location) bool {
+	return location.Latitude >= chicagoMinLatitude-ChicagoBoundsTolerance &&
+		location.Latitude <= chicagoMaxLatitude+ChicagoBoundsTolerance &&
+		location.Longitude >= chicagoMinLongitude-ChicagoBoundsTolerance &&
+		location.Longitude <= chicagoMaxLongitude+ChicagoBoundsTolerance
+}
@@ -0,0 +1,195 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// CityProfileEnvKey selects the city the services ingest: "chicago", the default, or the path of a JSON
+	// file describing another Socrata city as a CityProfile.
+	CityProfileEnvKey = "CITY_PROFILE"
+
+	// ChicagoSODADomain is the City of Chicago data portal.
+	ChicagoSODADomain = "https://data.cityofchicago.org"
+)
+
+// CityProfile describes where the services read one city's data from. The record structs, tables, and
+// reports are shared by every profile, so a profile can only point collectors at datasets publishing the
+// same columns as Chicago's.
+type CityProfile struct {
+	// Name identifies the profile in logs, e.g. "chicago" or "nyc".
+	Name string `json:"name"`
+	// SODADomain is the Socrata portal collectors pull from, e.g. "https://data.cityofnewyork.us".
+	SODADomain string `json:"soda_domain"`
+	// Schema is the Postgres schema the profile's tables live in when DB_SCHEMA is unset. It defaults to the
+	// profile name for profiles other than Chicago, so two cities never share tables.
+	Schema string `json:"schema"`
+	// Datasets maps dataset names (the Name of each SODASource, e.g. "taxi_trips") to the Socrata
+	// identifiers of the profile's portal. Collectors ask for Chicago's identifiers, which are translated
+	// through these names.
+	Datasets map[string]string `json:"datasets"`
+	// Boundaries maps spatial dataset names (e.g. "zip_codes") to the GeoJSON URLs of the profile's
+	// boundaries. Files are cached in a subdirectory of SPATIAL_DATA_DIR named after the profile.
+	Boundaries map[string]string `json:"boundaries"`
+	// CrosswalkDir is the subdirectory of src/data holding the profile's geography crosswalk CSVs.
+	CrosswalkDir string `json:"crosswalk_dir"`
+	// Collectors lists the collector jobs the profile runs; empty runs them all.
+	Collectors []string `json:"collectors"`
+}
+
+// ChicagoProfile is the built-in profile the services use unless CITY_PROFILE names another.
+var ChicagoProfile = CityProfile{
+	Name:       "chicago",
+	SODADomain: ChicagoSODADomain,
+	Datasets: map[string]string{
+		"ccvi":              "xhc6-88s9",
+		"covid":             "yhhz-zm2v",
+		"public_health":     "iqnk-2tcu",
+		"building_permits":  "ydr8-5enu",
+		"taxi_trips":        "wrvz-psew",
+		"tnp_trips":         "m6dm-c72p",
+		"cta_rail":          "5neh-572f",
+		"cta_bus":           "jyb9-n7fm",
+		"cta_stops":         "8pix-ypme",
+		"vacant_buildings":  "v6vf-nfxy",
+		"food_inspections":  "4ijn-s7e5",
+		"business_licenses": "r5kz-chrr",
+	},
+}
+
+// profileNamePattern keeps profile names usable as schema and directory names.
+var profileNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// cityProfile holds the profile loaded from CITY_PROFILE on first use.
+var cityProfile struct {
+	once    sync.Once
+	profile CityProfile
+	err     error
+}
+
+// CurrentCityProfile returns the profile selected by CITY_PROFILE, loading it on first use. A profile that
+// does not load is a required configuration problem, so the services exit at startup through
+// CheckRequiredConfig rather than pull one city's data into another's tables.
+func CurrentCityProfile() (CityProfile, error) {
+	cityProfile.once.Do(func() {
+		cityProfile.profile, cityProfile.err = loadCityProfile(strings.TrimSpace(os.Getenv(CityProfileEnvKey)))
+		if cityProfile.err == nil && cityProfile.profile.Name != ChicagoProfile.Name {
+			log.Printf("using the %s city profile (%s)", cityProfile.profile.Name, cityProfile.profile.SODADomain)
+		}
+	})
+	return cityProfile.profile, cityProfile.err
+}
+
+// activeCityProfile returns the current profile, or ChicagoProfile when CITY_PROFILE cannot be loaded.
+func activeCityProfile() CityProfile {
+	profile, err := CurrentCityProfile()
+	if err != nil {
+		return ChicagoProfile
+	}
+	return profile
+}
+
+// loadCityProfile returns ChicagoProfile for "" or "chicago", and otherwise reads the profile file at
+// source.
+func loadCityProfile(source string) (CityProfile, error) {
+	if source == "" || strings.EqualFold(source, ChicagoProfile.Name) {
+		return ChicagoProfile, nil
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return CityProfile{}, fmt.Errorf("failed to read city profile %s: %w", source, err)
+	}
+	var profile CityProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return CityProfile{}, fmt.Errorf("failed to decode city profile %s: %w", source, err)
+	}
+
+	switch {
+	case !profileNamePattern.MatchString(profile.Name):
+		return CityProfile{}, fmt.Errorf("city profile %s: name %q must be lowercase letters, digits, and underscores", source, profile.Name)
+	case profile.Name == ChicagoProfile.Name:
+		return CityProfile{}, fmt.Errorf("city profile %s: the name %s is reserved for the built-in profile", source, ChicagoProfile.Name)
+	case !strings.HasPrefix(profile.SODADomain, "https://"):
+		return CityProfile{}, fmt.Errorf("city profile %s: soda_domain %q must be an https URL", source, profile.SODADomain)
+	case len(profile.Datasets) == 0:
+		return CityProfile{}, fmt.Errorf("city profile %s: no datasets", source)
+	case profile.Schema != "" && !profileNamePattern.MatchString(profile.Schema):
+		return CityProfile{}, fmt.Errorf("city profile %s: schema %q must be lowercase letters, digits, and underscores", source, profile.Schema)
+	}
+	profile.SODADomain = strings.TrimRight(profile.SODADomain, "/")
+	if profile.Schema == "" {
+		profile.Schema = profile.Name
+	}
+	return profile, nil
+}
+
+// SODADomain returns the Socrata portal of the current city profile.
+func SODADomain() string {
+	return activeCityProfile().SODADomain
+}
+
+// CitySODAResource translates chicagoID, the identifier of a Chicago dataset, into the identifier of the
+// same dataset on the current profile's portal. ok is false when the profile does not publish it, in which
+// case chicagoID is returned unchanged. Identifiers that are not Chicago datasets, such as a resource chosen
+// in configuration, are the profile's own and returned unchanged.
+func CitySODAResource(chicagoID string) (id string, ok bool) {
+	profile := activeCityProfile()
+	if profile.Name == ChicagoProfile.Name {
+		return chicagoID, true
+	}
+	for name, candidate := range ChicagoProfile.Datasets {
+		if candidate != chicagoID {
+			continue
+		}
+		id, ok := profile.Datasets[name]
+		if !ok {
+			return chicagoID, false
+		}
+		return id, true
+	}
+	return chicagoID, true
+}
+
+// CityResource is CitySODAResource failing, rather than returning Chicago's identifier to be queried on
+// another city's portal, when the profile does not publish the dataset.
+func CityResource(chicagoID string) (string, error) {
+	id, ok := CitySODAResource(chicagoID)
+	if !ok {
+		return "", Invalid(fmt.Errorf("the %s city profile does not publish dataset %s", activeCityProfile().Name, chicagoID))
+	}
+	return id, nil
+}
+
+// RunsCollector reports whether the current profile runs the collector job named job.
+func RunsCollector(job string) bool {
+	collectors := activeCityProfile().Collectors
+	return len(collectors) == 0 || slices.Contains(collectors, job)
+}
+
+// profileSpatialDataset points ds at the current profile's boundaries, cached under a subdirectory named
+// after the profile. Its URL is left empty when the profile has none, so Chicago's are never downloaded in
+// their place.
+func profileSpatialDataset(ds SpatialDataset) SpatialDataset {
+	profile := activeCityProfile()
+	if profile.Name == ChicagoProfile.Name {
+		return ds
+	}
+	ds.URL = profile.Boundaries[ds.Name]
+	ds.FileName = filepath.Join(profile.Name, ds.FileName)
+	return ds
+}
+
+// CrosswalkPath returns the path of the geography crosswalk CSV name of the current profile, under
+// projectRoot's src/data.
+func CrosswalkPath(projectRoot, name string) string {
+	return filepath.Join(projectRoot, "src", "data", activeCityProfile().CrosswalkDir, name)
+}
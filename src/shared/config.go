@@ -0,0 +1,372 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Config is the typed configuration shared by the services and cbictl. Each field is read from the
+// variable named by its env tag. Tags also give the default, the allowed range (min, max) or values
+// (oneof), and whether the value is a secret that is redacted when printed. Settings that only one
+// report or collector reads stay next to the code that reads them.
+type Config struct {
+	DatabaseURL              string `env:"DATABASE_URL" secret:"true"`
+	ReplicaDatabaseURL       string `env:"REPLICA_DATABASE_URL" secret:"true"`
+	DBSchema                 string `env:"DB_SCHEMA"`
+	CityProfile              string `env:"CITY_PROFILE" default:"chicago"`
+	Port                     int    `env:"PORT" default:"8080" min:"1" max:"65535"`
+	GRPCPort                 int    `env:"GRPC_PORT" min:"1" max:"65535"`
+	RunOnce                  bool   `env:"RUN_ONCE"`
+	StartupDelayMinutes      int    `env:"STARTUP_DELAY_MINUTES" default:"4" min:"0"`
+	SourceWaitTimeoutMinutes int    `env:"SOURCE_WAIT_TIMEOUT_MINUTES" default:"120" min:"0"`
+	ForceRun                 bool   `env:"FORCE_RUN"`
+	CycleIntervalHours       int    `env:"CYCLE_INTERVAL_HOURS" default:"24" min:"1"`
+	CovidSource              string `env:"COVID_SOURCE" default:"weekly" oneof:"weekly respiratory"`
+	CovidRespiratoryResource string `env:"COVID_RESPIRATORY_RESOURCE"`
+
+	CollectorConcurrency    int `env:"COLLECTOR_CONCURRENCY" default:"3" min:"1"`
+	CollectorTimeoutMinutes int `env:"COLLECTOR_TIMEOUT_MINUTES" default:"30" min:"1"`
+	CollectorStaggerMinutes int `env:"COLLECTOR_STAGGER_MINUTES" default:"0" min:"0"`
+	CollectorMemoryMB       int `env:"COLLECTOR_MEMORY_MB" default:"64" min:"1"`
+	MaxRecordsPerCycle      int `env:"MAX_RECORDS_PER_CYCLE" default:"500000" min:"0"`
+
+	UseGeocoding              bool    `env:"USE_GEOCODING"`
+	GeocoderProvider          string  `env:"GEOCODER_PROVIDER" default:"google" oneof:"google nominatim census"`
+	APIKey                    string  `env:"API_KEY" secret:"true"`
+	NominatimURL              string  `env:"NOMINATIM_URL"`
+	GeocoderRequestsPerSecond float64 `env:"GEOCODER_REQUESTS_PER_SECOND" min:"0.001"`
+	GeocodingDailyBudget      int     `env:"GEOCODING_DAILY_BUDGET" default:"0" min:"0"`
+	GeocodingCostPer1000      float64 `env:"GEOCODING_COST_PER_1000" default:"5" min:"0"`
+	GeocodeCacheSize          int     `env:"GEOCODE_CACHE_SIZE" default:"50000" min:"1"`
+	GeocodeCachePrecision     int     `env:"GEOCODE_CACHE_PRECISION" default:"4" min:"3" max:"6"`
+
+	StorageBackend   string `env:"STORAGE_BACKEND" default:"postgres" oneof:"postgres bigquery"`
+	ProjectID        string `env:"PROJECT_ID"`
+	BigQueryProject  string `env:"BIGQUERY_PROJECT"`
+	BigQueryDataset  string `env:"BIGQUERY_DATASET"`
+	RawArchiveBucket string `env:"RAW_ARCHIVE_BUCKET"`
+
+	SpatialDataDir         string `env:"SPATIAL_DATA_DIR" default:"data/spatial"`
+	HTTPCacheDir           string `env:"HTTP_CACHE_DIR"`
+	HTTPCacheMaxAgeMinutes int    `env:"HTTP_CACHE_MAX_AGE_MINUTES" default:"60" min:"0"`
+
+	ReportSnapshotMode          string   `env:"REPORT_SNAPSHOT_MODE" oneof:"table append"`
+	ReportSnapshotRetentionDays int      `env:"REPORT_SNAPSHOT_RETENTION_DAYS" default:"90" min:"0"`
+	ReportReadRoles             []string `env:"REPORT_READ_ROLES"`
+	APIAuditRetentionDays       int      `env:"API_AUDIT_RETENTION_DAYS" default:"365" min:"0"`
+	APICacheMaxAgeMinutes       int      `env:"API_CACHE_MAX_AGE_MINUTES" default:"60" min:"0"`
+	InternalAPITokens           []string `env:"INTERNAL_API_TOKENS" secret:"true"`
+	AlertWebhookURL             string   `env:"ALERT_WEBHOOK_URL" secret:"true"`
+
+	DigestEmailTo   []string `env:"DIGEST_EMAIL_TO"`
+	DigestEmailFrom string   `env:"DIGEST_EMAIL_FROM"`
+	SendGridAPIKey  string   `env:"SENDGRID_API_KEY" secret:"true"`
+	SMTPHost        string   `env:"SMTP_HOST"`
+	SMTPPort        int      `env:"SMTP_PORT" default:"587" min:"1" max:"65535"`
+	SMTPUsername    string   `env:"SMTP_USERNAME"`
+	SMTPPassword    string   `env:"SMTP_PASSWORD" secret:"true"`
+
+	// entries records, per setting in field order, the raw value and where it came from.
+	entries []ConfigEntry
+}
+
+// ConfigEntry is one setting of a loaded Config as cbictl prints it.
+type ConfigEntry struct {
+	Key string
+	// Value is the raw value, with secrets redacted.
+	Value string
+	// Source is "env", the configuration file, or "default"; it is empty for unset settings.
+	Source string
+}
+
+// ConfigError is one problem found while loading or validating a Config.
+type ConfigError struct {
+	Key     string
+	Problem string
+	// Required marks a setting the services cannot run without; see CheckRequiredConfig.
+	Required bool
+}
+
+func (e ConfigError) Error() string {
+	return e.Key + ": " + e.Problem
+}
+
+// ConfigErrors lists every problem of a Config. It is in the ErrValidation category.
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Is(target error) bool {
+	return target == ErrValidation
+}
+
+func (e ConfigErrors) Error() string {
+	problems := make([]string, len(e))
+	for i, err := range e {
+		problems[i] = err.Error()
+	}
+	return strings.Join(problems, "; ")
+}
+
+// LoadConfig reads the configuration from the environment, then from the dotenv-format file at path when
+// path is not empty, then from the defaults. The Config is returned even when it is invalid, together
+// with ConfigErrors listing values that do not parse or fall outside their range and the problems found by
+// Validate. A file that cannot be read is returned as a plain error.
+func LoadConfig(path string) (*Config, error) {
+	var file map[string]string
+	if path != "" {
+		var err error
+		if file, err = godotenv.Read(path); err != nil {
+			return nil, fmt.Errorf("failed to read configuration file %s: %w", path, err)
+		}
+	}
+
+	cfg := &Config{}
+	var problems ConfigErrors
+	value := reflect.ValueOf(cfg).Elem()
+	for i := range value.NumField() {
+		field := value.Type().Field(i)
+		key := field.Tag.Get("env")
+		if key == "" {
+			continue
+		}
+
+		entry := ConfigEntry{Key: key}
+		raw, fromEnv := os.LookupEnv(key)
+		raw = strings.TrimSpace(raw)
+		switch fileRaw, fromFile := file[key]; {
+		case fromEnv && raw != "":
+			entry.Source = "env"
+		case fromFile && strings.TrimSpace(fileRaw) != "":
+			raw, entry.Source = strings.TrimSpace(fileRaw), path
+		case field.Tag.Get("default") != "":
+			raw, entry.Source = field.Tag.Get("default"), "default"
+		}
+		entry.Value = raw
+		if field.Tag.Get("secret") == "true" {
+			entry.Value = redactSecret(key, raw)
+		}
+		cfg.entries = append(cfg.entries, entry)
+
+		if raw == "" {
+			continue
+		}
+		if err := setConfigField(value.Field(i), field.Tag, raw); err != nil {
+			problems = append(problems, ConfigError{Key: key, Problem: err.Error()})
+		}
+	}
+
+	var invalid ConfigErrors
+	if err := cfg.Validate(); errors.As(err, &invalid) {
+		problems = append(problems, invalid...)
+	}
+	if len(problems) > 0 {
+		return cfg, problems
+	}
+	return cfg, nil
+}
+
+// setConfigField parses raw into field and checks it against the min, max, and oneof tags.
+func setConfigField(field reflect.Value, tag reflect.StructTag, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		if values := strings.Fields(tag.Get("oneof")); len(values) > 0 {
+			raw = strings.ToLower(raw)
+			if !slices.Contains(values, raw) {
+				return fmt.Errorf("%q is not one of %s", raw, strings.Join(values, ", "))
+			}
+		}
+		field.SetString(raw)
+	case reflect.Bool:
+		if !strings.EqualFold(raw, "true") && !strings.EqualFold(raw, "false") {
+			return fmt.Errorf("%q is not true or false", raw)
+		}
+		field.SetBool(strings.EqualFold(raw, "true"))
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", raw)
+		}
+		if err := checkConfigRange(float64(n), tag); err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		if err := checkConfigRange(f, tag); err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		var values []string
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	}
+	return nil
+}
+
+func checkConfigRange(n float64, tag reflect.StructTag) error {
+	if raw := tag.Get("min"); raw != "" {
+		if min, _ := strconv.ParseFloat(raw, 64); n < min {
+			return fmt.Errorf("%g is below the minimum of %s", n, raw)
+		}
+	}
+	if raw := tag.Get("max"); raw != "" {
+		if max, _ := strconv.ParseFloat(raw, 64); n > max {
+			return fmt.Errorf("%g is above the maximum of %s", n, raw)
+		}
+	}
+	return nil
+}
+
+// Validate checks the settings that depend on each other: keys required by the options chosen, and
+// options that cannot be combined.
+func (c *Config) Validate() error {
+	var problems ConfigErrors
+	fail := func(key, format string, args ...any) {
+		problems = append(problems, ConfigError{Key: key, Problem: fmt.Sprintf(format, args...)})
+	}
+	require := func(key, format string, args ...any) {
+		problems = append(problems, ConfigError{Key: key, Problem: fmt.Sprintf(format, args...), Required: true})
+	}
+
+	if c.UseGeocoding && c.GeocoderProvider == GeocoderGoogle && c.APIKey == "" {
+		fail(GeocoderAPIKeyEnvKey, "required when USE_GEOCODING=true with the %s geocoder; set it or choose GEOCODER_PROVIDER=%s or %s",
+			GeocoderGoogle, GeocoderNominatim, GeocoderCensus)
+	}
+	if c.NominatimURL != "" {
+		if c.GeocoderProvider != GeocoderNominatim {
+			fail(NominatimURLEnvKey, "only used with GEOCODER_PROVIDER=%s, but the provider is %s", GeocoderNominatim, c.GeocoderProvider)
+		}
+		if u, err := url.Parse(c.NominatimURL); err != nil || u.Scheme == "" || u.Host == "" {
+			fail(NominatimURLEnvKey, "%q is not an absolute URL", c.NominatimURL)
+		}
+	}
+
+	if c.StorageBackend == BackendBigQuery {
+		if c.BigQueryProject == "" && c.ProjectID == "" {
+			require("BIGQUERY_PROJECT", "required when STORAGE_BACKEND=%s (PROJECT_ID is used when it is unset)", BackendBigQuery)
+		}
+		if c.BigQueryDataset == "" {
+			require("BIGQUERY_DATASET", "required when STORAGE_BACKEND=%s", BackendBigQuery)
+		}
+	}
+
+	if c.CityProfile != "" {
+		// A profile that does not load would otherwise fall back to Chicago's portal and tables.
+		if _, err := loadCityProfile(c.CityProfile); err != nil {
+			require(CityProfileEnvKey, "%v", err)
+		}
+	}
+	if c.CovidSource == CovidSourceRespiratory && c.CovidRespiratoryResource == "" {
+		require(CovidRespiratoryResourceEnvKey, "required when %s=%s", CovidSourceEnvKey, CovidSourceRespiratory)
+	}
+	if c.CovidRespiratoryResource != "" && !regexp.MustCompile(`^[a-z0-9]{4}-[a-z0-9]{4}$`).MatchString(c.CovidRespiratoryResource) {
+		fail(CovidRespiratoryResourceEnvKey, "%q is not a Socrata identifier like abcd-1234", c.CovidRespiratoryResource)
+	}
+	if c.DBSchema != "" && !regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`).MatchString(c.DBSchema) {
+		fail(DBSchemaEnvKey, "%q is not a valid schema name", c.DBSchema)
+	}
+	if c.GRPCPort != 0 && c.GRPCPort == c.Port {
+		fail("GRPC_PORT", "must differ from PORT (%d)", c.Port)
+	}
+
+	if c.SendGridAPIKey != "" && c.SMTPHost != "" {
+		fail("SENDGRID_API_KEY", "cannot be combined with SMTP_HOST; the digest is sent through one of them")
+	}
+	if len(c.DigestEmailTo) > 0 {
+		if c.DigestEmailFrom == "" {
+			fail("DIGEST_EMAIL_FROM", "required when DIGEST_EMAIL_TO is set")
+		}
+		if c.SendGridAPIKey == "" && c.SMTPHost == "" {
+			fail("DIGEST_EMAIL_TO", "set SENDGRID_API_KEY or SMTP_HOST to send the digest")
+		}
+	}
+	if c.SMTPPassword != "" && c.SMTPUsername == "" {
+		fail("SMTP_PASSWORD", "set without SMTP_USERNAME, so it is never used")
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// Entries lists every setting in declaration order, with secrets redacted.
+func (c *Config) Entries() []ConfigEntry {
+	return slices.Clone(c.entries)
+}
+
+// connStringPassword matches the password of a key=value connection string.
+var connStringPassword = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// redactSecret hides a secret value. Database URLs keep everything but their password so the target
+// database can still be checked.
+func redactSecret(key, raw string) string {
+	if raw == "" {
+		return ""
+	}
+	if strings.HasSuffix(key, "DATABASE_URL") {
+		if u, err := url.Parse(raw); err == nil && u.Scheme != "" {
+			return u.Redacted()
+		}
+		return connStringPassword.ReplaceAllString(raw, "${1}xxxxx")
+	}
+	return "<redacted>"
+}
+
+// LogConfigProblems loads the configuration from the environment and logs every problem found. The
+// services call it at startup so misconfiguration shows up in their logs instead of as a silent fallback.
+func LogConfigProblems() {
+	_, err := LoadConfig("")
+	var problems ConfigErrors
+	if !errors.As(err, &problems) {
+		return
+	}
+	for _, problem := range problems {
+		log.Printf("configuration problem: %v; run cbictl config validate for details", problem)
+	}
+}
+
+// CheckRequiredConfig returns the settings a service cannot start without that are missing, as ConfigErrors,
+// or nil. Besides the settings Validate marks required, DATABASE_URL is required when no dotenv file was
+// loaded: DefaultConnectionString only fits a local development database, and a container configured through
+// its environment alone should fail with this message rather than with a refused connection.
+func CheckRequiredConfig() error {
+	_, err := LoadConfig("")
+	var problems, missing ConfigErrors
+	if errors.As(err, &problems) {
+		for _, problem := range problems {
+			if problem.Required {
+				missing = append(missing, problem)
+			}
+		}
+	}
+	if !DotenvLoaded() && strings.TrimSpace(os.Getenv("DATABASE_URL")) == "" {
+		missing = append(missing, ConfigError{
+			Key:      "DATABASE_URL",
+			Problem:  "required when there is no .env file; set it in the environment",
+			Required: true,
+		})
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required configuration: %w", missing)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	// CycleIntervalEnvKey sets how many hours apart the services start their collection or report cycles.
+	CycleIntervalEnvKey = "CYCLE_INTERVAL_HOURS"
+
+	defaultCycleIntervalHours = 24
+)
+
+// dotenv remembers the files LoadDotenv read and the variables it set from them.
+var dotenv struct {
+	sync.Mutex
+	files []string
+	set   map[string]string
+	// found reports whether any of the files existed.
+	found bool
+}
+
+// LoadDotenv loads the dotenv files, ".env" when none are given, into the environment like godotenv.Load:
+// variables already set by the environment win, and so do earlier files over later ones. Files that do not
+// exist are skipped, so a container configured through its environment alone needs none; a file that
+// exists but cannot be read or parsed is an error. It remembers the variables it set so ReloadDotenv can
+// refresh them, and the files, so one created later is picked up by a reload.
+func LoadDotenv(filenames ...string) error {
+	if len(filenames) == 0 {
+		filenames = []string{".env"}
+	}
+
+	values, found, err := readDotenv(filenames)
+	if err != nil {
+		return err
+	}
+	if !found {
+		log.Printf("no %s file found; using the environment only", strings.Join(filenames, " or "))
+	}
+
+	dotenv.Lock()
+	defer dotenv.Unlock()
+	dotenv.files = filenames
+	dotenv.found = found
+	dotenv.set = make(map[string]string)
+	for key, value := range values {
+		if _, inEnv := os.LookupEnv(key); inEnv {
+			continue
+		}
+		os.Setenv(key, value)
+		dotenv.set[key] = value
+	}
+	return nil
+}
+
+// ReloadDotenv reads the files given to LoadDotenv again and applies their changes to the variables they
+// set: changed values are updated, new variables are set, and variables dropped from the files are unset.
+// Variables set by the environment itself are left alone. It returns the names of the variables that
+// changed, sorted.
+func ReloadDotenv() ([]string, error) {
+	dotenv.Lock()
+	defer dotenv.Unlock()
+	if len(dotenv.files) == 0 {
+		return nil, errors.New("no dotenv file was loaded")
+	}
+
+	values, found, err := readDotenv(dotenv.files)
+	if err != nil {
+		return nil, err
+	}
+	dotenv.found = found
+
+	var changed []string
+	for key, value := range values {
+		previous, fromFile := dotenv.set[key]
+		if !fromFile {
+			if _, inEnv := os.LookupEnv(key); inEnv {
+				continue
+			}
+		} else if previous == value {
+			continue
+		}
+		os.Setenv(key, value)
+		dotenv.set[key] = value
+		changed = append(changed, key)
+	}
+	for key := range dotenv.set {
+		if _, kept := values[key]; !kept {
+			os.Unsetenv(key)
+			delete(dotenv.set, key)
+			changed = append(changed, key)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// DotenvLoaded reports whether LoadDotenv, or the last ReloadDotenv, found one of its files.
+func DotenvLoaded() bool {
+	dotenv.Lock()
+	defer dotenv.Unlock()
+	return dotenv.found
+}
+
+// readDotenv merges the dotenv files that exist, earlier files winning, and reports whether any did.
+func readDotenv(filenames []string) (map[string]string, bool, error) {
+	values := make(map[string]string)
+	found := false
+	for _, filename := range filenames {
+		file, err := godotenv.Read(filename)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read %s: %w", filename, err)
+		}
+		found = true
+		for key, value := range file {
+			if _, seen := values[key]; !seen {
+				values[key] = value
+			}
+		}
+	}
+	return values, found, nil
+}
+
+// ConfigReload is the outcome of ReloadConfig.
+type ConfigReload struct {
+	// Changed lists the variables whose value changed.
+	Changed []string `json:"changed"`
+	// Problems lists the configuration problems found in the reloaded settings.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// ReloadConfig reloads the dotenv files with ReloadDotenv and checks the result like LogConfigProblems,
+// logging both. Settings read at the start of each run, such as CYCLE_INTERVAL_HOURS, collector timeouts,
+// and report thresholds, take effect from the next run; those read once at startup, such as the ports,
+// DATABASE_URL, the geocoder, and INTERNAL_API_TOKENS, still need a restart.
+func ReloadConfig() (ConfigReload, error) {
+	changed, err := ReloadDotenv()
+	if err != nil {
+		return ConfigReload{}, err
+	}
+
+	reload := ConfigReload{Changed: changed}
+	if reload.Changed == nil {
+		reload.Changed = []string{}
+	}
+	if len(changed) == 0 {
+		log.Print("configuration reloaded; nothing changed")
+	} else {
+		log.Printf("configuration reloaded; changed %s", strings.Join(changed, ", "))
+	}
+
+	_, cfgErr := LoadConfig("")
+	var problems ConfigErrors
+	if errors.As(cfgErr, &problems) {
+		for _, problem := range problems {
+			reload.Problems = append(reload.Problems, problem.Error())
+			log.Printf("configuration problem: %v; run cbictl config validate for details", problem)
+		}
+	}
+	return reload, nil
+}
+
+// OnReloadSignal calls reload every time the process receives SIGHUP, until ctx is done.
+func OnReloadSignal(ctx context.Context, reload func()) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hangups)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangups:
+				log.Print("received SIGHUP; reloading configuration")
+				reload()
+			}
+		}
+	}()
+}
+
+// ReloadHandler answers POST requests by calling reload and writing its result as JSON.
+func ReloadHandler(reload func() (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		result, err := reload()
+		if err != nil {
+			log.Printf("configuration reload failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Printf("failed to write reload response: %v", err)
+		}
+	}
+}
+
+// CycleInterval returns how long after the start of one collection or report cycle the next one starts,
+// from CYCLE_INTERVAL_HOURS. It is read after every cycle, so a reloaded value applies to the next wait.
+func CycleInterval() time.Duration {
+	raw := strings.TrimSpace(os.Getenv(CycleIntervalEnvKey))
+	if raw == "" {
+		return defaultCycleIntervalHours * time.Hour
+	}
+
+	hours, err := strconv.Atoi(raw)
+	if err != nil || hours <= 0 {
+		log.Printf("invalid %s value %q; defaulting to %d", CycleIntervalEnvKey, raw, defaultCycleIntervalHours)
+		return defaultCycleIntervalHours * time.Hour
+	}
+	return time.Duration(hours) * time.Hour
+}
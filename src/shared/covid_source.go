@@ -0,0 +1,39 @@
+package shared
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+const (
+	// CovidSourceEnvKey selects the dataset the weekly COVID statistics come from: "weekly", the default, for
+	// the COVID-19 cases, tests, and deaths by ZIP code dataset, which stopped updating, or "respiratory" for
+	// its successor named by COVID_RESPIRATORY_RESOURCE. Only the selected collector runs; the other's table
+	// is kept as an archive that reports still read through the covid_unified view.
+	CovidSourceEnvKey = "COVID_SOURCE"
+	// CovidRespiratoryResourceEnvKey is the Socrata identifier of the respiratory surveillance by ZIP code
+	// dataset, required with COVID_SOURCE=respiratory.
+	CovidRespiratoryResourceEnvKey = "COVID_RESPIRATORY_RESOURCE"
+
+	CovidSourceWeekly      = "weekly"
+	CovidSourceRespiratory = "respiratory"
+)
+
+// CovidSource returns COVID_SOURCE, CovidSourceWeekly when it is unset or invalid.
+func CovidSource() string {
+	raw := strings.ToLower(strings.TrimSpace(os.Getenv(CovidSourceEnvKey)))
+	switch raw {
+	case "", CovidSourceWeekly:
+		return CovidSourceWeekly
+	case CovidSourceRespiratory:
+		return CovidSourceRespiratory
+	}
+	log.Printf("invalid %s value %q; defaulting to %s", CovidSourceEnvKey, raw, CovidSourceWeekly)
+	return CovidSourceWeekly
+}
+
+// CovidRespiratoryResource returns COVID_RESPIRATORY_RESOURCE, empty when it is unset.
+func CovidRespiratoryResource() string {
+	return strings.TrimSpace(os.Getenv(CovidRespiratoryResourceEnvKey))
+}
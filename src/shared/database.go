@@ -4,17 +4,78 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 )
 
 const DefaultConnectionString = "user=postgres dbname=chicago_business_intelligence password=sql host=localhost sslmode=disable port = 5432"
 
-// OpenDatabase establishes a database connection and verifies connectivity with retries.
+// DBSchemaEnvKey names the Postgres schema that holds every table of this deployment, so several
+// environments can share one instance. When it is unset, tables live in the city profile's schema, which
+// for Chicago is the server's default schema (public).
+const DBSchemaEnvKey = "DB_SCHEMA"
+
+// DBSchema returns the configured DB_SCHEMA, else the city profile's schema, or "" when tables live in the
+// default schema.
+func DBSchema() string {
+	if schema := strings.TrimSpace(os.Getenv(DBSchemaEnvKey)); schema != "" {
+		return schema
+	}
+	return activeCityProfile().Schema
+}
+
+// ApplyDBSchema points connStr at DB_SCHEMA through the search_path, so every unqualified table name used
+// by collectors, reports, and bookkeeping resolves inside that schema. connStr is returned as is when
+// neither DB_SCHEMA nor the city profile names a schema.
+func ApplyDBSchema(connStr string) string {
+	if schema := DBSchema(); schema != "" {
+		return WithSearchPath(connStr, schema)
+	}
+	return connStr
+}
+
+// ReplicaDatabaseURLEnvKey names an optional read replica that serves the read-only API endpoints, keeping
+// dashboard queries off the writer that report builders refresh.
+const ReplicaDatabaseURLEnvKey = "REPLICA_DATABASE_URL"
+
+// OpenDatabase establishes a database connection and verifies connectivity with retries. When DB_SCHEMA is
+// set the connection uses that schema, which is created if it does not exist yet.
 func OpenDatabase(connStr string) (*sql.DB, error) {
+	return openDatabase(connStr, true)
+}
+
+// OpenReadDatabase returns the connection read-only endpoints should query: the REPLICA_DATABASE_URL
+// replica when one is configured and reachable, otherwise writer. The returned close function releases the
+// replica connection and is a no-op when writer is reused.
+func OpenReadDatabase(writer *sql.DB) (*sql.DB, func() error) {
+	connStr := strings.TrimSpace(os.Getenv(ReplicaDatabaseURLEnvKey))
+	if connStr == "" {
+		return writer, func() error { return nil }
+	}
+
+	// The replica is read-only, so the schema is expected to exist already from the writer.
+	replica, err := openDatabase(connStr, false)
+	if err != nil {
+		log.Printf("read replica unavailable, serving reads from the primary: %v", err)
+		return writer, func() error { return nil }
+	}
+	return replica, replica.Close
+}
+
+func openDatabase(connStr string, createSchema bool) (*sql.DB, error) {
 	if connStr == "" {
 		return nil, errors.New("database connection string is required")
 	}
 
+	schema := DBSchema()
+	if schema != "" && !validSchemaName(schema) {
+		return nil, fmt.Errorf("invalid %s %q: use lowercase letters, digits, and underscores", DBSchemaEnvKey, schema)
+	}
+	connStr = ApplyDBSchema(connStr)
+
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, fmt.Errorf("could not open connection: %w", err)
@@ -36,5 +97,50 @@ func OpenDatabase(connStr string) (*sql.DB, error) {
 		return nil, fmt.Errorf("database not reachable after %d attempts: %w", maxRetries, err)
 	}
 
+	if schema != "" && createSchema {
+		if _, err := db.Exec(fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %q`, schema)); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create schema %s: %w", schema, err)
+		}
+	}
+
 	return db, nil
 }
+
+// WithSearchPath returns connStr with the Postgres search_path set to schema, so unqualified table names
+// resolve inside that schema. It accepts both URL and key=value connection strings.
+func WithSearchPath(connStr, schema string) string {
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		if u, err := url.Parse(connStr); err == nil {
+			query := u.Query()
+			query.Set("search_path", schema)
+			u.RawQuery = query.Encode()
+			return u.String()
+		}
+	}
+	return fmt.Sprintf("%s search_path=%s", connStr, schema)
+}
+
+// SmokeSchemaPrefix starts the name of every throwaway schema created by cbictl smoke.
+const SmokeSchemaPrefix = "cbi_smoke_"
+
+// IsSmokeSchema reports whether schema is a well-formed smoke test schema name, i.e. SmokeSchemaPrefix
+// followed by lowercase letters, digits, or underscores.
+func IsSmokeSchema(schema string) bool {
+	rest, ok := strings.CutPrefix(schema, SmokeSchemaPrefix)
+	return ok && rest != "" && validSchemaName(schema)
+}
+
+// validSchemaName accepts names made of lowercase letters, digits, and underscores, which need no quoting
+// inside a search_path setting.
+func validSchemaName(schema string) bool {
+	if schema == "" {
+		return false
+	}
+	for _, r := range schema {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DatasetRegistryTable is the inventory of the sources the collectors ingest, one row per collector job.
+const DatasetRegistryTable = "datasets"
+
+// DatasetEntry is one row of the datasets registry.
+type DatasetEntry struct {
+	// ID is the name of the collector job loading the dataset, e.g. "taxi_trips".
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// SODAResource lists the Socrata identifiers the job reads, comma-separated, on the city profile's
+	// portal. It is empty for sources outside Socrata, named in Description.
+	SODAResource string `json:"soda_resource"`
+	// Cadence is how often the collectors pull the dataset.
+	Cadence string `json:"cadence"`
+	// LastSuccess is when the job last finished without failing, nil before it ever has.
+	LastSuccess *time.Time `json:"last_success"`
+	// Owner is the agency publishing the source.
+	Owner       string `json:"owner"`
+	Description string `json:"description"`
+}
+
+// RegisterDatasets creates the datasets registry when it does not exist and makes its rows match entries:
+// descriptions of existing rows are updated, keeping their last success, and rows of datasets no longer
+// collected are removed.
+func RegisterDatasets(db *sql.DB, entries []DatasetEntry) error {
+	if db == nil {
+		return errors.New("db connection is nil")
+	}
+
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+		"id" VARCHAR(64) PRIMARY KEY,
+		"name" VARCHAR(255) NOT NULL,
+		"soda_resource" VARCHAR(255) NOT NULL,
+		"cadence" VARCHAR(64) NOT NULL,
+		"last_success" TIMESTAMP WITH TIME ZONE,
+		"owner" VARCHAR(255) NOT NULL,
+		"description" TEXT NOT NULL
+	)`, DatasetRegistryTable))
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", DatasetRegistryTable, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start %s transaction: %w", DatasetRegistryTable, err)
+	}
+	defer tx.Rollback()
+
+	stmt := fmt.Sprintf(`INSERT INTO %q ("id", "name", "soda_resource", "cadence", "owner", "description")
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT ("id") DO UPDATE
+		SET name = EXCLUDED.name,
+			soda_resource = EXCLUDED.soda_resource,
+			cadence = EXCLUDED.cadence,
+			owner = EXCLUDED.owner,
+			description = EXCLUDED.description`, DatasetRegistryTable)
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		if _, err := tx.Exec(stmt, entry.ID, entry.Name, entry.SODAResource, entry.Cadence, entry.Owner, entry.Description); err != nil {
+			return fmt.Errorf("failed to register dataset %s: %w", entry.ID, err)
+		}
+		ids[i] = entry.ID
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %q WHERE NOT ("id" = ANY($1))`, DatasetRegistryTable), pq.Array(ids)); err != nil {
+		return fmt.Errorf("failed to remove retired datasets from %s: %w", DatasetRegistryTable, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit %s: %w", DatasetRegistryTable, err)
+	}
+	return nil
+}
+
+// RecordDatasetSuccess stamps the datasets registry row of id with the current time as its last success.
+// Jobs without a row are ignored.
+func RecordDatasetSuccess(db *sql.DB, id string) error {
+	if db == nil {
+		return errors.New("db connection is nil")
+	}
+
+	stmt := fmt.Sprintf(`UPDATE %q SET "last_success" = NOW() WHERE "id" = $1`, DatasetRegistryTable)
+	if _, err := db.Exec(stmt, id); err != nil {
+		return fmt.Errorf("failed to record success of dataset %s: %w", id, err)
+	}
+	return nil
+}
+
+// RegisteredDatasets returns every row of the datasets registry, ordered by id.
+func RegisteredDatasets(ctx context.Context, db *sql.DB) ([]DatasetEntry, error) {
+	if db == nil {
+		return nil, errors.New("db connection is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT "id", "name", "soda_resource", "cadence", "last_success", "owner", "description"
+		FROM %q
+		ORDER BY "id"`, DatasetRegistryTable))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", DatasetRegistryTable, err)
+	}
+	defer rows.Close()
+
+	entries := []DatasetEntry{}
+	for rows.Next() {
+		var (
+			entry       DatasetEntry
+			lastSuccess sql.NullTime
+		)
+		if err := rows.Scan(&entry.ID, &entry.Name, &entry.SODAResource, &entry.Cadence, &lastSuccess, &entry.Owner, &entry.Description); err != nil {
+			return nil, fmt.Errorf("failed to scan %s row: %w", DatasetRegistryTable, err)
+		}
+		if lastSuccess.Valid {
+			entry.LastSuccess = &lastSuccess.Time
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", DatasetRegistryTable, err)
+	}
+	return entries, nil
+}
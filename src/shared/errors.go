@@ -0,0 +1,95 @@
+package shared
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// Error categories. Errors of the collectors and reports wrap one of these, so callers can branch on what went
+// wrong with errors.Is without matching messages: a source that cannot be reached or answered with an error,
+// data or a request that is invalid, or a write that lost to a concurrent one.
+var (
+	ErrSourceUnavailable = errors.New("source unavailable")
+	ErrValidation        = errors.New("validation failed")
+	ErrDBConflict        = errors.New("database conflict")
+)
+
+// Error category names, as ErrorCategory returns them and job_status records them.
+const (
+	ErrorCategorySourceUnavailable = "source_unavailable"
+	ErrorCategoryValidation        = "validation"
+	ErrorCategoryDBConflict        = "db_conflict"
+)
+
+// categorizedError puts err in a category while keeping its message and chain, so errors.Is and errors.As
+// still find what err wraps.
+type categorizedError struct {
+	err      error
+	category error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() []error {
+	return []error{e.err, e.category}
+}
+
+func categorize(err, category error) error {
+	if err == nil || errors.Is(err, category) {
+		return err
+	}
+	return &categorizedError{err: err, category: category}
+}
+
+// SourceUnavailable returns err in the ErrSourceUnavailable category, or nil when err is nil.
+func SourceUnavailable(err error) error {
+	return categorize(err, ErrSourceUnavailable)
+}
+
+// Invalid returns err in the ErrValidation category, or nil when err is nil.
+func Invalid(err error) error {
+	return categorize(err, ErrValidation)
+}
+
+// DBConflict returns err in the ErrDBConflict category, or nil when err is nil.
+func DBConflict(err error) error {
+	return categorize(err, ErrDBConflict)
+}
+
+// conflictCodes are the Postgres error codes of writes that collided with another transaction: unique and
+// exclusion violations, serialization failures, deadlocks, and locks that were not available.
+var conflictCodes = map[pq.ErrorCode]bool{
+	"23505": true,
+	"23P01": true,
+	"40001": true,
+	"40P01": true,
+	"55P03": true,
+}
+
+// ClassifyDBError returns err in the ErrDBConflict category when it is a Postgres conflict, and err unchanged
+// otherwise.
+func ClassifyDBError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && conflictCodes[pqErr.Code] {
+		return DBConflict(err)
+	}
+	return err
+}
+
+// ErrorCategory returns the name of the category of err, or "" when err is nil or in none.
+func ErrorCategory(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrSourceUnavailable):
+		return ErrorCategorySourceUnavailable
+	case errors.Is(err, ErrValidation):
+		return ErrorCategoryValidation
+	case errors.Is(err, ErrDBConflict):
+		return ErrorCategoryDBConflict
+	}
+	return ""
+}
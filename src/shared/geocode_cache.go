@@ -0,0 +1,334 @@
+package shared
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kelvins/geocoder"
+)
+
+const (
+	// GeocodeCacheTable keeps geocoder answers across processes and cycles, behind the in-process cache.
+	GeocodeCacheTable = "geocode_cache"
+
+	// GeocodeCacheSizeEnvKey caps the answers of each kind (forward and reverse) the in-process cache holds;
+	// the least recently used are dropped past it.
+	GeocodeCacheSizeEnvKey  = "GEOCODE_CACHE_SIZE"
+	defaultGeocodeCacheSize = 50000
+
+	// GeocodeCachePrecisionEnvKey sets the decimal places reverse geocode lookups round coordinates to before
+	// they are cached. A degree of latitude is about 111 km, so 3 places group points within roughly 110 m, 4
+	// within 11 m, and 5 within 1.1 m (a degree of longitude is about a quarter shorter at Chicago's latitude).
+	// Coarser keys answer more lookups from the cache, but points within that distance of a ZIP code border
+	// may get the neighbouring ZIP code. Trip centroids repeat exactly, so 4 loses nothing for them.
+	GeocodeCachePrecisionEnvKey  = "GEOCODE_CACHE_PRECISION"
+	defaultGeocodeCachePrecision = 4
+	minGeocodeCachePrecision     = 3
+	maxGeocodeCachePrecision     = 6
+
+	geocodeForward = "forward"
+	geocodeReverse = "reverse"
+)
+
+// lruCache is a map bounded to capacity entries, dropping the least recently used entry when full. It is
+// not safe for concurrent use; geocodeCache guards its caches with its mutex.
+type lruCache[V any] struct {
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRUCache[V any](capacity int) *lruCache[V] {
+	return &lruCache[V]{capacity: capacity, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *lruCache[V]) get(key string) (V, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry[V]).value, true
+}
+
+func (c *lruCache[V]) put(key string, value V) {
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*lruEntry[V]).value = value
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+// geocodeCacheStats counts how the lookups of one kind were answered since the process started.
+type geocodeCacheStats struct {
+	memoryHits, databaseHits, misses int64
+}
+
+// geocodeCache remembers geocoder answers in two tiers: an in-process LRU, so addresses and coordinates that
+// repeat within a pull never leave the process, and, once PersistGeocodeCache is called, the geocode_cache
+// table, so answers survive restarts and are shared between services. Only lookups missing from both are
+// sent to the provider.
+var geocodeCache struct {
+	sync.Mutex
+	once    sync.Once
+	db      *sql.DB
+	forward *lruCache[geocoder.Location]
+	reverse *lruCache[string]
+	stats   map[string]*geocodeCacheStats
+}
+
+// initGeocodeCache sizes the in-process caches from GEOCODE_CACHE_SIZE on first use. Callers hold
+// geocodeCache's mutex.
+func initGeocodeCache() {
+	geocodeCache.once.Do(func() {
+		size := geocodeCacheSize()
+		geocodeCache.forward = newLRUCache[geocoder.Location](size)
+		geocodeCache.reverse = newLRUCache[string](size)
+		geocodeCache.stats = map[string]*geocodeCacheStats{geocodeForward: {}, geocodeReverse: {}}
+	})
+}
+
+// geocodeCacheSize reads GEOCODE_CACHE_SIZE, falling back to defaultGeocodeCacheSize.
+func geocodeCacheSize() int {
+	raw := strings.TrimSpace(os.Getenv(GeocodeCacheSizeEnvKey))
+	if raw == "" {
+		return defaultGeocodeCacheSize
+	}
+	size, err := strconv.Atoi(raw)
+	if err != nil || size < 1 {
+		log.Printf("invalid %s value %q; defaulting to %d", GeocodeCacheSizeEnvKey, raw, defaultGeocodeCacheSize)
+		return defaultGeocodeCacheSize
+	}
+	return size
+}
+
+// GeocodeCachePrecision reads GEOCODE_CACHE_PRECISION, falling back to defaultGeocodeCachePrecision.
+func GeocodeCachePrecision() int {
+	raw := strings.TrimSpace(os.Getenv(GeocodeCachePrecisionEnvKey))
+	if raw == "" {
+		return defaultGeocodeCachePrecision
+	}
+	precision, err := strconv.Atoi(raw)
+	if err != nil || precision < minGeocodeCachePrecision || precision > maxGeocodeCachePrecision {
+		log.Printf("invalid %s value %q; defaulting to %d", GeocodeCachePrecisionEnvKey, raw, defaultGeocodeCachePrecision)
+		return defaultGeocodeCachePrecision
+	}
+	return precision
+}
+
+// PersistGeocodeCache creates the geocode_cache table when it does not exist and, from now on, answers the
+// lookups the in-process cache misses from it and stores every new geocoder answer in it. It also registers
+// the cache hit and miss counters with MetricsHandler. Processes that never call it cache in memory only.
+func PersistGeocodeCache(db *sql.DB) error {
+	if db == nil {
+		return errors.New("db connection is nil")
+	}
+
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+		"kind" VARCHAR(8) NOT NULL,
+		"cache_key" TEXT NOT NULL,
+		"latitude" DOUBLE PRECISION,
+		"longitude" DOUBLE PRECISION,
+		"zip_code" VARCHAR(10),
+		"provider" VARCHAR(16) NOT NULL,
+		"cached_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+		PRIMARY KEY ("kind", "cache_key")
+	)`, GeocodeCacheTable))
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", GeocodeCacheTable, err)
+	}
+
+	geocodeCache.Lock()
+	initGeocodeCache()
+	first := geocodeCache.db == nil
+	geocodeCache.db = db
+	geocodeCache.Unlock()
+	if first {
+		RegisterMetrics(geocodeCacheMetrics)
+	}
+	return nil
+}
+
+// ForwardGeocode returns the coordinates of address from DefaultGeocoder, answering repeated addresses
+// from the cache. Once geocoding is disabled or the daily budget is spent it fails fast with
+// ErrGeocoderUnavailable.
+func ForwardGeocode(ctx context.Context, address geocoder.Address) (geocoder.Location, error) {
+	key := strings.ToUpper(address.FormatAddress())
+
+	geocodeCache.Lock()
+	initGeocodeCache()
+	location, ok := geocodeCache.forward.get(key)
+	if ok {
+		geocodeCache.stats[geocodeForward].memoryHits++
+	}
+	db := geocodeCache.db
+	geocodeCache.Unlock()
+	if ok {
+		return location, nil
+	}
+
+	if db != nil {
+		var latitude, longitude sql.NullFloat64
+		err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT "latitude", "longitude" FROM %q WHERE "kind" = $1 AND "cache_key" = $2`,
+			GeocodeCacheTable), geocodeForward, key).Scan(&latitude, &longitude)
+		switch {
+		case err == nil:
+			location = geocoder.Location{Latitude: latitude.Float64, Longitude: longitude.Float64}
+			geocodeCache.Lock()
+			geocodeCache.forward.put(key, location)
+			geocodeCache.stats[geocodeForward].databaseHits++
+			geocodeCache.Unlock()
+			return location, nil
+		case !errors.Is(err, sql.ErrNoRows):
+			log.Printf("failed to read %s: %v", GeocodeCacheTable, err)
+		}
+	}
+
+	geocodeCache.Lock()
+	geocodeCache.stats[geocodeForward].misses++
+	geocodeCache.Unlock()
+
+	g, err := availableGeocoder(ctx)
+	if err != nil {
+		return geocoder.Location{}, err
+	}
+	if err := reserveGeocoderRequest(ctx); err != nil {
+		return geocoder.Location{}, err
+	}
+	location, err = g.Forward(ctx, address)
+	if err != nil {
+		return geocoder.Location{}, fmt.Errorf("failed to geocode %q: %w", key, checkGeocoderAuth(ctx, err))
+	}
+
+	geocodeCache.Lock()
+	geocodeCache.forward.put(key, location)
+	geocodeCache.Unlock()
+	storeGeocodeAnswer(ctx, db, geocodeForward, key, location.Latitude, location.Longitude, nil)
+	return location, nil
+}
+
+// ReverseGeocodeZip returns the postal code at location from DefaultGeocoder, or "" when the provider
+// has none. Coordinates are cached rounded to GEOCODE_CACHE_PRECISION decimal places; keys of different
+// precisions differ in length, so changing it never answers a lookup from an entry of another precision.
+// Each lookup is counted as a cache hit or miss on the job in ctx. Once geocoding is disabled or the daily
+// budget is spent it fails fast with ErrGeocoderUnavailable.
+func ReverseGeocodeZip(ctx context.Context, location geocoder.Location) (string, error) {
+	precision := GeocodeCachePrecision()
+	key := fmt.Sprintf("%.*f,%.*f", precision, location.Latitude, precision, location.Longitude)
+
+	geocodeCache.Lock()
+	initGeocodeCache()
+	zip, ok := geocodeCache.reverse.get(key)
+	if ok {
+		geocodeCache.stats[geocodeReverse].memoryHits++
+	}
+	db := geocodeCache.db
+	geocodeCache.Unlock()
+	if ok {
+		CountGeocodeLookup(ctx, precision, true)
+		return zip, nil
+	}
+
+	if db != nil {
+		var cached sql.NullString
+		err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT "zip_code" FROM %q WHERE "kind" = $1 AND "cache_key" = $2`,
+			GeocodeCacheTable), geocodeReverse, key).Scan(&cached)
+		switch {
+		case err == nil:
+			geocodeCache.Lock()
+			geocodeCache.reverse.put(key, cached.String)
+			geocodeCache.stats[geocodeReverse].databaseHits++
+			geocodeCache.Unlock()
+			CountGeocodeLookup(ctx, precision, true)
+			return cached.String, nil
+		case !errors.Is(err, sql.ErrNoRows):
+			log.Printf("failed to read %s: %v", GeocodeCacheTable, err)
+		}
+	}
+
+	geocodeCache.Lock()
+	geocodeCache.stats[geocodeReverse].misses++
+	geocodeCache.Unlock()
+	CountGeocodeLookup(ctx, precision, false)
+
+	g, err := availableGeocoder(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := reserveGeocoderRequest(ctx); err != nil {
+		return "", err
+	}
+	zip, err = g.ReverseZip(ctx, location)
+	if err != nil {
+		return "", fmt.Errorf("failed to reverse geocode %s: %w", key, checkGeocoderAuth(ctx, err))
+	}
+
+	geocodeCache.Lock()
+	geocodeCache.reverse.put(key, zip)
+	geocodeCache.Unlock()
+	storeGeocodeAnswer(ctx, db, geocodeReverse, key, location.Latitude, location.Longitude, &zip)
+	return zip, nil
+}
+
+// storeGeocodeAnswer writes a geocoder answer to geocode_cache when it is in use. Failures are logged only:
+// the answer is still cached in memory.
+func storeGeocodeAnswer(ctx context.Context, db *sql.DB, kind, key string, latitude, longitude float64, zip *string) {
+	if db == nil {
+		return
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %q ("kind", "cache_key", "latitude", "longitude", "zip_code", "provider", "cached_at")
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT ("kind", "cache_key") DO UPDATE
+		SET latitude = EXCLUDED.latitude,
+			longitude = EXCLUDED.longitude,
+			zip_code = EXCLUDED.zip_code,
+			provider = EXCLUDED.provider,
+			cached_at = EXCLUDED.cached_at`, GeocodeCacheTable), kind, key, latitude, longitude, zip, GeocoderProvider())
+	if err != nil {
+		log.Printf("failed to write %s: %v", GeocodeCacheTable, err)
+	}
+}
+
+// geocodeCacheMetrics reports the lookups of each kind answered by each cache tier or sent to the provider
+// since the process started, and the in-process cache sizes.
+func geocodeCacheMetrics(ctx context.Context) []Metric {
+	geocodeCache.Lock()
+	defer geocodeCache.Unlock()
+
+	var metrics []Metric
+	sizes := map[string]int{geocodeForward: geocodeCache.forward.order.Len(), geocodeReverse: geocodeCache.reverse.order.Len()}
+	for _, kind := range []string{geocodeForward, geocodeReverse} {
+		stats := geocodeCache.stats[kind]
+		metrics = append(metrics,
+			Metric{Name: "geocode_cache_hits_total", Help: "Geocode lookups answered from a cache tier.", Type: "counter",
+				Labels: map[string]string{"kind": kind, "tier": "memory"}, Value: float64(stats.memoryHits)},
+			Metric{Name: "geocode_cache_hits_total", Help: "Geocode lookups answered from a cache tier.", Type: "counter",
+				Labels: map[string]string{"kind": kind, "tier": "database"}, Value: float64(stats.databaseHits)},
+			Metric{Name: "geocode_cache_misses_total", Help: "Geocode lookups missing from every cache tier.", Type: "counter",
+				Labels: map[string]string{"kind": kind}, Value: float64(stats.misses)},
+			Metric{Name: "geocode_cache_entries", Help: "Answers held by the in-process geocode cache.", Type: "gauge",
+				Labels: map[string]string{"kind": kind}, Value: float64(sizes[kind])},
+		)
+	}
+	return metrics
+}
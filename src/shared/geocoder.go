@@ -0,0 +1,333 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelvins/geocoder"
+)
+
+const (
+	// GeocoderProviderEnvKey selects the geocoding provider: google (default), nominatim, or census.
+	GeocoderProviderEnvKey = "GEOCODER_PROVIDER"
+	// GeocoderRateEnvKey overrides the provider's default request rate, in requests per second.
+	GeocoderRateEnvKey = "GEOCODER_REQUESTS_PER_SECOND"
+	// GeocoderAPIKeyEnvKey holds the Google Geocoding API key; the other providers need none.
+	GeocoderAPIKeyEnvKey = "API_KEY"
+	// NominatimURLEnvKey points the nominatim provider at a self-hosted instance instead of the public one.
+	NominatimURLEnvKey = "NOMINATIM_URL"
+
+	GeocoderGoogle    = "google"
+	GeocoderNominatim = "nominatim"
+	GeocoderCensus    = "census"
+
+	defaultNominatimURL = "https://nominatim.openstreetmap.org"
+	censusGeocoderURL   = "https://geocoding.geo.census.gov/geocoder"
+	geocoderUserAgent   = "Chicago_BI/1.0 (+https://github.com/ahbreck/Chicago_BI)"
+)
+
+// Geocoder resolves street addresses to coordinates and coordinates to postal codes.
+type Geocoder interface {
+	// Forward returns the coordinates of address.
+	Forward(ctx context.Context, address geocoder.Address) (geocoder.Location, error)
+	// ReverseZip returns the postal code at location, or "" when the provider has none.
+	ReverseZip(ctx context.Context, location geocoder.Location) (string, error)
+}
+
+// defaultGeocoderRates are requests per second that stay within each provider's usage policy.
+var defaultGeocoderRates = map[string]float64{
+	GeocoderGoogle:    40,
+	GeocoderNominatim: 1,
+	GeocoderCensus:    5,
+}
+
+var (
+	defaultGeocoderOnce sync.Once
+	defaultGeocoder     Geocoder
+	defaultGeocoderErr  error
+)
+
+// GeocoderProvider returns the provider named by GEOCODER_PROVIDER, defaulting to google.
+func GeocoderProvider() string {
+	return normalizeGeocoderProvider(os.Getenv(GeocoderProviderEnvKey))
+}
+
+func normalizeGeocoderProvider(provider string) string {
+	provider = strings.ToLower(strings.TrimSpace(provider))
+	if provider == "" {
+		return GeocoderGoogle
+	}
+	return provider
+}
+
+// DefaultGeocoder returns the rate-limited geocoder selected by GEOCODER_PROVIDER, built once per process.
+func DefaultGeocoder() (Geocoder, error) {
+	defaultGeocoderOnce.Do(func() {
+		defaultGeocoder, defaultGeocoderErr = NewGeocoder(GeocoderProvider())
+	})
+	return defaultGeocoder, defaultGeocoderErr
+}
+
+// NewGeocoder builds the named provider ("" means google) wrapped in its rate limit.
+func NewGeocoder(provider string) (Geocoder, error) {
+	provider = normalizeGeocoderProvider(provider)
+
+	var inner Geocoder
+	switch provider {
+	case GeocoderGoogle:
+		apiKey := strings.TrimSpace(os.Getenv(GeocoderAPIKeyEnvKey))
+		if apiKey == "" {
+			return nil, fmt.Errorf("%s is required for the %s geocoder", GeocoderAPIKeyEnvKey, GeocoderGoogle)
+		}
+		// kelvins/geocoder reads its key from a package variable.
+		geocoder.ApiKey = apiKey
+		inner = googleGeocoder{}
+	case GeocoderNominatim:
+		baseURL := strings.TrimSpace(os.Getenv(NominatimURLEnvKey))
+		if baseURL == "" {
+			baseURL = defaultNominatimURL
+		}
+		inner = nominatimGeocoder{baseURL: strings.TrimSuffix(baseURL, "/"), client: geocoderHTTPClient}
+	case GeocoderCensus:
+		inner = censusGeocoder{baseURL: censusGeocoderURL, client: geocoderHTTPClient}
+	default:
+		return nil, fmt.Errorf("unknown %s %q: use %s, %s, or %s", GeocoderProviderEnvKey, provider, GeocoderGoogle, GeocoderNominatim, GeocoderCensus)
+	}
+
+	return &rateLimitedGeocoder{inner: inner, interval: geocoderInterval(provider)}, nil
+}
+
+var geocoderHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+func geocoderInterval(provider string) time.Duration {
+	rate := defaultGeocoderRates[provider]
+	if raw := strings.TrimSpace(os.Getenv(GeocoderRateEnvKey)); raw != "" {
+		if parsed, err := strconv.ParseFloat(raw, 64); err == nil && parsed > 0 {
+			rate = parsed
+		} else {
+			log.Printf("invalid %s value %q; defaulting to %g", GeocoderRateEnvKey, raw, rate)
+		}
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// rateLimitedGeocoder spaces requests to inner at least interval apart, across all goroutines.
+type rateLimitedGeocoder struct {
+	inner    Geocoder
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (g *rateLimitedGeocoder) wait(ctx context.Context) error {
+	g.mu.Lock()
+	now := time.Now()
+	slot := g.next
+	if slot.Before(now) {
+		slot = now
+	}
+	g.next = slot.Add(g.interval)
+	g.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(slot))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (g *rateLimitedGeocoder) Forward(ctx context.Context, address geocoder.Address) (geocoder.Location, error) {
+	if err := g.wait(ctx); err != nil {
+		return geocoder.Location{}, err
+	}
+	return g.inner.Forward(ctx, address)
+}
+
+func (g *rateLimitedGeocoder) ReverseZip(ctx context.Context, location geocoder.Location) (string, error) {
+	if err := g.wait(ctx); err != nil {
+		return "", err
+	}
+	return g.inner.ReverseZip(ctx, location)
+}
+
+// googleGeocoder calls the Google Geocoding API through kelvins/geocoder, which does not take a context.
+type googleGeocoder struct{}
+
+func (googleGeocoder) Forward(_ context.Context, address geocoder.Address) (geocoder.Location, error) {
+	return geocoder.Geocoding(address)
+}
+
+func (googleGeocoder) ReverseZip(_ context.Context, location geocoder.Location) (string, error) {
+	addresses, err := geocoder.GeocodingReverse(location)
+	if err != nil {
+		return "", err
+	}
+	if len(addresses) == 0 {
+		return "", nil
+	}
+	return addresses[0].PostalCode, nil
+}
+
+// nominatimGeocoder calls an OpenStreetMap Nominatim instance. The public instance allows one request
+// per second and requires an identifying User-Agent.
+type nominatimGeocoder struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (g nominatimGeocoder) Forward(ctx context.Context, address geocoder.Address) (geocoder.Location, error) {
+	street := address.Street
+	if address.Number > 0 {
+		street = fmt.Sprintf("%d %s", address.Number, address.Street)
+	}
+	params := url.Values{
+		"format":  {"jsonv2"},
+		"limit":   {"1"},
+		"street":  {street},
+		"city":    {address.City},
+		"state":   {address.State},
+		"country": {address.Country},
+	}
+
+	var results []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	if err := getGeocoderJSON(ctx, g.client, g.baseURL+"/search?"+params.Encode(), &results); err != nil {
+		return geocoder.Location{}, err
+	}
+	if len(results) == 0 {
+		return geocoder.Location{}, errors.New("no results found")
+	}
+
+	lat, latErr := strconv.ParseFloat(results[0].Lat, 64)
+	lon, lonErr := strconv.ParseFloat(results[0].Lon, 64)
+	if err := errors.Join(latErr, lonErr); err != nil {
+		return geocoder.Location{}, fmt.Errorf("failed to parse nominatim coordinates: %w", err)
+	}
+	return geocoder.Location{Latitude: lat, Longitude: lon}, nil
+}
+
+func (g nominatimGeocoder) ReverseZip(ctx context.Context, location geocoder.Location) (string, error) {
+	params := url.Values{
+		"format":         {"jsonv2"},
+		"zoom":           {"18"},
+		"addressdetails": {"1"},
+		"lat":            {strconv.FormatFloat(location.Latitude, 'f', -1, 64)},
+		"lon":            {strconv.FormatFloat(location.Longitude, 'f', -1, 64)},
+	}
+
+	var result struct {
+		Address struct {
+			Postcode string `json:"postcode"`
+		} `json:"address"`
+	}
+	if err := getGeocoderJSON(ctx, g.client, g.baseURL+"/reverse?"+params.Encode(), &result); err != nil {
+		return "", err
+	}
+	return result.Address.Postcode, nil
+}
+
+// censusGeocoder calls the free US Census Bureau geocoder. Reverse lookups return the ZIP Code
+// Tabulation Area containing the point, which matches the USPS ZIP for nearly all Chicago addresses.
+type censusGeocoder struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (g censusGeocoder) Forward(ctx context.Context, address geocoder.Address) (geocoder.Location, error) {
+	params := url.Values{
+		"address":   {address.FormatAddress()},
+		"benchmark": {"Public_AR_Current"},
+		"format":    {"json"},
+	}
+
+	var response struct {
+		Result struct {
+			AddressMatches []struct {
+				Coordinates struct {
+					X float64 `json:"x"`
+					Y float64 `json:"y"`
+				} `json:"coordinates"`
+			} `json:"addressMatches"`
+		} `json:"result"`
+	}
+	if err := getGeocoderJSON(ctx, g.client, g.baseURL+"/locations/onelineaddress?"+params.Encode(), &response); err != nil {
+		return geocoder.Location{}, err
+	}
+	if len(response.Result.AddressMatches) == 0 {
+		return geocoder.Location{}, errors.New("no results found")
+	}
+
+	match := response.Result.AddressMatches[0].Coordinates
+	return geocoder.Location{Latitude: match.Y, Longitude: match.X}, nil
+}
+
+func (g censusGeocoder) ReverseZip(ctx context.Context, location geocoder.Location) (string, error) {
+	params := url.Values{
+		"x":         {strconv.FormatFloat(location.Longitude, 'f', -1, 64)},
+		"y":         {strconv.FormatFloat(location.Latitude, 'f', -1, 64)},
+		"benchmark": {"Public_AR_Current"},
+		"vintage":   {"Current_Current"},
+		"layers":    {"all"},
+		"format":    {"json"},
+	}
+
+	var response struct {
+		Result struct {
+			Geographies map[string][]map[string]any `json:"geographies"`
+		} `json:"result"`
+	}
+	if err := getGeocoderJSON(ctx, g.client, g.baseURL+"/geographies/coordinates?"+params.Encode(), &response); err != nil {
+		return "", err
+	}
+
+	for layer, features := range response.Result.Geographies {
+		if !strings.Contains(strings.ToLower(layer), "zip code tabulation") || len(features) == 0 {
+			continue
+		}
+		for _, field := range []string{"ZCTA5", "GEOID", "BASENAME"} {
+			if zip, ok := features[0][field].(string); ok && zip != "" {
+				return zip, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func getGeocoderJSON(ctx context.Context, client *http.Client, endpoint string, target any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build geocoder request: %w", err)
+	}
+	req.Header.Set("User-Agent", geocoderUserAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("geocoder request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return geocoderStatusError{status: resp.Status, code: resp.StatusCode}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return fmt.Errorf("failed to decode geocoder response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrGeocoderUnavailable is returned by ForwardGeocode and ReverseGeocodeZip once geocoding has been
+// disabled for the process, because the geocoder could not be built (e.g. USE_GEOCODING=true without an
+// API_KEY) or the provider rejected its credentials. Callers fall back to the geography crosswalks. It is in
+// the ErrSourceUnavailable category.
+var ErrGeocoderUnavailable = SourceUnavailable(errors.New("geocoder unavailable"))
+
+// geocoderHealth remembers why geocoding was disabled. It stays disabled for the life of the process, since
+// a missing or revoked key does not fix itself between records.
+var geocoderHealth struct {
+	sync.Mutex
+	reason string
+}
+
+// GeocoderDegraded reports whether geocoding has been disabled for the process, and why.
+func GeocoderDegraded() (reason string, degraded bool) {
+	geocoderHealth.Lock()
+	defer geocoderHealth.Unlock()
+	return geocoderHealth.reason, geocoderHealth.reason != ""
+}
+
+// availableGeocoder returns DefaultGeocoder, or ErrGeocoderUnavailable without calling the provider once
+// geocoding is disabled.
+func availableGeocoder(ctx context.Context) (Geocoder, error) {
+	if reason, degraded := GeocoderDegraded(); degraded {
+		NoteDegraded(ctx, reason)
+		return nil, ErrGeocoderUnavailable
+	}
+
+	g, err := DefaultGeocoder()
+	if err != nil {
+		degradeGeocoder(ctx, err.Error())
+		return nil, fmt.Errorf("%w: %v", ErrGeocoderUnavailable, err)
+	}
+	return g, nil
+}
+
+// checkGeocoderAuth disables geocoding when err is an authentication failure, so the remaining records are
+// not sent to a provider that will reject them all, and returns err wrapped in ErrGeocoderUnavailable.
+// Other errors are returned unchanged.
+func checkGeocoderAuth(ctx context.Context, err error) error {
+	if !isGeocoderAuthError(err) {
+		return err
+	}
+	degradeGeocoder(ctx, fmt.Sprintf("the %s geocoder rejected its credentials: %v", GeocoderProvider(), err))
+	return fmt.Errorf("%w: %v", ErrGeocoderUnavailable, err)
+}
+
+// degradeGeocoder disables geocoding for the process, logging the first reason only, and notes the
+// degradation on the job in ctx.
+func degradeGeocoder(ctx context.Context, reason string) {
+	geocoderHealth.Lock()
+	first := geocoderHealth.reason == ""
+	if first {
+		geocoderHealth.reason = reason
+	}
+	reason = geocoderHealth.reason
+	geocoderHealth.Unlock()
+
+	if first {
+		log.Printf("geocoding disabled: %s; falling back to the geography crosswalks until restart", reason)
+	}
+	NoteDegraded(ctx, reason)
+}
+
+// geocoderStatusError is returned by the HTTP geocoders for responses other than 200 OK.
+type geocoderStatusError struct {
+	status string
+	code   int
+}
+
+func (e geocoderStatusError) Error() string {
+	return "geocoder returned " + e.status
+}
+
+// isGeocoderAuthError recognizes a rejected key: 401 or 403 from the HTTP providers, or a REQUEST_DENIED
+// message about the key from the Google Geocoding API, which kelvins/geocoder passes on as plain text.
+func isGeocoderAuthError(err error) bool {
+	var statusErr geocoderStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code == http.StatusUnauthorized || statusErr.code == http.StatusForbidden
+	}
+
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "api key") || strings.Contains(message, "not authorized")
+}
@@ -0,0 +1,197 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// GeocodingUsageTable counts the geocoder requests of every service per UTC day and provider.
+	GeocodingUsageTable = "geocoding_usage"
+
+	// GeocodingDailyBudgetEnvKey caps the geocoder requests all services together make per UTC day. Past
+	// it, lookups fail with ErrGeocoderUnavailable, so records fall back to the geography crosswalks until
+	// the next day. 0 or unset means no cap.
+	GeocodingDailyBudgetEnvKey = "GEOCODING_DAILY_BUDGET"
+	// GeocodingCostPer1000EnvKey is the price in US dollars of 1,000 Google Geocoding API requests, used to
+	// estimate the day's cost. The other providers are free.
+	GeocodingCostPer1000EnvKey = "GEOCODING_COST_PER_1000"
+
+	defaultGeocodingCostPer1000 = 5.0
+)
+
+// geocodingUsage holds the database requests are counted in, set by TrackGeocodingUsage, and the last day
+// the budget ran out, so that is logged once a day.
+var geocodingUsage struct {
+	sync.Mutex
+	db            *sql.DB
+	exhaustedDate string
+}
+
+// TrackGeocodingUsage creates the geocoding_usage table when it does not exist and counts every geocoder
+// request of the process in it from now on, enforcing GEOCODING_DAILY_BUDGET. It also registers the day's
+// usage with MetricsHandler. Processes that never call it geocode without counting or a budget.
+func TrackGeocodingUsage(db *sql.DB) error {
+	if db == nil {
+		return errors.New("db connection is nil")
+	}
+
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+		"usage_date" DATE NOT NULL,
+		"provider" VARCHAR(16) NOT NULL,
+		"requests" BIGINT NOT NULL DEFAULT 0,
+		"refused" BIGINT NOT NULL DEFAULT 0,
+		"estimated_cost_usd" NUMERIC(12, 4) NOT NULL DEFAULT 0,
+		"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+		PRIMARY KEY ("usage_date", "provider")
+	)`, GeocodingUsageTable))
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", GeocodingUsageTable, err)
+	}
+
+	geocodingUsage.Lock()
+	first := geocodingUsage.db == nil
+	geocodingUsage.db = db
+	geocodingUsage.Unlock()
+	if first {
+		RegisterMetrics(geocodingUsageMetrics)
+	}
+	return nil
+}
+
+// GeocodingDailyBudget returns GEOCODING_DAILY_BUDGET, 0 meaning no cap.
+func GeocodingDailyBudget() int64 {
+	raw := strings.TrimSpace(os.Getenv(GeocodingDailyBudgetEnvKey))
+	if raw == "" {
+		return 0
+	}
+	budget, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || budget < 0 {
+		log.Printf("invalid %s value %q; defaulting to no budget", GeocodingDailyBudgetEnvKey, raw)
+		return 0
+	}
+	return budget
+}
+
+// geocodingRequestCost returns the estimated price in US dollars of one request to provider.
+func geocodingRequestCost(provider string) float64 {
+	if provider != GeocoderGoogle {
+		return 0
+	}
+	perThousand := defaultGeocodingCostPer1000
+	if raw := strings.TrimSpace(os.Getenv(GeocodingCostPer1000EnvKey)); raw != "" {
+		if parsed, err := strconv.ParseFloat(raw, 64); err == nil && parsed >= 0 {
+			perThousand = parsed
+		} else {
+			log.Printf("invalid %s value %q; defaulting to %g", GeocodingCostPer1000EnvKey, raw, perThousand)
+		}
+	}
+	return perThousand / 1000
+}
+
+// reserveGeocoderRequest counts one request to the configured provider against today's usage before it is
+// sent. Once GEOCODING_DAILY_BUDGET requests were made today it counts the request as refused and returns
+// ErrGeocoderUnavailable instead. Bookkeeping failures are logged and the request is allowed, so a database
+// hiccup never stops geocoding.
+func reserveGeocoderRequest(ctx context.Context) error {
+	geocodingUsage.Lock()
+	db := geocodingUsage.db
+	geocodingUsage.Unlock()
+	if db == nil {
+		return nil
+	}
+
+	provider := GeocoderProvider()
+	budget := GeocodingDailyBudget()
+	today := time.Now().UTC().Format(time.DateOnly)
+
+	var requests int64
+	err := db.QueryRowContext(ctx, fmt.Sprintf(`INSERT INTO %[1]q ("usage_date", "provider", "requests", "estimated_cost_usd", "updated_at")
+		VALUES ($1, $2, 1, $3, NOW())
+		ON CONFLICT ("usage_date", "provider") DO UPDATE
+		SET requests = %[1]q.requests + 1,
+			estimated_cost_usd = %[1]q.estimated_cost_usd + EXCLUDED.estimated_cost_usd,
+			updated_at = EXCLUDED.updated_at
+		WHERE $4::BIGINT = 0 OR %[1]q.requests < $4::BIGINT
+		RETURNING "requests"`, GeocodingUsageTable), today, provider, geocodingRequestCost(provider), budget).Scan(&requests)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// The budget is spent: the conflicting row was left as is.
+	case err != nil:
+		log.Printf("failed to count geocoder request in %s: %v", GeocodingUsageTable, err)
+		return nil
+	default:
+		return nil
+	}
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(`UPDATE %q SET "refused" = "refused" + 1, "updated_at" = NOW()
+		WHERE "usage_date" = $1 AND "provider" = $2`, GeocodingUsageTable), today, provider); err != nil {
+		log.Printf("failed to count refused geocoder request in %s: %v", GeocodingUsageTable, err)
+	}
+
+	reason := fmt.Sprintf("the daily geocoding budget of %d %s requests is spent for %s", budget, provider, today)
+	geocodingUsage.Lock()
+	first := geocodingUsage.exhaustedDate != today
+	geocodingUsage.exhaustedDate = today
+	geocodingUsage.Unlock()
+	if first {
+		log.Printf("%s; falling back to the geography crosswalks until the next UTC day", reason)
+	}
+	NoteDegraded(ctx, reason)
+	return fmt.Errorf("%w: %s", ErrGeocoderUnavailable, reason)
+}
+
+// geocodingUsageMetrics reports today's geocoder requests, refusals, and estimated cost per provider, and
+// the daily budget.
+func geocodingUsageMetrics(ctx context.Context) []Metric {
+	metrics := []Metric{{
+		Name:  "geocoding_daily_budget",
+		Help:  "Geocoder requests allowed per UTC day across all services, 0 for no cap.",
+		Type:  "gauge",
+		Value: float64(GeocodingDailyBudget()),
+	}}
+
+	geocodingUsage.Lock()
+	db := geocodingUsage.db
+	geocodingUsage.Unlock()
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT "provider", "requests", "refused", "estimated_cost_usd"
+		FROM %q
+		WHERE "usage_date" = $1
+		ORDER BY "provider"`, GeocodingUsageTable), time.Now().UTC().Format(time.DateOnly))
+	if err != nil {
+		log.Printf("failed to read %s for metrics: %v", GeocodingUsageTable, err)
+		return metrics
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			provider          string
+			requests, refused int64
+			cost              float64
+		)
+		if err := rows.Scan(&provider, &requests, &refused, &cost); err != nil {
+			log.Printf("failed to scan %s row for metrics: %v", GeocodingUsageTable, err)
+			return metrics
+		}
+		labels := map[string]string{"provider": provider}
+		metrics = append(metrics,
+			Metric{Name: "geocoding_requests_today", Help: "Geocoder requests made today (UTC) across all services.", Type: "gauge", Labels: labels, Value: float64(requests)},
+			Metric{Name: "geocoding_refused_today", Help: "Geocoder requests refused today (UTC) because the daily budget was spent.", Type: "gauge", Labels: labels, Value: float64(refused)},
+			Metric{Name: "geocoding_estimated_cost_usd_today", Help: "Estimated cost in US dollars of today's (UTC) geocoder requests.", Type: "gauge", Labels: labels, Value: cost},
+		)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("failed to read %s for metrics: %v", GeocodingUsageTable, err)
+	}
+	return metrics
+}
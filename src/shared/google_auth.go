@@ -0,0 +1,104 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metadataTokenURL serves access tokens for the attached service account on Cloud Run and GCE.
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// SheetsScope is the OAuth scope for reading and writing Google Sheets.
+const SheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+
+// accessTokenSource caches an OAuth access token for Google APIs.
+type accessTokenSource struct {
+	// scopes, when set, are requested instead of the scopes the service account was given.
+	scopes  []string
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// googleAccessTokens is shared by every client that talks to Google Cloud APIs.
+var googleAccessTokens = &accessTokenSource{}
+
+// sheetsAccessTokens carry the Sheets scope, which the default Cloud Platform scope does not include.
+var sheetsAccessTokens = &accessTokenSource{scopes: []string{SheetsScope}}
+
+// SheetsAccessToken returns an access token of the attached service account for the Sheets API. The
+// spreadsheets it writes must be shared with that service account. Like the other tokens it may come from
+// GOOGLE_ACCESS_TOKEN for local runs, e.g. `gcloud auth print-access-token --scopes=...`.
+func SheetsAccessToken(ctx context.Context) (string, error) {
+	return sheetsAccessTokens.Token(ctx)
+}
+
+// Token returns GOOGLE_ACCESS_TOKEN (or the older BIGQUERY_ACCESS_TOKEN) when set, which is handy for
+// local runs with `gcloud auth print-access-token`; otherwise it returns a cached metadata server token.
+func (s *accessTokenSource) Token(ctx context.Context) (string, error) {
+	for _, key := range []string{"GOOGLE_ACCESS_TOKEN", "BIGQUERY_ACCESS_TOKEN"} {
+		if token := strings.TrimSpace(os.Getenv(key)); token != "" {
+			return token, nil
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Before(s.expires) {
+		return s.token, nil
+	}
+
+	tokenURL := metadataTokenURL
+	if len(s.scopes) > 0 {
+		tokenURL += "?scopes=" + url.QueryEscape(strings.Join(s.scopes, ","))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to construct token request: %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := simpleClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch access token from metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var payload struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := decodeGoogleResponse(resp, &payload); err != nil {
+		return "", fmt.Errorf("failed to fetch access token from metadata server: %w", err)
+	}
+
+	s.token = payload.AccessToken
+	// Refresh a minute early so in-flight requests never carry an expired token.
+	s.expires = time.Now().Add(time.Duration(payload.ExpiresIn)*time.Second - time.Minute)
+
+	return s.token, nil
+}
+
+// decodeGoogleResponse reads a Google Cloud REST response into out, treating any non-200 status as an error.
+func decodeGoogleResponse(resp *http.Response, out any) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, body)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package shared
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HTTPCacheDirEnvKey enables the on-disk response cache for SODA requests when set to a directory.
+	// It is meant for development, where the same queries are fetched over and over.
+	HTTPCacheDirEnvKey = "HTTP_CACHE_DIR"
+	// HTTPCacheMaxAgeEnvKey sets how many minutes a cached response stays fresh.
+	HTTPCacheMaxAgeEnvKey = "HTTP_CACHE_MAX_AGE_MINUTES"
+
+	defaultHTTPCacheMaxAgeMinutes = 60
+)
+
+// cachingTransport serves GET requests to the SODA portal from an on-disk cache keyed by URL when
+// HTTP_CACHE_DIR is set, and stores successful responses for later requests. Everything else,
+// including Google API and metadata server calls, goes straight to next.
+type cachingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	dir := strings.TrimSpace(os.Getenv(HTTPCacheDirEnvKey))
+	if dir == "" || req.Method != http.MethodGet || req.URL.Scheme+"://"+req.URL.Host != SODADomain() {
+		return t.next.RoundTrip(req)
+	}
+
+	key := sha256.Sum256([]byte(req.URL.String()))
+	path := filepath.Join(dir, hex.EncodeToString(key[:])+".cache")
+
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < httpCacheMaxAge() {
+		if body, err := os.ReadFile(path); err == nil {
+			log.Printf("serving %s from the HTTP cache", req.URL)
+			return cachedResponse(req, body), nil
+		}
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", req.URL, err)
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := writeHTTPCacheEntry(dir, path, body); err != nil {
+		log.Printf("unable to cache response from %s: %v", req.URL, err)
+	}
+	return res, nil
+}
+
+func cachedResponse(req *http.Request, body []byte) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"X-Cache": []string{"HIT"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// writeHTTPCacheEntry writes through a temporary file so concurrent readers never see a partial entry.
+func writeHTTPCacheEntry(dir, path string, body []byte) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "entry-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func httpCacheMaxAge() time.Duration {
+	raw := strings.TrimSpace(os.Getenv(HTTPCacheMaxAgeEnvKey))
+	if raw == "" {
+		return time.Duration(defaultHTTPCacheMaxAgeMinutes) * time.Minute
+	}
+
+	minutes, err := strconv.Atoi(raw)
+	if err != nil || minutes < 0 {
+		log.Printf("invalid %s value %q; defaulting to %d minutes", HTTPCacheMaxAgeEnvKey, raw, defaultHTTPCacheMaxAgeMinutes)
+		return time.Duration(defaultHTTPCacheMaxAgeMinutes) * time.Minute
+	}
+	return time.Duration(minutes) * time.Minute
+}
@@ -1,6 +1,7 @@
 package shared
 
 import (
+	"context"
 	"log"
 	"net"
 	"net/http"
@@ -16,7 +17,7 @@ var simpleTransport = &http.Transport{
 }
 
 var simpleClient = &http.Client{
-	Transport: simpleTransport,
+	Transport: &cachingTransport{next: simpleTransport},
 	Timeout:   10 * time.Second,
 }
 
@@ -35,29 +36,40 @@ var slowTransport = &http.Transport{
 }
 
 var slowClient = &http.Client{
-	Transport: slowTransport,
+	Transport: &cachingTransport{next: slowTransport},
 	Timeout:   1200 * time.Second,
 }
 
-// API fetch functions
-func FetchFastAPI(url string) (*http.Response, error) {
-	res, err := simpleClient.Get(url)
+// API fetch functions. Both record url as a source of the ingest run of ctx. Requests that fail to get a
+// response return an error in the ErrSourceUnavailable category.
+func FetchFastAPI(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		log.Printf("Error fetching %s: %v", url, err)
 		return nil, err
 	}
+	NoteIngestSource(ctx, url)
+	res, err := simpleClient.Do(req)
+	if err != nil {
+		log.Printf("Error fetching %s: %v", url, err)
+		return nil, SourceUnavailable(err)
+	}
 	if res.StatusCode != http.StatusOK {
 		log.Printf("Unexpected status: %d", res.StatusCode)
 	}
 	return res, nil
 }
 
-func FetchSlowAPI(url string) (*http.Response, error) {
-	res, err := slowClient.Get(url)
+func FetchSlowAPI(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		log.Printf("Error fetching %s: %v", url, err)
 		return nil, err
 	}
+	NoteIngestSource(ctx, url)
+	res, err := slowClient.Do(req)
+	if err != nil {
+		log.Printf("Error fetching %s: %v", url, err)
+		return nil, SourceUnavailable(err)
+	}
 	if res.StatusCode != http.StatusOK {
 		log.Printf("Unexpected status: %d", res.StatusCode)
 	}
@@ -0,0 +1,181 @@
+package shared
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// IngestRunsTable records every collector run that loaded rows: the request that triggered it, the
+	// source URLs it read, and its outcome. Loaded rows point to it through their ingest_run_id column.
+	IngestRunsTable = "ingest_runs"
+	// IngestRunIDColumn is the column of every collector table naming the ingest run that loaded a row.
+	IngestRunIDColumn = "ingest_run_id"
+	// RequestIDHeader carries the request id of an HTTP trigger. Callers may set it; otherwise Cloud Run's
+	// trace id is used, or a new id is made up. Responses echo it.
+	RequestIDHeader = "X-Request-Id"
+
+	// maxIngestSources bounds the source URLs remembered per run, e.g. for a trips backfill of many windows.
+	maxIngestSources = 1000
+)
+
+// requestIDPattern limits request ids taken from headers to something safe to log and store.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// IngestRun is one collector run, identified by the ingest_run_id stamped on the rows it loads.
+type IngestRun struct {
+	ID        string
+	RequestID string
+	Service   string
+	Job       string
+	StartedAt time.Time
+
+	mu        sync.Mutex
+	sources   []string
+	seen      map[string]bool
+	truncated int
+}
+
+type requestIDKey struct{}
+
+type ingestRunKey struct{}
+
+// NewRequestID returns a random 128-bit id in hex.
+func NewRequestID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		// crypto/rand does not fail on supported platforms; fall back to the clock just in case.
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// RequestIDFromHeader returns the request id of r: its X-Request-Id header, else the trace id of Cloud Run's
+// X-Cloud-Trace-Context header, else a new id.
+func RequestIDFromHeader(r *http.Request) string {
+	if id := strings.TrimSpace(r.Header.Get(RequestIDHeader)); requestIDPattern.MatchString(id) {
+		return id
+	}
+	// X-Cloud-Trace-Context is TRACE_ID/SPAN_ID;o=OPTIONS.
+	trace, _, _ := strings.Cut(r.Header.Get("X-Cloud-Trace-Context"), "/")
+	if trace = strings.TrimSpace(trace); requestIDPattern.MatchString(trace) {
+		return trace
+	}
+	return NewRequestID()
+}
+
+// WithRequestID attaches the id of the request or cycle that triggered the work done with ctx.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id attached to ctx, or "" when there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// StartIngestRun records the start of a run of job in service under a new ingest run id, linked to the
+// request id of ctx, and returns ctx with the run attached so stores stamp it on the rows they write and
+// fetches record their source URLs on it.
+func StartIngestRun(ctx context.Context, db *sql.DB, service, job string) (context.Context, *IngestRun, error) {
+	if db == nil {
+		return ctx, nil, errors.New("db connection is nil")
+	}
+
+	run := &IngestRun{
+		ID:        NewRequestID(),
+		RequestID: RequestID(ctx),
+		Service:   service,
+		Job:       job,
+		StartedAt: time.Now(),
+		seen:      make(map[string]bool),
+	}
+	stmt := fmt.Sprintf(`INSERT INTO %q ("ingest_run_id", "request_id", "service", "job_name", "started_at", "sources", "build_version")
+		VALUES ($1, $2, $3, $4, $5, '[]', $6)`, IngestRunsTable)
+	if _, err := db.ExecContext(ctx, stmt, run.ID, run.RequestID, service, job, run.StartedAt, Version()); err != nil {
+		return ctx, nil, fmt.Errorf("failed to record ingest run of %s: %w", job, err)
+	}
+	return context.WithValue(ctx, ingestRunKey{}, run), run, nil
+}
+
+// IngestRunID returns the id of the ingest run attached to ctx, NULL when there is none.
+func IngestRunID(ctx context.Context) sql.NullString {
+	run, _ := ctx.Value(ingestRunKey{}).(*IngestRun)
+	if run == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: run.ID, Valid: true}
+}
+
+// NoteIngestSource records that the ingest run attached to ctx, if any, read source. Pages of one SODA query
+// differ only in $offset, so it is dropped and each query, with the time window in its $where, is recorded
+// once.
+func NoteIngestSource(ctx context.Context, source string) {
+	run, _ := ctx.Value(ingestRunKey{}).(*IngestRun)
+	if run == nil {
+		return
+	}
+
+	if base, query, ok := strings.Cut(source, "?"); ok {
+		params := strings.Split(query, "&")
+		kept := params[:0]
+		for _, param := range params {
+			if !strings.HasPrefix(param, "$offset=") {
+				kept = append(kept, param)
+			}
+		}
+		source = base + "?" + strings.Join(kept, "&")
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.seen[source] {
+		return
+	}
+	if len(run.sources) == maxIngestSources {
+		run.truncated++
+		return
+	}
+	run.seen[source] = true
+	run.sources = append(run.sources, source)
+}
+
+// Finish records the outcome of the run, as given by JobStatusOf, and the sources it read.
+func (r *IngestRun) Finish(db *sql.DB, runErr error, health *JobHealth) error {
+	if db == nil {
+		return errors.New("db connection is nil")
+	}
+
+	status, detail := JobStatusOf(runErr, health)
+	r.mu.Lock()
+	sources, truncated := append([]string(nil), r.sources...), r.truncated
+	r.mu.Unlock()
+	if truncated > 0 {
+		note := fmt.Sprintf("%d more sources not recorded", truncated)
+		if detail != "" {
+			note = detail + "; " + note
+		}
+		detail = note
+	}
+	encoded, err := json.Marshal(sources)
+	if err != nil {
+		return fmt.Errorf("failed to encode sources of ingest run %s: %w", r.ID, err)
+	}
+
+	stmt := fmt.Sprintf(`UPDATE %q SET "finished_at" = NOW(), "status" = $2, "detail" = $3, "sources" = $4 WHERE "ingest_run_id" = $1`, IngestRunsTable)
+	if _, err := db.Exec(stmt, r.ID, status, detail, string(encoded)); err != nil {
+		return fmt.Errorf("failed to record outcome of ingest run %s: %w", r.ID, err)
+	}
+	return nil
+}
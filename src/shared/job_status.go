@@ -0,0 +1,173 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// JobStatusTable records the outcome of the latest run of every job.
+const JobStatusTable = "job_status"
+
+// Job statuses. A degraded job finished, but fell back from part of its work, e.g. from the geocoder to the
+// geography crosswalks.
+const (
+	JobStatusOK       = "ok"
+	JobStatusDegraded = "degraded"
+	JobStatusFailed   = "failed"
+)
+
+// JobHealth collects the degradations, record counts, and errors of one job run. It is shared with the code
+// the job calls through its context; see WithJobHealth.
+type JobHealth struct {
+	mu     sync.Mutex
+	notes  []string
+	counts JobCounts
+}
+
+// JobCounts are the records one job run fetched from its source, inserted, and skipped for data quality
+// issues, and the errors it logged without failing, e.g. a raw archive upload that failed. Jobs that reverse
+// geocode also count the lookups the geocode cache answered and missed at the GEOCODE_CACHE_PRECISION they
+// ran with, so hit rates of different settings can be compared.
+type JobCounts struct {
+	Fetched  int `json:"fetched"`
+	Inserted int `json:"inserted"`
+	Skipped  int `json:"skipped"`
+	Errors   int `json:"errors"`
+
+	GeocodeCacheHits      int `json:"geocode_cache_hits,omitempty"`
+	GeocodeCacheMisses    int `json:"geocode_cache_misses,omitempty"`
+	GeocodeCachePrecision int `json:"geocode_cache_precision,omitempty"`
+}
+
+// GeocodeCacheHitRate returns the share of reverse geocode lookups answered from the cache, and false when
+// the job made none.
+func (c JobCounts) GeocodeCacheHitRate() (float64, bool) {
+	lookups := c.GeocodeCacheHits + c.GeocodeCacheMisses
+	if lookups == 0 {
+		return 0, false
+	}
+	return float64(c.GeocodeCacheHits) / float64(lookups), true
+}
+
+type jobHealthKey struct{}
+
+// WithJobHealth attaches health to ctx so NoteDegraded records on it.
+func WithJobHealth(ctx context.Context, health *JobHealth) context.Context {
+	return context.WithValue(ctx, jobHealthKey{}, health)
+}
+
+// NoteDegraded records reason on the JobHealth attached to ctx, once per distinct reason. It is a no-op
+// when ctx carries none.
+func NoteDegraded(ctx context.Context, reason string) {
+	health, _ := ctx.Value(jobHealthKey{}).(*JobHealth)
+	if health == nil {
+		return
+	}
+
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	if !slices.Contains(health.notes, reason) {
+		health.notes = append(health.notes, reason)
+	}
+}
+
+// CountFetched adds n records fetched from the source to the JobHealth attached to ctx, if any.
+func CountFetched(ctx context.Context, n int) {
+	updateJobCounts(ctx, func(counts *JobCounts) { counts.Fetched += n })
+}
+
+// CountLoaded adds rows inserted and records skipped to the JobHealth attached to ctx, if any.
+func CountLoaded(ctx context.Context, inserted, skipped int) {
+	updateJobCounts(ctx, func(counts *JobCounts) {
+		counts.Inserted += inserted
+		counts.Skipped += skipped
+	})
+}
+
+// CountGeocodeLookup adds a reverse geocode lookup at precision decimal places, answered from the cache when
+// hit, to the JobHealth attached to ctx, if any.
+func CountGeocodeLookup(ctx context.Context, precision int, hit bool) {
+	updateJobCounts(ctx, func(counts *JobCounts) {
+		counts.GeocodeCachePrecision = precision
+		if hit {
+			counts.GeocodeCacheHits++
+		} else {
+			counts.GeocodeCacheMisses++
+		}
+	})
+}
+
+// NoteError logs a problem the job works around without failing and counts it on the JobHealth attached to
+// ctx, if any.
+func NoteError(ctx context.Context, format string, args ...any) {
+	log.Printf(format, args...)
+	updateJobCounts(ctx, func(counts *JobCounts) { counts.Errors++ })
+}
+
+func updateJobCounts(ctx context.Context, update func(*JobCounts)) {
+	health, _ := ctx.Value(jobHealthKey{}).(*JobHealth)
+	if health == nil {
+		return
+	}
+
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	update(&health.counts)
+}
+
+// Counts returns the counts recorded so far.
+func (h *JobHealth) Counts() JobCounts {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.counts
+}
+
+// Degradations returns the reasons noted so far.
+func (h *JobHealth) Degradations() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.notes)
+}
+
+// JobStatusOf returns the status of a job run and its detail: failed with runErr when it is not nil,
+// otherwise degraded with the reasons noted on health, otherwise ok.
+func JobStatusOf(runErr error, health *JobHealth) (status, detail string) {
+	if runErr != nil {
+		return JobStatusFailed, runErr.Error()
+	}
+	if health != nil {
+		if notes := health.Degradations(); len(notes) > 0 {
+			return JobStatusDegraded, strings.Join(notes, "; ")
+		}
+	}
+	return JobStatusOK, ""
+}
+
+// RecordJobStatus stores the outcome of a run of job in service, as given by JobStatusOf, and the
+// ErrorCategory of runErr.
+func RecordJobStatus(db *sql.DB, service, job string, runErr error, health *JobHealth) error {
+	if db == nil {
+		return errors.New("db connection is nil")
+	}
+
+	status, detail := JobStatusOf(runErr, health)
+	stmt := fmt.Sprintf(`INSERT INTO %q ("service", "job_name", "status", "detail", "error_category", "finished_at", "build_version")
+		VALUES ($1, $2, $3, $4, $5, NOW(), $6)
+		ON CONFLICT ("service", "job_name") DO UPDATE
+		SET status = EXCLUDED.status,
+			detail = EXCLUDED.detail,
+			error_category = EXCLUDED.error_category,
+			finished_at = EXCLUDED.finished_at,
+			build_version = EXCLUDED.build_version`, JobStatusTable)
+
+	if _, err := db.Exec(stmt, service, job, status, detail, ErrorCategory(runErr), Version()); err != nil {
+		return fmt.Errorf("failed to record status of %s: %w", job, err)
+	}
+	return nil
+}
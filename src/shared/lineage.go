@@ -0,0 +1,269 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// BuildVersion identifies the binary that produced a table. It is normally injected at build time with
+// -ldflags "-X github.com/ahbreck/Chicago_BI/shared.BuildVersion=<git sha>".
+var BuildVersion = ""
+
+const (
+	// TableRefreshesTable records when each collector last reloaded its source table.
+	TableRefreshesTable = "table_refreshes"
+	// LineageTable records, per report build, which source tables fed each report table.
+	LineageTable = "lineage"
+)
+
+// Version returns the build version of the running binary, falling back to the VCS revision
+// embedded by the Go toolchain and finally to "dev".
+func Version() string {
+	if BuildVersion != "" {
+		return BuildVersion
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" && setting.Value != "" {
+				return setting.Value
+			}
+		}
+	}
+
+	return "dev"
+}
+
+// EnsureLineageTables creates the refresh, lineage, table diff, audit, job status, rejects, and ingest run
+// bookkeeping tables when they do not exist.
+// Call it once at startup, before collectors or reports run concurrently.
+func EnsureLineageTables(db *sql.DB) error {
+	if db == nil {
+		return errors.New("db connection is nil")
+	}
+
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+			"table_name" VARCHAR(255) PRIMARY KEY,
+			"refreshed_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+			"row_count" BIGINT NOT NULL,
+			"build_version" VARCHAR(255) NOT NULL
+		)`, TableRefreshesTable),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+			"id" SERIAL PRIMARY KEY,
+			"report_table" VARCHAR(255) NOT NULL,
+			"source_table" VARCHAR(255) NOT NULL,
+			"source_refreshed_at" TIMESTAMP WITH TIME ZONE,
+			"source_row_count" BIGINT NOT NULL,
+			"build_version" VARCHAR(255) NOT NULL,
+			"duration_ms" BIGINT NOT NULL,
+			"built_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+			"metadata" JSONB
+		)`, LineageTable),
+		// Lineage tables created before builds recorded metadata gain the column here.
+		fmt.Sprintf(`ALTER TABLE %q ADD COLUMN IF NOT EXISTS "metadata" JSONB`, LineageTable),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+			"id" SERIAL PRIMARY KEY,
+			"table_name" VARCHAR(255) NOT NULL,
+			"compared_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+			"previous_rows" BIGINT NOT NULL,
+			"current_rows" BIGINT NOT NULL,
+			"rows_added" BIGINT NOT NULL,
+			"rows_removed" BIGINT NOT NULL,
+			"rows_changed" BIGINT NOT NULL,
+			"build_version" VARCHAR(255) NOT NULL
+		)`, TableDiffsTable),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+			"id" SERIAL PRIMARY KEY,
+			"called_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+			"service" VARCHAR(64) NOT NULL,
+			"identity" VARCHAR(255) NOT NULL,
+			"method" VARCHAR(16) NOT NULL,
+			"endpoint" VARCHAR(255) NOT NULL,
+			"parameters" TEXT NOT NULL,
+			"body" TEXT NOT NULL,
+			"status" INTEGER NOT NULL,
+			"duration_ms" BIGINT NOT NULL,
+			"build_version" VARCHAR(255) NOT NULL
+		)`, AuditTable),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %q ON %q ("called_at")`, AuditTable+"_called_at_idx", AuditTable),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+			"service" VARCHAR(64) NOT NULL,
+			"job_name" VARCHAR(255) NOT NULL,
+			"status" VARCHAR(16) NOT NULL,
+			"detail" TEXT NOT NULL,
+			"finished_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+			"build_version" VARCHAR(255) NOT NULL,
+			PRIMARY KEY ("service", "job_name")
+		)`, JobStatusTable),
+		// Job status tables created before failures recorded their error category gain the column here.
+		fmt.Sprintf(`ALTER TABLE %q ADD COLUMN IF NOT EXISTS "error_category" VARCHAR(32) NOT NULL DEFAULT ''`, JobStatusTable),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+			"id" BIGSERIAL PRIMARY KEY,
+			"table_name" VARCHAR(255) NOT NULL,
+			"record_id" VARCHAR(255) NOT NULL,
+			"reason" TEXT NOT NULL,
+			"record" JSONB NOT NULL,
+			"rejected_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+			"build_version" VARCHAR(255) NOT NULL
+		)`, RejectsTable),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %q ON %q ("table_name", "rejected_at")`, RejectsTable+"_table_rejected_at_idx", RejectsTable),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+			"ingest_run_id" VARCHAR(32) PRIMARY KEY,
+			"request_id" VARCHAR(128) NOT NULL,
+			"service" VARCHAR(64) NOT NULL,
+			"job_name" VARCHAR(255) NOT NULL,
+			"started_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+			"finished_at" TIMESTAMP WITH TIME ZONE,
+			"status" VARCHAR(16),
+			"detail" TEXT NOT NULL DEFAULT '',
+			"sources" JSONB NOT NULL,
+			"build_version" VARCHAR(255) NOT NULL
+		)`, IngestRunsTable),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %q ON %q ("request_id")`, IngestRunsTable+"_request_id_idx", IngestRunsTable),
+	}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create lineage tables: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// RecordTableRefresh marks a source table as reloaded now with the given number of rows.
+func RecordTableRefresh(db *sql.DB, table string, rowCount int) error {
+	if db == nil {
+		return errors.New("db connection is nil")
+	}
+
+	stmt := fmt.Sprintf(`INSERT INTO %q ("table_name", "refreshed_at", "row_count", "build_version")
+		VALUES ($1, NOW(), $2, $3)
+		ON CONFLICT ("table_name") DO UPDATE
+		SET refreshed_at = EXCLUDED.refreshed_at,
+			row_count = EXCLUDED.row_count,
+			build_version = EXCLUDED.build_version`, TableRefreshesTable)
+
+	if _, err := db.Exec(stmt, table, rowCount, Version()); err != nil {
+		return fmt.Errorf("failed to record refresh of %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// TableRefresh is one entry of the freshness registry in table_refreshes: the last successful load of a
+// source table. RowCount is the number of rows that load inserted, which is 0 for a load that only resumed
+// or upserted existing rows.
+type TableRefresh struct {
+	Table        string    `json:"table"`
+	RefreshedAt  time.Time `json:"refreshed_at"`
+	RowCount     int64     `json:"row_count"`
+	BuildVersion string    `json:"build_version"`
+}
+
+// TableRefreshes returns every entry of the freshness registry, ordered by table.
+func TableRefreshes(ctx context.Context, db *sql.DB) ([]TableRefresh, error) {
+	if db == nil {
+		return nil, errors.New("db connection is nil")
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT "table_name", "refreshed_at", "row_count", "build_version"
+		FROM %q
+		ORDER BY "table_name"`, TableRefreshesTable))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", TableRefreshesTable, err)
+	}
+	defer rows.Close()
+
+	refreshes := []TableRefresh{}
+	for rows.Next() {
+		var refresh TableRefresh
+		if err := rows.Scan(&refresh.Table, &refresh.RefreshedAt, &refresh.RowCount, &refresh.BuildVersion); err != nil {
+			return nil, fmt.Errorf("failed to scan %s row: %w", TableRefreshesTable, err)
+		}
+		refreshes = append(refreshes, refresh)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", TableRefreshesTable, err)
+	}
+	return refreshes, nil
+}
+
+// LookupTableRefresh returns the freshness registry entry of table, reporting false when table has never
+// been recorded.
+func LookupTableRefresh(db *sql.DB, table string) (TableRefresh, bool, error) {
+	if db == nil {
+		return TableRefresh{}, false, errors.New("db connection is nil")
+	}
+
+	refresh := TableRefresh{Table: table}
+	err := db.QueryRow(fmt.Sprintf(`SELECT "refreshed_at", "row_count", "build_version" FROM %q WHERE "table_name" = $1`, TableRefreshesTable),
+		table).Scan(&refresh.RefreshedAt, &refresh.RowCount, &refresh.BuildVersion)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return TableRefresh{}, false, nil
+	case err != nil:
+		return TableRefresh{}, false, fmt.Errorf("failed to look up refresh of %s: %w", table, err)
+	}
+	return refresh, true, nil
+}
+
+// RecordLineage stores one lineage row per source table for a freshly built report table. Source row
+// counts are taken at call time; refresh timestamps come from the table_refreshes bookkeeping table and
+// are left NULL for sources that have never been recorded. metadata, such as the formula of a score the
+// table holds, is stored as JSON on every row, or NULL when empty.
+func RecordLineage(db *sql.DB, reportTable string, sources []string, metadata map[string]string, duration time.Duration) error {
+	if db == nil {
+		return errors.New("db connection is nil")
+	}
+
+	var metadataJSON sql.NullString
+	if len(metadata) > 0 {
+		raw, err := json.Marshal(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode lineage metadata for %s: %w", reportTable, err)
+		}
+		metadataJSON = sql.NullString{String: string(raw), Valid: true}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start lineage transaction: %w", err)
+	}
+
+	builtAt := time.Now()
+	insertStmt := fmt.Sprintf(`INSERT INTO %q ("report_table", "source_table", "source_refreshed_at", "source_row_count", "build_version", "duration_ms", "built_at", "metadata")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, LineageTable)
+	refreshQuery := fmt.Sprintf(`SELECT "refreshed_at" FROM %q WHERE "table_name" = $1`, TableRefreshesTable)
+
+	for _, source := range sources {
+		var refreshedAt sql.NullTime
+		if err := tx.QueryRow(refreshQuery, source).Scan(&refreshedAt); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			tx.Rollback()
+			return fmt.Errorf("failed to look up refresh time of %s: %w", source, err)
+		}
+
+		var rowCount int64
+		if err := tx.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %q`, source)).Scan(&rowCount); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to count rows in %s: %w", source, err)
+		}
+
+		if _, err := tx.Exec(insertStmt, reportTable, source, refreshedAt, rowCount, Version(), duration.Milliseconds(), builtAt, metadataJSON); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record lineage for %s: %w", reportTable, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit lineage for %s: %w", reportTable, err)
+	}
+
+	return nil
+}
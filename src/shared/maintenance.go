@@ -0,0 +1,63 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AnalyzeMinRowsEnvKey sets how many rows a load must insert before the table is analyzed.
+	AnalyzeMinRowsEnvKey = "ANALYZE_MIN_ROWS"
+	// VacuumMinRowsEnvKey sets how many rows a load must insert before the table is vacuumed as well.
+	// Vacuuming is disabled when it is unset or zero.
+	VacuumMinRowsEnvKey = "VACUUM_MIN_ROWS"
+
+	defaultAnalyzeMinRows = 1000
+)
+
+// MaintainTable refreshes planner statistics on a Postgres table after a drop-and-reload of rowCount rows.
+// It runs ANALYZE once rowCount reaches ANALYZE_MIN_ROWS, or VACUUM ANALYZE once it reaches VACUUM_MIN_ROWS,
+// and returns the statement it ran ("" when below both thresholds). Tables stored outside Postgres are skipped.
+func MaintainTable(ctx context.Context, db *sql.DB, table string, rowCount int) (string, error) {
+	if StorageBackendFor(table) != BackendPostgres {
+		return "", nil
+	}
+	if db == nil {
+		return "", errors.New("db connection is nil")
+	}
+
+	action := ""
+	if vacuumMin := maintenanceThreshold(VacuumMinRowsEnvKey, 0); vacuumMin > 0 && rowCount >= vacuumMin {
+		action = "VACUUM ANALYZE"
+	} else if rowCount >= maintenanceThreshold(AnalyzeMinRowsEnvKey, defaultAnalyzeMinRows) {
+		action = "ANALYZE"
+	}
+	if action == "" {
+		return "", nil
+	}
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(`%s %q`, action, table)); err != nil {
+		return "", fmt.Errorf("failed to %s %s: %w", strings.ToLower(action), table, err)
+	}
+	return action, nil
+}
+
+func maintenanceThreshold(key string, fallback int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		log.Printf("invalid %s value %q; defaulting to %d", key, raw, fallback)
+		return fallback
+	}
+	return n
+}
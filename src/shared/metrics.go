@@ -0,0 +1,85 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric is one sample served by MetricsHandler in the Prometheus text exposition format.
+type Metric struct {
+	Name string
+	Help string
+	// Type is "counter" or "gauge".
+	Type   string
+	Labels map[string]string
+	Value  float64
+}
+
+// metricSources are the functions MetricsHandler collects samples from, registered with RegisterMetrics.
+var metricSources struct {
+	sync.Mutex
+	sources []func(ctx context.Context) []Metric
+}
+
+// RegisterMetrics adds source to the samples served by MetricsHandler. It is called on every scrape, so
+// it should be cheap.
+func RegisterMetrics(source func(ctx context.Context) []Metric) {
+	metricSources.Lock()
+	defer metricSources.Unlock()
+	metricSources.sources = append(metricSources.sources, source)
+}
+
+// MetricsHandler serves the registered metrics at /metrics in the Prometheus text exposition format.
+func MetricsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricSources.Lock()
+		sources := append([]func(ctx context.Context) []Metric(nil), metricSources.sources...)
+		metricSources.Unlock()
+
+		var metrics []Metric
+		for _, source := range sources {
+			metrics = append(metrics, source(r.Context())...)
+		}
+		// Samples of one metric must be adjacent, under a single HELP and TYPE.
+		sort.SliceStable(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		var b strings.Builder
+		for i, metric := range metrics {
+			if i == 0 || metrics[i-1].Name != metric.Name {
+				fmt.Fprintf(&b, "# HELP %s %s\n", metric.Name, metric.Help)
+				fmt.Fprintf(&b, "# TYPE %s %s\n", metric.Name, metric.Type)
+			}
+			b.WriteString(metric.Name)
+			b.WriteString(formatMetricLabels(metric.Labels))
+			b.WriteByte(' ')
+			b.WriteString(strconv.FormatFloat(metric.Value, 'g', -1, 64))
+			b.WriteByte('\n')
+		}
+		w.Write([]byte(b.String()))
+	}
+}
+
+// formatMetricLabels renders labels sorted by name, e.g. {provider="google"}, or "" when there are none.
+func formatMetricLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, escaper.Replace(labels[name]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
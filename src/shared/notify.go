@@ -0,0 +1,53 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// AlertWebhookURLEnvKey names a webhook that receives operational alerts as a Slack-compatible
+// {"text": ...} JSON payload. Alerts are only logged when it is unset.
+const AlertWebhookURLEnvKey = "ALERT_WEBHOOK_URL"
+
+var alertClient = &http.Client{Timeout: 15 * time.Second}
+
+// Notify posts an alert with a one-line subject and a message body to ALERT_WEBHOOK_URL. It is a no-op
+// when no webhook is configured.
+func Notify(ctx context.Context, subject, message string) error {
+	webhook := strings.TrimSpace(os.Getenv(AlertWebhookURLEnvKey))
+	if webhook == "" {
+		return nil
+	}
+
+	text := subject
+	if message != "" {
+		text += "\n" + message
+	}
+	payload, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build alert request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("alert webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	// RawArchiveBucketEnvKey names the GCS bucket that receives raw collector pulls. Archiving is
+	// disabled when it is empty.
+	RawArchiveBucketEnvKey = "RAW_ARCHIVE_BUCKET"
+
+	gcsAPIBase    = "https://storage.googleapis.com/storage/v1"
+	gcsUploadBase = "https://storage.googleapis.com/upload/storage/v1"
+)
+
+// RawArchiveEnabled reports whether collectors should archive their raw pulls.
+func RawArchiveEnabled() bool {
+	return strings.TrimSpace(os.Getenv(RawArchiveBucketEnvKey)) != ""
+}
+
+// RawArchiveObjectName returns the object path used for a pull of dataset taken at fetchedAt:
+// <dataset>/dt=<YYYY-MM-DD>/<dataset>-<HHMMSS>.parquet (UTC).
+func RawArchiveObjectName(dataset string, fetchedAt time.Time) string {
+	fetchedAt = fetchedAt.UTC()
+	return path.Join(
+		dataset,
+		"dt="+fetchedAt.Format("2006-01-02"),
+		fmt.Sprintf("%s-%s.parquet", dataset, fetchedAt.Format("150405")),
+	)
+}
+
+// rawArchiveChunkObjectName returns the object path of one chunk of a pull fetched in several chunks. The
+// first chunk uses RawArchiveObjectName; later ones add a -<chunk> suffix so they do not overwrite it.
+func rawArchiveChunkObjectName(dataset string, fetchedAt time.Time, chunk int) string {
+	object := RawArchiveObjectName(dataset, fetchedAt)
+	if chunk == 0 {
+		return object
+	}
+	return strings.TrimSuffix(object, ".parquet") + fmt.Sprintf("-%03d.parquet", chunk)
+}
+
+// RawArchivePartitionDate extracts the dt=<YYYY-MM-DD> partition date from an archived object path.
+func RawArchivePartitionDate(object string) (time.Time, bool) {
+	for _, segment := range strings.Split(filepath.ToSlash(object), "/") {
+		if value, ok := strings.CutPrefix(segment, "dt="); ok {
+			date, err := time.Parse("2006-01-02", value)
+			return date, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// ArchiveRawRecords writes the records exactly as fetched from SODA to a Parquet file in the raw archive
+// bucket. Column names follow the `parquet` struct tags of T. It is a no-op when RAW_ARCHIVE_BUCKET is unset.
+func ArchiveRawRecords[T any](ctx context.Context, dataset string, records []T) (string, error) {
+	return ArchiveRawChunk(ctx, dataset, 0, records)
+}
+
+// ArchiveRawChunk archives one chunk of a pull fetched in several chunks (see FetchSODAChunks). Chunks are
+// numbered from 0; each is written to its own object in the pull's partition, so replay reads them all.
+func ArchiveRawChunk[T any](ctx context.Context, dataset string, chunk int, records []T) (string, error) {
+	bucket := strings.TrimSpace(os.Getenv(RawArchiveBucketEnvKey))
+	if bucket == "" {
+		return "", nil
+	}
+
+	var buf bytes.Buffer
+	writer := parquet.NewGenericWriter[T](&buf)
+	if _, err := writer.Write(records); err != nil {
+		return "", fmt.Errorf("failed to encode %s records as parquet: %w", dataset, err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to finish %s parquet file: %w", dataset, err)
+	}
+
+	object := rawArchiveChunkObjectName(dataset, time.Now(), chunk)
+	if err := uploadGCSObject(ctx, bucket, object, "application/vnd.apache.parquet", buf.Bytes()); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("gs://%s/%s", bucket, object), nil
+}
+
+// uploadGCSObject stores data at gs://bucket/object with a single media upload.
+func uploadGCSObject(ctx context.Context, bucket, object, contentType string, data []byte) error {
+	token, err := googleAccessTokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	uploadURL := fmt.Sprintf("%s/b/%s/o?uploadType=media&name=%s", gcsUploadBase, url.PathEscape(bucket), url.QueryEscape(object))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to construct upload request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := slowClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload gs://%s/%s: %w", bucket, object, err)
+	}
+	defer resp.Body.Close()
+
+	var created struct {
+		Name string `json:"name"`
+	}
+	if err := decodeGoogleResponse(resp, &created); err != nil {
+		return fmt.Errorf("failed to upload gs://%s/%s: %w", bucket, object, err)
+	}
+
+	return nil
+}
+
+// ListRawArchive returns, in name order, the archived objects of dataset in bucket whose partition date
+// falls within [from, to].
+func ListRawArchive(ctx context.Context, bucket, dataset string, from, to time.Time) ([]string, error) {
+	token, err := googleAccessTokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []string
+	pageToken := ""
+	for {
+		listURL := fmt.Sprintf("%s/b/%s/o?prefix=%s", gcsAPIBase, url.PathEscape(bucket), url.QueryEscape(dataset+"/dt="))
+		if pageToken != "" {
+			listURL += "&pageToken=" + url.QueryEscape(pageToken)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, listURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct list request: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := simpleClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list gs://%s/%s: %w", bucket, dataset, err)
+		}
+
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = decodeGoogleResponse(resp, &page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list gs://%s/%s: %w", bucket, dataset, err)
+		}
+
+		for _, item := range page.Items {
+			date, ok := RawArchivePartitionDate(item.Name)
+			if !ok || date.Before(from) || date.After(to) {
+				continue
+			}
+			objects = append(objects, item.Name)
+		}
+
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+
+	sort.Strings(objects)
+	return objects, nil
+}
+
+// ReadRawArchiveObject downloads an archived object from bucket.
+func ReadRawArchiveObject(ctx context.Context, bucket, object string) ([]byte, error) {
+	token, err := googleAccessTokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	objectURL := fmt.Sprintf("%s/b/%s/o/%s?alt=media", gcsAPIBase, url.PathEscape(bucket), url.PathEscape(object))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, objectURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct download request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := slowClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download gs://%s/%s: %w", bucket, object, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status downloading gs://%s/%s: %s", bucket, object, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gs://%s/%s: %w", bucket, object, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,206 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// MaxRecordsPerCycleEnvKey caps the SODA records all collectors of one cycle may fetch together;
+	// 0 removes the cap.
+	MaxRecordsPerCycleEnvKey = "MAX_RECORDS_PER_CYCLE"
+	// CollectorLimitEnvKeyPrefix overrides a collector's built-in record limit per table, e.g.
+	// COLLECTOR_LIMIT_TAXI_TRIPS=20000.
+	CollectorLimitEnvKeyPrefix = "COLLECTOR_LIMIT_"
+	// CollectorMemoryEnvKey sets how many megabytes of records one collector may hold at once. Pulls
+	// larger than that are fetched and loaded in chunks.
+	CollectorMemoryEnvKey = "COLLECTOR_MEMORY_MB"
+
+	defaultMaxRecordsPerCycle = 500000
+	defaultCollectorMemoryMB  = 64
+	// defaultRecordBytes is assumed for datasets that do not declare RecordBytes.
+	defaultRecordBytes = 1024
+)
+
+// RecordBudget counts down the records a collector cycle may still fetch. It is shared by the cycle's
+// collectors through their context; see WithRecordBudget.
+type RecordBudget struct {
+	mu        sync.Mutex
+	remaining int
+}
+
+// NewRecordBudget returns a budget of max records; max <= 0 means unlimited.
+func NewRecordBudget(max int) *RecordBudget {
+	if max <= 0 {
+		return nil
+	}
+	return &RecordBudget{remaining: max}
+}
+
+// MaxRecordsPerCycle reads MAX_RECORDS_PER_CYCLE, defaulting to 500,000.
+func MaxRecordsPerCycle() int {
+	raw := strings.TrimSpace(os.Getenv(MaxRecordsPerCycleEnvKey))
+	if raw == "" {
+		return defaultMaxRecordsPerCycle
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		log.Printf("invalid %s value %q; defaulting to %d", MaxRecordsPerCycleEnvKey, raw, defaultMaxRecordsPerCycle)
+		return defaultMaxRecordsPerCycle
+	}
+	return n
+}
+
+type recordBudgetKey struct{}
+
+// WithRecordBudget attaches budget to ctx so TakeRecords and FetchSODAChunks draw from it. A nil budget
+// leaves fetches unlimited.
+func WithRecordBudget(ctx context.Context, budget *RecordBudget) context.Context {
+	return context.WithValue(ctx, recordBudgetKey{}, budget)
+}
+
+// TakeRecords reserves up to n records from the budget attached to ctx and returns how many were granted,
+// which is n when ctx carries no budget. Records that end up not being fetched are given back with
+// ReturnRecords.
+func TakeRecords(ctx context.Context, n int) int {
+	budget, _ := ctx.Value(recordBudgetKey{}).(*RecordBudget)
+	if budget == nil || n <= 0 {
+		return n
+	}
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	granted := min(n, budget.remaining)
+	budget.remaining -= granted
+	return granted
+}
+
+// ReturnRecords gives n unused records back to the budget attached to ctx.
+func ReturnRecords(ctx context.Context, n int) {
+	budget, _ := ctx.Value(recordBudgetKey{}).(*RecordBudget)
+	if budget == nil || n <= 0 {
+		return
+	}
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	budget.remaining += n
+}
+
+// CollectorLimit returns the number of records a collector should request for ds: its built-in limit,
+// unless COLLECTOR_LIMIT_<TABLE> overrides it.
+func CollectorLimit(ds Dataset, builtin int) int {
+	key := CollectorLimitEnvKeyPrefix + strings.ToUpper(ds.Table)
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return builtin
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		log.Printf("invalid %s value %q; defaulting to %d", key, raw, builtin)
+		return builtin
+	}
+	return n
+}
+
+// ChunkSize returns how many records of ds fit in COLLECTOR_MEMORY_MB (default 64) given ds.RecordBytes.
+func ChunkSize(ds Dataset) int {
+	megabytes := defaultCollectorMemoryMB
+	if raw := strings.TrimSpace(os.Getenv(CollectorMemoryEnvKey)); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			log.Printf("invalid %s value %q; defaulting to %d", CollectorMemoryEnvKey, raw, defaultCollectorMemoryMB)
+		} else {
+			megabytes = n
+		}
+	}
+
+	recordBytes := ds.RecordBytes
+	if recordBytes <= 0 {
+		recordBytes = defaultRecordBytes
+	}
+	return max(1, megabytes<<20/recordBytes)
+}
+
+// FetchSODAChunks requests up to limit rows of q in chunks of at most chunk rows, decoding each chunk into
+// records of type T and passing it to handle before the next one is fetched, so only one chunk is held in
+// memory. Rows are drawn from the record budget in ctx; fetching stops early when the budget runs out or
+// SODA returns a short chunk. When more than one chunk is needed and q has no Order, rows are ordered by
+// :id so offsets do not overlap. The decode stats of all chunks are returned combined. Nothing is fetched
+// when the city profile does not publish q.Resource.
+func FetchSODAChunks[T any](
+	ctx context.Context,
+	q SodaQuery,
+	limit, chunk int,
+	fetch func(ctx context.Context, url string) (*http.Response, error),
+	handle func(chunk int, records []T) error,
+) (DecodeStats, error) {
+	var stats DecodeStats
+	if _, err := CityResource(q.Resource); err != nil {
+		return stats, err
+	}
+	if chunk <= 0 {
+		chunk = limit
+	}
+	if limit > chunk {
+		log.Printf("fetching %d %s records in chunks of %d", limit, q.Resource, chunk)
+		if q.Order == "" {
+			q.Order = ":id"
+		}
+	}
+
+	for index, fetched := 0, 0; fetched < limit; index++ {
+		granted := TakeRecords(ctx, min(chunk, limit-fetched))
+		if granted == 0 {
+			log.Printf("%s reached; stopping %s after %d records", MaxRecordsPerCycleEnvKey, q.Resource, fetched)
+			break
+		}
+
+		page := q
+		page.Limit = granted
+		page.Offset = q.Offset + fetched
+		records, pageStats, err := fetchSODAPage[T](ctx, page.URL(), fetch)
+		if err != nil {
+			ReturnRecords(ctx, granted)
+			return stats, err
+		}
+		ReturnRecords(ctx, granted-len(records))
+		CountFetched(ctx, len(records))
+		stats.Add(pageStats)
+
+		if err := handle(index, records); err != nil {
+			return stats, err
+		}
+		if pageStats.Records < granted {
+			break
+		}
+		fetched += granted
+	}
+	return stats, nil
+}
+
+func fetchSODAPage[T any](ctx context.Context, url string, fetch func(ctx context.Context, url string) (*http.Response, error)) ([]T, DecodeStats, error) {
+	res, err := fetch(ctx, url)
+	if err != nil {
+		return nil, DecodeStats{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, DecodeStats{}, SourceUnavailable(fmt.Errorf("unexpected status fetching %s: %s", url, res.Status))
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, DecodeStats{}, fmt.Errorf("failed to read SODA response: %w", err)
+	}
+	return DecodeSODARecords[T](body)
+}
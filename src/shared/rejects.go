@@ -0,0 +1,41 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// RejectsTable keeps the records collectors refused to load because their data is wrong rather than merely
+// incomplete, such as coordinates outside Chicago, with the reason and the record as received.
+const RejectsTable = "ingest_rejects"
+
+type rejectsDBKey struct{}
+
+// WithRejects attaches db to ctx so RecordReject writes to its rejects table.
+func WithRejects(ctx context.Context, db *sql.DB) context.Context {
+	return context.WithValue(ctx, rejectsDBKey{}, db)
+}
+
+// RecordReject stores record, rejected from table for reason, in the rejects table of the database attached
+// to ctx. It is a no-op when ctx carries none; a failure to store is logged and counted with NoteError, so
+// bookkeeping never fails a load.
+func RecordReject(ctx context.Context, table, recordID, reason string, record any) {
+	db, _ := ctx.Value(rejectsDBKey{}).(*sql.DB)
+	if db == nil {
+		return
+	}
+
+	body, err := json.Marshal(record)
+	if err != nil {
+		NoteError(ctx, "unable to encode rejected %s record %s: %v", table, recordID, err)
+		return
+	}
+
+	stmt := fmt.Sprintf(`INSERT INTO %q ("table_name", "record_id", "reason", "record", "rejected_at", "build_version")
+		VALUES ($1, $2, $3, $4, NOW(), $5)`, RejectsTable)
+	if _, err := db.ExecContext(ctx, stmt, table, recordID, reason, string(body), Version()); err != nil {
+		NoteError(ctx, "unable to record rejected %s record %s: %v", table, recordID, err)
+	}
+}
@@ -0,0 +1,181 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kelvins/geocoder"
+)
+
+// SelfTestFlagUsage describes the -selftest flag of the services.
+const SelfTestFlagUsage = "check the database, PostGIS, the SODA datasets, the geocoder, and the geography crosswalks, print a pass/fail matrix, and exit"
+
+// selfTestTimeout bounds each self-test check, so an unreachable dependency fails its row instead of
+// hanging the container preflight.
+const selfTestTimeout = 15 * time.Second
+
+// GeographyCrosswalkFiles are the CSVs written to src/data by src/shared/build_geo_maps.py. City profiles
+// other than Chicago keep their own in the crosswalk_dir subdirectory of src/data.
+var GeographyCrosswalkFiles = []string{
+	"census_tract_to_zip_code.csv",
+	"zip_code_to_community_area.csv",
+	"community_area_to_zip_code.csv",
+}
+
+// selfTestCheck is one row of the self-test matrix. run returns errSelfTestSkipped, wrapped with the
+// reason, for a dependency the configuration does not use.
+type selfTestCheck struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+var errSelfTestSkipped = errors.New("skipped")
+
+// RunSelfTest checks the external dependencies of the services: the settings they require, the database
+// at connStr, PostGIS, the $select lists of the sources, the metadata endpoint of every source, the geocoder
+// credentials when USE_GEOCODING is on, and the geography crosswalk files. It writes a pass/fail matrix to w and returns how many checks
+// failed; skipped checks do not count.
+func RunSelfTest(ctx context.Context, w io.Writer, connStr string, sources []SODASource) int {
+	checks := []selfTestCheck{
+		{name: "configuration", run: func(context.Context) error { return CheckRequiredConfig() }},
+		{name: "database", run: func(ctx context.Context) error { return selfTestDatabase(ctx, connStr) }},
+		{name: "postgis", run: func(ctx context.Context) error { return selfTestPostGIS(ctx, connStr) }},
+		{name: "soda select lists", run: func(context.Context) error { return CheckSODASelects(sources) }},
+	}
+	for _, source := range sources {
+		if source.ID == "" {
+			continue
+		}
+		checks = append(checks, selfTestCheck{
+			name: "soda " + source.Name,
+			run: func(ctx context.Context) error {
+				if _, ok := CitySODAResource(source.ID); !ok {
+					return errSelfTestSkipped
+				}
+				_, err := FetchSODAColumns(ctx, source.ID)
+				return err
+			},
+		})
+	}
+	checks = append(checks,
+		selfTestCheck{name: "geocoder", run: selfTestGeocoder},
+		selfTestCheck{name: "geography crosswalks", run: func(context.Context) error { return selfTestCrosswalks() }},
+	)
+
+	failed := 0
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tDETAIL")
+	for _, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+		err := check.run(checkCtx)
+		cancel()
+
+		result, detail := "PASS", ""
+		switch {
+		case errors.Is(err, errSelfTestSkipped):
+			result, detail = "SKIP", strings.TrimPrefix(err.Error(), errSelfTestSkipped.Error()+": ")
+		case err != nil:
+			result, detail = "FAIL", err.Error()
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.name, result, detail)
+	}
+	tw.Flush()
+
+	if failed > 0 {
+		fmt.Fprintf(w, "self-test failed: %d of %d checks failed\n", failed, len(checks))
+	} else {
+		fmt.Fprintln(w, "self-test passed")
+	}
+	return failed
+}
+
+func selfTestDatabase(ctx context.Context, connStr string) error {
+	db, err := sql.Open("postgres", ApplyDBSchema(connStr))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.PingContext(ctx)
+}
+
+// selfTestPostGIS checks that the server can provide PostGIS, which the zoning report installs when it is
+// missing.
+func selfTestPostGIS(ctx context.Context, connStr string) error {
+	db, err := sql.Open("postgres", ApplyDBSchema(connStr))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var available bool
+	if err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'postgis')`).Scan(&available); err != nil {
+		return fmt.Errorf("failed to list available extensions: %w", err)
+	}
+	if !available {
+		return errors.New("the postgis extension is not available on the server")
+	}
+	return nil
+}
+
+// selfTestGeocoder geocodes City Hall with the configured provider, which fails on a missing or rejected key.
+func selfTestGeocoder(ctx context.Context) error {
+	if os.Getenv("USE_GEOCODING") != "true" {
+		return fmt.Errorf("%w: USE_GEOCODING is off", errSelfTestSkipped)
+	}
+
+	g, err := NewGeocoder(GeocoderProvider())
+	if err != nil {
+		return err
+	}
+	_, err = g.Forward(ctx, geocoder.Address{
+		Number:  121,
+		Street:  "N LaSalle St",
+		City:    "Chicago",
+		State:   "IL",
+		Country: "United States",
+	})
+	if err != nil {
+		return fmt.Errorf("the %s geocoder failed: %w", GeocoderProvider(), err)
+	}
+	return nil
+}
+
+// selfTestCrosswalks looks for the city profile's geography crosswalk files under src/data, walking up from
+// the working directory like the reports service does.
+func selfTestCrosswalks() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+	for {
+		if info, err := os.Stat(filepath.Join(dir, "src", "data", "spatial")); err == nil && info.IsDir() {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return errors.New("could not locate the project root containing 'src/data/spatial'")
+		}
+		dir = parent
+	}
+
+	var missing []string
+	for _, name := range GeographyCrosswalkFiles {
+		info, err := os.Stat(CrosswalkPath(dir, name))
+		if err != nil || info.Size() == 0 {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing or empty: %s; run cbictl rebuild-crosswalks", strings.Join(missing, ", "))
+	}
+	return nil
+}
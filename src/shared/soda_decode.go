@@ -0,0 +1,138 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DecodeStats describes how cleanly a SODA response decoded into its record struct.
+type DecodeStats struct {
+	// Records is the number of rows in the response.
+	Records int
+	// DecodeErrors counts rows that could not be decoded at all and were dropped.
+	DecodeErrors int
+	// RecordsWithUnknown counts rows carrying at least one field the record struct does not declare.
+	RecordsWithUnknown int
+	// RecordsWithMissing counts rows lacking at least one field the record struct declares.
+	RecordsWithMissing int
+	// UnknownFields counts, per field name, the rows that carried it without a matching struct field.
+	UnknownFields map[string]int
+	// MissingFields counts, per field name, the rows that omitted it.
+	MissingFields map[string]int
+}
+
+// Add accumulates other into s, e.g. to report one line for a pull fetched in several pages.
+func (s *DecodeStats) Add(other DecodeStats) {
+	s.Records += other.Records
+	s.DecodeErrors += other.DecodeErrors
+	s.RecordsWithUnknown += other.RecordsWithUnknown
+	s.RecordsWithMissing += other.RecordsWithMissing
+	for field, n := range other.UnknownFields {
+		if s.UnknownFields == nil {
+			s.UnknownFields = make(map[string]int)
+		}
+		s.UnknownFields[field] += n
+	}
+	for field, n := range other.MissingFields {
+		if s.MissingFields == nil {
+			s.MissingFields = make(map[string]int)
+		}
+		s.MissingFields[field] += n
+	}
+}
+
+// String renders the stats as a single log-friendly line.
+func (s DecodeStats) String() string {
+	return fmt.Sprintf("records=%d decode_errors=%d records_with_unknown=%d records_with_missing=%d unknown=%s missing=%s",
+		s.Records, s.DecodeErrors, s.RecordsWithUnknown, s.RecordsWithMissing, formatFieldCounts(s.UnknownFields), formatFieldCounts(s.MissingFields))
+}
+
+// DecodeSODARecords decodes a SODA JSON array into records of type T. Each row is first decoded with
+// DisallowUnknownFields so unexpected upstream fields are noticed; such rows are then decoded leniently
+// rather than dropped. Rows that fail to decode for any other reason are counted and skipped. Field
+// presence is tracked against the json tags of T, since SODA omits null values from its rows.
+func DecodeSODARecords[T any](body []byte) ([]T, DecodeStats, error) {
+	stats := DecodeStats{
+		UnknownFields: map[string]int{},
+		MissingFields: map[string]int{},
+	}
+
+	var rows []json.RawMessage
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, stats, fmt.Errorf("response is not a JSON array of records: %w", err)
+	}
+
+	var zero T
+	expected := JSONFieldNames(zero)
+	expectedSet := stringSet(expected)
+
+	records := make([]T, 0, len(rows))
+	for _, row := range rows {
+		stats.Records++
+
+		var present map[string]json.RawMessage
+		if err := json.Unmarshal(row, &present); err != nil {
+			stats.DecodeErrors++
+			continue
+		}
+
+		missing := false
+		for _, field := range expected {
+			if _, ok := present[field]; !ok {
+				stats.MissingFields[field]++
+				missing = true
+			}
+		}
+		if missing {
+			stats.RecordsWithMissing++
+		}
+
+		var record T
+		dec := json.NewDecoder(bytes.NewReader(row))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&record); err != nil {
+			if !strings.HasPrefix(err.Error(), "json: unknown field") {
+				stats.DecodeErrors++
+				continue
+			}
+
+			stats.RecordsWithUnknown++
+			for field := range present {
+				if !expectedSet[field] {
+					stats.UnknownFields[field]++
+				}
+			}
+
+			record = *new(T)
+			if err := json.Unmarshal(row, &record); err != nil {
+				stats.DecodeErrors++
+				continue
+			}
+		}
+
+		records = append(records, record)
+	}
+
+	return records, stats, nil
+}
+
+func formatFieldCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "{}"
+	}
+
+	fields := make([]string, 0, len(counts))
+	for field := range counts {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		parts = append(parts, fmt.Sprintf("%s:%d", field, counts[field]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,62 @@
+package shared
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SodaQuery describes a SoQL request against a Socrata resource endpoint.
+type SodaQuery struct {
+	// Resource is the identifier of the Chicago dataset, e.g. "xhc6-88s9".
+	Resource string
+	// Select lists the columns to return; empty selects every column.
+	Select []string
+	// Where is an optional SoQL filter such as "week_start between '2022-01-01' and '2022-03-31'".
+	Where string
+	// Order is an optional SoQL ordering such as "trip_start_timestamp DESC".
+	Order string
+	// Limit caps the number of rows returned; zero leaves Socrata's default in place.
+	Limit int
+	// Offset skips rows, for paging together with Order.
+	Offset int
+}
+
+// URL returns the JSON endpoint URL for the query on the current city profile's portal with every parameter
+// percent-encoded. Resource is translated from Chicago's identifier by CitySODAResource; callers check with
+// CityResource first that the profile publishes it.
+func (q SodaQuery) URL() string {
+	var params []string
+	add := func(key, value string) {
+		params = append(params, key+"="+sodaEscape(value))
+	}
+
+	if len(q.Select) > 0 {
+		add("$select", strings.Join(q.Select, ","))
+	}
+	if q.Where != "" {
+		add("$where", q.Where)
+	}
+	if q.Order != "" {
+		add("$order", q.Order)
+	}
+	if q.Limit > 0 {
+		add("$limit", fmt.Sprint(q.Limit))
+	}
+	if q.Offset > 0 {
+		add("$offset", fmt.Sprint(q.Offset))
+	}
+
+	resource, _ := CitySODAResource(q.Resource)
+	endpoint := fmt.Sprintf("%s/resource/%s.json", SODADomain(), url.PathEscape(resource))
+	if len(params) == 0 {
+		return endpoint
+	}
+	return endpoint + "?" + strings.Join(params, "&")
+}
+
+// sodaEscape percent-encodes a query value, using %20 rather than '+' for spaces so SoQL
+// expressions survive proxies that do not treat '+' as a space.
+func sodaEscape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
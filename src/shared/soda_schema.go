@@ -0,0 +1,319 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// SODASchemasTable keeps the last column list seen for each upstream dataset.
+	SODASchemasTable = "soda_schemas"
+)
+
+// SODAAliasTag is the struct tag naming the column a record field is read from in datasets that call it
+// differently from its json tag, e.g. `json:"tips" soda_alias:"tip"`. Queries of those datasets select
+// the column under the json name; bulk CSV exports and schema drift checks accept either name.
+const SODAAliasTag = "soda_alias"
+
+// SODASource ties an upstream Socrata dataset to the record struct its rows are decoded into.
+type SODASource struct {
+	// Name is the dataset name used in logs and the soda_schemas table.
+	Name string
+	// ID is the Socrata four-by-four identifier, e.g. "xhc6-88s9". It is empty for sources whose identifier
+	// is configured, which the drift check and self-test skip.
+	ID string
+	// Record is a zero value of the struct each row is unmarshaled into.
+	Record any
+	// Select is the $select list collectors pull the dataset with. It must name every json field of Record,
+	// and only those; a column published under another name is selected as "column AS field".
+	Select []string
+}
+
+// SchemaDrift summarizes how an upstream dataset differs from what the code and the last check expect.
+type SchemaDrift struct {
+	// Missing lists fields the record struct decodes that upstream no longer publishes.
+	Missing []string
+	// Added lists upstream columns that were not present at the previous check.
+	Added []string
+	// Removed lists upstream columns that were present at the previous check but are gone now.
+	Removed []string
+}
+
+// Empty reports whether no drift was detected.
+func (d SchemaDrift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// JSONFieldNames returns the json tag names of the struct (or pointer to struct) record.
+func JSONFieldNames(record any) []string {
+	t := reflect.TypeOf(record)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// SODAView is the metadata Socrata publishes about a dataset at /api/views/<id>.json.
+type SODAView struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Attribution is the agency publishing the dataset.
+	Attribution string       `json:"attribution"`
+	Columns     []SODAColumn `json:"columns"`
+}
+
+// SODAColumn describes one column of a SODAView.
+type SODAColumn struct {
+	// FieldName is the API field name rows are keyed by, e.g. "sr_number".
+	FieldName string `json:"fieldName"`
+	Name      string `json:"name"`
+	// DataTypeName is the Socrata column type, e.g. "text", "number", "calendar_date", or "point".
+	DataTypeName string `json:"dataTypeName"`
+	Description  string `json:"description"`
+}
+
+// FetchSODAView returns the metadata of a dataset, whose columns exclude the system and computed-region
+// columns whose names start with ':'.
+func FetchSODAView(ctx context.Context, datasetID string) (SODAView, error) {
+	resource, err := CityResource(datasetID)
+	if err != nil {
+		return SODAView{}, err
+	}
+	url := fmt.Sprintf("%s/api/views/%s.json", SODADomain(), resource)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return SODAView{}, fmt.Errorf("failed to construct metadata request: %w", err)
+	}
+
+	resp, err := simpleClient.Do(req)
+	if err != nil {
+		return SODAView{}, SourceUnavailable(fmt.Errorf("failed to fetch metadata for %s: %w", datasetID, err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return SODAView{}, SourceUnavailable(fmt.Errorf("unexpected status fetching metadata for %s: %s", datasetID, resp.Status))
+	}
+
+	var view SODAView
+	if err := json.NewDecoder(resp.Body).Decode(&view); err != nil {
+		return SODAView{}, fmt.Errorf("failed to decode metadata for %s: %w", datasetID, err)
+	}
+
+	columns := view.Columns[:0]
+	for _, col := range view.Columns {
+		if col.FieldName == "" || strings.HasPrefix(col.FieldName, ":") {
+			continue
+		}
+		columns = append(columns, col)
+	}
+	view.Columns = columns
+	return view, nil
+}
+
+// FetchSODAColumns returns the API field names Socrata publishes for a dataset, sorted, excluding the
+// system and computed-region columns whose names start with ':'.
+func FetchSODAColumns(ctx context.Context, datasetID string) ([]string, error) {
+	view, err := FetchSODAView(ctx, datasetID)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, len(view.Columns))
+	for _, col := range view.Columns {
+		columns = append(columns, col.FieldName)
+	}
+	sort.Strings(columns)
+	return columns, nil
+}
+
+// expectedSODAColumns returns the json tag names of record, each replaced by its SODAAliasTag when current
+// has the alias but not the name.
+func expectedSODAColumns(record any, current []string) []string {
+	t := reflect.TypeOf(record)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	currentSet := stringSet(current)
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		if alias := t.Field(i).Tag.Get(SODAAliasTag); alias != "" && !currentSet[name] && currentSet[alias] {
+			name = alias
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// CompareSODAColumns computes drift between the fields a record struct expects, the upstream columns
+// now, and the upstream columns recorded at the previous check (nil when there was none).
+func CompareSODAColumns(expected, current, previous []string) SchemaDrift {
+	currentSet := stringSet(current)
+
+	var drift SchemaDrift
+	for _, field := range expected {
+		if !currentSet[field] {
+			drift.Missing = append(drift.Missing, field)
+		}
+	}
+
+	if previous != nil {
+		previousSet := stringSet(previous)
+		for _, col := range current {
+			if !previousSet[col] {
+				drift.Added = append(drift.Added, col)
+			}
+		}
+		for _, col := range previous {
+			if !currentSet[col] {
+				drift.Removed = append(drift.Removed, col)
+			}
+		}
+	}
+
+	return drift
+}
+
+// CheckSODASchemas compares each source's upstream metadata with its record struct and with the column
+// list stored by the previous check, logs any drift, and stores the current column lists. Sources whose
+// metadata cannot be fetched are logged and skipped. The returned map only contains drifted sources.
+func CheckSODASchemas(ctx context.Context, db *sql.DB, sources []SODASource) (map[string]SchemaDrift, error) {
+	if db == nil {
+		return nil, errors.New("db connection is nil")
+	}
+
+	createStmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+		"dataset" VARCHAR(255) PRIMARY KEY,
+		"dataset_id" VARCHAR(9) NOT NULL,
+		"columns" TEXT[] NOT NULL,
+		"checked_at" TIMESTAMP WITH TIME ZONE NOT NULL
+	)`, SODASchemasTable)
+	if _, err := db.ExecContext(ctx, createStmt); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", SODASchemasTable, err)
+	}
+
+	selectStmt := fmt.Sprintf(`SELECT "columns" FROM %q WHERE "dataset" = $1`, SODASchemasTable)
+	upsertStmt := fmt.Sprintf(`INSERT INTO %q ("dataset", "dataset_id", "columns", "checked_at")
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT ("dataset") DO UPDATE
+		SET dataset_id = EXCLUDED.dataset_id,
+			columns = EXCLUDED.columns,
+			checked_at = EXCLUDED.checked_at`, SODASchemasTable)
+
+	drifted := make(map[string]SchemaDrift)
+	for _, source := range sources {
+		if _, ok := CitySODAResource(source.ID); source.ID == "" || !ok {
+			continue
+		}
+		current, err := FetchSODAColumns(ctx, source.ID)
+		if err != nil {
+			log.Printf("schema check skipped for %s: %v", source.Name, err)
+			continue
+		}
+
+		var previous []string
+		err = db.QueryRowContext(ctx, selectStmt, source.Name).Scan(pq.Array(&previous))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to load previous schema of %s: %w", source.Name, err)
+		}
+
+		drift := CompareSODAColumns(expectedSODAColumns(source.Record, current), current, previous)
+		if !drift.Empty() {
+			drifted[source.Name] = drift
+			log.Printf("SCHEMA DRIFT in %s (%s): missing expected fields %v, added columns %v, removed columns %v",
+				source.Name, source.ID, drift.Missing, drift.Added, drift.Removed)
+		}
+
+		if _, err := db.ExecContext(ctx, upsertStmt, source.Name, source.ID, pq.Array(current)); err != nil {
+			return nil, fmt.Errorf("failed to store schema of %s: %w", source.Name, err)
+		}
+	}
+
+	return drifted, nil
+}
+
+// selectedField returns the field a $select entry is decoded into: the alias of "column AS field", or the
+// column itself.
+func selectedField(entry string) string {
+	words := strings.Fields(entry)
+	if len(words) == 3 && strings.EqualFold(words[1], "AS") {
+		return words[2]
+	}
+	return strings.TrimSpace(entry)
+}
+
+// CheckSODASelects verifies that the Select list of every source names exactly the json fields of its
+// Record, so a column added to one is not silently missing from the other. It returns an error listing
+// every mismatch.
+func CheckSODASelects(sources []SODASource) error {
+	var problems []string
+	for _, source := range sources {
+		fields := JSONFieldNames(source.Record)
+		selected := make([]string, len(source.Select))
+		for i, entry := range source.Select {
+			selected[i] = selectedField(entry)
+		}
+
+		fieldSet, selectedSet := stringSet(fields), stringSet(selected)
+		var unselected, undecoded []string
+		for _, field := range fields {
+			if !selectedSet[field] {
+				unselected = append(unselected, field)
+			}
+		}
+		for _, field := range selected {
+			if !fieldSet[field] {
+				undecoded = append(undecoded, field)
+			}
+		}
+		if len(unselected) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: fields missing from $select: %s", source.Name, strings.Join(unselected, ", ")))
+		}
+		if len(undecoded) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: $select columns without a record field: %s", source.Name, strings.Join(undecoded, ", ")))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("SODA $select lists do not match their record structs:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
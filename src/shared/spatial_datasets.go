@@ -18,23 +18,40 @@ type SpatialDataset struct {
 	FileName string
 }
 
+// CommunityAreasDataset holds the Boundaries - Community Areas of the data portal.
+var CommunityAreasDataset = SpatialDataset{
+	Name:     "community_areas",
+	URL:      ChicagoSODADomain + "/resource/igwz-8jzy.geojson",
+	FileName: "community_areas.geojson",
+}
+
+// ZipCodesDataset holds the Boundaries - ZIP Codes of the data portal.
+var ZipCodesDataset = SpatialDataset{
+	Name:     "zip_codes",
+	URL:      ChicagoSODADomain + "/resource/unjd-c2ca.geojson",
+	FileName: "zip_codes.geojson",
+}
+
+// CensusTractsDataset holds the Boundaries - Census Tracts - 2010 of the data portal.
+var CensusTractsDataset = SpatialDataset{
+	Name:     "census_tracts",
+	URL:      ChicagoSODADomain + "/resource/4hp8-2i8z.geojson",
+	FileName: "census_tracts.geojson",
+}
+
 // DefaultSpatialDatasets enumerates the spatial files required by reporting workflows.
 var DefaultSpatialDatasets = []SpatialDataset{
-	{
-		Name:     "community_areas",
-		URL:      "https://data.cityofchicago.org/resource/igwz-8jzy.geojson",
-		FileName: "community_areas.geojson",
-	},
-	{
-		Name:     "zip_codes",
-		URL:      "https://data.cityofchicago.org/resource/unjd-c2ca.geojson",
-		FileName: "zip_codes.geojson",
-	},
-	{
-		Name:     "census_tracts",
-		URL:      "https://data.cityofchicago.org/resource/4hp8-2i8z.geojson",
-		FileName: "census_tracts.geojson",
-	},
+	CommunityAreasDataset,
+	ZipCodesDataset,
+	CensusTractsDataset,
+}
+
+// ZoningDistrictsDataset holds the current zoning districts. It is only needed by the zoning report, so it is
+// not part of DefaultSpatialDatasets. The portal returns 1,000 features unless $limit says otherwise.
+var ZoningDistrictsDataset = SpatialDataset{
+	Name:     "zoning_districts",
+	URL:      ChicagoSODADomain + "/resource/dj47-wfun.geojson?$limit=50000",
+	FileName: "zoning_districts.geojson",
 }
 
 const (
@@ -44,8 +61,9 @@ const (
 	spatialRequestTimeout = 30 * time.Second
 )
 
-// EnsureSpatialDatasets ensures all provided datasets exist on disk, downloading missing files.
-// The returned map contains dataset names mapped to their absolute file paths.
+// EnsureSpatialDatasets ensures all provided datasets exist on disk, downloading missing files from the
+// current city profile's boundaries. The returned map contains dataset names mapped to their absolute file
+// paths.
 func EnsureSpatialDatasets(ctx context.Context, datasets ...SpatialDataset) (map[string]string, error) {
 	if len(datasets) == 0 {
 		return map[string]string{}, nil
@@ -72,6 +90,7 @@ func EnsureSpatialDatasets(ctx context.Context, datasets ...SpatialDataset) (map
 	client := &http.Client{Timeout: spatialRequestTimeout}
 	results := make(map[string]string, len(datasets))
 	for _, ds := range datasets {
+		ds = profileSpatialDataset(ds)
 		if ds.Name == "" {
 			return nil, errors.New("dataset name is required")
 		}
@@ -113,7 +132,11 @@ func ensureSpatialDataset(ctx context.Context, client *http.Client, dir string,
 		return "", fmt.Errorf("unexpected status downloading %s: %s", ds.URL, resp.Status)
 	}
 
-	tmpFile, err := os.CreateTemp(dir, ds.FileName+".tmp-*")
+	// Profiles other than Chicago keep their files in a subdirectory.
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create spatial data directory %q: %w", filepath.Dir(targetPath), err)
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(targetPath), filepath.Base(ds.FileName)+".tmp-*")
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp file: %w", err)
 	}
@@ -0,0 +1,99 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ColumnType names a warehouse-neutral column type. The values match BigQuery standard SQL type names.
+type ColumnType string
+
+const (
+	ColumnString    ColumnType = "STRING"
+	ColumnFloat     ColumnType = "FLOAT64"
+	ColumnInteger   ColumnType = "INT64"
+	ColumnBoolean   ColumnType = "BOOL"
+	ColumnDate      ColumnType = "DATE"
+	ColumnTimestamp ColumnType = "TIMESTAMP"
+)
+
+// Column describes one value written by a collector, in the same order as the InsertSQL parameters.
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// Dataset describes a collector output table in enough detail for any Store to create and fill it.
+type Dataset struct {
+	// Table is the destination table name.
+	Table string
+	// CreateSQL is the Postgres DDL used to create the table.
+	CreateSQL string
+	// InsertSQL is the Postgres insert (or upsert) statement taking one $n parameter per column.
+	InsertSQL string
+	// Columns lists the inserted values in parameter order; warehouses without SQL DDL derive their schema from it.
+	// The last column is always IngestRunIDColumn.
+	Columns []Column
+	// DiffKey identifies a row across consecutive pulls of a slowly-changing dataset. Datasets with a key
+	// are compared with their previous pull on every reload (see PreserveTable and RecordTableDiff).
+	DiffKey []string
+	// DiffColumns are compared between pulls to count changed rows; other columns, such as retrieval
+	// dates, are ignored.
+	DiffColumns []string
+	// RecordBytes estimates the memory one record holds while it is loaded: the raw JSON, the decoded
+	// struct, and its insert values. ChunkSize divides COLLECTOR_MEMORY_MB by it.
+	RecordBytes int
+}
+
+// Store is a destination warehouse for collector output.
+type Store interface {
+	// Name identifies the backend in logs.
+	Name() string
+	// Reset drops the dataset table if it exists and recreates it empty.
+	Reset(ctx context.Context, ds Dataset) error
+	// Ensure creates the dataset table if it does not exist yet, keeping any rows it already holds.
+	Ensure(ctx context.Context, ds Dataset) error
+	// Insert writes one record whose values are ordered like ds.Columns, leaving out the last column,
+	// ingest_run_id, which it fills with the ingest run of ctx. Backends may buffer rows until Flush.
+	Insert(ctx context.Context, ds Dataset, values ...any) error
+	// Flush makes all buffered rows durable.
+	Flush(ctx context.Context, ds Dataset) error
+}
+
+const (
+	// StorageBackendEnvKey selects the default backend for every dataset.
+	StorageBackendEnvKey = "STORAGE_BACKEND"
+	// storageBackendTableEnvPrefix is followed by the upper-cased table name to override a single dataset.
+	storageBackendTableEnvPrefix = "STORAGE_BACKEND_"
+
+	BackendPostgres = "postgres"
+	BackendBigQuery = "bigquery"
+)
+
+// StorageBackendFor returns the backend configured for table. STORAGE_BACKEND_<TABLE> takes precedence
+// over STORAGE_BACKEND, and Postgres is used when neither is set.
+func StorageBackendFor(table string) string {
+	backend := strings.TrimSpace(os.Getenv(storageBackendTableEnvPrefix + strings.ToUpper(table)))
+	if backend == "" {
+		backend = strings.TrimSpace(os.Getenv(StorageBackendEnvKey))
+	}
+	if backend == "" {
+		return BackendPostgres
+	}
+	return strings.ToLower(backend)
+}
+
+// StoreForTable builds the Store configured for table.
+func StoreForTable(db *sql.DB, table string) (Store, error) {
+	switch backend := StorageBackendFor(table); backend {
+	case BackendPostgres:
+		return NewPostgresStore(db)
+	case BackendBigQuery:
+		return NewBigQueryStoreFromEnv()
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q for table %s", backend, table)
+	}
+}
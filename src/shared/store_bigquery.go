@@ -0,0 +1,304 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bigQueryAPIBase    = "https://bigquery.googleapis.com/bigquery/v2"
+	bigQueryUploadBase = "https://bigquery.googleapis.com/upload/bigquery/v2"
+	// bigQueryJobPollInterval is how often load job status is checked.
+	bigQueryJobPollInterval = 2 * time.Second
+)
+
+// BigQueryStore loads datasets into BigQuery with load jobs. Rows are buffered in memory by Insert and
+// submitted as newline-delimited JSON on Flush. BigQuery has no upsert semantics, so rows that Postgres
+// would merge through ON CONFLICT are appended as-is.
+type BigQueryStore struct {
+	project string
+	dataset string
+	client  *http.Client
+	tokens  *accessTokenSource
+
+	mu   sync.Mutex
+	rows map[string][]map[string]any
+}
+
+// NewBigQueryStoreFromEnv configures a BigQueryStore from BIGQUERY_PROJECT (falling back to PROJECT_ID)
+// and BIGQUERY_DATASET. Credentials are resolved by googleAccessTokens.
+func NewBigQueryStoreFromEnv() (*BigQueryStore, error) {
+	project := strings.TrimSpace(os.Getenv("BIGQUERY_PROJECT"))
+	if project == "" {
+		project = strings.TrimSpace(os.Getenv("PROJECT_ID"))
+	}
+	dataset := strings.TrimSpace(os.Getenv("BIGQUERY_DATASET"))
+	return NewBigQueryStore(project, dataset)
+}
+
+// NewBigQueryStore creates a store writing into project.dataset.
+func NewBigQueryStore(project, dataset string) (*BigQueryStore, error) {
+	if project == "" {
+		return nil, errors.New("bigquery project is required (set BIGQUERY_PROJECT or PROJECT_ID)")
+	}
+	if dataset == "" {
+		return nil, errors.New("bigquery dataset is required (set BIGQUERY_DATASET)")
+	}
+
+	return &BigQueryStore{
+		project: project,
+		dataset: dataset,
+		client:  simpleClient,
+		tokens:  googleAccessTokens,
+		rows:    make(map[string][]map[string]any),
+	}, nil
+}
+
+func (s *BigQueryStore) Name() string {
+	return BackendBigQuery
+}
+
+// Reset deletes the BigQuery table and discards any buffered rows. The table is recreated by the load
+// job in Flush, so an empty pull leaves no table behind rather than stale data.
+func (s *BigQueryStore) Reset(ctx context.Context, ds Dataset) error {
+	s.mu.Lock()
+	delete(s.rows, ds.Table)
+	s.mu.Unlock()
+
+	url := fmt.Sprintf("%s/projects/%s/datasets/%s/tables/%s", bigQueryAPIBase, s.project, s.dataset, ds.Table)
+	resp, err := s.do(ctx, http.MethodDelete, url, "", nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete bigquery table %s: %w", ds.Table, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status deleting bigquery table %s: %s: %s", ds.Table, resp.Status, body)
+	}
+
+	return nil
+}
+
+// Ensure is a no-op because the load job in Flush creates a missing table and appends to an existing one.
+func (s *BigQueryStore) Ensure(ctx context.Context, ds Dataset) error {
+	return nil
+}
+
+// Insert buffers a row of values followed by the ingest run id of ctx, for the last column.
+func (s *BigQueryStore) Insert(ctx context.Context, ds Dataset, values ...any) error {
+	values = append(values, IngestRunID(ctx))
+	if len(values) != len(ds.Columns) {
+		return fmt.Errorf("dataset %s expects %d values, got %d", ds.Table, len(ds.Columns), len(values))
+	}
+
+	row := make(map[string]any, len(values))
+	for i, col := range ds.Columns {
+		value, err := bigQueryValue(col, values[i])
+		if err != nil {
+			return fmt.Errorf("column %s of %s: %w", col.Name, ds.Table, err)
+		}
+		row[col.Name] = value
+	}
+
+	s.mu.Lock()
+	s.rows[ds.Table] = append(s.rows[ds.Table], row)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Flush submits the buffered rows for ds as a single load job and waits for it to finish.
+func (s *BigQueryStore) Flush(ctx context.Context, ds Dataset) error {
+	s.mu.Lock()
+	rows := s.rows[ds.Table]
+	delete(s.rows, ds.Table)
+	s.mu.Unlock()
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return fmt.Errorf("failed to encode %s row: %w", ds.Table, err)
+		}
+	}
+
+	fields := make([]map[string]string, 0, len(ds.Columns))
+	for _, col := range ds.Columns {
+		fields = append(fields, map[string]string{"name": col.Name, "type": string(col.Type), "mode": "NULLABLE"})
+	}
+
+	job := map[string]any{
+		"configuration": map[string]any{
+			"load": map[string]any{
+				"sourceFormat":      "NEWLINE_DELIMITED_JSON",
+				"createDisposition": "CREATE_IF_NEEDED",
+				"writeDisposition":  "WRITE_APPEND",
+				// Tables loaded before rows carried their ingest run gain the column.
+				"schemaUpdateOptions": []string{"ALLOW_FIELD_ADDITION"},
+				"destinationTable": map[string]string{
+					"projectId": s.project,
+					"datasetId": s.dataset,
+					"tableId":   ds.Table,
+				},
+				"schema": map[string]any{"fields": fields},
+			},
+		},
+	}
+
+	body, contentType, err := multipartRelated(job, data.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to build load request for %s: %w", ds.Table, err)
+	}
+
+	url := fmt.Sprintf("%s/projects/%s/jobs?uploadType=multipart", bigQueryUploadBase, s.project)
+	resp, err := s.do(ctx, http.MethodPost, url, contentType, body)
+	if err != nil {
+		return fmt.Errorf("failed to submit load job for %s: %w", ds.Table, err)
+	}
+	defer resp.Body.Close()
+
+	var status bigQueryJob
+	if err := decodeGoogleResponse(resp, &status); err != nil {
+		return fmt.Errorf("failed to submit load job for %s: %w", ds.Table, err)
+	}
+
+	return s.waitForJob(ctx, status)
+}
+
+type bigQueryJob struct {
+	JobReference struct {
+		JobID    string `json:"jobId"`
+		Location string `json:"location"`
+	} `json:"jobReference"`
+	Status struct {
+		State       string `json:"state"`
+		ErrorResult *struct {
+			Message string `json:"message"`
+		} `json:"errorResult"`
+	} `json:"status"`
+}
+
+func (s *BigQueryStore) waitForJob(ctx context.Context, job bigQueryJob) error {
+	for {
+		if job.Status.State == "DONE" {
+			if job.Status.ErrorResult != nil {
+				return fmt.Errorf("bigquery load job %s failed: %s", job.JobReference.JobID, job.Status.ErrorResult.Message)
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(bigQueryJobPollInterval):
+		}
+
+		url := fmt.Sprintf("%s/projects/%s/jobs/%s?location=%s", bigQueryAPIBase, s.project, job.JobReference.JobID, job.JobReference.Location)
+		resp, err := s.do(ctx, http.MethodGet, url, "", nil)
+		if err != nil {
+			return fmt.Errorf("failed to poll bigquery load job %s: %w", job.JobReference.JobID, err)
+		}
+		err = decodeGoogleResponse(resp, &job)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to poll bigquery load job: %w", err)
+		}
+	}
+}
+
+func (s *BigQueryStore) do(ctx context.Context, method, url, contentType string, body []byte) (*http.Response, error) {
+	token, err := s.tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	return s.client.Do(req)
+}
+
+// multipartRelated builds the multipart/related body expected by the BigQuery upload endpoint.
+func multipartRelated(metadata any, data []byte) ([]byte, string, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	metaPart, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	if err != nil {
+		return nil, "", err
+	}
+	if err := json.NewEncoder(metaPart).Encode(metadata); err != nil {
+		return nil, "", err
+	}
+
+	dataPart, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}})
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := dataPart.Write(data); err != nil {
+		return nil, "", err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), "multipart/related; boundary=" + writer.Boundary(), nil
+}
+
+// bigQueryValue converts a collector value into its JSON load representation.
+func bigQueryValue(col Column, value any) (any, error) {
+	if valuer, ok := value.(driver.Valuer); ok {
+		v, err := valuer.Value()
+		if err != nil {
+			return nil, err
+		}
+		value = v
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case time.Time:
+		if col.Type == ColumnDate {
+			return v.Format("2006-01-02"), nil
+		}
+		return v.UTC().Format(time.RFC3339Nano), nil
+	case string:
+		// SODA serializes dates as floating timestamps; DATE columns only accept the date part.
+		if col.Type == ColumnDate && len(v) > len("2006-01-02") {
+			return v[:len("2006-01-02")], nil
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,61 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// PostgresStore writes datasets straight into the Postgres data lake.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore wraps an open database connection.
+func NewPostgresStore(db *sql.DB) (*PostgresStore, error) {
+	if db == nil {
+		return nil, errors.New("db connection is nil")
+	}
+	return &PostgresStore{db: db}, nil
+}
+
+func (s *PostgresStore) Name() string {
+	return BackendPostgres
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, ds Dataset) error {
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %q`, ds.Table)); err != nil {
+		return fmt.Errorf("failed to drop %s: %w", ds.Table, err)
+	}
+	if _, err := s.db.ExecContext(ctx, ds.CreateSQL); err != nil {
+		return fmt.Errorf("failed to create %s: %w", ds.Table, err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) Ensure(ctx context.Context, ds Dataset) error {
+	if _, err := s.db.ExecContext(ctx, ds.CreateSQL); err != nil {
+		return fmt.Errorf("failed to create %s: %w", ds.Table, err)
+	}
+	// Tables created before rows carried their ingest run gain the column here.
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %q ADD COLUMN IF NOT EXISTS %q VARCHAR(32)`, ds.Table, IngestRunIDColumn)); err != nil {
+		return fmt.Errorf("failed to add %s to %s: %w", IngestRunIDColumn, ds.Table, err)
+	}
+	return nil
+}
+
+// Insert appends the ingest run id of ctx to values, for the last InsertSQL parameter. Inserts that collide
+// with a concurrent write fail in the ErrDBConflict category.
+func (s *PostgresStore) Insert(ctx context.Context, ds Dataset, values ...any) error {
+	values = append(values, IngestRunID(ctx))
+	if _, err := s.db.ExecContext(ctx, ds.InsertSQL, values...); err != nil {
+		return ClassifyDBError(fmt.Errorf("failed to insert into %s: %w", ds.Table, err))
+	}
+	return nil
+}
+
+// Flush is a no-op because every Insert is committed immediately.
+func (s *PostgresStore) Flush(ctx context.Context, ds Dataset) error {
+	return nil
+}
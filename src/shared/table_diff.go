@@ -0,0 +1,123 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TableDiffsTable records, per reload of a slowly-changing dataset, how its rows changed since the
+// previous pull.
+const TableDiffsTable = "table_diffs"
+
+// previousTableSuffix names the copy of a table kept from the previous pull for diffing.
+const previousTableSuffix = "_previous"
+
+// TableDiff summarizes how a reload changed a table compared with the previous pull.
+type TableDiff struct {
+	Table        string
+	PreviousRows int
+	CurrentRows  int
+	Added        int
+	Removed      int
+	Changed      int
+}
+
+func (d TableDiff) String() string {
+	return fmt.Sprintf("%d added, %d removed, %d changed (%d -> %d rows)", d.Added, d.Removed, d.Changed, d.PreviousRows, d.CurrentRows)
+}
+
+// PreserveTable copies the current contents of ds into <table>_previous before a reload, so RecordTableDiff
+// can compare the new pull against it. It reports whether a copy was made; datasets without a DiffKey,
+// tables stored outside Postgres, and tables that do not exist yet are skipped.
+func PreserveTable(ctx context.Context, db *sql.DB, ds Dataset) (bool, error) {
+	if len(ds.DiffKey) == 0 || StorageBackendFor(ds.Table) != BackendPostgres {
+		return false, nil
+	}
+	if db == nil {
+		return false, errors.New("db connection is nil")
+	}
+
+	var regClass sql.NullString
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass($1)`, fmt.Sprintf("%q", ds.Table)).Scan(&regClass); err != nil {
+		return false, fmt.Errorf("failed to check for %s: %w", ds.Table, err)
+	}
+	if !regClass.Valid {
+		return false, nil
+	}
+
+	previous := ds.Table + previousTableSuffix
+	statements := []string{
+		fmt.Sprintf(`DROP TABLE IF EXISTS %q`, previous),
+		fmt.Sprintf(`CREATE TABLE %q AS TABLE %q`, previous, ds.Table),
+	}
+	for _, stmt := range statements {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return false, fmt.Errorf("failed to preserve %s: %w", ds.Table, err)
+		}
+	}
+	return true, nil
+}
+
+// RecordTableDiff compares the reloaded ds with the copy saved by PreserveTable, matching rows on
+// ds.DiffKey and counting a matched row as changed when any of ds.DiffColumns differs. The summary is
+// appended to the table_diffs history and returned.
+func RecordTableDiff(ctx context.Context, db *sql.DB, ds Dataset) (TableDiff, error) {
+	if db == nil {
+		return TableDiff{}, errors.New("db connection is nil")
+	}
+	if len(ds.DiffKey) == 0 {
+		return TableDiff{}, fmt.Errorf("dataset %s has no diff key", ds.Table)
+	}
+
+	joins := make([]string, 0, len(ds.DiffKey))
+	for _, column := range ds.DiffKey {
+		joins = append(joins, fmt.Sprintf(`c.%q = p.%q`, column, column))
+	}
+	// sideNull matches rows missing from one side of the join: added rows have no previous key, removed
+	// rows no current key.
+	sideNull := func(alias string) string {
+		conditions := make([]string, 0, len(ds.DiffKey))
+		for _, column := range ds.DiffKey {
+			conditions = append(conditions, fmt.Sprintf(`%s.%q IS NULL`, alias, column))
+		}
+		return strings.Join(conditions, " AND ")
+	}
+	currentCols := make([]string, 0, len(ds.DiffColumns))
+	previousCols := make([]string, 0, len(ds.DiffColumns))
+	for _, column := range ds.DiffColumns {
+		currentCols = append(currentCols, fmt.Sprintf(`c.%q`, column))
+		previousCols = append(previousCols, fmt.Sprintf(`p.%q`, column))
+	}
+
+	changed := "0"
+	if len(ds.DiffColumns) > 0 {
+		changed = fmt.Sprintf(`COUNT(*) FILTER (WHERE NOT (%s) AND NOT (%s) AND ROW(%s) IS DISTINCT FROM ROW(%s))`,
+			sideNull("c"), sideNull("p"), strings.Join(currentCols, ", "), strings.Join(previousCols, ", "))
+	}
+
+	query := fmt.Sprintf(`SELECT
+		COUNT(*) FILTER (WHERE NOT (%s)),
+		COUNT(*) FILTER (WHERE NOT (%s)),
+		COUNT(*) FILTER (WHERE %s),
+		COUNT(*) FILTER (WHERE %s),
+		%s
+	FROM %q c
+	FULL OUTER JOIN %q p ON %s`,
+		sideNull("p"), sideNull("c"), sideNull("p"), sideNull("c"), changed,
+		ds.Table, ds.Table+previousTableSuffix, strings.Join(joins, " AND "))
+
+	diff := TableDiff{Table: ds.Table}
+	if err := db.QueryRowContext(ctx, query).Scan(&diff.PreviousRows, &diff.CurrentRows, &diff.Added, &diff.Removed, &diff.Changed); err != nil {
+		return TableDiff{}, fmt.Errorf("failed to diff %s against the previous pull: %w", ds.Table, err)
+	}
+
+	insertStmt := fmt.Sprintf(`INSERT INTO %q ("table_name", "compared_at", "previous_rows", "current_rows", "rows_added", "rows_removed", "rows_changed", "build_version")
+		VALUES ($1, NOW(), $2, $3, $4, $5, $6, $7)`, TableDiffsTable)
+	if _, err := db.ExecContext(ctx, insertStmt, diff.Table, diff.PreviousRows, diff.CurrentRows, diff.Added, diff.Removed, diff.Changed, Version()); err != nil {
+		return diff, fmt.Errorf("failed to record diff of %s: %w", ds.Table, err)
+	}
+	return diff, nil
+}
@@ -0,0 +1,136 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// postgresTypes lists the information_schema data_type values accepted for each column type.
+var postgresTypes = map[ColumnType][]string{
+	ColumnString:    {"character varying", "text", "character"},
+	ColumnFloat:     {"double precision", "real", "numeric"},
+	ColumnInteger:   {"integer", "bigint", "smallint"},
+	ColumnBoolean:   {"boolean"},
+	ColumnDate:      {"date"},
+	ColumnTimestamp: {"timestamp without time zone", "timestamp with time zone"},
+}
+
+// TableSchemaDiff describes how a Postgres table differs from its Dataset definition.
+type TableSchemaDiff struct {
+	Table string
+	// MissingTable is set when the table does not exist at all.
+	MissingTable bool
+	// MissingColumns lists columns the Dataset declares but the table lacks.
+	MissingColumns []string
+	// WrongTypes describes declared columns whose database type does not match, e.g. "latitude: expected FLOAT64, found text".
+	WrongTypes []string
+	// ExtraColumns lists table columns neither declared nor created by the Dataset; these are reported but harmless.
+	ExtraColumns []string
+}
+
+// Breaking reports whether queries written against the Dataset definition may fail on this table.
+func (d TableSchemaDiff) Breaking() bool {
+	return len(d.MissingColumns) > 0 || len(d.WrongTypes) > 0
+}
+
+// CompareTableSchema compares ds against the columns of its table in the current schema.
+func CompareTableSchema(ctx context.Context, db *sql.DB, ds Dataset) (TableSchemaDiff, error) {
+	diff := TableSchemaDiff{Table: ds.Table}
+
+	rows, err := db.QueryContext(ctx, `SELECT column_name, data_type
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1`, ds.Table)
+	if err != nil {
+		return diff, fmt.Errorf("failed to read columns of %s: %w", ds.Table, err)
+	}
+	defer rows.Close()
+
+	actual := make(map[string]string)
+	for rows.Next() {
+		var name, dataType string
+		if err := rows.Scan(&name, &dataType); err != nil {
+			return diff, fmt.Errorf("failed to scan columns of %s: %w", ds.Table, err)
+		}
+		actual[name] = dataType
+	}
+	if err := rows.Err(); err != nil {
+		return diff, fmt.Errorf("failed to read columns of %s: %w", ds.Table, err)
+	}
+
+	if len(actual) == 0 {
+		diff.MissingTable = true
+		return diff, nil
+	}
+
+	declared := make(map[string]bool, len(ds.Columns))
+	for _, col := range ds.Columns {
+		declared[col.Name] = true
+
+		dataType, ok := actual[col.Name]
+		if !ok {
+			diff.MissingColumns = append(diff.MissingColumns, col.Name)
+			continue
+		}
+		if !typeMatches(col.Type, dataType) {
+			diff.WrongTypes = append(diff.WrongTypes, fmt.Sprintf("%s: expected %s, found %s", col.Name, col.Type, dataType))
+		}
+	}
+
+	for name := range actual {
+		// Columns created by the DDL but never inserted, such as serial ids, are expected.
+		if !declared[name] && !strings.Contains(ds.CreateSQL, `"`+name+`"`) {
+			diff.ExtraColumns = append(diff.ExtraColumns, name)
+		}
+	}
+	sort.Strings(diff.ExtraColumns)
+
+	return diff, nil
+}
+
+// CheckTableSchemas compares every dataset with its Postgres table and logs the differences. Tables that do
+// not exist yet are skipped, since collectors create them on their next run. The returned slice only holds
+// tables with breaking differences.
+func CheckTableSchemas(ctx context.Context, db *sql.DB, datasets []Dataset) ([]TableSchemaDiff, error) {
+	if db == nil {
+		return nil, errors.New("db connection is nil")
+	}
+
+	var breaking []TableSchemaDiff
+	for _, ds := range datasets {
+		if StorageBackendFor(ds.Table) != BackendPostgres {
+			continue
+		}
+
+		diff, err := CompareTableSchema(ctx, db, ds)
+		if err != nil {
+			return nil, err
+		}
+		if diff.MissingTable {
+			continue
+		}
+
+		if diff.Breaking() {
+			breaking = append(breaking, diff)
+			log.Printf("TABLE SCHEMA DRIFT in %s: missing columns %v, wrong types %v", diff.Table, diff.MissingColumns, diff.WrongTypes)
+		}
+		if len(diff.ExtraColumns) > 0 {
+			log.Printf("table %s has columns not declared in code: %s", diff.Table, strings.Join(diff.ExtraColumns, ", "))
+		}
+	}
+
+	return breaking, nil
+}
+
+func typeMatches(columnType ColumnType, dataType string) bool {
+	for _, accepted := range postgresTypes[columnType] {
+		if dataType == accepted {
+			return true
+		}
+	}
+	return false
+}
@@ -32,13 +32,21 @@ def get_database_url() -> str:
     return db_url
 
 
+def connect():
+    """Open a connection whose search_path is DB_SCHEMA when set, matching the Go services."""
+    schema = os.getenv("DB_SCHEMA", "").strip()
+    if schema:
+        return psycopg2.connect(get_database_url(), options=f"-c search_path={schema}")
+    return psycopg2.connect(get_database_url())
+
+
 def fetch_table_rows(table_name: str, limit: int, offset: int):
     query = sql.SQL("SELECT * FROM {} LIMIT %s OFFSET %s").format(
         sql.Identifier(table_name)
     )
     count_query = sql.SQL("SELECT COUNT(*) FROM {}").format(sql.Identifier(table_name))
 
-    with psycopg2.connect(get_database_url()) as conn:
+    with connect() as conn:
         with conn.cursor() as cur:
             cur.execute(query, (limit, offset))
             rows = cur.fetchall()
@@ -88,13 +96,13 @@ def _startup_delay_seconds() -> int:
 
 
 def missing_required_tables() -> list[str]:
-    with psycopg2.connect(get_database_url()) as conn:
+    with connect() as conn:
         with conn.cursor() as cur:
             cur.execute(
                 """
                 SELECT table_name
                 FROM information_schema.tables
-                WHERE table_schema = 'public'
+                WHERE table_schema = current_schema()
                   AND table_name = ANY(%s)
                 """,
                 (REQUIRED_TABLES,),